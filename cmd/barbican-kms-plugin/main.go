@@ -17,8 +17,10 @@ limitations under the License.
 package main
 
 import (
+	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/sys/unix"
@@ -29,8 +31,12 @@ import (
 )
 
 var (
-	socketPath  string
-	cloudConfig string
+	socketPath         string
+	socketMode         string
+	socketUID          int
+	socketGID          int
+	socketSELinuxLabel string
+	cloudConfig        string
 )
 
 func main() {
@@ -40,8 +46,18 @@ func main() {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			sigChan := make(chan os.Signal, 1)
 			signal.Notify(sigChan, unix.SIGTERM, unix.SIGINT)
-			err := server.Run(cloudConfig, socketPath, sigChan)
-			return err
+			mode, err := strconv.ParseUint(socketMode, 8, 32)
+			if err != nil {
+				return fmt.Errorf("invalid socket mode %q: %v", socketMode, err)
+			}
+			socket := server.SocketOpts{
+				Path:         socketPath,
+				Mode:         os.FileMode(mode),
+				UID:          socketUID,
+				GID:          socketGID,
+				SELinuxLabel: socketSELinuxLabel,
+			}
+			return server.Run(cloudConfig, socket, sigChan)
 		},
 		Version: version.Version,
 	}
@@ -51,6 +67,11 @@ func main() {
 		klog.Fatalf("Unable to mark flag socketpath as required: %v", err)
 	}
 
+	cmd.PersistentFlags().StringVar(&socketMode, "socket-mode", "0600", "Permissions of the unix socket, in octal")
+	cmd.PersistentFlags().IntVar(&socketUID, "socket-uid", -1, "User ID owning the unix socket, -1 keeps the user of the plugin")
+	cmd.PersistentFlags().IntVar(&socketGID, "socket-gid", -1, "Group ID owning the unix socket, -1 keeps the group of the plugin")
+	cmd.PersistentFlags().StringVar(&socketSELinuxLabel, "socket-selinux-label", "", "SELinux label of the unix socket, e.g. system_u:object_r:container_file_t:s0, ignored if SELinux is not enabled")
+
 	cmd.PersistentFlags().StringVar(&cloudConfig, "cloud-config", "", "Barbican KMS Plugin cloud config")
 	if err := cmd.MarkPersistentFlagRequired("cloud-config"); err != nil {
 		klog.Fatalf("Unable to mark flag cloud-config as required: %v", err)
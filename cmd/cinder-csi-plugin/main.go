@@ -18,9 +18,12 @@ package main
 
 import (
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/cloud-provider-openstack/pkg/csi/cinder"
 	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
 	"k8s.io/cloud-provider-openstack/pkg/util/metadata"
@@ -32,12 +35,20 @@ import (
 
 var (
 	endpoint                 string
+	csiAddonsEndpoint        string
 	nodeID                   string
 	cloudConfig              []string
 	cluster                  string
 	httpEndpoint             string
 	provideControllerService bool
 	provideNodeService       bool
+	snapshotHooks            bool
+	snapshotHooksTimeout     time.Duration
+	modifyVolume             bool
+	attachAhead              bool
+	attachAheadTimeout       time.Duration
+	attachAheadNamespace     string
+	volumeTransfers          bool
 )
 
 func main() {
@@ -60,6 +71,8 @@ func main() {
 		klog.Fatalf("Unable to mark flag endpoint to be required: %v", err)
 	}
 
+	cmd.PersistentFlags().StringVar(&csiAddonsEndpoint, "csi-addons-endpoint", "", "Endpoint of the csi-addons services called by the csi-addons sidecar, e.g. for the ReclaimSpaceJobs (example: `unix:///csi/csi-addons.sock`). The default is empty string, which means the services are not served.")
+
 	cmd.PersistentFlags().StringSliceVar(&cloudConfig, "cloud-config", nil, "CSI driver cloud config. This option can be given multiple times")
 	if err := cmd.MarkPersistentFlagRequired("cloud-config"); err != nil {
 		klog.Fatalf("Unable to mark flag cloud-config to be required: %v", err)
@@ -71,6 +84,17 @@ func main() {
 	cmd.PersistentFlags().BoolVar(&provideControllerService, "provide-controller-service", true, "If set to true then the CSI driver does provide the controller service (default: true)")
 	cmd.PersistentFlags().BoolVar(&provideNodeService, "provide-node-service", true, "If set to true then the CSI driver does provide the node service (default: true)")
 
+	cmd.PersistentFlags().BoolVar(&snapshotHooks, "snapshot-hooks", false, "Run the pre-snapshot and post-snapshot hooks set in the annotations of the pods using a volume around the creation of its snapshots. Requires access to the Kubernetes API, including pods/exec. Only used by the controller service.")
+	cmd.PersistentFlags().DurationVar(&snapshotHooksTimeout, "snapshot-hooks-timeout", time.Minute, "Timeout of each snapshot hook")
+
+	cmd.PersistentFlags().BoolVar(&modifyVolume, "modify-volume", false, "Advertise the MODIFY_VOLUME controller capability, so that the volume type, bootable flag and metadata of the volumes can be changed with a VolumeAttributesClass. Requires the VolumeAttributesClass feature gate.")
+
+	cmd.PersistentFlags().BoolVar(&attachAhead, "attach-ahead", false, "Attach the volumes of the pods bound to a node before their VolumeAttachments are created, to speed up pod startup on clouds where attaching volumes is slow. Requires access to the Kubernetes API. Only used by the controller service.")
+	cmd.PersistentFlags().DurationVar(&attachAheadTimeout, "attach-ahead-timeout", 5*time.Minute, "Time after which a volume attached ahead without VolumeAttachment is detached again")
+	cmd.PersistentFlags().StringVar(&attachAheadNamespace, "attach-ahead-lease-namespace", "kube-system", "Namespace of the Lease electing the replica of the controller service attaching the volumes ahead")
+
+	cmd.PersistentFlags().BoolVar(&volumeTransfers, "volume-transfers", false, "Transfer the volumes of the PersistentVolumes annotated with cinder.csi.openstack.org/transfer-secret to the OpenStack project of that secret. Requires access to the Kubernetes API, including secrets. Only used by the controller service.")
+
 	openstack.AddExtraFlags(pflag.CommandLine)
 
 	code := cli.Run(cmd)
@@ -79,7 +103,40 @@ func main() {
 
 func handle() {
 	// Initialize cloud
-	d := cinder.NewDriver(&cinder.DriverOpts{Endpoint: endpoint, ClusterID: cluster})
+	opts := &cinder.DriverOpts{Endpoint: endpoint, CSIAddonsEndpoint: csiAddonsEndpoint, ClusterID: cluster, ModifyVolume: modifyVolume}
+	if (snapshotHooks || attachAhead || volumeTransfers) && provideControllerService {
+		cfg, err := rest.InClusterConfig()
+		if err != nil {
+			klog.Fatalf("Failed to get the Kubernetes client config: %v", err)
+		}
+		kubeClient, err := kubernetes.NewForConfig(cfg)
+		if err != nil {
+			klog.Fatalf("Failed to create Kubernetes client: %v", err)
+		}
+		if snapshotHooks {
+			opts.SnapshotHooks = cinder.SnapshotHooksOpts{
+				Enabled:    true,
+				Timeout:    snapshotHooksTimeout,
+				KubeClient: kubeClient,
+				RESTConfig: cfg,
+			}
+		}
+		if attachAhead {
+			opts.AttachAhead = cinder.AttachAheadOpts{
+				Enabled:        true,
+				Timeout:        attachAheadTimeout,
+				KubeClient:     kubeClient,
+				LeaseNamespace: attachAheadNamespace,
+			}
+		}
+		if volumeTransfers {
+			opts.VolumeTransfer = cinder.VolumeTransferOpts{
+				Enabled:    true,
+				KubeClient: kubeClient,
+			}
+		}
+	}
+	d := cinder.NewDriver(opts)
 
 	openstack.InitOpenStackProvider(cloudConfig, httpEndpoint)
 	cloud, err := openstack.GetOpenStackProvider()
@@ -89,7 +146,9 @@ func handle() {
 	}
 
 	if provideControllerService {
-		d.SetupControllerService(cloud)
+		if err := d.SetupControllerService(cloud); err != nil {
+			klog.Fatalf("Driver controller service initialization failed: %v", err)
+		}
 	}
 
 	if provideNodeService {
@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -29,6 +30,7 @@ import (
 
 	"golang.org/x/term"
 
+	"k8s.io/cloud-provider-openstack/pkg/identity/keyring"
 	"k8s.io/cloud-provider-openstack/pkg/identity/keystone"
 	"k8s.io/cloud-provider-openstack/pkg/version"
 )
@@ -148,8 +150,86 @@ var (
 	applicationCredentialID     string
 	applicationCredentialName   string
 	applicationCredentialSecret string
+	useKeyring                  bool
 )
 
+func keyringAccount() (string, error) {
+	return keyring.Account(url, domain, user, applicationCredentialID, applicationCredentialName)
+}
+
+// loadKeyringSecret reads the password, or the application credential secret, from the keyring of the operating
+// system if it's not set yet.
+func loadKeyringSecret() error {
+	if password != "" || applicationCredentialSecret != "" {
+		return nil
+	}
+
+	account, err := keyringAccount()
+	if err != nil {
+		return err
+	}
+
+	secret, err := keyring.New().Get(keyring.Service, account)
+	if err != nil {
+		if errors.Is(err, keyring.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if applicationCredentialID != "" || applicationCredentialName != "" {
+		applicationCredentialSecret = secret
+	} else {
+		password = secret
+	}
+	return nil
+}
+
+func newKeyringCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "keyring",
+		Short: "Manage the secrets stored in the keyring of the operating system",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "store",
+		Short: "Store the password, or the application credential secret, in the keyring",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			account, err := keyringAccount()
+			if err != nil {
+				return err
+			}
+
+			secret := password
+			if applicationCredentialID != "" || applicationCredentialName != "" {
+				secret = applicationCredentialSecret
+			}
+			if secret == "" {
+				if secret, err = promptForString("secret", nil, false); err != nil {
+					return err
+				}
+			}
+
+			return keyring.New().Set(keyring.Service, account, secret)
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "delete",
+		Short: "Delete the password, or the application credential secret, from the keyring",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			account, err := keyringAccount()
+			if err != nil {
+				return err
+			}
+
+			return keyring.New().Delete(keyring.Service, account)
+		},
+	})
+
+	return cmd
+}
+
 func main() {
 	cmd := &cobra.Command{
 		Use:   "client-keystone-auth",
@@ -171,12 +251,22 @@ func main() {
 	cmd.PersistentFlags().StringVar(&applicationCredentialID, "application-credential-id", os.Getenv("OS_APPLICATION_CREDENTIAL_ID"), "Application Credential ID")
 	cmd.PersistentFlags().StringVar(&applicationCredentialName, "application-credential-name", os.Getenv("OS_APPLICATION_CREDENTIAL_NAME"), "Application Credential Name")
 	cmd.PersistentFlags().StringVar(&applicationCredentialSecret, "application-credential-secret", os.Getenv("OS_APPLICATION_CREDENTIAL_SECRET"), "Application Credential Secret")
+	cmd.Flags().BoolVar(&useKeyring, "keyring", false, "Read the password, or the Application Credential Secret, from the keyring of the operating system if not set")
+
+	cmd.AddCommand(newKeyringCommand())
 
 	code := cli.Run(cmd)
 	os.Exit(code)
 }
 
 func handle() {
+	if useKeyring {
+		if err := loadKeyringSecret(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read the secret from the keyring: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// Generate Gophercloud Auth Options based on input data from stdin
 	// if IsTerminal returns "true", or from env variables otherwise.
 	if !term.IsTerminal(int(os.Stdin.Fd())) {
@@ -199,6 +289,13 @@ func handle() {
 				fmt.Fprintf(os.Stderr, "Failed to read openstack env vars: %s\n", err)
 				os.Exit(1)
 			}
+			// The secret may come from the keyring
+			if authOpts.Password == "" {
+				authOpts.Password = password
+			}
+			if authOpts.ApplicationCredentialSecret == "" {
+				authOpts.ApplicationCredentialSecret = applicationCredentialSecret
+			}
 			options.AuthOptions = *authOpts
 		}
 	} else {
@@ -0,0 +1,77 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	"sigs.k8s.io/yaml"
+)
+
+// newAdoptCommand returns the adopt command, which prepares an existing share to be consumed through the driver and
+// prints the manifest of its PersistentVolume.
+func newAdoptCommand() *cobra.Command {
+	var o manila.AdoptOpts
+
+	cmd := &cobra.Command{
+		Use:   "adopt",
+		Short: "Adopt an existing share",
+		Long: "Validate the protocol and the export locations of an existing Manila share, grant the access rule the driver would create, " +
+			"attribute the share to the cluster in its metadata, and print the manifest of a PersistentVolume consuming the share. " +
+			"The PersistentVolume retains the share once released.",
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			o.DriverName = driverName
+			o.ClusterID = clusterID
+			o.ManilaClientBuilder = &manilaclient.ClientBuilder{UserAgent: "manila-csi-plugin", ExtraUserAgentData: userAgentData}
+
+			pv, err := manila.Adopt(&o)
+			if err != nil {
+				return err
+			}
+
+			out, err := yaml.Marshal(pv)
+			if err != nil {
+				return fmt.Errorf("failed to marshal PersistentVolume: %v", err)
+			}
+
+			_, err = cmd.OutOrStdout().Write(out)
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&o.ShareID, "share-id", "", "ID of the share to adopt")
+	cmd.Flags().StringVar(&o.Protocol, "share-protocol", "", "share protocol of the driver, as set by --share-protocol-selector")
+	cmd.Flags().StringVar(&o.SecretDir, "secret-dir", "", "directory containing the OpenStack credentials, one file per key, in the same format as the CSI secrets")
+	for _, name := range []string{"share-id", "share-protocol", "secret-dir"} {
+		if err := cmd.MarkFlagRequired(name); err != nil {
+			panic(err)
+		}
+	}
+	cmd.Flags().StringVar(&o.PVName, "pv-name", "", "name of the PersistentVolume. Defaults to the name of the share made a valid DNS-1123 subdomain, or its ID")
+	cmd.Flags().StringToStringVar(&o.Parameters, "parameters", nil, "volume parameters of the access rule and of the node service, as in a StorageClass, e.g. cephfs-clientID=app,cephfs-mounter=kernel")
+	cmd.Flags().BoolVar(&o.ReadOnly, "read-only", false, "grant read-only access to the share, the PersistentVolume is ReadOnlyMany")
+	cmd.Flags().StringVar(&o.SecretName, "secret-name", "", "name of the Secret holding the CSI secrets, referenced as the node stage and publish secret of the PersistentVolume")
+	cmd.Flags().StringVar(&o.SecretNamespace, "secret-namespace", "default", "namespace of the Secret holding the CSI secrets")
+
+	return cmd
+}
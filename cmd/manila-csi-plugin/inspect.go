@@ -0,0 +1,102 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+)
+
+// newInspectCommand returns the inspect command, which lists the shares attributed to the cluster, their snapshots
+// and access rules, and flags the ones no longer referenced by Kubernetes.
+func newInspectCommand() *cobra.Command {
+	var (
+		secretDir     string
+		kubeconfig    string
+		orphansOnly   bool
+		failOnOrphans bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "inspect",
+		Short: "Inspect the shares managed by the driver",
+		Long: "List the Manila shares attributed to the cluster by their metadata, with their snapshots and access rules, " +
+			"cross-referenced with the PersistentVolumes and VolumeSnapshotContents of the driver. " +
+			"Shares, snapshots and access rules no longer referenced by Kubernetes, and PersistentVolumes whose share is missing, are flagged as orphans.",
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+			if err != nil {
+				return fmt.Errorf("failed to build Kubernetes client config: %v", err)
+			}
+
+			kubeClient, err := kubernetes.NewForConfig(cfg)
+			if err != nil {
+				return err
+			}
+
+			dynamicClient, err := dynamic.NewForConfig(cfg)
+			if err != nil {
+				return err
+			}
+
+			r, err := manila.Inspect(context.Background(), &manila.InspectOpts{
+				DriverName:          driverName,
+				ClusterID:           clusterID,
+				SecretDir:           secretDir,
+				ManilaClientBuilder: &manilaclient.ClientBuilder{UserAgent: "manila-csi-plugin", ExtraUserAgentData: userAgentData},
+				KubeClient:          kubeClient,
+				DynamicClient:       dynamicClient,
+			})
+			if err != nil {
+				return err
+			}
+
+			if err := r.Write(cmd.OutOrStdout(), orphansOnly); err != nil {
+				return err
+			}
+
+			n := r.Orphans()
+			fmt.Fprintf(cmd.OutOrStdout(), "\n%d orphan(s) found\n", n)
+
+			if failOnOrphans && n > 0 {
+				return fmt.Errorf("%d orphan(s) found", n)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&secretDir, "secret-dir", "", "directory containing the OpenStack credentials, one file per key, in the same format as the CSI secrets")
+	if err := cmd.MarkFlagRequired("secret-dir"); err != nil {
+		panic(err)
+	}
+	cmd.Flags().StringVar(&kubeconfig, "kubeconfig", "", "path to the kubeconfig file. The in-cluster configuration is used if empty")
+	cmd.Flags().BoolVar(&orphansOnly, "orphans-only", false, "only list the orphans")
+	cmd.Flags().BoolVar(&failOnOrphans, "fail-on-orphans", false, "exit with a non-zero code when orphans are found, e.g. to alert from a CronJob")
+
+	return cmd
+}
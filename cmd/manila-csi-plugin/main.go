@@ -20,12 +20,17 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/csiclient"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/runtimeconfig"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/shareadapters"
+	manilautil "k8s.io/cloud-provider-openstack/pkg/csi/manila/util"
 	"k8s.io/cloud-provider-openstack/pkg/version"
 	"k8s.io/component-base/cli"
 	"k8s.io/klog/v2"
@@ -37,7 +42,77 @@ var (
 	withTopology          bool
 	protoSelector         string
 	fwdEndpoint           string
+	fallbackFwdEndpoints  map[string]string
 	compatibilitySettings string
+	shareNameTemplate     string
+
+	// Metrics
+	httpEndpoint string
+
+	// Share metrics exporter
+	shareMetricsEndpoint            string
+	shareMetricsSecretDir           string
+	shareMetricsInterval            time.Duration
+	shareMetricsUsedSizeMetadataKey string
+
+	// Capacity
+	capacitySecretDir string
+	capacityCacheTTL  time.Duration
+
+	// Asynchronous access rights
+	asyncAccessRights        bool
+	asyncAccessRightsTimeout time.Duration
+
+	// cephx key rotation
+	keyRotationSecretDir   string
+	keyRotationPeriod      time.Duration
+	keyRotationGracePeriod time.Duration
+	keyRotationInterval    time.Duration
+
+	// Share metadata sync
+	shareMetadataSyncSecretDir string
+	shareMetadataSyncInterval  time.Duration
+
+	// Garbage collection of the orphaned shares
+	gcSecretDir            string
+	gcInterval             time.Duration
+	gcDeleteOrphanedShares bool
+
+	// Automatic volume expansion
+	autoExpansionInterval time.Duration
+
+	// NFS node access rules
+	nfsNodeAccess bool
+
+	// Scheduler hints
+	schedulerHintAnnotations bool
+
+	// Mount option overrides
+	pvcMountOptionOverrides []string
+
+	// Manila circuit breaker
+	manilaCircuitBreakerThreshold int
+	manilaCircuitBreakerCooldown  time.Duration
+
+	// Manila clients reused for the same credentials
+	manilaClientCacheTTL time.Duration
+
+	// Waits for shares and access keys
+	shareWaitTimeout     time.Duration
+	accessKeyWaitTimeout time.Duration
+
+	// Kerberos
+	nfsKrb5KeytabFile string
+
+	// Export locations
+	exportLocationPolicy string
+
+	// Share replicas
+	modifyVolume            bool
+	replicaStateAnnotations bool
+
+	// Volume group snapshots
+	volumeGroupSnapshots bool
 
 	// Node information
 	nodeID    string
@@ -53,16 +128,20 @@ var (
 )
 
 func validateShareProtocolSelector(v string) error {
-	supportedShareProtocols := []string{"NFS", "CEPHFS"}
+	if _, ok := shareadapters.GetShareAdapter(v); ok {
+		return nil
+	}
+
+	return fmt.Errorf("share protocol %q not supported; supported protocols are %v", strings.ToUpper(v), shareadapters.RegisteredProtocols())
+}
 
-	v = strings.ToUpper(v)
-	for _, proto := range supportedShareProtocols {
-		if v == proto {
-			return nil
-		}
+func newKubeClient() (kubernetes.Interface, error) {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
 	}
 
-	return fmt.Errorf("share protocol %q not supported; supported protocols are %v", v, supportedShareProtocols)
+	return kubernetes.NewForConfig(cfg)
 }
 
 func main() {
@@ -74,18 +153,117 @@ func main() {
 				klog.Fatalf(err.Error())
 			}
 
-			manilaClientBuilder := &manilaclient.ClientBuilder{UserAgent: "manila-csi-plugin", ExtraUserAgentData: userAgentData}
+			manilaClientBuilder := &manilaclient.ClientBuilder{UserAgent: "manila-csi-plugin", ExtraUserAgentData: userAgentData, ClientTTL: manilaClientCacheTTL}
+			if manilaCircuitBreakerThreshold > 0 {
+				if manilaCircuitBreakerCooldown <= 0 {
+					klog.Fatalf("manila-circuit-breaker-cooldown must be positive")
+				}
+				manilaClientBuilder.CircuitBreaker = manilaclient.NewCircuitBreaker(manilaCircuitBreakerThreshold, manilaCircuitBreakerCooldown)
+			}
 			csiClientBuilder := &csiclient.ClientBuilder{}
 
 			opts := &manila.DriverOpts{
-				DriverName:          driverName,
-				WithTopology:        withTopology,
-				ShareProto:          protoSelector,
-				ServerCSIEndpoint:   endpoint,
-				FwdCSIEndpoint:      fwdEndpoint,
-				ManilaClientBuilder: manilaClientBuilder,
-				CSIClientBuilder:    csiClientBuilder,
-				ClusterID:           clusterID,
+				DriverName:              driverName,
+				WithTopology:            withTopology,
+				ShareProto:              protoSelector,
+				ServerCSIEndpoint:       endpoint,
+				FwdCSIEndpoint:          fwdEndpoint,
+				FallbackFwdCSIEndpoints: fallbackFwdEndpoints,
+				ManilaClientBuilder:     manilaClientBuilder,
+				CSIClientBuilder:        csiClientBuilder,
+				ClusterID:               clusterID,
+				ShareNameTemplate:       shareNameTemplate,
+				HTTPEndpoint:            httpEndpoint,
+				ShareMetrics: manila.ShareMetricsOpts{
+					Endpoint:            shareMetricsEndpoint,
+					SecretDir:           shareMetricsSecretDir,
+					Interval:            shareMetricsInterval,
+					UsedSizeMetadataKey: shareMetricsUsedSizeMetadataKey,
+				},
+				Capacity: manila.CapacityOpts{
+					SecretDir: capacitySecretDir,
+					CacheTTL:  capacityCacheTTL,
+				},
+				NFSKrb5KeytabFile:    nfsKrb5KeytabFile,
+				ExportLocationPolicy: exportLocationPolicy,
+				ModifyVolume:         modifyVolume,
+				VolumeGroupSnapshots: volumeGroupSnapshots,
+				ShareWaitTimeout:     shareWaitTimeout,
+				AccessKeyWaitTimeout: accessKeyWaitTimeout,
+			}
+
+			if (asyncAccessRights || replicaStateAnnotations || keyRotationSecretDir != "" || shareMetadataSyncSecretDir != "" || gcSecretDir != "" || autoExpansionInterval > 0 || nfsNodeAccess || schedulerHintAnnotations || len(pvcMountOptionOverrides) > 0) && provideControllerService {
+				kubeClient, err := newKubeClient()
+				if err != nil {
+					klog.Fatalf("Failed to create Kubernetes client: %v", err)
+				}
+
+				if asyncAccessRights {
+					opts.AsyncAccessRights = manila.AsyncAccessRightsOpts{
+						Enabled:    true,
+						Timeout:    asyncAccessRightsTimeout,
+						KubeClient: kubeClient,
+					}
+				}
+
+				if replicaStateAnnotations {
+					opts.ReplicaStateKubeClient = kubeClient
+				}
+
+				if keyRotationSecretDir != "" {
+					opts.KeyRotation = manila.KeyRotationOpts{
+						SecretDir:   keyRotationSecretDir,
+						Period:      keyRotationPeriod,
+						GracePeriod: keyRotationGracePeriod,
+						Interval:    keyRotationInterval,
+						KubeClient:  kubeClient,
+					}
+				}
+
+				if shareMetadataSyncSecretDir != "" {
+					opts.ShareMetadataSync = manila.ShareMetadataSyncOpts{
+						SecretDir:  shareMetadataSyncSecretDir,
+						Interval:   shareMetadataSyncInterval,
+						KubeClient: kubeClient,
+					}
+				}
+
+				if gcSecretDir != "" {
+					opts.GC = manila.GCOpts{
+						SecretDir:            gcSecretDir,
+						Interval:             gcInterval,
+						DeleteOrphanedShares: gcDeleteOrphanedShares,
+						KubeClient:           kubeClient,
+					}
+				}
+
+				if autoExpansionInterval > 0 {
+					opts.AutoExpansion = manila.AutoExpansionOpts{
+						Interval:   autoExpansionInterval,
+						KubeClient: kubeClient,
+					}
+				}
+
+				if schedulerHintAnnotations {
+					opts.SchedulerHintAnnotations = manila.SchedulerHintAnnotationsOpts{
+						Enabled:    true,
+						KubeClient: kubeClient,
+					}
+				}
+
+				if len(pvcMountOptionOverrides) > 0 {
+					opts.MountOptionOverrides = manila.MountOptionOverridesOpts{
+						Allowed:    pvcMountOptionOverrides,
+						KubeClient: kubeClient,
+					}
+				}
+
+				if nfsNodeAccess {
+					opts.NFSNodeAccess = manila.NFSNodeAccessOpts{
+						Enabled:    true,
+						KubeClient: kubeClient,
+					}
+				}
 			}
 
 			if provideNodeService {
@@ -131,13 +309,16 @@ func main() {
 
 	cmd.PersistentFlags().BoolVar(&withTopology, "with-topology", false, "cluster is topology-aware")
 
-	cmd.PersistentFlags().StringVar(&protoSelector, "share-protocol-selector", "", "specifies which Manila share protocol to use. Valid values are NFS and CEPHFS")
-	if err := cmd.MarkPersistentFlagRequired("share-protocol-selector"); err != nil {
+	// The inspect command doesn't need the flags of the driver which are required
+	cmd.Flags().StringVar(&protoSelector, "share-protocol-selector", "", fmt.Sprintf("specifies which Manila share protocol to use. Valid values are %s", strings.Join(shareadapters.RegisteredProtocols(), ", ")))
+	if err := cmd.MarkFlagRequired("share-protocol-selector"); err != nil {
 		klog.Fatalf("Unable to mark flag share-protocol-selector to be required: %v", err)
 	}
 
-	cmd.PersistentFlags().StringVar(&fwdEndpoint, "fwdendpoint", "", "CSI Node Plugin endpoint to which all Node Service RPCs are forwarded. Must be able to handle the file-system specified in share-protocol-selector")
-	if err := cmd.MarkPersistentFlagRequired("fwdendpoint"); err != nil {
+	cmd.Flags().StringVar(&fwdEndpoint, "fwdendpoint", "", "CSI Node Plugin endpoint to which all Node Service RPCs are forwarded. Must be able to handle the file-system specified in share-protocol-selector")
+	cmd.Flags().StringToStringVar(&fallbackFwdEndpoints, "fallback-fwdendpoint", nil, "CSI Node Plugin endpoints of the additional share protocols allowed in the protocolFallback volume parameter, e.g. NFS=unix:///var/lib/kubelet/plugins/csi-nfsplugin/csi.sock. The Node Service RPCs of the shares created with these protocols are forwarded to their endpoint. Must be set for both the controller and the node services")
+
+	if err := cmd.MarkFlagRequired("fwdendpoint"); err != nil {
 		klog.Fatalf("Unable to mark flag fwdendpoint to be required: %v", err)
 	}
 
@@ -147,9 +328,59 @@ func main() {
 
 	cmd.PersistentFlags().StringVar(&clusterID, "cluster-id", "", "The identifier of the cluster that the plugin is running in.")
 
+	cmd.PersistentFlags().StringVar(&shareNameTemplate, "share-name-template", "", "Go template used to name newly created shares, e.g. \"{{ .ClusterID }}-{{ .PVCNamespace }}-{{ .PVCName }}\". Defaults to the PersistentVolume name.")
+
+	cmd.PersistentFlags().StringVar(&httpEndpoint, "http-endpoint", "", "The TCP network address where the HTTP server for providing metrics for diagnostics, e.g. of the Manila API requests, will listen (example: `:8080`). Used by both the controller and the node services. The default is empty string, which means the server is disabled.")
+
+	cmd.PersistentFlags().StringVar(&shareMetricsEndpoint, "share-metrics-endpoint", "", "The TCP network address where the HTTP server exposing per-PV share capacity metrics will listen (example: `:8080`). Only used by the controller service. The default is empty string, which means the exporter is disabled.")
+	cmd.PersistentFlags().StringVar(&shareMetricsSecretDir, "share-metrics-secret-dir", "", "directory containing the OpenStack credentials used by the share metrics exporter, one file per key as in the CSI secrets")
+	cmd.PersistentFlags().DurationVar(&shareMetricsInterval, "share-metrics-interval", 5*time.Minute, "interval between two queries of Manila by the share metrics exporter")
+	cmd.PersistentFlags().StringVar(&shareMetricsUsedSizeMetadataKey, "share-metrics-used-size-metadata-key", "", "share metadata key holding the used size of the share in bytes, if published by the share backend")
+
+	cmd.PersistentFlags().StringVar(&capacitySecretDir, "capacity-secret-dir", "", "directory containing the OpenStack credentials used to query the capacity of the Manila scheduler pools, one file per key as in the CSI secrets. Enables the GET_CAPACITY controller capability. The default is empty string, which means GetCapacity is not supported.")
+	cmd.PersistentFlags().DurationVar(&capacityCacheTTL, "capacity-cache-ttl", time.Minute, "time the capacity of a share type and availability zone is cached for")
+
+	cmd.PersistentFlags().BoolVar(&asyncAccessRights, "async-access-rights", false, "return CephFS volumes without waiting for the cephx key of their access right, which is awaited in the background and reported in the access-status annotation of the PersistentVolume. Staging the volume fails until the key is assigned. Requires access to the Kubernetes API. Only used by the controller service.")
+	cmd.PersistentFlags().DurationVar(&asyncAccessRightsTimeout, "async-access-rights-timeout", 30*time.Minute, "time after which an access right awaited in the background without cephx key is reported as failed")
+
+	cmd.PersistentFlags().StringVar(&keyRotationSecretDir, "key-rotation-secret-dir", "", "directory containing the OpenStack credentials used to rotate the cephx keys of the CephFS volumes, one file per key as in the CSI secrets. Requires access to the Kubernetes API. Only used by the controller service. The default is empty string, which means the keys are never rotated.")
+	cmd.PersistentFlags().DurationVar(&keyRotationPeriod, "key-rotation-period", 0, "maximum age of a cephx key, after which it's rotated. The default is 0, which means the keys are only rotated when requested with the rotate-access-key annotation of the PersistentVolume.")
+	cmd.PersistentFlags().DurationVar(&keyRotationGracePeriod, "key-rotation-grace-period", 24*time.Hour, "time the previous cephx key of a volume stays valid after a rotation, during which the volumes staged with it are expected to be restaged")
+	cmd.PersistentFlags().DurationVar(&keyRotationInterval, "key-rotation-interval", 10*time.Minute, "interval between two checks of the cephx keys due for rotation")
+
+	cmd.PersistentFlags().StringVar(&shareMetadataSyncSecretDir, "share-metadata-sync-secret-dir", "", "directory containing the OpenStack credentials used to keep the share metadata naming the PersistentVolume and PersistentVolumeClaim of the shares, and the PersistentVolumeClaim labels selected with the shareMetadataLabels volume parameter, up to date. One file per key as in the CSI secrets. Requires access to the Kubernetes API. Only used by the controller service. The default is empty string, which means the share metadata is only set when the share is created.")
+	cmd.PersistentFlags().DurationVar(&shareMetadataSyncInterval, "share-metadata-sync-interval", 10*time.Minute, "interval between two syncs of the share metadata")
+	cmd.PersistentFlags().StringVar(&gcSecretDir, "gc-secret-dir", "", "directory containing the OpenStack credentials used to find the shares of the cluster whose PersistentVolume doesn't exist anymore, and revoke the access rights of the ones which failed to be deleted. One file per key as in the CSI secrets. Requires --cluster-id and access to the Kubernetes API. Only used by the controller service. The default is empty string, which means the garbage collection is disabled.")
+	cmd.PersistentFlags().DurationVar(&gcInterval, "gc-interval", time.Hour, "interval between two garbage collections of the orphaned shares")
+	cmd.PersistentFlags().BoolVar(&gcDeleteOrphanedShares, "gc-delete-orphaned-shares", false, "delete the orphaned shares which failed to be deleted, instead of only revoking their access rights")
+
+	cmd.PersistentFlags().DurationVar(&autoExpansionInterval, "auto-expansion-interval", 0, "interval between two checks of the usage of the volumes whose StorageClass sets the autoExpansionThreshold parameter, expanded once their usage crosses the threshold. The usage is read from the kubelet stats summary. Requires access to the Kubernetes API. Only used by the controller service. The default is 0, which means the volumes are never expanded automatically.")
+
+	cmd.PersistentFlags().BoolVar(&nfsNodeAccess, "nfs-node-access", false, "enable the nfs-nodeAccess volume parameter, granting NFS access to the addresses of the nodes the volumes are attached to in ControllerPublishVolume and revoking it in ControllerUnpublishVolume. Requires attachRequired in the CSIDriver object, the external-attacher sidecar and access to the Kubernetes API. Only used by the controller service.")
+	cmd.PersistentFlags().StringSliceVar(&pvcMountOptionOverrides, "pvc-mount-option-overrides", nil, "comma-separated names of the CephFS mount options, e.g. rasize,recover_session, which the annotations of the PersistentVolumeClaims may override in the volume context of new volumes. Requires csi-provisioner running with --extra-create-metadata and access to the Kubernetes API. Only used by the controller service.")
+	cmd.PersistentFlags().BoolVar(&schedulerHintAnnotations, "scheduler-hint-annotations", false, "read the Manila scheduler hints and availability zone of new volumes from the annotations of their PersistentVolumeClaims. Requires csi-provisioner running with --extra-create-metadata and access to the Kubernetes API. Only used by the controller service.")
+
+	cmd.PersistentFlags().IntVar(&manilaCircuitBreakerThreshold, "manila-circuit-breaker-threshold", 0, "number of consecutive Manila server errors (5xx responses or connection failures) after which the requests to Manila fail fast with the Unavailable code for the cooldown, instead of adding load to a struggling Manila. A single request probes Manila once the cooldown is over. The default is 0, which means the circuit breaker is disabled.")
+	cmd.PersistentFlags().DurationVar(&manilaCircuitBreakerCooldown, "manila-circuit-breaker-cooldown", 30*time.Second, "time the requests to Manila fail fast once the circuit breaker is open. Doubled after each failed probe, up to 5 minutes")
+	cmd.PersistentFlags().DurationVar(&manilaClientCacheTTL, "manila-client-cache-ttl", 0, "time a Manila client is reused by the CSI calls with the same OpenStack credentials, cloud and region, instead of authenticating again. The default is 0, which means a client is created for each CSI call.")
+
+	cmd.PersistentFlags().DurationVar(&shareWaitTimeout, "share-wait-timeout", time.Minute, "time the controller waits for a new, extended or deleted share or a deleted snapshot to reach the desired status. Can be overridden with the shareWaitTimeout StorageClass parameter")
+	cmd.PersistentFlags().DurationVar(&accessKeyWaitTimeout, "access-key-wait-timeout", 90*time.Second, "time the controller waits for the cephx key of a new CephFS access right. Can be overridden with the cephfs-accessKeyTimeout StorageClass parameter")
+
+	cmd.PersistentFlags().StringVar(&nfsKrb5KeytabFile, "nfs-krb5-keytab-file", "", "path of the keytab into which the entries of the Kerberos keytab found in the node stage secret are merged when staging NFS shares with nfs-security set. The rpc.gssd daemon of the node is expected to use this keytab. The default is empty string, which means the keytab must be provisioned on the node beforehand.")
+
+	cmd.PersistentFlags().StringVar(&exportLocationPolicy, "export-location-policy", manilautil.DefaultExportLocationPolicy, "comma-separated rules ranking the export locations the shares are mounted with: \"preferred\" ranks the locations marked as preferred by Manila first, \"zone\" the locations in the availability zone of the node, \"cidr:<CIDR>\" the locations whose address is in the CIDR. May be overridden by the exportLocationPolicy volume parameter. Only used by the node service.")
+
+	cmd.PersistentFlags().BoolVar(&modifyVolume, "modify-volume", false, "advertise the MODIFY_VOLUME controller capability, so that the active replica of a share can be changed with a VolumeAttributesClass. Requires the VolumeAttributesClass feature gate.")
+	cmd.PersistentFlags().BoolVar(&replicaStateAnnotations, "replica-state-annotations", false, "report the state of the share replicas in the replica-state annotation of the PersistentVolumes. Requires access to the Kubernetes API. Only used by the controller service.")
+
+	cmd.PersistentFlags().BoolVar(&volumeGroupSnapshots, "volume-group-snapshots", false, "provide the group controller service, which snapshots the volumes of a Manila share group together with a share group snapshot. The shares are placed in a share group with the shareGroupID StorageClass parameter. Only used by the controller service.")
+
 	cmd.PersistentFlags().BoolVar(&provideControllerService, "provide-controller-service", true, "If set to true then the CSI driver does provide the controller service (default: true)")
 	cmd.PersistentFlags().BoolVar(&provideNodeService, "provide-node-service", true, "If set to true then the CSI driver does provide the node service (default: true)")
 
+	cmd.AddCommand(newInspectCommand(), newAdoptCommand())
+
 	code := cli.Run(cmd)
 	os.Exit(code)
 }
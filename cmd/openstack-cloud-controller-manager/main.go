@@ -53,6 +53,7 @@ func main() {
 	openstack.AddExtraFlags(fss.FlagSet("OpenStack Client"))
 
 	command := app.NewCloudControllerManagerCommand(ccmOptions, cloudInitializer, app.DefaultInitFuncConstructors, names.CCMControllerAliases(), fss, wait.NeverStop)
+	command.AddCommand(newValidateConfigCommand())
 
 	klog.V(1).Infof("openstack-cloud-controller-manager version: %s", version.Version)
 
@@ -81,5 +82,15 @@ func cloudInitializer(config *config.CompletedConfig) cloudprovider.Interface {
 			klog.Fatalf("no ClusterID found.  A ClusterID is required for the cloud provider to function properly.  This check can be bypassed by setting the allow-untagged-cloud option")
 		}
 	}
+
+	if osCloud, ok := cloud.(*openstack.OpenStack); ok {
+		osCloud.SetClusterName(config.ComponentConfig.KubeCloudShared.ClusterName)
+
+		// Warm the caches up before the leader election
+		if config.ComponentConfig.Generic.LeaderElection.LeaderElect {
+			osCloud.StartWarmStandby(config.SharedInformers, wait.NeverStop)
+		}
+	}
+
 	return cloud
 }
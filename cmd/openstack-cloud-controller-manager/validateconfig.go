@@ -0,0 +1,67 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"k8s.io/cloud-provider-openstack/pkg/openstack"
+)
+
+// newValidateConfigCommand returns the validate-config command, which checks a cloud config without starting
+// the controllers.
+func newValidateConfigCommand() *cobra.Command {
+	var cloudConfig string
+
+	cmd := &cobra.Command{
+		Use:           "validate-config",
+		Short:         "Validate the cloud config",
+		Long:          "Validate the cloud config, reporting unknown sections and options, deprecated options and the options missing for the enabled controllers.",
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := os.Open(cloudConfig)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			warns, errs := openstack.ValidateConfig(f)
+			for _, w := range warns {
+				fmt.Fprintf(cmd.OutOrStdout(), "warning: %s\n", w)
+			}
+			for _, err := range errs {
+				fmt.Fprintf(cmd.OutOrStdout(), "error: %v\n", err)
+			}
+
+			if len(errs) > 0 {
+				return fmt.Errorf("%s is invalid, %d error(s) found", cloudConfig, len(errs))
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "%s is valid\n", cloudConfig)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&cloudConfig, "cloud-config", "/etc/config/cloud.conf", "Path to the cloud provider configuration file.")
+
+	return cmd
+}
@@ -585,6 +585,11 @@ func (provider CloudProvider) UpdateHealthStatus(masters []healthcheck.NodeInfo,
 	}
 	healthStatusReason := strings.Replace(string(jsonDumps), "\"", "'", -1)
 
+	if provider.Config.DryRun {
+		log.Infof("dry run: cluster health status would be updated as %s for reason %s.", healthStatus, healthStatusReason)
+		return nil
+	}
+
 	updateOpts := []clusters.UpdateOptsBuilder{
 		clusters.UpdateOpts{
 			Op:    clusters.ReplaceOp,
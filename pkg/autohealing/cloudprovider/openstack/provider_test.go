@@ -0,0 +1,39 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/cloud-provider-openstack/pkg/autohealing/config"
+	"k8s.io/cloud-provider-openstack/pkg/autohealing/healthcheck"
+)
+
+func TestUpdateHealthStatusDryRun(t *testing.T) {
+	// The Magnum client is not set, the cluster must not be updated
+	provider := CloudProvider{Config: config.Config{DryRun: true, ClusterName: "cluster-1"}}
+	workers := []healthcheck.NodeInfo{
+		{KubeNode: apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}}, IsWorker: true, FailedCheck: "NodeCondition"},
+	}
+
+	assert.NoError(t, provider.UpdateHealthStatus(nil, workers))
+	assert.NoError(t, provider.UpdateHealthStatus(nil, nil))
+}
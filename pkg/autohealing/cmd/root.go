@@ -94,6 +94,10 @@ func Execute() {
 func init() {
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.kube_autohealer_config.yaml)")
+	rootCmd.PersistentFlags().Bool("dry-run", false, "detect the unhealthy nodes and report the repairs without making them, overrides dry-run of the config file")
+	if err := viper.BindPFlag("dry-run", rootCmd.PersistentFlags().Lookup("dry-run")); err != nil {
+		log.Fatalf("Failed to bind the dry-run flag, error: %v", err)
+	}
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -24,7 +24,8 @@ import (
 
 // Config struct contains ingress controller configuration
 type Config struct {
-	// (Optional) Emit an event without repairing nodes. Default: false
+	// (Optional) Detect the unhealthy nodes and report the repairs and the cluster health status that would be made,
+	// without cordoning the nodes or calling the Magnum API to update the cluster. Default: false
 	DryRun bool `mapstructure:"dry-run"`
 
 	// (Required) Cluster identifier
@@ -59,6 +60,23 @@ type Config struct {
 
 	// (Optional) How long to wait after a node being rebooted
 	RebuildDelayAfterReboot time.Duration `mapstructure:"rebuild-delay-after-reboot"`
+
+	// (Optional) Health check thresholds of the nodes in the node groups, keyed by the node group name(case
+	// insensitive) in the magnum.openstack.org/nodegroup node label.
+	NodeGroups map[string]NodeGroup `mapstructure:"nodegroups"`
+}
+
+// NodeGroup contains the health check thresholds of the nodes in a node group.
+type NodeGroup struct {
+	// (Optional) Overrides unhealthy-duration of the health check plugins for the nodes in the node group.
+	UnhealthyDuration time.Duration `mapstructure:"unhealthy-duration"`
+
+	// (Optional) How many consecutive monitor loops a node should fail the health checks before it's repaired. Default: 1
+	FailureThreshold int `mapstructure:"failure-threshold"`
+
+	// (Optional) How many of the configured health checks a node should fail in the same monitor loop to be considered
+	// unhealthy. Default: 1
+	Quorum int `mapstructure:"quorum"`
 }
 
 type healthCheck struct {
@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -69,6 +70,9 @@ const (
 	// LabelNodeRoleControlPlane specifies that a node is control-plane
 	LabelNodeRoleControlPlane = "node-role.kubernetes.io/control-plane"
 
+	// LabelNodeGroup specifies the Magnum node group of a node
+	LabelNodeGroup = "magnum.openstack.org/nodegroup"
+
 	leaderElectionResourceLockNamespace = "kube-system"
 	leaderElectionResourceLockName      = "magnum-auto-healer"
 )
@@ -180,6 +184,8 @@ func NewController(conf config.Config) *Controller {
 		leaderElectionClient: leaderElectionClient,
 		masterCheckers:       masterCheckers,
 		workerCheckers:       workerCheckers,
+		masterFailures:       make(map[string]int),
+		workerFailures:       make(map[string]int),
 	}
 
 	return controller
@@ -194,6 +200,9 @@ type Controller struct {
 	config               config.Config
 	workerCheckers       []healthcheck.HealthCheck
 	masterCheckers       []healthcheck.HealthCheck
+	// masterFailures and workerFailures count the consecutive monitor loops the nodes have failed the health checks.
+	masterFailures map[string]int
+	workerFailures map[string]int
 }
 
 // UpdateNodeAnnotation updates the specified node annotation, if value equals empty string, the annotation will be
@@ -267,14 +276,14 @@ func (c *Controller) getUnhealthyMasterNodes() ([]healthcheck.NodeInfo, error) {
 				continue
 			}
 
-			nodes = append(nodes, healthcheck.NodeInfo{KubeNode: node, IsWorker: false})
+			nodes = append(nodes, c.newNodeInfo(node, false))
 		}
 	}
 
 	// Do health check
 	unhealthyNodes := healthcheck.CheckNodes(c.masterCheckers, nodes, c)
 
-	return unhealthyNodes, nil
+	return c.applyFailureThreshold(c.masterFailures, unhealthyNodes), nil
 }
 
 // getUnhealthyWorkerNodes returns the nodes that need to be repaired.
@@ -305,13 +314,62 @@ func (c *Controller) getUnhealthyWorkerNodes() ([]healthcheck.NodeInfo, error) {
 			log.V(4).Infof("The node %s is created less than the configured check delay, skip", node.Name)
 			continue
 		}
-		nodes = append(nodes, healthcheck.NodeInfo{KubeNode: node, IsWorker: true})
+		nodes = append(nodes, c.newNodeInfo(node, true))
 	}
 
 	// Do health check
 	unhealthyNodes := healthcheck.CheckNodes(c.workerCheckers, nodes, c)
 
-	return unhealthyNodes, nil
+	return c.applyFailureThreshold(c.workerFailures, unhealthyNodes), nil
+}
+
+// getNodeGroup returns the health check thresholds of the node group the node belongs to.
+func (c *Controller) getNodeGroup(node apiv1.Node) config.NodeGroup {
+	name, ok := node.Labels[LabelNodeGroup]
+	if !ok {
+		return config.NodeGroup{}
+	}
+	// The keys are lower-cased when the configuration is loaded.
+	return c.config.NodeGroups[strings.ToLower(name)]
+}
+
+// newNodeInfo returns the NodeInfo of the node with the health check thresholds of its node group.
+func (c *Controller) newNodeInfo(node apiv1.Node, isWorker bool) healthcheck.NodeInfo {
+	ng := c.getNodeGroup(node)
+	return healthcheck.NodeInfo{
+		KubeNode:          node,
+		IsWorker:          isWorker,
+		UnhealthyDuration: ng.UnhealthyDuration,
+		Quorum:            ng.Quorum,
+	}
+}
+
+// applyFailureThreshold updates the consecutive failures of the nodes and returns the unhealthy nodes which have
+// reached the failure threshold of their node group. The nodes missing from unhealthyNodes are considered healthy.
+func (c *Controller) applyFailureThreshold(failures map[string]int, unhealthyNodes []healthcheck.NodeInfo) []healthcheck.NodeInfo {
+	var nodes []healthcheck.NodeInfo
+	unhealthyNodeNames := sets.NewString()
+
+	for _, n := range unhealthyNodes {
+		name := n.KubeNode.Name
+		unhealthyNodeNames.Insert(name)
+		failures[name]++
+
+		threshold := c.getNodeGroup(n.KubeNode).FailureThreshold
+		if failures[name] < threshold {
+			log.Infof("Node %s failed the health checks %d out of %d consecutive times, skip the repair", name, failures[name], threshold)
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+
+	for name := range failures {
+		if !unhealthyNodeNames.Has(name) {
+			delete(failures, name)
+		}
+	}
+
+	return nodes
 }
 
 func (c *Controller) repairNodes(unhealthyNodes []healthcheck.NodeInfo) {
@@ -328,7 +386,9 @@ func (c *Controller) repairNodes(unhealthyNodes []healthcheck.NodeInfo) {
 		} else {
 			log.Infof("Starting to repair nodes %s, dryrun: %t", unhealthyNodeNames.List(), c.config.DryRun)
 
-			if !c.config.DryRun {
+			if c.config.DryRun {
+				c.recordDryRunRepairs(unhealthyNodes)
+			} else {
 				// Cordon the nodes before repair.
 				for _, node := range unhealthyNodes {
 					nodeName := node.KubeNode.Name
@@ -364,11 +424,33 @@ func (c *Controller) repairNodes(unhealthyNodes []healthcheck.NodeInfo) {
 				if err := c.provider.Repair(unhealthyNodes); err != nil {
 					log.Errorf("Failed to repair the nodes %s, error: %v", unhealthyNodeNames.List(), err)
 				}
+
+				// The repaired nodes start counting the failures from scratch.
+				for _, node := range unhealthyNodes {
+					if node.IsWorker {
+						delete(c.workerFailures, node.KubeNode.Name)
+					} else {
+						delete(c.masterFailures, node.KubeNode.Name)
+					}
+				}
 			}
 		}
 	}
 }
 
+// recordDryRunRepairs logs and emits an event for each node that would be repaired if dry run was disabled.
+func (c *Controller) recordDryRunRepairs(unhealthyNodes []healthcheck.NodeInfo) {
+	for _, node := range unhealthyNodes {
+		action := "repaired"
+		if node.IsWorker {
+			action = "cordoned and repaired"
+		}
+		log.Infof("Dry run: node %s failed the %s health check and would be %s by the cloud provider %s", node.KubeNode.Name, node.FailedCheck, action, c.provider.GetName())
+		c.recorder.Eventf(&node.KubeNode, apiv1.EventTypeWarning, "NodeRepairDryRun",
+			"Node failed the %s health check and would be %s, dry run is enabled", node.FailedCheck, action)
+	}
+}
+
 // startMasterMonitor checks if there are failed master nodes and triggers the repair action. This function is supposed
 // to be running in a goroutine.
 func (c *Controller) startMasterMonitor(wg *sync.WaitGroup) {
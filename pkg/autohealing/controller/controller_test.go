@@ -0,0 +1,148 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"k8s.io/cloud-provider-openstack/pkg/autohealing/config"
+	"k8s.io/cloud-provider-openstack/pkg/autohealing/healthcheck"
+)
+
+// fakeProvider records the nodes it's asked to repair.
+type fakeProvider struct {
+	repaired [][]healthcheck.NodeInfo
+}
+
+func (p *fakeProvider) GetName() string {
+	return "fake"
+}
+
+func (p *fakeProvider) UpdateHealthStatus([]healthcheck.NodeInfo, []healthcheck.NodeInfo) error {
+	return nil
+}
+
+func (p *fakeProvider) Repair(nodes []healthcheck.NodeInfo) error {
+	p.repaired = append(p.repaired, nodes)
+	return nil
+}
+
+func (p *fakeProvider) Enabled() bool {
+	return true
+}
+
+func TestRepairNodesDryRun(t *testing.T) {
+	worker := apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}}
+	master := apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "master-1"}}
+	unhealthyNodes := []healthcheck.NodeInfo{
+		{KubeNode: worker, IsWorker: true, FailedCheck: "NodeCondition"},
+		{KubeNode: master, IsWorker: false, FailedCheck: "Endpoint"},
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		kubeClient := fake.NewSimpleClientset(worker.DeepCopy(), master.DeepCopy())
+		provider := &fakeProvider{}
+		recorder := record.NewFakeRecorder(10)
+		c := &Controller{
+			provider:       provider,
+			recorder:       recorder,
+			kubeClient:     kubeClient,
+			config:         config.Config{DryRun: dryRun},
+			masterFailures: map[string]int{"master-1": 1},
+			workerFailures: map[string]int{"worker-1": 1},
+		}
+
+		c.repairNodes(unhealthyNodes)
+
+		node, err := kubeClient.CoreV1().Nodes().Get(context.TODO(), "worker-1", metav1.GetOptions{})
+		assert.NoError(t, err)
+		if !dryRun {
+			// The worker is cordoned and the nodes are repaired
+			assert.True(t, node.Spec.Unschedulable)
+			assert.Equal(t, [][]healthcheck.NodeInfo{unhealthyNodes}, provider.repaired)
+			assert.Empty(t, c.workerFailures)
+			assert.Empty(t, c.masterFailures)
+			assert.Empty(t, recorder.Events)
+			continue
+		}
+
+		// The repairs are only reported
+		assert.False(t, node.Spec.Unschedulable)
+		assert.Empty(t, provider.repaired)
+		assert.Equal(t, map[string]int{"worker-1": 1}, c.workerFailures)
+		assert.Equal(t, map[string]int{"master-1": 1}, c.masterFailures)
+		assert.Len(t, recorder.Events, 2)
+		assert.Equal(t, "Warning NodeRepairDryRun Node failed the NodeCondition health check and would be cordoned and repaired, dry run is enabled", <-recorder.Events)
+		assert.Equal(t, "Warning NodeRepairDryRun Node failed the Endpoint health check and would be repaired, dry run is enabled", <-recorder.Events)
+	}
+}
+
+func TestApplyFailureThreshold(t *testing.T) {
+	newNode := func(name, nodeGroup string) healthcheck.NodeInfo {
+		node := apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if nodeGroup != "" {
+			node.Labels = map[string]string{LabelNodeGroup: nodeGroup}
+		}
+		return healthcheck.NodeInfo{KubeNode: node, IsWorker: true}
+	}
+	names := func(nodes []healthcheck.NodeInfo) []string {
+		var ret []string
+		for _, n := range nodes {
+			ret = append(ret, n.KubeNode.Name)
+		}
+		return ret
+	}
+
+	c := &Controller{
+		config: config.Config{
+			NodeGroups: map[string]config.NodeGroup{
+				"flaky": {FailureThreshold: 3},
+			},
+		},
+	}
+	// node-1 has no node group, node-2 belongs to a node group with a failure threshold
+	node1 := newNode("node-1", "")
+	node2 := newNode("node-2", "Flaky")
+	failures := map[string]int{}
+
+	// The nodes without a failure threshold are repaired at the first failure
+	assert.Equal(t, []string{"node-1"}, names(c.applyFailureThreshold(failures, []healthcheck.NodeInfo{node1, node2})))
+	assert.Equal(t, map[string]int{"node-1": 1, "node-2": 1}, failures)
+
+	assert.Empty(t, c.applyFailureThreshold(failures, []healthcheck.NodeInfo{node2}))
+	assert.Equal(t, map[string]int{"node-2": 2}, failures)
+
+	// A healthy round resets the failures
+	assert.Empty(t, c.applyFailureThreshold(failures, nil))
+	assert.Empty(t, failures)
+
+	assert.Empty(t, c.applyFailureThreshold(failures, []healthcheck.NodeInfo{node2}))
+	assert.Empty(t, c.applyFailureThreshold(failures, []healthcheck.NodeInfo{node2}))
+	assert.Equal(t, map[string]int{"node-2": 2}, failures)
+
+	// The node is repaired once it reaches the failure threshold, and as long as it keeps failing
+	assert.Equal(t, []string{"node-2"}, names(c.applyFailureThreshold(failures, []healthcheck.NodeInfo{node2})))
+	assert.Equal(t, []string{"node-2"}, names(c.applyFailureThreshold(failures, []healthcheck.NodeInfo{node2})))
+	assert.Equal(t, map[string]int{"node-2": 4}, failures)
+}
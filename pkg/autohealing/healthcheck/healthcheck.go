@@ -17,6 +17,7 @@ limitations under the License.
 package healthcheck
 
 import (
+	"strings"
 	"time"
 
 	apiv1 "k8s.io/api/core/v1"
@@ -36,6 +37,20 @@ type NodeInfo struct {
 	FailedCheck string
 	FoundAt     time.Time
 	RebootAt    time.Time
+
+	// UnhealthyDuration overrides the unhealthy duration of the health check plugins if not zero.
+	UnhealthyDuration time.Duration
+	// Quorum is how many health checks the node should fail to be unhealthy. Default: 1
+	Quorum int
+}
+
+// unhealthyDuration returns how long the node should be unhealthy before it's repaired, defaults to the duration
+// configured for the health check plugin.
+func (node NodeInfo) unhealthyDuration(pluginDuration time.Duration) time.Duration {
+	if node.UnhealthyDuration > 0 {
+		return node.UnhealthyDuration
+	}
+	return pluginDuration
 }
 
 type HealthCheck interface {
@@ -82,14 +97,32 @@ func CheckNodes(checkers []HealthCheck, nodes []NodeInfo, controller NodeControl
 
 	// Check the health for each node.
 	for _, node := range nodes {
+		// The node can't fail more checks than the configured ones.
+		quorum := node.Quorum
+		if quorum < 1 {
+			quorum = 1
+		}
+		if quorum > len(checkers) {
+			quorum = len(checkers)
+		}
+
+		var failedChecks []string
 		for _, checker := range checkers {
 			if !checker.Check(node, controller) {
-				node.FailedCheck = checker.GetName()
-				node.FoundAt = time.Now()
-				unhealthyNodes = append(unhealthyNodes, node)
-				break
+				failedChecks = append(failedChecks, checker.GetName())
+				if len(failedChecks) >= quorum {
+					break
+				}
 			}
 		}
+
+		if len(failedChecks) > 0 && len(failedChecks) >= quorum {
+			node.FailedCheck = strings.Join(failedChecks, ",")
+			node.FoundAt = time.Now()
+			unhealthyNodes = append(unhealthyNodes, node)
+		} else if len(failedChecks) > 0 {
+			log.Warningf("Node %s failed the health checks %s, %d failed checks are required to be unhealthy", node.KubeNode.Name, strings.Join(failedChecks, ","), quorum)
+		}
 	}
 
 	return unhealthyNodes
@@ -0,0 +1,97 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthcheck
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeCheck fails the nodes listed in unhealthy.
+type fakeCheck struct {
+	name      string
+	unhealthy []string
+}
+
+func (c *fakeCheck) Check(node NodeInfo, controller NodeController) bool {
+	for _, name := range c.unhealthy {
+		if name == node.KubeNode.Name {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *fakeCheck) IsMasterSupported() bool {
+	return true
+}
+
+func (c *fakeCheck) IsWorkerSupported() bool {
+	return true
+}
+
+func (c *fakeCheck) GetName() string {
+	return c.name
+}
+
+func TestCheckNodes(t *testing.T) {
+	checkers := []HealthCheck{
+		&fakeCheck{name: "A", unhealthy: []string{"node-1", "node-2", "node-3"}},
+		&fakeCheck{name: "B", unhealthy: []string{"node-2", "node-3"}},
+		&fakeCheck{name: "C", unhealthy: []string{"node-3"}},
+	}
+
+	testCases := []struct {
+		name        string
+		node        string
+		quorum      int
+		unhealthy   bool
+		failedCheck string
+	}{
+		{name: "default quorum", node: "node-1", quorum: 0, unhealthy: true, failedCheck: "A"},
+		{name: "healthy", node: "node-0", quorum: 1, unhealthy: false},
+		{name: "below quorum", node: "node-1", quorum: 2, unhealthy: false},
+		{name: "at quorum", node: "node-2", quorum: 2, unhealthy: true, failedCheck: "A,B"},
+		{name: "above quorum", node: "node-3", quorum: 2, unhealthy: true, failedCheck: "A,B"},
+		{name: "quorum clamped to the checks", node: "node-3", quorum: 5, unhealthy: true, failedCheck: "A,B,C"},
+		{name: "quorum clamped below the failed checks", node: "node-2", quorum: 5, unhealthy: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			node := NodeInfo{
+				KubeNode: apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: tc.node}},
+				IsWorker: true,
+				Quorum:   tc.quorum,
+			}
+
+			unhealthyNodes := CheckNodes(checkers, []NodeInfo{node}, nil)
+
+			if !tc.unhealthy {
+				assert.Empty(t, unhealthyNodes)
+				return
+			}
+			assert.Len(t, unhealthyNodes, 1)
+			assert.Equal(t, tc.node, unhealthyNodes[0].KubeNode.Name)
+			assert.Equal(t, tc.failedCheck, unhealthyNodes[0].FailedCheck)
+			assert.False(t, unhealthyNodes[0].FoundAt.IsZero())
+		})
+	}
+}
@@ -112,7 +112,7 @@ func (check *EndpointCheck) checkDuration(node NodeInfo, controller NodeControll
 		return true
 	}
 
-	if now.Sub(*unhealthyStartTime) >= check.UnhealthyDuration {
+	if now.Sub(*unhealthyStartTime) >= node.unhealthyDuration(check.UnhealthyDuration) {
 		// Need repair
 		return false
 	}
@@ -47,6 +47,7 @@ type NodeConditionCheck struct {
 // Check checks the node health, returns false if the node is unhealthy.
 func (check *NodeConditionCheck) Check(node NodeInfo, controller NodeController) bool {
 	nodeName := node.KubeNode.Name
+	duration := node.unhealthyDuration(check.UnhealthyDuration)
 
 	for _, cond := range node.KubeNode.Status.Conditions {
 		if utils.Contains(check.Types, string(cond.Type)) {
@@ -54,14 +55,14 @@ func (check *NodeConditionCheck) Check(node NodeInfo, controller NodeController)
 
 			if len(check.ErrorValues) > 0 {
 				if utils.Contains(check.ErrorValues, string(cond.Status)) {
-					if unhealthyDuration >= check.UnhealthyDuration {
+					if unhealthyDuration >= duration {
 						return false
 					}
 					log.Warningf("Node %s is unhealthy, %s: %s", nodeName, string(cond.Type), string(cond.Status))
 				}
 			} else if len(check.OKValues) > 0 {
 				if !utils.Contains(check.OKValues, string(cond.Status)) {
-					if unhealthyDuration >= check.UnhealthyDuration {
+					if unhealthyDuration >= duration {
 						return false
 					}
 					log.Warningf("Node %s is unhealthy, %s: %s", nodeName, string(cond.Type), string(cond.Status))
@@ -32,6 +32,7 @@ import (
 	"github.com/gophercloud/utils/openstack/clientconfig"
 
 	"k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/client-go/util/cert"
 	"k8s.io/cloud-provider-openstack/pkg/version"
 	"k8s.io/klog/v2"
@@ -202,6 +203,7 @@ func replaceEmpty(a string, b string) string {
 
 // ReadClouds reads Reads clouds.yaml to generate a Config
 // Allows the cloud-config to have priority
+// Fails if the cloud is missing from clouds.yaml, or if the auth URL or the credentials are missing from both.
 func ReadClouds(authOpts *AuthOpts) error {
 	co := new(clientconfig.ClientOpts)
 	if authOpts.Cloud != "" {
@@ -209,7 +211,10 @@ func ReadClouds(authOpts *AuthOpts) error {
 	}
 	cloud, err := clientconfig.GetCloudFromYAML(co)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to read the cloud %q from clouds.yaml: %v", authOpts.Cloud, err)
+	}
+	if cloud.AuthInfo == nil {
+		return fmt.Errorf("the cloud %q has no auth section in clouds.yaml", authOpts.Cloud)
 	}
 
 	authOpts.AuthURL = replaceEmpty(authOpts.AuthURL, cloud.AuthInfo.AuthURL)
@@ -233,9 +238,60 @@ func ReadClouds(authOpts *AuthOpts) error {
 	authOpts.ApplicationCredentialName = replaceEmpty(authOpts.ApplicationCredentialName, cloud.AuthInfo.ApplicationCredentialName)
 	authOpts.ApplicationCredentialSecret = replaceEmpty(authOpts.ApplicationCredentialSecret, cloud.AuthInfo.ApplicationCredentialSecret)
 
+	if authOpts.AuthURL == "" {
+		return fmt.Errorf("the cloud %q has no auth_url in clouds.yaml nor auth-url in the cloud config", authOpts.Cloud)
+	}
+	if authOpts.Password == "" && authOpts.ApplicationCredentialSecret == "" && authOpts.TrusteePassword == "" && authOpts.CertFile == "" {
+		return fmt.Errorf("the cloud %q has no credentials in clouds.yaml nor in the cloud config, one of password, application_credential_secret or cert is required", authOpts.Cloud)
+	}
+
 	return nil
 }
 
+// RequestIDHeader is the header of the request ID set on the OpenStack requests, which the OpenStack services log as
+// the global request ID to correlate the requests between services.
+const RequestIDHeader = "X-OpenStack-Request-ID"
+
+// requestIDRoundTripper sets a new request ID on each request.
+type requestIDRoundTripper struct {
+	rt http.RoundTripper
+}
+
+func (r *requestIDRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get(RequestIDHeader) == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(RequestIDHeader, "req-"+string(uuid.NewUUID()))
+	}
+	return r.rt.RoundTrip(req)
+}
+
+// SetRequestIDs makes the provider set a new request ID on each of its requests, see RequestIDHeader.
+func SetRequestIDs(provider *gophercloud.ProviderClient) {
+	provider.HTTPClient.Transport = &requestIDRoundTripper{rt: provider.HTTPClient.Transport}
+}
+
+// SetComponentUserAgent sets the User-Agent of the requests sent with the service clients, which extends the
+// User-Agent of the provider with the component of the program and the cluster sending them, e.g.
+// "component/loadbalancer cluster/kubernetes openstack-cloud-controller-manager/v1.30.0 gophercloud/v1.6.0".
+// The nil service clients are skipped.
+func SetComponentUserAgent(provider *gophercloud.ProviderClient, component, cluster string, scs ...*gophercloud.ServiceClient) {
+	ua := provider.UserAgent
+	if cluster != "" {
+		ua.Prepend("cluster/" + cluster)
+	}
+	ua.Prepend("component/" + component)
+
+	for _, sc := range scs {
+		if sc == nil {
+			continue
+		}
+		if sc.MoreHeaders == nil {
+			sc.MoreHeaders = make(map[string]string)
+		}
+		sc.MoreHeaders["User-Agent"] = ua.Join()
+	}
+}
+
 // NewOpenStackClient creates a new instance of the openstack client
 func NewOpenStackClient(cfg *AuthOpts, userAgent string, extraUserAgent ...string) (*gophercloud.ProviderClient, error) {
 	provider, err := openstack.NewClient(cfg.AuthURL)
@@ -0,0 +1,457 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+// AttachAheadOpts configures attach-ahead: the volumes of the pods scheduled
+// to a node are attached to it as soon as the pods are bound, before the
+// external-attacher creates their VolumeAttachments and calls
+// ControllerPublishVolume. This overlaps the attachment with the rest of the
+// scheduling and speeds up e.g. the failover of StatefulSet pods on clouds
+// where Nova attaches volumes slowly.
+type AttachAheadOpts struct {
+	Enabled bool
+	// Timeout after which a volume attached ahead without VolumeAttachment
+	// is detached again, e.g. because its pod was deleted meanwhile
+	Timeout time.Duration
+	// KubeClient is used to watch the pending pods and find their volumes
+	KubeClient kubernetes.Interface
+	// LeaseNamespace is the namespace of the Lease electing the replica of
+	// the controller plugin attaching the volumes ahead
+	LeaseNamespace string
+}
+
+const (
+	attachAheadWorkers = 2
+
+	attachAheadRetryBaseDelay = time.Second
+	attachAheadRetryMaxDelay  = time.Minute
+	attachAheadMaxRetries     = 5
+
+	attachAheadLeaseName          = "cinder-csi-attach-ahead"
+	attachAheadLeaseDuration      = 15 * time.Second
+	attachAheadLeaseRenewDeadline = 10 * time.Second
+	attachAheadLeaseRetryPeriod   = 2 * time.Second
+
+	// attachedAheadKey is set to true in the metadata of the volumes attached
+	// ahead until ControllerPublishVolume claims them, and attachedAheadAtKey
+	// to the time they were attached at, so that the volumes left attached
+	// are detached even if the controller plugin restarted meanwhile
+	attachedAheadKey   = "cinder.csi.openstack.org/attached-ahead"
+	attachedAheadAtKey = "cinder.csi.openstack.org/attached-ahead-at"
+)
+
+type attachAhead struct {
+	cloud          openstack.IOpenStack
+	kubeClient     kubernetes.Interface
+	timeout        time.Duration
+	leaseNamespace string
+
+	podFactory    informers.SharedInformerFactory
+	factory       informers.SharedInformerFactory
+	podInformer   cache.SharedIndexInformer
+	pvcLister     corelisters.PersistentVolumeClaimLister
+	pvLister      corelisters.PersistentVolumeLister
+	csiNodeLister storagelisters.CSINodeLister
+	vaLister      storagelisters.VolumeAttachmentLister
+
+	queue workqueue.RateLimitingInterface
+}
+
+func newAttachAhead(cloud openstack.IOpenStack, opts AttachAheadOpts) *attachAhead {
+	// Only the pods already bound to a node but not started yet are of interest
+	podFactory := informers.NewSharedInformerFactoryWithOptions(opts.KubeClient, 0, informers.WithTweakListOptions(func(lo *metav1.ListOptions) {
+		lo.FieldSelector = fields.AndSelectors(
+			fields.OneTermEqualSelector("status.phase", string(v1.PodPending)),
+			fields.OneTermNotEqualSelector("spec.nodeName", ""),
+		).String()
+	}))
+	factory := informers.NewSharedInformerFactory(opts.KubeClient, 0)
+
+	a := &attachAhead{
+		cloud:          cloud,
+		kubeClient:     opts.KubeClient,
+		timeout:        opts.Timeout,
+		leaseNamespace: opts.LeaseNamespace,
+		podFactory:     podFactory,
+		factory:        factory,
+		podInformer:    podFactory.Core().V1().Pods().Informer(),
+		pvcLister:      factory.Core().V1().PersistentVolumeClaims().Lister(),
+		pvLister:       factory.Core().V1().PersistentVolumes().Lister(),
+		csiNodeLister:  factory.Storage().V1().CSINodes().Lister(),
+		vaLister:       factory.Storage().V1().VolumeAttachments().Lister(),
+		queue:          workqueue.NewNamedRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(attachAheadRetryBaseDelay, attachAheadRetryMaxDelay), "cinder-csi-attach-ahead"),
+	}
+
+	_, err := a.podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    a.enqueuePod,
+		UpdateFunc: func(_, obj interface{}) { a.enqueuePod(obj) },
+	})
+	if err != nil {
+		klog.Fatalf("Failed to add the pod event handler of attach-ahead: %v", err)
+	}
+
+	return a
+}
+
+// Run campaigns for the leadership of attach-ahead, so that a single replica
+// of the controller plugin attaches the volumes ahead. The plugin exits when
+// it loses the leadership.
+func (a *attachAhead) Run(stopCh <-chan struct{}) {
+	id, err := os.Hostname()
+	if err != nil {
+		klog.Fatalf("Failed to get the hostname for the leader election of attach-ahead: %v", err)
+	}
+	// Two processes on the same host must not both become the leader
+	id = id + "_" + string(uuid.NewUUID())
+
+	lock, err := resourcelock.New(
+		resourcelock.LeasesResourceLock,
+		a.leaseNamespace,
+		attachAheadLeaseName,
+		a.kubeClient.CoreV1(),
+		a.kubeClient.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: id},
+	)
+	if err != nil {
+		klog.Fatalf("Failed to create the lock of attach-ahead: %v", err)
+	}
+
+	go leaderelection.RunOrDie(wait.ContextForChannel(stopCh), leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: attachAheadLeaseDuration,
+		RenewDeadline: attachAheadLeaseRenewDeadline,
+		RetryPeriod:   attachAheadLeaseRetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				a.run(ctx.Done())
+			},
+			OnStoppedLeading: func() {
+				klog.Fatal("Lost the leadership of attach-ahead")
+			},
+		},
+		Name: attachAheadLeaseName,
+	})
+}
+
+// run starts watching the pods, the workers and the detachment of the unclaimed volumes.
+func (a *attachAhead) run(stopCh <-chan struct{}) {
+	klog.Infof("Attaching the volumes of scheduled pods ahead, unclaimed volumes are detached after %v", a.timeout)
+
+	a.podFactory.Start(stopCh)
+	a.factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, a.podInformer.HasSynced) || !a.cachesSynced(stopCh) {
+		klog.Error("Failed to sync the caches of attach-ahead")
+		return
+	}
+
+	for i := 0; i < attachAheadWorkers; i++ {
+		go wait.Until(a.runWorker, time.Second, stopCh)
+	}
+	go wait.Until(a.sweep, a.timeout/2, stopCh)
+
+	go func() {
+		<-stopCh
+		a.queue.ShutDown()
+	}()
+}
+
+// cachesSynced waits for the caches of the listers to be synced.
+func (a *attachAhead) cachesSynced(stopCh <-chan struct{}) bool {
+	for _, synced := range a.factory.WaitForCacheSync(stopCh) {
+		if !synced {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *attachAhead) enqueuePod(obj interface{}) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok || pod.Spec.NodeName == "" || pod.Status.Phase != v1.PodPending {
+		return
+	}
+
+	key, err := cache.MetaNamespaceKeyFunc(pod)
+	if err != nil {
+		klog.Errorf("Failed to get the key of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return
+	}
+	a.queue.Add(key)
+}
+
+func (a *attachAhead) runWorker() {
+	for a.processNextItem() {
+	}
+}
+
+func (a *attachAhead) processNextItem() bool {
+	item, quit := a.queue.Get()
+	if quit {
+		return false
+	}
+	defer a.queue.Done(item)
+
+	key := item.(string)
+	if err := a.attachPodVolumes(key); err != nil {
+		if a.queue.NumRequeues(item) < attachAheadMaxRetries {
+			klog.V(4).Infof("Failed to attach the volumes of pod %s ahead, will retry: %v", key, err)
+			a.queue.AddRateLimited(item)
+			return true
+		}
+		// The external-attacher will attach the volumes anyway
+		klog.Warningf("Failed to attach the volumes of pod %s ahead: %v", key, err)
+	}
+
+	a.queue.Forget(item)
+	return true
+}
+
+// attachPodVolumes attaches the Cinder volumes of the pod to its node.
+func (a *attachAhead) attachPodVolumes(key string) error {
+	obj, exists, err := a.podInformer.GetStore().GetByKey(key)
+	if err != nil || !exists {
+		return err
+	}
+	pod := obj.(*v1.Pod)
+	if pod.Status.Phase != v1.PodPending {
+		return nil
+	}
+
+	var instanceID string
+	for _, vol := range pod.Spec.Volumes {
+		if vol.PersistentVolumeClaim == nil {
+			continue
+		}
+
+		pv, err := a.cinderVolume(pod.Namespace, vol.PersistentVolumeClaim.ClaimName)
+		if err != nil {
+			return err
+		}
+		if pv == nil {
+			continue
+		}
+
+		// The node ID registered by the node service is the ID of its instance
+		if instanceID == "" {
+			if instanceID, err = a.nodeInstanceID(pod.Spec.NodeName); err != nil || instanceID == "" {
+				return err
+			}
+		}
+
+		if err := a.attach(pv.Spec.CSI.VolumeHandle, instanceID, pod.Spec.NodeName, pv.Spec.CSI.VolumeAttributes[deviceTagKey]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cinderVolume returns the PersistentVolume bound to the claim, or nil if the claim is not bound or not provisioned
+// by this driver.
+func (a *attachAhead) cinderVolume(namespace, claimName string) (*v1.PersistentVolume, error) {
+	pvc, err := a.pvcLister.PersistentVolumeClaims(namespace).Get(claimName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get PersistentVolumeClaim %s/%s: %v", namespace, claimName, err)
+	}
+	if pvc.Status.Phase != v1.ClaimBound || pvc.Spec.VolumeName == "" {
+		return nil, nil
+	}
+
+	pv, err := a.pvLister.Get(pvc.Spec.VolumeName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get PersistentVolume %s: %v", pvc.Spec.VolumeName, err)
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != driverName || pv.Spec.CSI.VolumeHandle == "" {
+		return nil, nil
+	}
+
+	return pv, nil
+}
+
+// nodeInstanceID returns the node ID of the node service running on the node, empty if it isn't registered yet.
+func (a *attachAhead) nodeInstanceID(nodeName string) (string, error) {
+	csiNode, err := a.csiNodeLister.Get(nodeName)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get CSINode %s: %v", nodeName, err)
+	}
+
+	for _, d := range csiNode.Spec.Drivers {
+		if d.Name == driverName {
+			return d.NodeID, nil
+		}
+	}
+
+	return "", nil
+}
+
+// attach attaches the volume to the instance, unless it is attached already or is in use, e.g. still attached to
+// the node of a previous pod: the external-attacher then detaches and attaches it in order. The volume is marked as
+// attached ahead first, and attached with the device tag of its PV, if any, as ControllerPublishVolume would.
+func (a *attachAhead) attach(volumeID, instanceID, nodeName, deviceTag string) error {
+	vol, err := a.cloud.GetVolume(volumeID)
+	if err != nil {
+		if cpoerrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !canAttachAhead(vol) {
+		klog.V(5).Infof("Not attaching volume %s in status %s ahead", volumeID, vol.Status)
+		return nil
+	}
+
+	md := map[string]string{
+		attachedAheadKey:   "true",
+		attachedAheadAtKey: time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := a.cloud.UpdateVolumeMetadata(volumeID, md); err != nil {
+		return err
+	}
+
+	if _, err := a.cloud.AttachVolume(instanceID, volumeID, deviceTag); err != nil {
+		return err
+	}
+
+	klog.V(4).Infof("Attached volume %s to instance %s of node %s ahead", volumeID, instanceID, nodeName)
+
+	return nil
+}
+
+// canAttachAhead returns whether the volume is available and not attached anywhere.
+func canAttachAhead(vol *volumes.Volume) bool {
+	return vol.Status == openstack.VolumeAvailableStatus && len(vol.Attachments) == 0 && !vol.Multiattach
+}
+
+// claimAttachedAhead is called by ControllerPublishVolume, on any replica of the controller plugin: the volume
+// attached ahead is now managed by the external-attacher.
+func claimAttachedAhead(cloud openstack.IOpenStack, vol *volumes.Volume) {
+	if vol.Metadata[attachedAheadKey] != "true" {
+		return
+	}
+
+	// The sweep releases the volume anyway once it finds its VolumeAttachment
+	if err := cloud.UpdateVolumeMetadata(vol.ID, map[string]string{attachedAheadKey: "false"}); err != nil {
+		klog.Warningf("Failed to release volume %s attached ahead: %v", vol.ID, err)
+	}
+}
+
+// sweep detaches the volumes of the cluster attached ahead which are not claimed after the timeout and have no
+// VolumeAttachment.
+func (a *attachAhead) sweep() {
+	vols, err := a.cloud.GetVolumesByMetadata(map[string]string{attachedAheadKey: "true"})
+	if err != nil {
+		klog.Errorf("Failed to list the volumes attached ahead: %v", err)
+		return
+	}
+	if len(vols) == 0 {
+		return
+	}
+
+	pvs, err := a.pvLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list PersistentVolumes: %v", err)
+		return
+	}
+	volumeHandles := make(map[string]string, len(pvs))
+	for _, pv := range pvs {
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == driverName {
+			volumeHandles[pv.Name] = pv.Spec.CSI.VolumeHandle
+		}
+	}
+
+	vas, err := a.vaLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list VolumeAttachments: %v", err)
+		return
+	}
+	cluster := make(map[string]bool, len(volumeHandles))
+	for _, handle := range volumeHandles {
+		cluster[handle] = true
+	}
+	claimed := make(map[string]bool)
+	for _, va := range vas {
+		if va.Spec.Attacher == driverName && va.Spec.Source.PersistentVolumeName != nil {
+			if handle, ok := volumeHandles[*va.Spec.Source.PersistentVolumeName]; ok {
+				claimed[handle] = true
+			}
+		}
+	}
+
+	for _, vol := range vols {
+		// The volumes of other clusters sharing the project are left to them
+		if !cluster[vol.ID] {
+			continue
+		}
+
+		if attachedAt, err := time.Parse(time.RFC3339, vol.Metadata[attachedAheadAtKey]); err == nil && time.Since(attachedAt) <= a.timeout {
+			continue
+		}
+
+		if !claimed[vol.ID] {
+			if err := a.detach(&vol); err != nil {
+				klog.Errorf("Failed to detach volume %s attached ahead: %v", vol.ID, err)
+				continue
+			}
+		}
+
+		if err := a.cloud.UpdateVolumeMetadata(vol.ID, map[string]string{attachedAheadKey: "false"}); err != nil {
+			klog.Errorf("Failed to release volume %s attached ahead: %v", vol.ID, err)
+		}
+	}
+}
+
+// detach detaches the unclaimed volume from the instances it is attached to.
+func (a *attachAhead) detach(vol *volumes.Volume) error {
+	for _, att := range vol.Attachments {
+		klog.Infof("Detaching volume %s attached ahead to instance %s and not claimed after %v", vol.ID, att.ServerID, a.timeout)
+		if err := a.cloud.DetachVolume(att.ServerID, vol.ID); err != nil && !cpoerrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
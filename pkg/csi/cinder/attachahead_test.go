@@ -0,0 +1,213 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+)
+
+// fakeAttachAheadCloud returns vol from GetVolume, the mock doesn't allow to set it
+type fakeAttachAheadCloud struct {
+	*openstack.OpenStackMock
+
+	vol volumes.Volume
+}
+
+func (c fakeAttachAheadCloud) GetVolume(volumeID string) (*volumes.Volume, error) {
+	vol := c.vol
+	vol.ID = volumeID
+	return &vol, nil
+}
+
+func fakeAttachAhead(t *testing.T, cloud openstack.IOpenStack, objects ...runtime.Object) *attachAhead {
+	pvName := "pv-data"
+	objects = append(objects,
+		&v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "db"},
+			Spec:       v1.PersistentVolumeClaimSpec{VolumeName: pvName},
+			Status:     v1.PersistentVolumeClaimStatus{Phase: v1.ClaimBound},
+		},
+		&v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: pvName},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{
+					CSI: &v1.CSIPersistentVolumeSource{Driver: driverName, VolumeHandle: FakeVolID},
+				},
+			},
+		},
+		&storagev1.CSINode{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+			Spec: storagev1.CSINodeSpec{
+				Drivers: []storagev1.CSINodeDriver{{Name: driverName, NodeID: FakeInstanceID}},
+			},
+		},
+	)
+
+	a := newAttachAhead(cloud, AttachAheadOpts{Enabled: true, Timeout: time.Minute, KubeClient: fake.NewSimpleClientset(objects...)})
+
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	a.factory.Start(stopCh)
+	assert.True(t, a.cachesSynced(stopCh))
+
+	return a
+}
+
+func pendingPod(nodeName string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "postgres-0", Namespace: "db"},
+		Spec: v1.PodSpec{
+			NodeName: nodeName,
+			Volumes: []v1.Volume{{
+				Name: "data",
+				VolumeSource: v1.VolumeSource{
+					PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "data"},
+				},
+			}},
+		},
+		Status: v1.PodStatus{Phase: v1.PodPending},
+	}
+}
+
+func TestAttachAhead(t *testing.T) {
+	assert := assert.New(t)
+
+	cloud := new(openstack.OpenStackMock)
+	a := fakeAttachAhead(t, fakeAttachAheadCloud{OpenStackMock: cloud, vol: volumes.Volume{Status: "available"}})
+	defer a.queue.ShutDown()
+
+	assert.NoError(a.podInformer.GetStore().Add(pendingPod("node-1")))
+
+	// The volume is marked as attached ahead before being attached
+	cloud.On("UpdateVolumeMetadata", FakeVolID, mock.MatchedBy(func(md map[string]string) bool {
+		_, err := time.Parse(time.RFC3339, md[attachedAheadAtKey])
+		return len(md) == 2 && md[attachedAheadKey] == "true" && err == nil
+	})).Return(nil).Once()
+	cloud.On("AttachVolume", FakeInstanceID, FakeVolID, "").Return(FakeVolID, nil).Once()
+	assert.NoError(a.attachPodVolumes("db/postgres-0"))
+	cloud.AssertExpectations(t)
+
+	// A volume already attached is not attached again
+	attached := fakeAttachAhead(t, fakeAttachAheadCloud{OpenStackMock: cloud, vol: volumes.Volume{Status: "attaching"}})
+	defer attached.queue.ShutDown()
+	assert.NoError(attached.podInformer.GetStore().Add(pendingPod("node-1")))
+	assert.NoError(attached.attachPodVolumes("db/postgres-0"))
+	cloud.AssertNumberOfCalls(t, "AttachVolume", 1)
+}
+
+func TestClaimAttachedAhead(t *testing.T) {
+	cloud := new(openstack.OpenStackMock)
+
+	claimAttachedAhead(cloud, &volumes.Volume{ID: "vol-1"})
+	claimAttachedAhead(cloud, &volumes.Volume{ID: "vol-2", Metadata: map[string]string{attachedAheadKey: "false"}})
+
+	cloud.On("UpdateVolumeMetadata", "vol-3", map[string]string{attachedAheadKey: "false"}).Return(nil).Once()
+	claimAttachedAhead(cloud, &volumes.Volume{ID: "vol-3", Metadata: map[string]string{attachedAheadKey: "true"}})
+
+	cloud.AssertExpectations(t)
+}
+
+func TestAttachAheadNodeNotRegistered(t *testing.T) {
+	assert := assert.New(t)
+
+	cloud := new(openstack.OpenStackMock)
+	a := fakeAttachAhead(t, fakeAttachAheadCloud{OpenStackMock: cloud, vol: volumes.Volume{Status: "available"}})
+	defer a.queue.ShutDown()
+
+	// The node service of node-2 hasn't registered its node ID yet
+	assert.NoError(a.podInformer.GetStore().Add(pendingPod("node-2")))
+	assert.NoError(a.attachPodVolumes("db/postgres-0"))
+
+	cloud.AssertNotCalled(t, "AttachVolume", FakeInstanceID, FakeVolID, "")
+}
+
+func TestAttachAheadSweep(t *testing.T) {
+	assert := assert.New(t)
+
+	pv := func(name, volumeID string) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{
+					CSI: &v1.CSIPersistentVolumeSource{Driver: driverName, VolumeHandle: volumeID},
+				},
+			},
+		}
+	}
+	pvName := "pv-data"
+	va := &storagev1.VolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{Name: "csi-1234"},
+		Spec: storagev1.VolumeAttachmentSpec{
+			Attacher: driverName,
+			NodeName: "node-1",
+			Source:   storagev1.VolumeAttachmentSource{PersistentVolumeName: &pvName},
+		},
+	}
+
+	cloud := new(openstack.OpenStackMock)
+	a := fakeAttachAhead(t, cloud, va, pv("pv-2", "vol-2"), pv("pv-3", "vol-3"))
+	defer a.queue.ShutDown()
+
+	expired := time.Now().Add(-2 * time.Minute).UTC().Format(time.RFC3339)
+	attached := func(id, at string) volumes.Volume {
+		return volumes.Volume{
+			ID:          id,
+			Attachments: []volumes.Attachment{{ServerID: FakeInstanceID}},
+			Metadata:    map[string]string{attachedAheadKey: "true", attachedAheadAtKey: at},
+		}
+	}
+	cloud.On("GetVolumesByMetadata", map[string]string{attachedAheadKey: "true"}).Return([]volumes.Volume{
+		// Claimed by a VolumeAttachment
+		attached(FakeVolID, expired),
+		// Not claimed
+		attached("vol-2", expired),
+		// Not expired yet
+		attached("vol-3", time.Now().UTC().Format(time.RFC3339)),
+		// Of another cluster
+		attached("vol-4", expired),
+	}, nil)
+
+	released := map[string]string{attachedAheadKey: "false"}
+	cloud.On("UpdateVolumeMetadata", FakeVolID, released).Return(nil).Once()
+	cloud.On("DetachVolume", FakeInstanceID, "vol-2").Return(nil).Once()
+	cloud.On("UpdateVolumeMetadata", "vol-2", released).Return(nil).Once()
+	a.sweep()
+
+	cloud.AssertExpectations(t)
+	assert.Len(cloud.Calls, 4)
+}
+
+func TestCanAttachAhead(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(canAttachAhead(&volumes.Volume{Status: "available"}))
+	assert.False(canAttachAhead(&volumes.Volume{Status: "in-use", Attachments: []volumes.Attachment{{ServerID: "old-node"}}}))
+	assert.False(canAttachAhead(&volumes.Volume{Status: "detaching"}))
+	assert.False(canAttachAhead(&volumes.Volume{Status: "available", Multiattach: true}))
+}
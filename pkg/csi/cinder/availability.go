@@ -0,0 +1,84 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/schedulerhints"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+// parseIgnoreVolumeAZ returns the ignoreVolumeAZ parameter of a StorageClass,
+// defaulting to the ignore-volume-az option of the cloud config.
+func parseIgnoreVolumeAZ(value string, def bool) (bool, error) {
+	if value == "" {
+		return def, nil
+	}
+
+	ignore, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid ignoreVolumeAZ parameter %q: %v", value, err)
+	}
+
+	return ignore, nil
+}
+
+// availabilityCandidates returns the availability zones the volume is created
+// in, tried in order: the requested zone followed by the comma-separated zones
+// of the availabilityFallback parameter of a StorageClass.
+func availabilityCandidates(availability, fallback string) []string {
+	zones := []string{availability}
+	if availability == "" {
+		// The volume is created in the default zone of Cinder
+		return zones
+	}
+
+	for _, zone := range strings.Split(fallback, ",") {
+		zone = strings.TrimSpace(zone)
+		if zone != "" && zone != availability {
+			zones = append(zones, zone)
+		}
+	}
+
+	return zones
+}
+
+// createVolumeInZones creates the volume in the first of the zones accepted by
+// Cinder. The next zone is tried only when Cinder rejects the request, e.g.
+// because the zone doesn't exist in Cinder.
+func createVolumeInZones(cloud openstack.IOpenStack, zones []string, name string, size int, vtype, snapshotID, sourceVolID, sourceBackupID string, tags map[string]string, schedulerHints *schedulerhints.SchedulerHints) (*volumes.Volume, error) {
+	var (
+		vol *volumes.Volume
+		err error
+	)
+
+	for i, zone := range zones {
+		if vol, err = cloud.CreateVolume(name, size, vtype, zone, snapshotID, sourceVolID, sourceBackupID, tags, schedulerHints); err == nil || !cpoerrors.IsInvalidError(err) || i == len(zones)-1 {
+			break
+		}
+
+		klog.Warningf("Failed to create volume in availability zone %q, falling back to %q: %v", zone, zones[i+1], err)
+	}
+
+	return vol, err
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/schedulerhints"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+)
+
+func TestParseIgnoreVolumeAZ(t *testing.T) {
+	ignore, err := parseIgnoreVolumeAZ("", true)
+	assert.NoError(t, err)
+	assert.True(t, ignore)
+
+	ignore, err = parseIgnoreVolumeAZ("false", true)
+	assert.NoError(t, err)
+	assert.False(t, ignore)
+
+	ignore, err = parseIgnoreVolumeAZ("true", false)
+	assert.NoError(t, err)
+	assert.True(t, ignore)
+
+	_, err = parseIgnoreVolumeAZ("sometimes", false)
+	assert.Error(t, err)
+}
+
+func TestAvailabilityCandidates(t *testing.T) {
+	assert.Equal(t, []string{"nova-1"}, availabilityCandidates("nova-1", ""))
+	assert.Equal(t, []string{"nova-1", "cinder-1", "nova"}, availabilityCandidates("nova-1", " cinder-1, nova-1,,nova "))
+	assert.Equal(t, []string{""}, availabilityCandidates("", "cinder-1"))
+}
+
+func TestCreateVolumeInZones(t *testing.T) {
+	hints := (*schedulerhints.SchedulerHints)(nil)
+	vol := &volumes.Volume{ID: "vol", AvailabilityZone: "cinder-1"}
+
+	t.Run("falls back on rejected zones", func(t *testing.T) {
+		osmock := new(openstack.OpenStackMock)
+		osmock.On("CreateVolume", "pv", 1, "type", "nova-1", "", "", "", map[string]string(nil), hints).Return((*volumes.Volume)(nil), gophercloud.ErrDefault400{})
+		osmock.On("CreateVolume", "pv", 1, "type", "cinder-1", "", "", "", map[string]string(nil), hints).Return(vol, nil)
+
+		actual, err := createVolumeInZones(osmock, []string{"nova-1", "cinder-1", "nova"}, "pv", 1, "type", "", "", "", nil, hints)
+		assert.NoError(t, err)
+		assert.Equal(t, vol, actual)
+		osmock.AssertNotCalled(t, "CreateVolume", "pv", 1, "type", "nova", "", "", "", map[string]string(nil), hints)
+	})
+
+	t.Run("fails on other errors", func(t *testing.T) {
+		osmock := new(openstack.OpenStackMock)
+		osmock.On("CreateVolume", "pv", 1, "type", "nova-1", "", "", "", map[string]string(nil), hints).Return((*volumes.Volume)(nil), errors.New("quota exceeded"))
+
+		_, err := createVolumeInZones(osmock, []string{"nova-1", "cinder-1"}, "pv", 1, "type", "", "", "", nil, hints)
+		assert.EqualError(t, err, "quota exceeded")
+		osmock.AssertNumberOfCalls(t, "CreateVolume", 1)
+	})
+
+	t.Run("returns the error of the last zone", func(t *testing.T) {
+		osmock := new(openstack.OpenStackMock)
+		osmock.On("CreateVolume", "pv", 1, "type", "nova-1", "", "", "", map[string]string(nil), hints).Return((*volumes.Volume)(nil), gophercloud.ErrDefault400{})
+		osmock.On("CreateVolume", "pv", 1, "type", "cinder-1", "", "", "", map[string]string(nil), hints).Return((*volumes.Volume)(nil), gophercloud.ErrDefault400{})
+
+		_, err := createVolumeInZones(osmock, []string{"nova-1", "cinder-1"}, "pv", 1, "type", "", "", "", nil, hints)
+		assert.Error(t, err)
+		osmock.AssertNumberOfCalls(t, "CreateVolume", 2)
+	})
+}
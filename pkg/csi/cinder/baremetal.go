@@ -0,0 +1,426 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	utilexec "k8s.io/utils/exec"
+
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+// Bare-metal nodes, i.e. nodes unknown to Nova, are attached volumes with the
+// Cinder attachments API: the controller reserves the volume for the node and
+// passes the attachment ID in the publish context, the node sets its connector
+// on the attachment, connects the volume and completes the attachment.
+
+const (
+	// attachmentIDKey is the publish context key holding the Cinder attachment
+	// of a volume attached to a bare-metal node
+	attachmentIDKey = "AttachmentID"
+
+	iscsiDeviceTimeout = 30 * time.Second
+
+	// iscsiadm exit codes
+	iscsiErrSessionExists = 15
+	iscsiErrNoObjsFound   = 21
+)
+
+const iscsiInitiatorFile = "/etc/iscsi/initiatorname.iscsi"
+
+// iscsiNodeDirs are the directories where iscsiadm keeps the node records,
+// depending on the distribution.
+var iscsiNodeDirs = []string{"/etc/iscsi/nodes", "/var/lib/iscsi/nodes"}
+
+// iscsiTarget is the iSCSI LUN of an attachment, as found in the connection
+// info returned by Cinder.
+type iscsiTarget struct {
+	portal       string
+	iqn          string
+	lun          int
+	authMethod   string
+	authUsername string
+	authPassword string
+}
+
+func parseISCSITarget(connectionInfo map[string]interface{}) (*iscsiTarget, error) {
+	if t, _ := connectionInfo["driver_volume_type"].(string); t != "iscsi" {
+		return nil, fmt.Errorf("unsupported driver volume type %q, only iscsi is supported on bare-metal nodes", t)
+	}
+
+	data, ok := connectionInfo["data"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("connection info has no data")
+	}
+
+	t := &iscsiTarget{}
+	t.portal, _ = data["target_portal"].(string)
+	t.iqn, _ = data["target_iqn"].(string)
+	if t.portal == "" || t.iqn == "" {
+		return nil, fmt.Errorf("connection info has no target portal or IQN")
+	}
+	// JSON numbers are decoded as float64
+	if lun, ok := data["target_lun"].(float64); ok {
+		t.lun = int(lun)
+	}
+	t.authMethod, _ = data["auth_method"].(string)
+	t.authUsername, _ = data["auth_username"].(string)
+	t.authPassword, _ = data["auth_password"].(string)
+
+	return t, nil
+}
+
+// byPathPrefix returns the prefix of the udev links of the LUNs of the target.
+func (t *iscsiTarget) byPathPrefix() string {
+	return fmt.Sprintf("/dev/disk/by-path/ip-%s-iscsi-%s-lun-", t.portal, t.iqn)
+}
+
+func (t *iscsiTarget) devicePath() string {
+	return fmt.Sprintf("%s%d", t.byPathPrefix(), t.lun)
+}
+
+func iscsiadm(exec utilexec.Interface, t *iscsiTarget, args ...string) error {
+	args = append([]string{"-m", "node", "-T", t.iqn, "-p", t.portal}, args...)
+	out, err := exec.Command("iscsiadm", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("iscsiadm %s failed: %w, output: %s", strings.Join(args, " "), err, string(out))
+	}
+	return nil
+}
+
+// setISCSINodeAuth writes the CHAP credentials of the target to its node
+// records, created by iscsiadm -o new, which the login then uses. They are
+// not set with iscsiadm -o update, as the password would be exposed in the
+// command line of the process.
+func setISCSINodeAuth(nodeDirs []string, t *iscsiTarget) error {
+	host, port, err := net.SplitHostPort(t.portal)
+	if err != nil {
+		return fmt.Errorf("invalid target portal %q: %v", t.portal, err)
+	}
+
+	// A record is <target>/<host>,<port>,<tpgt>/<iface>
+	var records []string
+	for _, dir := range nodeDirs {
+		matches, err := filepath.Glob(filepath.Join(dir, t.iqn, fmt.Sprintf("%s,%s,*", host, port), "*"))
+		if err != nil {
+			return err
+		}
+		records = append(records, matches...)
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("no iSCSI node record found for target %s at %s", t.iqn, t.portal)
+	}
+
+	settings := [][2]string{
+		{"node.session.auth.authmethod", t.authMethod},
+		{"node.session.auth.username", t.authUsername},
+		{"node.session.auth.password", t.authPassword},
+	}
+	for _, record := range records {
+		data, err := os.ReadFile(record)
+		if err != nil {
+			return err
+		}
+
+		var lines []string
+		for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+			key, _, _ := strings.Cut(line, "=")
+			if key = strings.TrimSpace(key); key == settings[0][0] || key == settings[1][0] || key == settings[2][0] {
+				continue
+			}
+			lines = append(lines, line)
+		}
+		for _, setting := range settings {
+			lines = append(lines, fmt.Sprintf("%s = %s", setting[0], setting[1]))
+		}
+
+		f, err := os.CreateTemp(filepath.Dir(record), ".record-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+
+		if _, err := f.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		if err := os.Chmod(f.Name(), 0600); err != nil {
+			return err
+		}
+		if err := os.Rename(f.Name(), record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func hasExitStatus(err error, code int) bool {
+	var exitErr utilexec.ExitError
+	return errors.As(err, &exitErr) && exitErr.ExitStatus() == code
+}
+
+// connectISCSI logs in to the target and waits for the device of the LUN.
+func connectISCSI(exec utilexec.Interface, t *iscsiTarget) (string, error) {
+	if err := iscsiadm(exec, t, "-o", "new"); err != nil {
+		return "", err
+	}
+	if t.authMethod != "" {
+		if err := setISCSINodeAuth(iscsiNodeDirs, t); err != nil {
+			return "", err
+		}
+	}
+	if err := iscsiadm(exec, t, "--login"); err != nil && !hasExitStatus(err, iscsiErrSessionExists) {
+		return "", err
+	}
+
+	devicePath := t.devicePath()
+	err := wait.PollUntilContextTimeout(context.Background(), time.Second, iscsiDeviceTimeout, true, func(context.Context) (bool, error) {
+		_, err := os.Stat(devicePath)
+		return err == nil, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("device %s did not appear: %v", devicePath, err)
+	}
+
+	return devicePath, nil
+}
+
+// disconnectISCSI removes the device of the LUN, and logs out of the target if
+// none of its other LUNs are in use on the node.
+func disconnectISCSI(exec utilexec.Interface, t *iscsiTarget) error {
+	devicePath := t.devicePath()
+	if dev, err := filepath.EvalSymlinks(devicePath); err == nil {
+		deletePath := filepath.Join("/sys/block", filepath.Base(dev), "device", "delete")
+		if err := os.WriteFile(deletePath, []byte("1"), 0200); err != nil {
+			return fmt.Errorf("failed to delete device %s: %v", dev, err)
+		}
+	}
+
+	luns, err := filepath.Glob(t.byPathPrefix() + "*")
+	if err != nil {
+		return err
+	}
+	for _, lun := range luns {
+		if lun != devicePath {
+			klog.V(4).Infof("Not logging out of iSCSI target %s, LUN %s is still connected", t.iqn, lun)
+			return nil
+		}
+	}
+
+	if err := iscsiadm(exec, t, "--logout"); err != nil && !hasExitStatus(err, iscsiErrNoObjsFound) {
+		return err
+	}
+	if err := iscsiadm(exec, t, "-o", "delete"); err != nil && !hasExitStatus(err, iscsiErrNoObjsFound) {
+		return err
+	}
+
+	return nil
+}
+
+// readInitiatorName returns the iSCSI initiator name of the node.
+func readInitiatorName(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if name, ok := strings.CutPrefix(line, "InitiatorName="); ok {
+			return name, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", fmt.Errorf("no InitiatorName in %s", path)
+}
+
+// getConnector returns the connector of the node, which describes to Cinder
+// how the node connects to the volumes.
+func getConnector() (map[string]interface{}, error) {
+	initiator, err := readInitiatorName(iscsiInitiatorFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the iSCSI initiator name: %v", err)
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	connector := map[string]interface{}{
+		"initiator": initiator,
+		"host":      host,
+		"os_type":   "linux",
+		"multipath": false,
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.IsGlobalUnicast() {
+			connector["ip"] = ipNet.IP.String()
+			break
+		}
+	}
+
+	return connector, nil
+}
+
+// connectAttachment connects the volume reserved by the attachment to the
+// node and returns the path of its device.
+func (ns *nodeServer) connectAttachment(attachmentID string) (string, error) {
+	att, err := ns.Cloud.GetAttachmentByID(attachmentID)
+	if err != nil {
+		return "", err
+	}
+
+	// The attachment is already completed when the stage request is retried
+	if att.Status != openstack.AttachmentAttachedStatus {
+		connector, err := getConnector()
+		if err != nil {
+			return "", err
+		}
+		att, err = ns.Cloud.UpdateAttachment(attachmentID, connector)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	t, err := parseISCSITarget(att.ConnectionInfo)
+	if err != nil {
+		return "", err
+	}
+
+	devicePath, err := connectISCSI(ns.Mount.Mounter().Exec, t)
+	if err != nil {
+		return "", err
+	}
+
+	if att.Status != openstack.AttachmentAttachedStatus {
+		if err := ns.Cloud.CompleteAttachment(attachmentID); err != nil {
+			return "", err
+		}
+	}
+
+	return devicePath, nil
+}
+
+// attachmentDevicePath returns the path of the device of a volume already
+// connected to the node.
+func (ns *nodeServer) attachmentDevicePath(attachmentID string) (string, error) {
+	att, err := ns.Cloud.GetAttachmentByID(attachmentID)
+	if err != nil {
+		return "", err
+	}
+
+	t, err := parseISCSITarget(att.ConnectionInfo)
+	if err != nil {
+		return "", err
+	}
+
+	return t.devicePath(), nil
+}
+
+// disconnectAttachment disconnects the volume from the node if it's a
+// bare-metal node. Deleting the attachment is left to the controller.
+func (ns *nodeServer) disconnectAttachment(volumeID string) error {
+	isBareMetal, err := ns.isBareMetal()
+	if err != nil || !isBareMetal {
+		return err
+	}
+
+	nodeID, err := ns.Metadata.GetInstanceID()
+	if err != nil {
+		return err
+	}
+
+	att, err := ns.Cloud.GetAttachment(nodeID, volumeID)
+	if err != nil {
+		if cpoerrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	t, err := parseISCSITarget(att.ConnectionInfo)
+	if err != nil {
+		return err
+	}
+
+	return disconnectISCSI(ns.Mount.Mounter().Exec, t)
+}
+
+// isBareMetal returns true if bare-metal attach is enabled and the node is not
+// a Nova server but an Ironic node. The result is cached, a node doesn't change
+// its nature.
+func (ns *nodeServer) isBareMetal() (bool, error) {
+	if !ns.Cloud.GetBlockStorageOpts().BareMetalAttach {
+		return false, nil
+	}
+
+	ns.bareMetalMtx.Lock()
+	defer ns.bareMetalMtx.Unlock()
+
+	if ns.bareMetal != nil {
+		return *ns.bareMetal, nil
+	}
+
+	nodeID, err := ns.Metadata.GetInstanceID()
+	if err != nil {
+		return false, err
+	}
+
+	bareMetal := false
+	_, err = ns.Cloud.GetInstanceByID(nodeID)
+	if err != nil {
+		if !cpoerrors.IsNotFound(err) {
+			return false, err
+		}
+
+		_, err = ns.Cloud.GetBareMetalNode(nodeID)
+		if err != nil && !cpoerrors.IsNotFound(err) {
+			return false, err
+		}
+		bareMetal = err == nil
+	}
+
+	ns.bareMetal = &bareMetal
+	return bareMetal, nil
+}
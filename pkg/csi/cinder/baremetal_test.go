@@ -0,0 +1,201 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	"k8s.io/cloud-provider-openstack/pkg/util/metadata"
+)
+
+// fakeBareMetalCloud enables bare-metal attach and knows the Nova servers,
+// the mock doesn't allow to set them
+type fakeBareMetalCloud struct {
+	*openstack.OpenStackMock
+
+	servers map[string]bool
+}
+
+func (c fakeBareMetalCloud) GetBlockStorageOpts() openstack.BlockStorageOpts {
+	return openstack.BlockStorageOpts{BareMetalAttach: true}
+}
+
+func (c fakeBareMetalCloud) GetInstanceByID(instanceID string) (*servers.Server, error) {
+	if !c.servers[instanceID] {
+		return nil, gophercloud.ErrDefault404{}
+	}
+	return &servers.Server{ID: instanceID}, nil
+}
+
+func TestParseISCSITarget(t *testing.T) {
+	tests := []struct {
+		name           string
+		connectionInfo map[string]interface{}
+		expected       *iscsiTarget
+		expectedPath   string
+		wantErr        bool
+	}{
+		{
+			name: "iscsi with CHAP",
+			connectionInfo: map[string]interface{}{
+				"driver_volume_type": "iscsi",
+				"data": map[string]interface{}{
+					"target_portal": "192.0.2.10:3260",
+					"target_iqn":    "iqn.2010-10.org.openstack:volume-1",
+					"target_lun":    float64(1),
+					"auth_method":   "CHAP",
+					"auth_username": "user",
+					"auth_password": "secret",
+				},
+			},
+			expected: &iscsiTarget{
+				portal:       "192.0.2.10:3260",
+				iqn:          "iqn.2010-10.org.openstack:volume-1",
+				lun:          1,
+				authMethod:   "CHAP",
+				authUsername: "user",
+				authPassword: "secret",
+			},
+			expectedPath: "/dev/disk/by-path/ip-192.0.2.10:3260-iscsi-iqn.2010-10.org.openstack:volume-1-lun-1",
+		},
+		{
+			name: "unsupported driver volume type",
+			connectionInfo: map[string]interface{}{
+				"driver_volume_type": "rbd",
+				"data":               map[string]interface{}{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing target",
+			connectionInfo: map[string]interface{}{
+				"driver_volume_type": "iscsi",
+				"data":               map[string]interface{}{"target_lun": float64(0)},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target, err := parseISCSITarget(test.connectionInfo)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, target)
+			assert.Equal(t, test.expectedPath, target.devicePath())
+		})
+	}
+}
+
+func TestReadInitiatorName(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "initiatorname.iscsi")
+
+	err := os.WriteFile(path, []byte("## DO NOT EDIT\nInitiatorName=iqn.1994-05.com.redhat:node-1\n"), 0644)
+	assert.NoError(t, err)
+	name, err := readInitiatorName(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "iqn.1994-05.com.redhat:node-1", name)
+
+	err = os.WriteFile(path, []byte("# empty\n"), 0644)
+	assert.NoError(t, err)
+	_, err = readInitiatorName(path)
+	assert.Error(t, err)
+}
+
+func TestIsBareMetal(t *testing.T) {
+	cloud := new(openstack.OpenStackMock)
+	cloud.On("GetBareMetalNode", "ironic-node").Return(&nodes.Node{UUID: "ironic-node"}, nil)
+	cloud.On("GetBareMetalNode", "unknown-node").Return(nil, gophercloud.ErrDefault404{})
+	fakeCloud := fakeBareMetalCloud{OpenStackMock: cloud, servers: map[string]bool{"nova-server": true}}
+
+	tests := []struct {
+		nodeID   string
+		expected bool
+	}{
+		{nodeID: "ironic-node", expected: true},
+		{nodeID: "nova-server", expected: false},
+		// Neither a Nova server nor an Ironic node, e.g. a deleted server
+		{nodeID: "unknown-node", expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.nodeID, func(t *testing.T) {
+			meta := new(metadata.MetadataMock)
+			meta.On("GetInstanceID").Return(test.nodeID, nil)
+			ns := &nodeServer{Cloud: fakeCloud, Metadata: meta}
+
+			isBareMetal, err := ns.isBareMetal()
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, isBareMetal)
+		})
+	}
+
+	// The controller only looks up Ironic for the nodes unknown to Nova
+	cs := &controllerServer{Cloud: fakeCloud}
+	isBareMetal, err := cs.isBareMetalNode("ironic-node")
+	assert.NoError(t, err)
+	assert.True(t, isBareMetal)
+	isBareMetal, err = cs.isBareMetalNode("unknown-node")
+	assert.NoError(t, err)
+	assert.False(t, isBareMetal)
+
+	cloud.AssertNotCalled(t, "GetBareMetalNode", "nova-server")
+}
+
+func TestSetISCSINodeAuth(t *testing.T) {
+	dir := t.TempDir()
+	target := &iscsiTarget{
+		portal:       "192.0.2.10:3260",
+		iqn:          "iqn.2010-10.org.openstack:volume-1",
+		authMethod:   "CHAP",
+		authUsername: "user",
+		authPassword: "secret",
+	}
+
+	// No record, iscsiadm -o new hasn't been run
+	assert.Error(t, setISCSINodeAuth([]string{dir}, target))
+
+	recordDir := filepath.Join(dir, target.iqn, "192.0.2.10,3260,1")
+	assert.NoError(t, os.MkdirAll(recordDir, 0700))
+	record := filepath.Join(recordDir, "default")
+	err := os.WriteFile(record, []byte("node.name = iqn.2010-10.org.openstack:volume-1\nnode.session.auth.authmethod = None\nnode.session.auth.password = stale\n"), 0644)
+	assert.NoError(t, err)
+
+	assert.NoError(t, setISCSINodeAuth([]string{filepath.Join(dir, "missing"), dir}, target))
+
+	data, err := os.ReadFile(record)
+	assert.NoError(t, err)
+	assert.Equal(t, "node.name = iqn.2010-10.org.openstack:volume-1\n"+
+		"node.session.auth.authmethod = CHAP\n"+
+		"node.session.auth.username = user\n"+
+		"node.session.auth.password = secret\n", string(data))
+
+	fi, err := os.Stat(record)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+}
@@ -19,9 +19,11 @@ package cinder
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/backups"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/schedulerhints"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/snapshots"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
 	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
@@ -31,6 +33,7 @@ import (
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
 	"k8s.io/cloud-provider-openstack/pkg/util"
 	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
 	"k8s.io/klog/v2"
@@ -39,10 +42,41 @@ import (
 type controllerServer struct {
 	Driver *Driver
 	Cloud  openstack.IOpenStack
+
+	// deletionQueue defers the deletion of volumes, nil if volumes are deleted synchronously
+	deletionQueue *deletionQueue
+
+	// attachAhead attaches the volumes of scheduled pods before ControllerPublishVolume, nil if disabled
+	attachAhead *attachAhead
+
+	// volumeTransfer transfers the volumes of annotated PersistentVolumes to other projects, nil if disabled
+	volumeTransfer *volumeTransfer
+
+	// attachLocks serializes the attachments and detachments of the same volume
+	attachLocks *volumeLocks
+
+	// owner is the metadata marking the volumes and snapshots created by the cluster
+	owner map[string]string
 }
 
 const (
 	cinderCSIClusterIDKey = "cinder.csi.openstack.org/cluster"
+	// cinderCSICellKey is the volume metadata key recording the Nova cell the volume was created for
+	cinderCSICellKey = "cinder.csi.openstack.org/cell"
+
+	// StorageClass parameter and volume context key of the post-restore verification
+	restoreVerificationKey = "restoreVerification"
+
+	restoreVerificationNone = "none"
+	restoreVerificationFsck = "fsck"
+
+	// restoreVerifiedKey is the volume metadata key recording that the
+	// restored volume passed its verification, which only runs once
+	restoreVerifiedKey = "cinder.csi.openstack.org/restore-verified"
+
+	// snapshotsListSort is the order of the paginated snapshots, the ID
+	// breaks the ties of the snapshots created at the same time.
+	snapshotsListSort = "created_at:asc,id:asc"
 )
 
 func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
@@ -70,6 +104,15 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 	// Volume Type
 	volType := req.GetParameters()["type"]
 
+	// Mutable parameters of the VolumeAttributesClass of the PVC
+	modification, err := parseMutableParameters(req.GetMutableParameters())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "[CreateVolume] %v", err)
+	}
+	if modification != nil && modification.volumeType != "" {
+		volType = modification.volumeType
+	}
+
 	// First check if volAvailability is already specified, if not get preferred from Topology
 	// Required, incase vol AZ is different from node AZ
 	volAvailability := req.GetParameters()["availability"]
@@ -80,8 +123,79 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 		}
 	}
 
+	volCtx, err := getRestoreVerificationContext(req.GetParameters(), req.GetVolumeContentSource())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "[CreateVolume] %v", err)
+	}
+
+	readCacheCtx, err := getReadCacheContext(req.GetParameters())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "[CreateVolume] %v", err)
+	}
+	if readCacheCtx != nil {
+		if volCtx == nil {
+			volCtx = map[string]string{}
+		}
+		for k, v := range readCacheCtx {
+			volCtx[k] = v
+		}
+	}
+
 	cloud := cs.Cloud
-	ignoreVolumeAZ := cloud.GetBlockStorageOpts().IgnoreVolumeAZ
+	ignoreVolumeAZ, err := parseIgnoreVolumeAZ(req.GetParameters()["ignoreVolumeAZ"], cloud.GetBlockStorageOpts().IgnoreVolumeAZ)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "[CreateVolume] %v", err)
+	}
+
+	// Attach the volume with its PV name as device tag, requires --extra-create-metadata
+	if cloud.GetBlockStorageOpts().AttachDeviceTag {
+		if tag := pvDeviceTag(req.GetParameters()["csi.storage.k8s.io/pv/name"]); tag != "" {
+			if volCtx == nil {
+				volCtx = map[string]string{}
+			}
+			volCtx[deviceTagKey] = tag
+		}
+	}
+
+	// Volumes are cell-local in multi-cell deployments, pin the volume to the cell of the selected node
+	var volCell string
+	if cloud.GetBlockStorageOpts().CellMetadataKey != "" && req.GetAccessibilityRequirements() != nil {
+		volCell = util.GetAZFromTopology(cellTopologyKey, req.GetAccessibilityRequirements())
+	}
+
+	// Place the volume on the same host as the instance of the selected node, requires WaitForFirstConsumer
+	var schedulerHints *schedulerhints.SchedulerHints
+	if localToInstance := req.GetParameters()["localToInstance"]; localToInstance != "" {
+		enabled, err := strconv.ParseBool(localToInstance)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "[CreateVolume] invalid localToInstance parameter %q: %v", localToInstance, err)
+		}
+		if enabled {
+			instanceID := util.GetAZFromTopology(instanceTopologyKey, req.GetAccessibilityRequirements())
+			if instanceID == "" {
+				return nil, status.Errorf(codes.InvalidArgument, "[CreateVolume] localToInstance requires the %s topology key of the selected node, use WaitForFirstConsumer volume binding and enable instance-topology", instanceTopologyKey)
+			}
+			schedulerHints = &schedulerhints.SchedulerHints{LocalToInstance: instanceID}
+		}
+	}
+
+	// A volume is unstaged every time its pods leave a node, the persistent
+	// volumes opting in are wiped each time, e.g. for scratch data
+	wipeProperties, err := parseWipeParameter(req.GetParameters()["wipe"])
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "[CreateVolume] %v", err)
+	}
+
+	// Multi-node access modes require a multiattach volume type
+	multiNode := hasMultiNodeMode(volCapabilities)
+	if multiNode && len(wipeProperties) > 0 {
+		return nil, status.Error(codes.InvalidArgument, "[CreateVolume] the wipe parameter is not supported with multi-node access modes, the volume would be wiped while staged on other nodes")
+	}
+	if multiNode {
+		if err := validateMultiNodeCreate(cloud, volType, volCapabilities, readCacheCtx != nil); err != nil {
+			return nil, err
+		}
+	}
 
 	// Verify a volume with the provided name doesn't already exist for this tenant
 	volumes, err := cloud.GetVolumesByName(volName)
@@ -94,8 +208,14 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 		if volSizeGB != volumes[0].Size {
 			return nil, status.Error(codes.AlreadyExists, "Volume Already exists with same name and different capacity")
 		}
+		if multiNode && !volumes[0].Multiattach {
+			return nil, status.Error(codes.AlreadyExists, "Volume Already exists with same name and without multiattach")
+		}
 		klog.V(4).Infof("Volume %s already exists in Availability Zone: %s of size %d GiB", volumes[0].ID, volumes[0].AvailabilityZone, volumes[0].Size)
-		return getCreateVolumeResponse(&volumes[0], ignoreVolumeAZ, req.GetAccessibilityRequirements()), nil
+		if err := cs.setCreatedVolumeBootable(&volumes[0], modification); err != nil {
+			return nil, status.Errorf(codes.Internal, "[CreateVolume] %v", err)
+		}
+		return getCreateVolumeResponse(&volumes[0], volCtx, ignoreVolumeAZ, req.GetAccessibilityRequirements()), nil
 	} else if len(volumes) > 1 {
 		klog.V(3).Infof("found multiple existing volumes with selected name (%s) during create", volName)
 		return nil, status.Error(codes.Internal, "Multiple volumes reported by Cinder with same name")
@@ -103,7 +223,18 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 	}
 
 	// Volume Create
-	properties := map[string]string{cinderCSIClusterIDKey: cs.Driver.cluster}
+	properties := cs.ownerProperties()
+	if volCell != "" {
+		properties[cinderCSICellKey] = volCell
+	}
+	if modification != nil {
+		for k, v := range modification.metadata {
+			properties[k] = v
+		}
+	}
+	for k, v := range wipeProperties {
+		properties[k] = v
+	}
 	//Tag volume with metadata if present: https://github.com/kubernetes-csi/external-provisioner/pull/399
 	for _, mKey := range []string{"csi.storage.k8s.io/pvc/name", "csi.storage.k8s.io/pvc/namespace", "csi.storage.k8s.io/pv/name"} {
 		if v, ok := req.Parameters[mKey]; ok {
@@ -132,6 +263,24 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 		if err == nil && snap.Status != "available" {
 			return nil, status.Errorf(codes.Unavailable, "VolumeContentSource Snapshot %s is not yet available. status: %s", snapshotID, snap.Status)
 		}
+		// Imported snapshots are not sized by the provisioner, so make sure
+		// the requested capacity can hold the snapshot contents.
+		if err == nil && volSizeGB < snap.Size {
+			return nil, status.Errorf(codes.OutOfRange, "Requested volume size %d GiB is smaller than the source snapshot %s size %d GiB", volSizeGB, snapshotID, snap.Size)
+		}
+		// Cinder restores the snapshot in the availability zone of its source
+		// volume, which must be allowed by the topology, e.g. of the node
+		// selected for a WaitForFirstConsumer volume
+		if err == nil && req.GetParameters()["availability"] == "" && req.GetAccessibilityRequirements() != nil &&
+			!ignoreVolumeAZ && !cloud.GetBlockStorageOpts().CrossAZSnapshotRestore {
+			az, err := snapshotRestoreAvailability(cloud, snap, req.GetAccessibilityRequirements())
+			if err != nil {
+				return nil, err
+			}
+			if az != "" {
+				volAvailability = az
+			}
+		}
 
 		// In case a snapshot is not found
 		// check if a Backup with the same ID exists
@@ -146,6 +295,9 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 				// If the backup exists but is not yet available, fail.
 				return nil, status.Errorf(codes.Unavailable, "VolumeContentSource Backup %s is not yet available. status: %s", snapshotID, back.Status)
 			}
+			if volSizeGB < back.Size {
+				return nil, status.Errorf(codes.OutOfRange, "Requested volume size %d GiB is smaller than the source backup %s size %d GiB", volSizeGB, snapshotID, back.Size)
+			}
 			// If an available backup is found, create the volume from the backup
 			sourceBackupID = snapshotID
 			snapshotID = ""
@@ -168,7 +320,9 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 		}
 	}
 
-	vol, err := cloud.CreateVolume(volName, volSizeGB, volType, volAvailability, snapshotID, sourceVolID, sourceBackupID, properties)
+	// Cinder may not know the zone of the topology, e.g. when the zones of Nova and Cinder are named differently
+	zones := availabilityCandidates(volAvailability, req.GetParameters()["availabilityFallback"])
+	vol, err := createVolumeInZones(cloud, zones, volName, volSizeGB, volType, snapshotID, sourceVolID, sourceBackupID, properties, schedulerHints)
 	// When creating a volume from a backup, the response does not include the backupID.
 	if sourceBackupID != "" {
 		vol.BackupID = &sourceBackupID
@@ -181,7 +335,11 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 
 	klog.V(4).Infof("CreateVolume: Successfully created volume %s in Availability Zone: %s of size %d GiB", vol.ID, vol.AvailabilityZone, vol.Size)
 
-	return getCreateVolumeResponse(vol, ignoreVolumeAZ, req.GetAccessibilityRequirements()), nil
+	if err := cs.setCreatedVolumeBootable(vol, modification); err != nil {
+		return nil, status.Errorf(codes.Internal, "[CreateVolume] %v", err)
+	}
+
+	return getCreateVolumeResponse(vol, volCtx, ignoreVolumeAZ, req.GetAccessibilityRequirements()), nil
 }
 
 func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
@@ -192,6 +350,38 @@ func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVol
 	if len(volID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "DeleteVolume Volume ID must be provided")
 	}
+
+	if cs.Cloud.GetBlockStorageOpts().ProtectUnownedVolumes {
+		vol, err := cs.Cloud.GetVolume(volID)
+		if err != nil {
+			if cpoerrors.IsNotFound(err) {
+				klog.V(3).Infof("Volume %s is already deleted.", volID)
+				return &csi.DeleteVolumeResponse{}, nil
+			}
+			return nil, status.Errorf(codes.Internal, "DeleteVolume get volume failed with error %v", err)
+		}
+		if !isOwned(vol.Metadata, cs.owner) && vol.Metadata[cinderCSIForceDeleteKey] != "true" {
+			return nil, status.Errorf(codes.FailedPrecondition, "DeleteVolume volume %s is not owned by the cluster, set its %s metadata to true to delete it", volID, cinderCSIForceDeleteKey)
+		}
+	}
+
+	if cs.deletionQueue != nil {
+		if err := cs.deletionQueue.Enqueue(volID); err != nil {
+			if cpoerrors.IsNotFound(err) {
+				klog.V(3).Infof("Volume %s is already deleted.", volID)
+				return &csi.DeleteVolumeResponse{}, nil
+			}
+			if status.Code(err) == codes.FailedPrecondition {
+				return nil, err
+			}
+			klog.Errorf("Failed to queue the deletion of volume %s: %v", volID, err)
+			return nil, status.Errorf(codes.Internal, "DeleteVolume failed with error %v", err)
+		}
+
+		klog.V(4).Infof("DeleteVolume: Queued the deletion of volume %s", volID)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
 	err := cs.Cloud.DeleteVolume(volID)
 	if err != nil {
 		if cpoerrors.IsNotFound(err) {
@@ -225,7 +415,15 @@ func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *cs
 		return nil, status.Error(codes.InvalidArgument, "[ControllerPublishVolume] Volume capability must be provided")
 	}
 
-	_, err := cs.Cloud.GetVolume(volumeID)
+	op := metrics.StartVolumeOperation(instanceID, "attach")
+	defer op.Done()
+
+	if err := cs.attachLocks.Acquire(ctx, volumeID); err != nil {
+		return nil, status.Errorf(codes.Aborted, "[ControllerPublishVolume] Volume %s is being attached or detached: %v", volumeID, err)
+	}
+	defer cs.attachLocks.Release(volumeID)
+
+	vol, err := cs.Cloud.GetVolume(volumeID)
 	if err != nil {
 		if cpoerrors.IsNotFound(err) {
 			return nil, status.Errorf(codes.NotFound, "[ControllerPublishVolume] Volume %s not found", volumeID)
@@ -233,20 +431,38 @@ func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *cs
 		return nil, status.Errorf(codes.Internal, "[ControllerPublishVolume] get volume failed with error %v", err)
 	}
 
-	_, err = cs.Cloud.GetInstanceByID(instanceID)
+	if isMultiNodeMode(volumeCapability.GetAccessMode().GetMode()) && !vol.Multiattach {
+		return nil, status.Errorf(codes.FailedPrecondition, "[ControllerPublishVolume] Volume %s is not multiattach and cannot be published with access mode %s", volumeID, volumeCapability.GetAccessMode().GetMode())
+	}
+
+	server, err := cs.Cloud.GetInstanceByID(instanceID)
 	if err != nil {
 		if cpoerrors.IsNotFound(err) {
+			isBareMetal, err := cs.isBareMetalNode(instanceID)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "[ControllerPublishVolume] GetBareMetalNode failed with error %v", err)
+			}
+			if isBareMetal {
+				return cs.publishBareMetal(op, instanceID, volumeID)
+			}
 			return nil, status.Errorf(codes.NotFound, "[ControllerPublishVolume] Instance %s not found", instanceID)
 		}
 		return nil, status.Errorf(codes.Internal, "[ControllerPublishVolume] GetInstanceByID failed with error %v", err)
 	}
 
-	_, err = cs.Cloud.AttachVolume(instanceID, volumeID)
+	if cellKey := cs.Cloud.GetBlockStorageOpts().CellMetadataKey; cellKey != "" {
+		if volCell, ok := vol.Metadata[cinderCSICellKey]; ok && volCell != server.Metadata[cellKey] {
+			return nil, status.Errorf(codes.FailedPrecondition, "[ControllerPublishVolume] Volume %s in cell %q cannot be attached to instance %s in cell %q", volumeID, volCell, instanceID, server.Metadata[cellKey])
+		}
+	}
+
+	_, err = cs.Cloud.AttachVolume(instanceID, volumeID, req.GetVolumeContext()[deviceTagKey])
 	if err != nil {
 		klog.Errorf("Failed to AttachVolume: %v", err)
 		return nil, status.Errorf(codes.Internal, "[ControllerPublishVolume] Attach Volume failed with error %v", err)
 
 	}
+	claimAttachedAhead(cs.Cloud, vol)
 
 	err = cs.Cloud.WaitDiskAttached(instanceID, volumeID)
 	if err != nil {
@@ -261,6 +477,7 @@ func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *cs
 	}
 
 	klog.V(4).Infof("ControllerPublishVolume %s on %s is successful", volumeID, instanceID)
+	op.Succeeded()
 
 	// Publish Volume Info
 	pvInfo := map[string]string{}
@@ -281,9 +498,25 @@ func (cs *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *
 	if len(volumeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "[ControllerUnpublishVolume] Volume ID must be provided")
 	}
+
+	op := metrics.StartVolumeOperation(instanceID, "detach")
+	defer op.Done()
+
+	if err := cs.attachLocks.Acquire(ctx, volumeID); err != nil {
+		return nil, status.Errorf(codes.Aborted, "[ControllerUnpublishVolume] Volume %s is being attached or detached: %v", volumeID, err)
+	}
+	defer cs.attachLocks.Release(volumeID)
+
 	_, err := cs.Cloud.GetInstanceByID(instanceID)
 	if err != nil {
 		if cpoerrors.IsNotFound(err) {
+			isBareMetal, err := cs.isBareMetalNode(instanceID)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "[ControllerUnpublishVolume] GetBareMetalNode failed with error %v", err)
+			}
+			if isBareMetal {
+				return cs.unpublishBareMetal(op, instanceID, volumeID)
+			}
 			klog.V(3).Infof("ControllerUnpublishVolume assuming volume %s is detached, because node %s does not exist", volumeID, instanceID)
 			return &csi.ControllerUnpublishVolumeResponse{}, nil
 		}
@@ -311,6 +544,69 @@ func (cs *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *
 	}
 
 	klog.V(4).Infof("ControllerUnpublishVolume %s on %s", volumeID, instanceID)
+	op.Succeeded()
+
+	return &csi.ControllerUnpublishVolumeResponse{}, nil
+}
+
+// isBareMetalNode returns true if bare-metal attach is enabled and the node,
+// which is not a Nova server, is an Ironic node.
+func (cs *controllerServer) isBareMetalNode(instanceID string) (bool, error) {
+	if !cs.Cloud.GetBlockStorageOpts().BareMetalAttach {
+		return false, nil
+	}
+
+	_, err := cs.Cloud.GetBareMetalNode(instanceID)
+	if err != nil {
+		if cpoerrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// publishBareMetal reserves the volume for a node unknown to Nova with a
+// Cinder attachment, which the node connects and completes when staging the
+// volume.
+func (cs *controllerServer) publishBareMetal(op *metrics.VolumeOperation, instanceID, volumeID string) (*csi.ControllerPublishVolumeResponse, error) {
+	attachmentID, err := cs.Cloud.CreateAttachment(instanceID, volumeID)
+	if err != nil {
+		klog.Errorf("Failed to CreateAttachment: %v", err)
+		return nil, status.Errorf(codes.Internal, "[ControllerPublishVolume] Attach Volume to bare-metal node failed with error %v", err)
+	}
+
+	klog.V(4).Infof("ControllerPublishVolume %s on bare-metal node %s is successful, attachment %s", volumeID, instanceID, attachmentID)
+	op.Succeeded()
+
+	return &csi.ControllerPublishVolumeResponse{
+		PublishContext: map[string]string{attachmentIDKey: attachmentID},
+	}, nil
+}
+
+// unpublishBareMetal deletes the Cinder attachment of the volume to a node
+// unknown to Nova, the node has disconnected the volume when unstaging it.
+func (cs *controllerServer) unpublishBareMetal(op *metrics.VolumeOperation, instanceID, volumeID string) (*csi.ControllerUnpublishVolumeResponse, error) {
+	att, err := cs.Cloud.GetAttachment(instanceID, volumeID)
+	if err != nil {
+		if cpoerrors.IsNotFound(err) {
+			klog.V(3).Infof("ControllerUnpublishVolume assuming volume %s is detached, because node %s has no attachment", volumeID, instanceID)
+			return &csi.ControllerUnpublishVolumeResponse{}, nil
+		}
+		return nil, status.Errorf(codes.Internal, "[ControllerUnpublishVolume] GetAttachment failed with error %v", err)
+	}
+
+	if err := cs.Cloud.DeleteAttachment(att.ID); err != nil {
+		if cpoerrors.IsNotFound(err) {
+			return &csi.ControllerUnpublishVolumeResponse{}, nil
+		}
+		klog.Errorf("Failed to DeleteAttachment: %v", err)
+		return nil, status.Errorf(codes.Internal, "ControllerUnpublishVolume Detach Volume failed with error %v", err)
+	}
+
+	klog.V(4).Infof("ControllerUnpublishVolume %s on bare-metal node %s", volumeID, instanceID)
+	op.Succeeded()
 
 	return &csi.ControllerUnpublishVolumeResponse{}, nil
 }
@@ -346,6 +642,7 @@ func (cs *controllerServer) ListVolumes(ctx context.Context, req *csi.ListVolume
 		for _, attachment := range v.Attachments {
 			status.PublishedNodeIds = append(status.PublishedNodeIds, attachment.ServerID)
 		}
+		status.VolumeCondition = volumeCondition(&v)
 		ventry.Status = status
 
 		ventries = append(ventries, &ventry)
@@ -451,7 +748,7 @@ func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateS
 
 	// Create the snapshot if the backup does not already exist and wait for it to be ready
 	if !backupAlreadyExists {
-		snap, err = cs.createSnapshot(name, volumeID, req.Parameters)
+		snap, err = cs.createSnapshot(ctx, name, volumeID, req.Parameters)
 		if err != nil {
 			return nil, err
 		}
@@ -529,7 +826,7 @@ func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateS
 
 }
 
-func (cs *controllerServer) createSnapshot(name string, volumeID string, parameters map[string]string) (snap *snapshots.Snapshot, err error) {
+func (cs *controllerServer) createSnapshot(ctx context.Context, name string, volumeID string, parameters map[string]string) (snap *snapshots.Snapshot, err error) {
 
 	filters := map[string]string{}
 	filters["Name"] = name
@@ -560,8 +857,8 @@ func (cs *controllerServer) createSnapshot(name string, volumeID string, paramet
 		return snap, nil
 	}
 
-	// Add cluster ID to the snapshot metadata
-	properties := map[string]string{cinderCSIClusterIDKey: cs.Driver.cluster}
+	// Add the owner metadata to the snapshot metadata
+	properties := cs.ownerProperties()
 
 	// see https://github.com/kubernetes-csi/external-snapshotter/pull/375/
 	// Also, we don't want to tag every param but we still want to send the
@@ -572,6 +869,19 @@ func (cs *controllerServer) createSnapshot(name string, volumeID string, paramet
 			properties[mKey] = v
 		}
 	}
+	if _, ok := parameters[openstack.SnapshotForceCreate]; !ok && cs.Cloud.GetBlockStorageOpts().SnapshotForceCreate {
+		properties[openstack.SnapshotForceCreate] = "true"
+	}
+
+	// Quiesce the pods using the volume until the snapshot is taken
+	if hooks := cs.Driver.snapshotHooks; hooks != nil {
+		release, err := hooks.run(ctx, volumeID)
+		if err != nil {
+			klog.Errorf("Failed to run the pre-snapshot hooks of volume %s: %v", volumeID, err)
+			return nil, status.Errorf(codes.Internal, "CreateSnapshot failed to run the pre-snapshot hooks: %v", err)
+		}
+		defer release()
+	}
 
 	// TODO: Delegate the check to openstack itself and ignore the conflict
 	snap, err = cs.Cloud.CreateSnapshot(name, volumeID, properties)
@@ -580,6 +890,14 @@ func (cs *controllerServer) createSnapshot(name string, volumeID string, paramet
 		return nil, status.Errorf(codes.Internal, "CreateSnapshot failed with error %v", err)
 	}
 
+	if cs.Driver.snapshotHooks != nil {
+		// The failure is reported by CreateSnapshot, which waits for the
+		// snapshot again
+		if _, err := cs.Cloud.WaitSnapshotReady(snap.ID); err != nil {
+			klog.Errorf("Failed to wait for snapshot %s before running the post-snapshot hooks: %v", snap.ID, err)
+		}
+	}
+
 	klog.V(3).Infof("CreateSnapshot %s from volume with ID: %s", name, volumeID)
 
 	return snap, nil
@@ -587,8 +905,8 @@ func (cs *controllerServer) createSnapshot(name string, volumeID string, paramet
 
 func (cs *controllerServer) createBackup(name string, volumeID string, snap *snapshots.Snapshot, parameters map[string]string) (*backups.Backup, error) {
 
-	// Add cluster ID to the snapshot metadata
-	properties := map[string]string{cinderCSIClusterIDKey: cs.Driver.cluster}
+	// Add the owner metadata to the snapshot metadata
+	properties := cs.ownerProperties()
 
 	// see https://github.com/kubernetes-csi/external-snapshotter/pull/375/
 	// Also, we don't want to tag every param but we still want to send the
@@ -643,9 +961,17 @@ func (cs *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteS
 }
 
 func (cs *controllerServer) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
+	klog.V(4).Infof("ListSnapshots: called with %+#v request", req)
+
+	if req.MaxEntries < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "[ListSnapshots] Invalid max entries request %v, must not be negative ", req.MaxEntries)
+	}
 
 	snapshotID := req.GetSnapshotId()
 	if len(snapshotID) != 0 {
+		// A lookup by ID is also how the external-snapshotter imports a
+		// pre-provisioned VolumeSnapshotContent, so the snapshot may have been
+		// created outside of Kubernetes and has to be validated.
 		snap, err := cs.Cloud.GetSnapshotByID(snapshotID)
 		if err != nil {
 			if cpoerrors.IsNotFound(err) {
@@ -654,6 +980,15 @@ func (cs *controllerServer) ListSnapshots(ctx context.Context, req *csi.ListSnap
 			}
 			return nil, status.Errorf(codes.Internal, "Failed to GetSnapshot %s: %v", snapshotID, err)
 		}
+		if sourceVolumeID := req.GetSourceVolumeId(); sourceVolumeID != "" && snap.VolumeID != sourceVolumeID {
+			klog.V(3).Infof("Snapshot %s is not a snapshot of volume %s", snapshotID, sourceVolumeID)
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+
+		ready, err := validateSnapshot(snap)
+		if err != nil {
+			return nil, err
+		}
 
 		ctime := timestamppb.New(snap.CreatedAt)
 
@@ -663,7 +998,7 @@ func (cs *controllerServer) ListSnapshots(ctx context.Context, req *csi.ListSnap
 				SnapshotId:     snap.ID,
 				SourceVolumeId: snap.VolumeID,
 				CreationTime:   ctime,
-				ReadyToUse:     true,
+				ReadyToUse:     ready,
 			},
 		}
 
@@ -680,19 +1015,29 @@ func (cs *controllerServer) ListSnapshots(ctx context.Context, req *csi.ListSnap
 	var err error
 	var nextPageToken string
 
-	// Add the filters
+	// Add the filters. The source volume filter is paginated as well, the
+	// snapshots are sorted by creation time and ID so that the tokens stay
+	// valid when snapshots are created between the pages.
 	if len(req.GetSourceVolumeId()) != 0 {
 		filters["VolumeID"] = req.GetSourceVolumeId()
-	} else {
+	}
+	if req.MaxEntries > 0 {
 		filters["Limit"] = strconv.Itoa(int(req.MaxEntries))
+	}
+	if req.StartingToken != "" {
 		filters["Marker"] = req.StartingToken
 	}
+	filters["Sort"] = snapshotsListSort
 
 	// Only retrieve snapshots that are available
 	filters["Status"] = "available"
 	slist, nextPageToken, err = cs.Cloud.ListSnapshots(filters)
 	if err != nil {
 		klog.Errorf("Failed to ListSnapshots: %v", err)
+		// Cinder rejects a marker of a missing snapshot
+		if req.StartingToken != "" && (cpoerrors.IsInvalidError(err) || cpoerrors.IsNotFound(err)) {
+			return nil, status.Errorf(codes.Aborted, "[ListSnapshots] Invalid starting token %s: %v", req.StartingToken, err)
+		}
 		return nil, status.Errorf(codes.Internal, "ListSnapshots failed with error %v", err)
 	}
 
@@ -713,11 +1058,12 @@ func (cs *controllerServer) ListSnapshots(ctx context.Context, req *csi.ListSnap
 		}
 		sentries = append(sentries, &sentry)
 	}
+
+	klog.V(4).Infof("ListSnapshots: completed with %d entries and %q next token", len(sentries), nextPageToken)
 	return &csi.ListSnapshotsResponse{
 		Entries:   sentries,
 		NextToken: nextPageToken,
 	}, nil
-
 }
 
 // ControllerGetCapabilities implements the default GRPC callout.
@@ -743,7 +1089,7 @@ func (cs *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req
 		return nil, status.Error(codes.InvalidArgument, "ValidateVolumeCapabilities Volume ID must be provided")
 	}
 
-	_, err := cs.Cloud.GetVolume(volumeID)
+	vol, err := cs.Cloud.GetVolume(volumeID)
 	if err != nil {
 		if cpoerrors.IsNotFound(err) {
 			return nil, status.Errorf(codes.NotFound, "ValidateVolumeCapabilities Volume %s not found", volumeID)
@@ -751,20 +1097,26 @@ func (cs *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req
 		return nil, status.Errorf(codes.Internal, "ValidateVolumeCapabilities %v", err)
 	}
 
+	var confirmed []*csi.VolumeCapability
 	for _, cap := range reqVolCap {
-		if cap.GetAccessMode().GetMode() != cs.Driver.vcap[0].Mode {
+		mode := cap.GetAccessMode().GetMode()
+		supported := false
+		for _, m := range cs.Driver.vcap {
+			if m.Mode == mode {
+				supported = true
+				break
+			}
+		}
+		// Only multiattach volumes can be published on several nodes
+		if !supported || (isMultiNodeMode(mode) && !vol.Multiattach) {
 			return &csi.ValidateVolumeCapabilitiesResponse{Message: "Requested Volume Capability not supported"}, nil
 		}
+		confirmed = append(confirmed, cap)
 	}
 
-	// Cinder CSI driver currently supports one mode only
 	resp := &csi.ValidateVolumeCapabilitiesResponse{
 		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
-			VolumeCapabilities: []*csi.VolumeCapability{
-				{
-					AccessMode: cs.Driver.vcap[0],
-				},
-			},
+			VolumeCapabilities: confirmed,
 		},
 	}
 
@@ -804,6 +1156,7 @@ func (cs *controllerServer) ControllerGetVolume(ctx context.Context, req *csi.Co
 	for _, attachment := range volume.Attachments {
 		status.PublishedNodeIds = append(status.PublishedNodeIds, attachment.ServerID)
 	}
+	status.VolumeCondition = volumeCondition(volume)
 	ventry.Status = status
 
 	return &ventry, nil
@@ -853,7 +1206,7 @@ func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi
 
 	// we need wait for the volume to be available or InUse, it might be error_extending in some scenario
 	targetStatus := []string{openstack.VolumeAvailableStatus, openstack.VolumeInUseStatus}
-	err = cs.Cloud.WaitVolumeTargetStatus(volumeID, targetStatus)
+	err = cs.Cloud.WaitVolumeTargetStatus(volumeID, targetStatus, openstack.WaitResize)
 	if err != nil {
 		klog.Errorf("Failed to WaitVolumeTargetStatus of volume %s: %v", volumeID, err)
 		return nil, status.Errorf(codes.Internal, "[ControllerExpandVolume] Volume %s not in target state after resize operation: %v", volumeID, err)
@@ -867,7 +1220,82 @@ func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi
 	}, nil
 }
 
-func getCreateVolumeResponse(vol *volumes.Volume, ignoreVolumeAZ bool, accessibleTopologyReq *csi.TopologyRequirement) *csi.CreateVolumeResponse {
+func (cs *controllerServer) ControllerModifyVolume(ctx context.Context, req *csi.ControllerModifyVolumeRequest) (*csi.ControllerModifyVolumeResponse, error) {
+	klog.V(4).Infof("ControllerModifyVolume: called with args %+v", protosanitizer.StripSecrets(*req))
+
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
+	}
+
+	modification, err := parseMutableParameters(req.GetMutableParameters())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "[ControllerModifyVolume] %v", err)
+	}
+
+	volume, err := cs.Cloud.GetVolume(volumeID)
+	if err != nil {
+		if cpoerrors.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "Volume %s not found", volumeID)
+		}
+		return nil, status.Errorf(codes.Internal, "GetVolume failed with error %v", err)
+	}
+
+	if err := cs.modifyVolume(volume, modification); err != nil {
+		return nil, status.Errorf(codes.Internal, "[ControllerModifyVolume] %v", err)
+	}
+
+	klog.V(4).Infof("ControllerModifyVolume modified volume %s", volumeID)
+
+	return &csi.ControllerModifyVolumeResponse{}, nil
+}
+
+// getRestoreVerificationContext validates the post-restore verification
+// parameters and returns the volume context which instructs the node plugin to
+// verify the device before it's staged. Verification only applies to volumes
+// restored from a snapshot or cloned from another volume.
+func getRestoreVerificationContext(params map[string]string, content *csi.VolumeContentSource) (map[string]string, error) {
+	mode := params[restoreVerificationKey]
+	switch mode {
+	case "", restoreVerificationNone:
+		return nil, nil
+	case restoreVerificationFsck:
+	default:
+		return nil, fmt.Errorf("invalid %s parameter %q, must be one of %q or %q", restoreVerificationKey, mode, restoreVerificationNone, restoreVerificationFsck)
+	}
+
+	if content == nil {
+		return nil, nil
+	}
+
+	return map[string]string{
+		restoreVerificationKey: mode,
+	}, nil
+}
+
+// validateSnapshot checks that a snapshot looked up by ID can be used as a
+// VolumeSnapshotContent source and reports whether it is ready to use.
+func validateSnapshot(snap *snapshots.Snapshot) (bool, error) {
+	if snap.VolumeID == "" {
+		return false, status.Errorf(codes.FailedPrecondition, "Snapshot %s has no source volume", snap.ID)
+	}
+	if snap.Size <= 0 {
+		return false, status.Errorf(codes.FailedPrecondition, "Snapshot %s has invalid size %d", snap.ID, snap.Size)
+	}
+
+	// Transient statuses, e.g. creating, backing-up, restoring or deleting,
+	// are reported as not ready, only the error statuses are fatal.
+	switch {
+	case snap.Status == "available":
+		return true, nil
+	case strings.HasPrefix(snap.Status, "error"):
+		return false, status.Errorf(codes.FailedPrecondition, "Snapshot %s is in %s status", snap.ID, snap.Status)
+	default:
+		return false, nil
+	}
+}
+
+func getCreateVolumeResponse(vol *volumes.Volume, volCtx map[string]string, ignoreVolumeAZ bool, accessibleTopologyReq *csi.TopologyRequirement) *csi.CreateVolumeResponse {
 
 	var volsrc *csi.VolumeContentSource
 
@@ -906,12 +1334,16 @@ func getCreateVolumeResponse(vol *volumes.Volume, ignoreVolumeAZ bool, accessibl
 	// use from preferred topologies instead.
 	if ignoreVolumeAZ {
 		if accessibleTopologyReq != nil {
-			accessibleTopology = accessibleTopologyReq.GetPreferred()
+			accessibleTopology = zoneTopologies(accessibleTopologyReq.GetPreferred())
 		}
 	} else {
+		segments := map[string]string{topologyKey: vol.AvailabilityZone}
+		if cell, ok := vol.Metadata[cinderCSICellKey]; ok {
+			segments[cellTopologyKey] = cell
+		}
 		accessibleTopology = []*csi.Topology{
 			{
-				Segments: map[string]string{topologyKey: vol.AvailabilityZone},
+				Segments: segments,
 			},
 		}
 	}
@@ -922,9 +1354,34 @@ func getCreateVolumeResponse(vol *volumes.Volume, ignoreVolumeAZ bool, accessibl
 			CapacityBytes:      int64(vol.Size * 1024 * 1024 * 1024),
 			AccessibleTopology: accessibleTopology,
 			ContentSource:      volsrc,
+			VolumeContext:      volCtx,
 		},
 	}
 
 	return resp
 
 }
+
+// zoneTopologies keeps the zone and cell segments of the preferred topologies. The instance
+// segment identifies a single node and must not end up in the PV node affinity.
+func zoneTopologies(preferred []*csi.Topology) []*csi.Topology {
+	var topologies []*csi.Topology
+	seen := make(map[string]bool)
+	for _, t := range preferred {
+		zone, ok := t.GetSegments()[topologyKey]
+		if !ok {
+			continue
+		}
+		segments := map[string]string{topologyKey: zone}
+		cell, ok := t.GetSegments()[cellTopologyKey]
+		if ok {
+			segments[cellTopologyKey] = cell
+		}
+		if seen[zone+"/"+cell] {
+			continue
+		}
+		seen[zone+"/"+cell] = true
+		topologies = append(topologies, &csi.Topology{Segments: segments})
+	}
+	return topologies
+}
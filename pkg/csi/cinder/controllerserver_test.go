@@ -20,8 +20,15 @@ import (
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/schedulerhints"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/snapshots"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
 )
 
@@ -36,7 +43,7 @@ func init() {
 
 		d := NewDriver(&DriverOpts{Endpoint: FakeEndpoint, ClusterID: FakeCluster})
 
-		fakeCs = NewControllerServer(d, openstack.OsInstance)
+		fakeCs, _ = NewControllerServer(d, openstack.OsInstance)
 	}
 }
 
@@ -44,8 +51,8 @@ func init() {
 func TestCreateVolume(t *testing.T) {
 	// mock OpenStack
 	properties := map[string]string{"cinder.csi.openstack.org/cluster": FakeCluster}
-	// CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourceVolID string, sourceBackupID string, tags map[string]string) (string, string, int, error)
-	osmock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, FakeAvailability, "", "", "", properties).Return(&FakeVol, nil)
+	// CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourceVolID string, sourceBackupID string, tags map[string]string, schedulerHints *schedulerhints.SchedulerHints) (string, string, int, error)
+	osmock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, FakeAvailability, "", "", "", properties, (*schedulerhints.SchedulerHints)(nil)).Return(&FakeVol, nil)
 
 	osmock.On("GetVolumesByName", FakeVolName).Return(FakeVolListEmpty, nil)
 	// Init assert
@@ -90,9 +97,9 @@ func TestCreateVolume(t *testing.T) {
 func TestCreateVolumeWithParam(t *testing.T) {
 	// mock OpenStack
 	properties := map[string]string{"cinder.csi.openstack.org/cluster": FakeCluster}
-	// CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourceVolID string, sourceBackupID string, tags map[string]string) (string, string, int, error)
+	// CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourceVolID string, sourceBackupID string, tags map[string]string, schedulerHints *schedulerhints.SchedulerHints) (string, string, int, error)
 	// Vol type and availability comes from CreateVolumeRequest.Parameters
-	osmock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), "dummyVolType", "cinder", "", "", "", properties).Return(&FakeVol, nil)
+	osmock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), "dummyVolType", "cinder", "", "", "", properties, (*schedulerhints.SchedulerHints)(nil)).Return(&FakeVol, nil)
 
 	osmock.On("GetVolumesByName", FakeVolName).Return(FakeVolListEmpty, nil)
 	// Init assert
@@ -146,8 +153,8 @@ func TestCreateVolumeWithExtraMetadata(t *testing.T) {
 		"csi.storage.k8s.io/pvc/name":      FakePVCName,
 		"csi.storage.k8s.io/pvc/namespace": FakePVCNamespace,
 	}
-	// CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourceVolID string, sourceBackupID string, tags map[string]string) (string, string, int, error)
-	osmock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, FakeAvailability, "", "", "", properties).Return(&FakeVol, nil)
+	// CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourceVolID string, sourceBackupID string, tags map[string]string, schedulerHints *schedulerhints.SchedulerHints) (string, string, int, error)
+	osmock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, FakeAvailability, "", "", "", properties, (*schedulerhints.SchedulerHints)(nil)).Return(&FakeVol, nil)
 
 	osmock.On("GetVolumesByName", FakeVolName).Return(FakeVolListEmpty, nil)
 
@@ -184,10 +191,70 @@ func TestCreateVolumeWithExtraMetadata(t *testing.T) {
 
 }
 
+func TestCreateVolumeLocalToInstance(t *testing.T) {
+	properties := map[string]string{"cinder.csi.openstack.org/cluster": FakeCluster}
+	hints := &schedulerhints.SchedulerHints{LocalToInstance: FakeInstanceID}
+	// CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourceVolID string, sourceBackupID string, tags map[string]string, schedulerHints *schedulerhints.SchedulerHints) (string, string, int, error)
+	osmock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, FakeAvailability, "", "", "", properties, hints).Return(&FakeVol, nil)
+
+	osmock.On("GetVolumesByName", FakeVolName).Return(FakeVolListEmpty, nil)
+
+	fakeReq := &csi.CreateVolumeRequest{
+		Name:       FakeVolName,
+		Parameters: map[string]string{"localToInstance": "true"},
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			},
+		},
+		AccessibilityRequirements: &csi.TopologyRequirement{
+			Preferred: []*csi.Topology{
+				{
+					Segments: map[string]string{
+						topologyKey:         FakeAvailability,
+						instanceTopologyKey: FakeInstanceID,
+					},
+				},
+			},
+		},
+	}
+
+	_, err := fakeCs.CreateVolume(FakeCtx, fakeReq)
+	assert.NoError(t, err)
+	osmock.AssertCalled(t, "CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, FakeAvailability, "", "", "", properties, hints)
+
+	// Without the instance topology key of the selected node the volume can't be created
+	fakeReq.AccessibilityRequirements = &csi.TopologyRequirement{
+		Preferred: []*csi.Topology{{Segments: map[string]string{topologyKey: FakeAvailability}}},
+	}
+	_, err = fakeCs.CreateVolume(FakeCtx, fakeReq)
+	assert.Error(t, err)
+}
+
+func TestZoneTopologies(t *testing.T) {
+	preferred := []*csi.Topology{
+		{Segments: map[string]string{topologyKey: FakeAvailability, instanceTopologyKey: FakeInstanceID}},
+		{Segments: map[string]string{topologyKey: FakeAvailability, instanceTopologyKey: "other-instance"}},
+		{Segments: map[string]string{topologyKey: "nova2", cellTopologyKey: "cell1", instanceTopologyKey: "third-instance"}},
+		{Segments: map[string]string{instanceTopologyKey: "no-zone"}},
+	}
+	expected := []*csi.Topology{
+		{Segments: map[string]string{topologyKey: FakeAvailability}},
+		{Segments: map[string]string{topologyKey: "nova2", cellTopologyKey: "cell1"}},
+	}
+	assert.Equal(t, expected, zoneTopologies(preferred))
+	assert.Nil(t, zoneTopologies(nil))
+
+	resp := getCreateVolumeResponse(&FakeVol, nil, true, &csi.TopologyRequirement{Preferred: preferred[:1]})
+	assert.Equal(t, expected[:1], resp.Volume.AccessibleTopology)
+}
+
 func TestCreateVolumeFromSnapshot(t *testing.T) {
 	properties := map[string]string{"cinder.csi.openstack.org/cluster": FakeCluster}
-	// CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourceVolID string, sourceBackupID string, tags map[string]string) (string, string, int, error)
-	osmock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, "", FakeSnapshotID, "", "", properties).Return(&FakeVolFromSnapshot, nil)
+	// CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourceVolID string, sourceBackupID string, tags map[string]string, schedulerHints *schedulerhints.SchedulerHints) (string, string, int, error)
+	osmock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, "", FakeSnapshotID, "", "", properties, (*schedulerhints.SchedulerHints)(nil)).Return(&FakeVolFromSnapshot, nil)
 	osmock.On("GetVolumesByName", FakeVolName).Return(FakeVolListEmpty, nil)
 
 	// Init assert
@@ -233,8 +300,8 @@ func TestCreateVolumeFromSnapshot(t *testing.T) {
 
 func TestCreateVolumeFromSourceVolume(t *testing.T) {
 	properties := map[string]string{"cinder.csi.openstack.org/cluster": FakeCluster}
-	// CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourceVolID string, sourceBackupID string, tags map[string]string) (string, string, int, error)
-	osmock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, "", "", FakeVolID, "", properties).Return(&FakeVolFromSourceVolume, nil)
+	// CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourceVolID string, sourceBackupID string, tags map[string]string, schedulerHints *schedulerhints.SchedulerHints) (string, string, int, error)
+	osmock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, "", "", FakeVolID, "", properties, (*schedulerhints.SchedulerHints)(nil)).Return(&FakeVolFromSourceVolume, nil)
 	osmock.On("GetVolumesByName", FakeVolName).Return(FakeVolListEmpty, nil)
 
 	// Init assert
@@ -338,8 +405,8 @@ func TestDeleteVolume(t *testing.T) {
 
 // Test ControllerPublishVolume
 func TestControllerPublishVolume(t *testing.T) {
-	// AttachVolume(instanceID, volumeID string) (string, error)
-	osmock.On("AttachVolume", FakeNodeID, FakeVolID).Return(FakeVolID, nil)
+	// AttachVolume(instanceID, volumeID, deviceTag string) (string, error)
+	osmock.On("AttachVolume", FakeNodeID, FakeVolID, "").Return(FakeVolID, nil)
 	// WaitDiskAttached(instanceID string, volumeID string) error
 	osmock.On("WaitDiskAttached", FakeNodeID, FakeVolID).Return(nil)
 	// GetAttachmentDiskPath(instanceID, volumeID string) (string, error)
@@ -424,6 +491,7 @@ func TestListVolumes(t *testing.T) {
 				},
 				Status: &csi.ListVolumesResponse_VolumeStatus{
 					PublishedNodeIds: []string{FakeNodeID},
+					VolumeCondition:  &csi.VolumeCondition{Message: "Volume is available"},
 				},
 			},
 			{
@@ -433,6 +501,7 @@ func TestListVolumes(t *testing.T) {
 				},
 				Status: &csi.ListVolumesResponse_VolumeStatus{
 					PublishedNodeIds: []string{},
+					VolumeCondition:  &csi.VolumeCondition{Message: "Volume is available"},
 				},
 			},
 		},
@@ -548,7 +617,7 @@ func TestDeleteSnapshot(t *testing.T) {
 }
 
 func TestListSnapshots(t *testing.T) {
-	osmock.On("ListSnapshots", map[string]string{"Limit": "1", "Marker": FakeVolID, "Status": "available"}).Return(FakeSnapshotsRes, "", nil)
+	osmock.On("ListSnapshots", map[string]string{"Limit": "1", "Marker": FakeVolID, "Status": "available", "Sort": snapshotsListSort}).Return(FakeSnapshotsRes, "", nil)
 	assert := assert.New(t)
 
 	fakeReq := &csi.ListSnapshotsRequest{MaxEntries: 1, StartingToken: FakeVolID}
@@ -562,13 +631,51 @@ func TestListSnapshots(t *testing.T) {
 	assert.NotNil(FakeSnapshotID, actualRes.Entries[0].Snapshot.SnapshotId)
 }
 
+func TestListSnapshotsPagination(t *testing.T) {
+	osmock.On("ListSnapshots", map[string]string{"VolumeID": FakeVolID, "Limit": "1", "Status": "available", "Sort": snapshotsListSort}).Return(FakeSnapshotsRes, FakeSnapshotID, nil)
+	osmock.On("ListSnapshots", map[string]string{"VolumeID": FakeVolID, "Limit": "1", "Marker": FakeSnapshotID, "Status": "available", "Sort": snapshotsListSort}).Return(FakeSnapshotListEmpty, "", nil)
+	osmock.On("ListSnapshots", map[string]string{"Marker": "missing", "Status": "available", "Sort": snapshotsListSort}).Return(FakeSnapshotListEmpty, "", gophercloud.ErrDefault404{})
+	assert := assert.New(t)
+
+	// The source volume filter is paginated
+	actualRes, err := fakeCs.ListSnapshots(FakeCtx, &csi.ListSnapshotsRequest{SourceVolumeId: FakeVolID, MaxEntries: 1})
+	assert.NoError(err)
+	assert.Len(actualRes.Entries, 1)
+	assert.Equal(FakeSnapshotID, actualRes.NextToken)
+
+	actualRes, err = fakeCs.ListSnapshots(FakeCtx, &csi.ListSnapshotsRequest{SourceVolumeId: FakeVolID, MaxEntries: 1, StartingToken: actualRes.NextToken})
+	assert.NoError(err)
+	assert.Empty(actualRes.Entries)
+	assert.Empty(actualRes.NextToken)
+
+	// A token of a missing snapshot aborts the listing
+	_, err = fakeCs.ListSnapshots(FakeCtx, &csi.ListSnapshotsRequest{StartingToken: "missing"})
+	assert.Equal(codes.Aborted, status.Code(err))
+
+	_, err = fakeCs.ListSnapshots(FakeCtx, &csi.ListSnapshotsRequest{MaxEntries: -1})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+}
+
+func TestListSnapshotsByIDAndSourceVolume(t *testing.T) {
+	assert := assert.New(t)
+
+	actualRes, err := fakeCs.ListSnapshots(FakeCtx, &csi.ListSnapshotsRequest{SnapshotId: FakeSnapshotID, SourceVolumeId: FakeVolID})
+	assert.NoError(err)
+	assert.Len(actualRes.Entries, 1)
+
+	// The snapshot of another volume doesn't match
+	actualRes, err = fakeCs.ListSnapshots(FakeCtx, &csi.ListSnapshotsRequest{SnapshotId: FakeSnapshotID, SourceVolumeId: "other-volume"})
+	assert.NoError(err)
+	assert.Empty(actualRes.Entries)
+}
+
 func TestControllerExpandVolume(t *testing.T) {
 	tState := []string{"available", "in-use"}
 	// ExpandVolume(volumeID string, status string, size int)
 	osmock.On("ExpandVolume", FakeVolID, openstack.VolumeAvailableStatus, 5).Return(nil)
 
-	// WaitVolumeTargetStatus(volumeID string, tState []string) error
-	osmock.On("WaitVolumeTargetStatus", FakeVolID, tState).Return(nil)
+	// WaitVolumeTargetStatus(volumeID string, tState []string, op WaitOperation) error
+	osmock.On("WaitVolumeTargetStatus", FakeVolID, tState, openstack.WaitResize).Return(nil)
 
 	// Init assert
 	assert := assert.New(t)
@@ -661,3 +768,231 @@ func TestValidateVolumeCapabilities(t *testing.T) {
 	assert.Equal(expectedRes2, actualRes2)
 
 }
+
+func TestListSnapshotsByID(t *testing.T) {
+	assert := assert.New(t)
+
+	fakeReq := &csi.ListSnapshotsRequest{SnapshotId: FakeSnapshotID}
+	actualRes, err := fakeCs.ListSnapshots(FakeCtx, fakeReq)
+	if err != nil {
+		t.Errorf("failed to ListSnapshots: %v", err)
+	}
+
+	// Assert
+	assert.Len(actualRes.Entries, 1)
+	assert.Equal(FakeSnapshotID, actualRes.Entries[0].Snapshot.SnapshotId)
+	assert.Equal(FakeVolID, actualRes.Entries[0].Snapshot.SourceVolumeId)
+	assert.True(actualRes.Entries[0].Snapshot.ReadyToUse)
+}
+
+func TestValidateSnapshot(t *testing.T) {
+	tests := []struct {
+		name      string
+		snap      snapshots.Snapshot
+		wantReady bool
+		wantErr   bool
+	}{
+		{
+			name:      "available snapshot",
+			snap:      snapshots.Snapshot{ID: FakeSnapshotID, VolumeID: FakeVolID, Size: 1, Status: "available"},
+			wantReady: true,
+		},
+		{
+			name: "snapshot still creating",
+			snap: snapshots.Snapshot{ID: FakeSnapshotID, VolumeID: FakeVolID, Size: 1, Status: "creating"},
+		},
+		{
+			name: "snapshot backing up",
+			snap: snapshots.Snapshot{ID: FakeSnapshotID, VolumeID: FakeVolID, Size: 1, Status: "backing-up"},
+		},
+		{
+			name: "snapshot being deleted",
+			snap: snapshots.Snapshot{ID: FakeSnapshotID, VolumeID: FakeVolID, Size: 1, Status: "deleting"},
+		},
+		{
+			name:    "snapshot in error",
+			snap:    snapshots.Snapshot{ID: FakeSnapshotID, VolumeID: FakeVolID, Size: 1, Status: "error"},
+			wantErr: true,
+		},
+		{
+			name:    "snapshot failed to delete",
+			snap:    snapshots.Snapshot{ID: FakeSnapshotID, VolumeID: FakeVolID, Size: 1, Status: "error_deleting"},
+			wantErr: true,
+		},
+		{
+			name:    "snapshot without source volume",
+			snap:    snapshots.Snapshot{ID: FakeSnapshotID, Size: 1, Status: "available"},
+			wantErr: true,
+		},
+		{
+			name:    "snapshot without size",
+			snap:    snapshots.Snapshot{ID: FakeSnapshotID, VolumeID: FakeVolID, Status: "available"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ready, err := validateSnapshot(&test.snap)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.wantReady, ready)
+		})
+	}
+}
+
+func TestGetRestoreVerificationContext(t *testing.T) {
+	src := &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Snapshot{
+			Snapshot: &csi.VolumeContentSource_SnapshotSource{
+				SnapshotId: FakeSnapshotID,
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		params  map[string]string
+		content *csi.VolumeContentSource
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "no verification",
+			params:  map[string]string{},
+			content: src,
+		},
+		{
+			name:    "verification disabled",
+			params:  map[string]string{"restoreVerification": "none"},
+			content: src,
+		},
+		{
+			name:    "fsck",
+			params:  map[string]string{"restoreVerification": "fsck"},
+			content: src,
+			want:    map[string]string{"restoreVerification": "fsck"},
+		},
+		{
+			name:   "blank volume",
+			params: map[string]string{"restoreVerification": "fsck"},
+		},
+		{
+			name:    "invalid mode",
+			params:  map[string]string{"restoreVerification": "checksum"},
+			content: src,
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			volCtx, err := getRestoreVerificationContext(test.params, test.content)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, volCtx)
+		})
+	}
+}
+
+// fakeCellCloud enables the cell topology, returns vol from GetVolume and a
+// server in serverCell from GetInstanceByID, the mock doesn't allow to set them
+type fakeCellCloud struct {
+	*openstack.OpenStackMock
+
+	vol        volumes.Volume
+	serverCell string
+}
+
+func (c fakeCellCloud) GetBlockStorageOpts() openstack.BlockStorageOpts {
+	return openstack.BlockStorageOpts{CellMetadataKey: "cell"}
+}
+
+func (c fakeCellCloud) GetVolume(volumeID string) (*volumes.Volume, error) {
+	vol := c.vol
+	vol.ID = volumeID
+	return &vol, nil
+}
+
+func (c fakeCellCloud) GetInstanceByID(instanceID string) (*servers.Server, error) {
+	return &servers.Server{ID: instanceID, Metadata: map[string]string{"cell": c.serverCell}}, nil
+}
+
+func TestCreateVolumeCell(t *testing.T) {
+	properties := map[string]string{cinderCSIClusterIDKey: FakeCluster, cinderCSICellKey: "cell1"}
+	vol := FakeVol
+	vol.Metadata = properties
+
+	cloud := new(openstack.OpenStackMock)
+	cloud.On("GetVolumesByName", FakeVolName).Return(FakeVolListEmpty, nil)
+	cloud.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, FakeAvailability, "", "", "", properties, (*schedulerhints.SchedulerHints)(nil)).Return(&vol, nil)
+
+	d := NewDriver(&DriverOpts{Endpoint: FakeEndpoint, ClusterID: FakeCluster})
+	cs, err := NewControllerServer(d, fakeCellCloud{OpenStackMock: cloud})
+	assert.NoError(t, err)
+
+	// The volume is pinned to the cell of the selected node
+	res, err := cs.CreateVolume(FakeCtx, &csi.CreateVolumeRequest{
+		Name: FakeVolName,
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER}},
+		},
+		AccessibilityRequirements: &csi.TopologyRequirement{
+			Requisite: []*csi.Topology{
+				{Segments: map[string]string{topologyKey: FakeAvailability, cellTopologyKey: "cell1"}},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []*csi.Topology{
+		{Segments: map[string]string{topologyKey: FakeAvailability, cellTopologyKey: "cell1"}},
+	}, res.GetVolume().GetAccessibleTopology())
+	cloud.AssertExpectations(t)
+}
+
+func TestControllerPublishVolumeCell(t *testing.T) {
+	req := &csi.ControllerPublishVolumeRequest{
+		VolumeId: FakeVolID,
+		NodeId:   FakeNodeID,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+	}
+	d := NewDriver(&DriverOpts{Endpoint: FakeEndpoint, ClusterID: FakeCluster})
+
+	// A volume can't be attached to a server of another cell
+	cloud := new(openstack.OpenStackMock)
+	cs, err := NewControllerServer(d, fakeCellCloud{
+		OpenStackMock: cloud,
+		vol:           volumes.Volume{Metadata: map[string]string{cinderCSICellKey: "cell1"}},
+		serverCell:    "cell2",
+	})
+	assert.NoError(t, err)
+	_, err = cs.ControllerPublishVolume(FakeCtx, req)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	cloud.AssertNotCalled(t, "AttachVolume", FakeNodeID, FakeVolID, "")
+
+	// The volumes of the same cell, and the volumes created without a cell, are attached
+	for _, volMetadata := range []map[string]string{{cinderCSICellKey: "cell2"}, nil} {
+		cloud := new(openstack.OpenStackMock)
+		cloud.On("AttachVolume", FakeNodeID, FakeVolID, "").Return(FakeVolID, nil).Once()
+		cloud.On("WaitDiskAttached", FakeNodeID, FakeVolID).Return(nil)
+		cloud.On("GetAttachmentDiskPath", FakeNodeID, FakeVolID).Return(FakeDevicePath, nil)
+		cs, err := NewControllerServer(d, fakeCellCloud{
+			OpenStackMock: cloud,
+			vol:           volumes.Volume{Metadata: volMetadata},
+			serverCell:    "cell2",
+		})
+		assert.NoError(t, err)
+		_, err = cs.ControllerPublishVolume(FakeCtx, req)
+		assert.NoError(t, err)
+		cloud.AssertExpectations(t)
+	}
+}
@@ -0,0 +1,478 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+	"k8s.io/klog/v2"
+
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+// The csi-addons (https://github.com/csi-addons/spec) services called by the
+// csi-addons sidecar for the ReclaimSpaceJobs and ReclaimSpaceCronJobs. Their
+// generated bindings aren't a dependency of the plugin: the few messages used
+// are encoded and decoded with protowire by csiAddonsCodec, the fields not
+// used being skipped.
+
+// csi-addons Capability.Service.Type
+const (
+	csiAddonsControllerService = 1
+	csiAddonsNodeService       = 2
+)
+
+// csi-addons Capability.ReclaimSpace.Type
+const csiAddonsReclaimSpaceOnline = 2
+
+type csiAddonsMessage interface {
+	marshal() []byte
+	unmarshal(b []byte) error
+}
+
+// csiAddonsCodec is the codec of the csi-addons server, it replaces the proto
+// codec for its messages.
+type csiAddonsCodec struct{}
+
+func (csiAddonsCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(csiAddonsMessage)
+	if !ok {
+		return nil, fmt.Errorf("unexpected csi-addons message type %T", v)
+	}
+	return m.marshal(), nil
+}
+
+func (csiAddonsCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(csiAddonsMessage)
+	if !ok {
+		return fmt.Errorf("unexpected csi-addons message type %T", v)
+	}
+	return m.unmarshal(data)
+}
+
+func (csiAddonsCodec) Name() string {
+	return "proto"
+}
+
+// consumeFields calls field with the number, the type and the encoded value of
+// each field of the message b.
+func consumeFields(b []byte, field func(num protowire.Number, typ protowire.Type, v []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		if err := field(num, typ, b[:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// consumeString returns the value of a string field, "" if it isn't one.
+func consumeString(typ protowire.Type, v []byte) string {
+	if typ != protowire.BytesType {
+		return ""
+	}
+	s, _ := protowire.ConsumeString(v)
+	return s
+}
+
+// csiAddonsEmpty is the request of the csi-addons identity RPCs and the
+// response of the reclaim space RPCs, whose pre_usage and post_usage are
+// optional.
+type csiAddonsEmpty struct{}
+
+func (*csiAddonsEmpty) marshal() []byte {
+	return nil
+}
+
+func (*csiAddonsEmpty) unmarshal(b []byte) error {
+	return consumeFields(b, func(protowire.Number, protowire.Type, []byte) error { return nil })
+}
+
+// getIdentityResponse is the csi-addons identity GetIdentityResponse.
+type getIdentityResponse struct {
+	name          string
+	vendorVersion string
+}
+
+func (r *getIdentityResponse) marshal() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, r.name)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	return protowire.AppendString(b, r.vendorVersion)
+}
+
+func (r *getIdentityResponse) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			r.name = consumeString(typ, v)
+		case 2:
+			r.vendorVersion = consumeString(typ, v)
+		}
+		return nil
+	})
+}
+
+// csiAddonsCapability is either a service, as the field 1 of the csi-addons
+// Capability, or a reclaim space capability, as its field 2.
+type csiAddonsCapability struct {
+	service      int32
+	reclaimSpace int32
+}
+
+// getCapabilitiesResponse is the csi-addons identity GetCapabilitiesResponse.
+type getCapabilitiesResponse struct {
+	capabilities []csiAddonsCapability
+}
+
+func (r *getCapabilitiesResponse) marshal() []byte {
+	var b []byte
+	for _, c := range r.capabilities {
+		num, typ := protowire.Number(1), c.service
+		if c.reclaimSpace != 0 {
+			num, typ = 2, c.reclaimSpace
+		}
+		var inner []byte
+		inner = protowire.AppendTag(inner, 1, protowire.VarintType)
+		inner = protowire.AppendVarint(inner, uint64(typ))
+		var capability []byte
+		capability = protowire.AppendTag(capability, num, protowire.BytesType)
+		capability = protowire.AppendBytes(capability, inner)
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, capability)
+	}
+	return b
+}
+
+func (r *getCapabilitiesResponse) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		capability, _ := protowire.ConsumeBytes(v)
+		var c csiAddonsCapability
+		err := consumeFields(capability, func(num protowire.Number, typ protowire.Type, v []byte) error {
+			if typ != protowire.BytesType {
+				return nil
+			}
+			inner, _ := protowire.ConsumeBytes(v)
+			return consumeFields(inner, func(n protowire.Number, t protowire.Type, v []byte) error {
+				if n != 1 || t != protowire.VarintType {
+					return nil
+				}
+				value, _ := protowire.ConsumeVarint(v)
+				switch num {
+				case 1:
+					c.service = int32(value)
+				case 2:
+					c.reclaimSpace = int32(value)
+				}
+				return nil
+			})
+		})
+		r.capabilities = append(r.capabilities, c)
+		return err
+	})
+}
+
+// probeResponse is the csi-addons identity ProbeResponse, whose ready field
+// is a google.protobuf.BoolValue.
+type probeResponse struct {
+	ready bool
+}
+
+func (r *probeResponse) marshal() []byte {
+	var ready []byte
+	ready = protowire.AppendTag(ready, 1, protowire.VarintType)
+	ready = protowire.AppendVarint(ready, protowire.EncodeBool(r.ready))
+
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	return protowire.AppendBytes(b, ready)
+}
+
+func (r *probeResponse) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		ready, _ := protowire.ConsumeBytes(v)
+		return consumeFields(ready, func(num protowire.Number, typ protowire.Type, v []byte) error {
+			if num == 1 && typ == protowire.VarintType {
+				value, _ := protowire.ConsumeVarint(v)
+				r.ready = protowire.DecodeBool(value)
+			}
+			return nil
+		})
+	})
+}
+
+// reclaimSpaceRequest is either the csi-addons ControllerReclaimSpaceRequest,
+// of which only the volume_id is used, or the NodeReclaimSpaceRequest. The
+// secrets are never decoded.
+type reclaimSpaceRequest struct {
+	volumeID          string
+	volumePath        string
+	stagingTargetPath string
+	volumeCapability  *csi.VolumeCapability
+}
+
+func (r *reclaimSpaceRequest) marshal() []byte {
+	var b []byte
+	for _, f := range []struct {
+		num   protowire.Number
+		value string
+	}{{1, r.volumeID}, {2, r.volumePath}, {3, r.stagingTargetPath}} {
+		if f.value != "" {
+			b = protowire.AppendTag(b, f.num, protowire.BytesType)
+			b = protowire.AppendString(b, f.value)
+		}
+	}
+	if r.volumeCapability != nil {
+		capability, _ := proto.Marshal(protoadapt.MessageV2Of(r.volumeCapability))
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendBytes(b, capability)
+	}
+	return b
+}
+
+func (r *reclaimSpaceRequest) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			r.volumeID = consumeString(typ, v)
+		case 2:
+			r.volumePath = consumeString(typ, v)
+		case 3:
+			r.stagingTargetPath = consumeString(typ, v)
+		case 4:
+			if typ != protowire.BytesType {
+				return nil
+			}
+			capability, _ := protowire.ConsumeBytes(v)
+			r.volumeCapability = &csi.VolumeCapability{}
+			return proto.Unmarshal(capability, protoadapt.MessageV2Of(r.volumeCapability))
+		}
+		return nil
+	})
+}
+
+// csiAddonsServer serves the csi-addons identity service and the reclaim
+// space services of the services provided by the driver.
+type csiAddonsServer struct {
+	Driver *Driver
+}
+
+func (s *csiAddonsServer) GetIdentity(ctx context.Context, req *csiAddonsEmpty) (*getIdentityResponse, error) {
+	return &getIdentityResponse{name: s.Driver.name, vendorVersion: s.Driver.fqVersion}, nil
+}
+
+func (s *csiAddonsServer) GetCapabilities(ctx context.Context, req *csiAddonsEmpty) (*getCapabilitiesResponse, error) {
+	resp := &getCapabilitiesResponse{}
+	if s.Driver.cs != nil {
+		// OFFLINE isn't advertised: Cinder has no API to reclaim the space of a detached volume.
+		resp.capabilities = append(resp.capabilities, csiAddonsCapability{service: csiAddonsControllerService})
+	}
+	if s.Driver.ns != nil {
+		resp.capabilities = append(resp.capabilities,
+			csiAddonsCapability{service: csiAddonsNodeService},
+			csiAddonsCapability{reclaimSpace: csiAddonsReclaimSpaceOnline})
+	}
+	return resp, nil
+}
+
+func (s *csiAddonsServer) Probe(ctx context.Context, req *csiAddonsEmpty) (*probeResponse, error) {
+	return &probeResponse{ready: true}, nil
+}
+
+// ControllerReclaimSpace only checks the volume: Cinder has no API to reclaim
+// the space of a volume, it is reclaimed by NodeReclaimSpace while the volume
+// is staged.
+func (s *csiAddonsServer) ControllerReclaimSpace(ctx context.Context, req *reclaimSpaceRequest) (*csiAddonsEmpty, error) {
+	if req.volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "[ControllerReclaimSpace] Volume ID must be provided")
+	}
+
+	if _, err := s.Driver.cs.Cloud.GetVolume(req.volumeID); err != nil {
+		if cpoerrors.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "[ControllerReclaimSpace] Volume %s not found", req.volumeID)
+		}
+		return nil, status.Errorf(codes.Internal, "[ControllerReclaimSpace] get volume failed with error %v", err)
+	}
+
+	return &csiAddonsEmpty{}, nil
+}
+
+// NodeReclaimSpace runs fstrim on the filesystem of the volume. Block volumes
+// are refused: blkdiscard discards the whole device, not only the blocks no
+// longer used by the application, and so would destroy its data.
+func (s *csiAddonsServer) NodeReclaimSpace(ctx context.Context, req *reclaimSpaceRequest) (*csiAddonsEmpty, error) {
+	if req.volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "[NodeReclaimSpace] Volume ID must be provided")
+	}
+	path := req.stagingTargetPath
+	if path == "" {
+		path = req.volumePath
+	}
+	if path == "" {
+		return nil, status.Error(codes.InvalidArgument, "[NodeReclaimSpace] Volume path or staging target path must be provided")
+	}
+	if req.volumeCapability.GetBlock() != nil {
+		return nil, status.Errorf(codes.Unimplemented, "[NodeReclaimSpace] Reclaiming the space of block volume %s is not supported, blkdiscard would discard all its data", req.volumeID)
+	}
+
+	ns := s.Driver.ns
+	if !ns.volumeLocks.TryAcquire(req.volumeID) {
+		return nil, status.Errorf(codes.Aborted, "An operation with the given volume %s already exists", req.volumeID)
+	}
+	defer ns.volumeLocks.Release(req.volumeID)
+
+	if notMnt, err := ns.Mount.Mounter().IsLikelyNotMountPoint(path); err != nil || notMnt {
+		return nil, status.Errorf(codes.NotFound, "[NodeReclaimSpace] Volume %s is not mounted at %s", req.volumeID, path)
+	}
+
+	klog.V(4).Infof("Reclaiming the space of volume %s mounted at %s", req.volumeID, path)
+	out, err := ns.Mount.Mounter().Exec.Command("fstrim", path).CombinedOutput()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "[NodeReclaimSpace] fstrim %s failed: %v, output: %s", path, err, string(out))
+	}
+
+	return &csiAddonsEmpty{}, nil
+}
+
+// csiAddonsMethod returns the description of the RPC method of a csi-addons
+// service, whose request is decoded into the message returned by newRequest.
+func csiAddonsMethod(service, method string, newRequest func() csiAddonsMessage, call func(s *csiAddonsServer, ctx context.Context, req csiAddonsMessage) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := newRequest()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(*csiAddonsServer), ctx, req.(csiAddonsMessage))
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + service + "/" + method}
+			return interceptor(ctx, req, info, handler)
+		},
+	}
+}
+
+func newCSIAddonsEmpty() csiAddonsMessage      { return &csiAddonsEmpty{} }
+func newReclaimSpaceRequest() csiAddonsMessage { return &reclaimSpaceRequest{} }
+
+var csiAddonsIdentityDesc = grpc.ServiceDesc{
+	ServiceName: "identity.Identity",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		csiAddonsMethod("identity.Identity", "GetIdentity", newCSIAddonsEmpty, func(s *csiAddonsServer, ctx context.Context, req csiAddonsMessage) (interface{}, error) {
+			return s.GetIdentity(ctx, req.(*csiAddonsEmpty))
+		}),
+		csiAddonsMethod("identity.Identity", "GetCapabilities", newCSIAddonsEmpty, func(s *csiAddonsServer, ctx context.Context, req csiAddonsMessage) (interface{}, error) {
+			return s.GetCapabilities(ctx, req.(*csiAddonsEmpty))
+		}),
+		csiAddonsMethod("identity.Identity", "Probe", newCSIAddonsEmpty, func(s *csiAddonsServer, ctx context.Context, req csiAddonsMessage) (interface{}, error) {
+			return s.Probe(ctx, req.(*csiAddonsEmpty))
+		}),
+	},
+}
+
+var csiAddonsReclaimSpaceControllerDesc = grpc.ServiceDesc{
+	ServiceName: "reclaimspace.ReclaimSpaceController",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		csiAddonsMethod("reclaimspace.ReclaimSpaceController", "ControllerReclaimSpace", newReclaimSpaceRequest, func(s *csiAddonsServer, ctx context.Context, req csiAddonsMessage) (interface{}, error) {
+			return s.ControllerReclaimSpace(ctx, req.(*reclaimSpaceRequest))
+		}),
+	},
+}
+
+var csiAddonsReclaimSpaceNodeDesc = grpc.ServiceDesc{
+	ServiceName: "reclaimspace.ReclaimSpaceNode",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		csiAddonsMethod("reclaimspace.ReclaimSpaceNode", "NodeReclaimSpace", newReclaimSpaceRequest, func(s *csiAddonsServer, ctx context.Context, req csiAddonsMessage) (interface{}, error) {
+			return s.NodeReclaimSpace(ctx, req.(*reclaimSpaceRequest))
+		}),
+	},
+}
+
+// newCSIAddonsGRPCServer returns the gRPC server of the csi-addons services
+// of the services provided by the driver.
+func newCSIAddonsGRPCServer(d *Driver) *grpc.Server {
+	server := grpc.NewServer(grpc.ForceServerCodec(csiAddonsCodec{}), grpc.UnaryInterceptor(logGRPC))
+	s := &csiAddonsServer{Driver: d}
+	server.RegisterService(&csiAddonsIdentityDesc, s)
+	if d.cs != nil {
+		server.RegisterService(&csiAddonsReclaimSpaceControllerDesc, s)
+	}
+	if d.ns != nil {
+		server.RegisterService(&csiAddonsReclaimSpaceNodeDesc, s)
+	}
+	return server
+}
+
+// serveCSIAddons serves the csi-addons services at the endpoint until the
+// server fails.
+func serveCSIAddons(endpoint string, d *Driver) {
+	proto, addr, err := ParseEndpoint(endpoint)
+	if err != nil {
+		klog.Fatal(err.Error())
+	}
+
+	if proto == "unix" {
+		addr = "/" + addr
+		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
+			klog.Fatalf("Failed to remove %s, error: %v", addr, err)
+		}
+	}
+
+	listener, err := net.Listen(proto, addr)
+	if err != nil {
+		klog.Fatalf("Failed to listen: %v", err)
+	}
+
+	klog.Infof("Listening for csi-addons connections on address: %#v", listener.Addr())
+	if err := newCSIAddonsGRPCServer(d).Serve(listener); err != nil {
+		klog.Errorf("csi-addons server stopped with: %v", err)
+	}
+}
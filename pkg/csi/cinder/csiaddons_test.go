@@ -0,0 +1,185 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	"k8s.io/cloud-provider-openstack/pkg/util/mount"
+	mountutil "k8s.io/mount-utils"
+)
+
+// dialCSIAddons serves the csi-addons services of d in memory and returns a
+// client connection to them
+func dialCSIAddons(t *testing.T, d *Driver) *grpc.ClientConn {
+	listener := bufconn.Listen(1024 * 1024)
+	server := newCSIAddonsGRPCServer(d)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(csiAddonsCodec{})))
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestCSIAddonsIdentity(t *testing.T) {
+	d := NewDriver(&DriverOpts{Endpoint: FakeEndpoint, ClusterID: FakeCluster})
+	d.cs = &controllerServer{Driver: d}
+	d.ns = &nodeServer{Driver: d}
+	conn := dialCSIAddons(t, d)
+
+	identity := &getIdentityResponse{}
+	assert.NoError(t, conn.Invoke(FakeCtx, "/identity.Identity/GetIdentity", &csiAddonsEmpty{}, identity))
+	assert.Equal(t, &getIdentityResponse{name: driverName, vendorVersion: d.fqVersion}, identity)
+
+	capabilities := &getCapabilitiesResponse{}
+	assert.NoError(t, conn.Invoke(FakeCtx, "/identity.Identity/GetCapabilities", &csiAddonsEmpty{}, capabilities))
+	assert.Equal(t, []csiAddonsCapability{{service: csiAddonsControllerService}, {service: csiAddonsNodeService}, {reclaimSpace: csiAddonsReclaimSpaceOnline}}, capabilities.capabilities)
+
+	probe := &probeResponse{}
+	assert.NoError(t, conn.Invoke(FakeCtx, "/identity.Identity/Probe", &csiAddonsEmpty{}, probe))
+	assert.True(t, probe.ready)
+
+	// The node plugin doesn't serve the controller service
+	d.cs = nil
+	conn = dialCSIAddons(t, d)
+	capabilities = &getCapabilitiesResponse{}
+	assert.NoError(t, conn.Invoke(FakeCtx, "/identity.Identity/GetCapabilities", &csiAddonsEmpty{}, capabilities))
+	assert.Equal(t, []csiAddonsCapability{{service: csiAddonsNodeService}, {reclaimSpace: csiAddonsReclaimSpaceOnline}}, capabilities.capabilities)
+	err := conn.Invoke(FakeCtx, "/reclaimspace.ReclaimSpaceController/ControllerReclaimSpace", &reclaimSpaceRequest{volumeID: FakeVolID}, &csiAddonsEmpty{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+// fakeVolumesCloud only knows the volumes in volumes
+type fakeVolumesCloud struct {
+	*openstack.OpenStackMock
+
+	volumes map[string]bool
+}
+
+func (c fakeVolumesCloud) GetVolume(volumeID string) (*volumes.Volume, error) {
+	if !c.volumes[volumeID] {
+		return nil, gophercloud.ErrDefault404{}
+	}
+	return &volumes.Volume{ID: volumeID}, nil
+}
+
+func TestCSIAddonsControllerReclaimSpace(t *testing.T) {
+	d := NewDriver(&DriverOpts{Endpoint: FakeEndpoint, ClusterID: FakeCluster})
+	d.cs = &controllerServer{Driver: d, Cloud: fakeVolumesCloud{OpenStackMock: new(openstack.OpenStackMock), volumes: map[string]bool{FakeVolID: true}}}
+	conn := dialCSIAddons(t, d)
+
+	assert.NoError(t, conn.Invoke(FakeCtx, "/reclaimspace.ReclaimSpaceController/ControllerReclaimSpace", &reclaimSpaceRequest{volumeID: FakeVolID}, &csiAddonsEmpty{}))
+
+	err := conn.Invoke(FakeCtx, "/reclaimspace.ReclaimSpaceController/ControllerReclaimSpace", &reclaimSpaceRequest{volumeID: "missing"}, &csiAddonsEmpty{})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	err = conn.Invoke(FakeCtx, "/reclaimspace.ReclaimSpaceController/ControllerReclaimSpace", &reclaimSpaceRequest{}, &csiAddonsEmpty{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestCSIAddonsNodeReclaimSpace(t *testing.T) {
+	staged := t.TempDir()
+	notStaged := t.TempDir()
+	mountCap := &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}}}
+	blockCap := &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}}
+
+	tests := []struct {
+		name     string
+		req      *reclaimSpaceRequest
+		code     codes.Code
+		commands []fakeCommand
+	}{
+		{
+			name:     "filesystem",
+			req:      &reclaimSpaceRequest{volumeID: FakeVolID, volumePath: filepath.Join(staged, "pod"), stagingTargetPath: staged, volumeCapability: mountCap},
+			code:     codes.OK,
+			commands: []fakeCommand{{cmd: []string{"fstrim", staged}}},
+		},
+		{
+			name: "block",
+			req:  &reclaimSpaceRequest{volumeID: FakeVolID, volumePath: filepath.Join(staged, "pod"), stagingTargetPath: staged, volumeCapability: blockCap},
+			code: codes.Unimplemented,
+		},
+		{
+			name: "not staged",
+			req:  &reclaimSpaceRequest{volumeID: FakeVolID, stagingTargetPath: notStaged, volumeCapability: mountCap},
+			code: codes.NotFound,
+		},
+		{
+			name: "no path",
+			req:  &reclaimSpaceRequest{volumeID: FakeVolID, volumeCapability: mountCap},
+			code: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mounter := mount.NewFakeMounter()
+			mounter.MountPoints = []mountutil.MountPoint{{Device: "/dev/vdb", Path: staged, Type: "ext4"}}
+			var cmds [][]string
+			m := &sharedMountMock{
+				MountMock: new(mount.MountMock),
+				mounter:   &mountutil.SafeFormatAndMount{Interface: mounter, Exec: newFakeExec(test.commands, &cmds)},
+			}
+			d := NewDriver(&DriverOpts{Endpoint: FakeEndpoint, ClusterID: FakeCluster})
+			d.ns = &nodeServer{Driver: d, Mount: m, volumeLocks: newVolumeLocks()}
+			conn := dialCSIAddons(t, d)
+
+			err := conn.Invoke(FakeCtx, "/reclaimspace.ReclaimSpaceNode/NodeReclaimSpace", test.req, &csiAddonsEmpty{})
+			assert.Equal(t, test.code, status.Code(err))
+			assert.Equal(t, expectedCommands(test.commands), cmds)
+		})
+	}
+}
+
+func TestReclaimSpaceRequestUnmarshal(t *testing.T) {
+	// A NodeReclaimSpaceRequest with secrets, which are skipped
+	var secret []byte
+	secret = protowire.AppendTag(secret, 1, protowire.BytesType)
+	secret = protowire.AppendString(secret, "password")
+	secret = protowire.AppendTag(secret, 2, protowire.BytesType)
+	secret = protowire.AppendString(secret, "secret")
+	b := (&reclaimSpaceRequest{volumeID: FakeVolID, stagingTargetPath: "/staging"}).marshal()
+	b = protowire.AppendTag(b, 5, protowire.BytesType)
+	b = protowire.AppendBytes(b, secret)
+
+	req := &reclaimSpaceRequest{}
+	assert.NoError(t, req.unmarshal(b))
+	assert.Equal(t, &reclaimSpaceRequest{volumeID: FakeVolID, stagingTargetPath: "/staging"}, req)
+
+	assert.Error(t, req.unmarshal([]byte{0x0a, 0x10}))
+}
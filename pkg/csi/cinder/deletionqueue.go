@@ -0,0 +1,192 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+const (
+	// cinderCSIDeletionRequestedKey is the volume metadata key marking a volume whose deletion was requested but
+	// not completed yet, with the value "true" so that the marked volumes can be listed with a metadata filter
+	cinderCSIDeletionRequestedKey = "cinder.csi.openstack.org/deletion-requested"
+
+	defaultDeletionQueueRate   = 10
+	defaultDeletionSweepPeriod = 10 * time.Minute
+
+	deletionMaxRetries     = 5
+	deletionRetryBaseDelay = 5 * time.Second
+	deletionRetryMaxDelay  = 5 * time.Minute
+)
+
+// deletionQueue deletes volumes in the background, at a bounded rate and with retries, so that deleting many
+// volumes at once doesn't hit the rate limits of Cinder. Volumes are marked with cinderCSIDeletionRequestedKey
+// before being queued, the marked volumes of the cluster are periodically queued again in case their deletion
+// failed for good or the controller restarted.
+type deletionQueue struct {
+	cloud       openstack.IOpenStack
+	cluster     string
+	queue       workqueue.RateLimitingInterface
+	limiter     *rate.Limiter
+	workers     int
+	sweepPeriod time.Duration
+}
+
+func newDeletionQueue(cloud openstack.IOpenStack, cluster string, opts openstack.BlockStorageOpts) *deletionQueue {
+	qps := opts.DeletionQueueRate
+	if qps <= 0 {
+		qps = defaultDeletionQueueRate
+	}
+
+	sweepPeriod := opts.DeletionSweepPeriod.Duration
+	if sweepPeriod <= 0 {
+		sweepPeriod = defaultDeletionSweepPeriod
+	}
+
+	return &deletionQueue{
+		cloud:       cloud,
+		cluster:     cluster,
+		queue:       workqueue.NewNamedRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(deletionRetryBaseDelay, deletionRetryMaxDelay), "cinder-csi-deletion"),
+		limiter:     rate.NewLimiter(rate.Limit(qps), qps),
+		workers:     opts.DeletionQueueWorkers,
+		sweepPeriod: sweepPeriod,
+	}
+}
+
+// Run starts the workers and the orphan sweeper.
+func (q *deletionQueue) Run(stopCh <-chan struct{}) {
+	klog.Infof("Deleting volumes in the background with %d workers", q.workers)
+
+	for i := 0; i < q.workers; i++ {
+		go wait.Until(q.runWorker, time.Second, stopCh)
+	}
+	go wait.Until(q.sweep, q.sweepPeriod, stopCh)
+
+	go func() {
+		<-stopCh
+		q.queue.ShutDown()
+	}()
+}
+
+// deletableVolumeStatuses are the statuses of the volumes Cinder accepts to delete
+var deletableVolumeStatuses = map[string]bool{
+	"available":       true,
+	"error":           true,
+	"error_restoring": true,
+	"error_extending": true,
+	"error_managing":  true,
+}
+
+// Enqueue marks the volume for deletion and queues it. The volumes Cinder would refuse to delete, because they
+// are attached, busy or have snapshots, are rejected with FailedPrecondition so that the CO retries the deletion.
+func (q *deletionQueue) Enqueue(volumeID string) error {
+	vol, err := q.cloud.GetVolume(volumeID)
+	if err != nil {
+		return err
+	}
+
+	if !deletableVolumeStatuses[vol.Status] || len(vol.Attachments) > 0 {
+		return status.Errorf(codes.FailedPrecondition, "volume %s can't be deleted in status %s", volumeID, vol.Status)
+	}
+
+	snaps, _, err := q.cloud.ListSnapshots(map[string]string{"VolumeID": volumeID})
+	if err != nil {
+		return err
+	}
+	if len(snaps) > 0 {
+		return status.Errorf(codes.FailedPrecondition, "volume %s can't be deleted, it has snapshots", volumeID)
+	}
+
+	md := map[string]string{cinderCSIDeletionRequestedKey: "true"}
+	if err := q.cloud.UpdateVolumeMetadata(volumeID, md); err != nil {
+		return err
+	}
+
+	q.queue.Add(volumeID)
+	return nil
+}
+
+func (q *deletionQueue) runWorker() {
+	for q.processNextItem() {
+	}
+}
+
+func (q *deletionQueue) processNextItem() bool {
+	item, quit := q.queue.Get()
+	if quit {
+		return false
+	}
+	defer q.queue.Done(item)
+
+	volumeID := item.(string)
+	if err := q.delete(volumeID); err != nil {
+		if q.queue.NumRequeues(item) < deletionMaxRetries {
+			klog.Warningf("Failed to delete volume %s, will retry: %v", volumeID, err)
+			q.queue.AddRateLimited(item)
+			return true
+		}
+		klog.Errorf("Failed to delete volume %s, giving up until the next sweep: %v", volumeID, err)
+	}
+
+	q.queue.Forget(item)
+	return true
+}
+
+func (q *deletionQueue) delete(volumeID string) error {
+	if err := q.limiter.Wait(context.Background()); err != nil {
+		return err
+	}
+
+	if err := q.cloud.DeleteVolume(volumeID); err != nil {
+		if cpoerrors.IsNotFound(err) {
+			klog.V(3).Infof("Volume %s is already deleted.", volumeID)
+			return nil
+		}
+		return err
+	}
+
+	klog.V(4).Infof("Successfully deleted volume %s", volumeID)
+	return nil
+}
+
+// sweep queues the volumes of the cluster marked for deletion.
+func (q *deletionQueue) sweep() {
+	vols, err := q.cloud.GetVolumesByMetadata(map[string]string{cinderCSIDeletionRequestedKey: "true"})
+	if err != nil {
+		klog.Errorf("Failed to list volumes pending deletion: %v", err)
+		return
+	}
+
+	for _, vol := range vols {
+		if vol.Metadata[cinderCSIClusterIDKey] != q.cluster {
+			continue
+		}
+		klog.V(4).Infof("Queuing volume %s pending deletion", vol.ID)
+		q.queue.Add(vol.ID)
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/snapshots"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+)
+
+// fakeDeletionCloud returns vol from GetVolume, the mock doesn't allow to set it
+type fakeDeletionCloud struct {
+	*openstack.OpenStackMock
+
+	vol volumes.Volume
+}
+
+func (c fakeDeletionCloud) GetVolume(volumeID string) (*volumes.Volume, error) {
+	vol := c.vol
+	vol.ID = volumeID
+	return &vol, nil
+}
+
+func TestDeletionQueue(t *testing.T) {
+	assert := assert.New(t)
+
+	cloud := new(openstack.OpenStackMock)
+	q := newDeletionQueue(fakeDeletionCloud{OpenStackMock: cloud, vol: volumes.Volume{Status: "available"}}, FakeCluster, openstack.BlockStorageOpts{DeletionQueueWorkers: 1})
+	defer q.queue.ShutDown()
+
+	cloud.On("ListSnapshots", map[string]string{"VolumeID": "vol-1"}).Return([]snapshots.Snapshot{}, "", nil)
+	cloud.On("UpdateVolumeMetadata", "vol-1", map[string]string{cinderCSIDeletionRequestedKey: "true"}).Return(nil)
+	assert.NoError(q.Enqueue("vol-1"))
+	assert.Equal(1, q.queue.Len())
+
+	// A failed deletion is retried
+	cloud.On("DeleteVolume", "vol-1").Return(errors.New("rate limited")).Once()
+	assert.True(q.processNextItem())
+	assert.Equal(1, q.queue.NumRequeues("vol-1"))
+
+	// A successful deletion resets the retries
+	q.queue.Add("vol-1")
+	cloud.On("DeleteVolume", "vol-1").Return(nil).Once()
+	assert.True(q.processNextItem())
+	assert.Equal(0, q.queue.NumRequeues("vol-1"))
+
+	cloud.AssertExpectations(t)
+}
+
+func TestDeletionQueueRejectsUndeletableVolumes(t *testing.T) {
+	assert := assert.New(t)
+
+	cloud := new(openstack.OpenStackMock)
+	cloud.On("ListSnapshots", map[string]string{"VolumeID": "vol-snap"}).Return([]snapshots.Snapshot{{ID: "snap-1"}}, "", nil)
+
+	for _, vol := range []volumes.Volume{
+		{Status: "in-use", Attachments: []volumes.Attachment{{ServerID: "server-1"}}},
+		{Status: "detaching"},
+		{Status: "available", Attachments: []volumes.Attachment{{ServerID: "server-1"}}},
+	} {
+		q := newDeletionQueue(fakeDeletionCloud{OpenStackMock: cloud, vol: vol}, FakeCluster, openstack.BlockStorageOpts{DeletionQueueWorkers: 1})
+		err := q.Enqueue("vol-1")
+		assert.Equal(codes.FailedPrecondition, status.Code(err), "volume %+v", vol)
+		assert.Equal(0, q.queue.Len())
+		q.queue.ShutDown()
+	}
+
+	// Cinder doesn't delete the volumes with snapshots
+	q := newDeletionQueue(fakeDeletionCloud{OpenStackMock: cloud, vol: volumes.Volume{Status: "available"}}, FakeCluster, openstack.BlockStorageOpts{DeletionQueueWorkers: 1})
+	defer q.queue.ShutDown()
+	assert.Equal(codes.FailedPrecondition, status.Code(q.Enqueue("vol-snap")))
+	assert.Equal(0, q.queue.Len())
+
+	cloud.AssertNotCalled(t, "UpdateVolumeMetadata", mock.Anything, mock.Anything)
+}
+
+func TestDeletionQueueSweep(t *testing.T) {
+	assert := assert.New(t)
+
+	cloud := new(openstack.OpenStackMock)
+	q := newDeletionQueue(cloud, FakeCluster, openstack.BlockStorageOpts{DeletionQueueWorkers: 1})
+	defer q.queue.ShutDown()
+
+	marked := map[string]string{cinderCSIClusterIDKey: FakeCluster, cinderCSIDeletionRequestedKey: "true"}
+	otherCluster := map[string]string{cinderCSIClusterIDKey: "other", cinderCSIDeletionRequestedKey: "true"}
+
+	// Only the marked volumes are listed
+	cloud.On("GetVolumesByMetadata", map[string]string{cinderCSIDeletionRequestedKey: "true"}).Return([]volumes.Volume{
+		{ID: "vol-1", Metadata: marked},
+		{ID: "vol-3", Metadata: otherCluster},
+		{ID: "vol-4", Metadata: marked},
+	}, nil)
+
+	q.sweep()
+
+	assert.Equal(2, q.queue.Len())
+	for _, expected := range []string{"vol-1", "vol-4"} {
+		item, _ := q.queue.Get()
+		assert.Equal(expected, item)
+		q.queue.Done(item)
+	}
+}
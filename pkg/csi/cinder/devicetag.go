@@ -0,0 +1,59 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/util/metadata"
+	"k8s.io/cloud-provider-openstack/pkg/util/mount"
+)
+
+const (
+	// deviceTagKey is the volume context key of the Nova device tag the volume
+	// is attached with, the name of its PV
+	deviceTagKey = "deviceTag"
+
+	// maxDeviceTagLength is the length limit of the Nova device tags
+	maxDeviceTagLength = 60
+)
+
+// pvDeviceTag returns the device tag of the volume of the PV, empty if Nova
+// doesn't accept the PV name as device tag.
+func pvDeviceTag(pvName string) string {
+	if pvName == "" || len(pvName) > maxDeviceTagLength || strings.ContainsAny(pvName, ",/") {
+		return ""
+	}
+	return pvName
+}
+
+// getTaggedDevicePath returns the device of the volume attached with the
+// device tag, resolved from the instance metadata, which doesn't depend on the
+// by-id links created by udev. It falls back to the serial of the volume.
+func getTaggedDevicePath(volumeID, deviceTag string, m mount.IMount) (string, error) {
+	if deviceTag != "" {
+		devicePath, err := metadata.GetDevicePathByTag(deviceTag)
+		if err == nil {
+			return devicePath, nil
+		}
+		klog.Warningf("Couldn't get device path of volume %s from its device tag %q: %v", volumeID, deviceTag, err)
+	}
+
+	return getDevicePath(volumeID, m)
+}
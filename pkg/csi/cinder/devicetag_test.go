@@ -0,0 +1,64 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPVDeviceTag(t *testing.T) {
+	tests := []struct {
+		pvName string
+		tag    string
+	}{
+		{pvName: "pvc-7c0dc0c6-0b8d-4e4d-8b8c-8b1e5a4a2f3e", tag: "pvc-7c0dc0c6-0b8d-4e4d-8b8c-8b1e5a4a2f3e"},
+		{pvName: "", tag: ""},
+		{pvName: strings.Repeat("a", maxDeviceTagLength), tag: strings.Repeat("a", maxDeviceTagLength)},
+		{pvName: strings.Repeat("a", maxDeviceTagLength+1), tag: ""},
+		{pvName: "a,b", tag: ""},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.tag, pvDeviceTag(tt.pvName), tt.pvName)
+	}
+}
+
+func TestControllerPublishVolumeDeviceTag(t *testing.T) {
+	osmock.On("AttachVolume", FakeNodeID, FakeVolID, FakePVName).Return(FakeVolID, nil).Once()
+	osmock.On("WaitDiskAttached", FakeNodeID, FakeVolID).Return(nil)
+	osmock.On("GetAttachmentDiskPath", FakeNodeID, FakeVolID).Return(FakeDevicePath, nil)
+
+	_, err := fakeCs.ControllerPublishVolume(FakeCtx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: FakeVolID,
+		NodeId:   FakeNodeID,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+		},
+		VolumeContext: map[string]string{deviceTagKey: FakePVName},
+	})
+	if err != nil {
+		t.Fatalf("failed to ControllerPublishVolume: %v", err)
+	}
+
+	osmock.AssertCalled(t, "AttachVolume", FakeNodeID, FakeVolID, FakePVName)
+}
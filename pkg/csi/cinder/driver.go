@@ -22,6 +22,7 @@ import (
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
 	"k8s.io/cloud-provider-openstack/pkg/util/metadata"
 	"k8s.io/cloud-provider-openstack/pkg/util/mount"
@@ -30,13 +31,17 @@ import (
 )
 
 const (
-	driverName  = "cinder.csi.openstack.org"
-	topologyKey = "topology." + driverName + "/zone"
+	driverName      = "cinder.csi.openstack.org"
+	topologyKey     = "topology." + driverName + "/zone"
+	cellTopologyKey = "topology." + driverName + "/cell"
+	// instanceTopologyKey is only reported when instance-topology is enabled and is used for the local_to_instance
+	// scheduler hint, volumes are never restricted to it.
+	instanceTopologyKey = "topology." + driverName + "/instance"
 )
 
 var (
 	// CSI spec version
-	specVersion = "1.8.0"
+	specVersion = "1.9.0"
 
 	// Driver version
 	// Version history:
@@ -61,6 +66,9 @@ type Driver struct {
 	endpoint  string
 	cluster   string
 
+	// csiAddonsEndpoint is the endpoint of the csi-addons services, they are not served if empty
+	csiAddonsEndpoint string
+
 	ids *identityServer
 	cs  *controllerServer
 	ns  *nodeServer
@@ -68,11 +76,30 @@ type Driver struct {
 	vcap  []*csi.VolumeCapability_AccessMode
 	cscap []*csi.ControllerServiceCapability
 	nscap []*csi.NodeServiceCapability
+
+	// snapshotHooks is nil if the snapshot hooks are disabled
+	snapshotHooks *snapshotHooks
+
+	attachAheadOpts AttachAheadOpts
+	// volumeTransferOpts configures the volume transfers between projects
+	volumeTransferOpts VolumeTransferOpts
 }
 
 type DriverOpts struct {
 	ClusterID string
 	Endpoint  string
+
+	// CSIAddonsEndpoint is the endpoint of the csi-addons services, e.g. for the ReclaimSpaceJobs
+	CSIAddonsEndpoint string
+
+	SnapshotHooks SnapshotHooksOpts
+
+	// ModifyVolume advertises the MODIFY_VOLUME capability, i.e. the support of VolumeAttributesClass
+	ModifyVolume bool
+
+	AttachAhead AttachAheadOpts
+
+	VolumeTransfer VolumeTransferOpts
 }
 
 func NewDriver(o *DriverOpts) *Driver {
@@ -81,26 +108,41 @@ func NewDriver(o *DriverOpts) *Driver {
 	d.fqVersion = fmt.Sprintf("%s@%s", Version, version.Version)
 	d.endpoint = o.Endpoint
 	d.cluster = o.ClusterID
+	d.csiAddonsEndpoint = o.CSIAddonsEndpoint
+	if o.SnapshotHooks.Enabled {
+		d.snapshotHooks = newSnapshotHooks(o.SnapshotHooks)
+		klog.Infof("Snapshot hooks enabled, timeout %v", o.SnapshotHooks.Timeout)
+	}
+	d.attachAheadOpts = o.AttachAhead
+	d.volumeTransferOpts = o.VolumeTransfer
 
 	klog.Info("Driver: ", d.name)
 	klog.Info("Driver version: ", d.fqVersion)
 	klog.Info("CSI Spec version: ", specVersion)
 
-	d.AddControllerServiceCapabilities(
-		[]csi.ControllerServiceCapability_RPC_Type{
-			csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
-			csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
-			csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
-			csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
-			csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
-			csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
-			csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
-			csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
-			csi.ControllerServiceCapability_RPC_GET_VOLUME,
-		})
+	controllerCaps := []csi.ControllerServiceCapability_RPC_Type{
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+		csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+		csi.ControllerServiceCapability_RPC_GET_VOLUME,
+		csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+	}
+	if o.ModifyVolume {
+		controllerCaps = append(controllerCaps, csi.ControllerServiceCapability_RPC_MODIFY_VOLUME)
+	}
+	d.AddControllerServiceCapabilities(controllerCaps)
 	d.AddVolumeCapabilityAccessModes(
 		[]csi.VolumeCapability_AccessMode_Mode{
 			csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			// Multi-node access modes are restricted to multiattach volume types
+			csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+			csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+			csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
 		})
 
 	// ignoring error, because AddNodeServiceCapabilities is public
@@ -110,6 +152,7 @@ func NewDriver(o *DriverOpts) *Driver {
 			csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
 			csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
 			csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+			csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
 		})
 
 	d.ids = NewIdentityServer(d)
@@ -172,14 +215,33 @@ func (d *Driver) GetVolumeCapabilityAccessModes() []*csi.VolumeCapability_Access
 	return d.vcap
 }
 
-func (d *Driver) SetupControllerService(cloud openstack.IOpenStack) {
+func (d *Driver) SetupControllerService(cloud openstack.IOpenStack) error {
 	klog.Info("Providing controller service")
-	d.cs = NewControllerServer(d, cloud)
+	cs, err := NewControllerServer(d, cloud)
+	if err != nil {
+		return err
+	}
+	d.cs = cs
+	if d.cs.deletionQueue != nil {
+		d.cs.deletionQueue.Run(wait.NeverStop)
+	}
+	if d.cs.attachAhead != nil {
+		d.cs.attachAhead.Run(wait.NeverStop)
+	}
+	if d.cs.volumeTransfer != nil {
+		d.cs.volumeTransfer.Run(wait.NeverStop)
+	}
+
+	return nil
 }
 
 func (d *Driver) SetupNodeService(cloud openstack.IOpenStack, mount mount.IMount, metadata metadata.IMetadata) {
 	klog.Info("Providing node service")
 	d.ns = NewNodeServer(d, mount, metadata, cloud)
+	if interval := cloud.GetBlockStorageOpts().ReclaimSpaceInterval.Duration; interval > 0 {
+		// Jittered, not to trim the volumes of all the nodes of the backend at the same time
+		go wait.JitterUntil(func() { reclaimSpace(mount, d.name) }, interval, 0.5, true, wait.NeverStop)
+	}
 }
 
 func (d *Driver) Run() {
@@ -187,5 +249,9 @@ func (d *Driver) Run() {
 		klog.Fatal("No CSI services initialized")
 	}
 
+	if d.csiAddonsEndpoint != "" {
+		go serveCSIAddons(d.csiAddonsEndpoint, d)
+	}
+
 	RunServicesInitialized(d.endpoint, d.ids, d.cs, d.ns)
 }
@@ -0,0 +1,157 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	"k8s.io/klog/v2"
+)
+
+// Mutable parameters of a VolumeAttributesClass, applied when creating a volume
+// and by ControllerModifyVolume
+const (
+	mutableTypeKey     = "type"
+	mutableBootableKey = "bootable"
+	// mutableMetadataPrefix prefixes the volume metadata keys, e.g. metadata.tier
+	mutableMetadataPrefix = "metadata."
+
+	// reservedMetadataPrefix prefixes the volume metadata keys managed by the plugin
+	reservedMetadataPrefix = "cinder.csi.openstack.org/"
+)
+
+// volumeModification is the change of a volume requested by the mutable
+// parameters of a VolumeAttributesClass.
+type volumeModification struct {
+	volumeType string
+	bootable   *bool
+	metadata   map[string]string
+}
+
+// parseMutableParameters validates the mutable parameters, nil being returned
+// if there are none.
+func parseMutableParameters(params map[string]string) (*volumeModification, error) {
+	if len(params) == 0 {
+		return nil, nil
+	}
+
+	m := &volumeModification{}
+	for k, v := range params {
+		switch {
+		case k == mutableTypeKey:
+			if v == "" {
+				return nil, fmt.Errorf("empty %s parameter", mutableTypeKey)
+			}
+			m.volumeType = v
+		case k == mutableBootableKey:
+			bootable, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s parameter %q: %v", mutableBootableKey, v, err)
+			}
+			m.bootable = &bootable
+		case strings.HasPrefix(k, mutableMetadataPrefix):
+			key := strings.TrimPrefix(k, mutableMetadataPrefix)
+			if key == "" || strings.HasPrefix(key, reservedMetadataPrefix) {
+				return nil, fmt.Errorf("invalid metadata key %q in parameter %s", key, k)
+			}
+			if m.metadata == nil {
+				m.metadata = make(map[string]string)
+			}
+			m.metadata[key] = v
+		default:
+			return nil, fmt.Errorf("unsupported mutable parameter %s", k)
+		}
+	}
+
+	return m, nil
+}
+
+// modifyVolume applies the modification to the volume, skipping the changes
+// already applied so that it can be retried.
+func (cs *controllerServer) modifyVolume(vol *volumes.Volume, m *volumeModification) error {
+	if m == nil {
+		return nil
+	}
+
+	if m.volumeType != "" && m.volumeType != vol.VolumeType {
+		klog.V(2).Infof("Changing the type of volume %s from %s to %s", vol.ID, vol.VolumeType, m.volumeType)
+		if err := cs.Cloud.RetypeVolume(vol.ID, m.volumeType); err != nil {
+			return fmt.Errorf("failed to change the type of volume %s to %s: %v", vol.ID, m.volumeType, err)
+		}
+
+		// The volume may be migrated to another backend
+		targetStatus := []string{openstack.VolumeAvailableStatus, openstack.VolumeInUseStatus}
+		if err := cs.Cloud.WaitVolumeTargetStatus(vol.ID, targetStatus, openstack.WaitRetype); err != nil {
+			return fmt.Errorf("volume %s not in target state after retype operation: %v", vol.ID, err)
+		}
+
+		// A failed retype also brings the volume back to its previous status,
+		// with its previous type
+		retyped, err := cs.Cloud.GetVolume(vol.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get volume %s after retype operation: %v", vol.ID, err)
+		}
+		if retyped.VolumeType != m.volumeType {
+			return fmt.Errorf("failed to change the type of volume %s to %s: the type is still %s", vol.ID, m.volumeType, retyped.VolumeType)
+		}
+	}
+
+	if m.bootable != nil && vol.Bootable != strconv.FormatBool(*m.bootable) {
+		if err := cs.Cloud.SetVolumeBootable(vol.ID, *m.bootable); err != nil {
+			return fmt.Errorf("failed to set the bootable flag of volume %s: %v", vol.ID, err)
+		}
+	}
+
+	var md map[string]string
+	for k, v := range m.metadata {
+		if cur, ok := vol.Metadata[k]; !ok || cur != v {
+			if md == nil {
+				md = make(map[string]string)
+			}
+			md[k] = v
+		}
+	}
+	if md != nil {
+		if err := cs.Cloud.UpdateVolumeMetadata(vol.ID, md); err != nil {
+			return fmt.Errorf("failed to update the metadata of volume %s: %v", vol.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// setCreatedVolumeBootable sets the bootable flag requested when creating the
+// volume, once the volume is available.
+func (cs *controllerServer) setCreatedVolumeBootable(vol *volumes.Volume, m *volumeModification) error {
+	if m == nil || m.bootable == nil || vol.Bootable == strconv.FormatBool(*m.bootable) {
+		return nil
+	}
+
+	if err := cs.Cloud.WaitVolumeTargetStatus(vol.ID, []string{openstack.VolumeAvailableStatus}, openstack.WaitCreate); err != nil {
+		return fmt.Errorf("volume %s not available after creation: %v", vol.ID, err)
+	}
+
+	if err := cs.Cloud.SetVolumeBootable(vol.ID, *m.bootable); err != nil {
+		return fmt.Errorf("failed to set the bootable flag of volume %s: %v", vol.ID, err)
+	}
+
+	return nil
+}
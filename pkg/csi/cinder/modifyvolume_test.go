@@ -0,0 +1,186 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+)
+
+func TestParseMutableParameters(t *testing.T) {
+	bootable := true
+
+	tests := []struct {
+		name     string
+		params   map[string]string
+		expected *volumeModification
+		err      bool
+	}{
+		{
+			name: "no parameters",
+		},
+		{
+			name: "all parameters",
+			params: map[string]string{
+				"type":                  "ssd",
+				"bootable":              "true",
+				"metadata.tier":         "gold",
+				"metadata.backup/class": "daily",
+			},
+			expected: &volumeModification{
+				volumeType: "ssd",
+				bootable:   &bootable,
+				metadata:   map[string]string{"tier": "gold", "backup/class": "daily"},
+			},
+		},
+		{
+			name:   "invalid bootable flag",
+			params: map[string]string{"bootable": "yes please"},
+			err:    true,
+		},
+		{
+			name:   "empty type",
+			params: map[string]string{"type": ""},
+			err:    true,
+		},
+		{
+			name:   "reserved metadata key",
+			params: map[string]string{"metadata." + cinderCSIClusterIDKey: "other"},
+			err:    true,
+		},
+		{
+			name:   "unsupported parameter",
+			params: map[string]string{"availability": "nova"},
+			err:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := parseMutableParameters(tt.params)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, m)
+		})
+	}
+}
+
+// fakeRetypeCloud returns a volume whose type is changed by RetypeVolume
+// unless the retype fails.
+type fakeRetypeCloud struct {
+	*openstack.OpenStackMock
+
+	vol       volumes.Volume
+	retypeErr bool
+}
+
+func (c *fakeRetypeCloud) GetVolume(volumeID string) (*volumes.Volume, error) {
+	vol := c.vol
+	return &vol, nil
+}
+
+func (c *fakeRetypeCloud) RetypeVolume(volumeID string, volumeType string) error {
+	if err := c.OpenStackMock.RetypeVolume(volumeID, volumeType); err != nil {
+		return err
+	}
+	if !c.retypeErr {
+		c.vol.VolumeType = volumeType
+	}
+	return nil
+}
+
+func TestControllerModifyVolume(t *testing.T) {
+	osmock := new(openstack.OpenStackMock)
+	cloud := &fakeRetypeCloud{OpenStackMock: osmock, vol: volumes.Volume{ID: FakeVolID, VolumeType: "hdd"}}
+	cs := &controllerServer{Cloud: cloud}
+
+	vol := &cloud.vol
+	tState := []string{openstack.VolumeAvailableStatus, openstack.VolumeInUseStatus}
+
+	osmock.On("RetypeVolume", vol.ID, "ssd").Return(nil)
+	osmock.On("WaitVolumeTargetStatus", vol.ID, tState, openstack.WaitRetype).Return(nil)
+	osmock.On("SetVolumeBootable", vol.ID, true).Return(nil)
+	osmock.On("UpdateVolumeMetadata", vol.ID, map[string]string{"tier": "gold"}).Return(nil)
+
+	_, err := cs.ControllerModifyVolume(FakeCtx, &csi.ControllerModifyVolumeRequest{
+		VolumeId: FakeVolID,
+		MutableParameters: map[string]string{
+			"type":          "ssd",
+			"bootable":      "true",
+			"metadata.tier": "gold",
+		},
+	})
+	assert.NoError(t, err)
+	osmock.AssertExpectations(t)
+
+	_, err = cs.ControllerModifyVolume(FakeCtx, &csi.ControllerModifyVolumeRequest{
+		VolumeId:          FakeVolID,
+		MutableParameters: map[string]string{"iops": "1000"},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestModifyVolumeSkipsAppliedChanges(t *testing.T) {
+	osmock := new(openstack.OpenStackMock)
+	cs := &controllerServer{Cloud: osmock}
+
+	bootable := false
+	vol := &volumes.Volume{
+		ID:         FakeVolID,
+		VolumeType: "ssd",
+		Bootable:   "false",
+		Metadata:   map[string]string{"tier": "gold", "team": "storage"},
+	}
+
+	// Only the changed metadata key is updated
+	osmock.On("UpdateVolumeMetadata", FakeVolID, map[string]string{"team": "db"}).Return(nil)
+
+	err := cs.modifyVolume(vol, &volumeModification{
+		volumeType: "ssd",
+		bootable:   &bootable,
+		metadata:   map[string]string{"tier": "gold", "team": "db"},
+	})
+	assert.NoError(t, err)
+	osmock.AssertExpectations(t)
+}
+
+func TestModifyVolumeFailedRetype(t *testing.T) {
+	osmock := new(openstack.OpenStackMock)
+	cloud := &fakeRetypeCloud{OpenStackMock: osmock, vol: volumes.Volume{ID: FakeVolID, VolumeType: "hdd"}, retypeErr: true}
+	cs := &controllerServer{Cloud: cloud}
+
+	tState := []string{openstack.VolumeAvailableStatus, openstack.VolumeInUseStatus}
+	osmock.On("RetypeVolume", FakeVolID, "ssd").Return(nil)
+	osmock.On("WaitVolumeTargetStatus", FakeVolID, tState, openstack.WaitRetype).Return(nil)
+
+	// The metadata is not updated once the retype failed
+	err := cs.modifyVolume(&cloud.vol, &volumeModification{
+		volumeType: "ssd",
+		metadata:   map[string]string{"tier": "gold"},
+	})
+	assert.ErrorContains(t, err, "the type is still hdd")
+	osmock.AssertExpectations(t)
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/cloud-provider-openstack/pkg/util/mount"
+)
+
+// multiattachSpec is the extra spec of the Cinder volume types whose volumes
+// can be attached to several instances at the same time
+const multiattachSpec = "multiattach"
+
+// localFsTypes are the filesystems which must not be mounted read-write on
+// several nodes at the same time, they would be corrupted.
+var localFsTypes = map[string]bool{
+	"ext2":  true,
+	"ext3":  true,
+	"ext4":  true,
+	"xfs":   true,
+	"btrfs": true,
+}
+
+// isMultiNodeMode reports whether the access mode allows the volume to be
+// published on several nodes.
+func isMultiNodeMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	switch mode {
+	case csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:
+		return true
+	}
+	return false
+}
+
+// hasMultiNodeMode reports whether any of the capabilities has a multi-node
+// access mode.
+func hasMultiNodeMode(caps []*csi.VolumeCapability) bool {
+	for _, c := range caps {
+		if isMultiNodeMode(c.GetAccessMode().GetMode()) {
+			return true
+		}
+	}
+	return false
+}
+
+// isMultiattachType reports whether the volume type has the multiattach
+// extra spec, e.g. multiattach="<is> True".
+func isMultiattachType(extraSpecs map[string]string) bool {
+	v := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(extraSpecs[multiattachSpec]), "<is>"))
+	return strings.EqualFold(v, "true")
+}
+
+// validateMultiNodeCreate checks that a volume requested with multi-node
+// access modes can be shared by the nodes: its volume type must be
+// multiattach and its capabilities must not mount a local filesystem
+// read-write, nor cache it on the node.
+func validateMultiNodeCreate(cloud openstack.IOpenStack, volType string, caps []*csi.VolumeCapability, readCache bool) error {
+	if volType == "" {
+		return status.Error(codes.InvalidArgument, "[CreateVolume] multi-node access modes require the type parameter of a multiattach volume type")
+	}
+	if readCache {
+		return status.Error(codes.InvalidArgument, "[CreateVolume] readCache is not supported with multi-node access modes")
+	}
+	for _, c := range caps {
+		if c.GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY {
+			continue
+		}
+		if fsType := c.GetMount().GetFsType(); localFsTypes[fsType] {
+			return status.Errorf(codes.InvalidArgument, "[CreateVolume] filesystem %s cannot be mounted read-write on several nodes, use a clustered filesystem or a block volume", fsType)
+		}
+	}
+
+	vt, err := cloud.GetVolumeType(volType)
+	if err != nil {
+		if cpoerrors.IsNotFound(err) {
+			return status.Errorf(codes.InvalidArgument, "[CreateVolume] volume type %s not found", volType)
+		}
+		return status.Errorf(codes.Internal, "[CreateVolume] failed to get volume type %s: %v", volType, err)
+	}
+	if !isMultiattachType(vt.ExtraSpecs) {
+		return status.Errorf(codes.InvalidArgument, "[CreateVolume] volume type %s does not support multi-node access modes, it requires the %s=\"<is> True\" extra spec", volType, multiattachSpec)
+	}
+	return nil
+}
+
+// mountShared mounts the device of a volume published with a multi-node
+// access mode. Unlike FormatAndMount, it never runs fsck nor mkfs, because
+// the device may be mounted by other nodes at the same time: the volume must
+// be formatted beforehand, with a clustered filesystem if it's mounted
+// read-write.
+func mountShared(m mount.IMount, devicePath, stagingTarget string, volumeCapability *csi.VolumeCapability) error {
+	format, err := m.Mounter().GetDiskFormat(devicePath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get the format of device %s: %v", devicePath, err)
+	}
+	if format == "" {
+		return status.Errorf(codes.FailedPrecondition, "device %s is not formatted, volumes with multi-node access modes must be formatted before they're mounted", devicePath)
+	}
+	if fsType := volumeCapability.GetMount().GetFsType(); fsType != "" && fsType != format {
+		return status.Errorf(codes.FailedPrecondition, "device %s is formatted with %s instead of %s", devicePath, format, fsType)
+	}
+
+	options := collectMountOptions(format, volumeCapability.GetMount().GetMountFlags())
+	mode := volumeCapability.GetAccessMode().GetMode()
+	if mode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY {
+		options = append(options, "ro")
+	} else if localFsTypes[format] {
+		return status.Errorf(codes.FailedPrecondition, "filesystem %s of device %s cannot be mounted read-write on several nodes", format, devicePath)
+	}
+
+	klog.V(4).Infof("Mounting shared device %s with filesystem %s on %s", devicePath, format, stagingTarget)
+	if err := m.Mounter().Mount(devicePath, stagingTarget, format, options); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumetypes"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/cloud-provider-openstack/pkg/util/mount"
+	mountutil "k8s.io/mount-utils"
+)
+
+func multiNodeCap(mode csi.VolumeCapability_AccessMode_Mode, fsType string) *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{FsType: fsType},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+	}
+}
+
+func TestIsMultiattachType(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(isMultiattachType(map[string]string{"multiattach": "<is> True"}))
+	assert.True(isMultiattachType(map[string]string{"multiattach": "true"}))
+	assert.False(isMultiattachType(map[string]string{"multiattach": "<is> False"}))
+	assert.False(isMultiattachType(map[string]string{}))
+	assert.False(isMultiattachType(nil))
+}
+
+func TestHasMultiNodeMode(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(hasMultiNodeMode([]*csi.VolumeCapability{
+		multiNodeCap(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, ""),
+	}))
+	assert.True(hasMultiNodeMode([]*csi.VolumeCapability{
+		multiNodeCap(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, ""),
+		multiNodeCap(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY, ""),
+	}))
+}
+
+func TestValidateMultiNodeCreate(t *testing.T) {
+	cloud := new(openstack.OpenStackMock)
+	cloud.On("GetVolumeType", "multiattach").Return(&volumetypes.VolumeType{Name: "multiattach", ExtraSpecs: map[string]string{"multiattach": "<is> True"}}, nil)
+	cloud.On("GetVolumeType", "standard").Return(&volumetypes.VolumeType{Name: "standard"}, nil)
+	cloud.On("GetVolumeType", "missing").Return(nil, cpoerrors.ErrNotFound)
+
+	writer := []*csi.VolumeCapability{multiNodeCap(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, "")}
+	tests := []struct {
+		name      string
+		volType   string
+		caps      []*csi.VolumeCapability
+		readCache bool
+		code      codes.Code
+	}{
+		{name: "multiattach type", volType: "multiattach", caps: writer, code: codes.OK},
+		{name: "no type", caps: writer, code: codes.InvalidArgument},
+		{name: "type without multiattach", volType: "standard", caps: writer, code: codes.InvalidArgument},
+		{name: "missing type", volType: "missing", caps: writer, code: codes.InvalidArgument},
+		{name: "read cache", volType: "multiattach", caps: writer, readCache: true, code: codes.InvalidArgument},
+		{
+			name:    "local filesystem writer",
+			volType: "multiattach",
+			caps:    []*csi.VolumeCapability{multiNodeCap(csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER, "ext4")},
+			code:    codes.InvalidArgument,
+		},
+		{
+			name:    "local filesystem reader",
+			volType: "multiattach",
+			caps:    []*csi.VolumeCapability{multiNodeCap(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY, "ext4")},
+			code:    codes.OK,
+		},
+		{
+			name:    "clustered filesystem writer",
+			volType: "multiattach",
+			caps:    []*csi.VolumeCapability{multiNodeCap(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, "gfs2")},
+			code:    codes.OK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMultiNodeCreate(cloud, tt.volType, tt.caps, tt.readCache)
+			assert.Equal(t, tt.code, status.Code(err), "%v", err)
+		})
+	}
+}
+
+func TestControllerPublishVolumeNotMultiattach(t *testing.T) {
+	fakeReq := &csi.ControllerPublishVolumeRequest{
+		VolumeId:         FakeVolID,
+		NodeId:           FakeNodeID,
+		VolumeCapability: multiNodeCap(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, ""),
+	}
+
+	_, err := fakeCs.ControllerPublishVolume(FakeCtx, fakeReq)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+// sharedMountMock is a MountMock whose device is formatted with fsType
+type sharedMountMock struct {
+	*mount.MountMock
+	mounter *mountutil.SafeFormatAndMount
+}
+
+func newSharedMountMock(fsType string) *sharedMountMock {
+	var cmds [][]string
+	return &sharedMountMock{
+		MountMock: new(mount.MountMock),
+		mounter: &mountutil.SafeFormatAndMount{
+			Interface: mount.NewFakeMounter(),
+			Exec:      newFakeExec([]fakeCommand{{output: "TYPE=" + fsType + "\n"}}, &cmds),
+		},
+	}
+}
+
+func (m *sharedMountMock) Mounter() *mountutil.SafeFormatAndMount {
+	return m.mounter
+}
+
+func TestMountShared(t *testing.T) {
+	tests := []struct {
+		name   string
+		fsType string
+		cap    *csi.VolumeCapability
+		code   codes.Code
+		opts   []string
+	}{
+		{
+			name:   "clustered filesystem writer",
+			fsType: "gfs2",
+			cap:    multiNodeCap(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, ""),
+			code:   codes.OK,
+			opts:   []string{},
+		},
+		{
+			name:   "local filesystem writer",
+			fsType: "ext4",
+			cap:    multiNodeCap(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, ""),
+			code:   codes.FailedPrecondition,
+		},
+		{
+			name:   "local filesystem reader",
+			fsType: "xfs",
+			cap:    multiNodeCap(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY, ""),
+			code:   codes.OK,
+			opts:   []string{"nouuid", "ro"},
+		},
+		{
+			name:   "different filesystem",
+			fsType: "ext4",
+			cap:    multiNodeCap(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY, "xfs"),
+			code:   codes.FailedPrecondition,
+		},
+		{
+			name: "not formatted",
+			cap:  multiNodeCap(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, ""),
+			code: codes.FailedPrecondition,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newSharedMountMock(tt.fsType)
+			err := mountShared(m, FakeDevicePath, FakeStagingTargetPath, tt.cap)
+			assert.Equal(t, tt.code, status.Code(err), "%v", err)
+
+			mountPoints := m.mounter.Interface.(*mountutil.FakeMounter).MountPoints
+			if tt.code != codes.OK {
+				assert.Empty(t, mountPoints)
+				return
+			}
+			if assert.Len(t, mountPoints, 1) {
+				assert.Equal(t, tt.fsType, mountPoints[0].Type)
+				assert.Equal(t, tt.opts, mountPoints[0].Opts)
+			}
+		})
+	}
+}
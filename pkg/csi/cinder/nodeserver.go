@@ -22,6 +22,8 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
@@ -33,6 +35,7 @@ import (
 	utilpath "k8s.io/utils/path"
 
 	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
 	"k8s.io/cloud-provider-openstack/pkg/util/blockdevice"
 	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
 	"k8s.io/cloud-provider-openstack/pkg/util/metadata"
@@ -45,6 +48,19 @@ type nodeServer struct {
 	Mount    mount.IMount
 	Metadata metadata.IMetadata
 	Cloud    openstack.IOpenStack
+
+	// volumeLocks serializes stage/unstage operations per volume, while
+	// stagePool bounds how many of them run concurrently on the node.
+	volumeLocks *volumeLocks
+	stagePool   *workerPool
+
+	// startTime is used to only observe the create-to-stage latency of the
+	// volumes created while the plugin is running.
+	startTime time.Time
+
+	// bareMetal caches whether the node is a bare-metal node, see isBareMetal.
+	bareMetal    *bool
+	bareMetalMtx sync.Mutex
 }
 
 func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
@@ -150,7 +166,15 @@ func nodePublishEphemeral(req *csi.NodePublishVolumeRequest, ns *nodeServer) (*c
 		volumeType = ""
 	}
 
-	evol, err := ns.Cloud.CreateVolume(volName, size, volumeType, volAvailability, "", "", "", properties)
+	wipeProperties, err := parseWipeParameter(req.GetVolumeContext()["wipe"])
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	for k, v := range wipeProperties {
+		properties[k] = v
+	}
+
+	evol, err := ns.Cloud.CreateVolume(volName, size, volumeType, volAvailability, "", "", "", properties, nil)
 
 	if err != nil {
 		klog.V(3).Infof("Failed to Create Ephemeral Volume: %v", err)
@@ -160,7 +184,7 @@ func nodePublishEphemeral(req *csi.NodePublishVolumeRequest, ns *nodeServer) (*c
 	// Wait for volume status to be Available, before attaching
 	if evol.Status != openstack.VolumeAvailableStatus {
 		targetStatus := []string{openstack.VolumeAvailableStatus}
-		err := ns.Cloud.WaitVolumeTargetStatus(evol.ID, targetStatus)
+		err := ns.Cloud.WaitVolumeTargetStatus(evol.ID, targetStatus, openstack.WaitCreate)
 		if err != nil {
 			return nil, status.Error(codes.Internal, err.Error())
 		}
@@ -177,7 +201,7 @@ func nodePublishEphemeral(req *csi.NodePublishVolumeRequest, ns *nodeServer) (*c
 		return nil, status.Errorf(codes.Internal, msg, err)
 	}
 
-	_, err = ns.Cloud.AttachVolume(nodeID, evol.ID)
+	_, err = ns.Cloud.AttachVolume(nodeID, evol.ID, "")
 	if err != nil {
 		msg := "nodePublishEphemeral: attach volume %s failed with error: %v"
 		klog.V(3).Infof(msg, evol.ID, err)
@@ -229,10 +253,19 @@ func nodePublishVolumeForBlock(req *csi.NodePublishVolumeRequest, ns *nodeServer
 	m := ns.Mount
 
 	// Do not trust the path provided by cinder, get the real path on node
-	source, err := getDevicePath(volumeID, m)
+	var source string
+	var err error
+	if attachmentID := req.GetPublishContext()[attachmentIDKey]; attachmentID != "" {
+		source, err = ns.attachmentDevicePath(attachmentID)
+	} else {
+		source, err = getTaggedDevicePath(volumeID, req.GetVolumeContext()[deviceTagKey], m)
+	}
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "Unable to find Device path for volume: %v", err)
 	}
+	if cachePath := readCacheDevicePath(volumeID); cachePath != "" {
+		source = cachePath
+	}
 
 	exists, err := utilpath.Exists(utilpath.CheckFollowSymlink, podVolumePath)
 	if err != nil {
@@ -320,6 +353,10 @@ func nodeUnpublishEphemeral(req *csi.NodeUnpublishVolumeRequest, ns *nodeServer,
 		return nil, status.Error(codes.FailedPrecondition, "Volume attachment not found in request")
 	}
 
+	if err := ns.wipeVolume(volumeID, vol.Metadata); err != nil {
+		return nil, status.Errorf(codes.Internal, "Unable to wipe volume %s: %v", volumeID, err)
+	}
+
 	err := ns.Cloud.DetachVolume(instanceID, volumeID)
 	if err != nil {
 		klog.V(3).Infof("Failed to DetachVolume: %v", err)
@@ -359,6 +396,18 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 		return nil, status.Error(codes.InvalidArgument, "NodeStageVolume Volume Capability must be provided")
 	}
 
+	if !ns.volumeLocks.TryAcquire(volumeID) {
+		return nil, status.Errorf(codes.Aborted, "An operation with the given volume %s already exists", volumeID)
+	}
+	defer ns.volumeLocks.Release(volumeID)
+
+	op := metrics.StartVolumeOperation("", "stage")
+
+	if err := ns.stagePool.Acquire(ctx); err != nil {
+		return nil, status.Errorf(codes.Aborted, "NodeStageVolume for volume %s was not started: %v", volumeID, err)
+	}
+	defer ns.stagePool.Release()
+
 	vol, err := ns.Cloud.GetVolume(volumeID)
 	if err != nil {
 		if cpoerrors.IsNotFound(err) {
@@ -368,14 +417,34 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 	}
 
 	m := ns.Mount
-	// Do not trust the path provided by cinder, get the real path on node
-	devicePath, err := getDevicePath(volumeID, m)
+	var devicePath string
+	if attachmentID := req.GetPublishContext()[attachmentIDKey]; attachmentID != "" {
+		// Bare-metal node, the volume is connected by the node itself
+		devicePath, err = ns.connectAttachment(attachmentID)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Unable to connect volume %s with attachment %s: %v", volumeID, attachmentID, err)
+		}
+	} else {
+		// Do not trust the path provided by cinder, get the real path on node
+		devicePath, err = getTaggedDevicePath(volumeID, req.GetVolumeContext()[deviceTagKey], m)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Unable to find Device path for volume: %v", err)
+		}
+	}
+
+	devicePath, err = ns.stageReadCache(volumeID, devicePath, req.GetVolumeContext())
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Unable to find Device path for volume: %v", err)
+		return nil, status.Errorf(codes.Internal, "Unable to set up the read cache of volume %s: %v", volumeID, err)
 	}
 
+	isRestored := vol.SourceVolID != "" || vol.SnapshotID != ""
+	verificationMode := req.GetVolumeContext()[restoreVerificationKey]
+	// The device of a multi-node volume may be in use by other nodes
+	multiNode := isMultiNodeMode(volumeCapability.GetAccessMode().GetMode())
+
 	if blk := volumeCapability.GetBlock(); blk != nil {
 		// If block volume, do nothing
+		ns.observeStage(op, vol)
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
@@ -397,15 +466,31 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 			mountFlags := mnt.GetMountFlags()
 			options = append(options, collectMountOptions(fsType, mountFlags)...)
 		}
+		// Verify the contents of a restored volume before it's mounted, because
+		// FormatAndMount would silently repair, or even reformat, a broken device.
+		if isRestored && verificationMode == restoreVerificationFsck {
+			if multiNode {
+				klog.V(2).Infof("NodeStageVolume: skipping the fsck verification of multi-node volume %s", volumeID)
+			} else if err := ns.verifyRestoredDevice(vol, devicePath, fsType); err != nil {
+				return nil, err
+			}
+		}
 		// Mount
-		err = m.Mounter().FormatAndMount(devicePath, stagingTarget, fsType, options)
-		if err != nil {
-			return nil, status.Error(codes.Internal, err.Error())
+		if multiNode {
+			if err := mountShared(m, devicePath, stagingTarget, volumeCapability); err != nil {
+				return nil, err
+			}
+		} else {
+			err = m.Mounter().FormatAndMount(devicePath, stagingTarget, fsType, options)
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
 		}
 	}
 
-	// Try expanding the volume if it's created from a snapshot or another volume (see #1539)
-	if vol.SourceVolID != "" || vol.SnapshotID != "" {
+	// Try expanding the volume if it's created from a snapshot or another volume (see #1539),
+	// the filesystem of a multi-node volume is shared and must be resized by its owner
+	if isRestored && !multiNode {
 
 		r := mountutil.NewResizeFs(ns.Mount.Mounter().Exec)
 
@@ -423,9 +508,21 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 		}
 	}
 
+	if notMnt {
+		ns.observeStage(op, vol)
+	}
+
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
+// observeStage records the metrics of a volume newly staged on the node.
+func (ns *nodeServer) observeStage(op *metrics.VolumeOperation, vol *volumes.Volume) {
+	op.Succeeded()
+	if vol.CreatedAt.After(ns.startTime) {
+		metrics.ObserveVolumeCreateToStage(vol.CreatedAt)
+	}
+}
+
 func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
 	klog.V(4).Infof("NodeUnstageVolume: called with args %+v", protosanitizer.StripSecrets(*req))
 
@@ -439,7 +536,19 @@ func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 		return nil, status.Error(codes.InvalidArgument, "NodeUnstageVolume Staging Target Path must be provided")
 	}
 
-	_, err := ns.Cloud.GetVolume(volumeID)
+	if !ns.volumeLocks.TryAcquire(volumeID) {
+		return nil, status.Errorf(codes.Aborted, "An operation with the given volume %s already exists", volumeID)
+	}
+	defer ns.volumeLocks.Release(volumeID)
+
+	op := metrics.StartVolumeOperation("", "unstage")
+
+	if err := ns.stagePool.Acquire(ctx); err != nil {
+		return nil, status.Errorf(codes.Aborted, "NodeUnstageVolume for volume %s was not started: %v", volumeID, err)
+	}
+	defer ns.stagePool.Release()
+
+	vol, err := ns.Cloud.GetVolume(volumeID)
 	if err != nil {
 		if cpoerrors.IsNotFound(err) {
 			klog.V(4).Infof("NodeUnstageVolume: Unable to find volume: %v", err)
@@ -453,6 +562,19 @@ func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 		return nil, status.Errorf(codes.Internal, "Unmount of targetPath %s failed with error %v", stagingTargetPath, err)
 	}
 
+	if err := teardownReadCache(ns.Mount.Mounter().Exec, ns.Cloud.GetBlockStorageOpts().ReadCacheVolumeGroup, volumeID); err != nil {
+		return nil, status.Errorf(codes.Internal, "Unable to remove the read cache of volume %s: %v", volumeID, err)
+	}
+
+	if err := ns.wipeVolume(volumeID, vol.Metadata); err != nil {
+		return nil, status.Errorf(codes.Internal, "Unable to wipe volume %s: %v", volumeID, err)
+	}
+
+	if err := ns.disconnectAttachment(volumeID); err != nil {
+		return nil, status.Errorf(codes.Internal, "Unable to disconnect volume %s: %v", volumeID, err)
+	}
+	op.Succeeded()
+
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
@@ -469,6 +591,22 @@ func (ns *nodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoReque
 	}
 	topology := &csi.Topology{Segments: map[string]string{topologyKey: zone}}
 
+	if cellKey := ns.Cloud.GetBlockStorageOpts().CellMetadataKey; cellKey != "" {
+		meta, err := ns.Metadata.GetInstanceMetadata()
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "[NodeGetInfo] Unable to retrieve metadata of node %v", err)
+		}
+		if cell, ok := meta[cellKey]; ok && cell != "" {
+			topology.Segments[cellTopologyKey] = cell
+		} else {
+			klog.Warningf("[NodeGetInfo] Metadata key %s not found on node %s, not reporting its cell", cellKey, nodeID)
+		}
+	}
+
+	if ns.Cloud.GetBlockStorageOpts().InstanceTopology {
+		topology.Segments[instanceTopologyKey] = nodeID
+	}
+
 	maxVolume := ns.Cloud.GetMaxVolLimit()
 
 	return &csi.NodeGetInfoResponse{
@@ -506,8 +644,13 @@ func (ns *nodeServer) NodeGetVolumeStats(_ context.Context, req *csi.NodeGetVolu
 	if !exists {
 		return nil, status.Errorf(codes.NotFound, "target: %s not found", volumePath)
 	}
+	condition := nodeVolumeCondition(ns.Mount, volumePath)
 	stats, err := ns.Mount.GetDeviceStats(volumePath)
 	if err != nil {
+		// The stats of a detached block device can't be read, report its condition only
+		if condition.Abnormal {
+			return &csi.NodeGetVolumeStatsResponse{VolumeCondition: condition}, nil
+		}
 		return nil, status.Errorf(codes.Internal, "failed to get stats by path: %v", err)
 	}
 
@@ -519,6 +662,7 @@ func (ns *nodeServer) NodeGetVolumeStats(_ context.Context, req *csi.NodeGetVolu
 					Unit:  csi.VolumeUsage_BYTES,
 				},
 			},
+			VolumeCondition: condition,
 		}, nil
 	}
 
@@ -527,6 +671,7 @@ func (ns *nodeServer) NodeGetVolumeStats(_ context.Context, req *csi.NodeGetVolu
 			{Total: stats.TotalBytes, Available: stats.AvailableBytes, Used: stats.UsedBytes, Unit: csi.VolumeUsage_BYTES},
 			{Total: stats.TotalInodes, Available: stats.AvailableInodes, Used: stats.UsedInodes, Unit: csi.VolumeUsage_INODES},
 		},
+		VolumeCondition: condition,
 	}, nil
 }
 
@@ -560,13 +705,29 @@ func (ns *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 		return nil, status.Error(codes.Internal, "Unable to find Device path for volume")
 	}
 
+	// The read cache is extended once its origin device has grown
+	originPath := devicePath
+	cachePath := readCacheDevicePath(volumeID)
+	if cachePath != "" {
+		originPath, err = getDevicePath(volumeID, ns.Mount)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Unable to find Device path for volume: %v", err)
+		}
+	}
+
 	if ns.Cloud.GetBlockStorageOpts().RescanOnResize {
 		// comparing current volume size with the expected one
 		newSize := req.GetCapacityRange().GetRequiredBytes()
-		if err := blockdevice.RescanBlockDeviceGeometry(devicePath, volumePath, newSize); err != nil {
+		if err := blockdevice.RescanBlockDeviceGeometry(originPath, volumePath, newSize); err != nil {
 			return nil, status.Errorf(codes.Internal, "Could not verify %q volume size: %v", volumeID, err)
 		}
 	}
+
+	if cachePath != "" {
+		if err := resizeReadCache(ns.Mount.Mounter().Exec, ns.Cloud.GetBlockStorageOpts().ReadCacheVolumeGroup, volumeID, originPath); err != nil {
+			return nil, status.Errorf(codes.Internal, "Could not resize the read cache of volume %q: %v", volumeID, err)
+		}
+	}
 	r := mountutil.NewResizeFs(ns.Mount.Mounter().Exec)
 	if _, err := r.Resize(devicePath, volumePath); err != nil {
 		return nil, status.Errorf(codes.Internal, "Could not resize volume %q: %v", volumeID, err)
@@ -606,3 +767,44 @@ func collectMountOptions(fsType string, mntFlags []string) []string {
 	}
 	return options
 }
+
+// verifyRestoredDevice checks the filesystem of a restored volume the first
+// time it's staged, and records the successful check in the volume metadata:
+// the filesystem of a volume mounted before may legitimately need a journal
+// replay, which the read-only check reports as an error.
+func (ns *nodeServer) verifyRestoredDevice(vol *volumes.Volume, devicePath, fsType string) error {
+	if _, ok := vol.Metadata[restoreVerifiedKey]; ok {
+		klog.V(4).Infof("Restored volume %s was already verified", vol.ID)
+		return nil
+	}
+
+	if err := verifyRestoredDeviceFsck(ns.Mount, devicePath, fsType); err != nil {
+		return status.Errorf(codes.DataLoss, "Verification of restored volume %s failed: %v", vol.ID, err)
+	}
+
+	if err := ns.Cloud.UpdateVolumeMetadata(vol.ID, map[string]string{restoreVerifiedKey: restoreVerificationFsck}); err != nil {
+		return status.Errorf(codes.Internal, "Failed to record the verification of restored volume %s: %v", vol.ID, err)
+	}
+
+	return nil
+}
+
+// verifyRestoredDeviceFsck runs a read-only filesystem check on a device
+// restored from a snapshot or cloned from another volume.
+func verifyRestoredDeviceFsck(m mount.IMount, devicePath string, fsType string) error {
+	var cmd string
+	var args []string
+	if fsType == "xfs" {
+		cmd, args = "xfs_repair", []string{"-n", devicePath}
+	} else {
+		cmd, args = "fsck", []string{"-n", "-t", fsType, devicePath}
+	}
+
+	klog.V(4).Infof("Verifying restored device %s: %s %s", devicePath, cmd, strings.Join(args, " "))
+	out, err := m.Mounter().Exec.Command(cmd, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s reported errors on %s: %v, output: %s", cmd, devicePath, err, string(out))
+	}
+
+	return nil
+}
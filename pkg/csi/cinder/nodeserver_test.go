@@ -21,12 +21,18 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/schedulerhints"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
 	"github.com/stretchr/testify/assert"
 	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
 	"k8s.io/cloud-provider-openstack/pkg/util/metadata"
 	"k8s.io/cloud-provider-openstack/pkg/util/mount"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/component-base/metrics/testutil"
 )
 
 var fakeNs *nodeServer
@@ -129,11 +135,11 @@ func TestNodePublishVolumeEphermeral(t *testing.T) {
 	fvolName := fmt.Sprintf("ephemeral-%s", FakeVolID)
 	tState := []string{"available"}
 
-	omock.On("CreateVolume", fvolName, 2, "test", "nova", "", "", "", properties).Return(&FakeVol, nil)
+	omock.On("CreateVolume", fvolName, 2, "test", "nova", "", "", "", properties, (*schedulerhints.SchedulerHints)(nil)).Return(&FakeVol, nil)
 
-	omock.On("AttachVolume", FakeNodeID, FakeVolID).Return(FakeVolID, nil)
+	omock.On("AttachVolume", FakeNodeID, FakeVolID, "").Return(FakeVolID, nil)
 	omock.On("WaitDiskAttached", FakeNodeID, FakeVolID).Return(nil)
-	omock.On("WaitVolumeTargetStatus", FakeVolID, tState).Return(nil)
+	omock.On("WaitVolumeTargetStatus", FakeVolID, tState, openstack.WaitCreate).Return(nil)
 	mmock.On("GetDevicePath", FakeVolID).Return(FakeDevicePath, nil)
 	mmock.On("IsLikelyNotMountPointAttach", FakeTargetPath).Return(true, nil)
 	metamock.On("GetAvailabilityZone").Return(FakeAvailability, nil)
@@ -396,6 +402,7 @@ func TestNodeGetVolumeStatsBlock(t *testing.T) {
 		Usage: []*csi.VolumeUsage{
 			{Total: FakeBlockDeviceStats.TotalBytes, Unit: csi.VolumeUsage_BYTES},
 		},
+		VolumeCondition: &csi.VolumeCondition{Message: "Volume is mounted"},
 	}
 
 	blockRes, err := fakeNs.NodeGetVolumeStats(FakeCtx, fakeReq)
@@ -432,6 +439,7 @@ func TestNodeGetVolumeStatsFs(t *testing.T) {
 			{Total: FakeFsStats.TotalBytes, Available: FakeFsStats.AvailableBytes, Used: FakeFsStats.UsedBytes, Unit: csi.VolumeUsage_BYTES},
 			{Total: FakeFsStats.TotalInodes, Available: FakeFsStats.AvailableInodes, Used: FakeFsStats.UsedInodes, Unit: csi.VolumeUsage_INODES},
 		},
+		VolumeCondition: &csi.VolumeCondition{Message: "Volume is mounted"},
 	}
 
 	fsRes, err := fakeNs.NodeGetVolumeStats(FakeCtx, fakeReq)
@@ -440,3 +448,78 @@ func TestNodeGetVolumeStatsFs(t *testing.T) {
 	assert.Equal(expectedFsRes, fsRes)
 
 }
+
+func TestVerifyRestoredDevice(t *testing.T) {
+	assert := assert.New(t)
+
+	cloud := new(openstack.OpenStackMock)
+	cloud.On("UpdateVolumeMetadata", "restored", map[string]string{restoreVerifiedKey: "fsck"}).Return(nil).Once()
+	ns := &nodeServer{Mount: new(mount.MountMock), Cloud: cloud}
+
+	// The first stage checks the filesystem and records it
+	vol := &volumes.Volume{ID: "restored", SnapshotID: FakeSnapshotID}
+	assert.NoError(ns.verifyRestoredDevice(vol, FakeDevicePath, "ext4"))
+	cloud.AssertExpectations(t)
+
+	// The next ones don't check it again
+	vol.Metadata = map[string]string{restoreVerifiedKey: "fsck"}
+	assert.NoError(ns.verifyRestoredDevice(vol, FakeDevicePath, "ext4"))
+	cloud.AssertNumberOfCalls(t, "UpdateVolumeMetadata", 1)
+}
+
+func TestObserveStage(t *testing.T) {
+	metrics.RegisterMetrics("cinder-csi")
+
+	createToStageCount := func() uint64 {
+		vec, err := testutil.GetHistogramVecFromGatherer(legacyregistry.DefaultGatherer, "cinder_csi_volume_create_to_stage_duration_seconds", nil)
+		if err != nil {
+			// Not gathered before the first observation
+			return 0
+		}
+		return vec.GetAggregatedSampleCount()
+	}
+
+	ns := &nodeServer{startTime: time.Now()}
+	before := createToStageCount()
+
+	// The volumes created before the plugin started don't skew the create-to-stage latency
+	ns.observeStage(metrics.StartVolumeOperation("", "stage"), &volumes.Volume{CreatedAt: ns.startTime.Add(-time.Hour)})
+	assert.Equal(t, before, createToStageCount())
+
+	ns.observeStage(metrics.StartVolumeOperation("", "stage"), &volumes.Volume{CreatedAt: ns.startTime.Add(time.Second)})
+	assert.Equal(t, before+1, createToStageCount())
+}
+
+func TestNodeGetInfoCell(t *testing.T) {
+	tests := []struct {
+		name             string
+		instanceMetadata map[string]string
+		expectedSegments map[string]string
+	}{
+		{
+			name:             "cell",
+			instanceMetadata: map[string]string{"cell": "cell1"},
+			expectedSegments: map[string]string{topologyKey: FakeAvailability, cellTopologyKey: "cell1"},
+		},
+		{
+			// The cell is not reported
+			name:             "no cell metadata",
+			instanceMetadata: map[string]string{"role": "worker"},
+			expectedSegments: map[string]string{topologyKey: FakeAvailability},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			meta := new(metadata.MetadataMock)
+			meta.On("GetInstanceID").Return(FakeNodeID, nil)
+			meta.On("GetAvailabilityZone").Return(FakeAvailability, nil)
+			meta.On("GetInstanceMetadata").Return(test.instanceMetadata, nil)
+			ns := &nodeServer{Cloud: fakeCellCloud{OpenStackMock: new(openstack.OpenStackMock)}, Metadata: meta}
+
+			res, err := ns.NodeGetInfo(FakeCtx, &csi.NodeGetInfoRequest{})
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedSegments, res.GetAccessibleTopology().GetSegments())
+		})
+	}
+}
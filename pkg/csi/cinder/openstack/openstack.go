@@ -23,14 +23,20 @@ import (
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/backups"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/schedulerhints"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/volumetransfers"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/attachments"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/snapshots"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumetypes"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 	"github.com/spf13/pflag"
 	gcfg "gopkg.in/gcfg.v1"
 	"k8s.io/cloud-provider-openstack/pkg/client"
 	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	"k8s.io/cloud-provider-openstack/pkg/util"
 	"k8s.io/cloud-provider-openstack/pkg/util/metadata"
 	"k8s.io/component-base/metrics/legacyregistry"
 	"k8s.io/klog/v2"
@@ -45,17 +51,25 @@ func AddExtraFlags(fs *pflag.FlagSet) {
 }
 
 type IOpenStack interface {
-	CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourceVolID string, sourceBackupID string, tags map[string]string) (*volumes.Volume, error)
+	CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourceVolID string, sourceBackupID string, tags map[string]string, schedulerHints *schedulerhints.SchedulerHints) (*volumes.Volume, error)
 	DeleteVolume(volumeID string) error
-	AttachVolume(instanceID, volumeID string) (string, error)
+	UpdateVolumeMetadata(volumeID string, metadata map[string]string) error
+	AttachVolume(instanceID, volumeID, deviceTag string) (string, error)
 	ListVolumes(limit int, startingToken string) ([]volumes.Volume, string, error)
 	WaitDiskAttached(instanceID string, volumeID string) error
 	DetachVolume(instanceID, volumeID string) error
 	WaitDiskDetached(instanceID string, volumeID string) error
-	WaitVolumeTargetStatus(volumeID string, tStatus []string) error
+	WaitVolumeTargetStatus(volumeID string, tStatus []string, op WaitOperation) error
 	GetAttachmentDiskPath(instanceID, volumeID string) (string, error)
+	CreateAttachment(instanceID, volumeID string) (string, error)
+	GetAttachment(instanceID, volumeID string) (*attachments.Attachment, error)
+	GetAttachmentByID(attachmentID string) (*attachments.Attachment, error)
+	UpdateAttachment(attachmentID string, connector map[string]interface{}) (*attachments.Attachment, error)
+	CompleteAttachment(attachmentID string) error
+	DeleteAttachment(attachmentID string) error
 	GetVolume(volumeID string) (*volumes.Volume, error)
 	GetVolumesByName(name string) ([]volumes.Volume, error)
+	GetVolumesByMetadata(metadata map[string]string) ([]volumes.Volume, error)
 	CreateSnapshot(name, volID string, tags map[string]string) (*snapshots.Snapshot, error)
 	ListSnapshots(filters map[string]string) ([]snapshots.Snapshot, string, error)
 	DeleteSnapshot(snapID string) error
@@ -68,7 +82,15 @@ type IOpenStack interface {
 	BackupsAreEnabled() (bool, error)
 	WaitBackupReady(backupID string, snapshotSize int, backupMaxDurationSecondsPerGB int) (string, error)
 	GetInstanceByID(instanceID string) (*servers.Server, error)
+	GetBareMetalNode(nodeID string) (*nodes.Node, error)
 	ExpandVolume(volumeID string, status string, size int) error
+	RetypeVolume(volumeID string, volumeType string) error
+	SetVolumeBootable(volumeID string, bootable bool) error
+	GetVolumeType(nameOrID string) (*volumetypes.VolumeType, error)
+	CreateTransfer(volumeID, name string) (*volumetransfers.Transfer, error)
+	ListTransfers(volumeID string) ([]volumetransfers.Transfer, error)
+	DeleteTransfer(transferID string) error
+	AcceptTransfer(transferID, authKey string) error
 	GetMaxVolLimit() int64
 	GetMetadataOpts() metadata.Opts
 	GetBlockStorageOpts() BlockStorageOpts
@@ -83,10 +105,72 @@ type OpenStack struct {
 }
 
 type BlockStorageOpts struct {
-	NodeVolumeAttachLimit    int64 `gcfg:"node-volume-attach-limit"`
-	RescanOnResize           bool  `gcfg:"rescan-on-resize"`
-	IgnoreVolumeAZ           bool  `gcfg:"ignore-volume-az"`
-	IgnoreVolumeMicroversion bool  `gcfg:"ignore-volume-microversion"`
+	NodeVolumeAttachLimit    int64  `gcfg:"node-volume-attach-limit"`
+	RescanOnResize           bool   `gcfg:"rescan-on-resize"`
+	IgnoreVolumeAZ           bool   `gcfg:"ignore-volume-az"`
+	IgnoreVolumeMicroversion bool   `gcfg:"ignore-volume-microversion"`
+	CellMetadataKey          string `gcfg:"cell-metadata-key"`
+	InstanceTopology         bool   `gcfg:"instance-topology"`
+	NodeStageConcurrency     int    `gcfg:"node-stage-concurrency"`
+
+	// Attach the volumes created from now on with their PV name as Nova
+	// device tag, the nodes then find them in the instance metadata
+	AttachDeviceTag bool `gcfg:"attach-device-tag"`
+
+	// Cinder allows restoring snapshots in another availability zone than
+	// their source volume (cloned_volume_same_az = False), the availability
+	// zone of the volumes restored from snapshots is then not checked
+	CrossAZSnapshotRestore bool `gcfg:"cross-az-snapshot-restore"`
+
+	// Attach volumes to the nodes unknown to Nova and known to Ironic, i.e.
+	// bare-metal nodes, with the Cinder attachments API and a connector on the
+	// node
+	BareMetalAttach bool `gcfg:"bare-metal-attach"`
+
+	// Comma-separated key=value pairs written on the created volumes and
+	// snapshots next to the cluster ID, e.g. the UID of the cluster. With
+	// ProtectUnownedVolumes, DeleteVolume refuses the volumes missing any of
+	// them or the cluster ID, or with another value.
+	ClusterMetadata       string `gcfg:"cluster-metadata"`
+	ProtectUnownedVolumes bool   `gcfg:"protect-unowned-volumes"`
+
+	// Default of the force-create parameter of the VolumeSnapshotClasses,
+	// allowing the snapshots of in-use volumes
+	SnapshotForceCreate bool `gcfg:"snapshot-force-create"`
+
+	// Method used by the nodes to wipe the volumes created with the wipe
+	// parameter: zero (default) or discard
+	WipeMethod string `gcfg:"wipe-method"`
+
+	// Interval at which the node runs fstrim on the staged filesystems,
+	// disabled if unset
+	ReclaimSpaceInterval util.MyDuration `gcfg:"reclaim-space-interval"`
+
+	// LVM volume group of the node, typically on a local SSD, holding the
+	// dm-cache read caches of the volumes created with the readCache
+	// parameter, and the default size of a cache
+	ReadCacheVolumeGroup string `gcfg:"read-cache-volume-group"`
+	ReadCacheSize        string `gcfg:"read-cache-size"`
+
+	// Deferred deletion of volumes, disabled if DeletionQueueWorkers is 0
+	DeletionQueueWorkers int             `gcfg:"deletion-queue-workers"`
+	DeletionQueueRate    int             `gcfg:"deletion-queue-rate"`
+	DeletionSweepPeriod  util.MyDuration `gcfg:"deletion-sweep-period"`
+
+	// Timeouts and poll intervals of the waits for each class of operation,
+	// the default backoffs are used when the timeout is unset
+	CreateTimeout        util.MyDuration `gcfg:"create-timeout"`
+	CreatePollInterval   util.MyDuration `gcfg:"create-poll-interval"`
+	AttachTimeout        util.MyDuration `gcfg:"attach-timeout"`
+	AttachPollInterval   util.MyDuration `gcfg:"attach-poll-interval"`
+	DetachTimeout        util.MyDuration `gcfg:"detach-timeout"`
+	DetachPollInterval   util.MyDuration `gcfg:"detach-poll-interval"`
+	SnapshotTimeout      util.MyDuration `gcfg:"snapshot-timeout"`
+	SnapshotPollInterval util.MyDuration `gcfg:"snapshot-poll-interval"`
+	ResizeTimeout        util.MyDuration `gcfg:"resize-timeout"`
+	ResizePollInterval   util.MyDuration `gcfg:"resize-poll-interval"`
+	RetypeTimeout        util.MyDuration `gcfg:"retype-timeout"`
+	RetypePollInterval   util.MyDuration `gcfg:"retype-poll-interval"`
 }
 
 type Config struct {
@@ -169,6 +253,27 @@ func CreateOpenStackProvider() (IOpenStack, error) {
 	}
 	logcfg(cfg)
 
+	instance, err := newOpenStack(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	OsInstance = instance
+	return OsInstance, nil
+}
+
+// NewOpenStackFromConfig creates an Openstack Instance from the contents of a
+// cloud config file, e.g. the credentials of another project
+func NewOpenStackFromConfig(data []byte) (IOpenStack, error) {
+	var cfg Config
+	if err := gcfg.FatalOnly(gcfg.ReadStringInto(&cfg, string(data))); err != nil {
+		return nil, fmt.Errorf("failed to read OpenStack configuration: %v", err)
+	}
+
+	return newOpenStack(cfg)
+}
+
+func newOpenStack(cfg Config) (*OpenStack, error) {
 	provider, err := client.NewOpenStackClient(&cfg.Global, "cinder-csi-plugin", userAgentData...)
 	if err != nil {
 		return nil, err
@@ -197,15 +302,13 @@ func CreateOpenStackProvider() (IOpenStack, error) {
 	}
 
 	// Init OpenStack
-	OsInstance = &OpenStack{
+	return &OpenStack{
 		compute:      computeclient,
 		blockstorage: blockstorageclient,
 		bsOpts:       cfg.BlockStorage,
 		epOpts:       epOpts,
 		metadataOpts: cfg.Metadata,
-	}
-
-	return OsInstance, nil
+	}, nil
 }
 
 // GetOpenStackProvider returns Openstack Instance
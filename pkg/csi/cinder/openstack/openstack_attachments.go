@@ -0,0 +1,180 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package openstack attachments provides the Cinder attachments used to attach
+// volumes to bare-metal nodes, which are not managed by Nova.
+package openstack
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/attachments"
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+// AttachmentAttachedStatus is the status of a completed attachment
+const AttachmentAttachedStatus = "attached"
+
+// attachmentsClient returns a local thread safe copy of the Cinder
+// ServiceClient, with the microversion required by the attachments API.
+func (os *OpenStack) attachmentsClient() (*gophercloud.ServiceClient, error) {
+	// If the user has disabled the use of microversion to be compatible with
+	// older clouds, we should fail early
+	if os.bsOpts.IgnoreVolumeMicroversion {
+		return nil, fmt.Errorf("cinder attachments are not available with ignore-volume-microversion, requires microversion 3.44 or newer")
+	}
+
+	blockstorageClient, err := openstack.NewBlockStorageV3(os.blockstorage.ProviderClient, os.epOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	// attachments are completed by the client since 3.44 microversion
+	// https://docs.openstack.org/cinder/latest/contributor/api_microversion_history.html#id42
+	blockstorageClient.Microversion = "3.44"
+
+	return blockstorageClient, nil
+}
+
+// CreateAttachment reserves the volume for the instance, without connecting
+// it yet, and returns the ID of the attachment. The attachment is completed by
+// the node once it has connected the volume.
+func (os *OpenStack) CreateAttachment(instanceID, volumeID string) (string, error) {
+	att, err := os.GetAttachment(instanceID, volumeID)
+	if err == nil {
+		klog.V(4).Infof("Volume %s already has attachment %s for instance %s", volumeID, att.ID, instanceID)
+		return att.ID, nil
+	}
+	if !cpoerrors.IsNotFound(err) {
+		return "", err
+	}
+
+	client, err := os.attachmentsClient()
+	if err != nil {
+		return "", err
+	}
+
+	mc := metrics.NewMetricContext("attachment", "create")
+	att, err = attachments.Create(client, attachments.CreateOpts{
+		VolumeUUID:   volumeID,
+		InstanceUUID: instanceID,
+	}).Extract()
+	if mc.ObserveRequest(err) != nil {
+		return "", fmt.Errorf("failed to create attachment of volume %s for instance %s: %v", volumeID, instanceID, err)
+	}
+
+	return att.ID, nil
+}
+
+// GetAttachment returns the attachment of the volume to the instance,
+// including its connection info.
+func (os *OpenStack) GetAttachment(instanceID, volumeID string) (*attachments.Attachment, error) {
+	client, err := os.attachmentsClient()
+	if err != nil {
+		return nil, err
+	}
+
+	mc := metrics.NewMetricContext("attachment", "list")
+	pages, err := attachments.List(client, attachments.ListOpts{
+		VolumeID:   volumeID,
+		InstanceID: instanceID,
+	}).AllPages()
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	atts, err := attachments.ExtractAttachments(pages)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, att := range atts {
+		if att.VolumeID == volumeID && att.Instance == instanceID {
+			// The list only returns a summary of the attachments
+			return os.GetAttachmentByID(att.ID)
+		}
+	}
+
+	return nil, cpoerrors.ErrNotFound
+}
+
+// GetAttachmentByID retrieves the attachment by its ID.
+func (os *OpenStack) GetAttachmentByID(attachmentID string) (*attachments.Attachment, error) {
+	client, err := os.attachmentsClient()
+	if err != nil {
+		return nil, err
+	}
+
+	mc := metrics.NewMetricContext("attachment", "get")
+	att, err := attachments.Get(client, attachmentID).Extract()
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	return att, nil
+}
+
+// UpdateAttachment sets the connector of the node on the attachment, and
+// returns the attachment holding the connection info of the volume.
+func (os *OpenStack) UpdateAttachment(attachmentID string, connector map[string]interface{}) (*attachments.Attachment, error) {
+	client, err := os.attachmentsClient()
+	if err != nil {
+		return nil, err
+	}
+
+	mc := metrics.NewMetricContext("attachment", "update")
+	att, err := attachments.Update(client, attachmentID, attachments.UpdateOpts{
+		Connector: connector,
+	}).Extract()
+	if mc.ObserveRequest(err) != nil {
+		return nil, fmt.Errorf("failed to update attachment %s: %v", attachmentID, err)
+	}
+
+	return att, nil
+}
+
+// CompleteAttachment marks the volume of the attachment as in-use.
+func (os *OpenStack) CompleteAttachment(attachmentID string) error {
+	client, err := os.attachmentsClient()
+	if err != nil {
+		return err
+	}
+
+	mc := metrics.NewMetricContext("attachment", "complete")
+	err = attachments.Complete(client, attachmentID).ExtractErr()
+	if mc.ObserveRequest(err) != nil {
+		return fmt.Errorf("failed to complete attachment %s: %v", attachmentID, err)
+	}
+
+	return nil
+}
+
+// DeleteAttachment deletes the attachment, terminating the connection of the
+// volume on the storage backend.
+func (os *OpenStack) DeleteAttachment(attachmentID string) error {
+	client, err := os.attachmentsClient()
+	if err != nil {
+		return err
+	}
+
+	mc := metrics.NewMetricContext("attachment", "delete")
+	err = attachments.Delete(client, attachmentID).ExtractErr()
+	return mc.ObserveRequest(err)
+}
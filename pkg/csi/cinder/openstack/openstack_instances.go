@@ -1,6 +1,9 @@
 package openstack
 
 import (
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 	"k8s.io/cloud-provider-openstack/pkg/metrics"
 )
@@ -14,3 +17,36 @@ func (os *OpenStack) GetInstanceByID(instanceID string) (*servers.Server, error)
 	}
 	return server, nil
 }
+
+// GetBareMetalNode returns the Ironic node with the specified ID, either the
+// UUID or name of the node or the UUID of the instance deployed on it.
+func (os *OpenStack) GetBareMetalNode(nodeID string) (*nodes.Node, error) {
+	baremetalClient, err := openstack.NewBareMetalV1(os.compute.ProviderClient, os.epOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	mc := metrics.NewMetricContext("baremetal_node", "get")
+	node, err := nodes.Get(baremetalClient, nodeID).Extract()
+	if mc.ObserveRequest(err) == nil {
+		return node, nil
+	}
+	if _, ok := err.(gophercloud.ErrDefault404); !ok {
+		return nil, err
+	}
+
+	mc = metrics.NewMetricContext("baremetal_node", "list")
+	allPages, err := nodes.List(baremetalClient, nodes.ListOpts{InstanceUUID: nodeID}).AllPages()
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+	nodeList, err := nodes.ExtractNodes(allPages)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodeList) == 0 {
+		return nil, gophercloud.ErrDefault404{}
+	}
+
+	return &nodeList[0], nil
+}
@@ -17,9 +17,14 @@ limitations under the License.
 package openstack
 
 import (
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/backups"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/schedulerhints"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/volumetransfers"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/attachments"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/snapshots"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumetypes"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 	"github.com/stretchr/testify/mock"
 	"k8s.io/cloud-provider-openstack/pkg/util/metadata"
@@ -63,20 +68,20 @@ type OpenStackMock struct {
 
 // revive:enable:exported
 
-// AttachVolume provides a mock function with given fields: instanceID, volumeID
-func (_m *OpenStackMock) AttachVolume(instanceID string, volumeID string) (string, error) {
-	ret := _m.Called(instanceID, volumeID)
+// AttachVolume provides a mock function with given fields: instanceID, volumeID, deviceTag
+func (_m *OpenStackMock) AttachVolume(instanceID string, volumeID string, deviceTag string) (string, error) {
+	ret := _m.Called(instanceID, volumeID, deviceTag)
 
 	var r0 string
-	if rf, ok := ret.Get(0).(func(string, string) string); ok {
-		r0 = rf(instanceID, volumeID)
+	if rf, ok := ret.Get(0).(func(string, string, string) string); ok {
+		r0 = rf(instanceID, volumeID, deviceTag)
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, string) error); ok {
-		r1 = rf(instanceID, volumeID)
+	if rf, ok := ret.Get(1).(func(string, string, string) error); ok {
+		r1 = rf(instanceID, volumeID, deviceTag)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -85,19 +90,19 @@ func (_m *OpenStackMock) AttachVolume(instanceID string, volumeID string) (strin
 }
 
 // CreateVolume provides a mock function with given fields: name, size, vtype, availability, tags
-func (_m *OpenStackMock) CreateVolume(name string, size int, vtype string, availability string, snapshotID string, sourceVolID string, sourceBackupID string, tags map[string]string) (*volumes.Volume, error) {
-	ret := _m.Called(name, size, vtype, availability, snapshotID, sourceVolID, sourceBackupID, tags)
+func (_m *OpenStackMock) CreateVolume(name string, size int, vtype string, availability string, snapshotID string, sourceVolID string, sourceBackupID string, tags map[string]string, schedulerHints *schedulerhints.SchedulerHints) (*volumes.Volume, error) {
+	ret := _m.Called(name, size, vtype, availability, snapshotID, sourceVolID, sourceBackupID, tags, schedulerHints)
 
 	var r0 *volumes.Volume
-	if rf, ok := ret.Get(0).(func(string, int, string, string, string, string, string, map[string]string) *volumes.Volume); ok {
-		r0 = rf(name, size, vtype, availability, snapshotID, sourceVolID, sourceBackupID, tags)
+	if rf, ok := ret.Get(0).(func(string, int, string, string, string, string, string, map[string]string, *schedulerhints.SchedulerHints) *volumes.Volume); ok {
+		r0 = rf(name, size, vtype, availability, snapshotID, sourceVolID, sourceBackupID, tags, schedulerHints)
 	} else {
 		r0 = ret.Get(0).(*volumes.Volume)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, int, string, string, string, string, string, map[string]string) error); ok {
-		r1 = rf(name, size, vtype, availability, snapshotID, sourceVolID, sourceBackupID, tags)
+	if rf, ok := ret.Get(1).(func(string, int, string, string, string, string, string, map[string]string, *schedulerhints.SchedulerHints) error); ok {
+		r1 = rf(name, size, vtype, availability, snapshotID, sourceVolID, sourceBackupID, tags, schedulerHints)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -119,6 +124,20 @@ func (_m *OpenStackMock) DeleteVolume(volumeID string) error {
 	return r0
 }
 
+// UpdateVolumeMetadata provides a mock function with given fields: volumeID, metadata
+func (_m *OpenStackMock) UpdateVolumeMetadata(volumeID string, metadata map[string]string) error {
+	ret := _m.Called(volumeID, metadata)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, map[string]string) error); ok {
+		r0 = rf(volumeID, metadata)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // GetVolume provides a mock function with given fields: volumeID
 func (_m *OpenStackMock) GetVolume(volumeID string) (*volumes.Volume, error) {
 	return &fakeVol1, nil
@@ -159,6 +178,63 @@ func (_m *OpenStackMock) GetAttachmentDiskPath(instanceID string, volumeID strin
 	return r0, r1
 }
 
+// CreateAttachment provides a mock function with given fields: instanceID, volumeID
+func (_m *OpenStackMock) CreateAttachment(instanceID string, volumeID string) (string, error) {
+	ret := _m.Called(instanceID, volumeID)
+
+	return ret.String(0), ret.Error(1)
+}
+
+// GetAttachment provides a mock function with given fields: instanceID, volumeID
+func (_m *OpenStackMock) GetAttachment(instanceID string, volumeID string) (*attachments.Attachment, error) {
+	ret := _m.Called(instanceID, volumeID)
+
+	var r0 *attachments.Attachment
+	if ret.Get(0) != nil {
+		r0 = ret.Get(0).(*attachments.Attachment)
+	}
+
+	return r0, ret.Error(1)
+}
+
+// GetAttachmentByID provides a mock function with given fields: attachmentID
+func (_m *OpenStackMock) GetAttachmentByID(attachmentID string) (*attachments.Attachment, error) {
+	ret := _m.Called(attachmentID)
+
+	var r0 *attachments.Attachment
+	if ret.Get(0) != nil {
+		r0 = ret.Get(0).(*attachments.Attachment)
+	}
+
+	return r0, ret.Error(1)
+}
+
+// UpdateAttachment provides a mock function with given fields: attachmentID, connector
+func (_m *OpenStackMock) UpdateAttachment(attachmentID string, connector map[string]interface{}) (*attachments.Attachment, error) {
+	ret := _m.Called(attachmentID, connector)
+
+	var r0 *attachments.Attachment
+	if ret.Get(0) != nil {
+		r0 = ret.Get(0).(*attachments.Attachment)
+	}
+
+	return r0, ret.Error(1)
+}
+
+// CompleteAttachment provides a mock function with given fields: attachmentID
+func (_m *OpenStackMock) CompleteAttachment(attachmentID string) error {
+	ret := _m.Called(attachmentID)
+
+	return ret.Error(0)
+}
+
+// DeleteAttachment provides a mock function with given fields: attachmentID
+func (_m *OpenStackMock) DeleteAttachment(attachmentID string) error {
+	ret := _m.Called(attachmentID)
+
+	return ret.Error(0)
+}
+
 // WaitDiskAttached provides a mock function with given fields: instanceID, volumeID
 func (_m *OpenStackMock) WaitDiskAttached(instanceID string, volumeID string) error {
 	ret := _m.Called(instanceID, volumeID)
@@ -173,13 +249,13 @@ func (_m *OpenStackMock) WaitDiskAttached(instanceID string, volumeID string) er
 	return r0
 }
 
-// WaitVolumeTargetStatus provides a mock function with given fields: volumeID, tStatus
-func (_m *OpenStackMock) WaitVolumeTargetStatus(volumeID string, tStatus []string) error {
-	ret := _m.Called(volumeID, tStatus)
+// WaitVolumeTargetStatus provides a mock function with given fields: volumeID, tStatus, op
+func (_m *OpenStackMock) WaitVolumeTargetStatus(volumeID string, tStatus []string, op WaitOperation) error {
+	ret := _m.Called(volumeID, tStatus, op)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, []string) error); ok {
-		r0 = rf(volumeID, tStatus)
+	if rf, ok := ret.Get(0).(func(string, []string, WaitOperation) error); ok {
+		r0 = rf(volumeID, tStatus, op)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -225,6 +301,29 @@ func (_m *OpenStackMock) GetVolumesByName(name string) ([]volumes.Volume, error)
 	return r0, r1
 }
 
+// GetVolumesByMetadata provides a mock function with given fields: metadata
+func (_m *OpenStackMock) GetVolumesByMetadata(metadata map[string]string) ([]volumes.Volume, error) {
+	ret := _m.Called(metadata)
+
+	var r0 []volumes.Volume
+	if rf, ok := ret.Get(0).(func(map[string]string) []volumes.Volume); ok {
+		r0 = rf(metadata)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]volumes.Volume)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(map[string]string) error); ok {
+		r1 = rf(metadata)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ListSnapshots provides a mock function with given fields: limit, offset, filters
 func (_m *OpenStackMock) ListSnapshots(filters map[string]string) ([]snapshots.Snapshot, string, error) {
 	ret := _m.Called(filters)
@@ -468,6 +567,29 @@ func (_m *OpenStackMock) GetInstanceByID(instanceID string) (*servers.Server, er
 	return nil, nil
 }
 
+// GetBareMetalNode provides a mock function with given fields: nodeID
+func (_m *OpenStackMock) GetBareMetalNode(nodeID string) (*nodes.Node, error) {
+	ret := _m.Called(nodeID)
+
+	var r0 *nodes.Node
+	if rf, ok := ret.Get(0).(func(string) *nodes.Node); ok {
+		r0 = rf(nodeID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*nodes.Node)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(nodeID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ExpandVolume provides a mock function with given fields: instanceID, volumeID
 func (_m *OpenStackMock) ExpandVolume(volumeID string, status string, size int) error {
 	ret := _m.Called(volumeID, status, size)
@@ -482,6 +604,84 @@ func (_m *OpenStackMock) ExpandVolume(volumeID string, status string, size int)
 	return r0
 }
 
+// RetypeVolume provides a mock function with given fields: volumeID, volumeType
+func (_m *OpenStackMock) RetypeVolume(volumeID string, volumeType string) error {
+	ret := _m.Called(volumeID, volumeType)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string) error); ok {
+		r0 = rf(volumeID, volumeType)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetVolumeBootable provides a mock function with given fields: volumeID, bootable
+func (_m *OpenStackMock) SetVolumeBootable(volumeID string, bootable bool) error {
+	ret := _m.Called(volumeID, bootable)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, bool) error); ok {
+		r0 = rf(volumeID, bootable)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetVolumeType provides a mock function with given fields: nameOrID
+func (_m *OpenStackMock) GetVolumeType(nameOrID string) (*volumetypes.VolumeType, error) {
+	ret := _m.Called(nameOrID)
+
+	var r0 *volumetypes.VolumeType
+	if rf, ok := ret.Get(0).(func(string) *volumetypes.VolumeType); ok {
+		r0 = rf(nameOrID)
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).(*volumetypes.VolumeType)
+	}
+
+	return r0, ret.Error(1)
+}
+
+func (_m *OpenStackMock) CreateTransfer(volumeID, name string) (*volumetransfers.Transfer, error) {
+	ret := _m.Called(volumeID, name)
+
+	var r0 *volumetransfers.Transfer
+	if rf, ok := ret.Get(0).(func(string, string) *volumetransfers.Transfer); ok {
+		r0 = rf(volumeID, name)
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).(*volumetransfers.Transfer)
+	}
+
+	return r0, ret.Error(1)
+}
+
+func (_m *OpenStackMock) ListTransfers(volumeID string) ([]volumetransfers.Transfer, error) {
+	ret := _m.Called(volumeID)
+
+	var r0 []volumetransfers.Transfer
+	if ret.Get(0) != nil {
+		r0 = ret.Get(0).([]volumetransfers.Transfer)
+	}
+
+	return r0, ret.Error(1)
+}
+
+func (_m *OpenStackMock) DeleteTransfer(transferID string) error {
+	ret := _m.Called(transferID)
+
+	return ret.Error(0)
+}
+
+func (_m *OpenStackMock) AcceptTransfer(transferID, authKey string) error {
+	ret := _m.Called(transferID, authKey)
+
+	return ret.Error(0)
+}
+
 func (_m *OpenStackMock) GetMetadataOpts() metadata.Opts {
 	var m metadata.Opts
 	m.SearchOrder = "configDrive"
@@ -83,7 +83,7 @@ func (os *OpenStack) CreateSnapshot(name, volID string, tags map[string]string)
 // ListSnapshots retrieves a list of active snapshots from Cinder for the corresponding Tenant.  We also
 // provide the ability to provide limit and offset to enable the consumer to provide accurate pagination.
 // In addition the filters argument provides a mechanism for passing in valid filter strings to the list
-// operation.  Valid filter keys are:  Name, Status, VolumeID, Limit, Marker (TenantID has no effect)
+// operation.  Valid filter keys are:  Name, Status, VolumeID, Limit, Marker, Sort (TenantID has no effect)
 func (os *OpenStack) ListSnapshots(filters map[string]string) ([]snapshots.Snapshot, string, error) {
 	var nextPageToken string
 	var snaps []snapshots.Snapshot
@@ -102,6 +102,8 @@ func (os *OpenStack) ListSnapshots(filters map[string]string) ([]snapshots.Snaps
 			opts.Marker = val
 		case "Limit":
 			opts.Limit, _ = strconv.Atoi(val)
+		case "Sort":
+			opts.Sort = val
 		default:
 			klog.V(3).Infof("Not a valid filter key %s", key)
 		}
@@ -166,7 +168,7 @@ func (os *OpenStack) WaitSnapshotReady(snapshotID string) (string, error) {
 		Steps:    snapReadySteps,
 	}
 
-	err := wait.ExponentialBackoff(backoff, func() (bool, error) {
+	err := os.waitFor(WaitSnapshot, backoff, func() (bool, error) {
 		ready, err := os.snapshotIsReady(snapshotID)
 		if err != nil {
 			return false, err
@@ -0,0 +1,80 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/volumetransfers"
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	"k8s.io/klog/v2"
+)
+
+// CreateTransfer creates a transfer of the volume to another project. The
+// returned transfer holds the auth key the other project accepts it with.
+func (os *OpenStack) CreateTransfer(volumeID, name string) (*volumetransfers.Transfer, error) {
+	mc := metrics.NewMetricContext("transfer", "create")
+	transfer, err := volumetransfers.Create(os.blockstorage, volumetransfers.CreateOpts{
+		VolumeID: volumeID,
+		Name:     name,
+	}).Extract()
+	if mc.ObserveRequest(err) != nil {
+		klog.Errorf("Failed to create transfer of volume %s: %v", volumeID, err)
+		return nil, err
+	}
+	return transfer, nil
+}
+
+// ListTransfers returns the pending transfers of the volume.
+func (os *OpenStack) ListTransfers(volumeID string) ([]volumetransfers.Transfer, error) {
+	mc := metrics.NewMetricContext("transfer", "list")
+	pages, err := volumetransfers.List(os.blockstorage, volumetransfers.ListOpts{}).AllPages()
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	all, err := volumetransfers.ExtractTransfers(pages)
+	if err != nil {
+		return nil, err
+	}
+
+	var transfers []volumetransfers.Transfer
+	for _, t := range all {
+		if t.VolumeID == volumeID {
+			transfers = append(transfers, t)
+		}
+	}
+	return transfers, nil
+}
+
+// DeleteTransfer cancels a pending transfer.
+func (os *OpenStack) DeleteTransfer(transferID string) error {
+	mc := metrics.NewMetricContext("transfer", "delete")
+	err := volumetransfers.Delete(os.blockstorage, transferID).ExtractErr()
+	if mc.ObserveRequest(err) != nil {
+		klog.Errorf("Failed to delete transfer %s: %v", transferID, err)
+	}
+	return err
+}
+
+// AcceptTransfer accepts a transfer into the project of the client.
+func (os *OpenStack) AcceptTransfer(transferID, authKey string) error {
+	mc := metrics.NewMetricContext("transfer", "accept")
+	_, err := volumetransfers.Accept(os.blockstorage, transferID, volumetransfers.AcceptOpts{AuthKey: authKey}).Extract()
+	if mc.ObserveRequest(err) != nil {
+		klog.Errorf("Failed to accept transfer %s: %v", transferID, err)
+	}
+	return err
+}
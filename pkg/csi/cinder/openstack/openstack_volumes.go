@@ -21,9 +21,12 @@ import (
 	"net/url"
 	"time"
 
+	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/schedulerhints"
 	volumeexpand "github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/volumeactions"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumetypes"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/volumeattach"
 	"github.com/gophercloud/gophercloud/pagination"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -51,7 +54,7 @@ const (
 var volumeErrorStates = [...]string{"error", "error_extending", "error_deleting"}
 
 // CreateVolume creates a volume of given size
-func (os *OpenStack) CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourceVolID string, sourceBackupID string, tags map[string]string) (*volumes.Volume, error) {
+func (os *OpenStack) CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourceVolID string, sourceBackupID string, tags map[string]string, schedulerHints *schedulerhints.SchedulerHints) (*volumes.Volume, error) {
 
 	opts := &volumes.CreateOpts{
 		Name:             name,
@@ -78,8 +81,16 @@ func (os *OpenStack) CreateVolume(name string, size int, vtype, availability str
 		blockstorageClient.Microversion = "3.51"
 	}
 
+	var createOpts volumes.CreateOptsBuilder = opts
+	if schedulerHints != nil {
+		createOpts = schedulerhints.CreateOptsExt{
+			VolumeCreateOptsBuilder: opts,
+			SchedulerHints:          schedulerHints,
+		}
+	}
+
 	mc := metrics.NewMetricContext("volume", "create")
-	vol, err := volumes.Create(blockstorageClient, opts).Extract()
+	vol, err := volumes.Create(blockstorageClient, createOpts).Extract()
 	if mc.ObserveRequest(err) != nil {
 		return nil, err
 	}
@@ -154,6 +165,18 @@ func (os *OpenStack) GetVolumesByName(n string) ([]volumes.Volume, error) {
 	return vols, nil
 }
 
+// GetVolumesByMetadata returns the volumes having all the given metadata
+func (os *OpenStack) GetVolumesByMetadata(metadata map[string]string) ([]volumes.Volume, error) {
+	opts := volumes.ListOpts{Metadata: metadata}
+	mc := metrics.NewMetricContext("volume", "list")
+	pages, err := volumes.List(os.blockstorage, opts).AllPages()
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	return volumes.ExtractVolumes(pages)
+}
+
 // DeleteVolume delete a volume
 func (os *OpenStack) DeleteVolume(volumeID string) error {
 	used, err := os.diskIsUsed(volumeID)
@@ -169,6 +192,17 @@ func (os *OpenStack) DeleteVolume(volumeID string) error {
 	return mc.ObserveRequest(err)
 }
 
+// UpdateVolumeMetadata sets the given metadata keys on the volume, keeping its other metadata. The keys are
+// merged by Cinder, concurrent updates of other keys aren't lost.
+func (os *OpenStack) UpdateVolumeMetadata(volumeID string, metadata map[string]string) error {
+	mc := metrics.NewMetricContext("volume", "update_metadata")
+	body := map[string]interface{}{"metadata": metadata}
+	_, err := os.blockstorage.Post(os.blockstorage.ServiceURL("volumes", volumeID, "metadata"), body, nil, &gophercloud.RequestOpts{
+		OkCodes: []int{200},
+	})
+	return mc.ObserveRequest(err)
+}
+
 // GetVolume retrieves Volume by its ID.
 func (os *OpenStack) GetVolume(volumeID string) (*volumes.Volume, error) {
 	mc := metrics.NewMetricContext("volume", "get")
@@ -180,8 +214,38 @@ func (os *OpenStack) GetVolume(volumeID string) (*volumes.Volume, error) {
 	return vol, nil
 }
 
-// AttachVolume attaches given cinder volume to the compute
-func (os *OpenStack) AttachVolume(instanceID, volumeID string) (string, error) {
+// GetVolumeType retrieves the volume type by its ID, or by its name.
+func (os *OpenStack) GetVolumeType(nameOrID string) (*volumetypes.VolumeType, error) {
+	mc := metrics.NewMetricContext("volume_type", "get")
+	vt, err := volumetypes.Get(os.blockstorage, nameOrID).Extract()
+	if mc.ObserveRequest(err) == nil {
+		return vt, nil
+	}
+	if !cpoerrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	mc = metrics.NewMetricContext("volume_type", "list")
+	pages, err := volumetypes.List(os.blockstorage, volumetypes.ListOpts{}).AllPages()
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	vts, err := volumetypes.ExtractVolumeTypes(pages)
+	if err != nil {
+		return nil, err
+	}
+	for i := range vts {
+		if vts[i].Name == nameOrID {
+			return &vts[i], nil
+		}
+	}
+
+	return nil, cpoerrors.ErrNotFound
+}
+
+// AttachVolume attaches given cinder volume to the compute, with the device tag if not empty
+func (os *OpenStack) AttachVolume(instanceID, volumeID, deviceTag string) (string, error) {
 	computeServiceClient := os.compute
 
 	volume, err := os.GetVolume(volumeID)
@@ -196,19 +260,24 @@ func (os *OpenStack) AttachVolume(instanceID, volumeID string) (string, error) {
 		}
 	}
 
-	if volume.Multiattach {
-		// For multiattach volumes, supported compute api version is 2.60
+	if volume.Multiattach || deviceTag != "" {
+		// For multiattach volumes, supported compute api version is 2.60,
+		// device tags require 2.49
 		// Init a local thread safe copy of the compute ServiceClient
 		computeServiceClient, err = openstack.NewComputeV2(os.compute.ProviderClient, os.epOpts)
 		if err != nil {
 			return "", err
 		}
-		computeServiceClient.Microversion = "2.60"
+		computeServiceClient.Microversion = "2.49"
+		if volume.Multiattach {
+			computeServiceClient.Microversion = "2.60"
+		}
 	}
 
 	mc := metrics.NewMetricContext("volume", "attach")
 	_, err = volumeattach.Create(computeServiceClient, instanceID, &volumeattach.CreateOpts{
 		VolumeID: volume.ID,
+		Tag:      deviceTag,
 	}).Extract()
 
 	if mc.ObserveRequest(err) != nil {
@@ -226,7 +295,7 @@ func (os *OpenStack) WaitDiskAttached(instanceID string, volumeID string) error
 		Steps:    diskAttachSteps,
 	}
 
-	err := wait.ExponentialBackoff(backoff, func() (bool, error) {
+	err := os.waitFor(WaitAttach, backoff, func() (bool, error) {
 		attached, err := os.diskIsAttached(instanceID, volumeID)
 		if err != nil && !cpoerrors.IsNotFound(err) {
 			// if this is a race condition indicate the volume is deleted
@@ -243,15 +312,15 @@ func (os *OpenStack) WaitDiskAttached(instanceID string, volumeID string) error
 	return err
 }
 
-// WaitVolumeTargetStatus waits for volume to be in target state
-func (os *OpenStack) WaitVolumeTargetStatus(volumeID string, tStatus []string) error {
+// WaitVolumeTargetStatus waits for volume to be in target state after the operation
+func (os *OpenStack) WaitVolumeTargetStatus(volumeID string, tStatus []string, op WaitOperation) error {
 	backoff := wait.Backoff{
 		Duration: operationFinishInitDelay,
 		Factor:   operationFinishFactor,
 		Steps:    operationFinishSteps,
 	}
 
-	waitErr := wait.ExponentialBackoff(backoff, func() (bool, error) {
+	waitErr := os.waitFor(op, backoff, func() (bool, error) {
 		vol, err := os.GetVolume(volumeID)
 		if err != nil {
 			return false, err
@@ -316,7 +385,7 @@ func (os *OpenStack) WaitDiskDetached(instanceID string, volumeID string) error
 		Steps:    diskDetachSteps,
 	}
 
-	err := wait.ExponentialBackoff(backoff, func() (bool, error) {
+	err := os.waitFor(WaitDetach, backoff, func() (bool, error) {
 		attached, err := os.diskIsAttached(instanceID, volumeID)
 		if err != nil {
 			return false, err
@@ -387,6 +456,24 @@ func (os *OpenStack) ExpandVolume(volumeID string, status string, newSize int) e
 	return fmt.Errorf("volume cannot be resized, when status is %s", status)
 }
 
+// RetypeVolume changes the volume type of the volume, migrating it to another
+// backend if the new type requires it.
+func (os *OpenStack) RetypeVolume(volumeID string, volumeType string) error {
+	opts := volumeexpand.ChangeTypeOpts{
+		NewType:         volumeType,
+		MigrationPolicy: volumeexpand.MigrationPolicyOnDemand,
+	}
+
+	mc := metrics.NewMetricContext("volume", "retype")
+	return mc.ObserveRequest(volumeexpand.ChangeType(os.blockstorage, volumeID, opts).ExtractErr())
+}
+
+// SetVolumeBootable sets the bootable flag of the volume.
+func (os *OpenStack) SetVolumeBootable(volumeID string, bootable bool) error {
+	mc := metrics.NewMetricContext("volume", "set_bootable")
+	return mc.ObserveRequest(volumeexpand.SetBootable(os.blockstorage, volumeID, volumeexpand.BootableOpts{Bootable: bootable}).ExtractErr())
+}
+
 // GetMaxVolLimit returns max vol limit
 func (os *OpenStack) GetMaxVolLimit() int64 {
 	if os.bsOpts.NodeVolumeAttachLimit > 0 && os.bsOpts.NodeVolumeAttachLimit <= 256 {
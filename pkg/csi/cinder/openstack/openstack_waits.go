@@ -0,0 +1,76 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// WaitOperation is the class of an operation waited for, each class having its own timeout and poll interval.
+type WaitOperation string
+
+const (
+	WaitCreate   WaitOperation = "create"
+	WaitAttach   WaitOperation = "attach"
+	WaitDetach   WaitOperation = "detach"
+	WaitSnapshot WaitOperation = "snapshot"
+	WaitResize   WaitOperation = "resize"
+	WaitRetype   WaitOperation = "retype"
+
+	defaultWaitPollInterval = 2 * time.Second
+)
+
+// waitTimeout returns the timeout and poll interval configured for the class of operation, the timeout being 0 if
+// unset.
+func (opts BlockStorageOpts) waitTimeout(op WaitOperation) (timeout, interval time.Duration) {
+	switch op {
+	case WaitCreate:
+		timeout, interval = opts.CreateTimeout.Duration, opts.CreatePollInterval.Duration
+	case WaitAttach:
+		timeout, interval = opts.AttachTimeout.Duration, opts.AttachPollInterval.Duration
+	case WaitDetach:
+		timeout, interval = opts.DetachTimeout.Duration, opts.DetachPollInterval.Duration
+	case WaitSnapshot:
+		timeout, interval = opts.SnapshotTimeout.Duration, opts.SnapshotPollInterval.Duration
+	case WaitResize:
+		timeout, interval = opts.ResizeTimeout.Duration, opts.ResizePollInterval.Duration
+	case WaitRetype:
+		timeout, interval = opts.RetypeTimeout.Duration, opts.RetypePollInterval.Duration
+	}
+
+	if interval <= 0 {
+		interval = defaultWaitPollInterval
+	}
+	return timeout, interval
+}
+
+// waitFor waits for the condition of the operation, polling at the interval configured for its class until the
+// timeout, or with the default backoff of the operation if no timeout is configured. A timeout is reported as
+// wait.Interrupted.
+func (os *OpenStack) waitFor(op WaitOperation, backoff wait.Backoff, condition wait.ConditionFunc) error {
+	timeout, interval := os.bsOpts.waitTimeout(op)
+	if timeout <= 0 {
+		return wait.ExponentialBackoff(backoff, condition)
+	}
+
+	return wait.PollUntilContextTimeout(context.Background(), interval, timeout, false, func(context.Context) (bool, error) {
+		return condition()
+	})
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cloud-provider-openstack/pkg/util"
+)
+
+func TestWaitTimeout(t *testing.T) {
+	opts := BlockStorageOpts{
+		AttachTimeout:        util.MyDuration{Duration: time.Minute},
+		AttachPollInterval:   util.MyDuration{Duration: 5 * time.Second},
+		SnapshotTimeout:      util.MyDuration{Duration: time.Hour},
+		ResizePollInterval:   util.MyDuration{Duration: 10 * time.Second},
+		DetachPollInterval:   util.MyDuration{Duration: time.Second},
+		CreateTimeout:        util.MyDuration{Duration: 30 * time.Minute},
+		RetypeTimeout:        util.MyDuration{Duration: 2 * time.Hour},
+		SnapshotPollInterval: util.MyDuration{},
+	}
+
+	ts := []struct {
+		op                WaitOperation
+		timeout, interval time.Duration
+	}{
+		{WaitAttach, time.Minute, 5 * time.Second},
+		{WaitSnapshot, time.Hour, defaultWaitPollInterval},
+		{WaitResize, 0, 10 * time.Second},
+		{WaitDetach, 0, time.Second},
+		{WaitCreate, 30 * time.Minute, defaultWaitPollInterval},
+		{WaitRetype, 2 * time.Hour, defaultWaitPollInterval},
+	}
+
+	for _, tc := range ts {
+		timeout, interval := opts.waitTimeout(tc.op)
+		assert.Equal(t, tc.timeout, timeout, string(tc.op))
+		assert.Equal(t, tc.interval, interval, string(tc.op))
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	backoff := wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 3}
+
+	polls := 0
+	os := &OpenStack{}
+	err := os.waitFor(WaitAttach, backoff, func() (bool, error) {
+		polls++
+		return false, nil
+	})
+	assert.True(t, wait.Interrupted(err))
+	assert.Equal(t, 3, polls, "the backoff is used without timeout")
+
+	polls = 0
+	os.bsOpts.AttachTimeout = util.MyDuration{Duration: time.Second}
+	os.bsOpts.AttachPollInterval = util.MyDuration{Duration: time.Millisecond}
+	err = os.waitFor(WaitAttach, backoff, func() (bool, error) {
+		polls++
+		return polls == 5, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, polls, "the backoff steps don't apply with a timeout")
+
+	os.bsOpts.AttachTimeout = util.MyDuration{Duration: 20 * time.Millisecond}
+	err = os.waitFor(WaitAttach, backoff, func() (bool, error) {
+		return false, nil
+	})
+	assert.True(t, wait.Interrupted(err))
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"fmt"
+	"strings"
+)
+
+// cinderCSIForceDeleteKey is the volume metadata key allowing DeleteVolume to
+// delete a volume not owned by the cluster when set to "true"
+const cinderCSIForceDeleteKey = "cinder.csi.openstack.org/force-delete"
+
+// ownerMetadata returns the metadata marking the volumes and snapshots created
+// by the cluster: the cluster ID and the key=value pairs of the
+// cluster-metadata option.
+func ownerMetadata(cluster string, clusterMetadata string) (map[string]string, error) {
+	owner := map[string]string{cinderCSIClusterIDKey: cluster}
+	if clusterMetadata == "" {
+		return owner, nil
+	}
+
+	for _, pair := range strings.Split(clusterMetadata, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid cluster metadata %q, expected key=value", pair)
+		}
+		if key == cinderCSIClusterIDKey {
+			return nil, fmt.Errorf("cluster metadata can't override %s, set with --cluster", cinderCSIClusterIDKey)
+		}
+		owner[key] = value
+	}
+
+	return owner, nil
+}
+
+// isOwned returns true if the volume metadata holds all the owner metadata
+// keys with the values of the cluster. The volumes created by another cluster
+// aren't owned, nor are the volumes missing any of the keys, e.g. created out
+// of band or before the key was added to cluster-metadata.
+func isOwned(volMetadata map[string]string, owner map[string]string) bool {
+	for k, v := range owner {
+		if value, ok := volMetadata[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ownerProperties returns a copy of the owner metadata, to be completed with
+// the metadata of a volume or snapshot to create.
+func (cs *controllerServer) ownerProperties() map[string]string {
+	properties := make(map[string]string, len(cs.owner))
+	for k, v := range cs.owner {
+		properties[k] = v
+	}
+	return properties
+}
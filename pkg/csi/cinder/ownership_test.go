@@ -0,0 +1,159 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+)
+
+// fakeOwnershipCloud returns opts from GetBlockStorageOpts and a volume with
+// metadata from GetVolume, the mock doesn't allow to set them
+type fakeOwnershipCloud struct {
+	*openstack.OpenStackMock
+
+	opts     openstack.BlockStorageOpts
+	metadata map[string]string
+}
+
+func (c fakeOwnershipCloud) GetBlockStorageOpts() openstack.BlockStorageOpts {
+	return c.opts
+}
+
+func (c fakeOwnershipCloud) GetVolume(volumeID string) (*volumes.Volume, error) {
+	return &volumes.Volume{ID: volumeID, Metadata: c.metadata}, nil
+}
+
+func TestNewControllerServerClusterMetadata(t *testing.T) {
+	d := NewDriver(&DriverOpts{Endpoint: FakeEndpoint, ClusterID: FakeCluster})
+
+	cs, err := NewControllerServer(d, fakeOwnershipCloud{OpenStackMock: new(openstack.OpenStackMock), opts: openstack.BlockStorageOpts{ClusterMetadata: "cluster-uid=3f7a"}})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{cinderCSIClusterIDKey: FakeCluster, "cluster-uid": "3f7a"}, cs.owner)
+
+	_, err = NewControllerServer(d, fakeOwnershipCloud{OpenStackMock: new(openstack.OpenStackMock), opts: openstack.BlockStorageOpts{ClusterMetadata: "cluster-uid"}})
+	assert.Error(t, err)
+}
+
+func TestOwnerMetadata(t *testing.T) {
+	owner, err := ownerMetadata("cluster", "")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{cinderCSIClusterIDKey: "cluster"}, owner)
+
+	owner, err = ownerMetadata("cluster", "cluster-uid=3f7a, env=prod")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{cinderCSIClusterIDKey: "cluster", "cluster-uid": "3f7a", "env": "prod"}, owner)
+
+	_, err = ownerMetadata("cluster", "cluster-uid")
+	assert.Error(t, err)
+
+	_, err = ownerMetadata("cluster", cinderCSIClusterIDKey+"=other")
+	assert.Error(t, err)
+}
+
+func TestIsOwned(t *testing.T) {
+	owner := map[string]string{cinderCSIClusterIDKey: "cluster", "cluster-uid": "3f7a"}
+
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		expected bool
+	}{
+		{
+			name:     "all the owner metadata",
+			metadata: map[string]string{cinderCSIClusterIDKey: "cluster", "cluster-uid": "3f7a", "csi.storage.k8s.io/pv/name": "pv"},
+			expected: true,
+		},
+		{
+			name:     "other cluster",
+			metadata: map[string]string{cinderCSIClusterIDKey: "other", "cluster-uid": "3f7a"},
+		},
+		{
+			name:     "other cluster UID",
+			metadata: map[string]string{cinderCSIClusterIDKey: "cluster", "cluster-uid": "9c21"},
+		},
+		{
+			// Created before cluster-uid was added to cluster-metadata
+			name:     "no cluster UID",
+			metadata: map[string]string{cinderCSIClusterIDKey: "cluster"},
+		},
+		{
+			// Created out of band
+			name: "no metadata",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, isOwned(test.metadata, owner))
+		})
+	}
+}
+
+func TestDeleteVolumeProtectUnowned(t *testing.T) {
+	opts := openstack.BlockStorageOpts{ClusterMetadata: "cluster-uid=3f7a", ProtectUnownedVolumes: true}
+
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		code     codes.Code
+	}{
+		{
+			name:     "owned",
+			metadata: map[string]string{cinderCSIClusterIDKey: FakeCluster, "cluster-uid": "3f7a"},
+			code:     codes.OK,
+		},
+		{
+			name:     "other cluster",
+			metadata: map[string]string{cinderCSIClusterIDKey: "other", "cluster-uid": "3f7a"},
+			code:     codes.FailedPrecondition,
+		},
+		{
+			// Created out of band, without any owner metadata
+			name: "unmarked",
+			code: codes.FailedPrecondition,
+		},
+		{
+			name:     "unmarked force-delete",
+			metadata: map[string]string{cinderCSIForceDeleteKey: "true"},
+			code:     codes.OK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cloud := new(openstack.OpenStackMock)
+			cloud.On("DeleteVolume", FakeVolID).Return(nil)
+			d := NewDriver(&DriverOpts{Endpoint: FakeEndpoint, ClusterID: FakeCluster})
+			cs, err := NewControllerServer(d, fakeOwnershipCloud{OpenStackMock: cloud, opts: opts, metadata: test.metadata})
+			assert.NoError(t, err)
+
+			_, err = cs.DeleteVolume(FakeCtx, &csi.DeleteVolumeRequest{VolumeId: FakeVolID})
+			assert.Equal(t, test.code, status.Code(err))
+			if test.code != codes.OK {
+				cloud.AssertNotCalled(t, "DeleteVolume", FakeVolID)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+	utilexec "k8s.io/utils/exec"
+)
+
+const (
+	// StorageClass parameters and volume context keys of the node-local read cache
+	readCacheKey     = "readCache"
+	readCacheSizeKey = "readCacheSize"
+
+	// defaultReadCacheSize is the size of the read cache of a volume when
+	// neither readCacheSize nor read-cache-size is set
+	defaultReadCacheSize = "10Gi"
+
+	// readCacheBlockSectors is the dm-cache block size, 256KiB in 512-byte sectors
+	readCacheBlockSectors = 512
+
+	bytesInMiB = 1024 * 1024
+)
+
+// devMapperDir holds the device-mapper devices, overridden by the tests
+var devMapperDir = "/dev/mapper"
+
+// getReadCacheContext validates the read cache parameters and returns the
+// volume context which instructs the node plugin to set up the cache.
+func getReadCacheContext(params map[string]string) (map[string]string, error) {
+	if v, ok := params[readCacheSizeKey]; ok {
+		if _, err := parseReadCacheSize(v); err != nil {
+			return nil, err
+		}
+	}
+
+	v := params[readCacheKey]
+	if v == "" {
+		return nil, nil
+	}
+
+	enabled, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s parameter %q: %v", readCacheKey, v, err)
+	}
+	if !enabled {
+		return nil, nil
+	}
+
+	volCtx := map[string]string{readCacheKey: "true"}
+	if size := params[readCacheSizeKey]; size != "" {
+		volCtx[readCacheSizeKey] = size
+	}
+
+	return volCtx, nil
+}
+
+// parseReadCacheSize returns the size in bytes of a readCacheSize parameter
+// or of the read-cache-size option.
+func parseReadCacheSize(value string) (int64, error) {
+	q, err := resource.ParseQuantity(value)
+	if err != nil || q.Value() < bytesInMiB {
+		return 0, fmt.Errorf("invalid read cache size %q, must be a quantity of at least 1Mi", value)
+	}
+	return q.Value(), nil
+}
+
+// readCacheNames returns the names of the device-mapper device of the read
+// cache of the volume, and of its logical volumes holding the cached blocks
+// and the dm-cache metadata.
+func readCacheNames(volumeID string) (dmName, dataLV, metaLV string) {
+	dmName = "cinder-cache-" + volumeID
+	return dmName, dmName + "-data", dmName + "-meta"
+}
+
+// readCacheDevicePath returns the device of the read cache of the volume,
+// empty if the volume has no read cache on the node.
+func readCacheDevicePath(volumeID string) string {
+	dmName, _, _ := readCacheNames(volumeID)
+	devicePath := filepath.Join(devMapperDir, dmName)
+	if _, err := os.Stat(devicePath); err != nil {
+		return ""
+	}
+	return devicePath
+}
+
+// readCacheTable returns the device-mapper table of the read cache of the
+// volume. The writethrough mode writes to the origin device before completing
+// the writes, so the cache never holds dirty blocks and can be dropped at any
+// time.
+func readCacheTable(vg, volumeID, originPath string, originSectors int64) string {
+	_, dataLV, metaLV := readCacheNames(volumeID)
+	return fmt.Sprintf("0 %d cache %s %s %s %d 1 writethrough default 0",
+		originSectors, filepath.Join("/dev", vg, metaLV), filepath.Join("/dev", vg, dataLV), originPath, readCacheBlockSectors)
+}
+
+func deviceSectors(exec utilexec.Interface, devicePath string) (int64, error) {
+	out, err := exec.Command("blockdev", "--getsz", devicePath).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("blockdev --getsz %s failed: %v, output: %s", devicePath, err, string(out))
+	}
+
+	sectors, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size of %s %q: %v", devicePath, string(out), err)
+	}
+
+	return sectors, nil
+}
+
+// setupReadCache creates a dm-cache device caching the reads of the origin
+// device of the volume on logical volumes of the volume group vg, typically
+// on a local SSD. It returns the path of the cache device, which is used in
+// place of the origin device.
+func setupReadCache(exec utilexec.Interface, vg, volumeID, originPath string, size int64) (string, error) {
+	dmName, dataLV, metaLV := readCacheNames(volumeID)
+	if devicePath := readCacheDevicePath(volumeID); devicePath != "" {
+		return devicePath, nil
+	}
+
+	originSectors, err := deviceSectors(exec, originPath)
+	if err != nil {
+		return "", err
+	}
+
+	// The cache is never larger than the volume
+	if size > originSectors*512 {
+		size = originSectors * 512
+	}
+	dataMiB := size / bytesInMiB
+	if dataMiB == 0 {
+		dataMiB = 1
+	}
+	// dm-cache needs 4MiB plus 16 bytes per cache block of metadata
+	blocks := dataMiB * bytesInMiB / (readCacheBlockSectors * 512)
+	metaMiB := 4 + (16*blocks+bytesInMiB-1)/bytesInMiB
+
+	// Logical volumes left behind by a failed setup
+	if err := removeReadCacheVolumes(exec, vg, volumeID); err != nil {
+		return "", err
+	}
+
+	for _, lv := range []struct {
+		name string
+		mib  int64
+	}{{dataLV, dataMiB}, {metaLV, metaMiB}} {
+		if out, err := exec.Command("lvcreate", "--yes", "--name", lv.name, "--size", fmt.Sprintf("%dm", lv.mib), vg).CombinedOutput(); err != nil {
+			_ = removeReadCacheVolumes(exec, vg, volumeID)
+			return "", fmt.Errorf("lvcreate %s/%s failed: %v, output: %s", vg, lv.name, err, string(out))
+		}
+	}
+
+	// dm-cache formats a metadata device starting with zeroes
+	metaPath := filepath.Join("/dev", vg, metaLV)
+	if out, err := exec.Command("blkdiscard", "--zeroout", metaPath).CombinedOutput(); err != nil {
+		_ = removeReadCacheVolumes(exec, vg, volumeID)
+		return "", fmt.Errorf("blkdiscard %s failed: %v, output: %s", metaPath, err, string(out))
+	}
+
+	table := readCacheTable(vg, volumeID, originPath, originSectors)
+	if out, err := exec.Command("dmsetup", "create", dmName, "--table", table).CombinedOutput(); err != nil {
+		_ = removeReadCacheVolumes(exec, vg, volumeID)
+		return "", fmt.Errorf("dmsetup create %s failed: %v, output: %s", dmName, err, string(out))
+	}
+
+	klog.V(4).Infof("Created read cache %s of %dMiB for volume %s on device %s", dmName, dataMiB, volumeID, originPath)
+
+	return filepath.Join(devMapperDir, dmName), nil
+}
+
+// resizeReadCache extends the read cache of the volume to the size of its
+// origin device, after the volume was extended.
+func resizeReadCache(exec utilexec.Interface, vg, volumeID, originPath string) error {
+	dmName, _, _ := readCacheNames(volumeID)
+
+	originSectors, err := deviceSectors(exec, originPath)
+	if err != nil {
+		return err
+	}
+
+	table := readCacheTable(vg, volumeID, originPath, originSectors)
+	for _, args := range [][]string{
+		{"reload", dmName, "--table", table},
+		{"suspend", dmName},
+		{"resume", dmName},
+	} {
+		if out, err := exec.Command("dmsetup", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("dmsetup %s %s failed: %v, output: %s", args[0], dmName, err, string(out))
+		}
+	}
+
+	return nil
+}
+
+// teardownReadCache removes the read cache of the volume, if any. The volume
+// must be unmounted.
+func teardownReadCache(exec utilexec.Interface, vg, volumeID string) error {
+	dmName, _, _ := readCacheNames(volumeID)
+	if readCacheDevicePath(volumeID) != "" {
+		if out, err := exec.Command("dmsetup", "remove", "--retry", dmName).CombinedOutput(); err != nil {
+			return fmt.Errorf("dmsetup remove %s failed: %v, output: %s", dmName, err, string(out))
+		}
+		klog.V(4).Infof("Removed read cache %s of volume %s", dmName, volumeID)
+	}
+
+	if vg == "" {
+		return nil
+	}
+
+	return removeReadCacheVolumes(exec, vg, volumeID)
+}
+
+func removeReadCacheVolumes(exec utilexec.Interface, vg, volumeID string) error {
+	_, dataLV, metaLV := readCacheNames(volumeID)
+	for _, lv := range []string{dataLV, metaLV} {
+		out, err := exec.Command("lvremove", "--yes", vg+"/"+lv).CombinedOutput()
+		if err != nil && !strings.Contains(string(out), "Failed to find logical volume") {
+			return fmt.Errorf("lvremove %s/%s failed: %v, output: %s", vg, lv, err, string(out))
+		}
+	}
+	return nil
+}
+
+// stageReadCache sets up the read cache of the volume if its volume context
+// requests one. It returns the device the volume is staged from, the origin
+// device when the node has no read-cache-volume-group.
+func (ns *nodeServer) stageReadCache(volumeID, originPath string, volCtx map[string]string) (string, error) {
+	if volCtx[readCacheKey] != "true" {
+		return originPath, nil
+	}
+
+	opts := ns.Cloud.GetBlockStorageOpts()
+	if opts.ReadCacheVolumeGroup == "" {
+		klog.Warningf("Not caching the reads of volume %s, read-cache-volume-group is not set on the node", volumeID)
+		return originPath, nil
+	}
+
+	sizeValue := volCtx[readCacheSizeKey]
+	if sizeValue == "" {
+		sizeValue = opts.ReadCacheSize
+	}
+	if sizeValue == "" {
+		sizeValue = defaultReadCacheSize
+	}
+	size, err := parseReadCacheSize(sizeValue)
+	if err != nil {
+		return "", err
+	}
+
+	return setupReadCache(ns.Mount.Mounter().Exec, opts.ReadCacheVolumeGroup, volumeID, originPath, size)
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	utilexec "k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+)
+
+type fakeCommand struct {
+	cmd    []string
+	output string
+	err    error
+}
+
+// newFakeExec returns an exec running the commands in order, recording them in cmds
+func newFakeExec(commands []fakeCommand, cmds *[][]string) *testingexec.FakeExec {
+	fakeExec := &testingexec.FakeExec{}
+	for _, c := range commands {
+		c := c
+		fakeExec.CommandScript = append(fakeExec.CommandScript, func(cmd string, args ...string) utilexec.Cmd {
+			*cmds = append(*cmds, append([]string{cmd}, args...))
+			return &testingexec.FakeCmd{
+				CombinedOutputScript: []testingexec.FakeAction{
+					func() ([]byte, []byte, error) { return []byte(c.output), nil, c.err },
+				},
+			}
+		})
+	}
+	return fakeExec
+}
+
+func expectedCommands(commands []fakeCommand) [][]string {
+	var cmds [][]string
+	for _, c := range commands {
+		cmds = append(cmds, c.cmd)
+	}
+	return cmds
+}
+
+func TestGetReadCacheContext(t *testing.T) {
+	volCtx, err := getReadCacheContext(map[string]string{})
+	assert.NoError(t, err)
+	assert.Nil(t, volCtx)
+
+	volCtx, err = getReadCacheContext(map[string]string{readCacheKey: "false", readCacheSizeKey: "1Gi"})
+	assert.NoError(t, err)
+	assert.Nil(t, volCtx)
+
+	volCtx, err = getReadCacheContext(map[string]string{readCacheKey: "true"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{readCacheKey: "true"}, volCtx)
+
+	volCtx, err = getReadCacheContext(map[string]string{readCacheKey: "true", readCacheSizeKey: "20Gi"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{readCacheKey: "true", readCacheSizeKey: "20Gi"}, volCtx)
+
+	_, err = getReadCacheContext(map[string]string{readCacheKey: "sure"})
+	assert.Error(t, err)
+
+	_, err = getReadCacheContext(map[string]string{readCacheKey: "true", readCacheSizeKey: "1Ki"})
+	assert.Error(t, err)
+}
+
+func TestSetupReadCache(t *testing.T) {
+	devMapperDir = t.TempDir()
+	defer func() { devMapperDir = "/dev/mapper" }()
+
+	notFound := errors.New("exit status 5")
+	commands := []fakeCommand{
+		{cmd: []string{"blockdev", "--getsz", "/dev/vdb"}, output: "4194304\n"},
+		{cmd: []string{"lvremove", "--yes", "cache/cinder-cache-vol-1-data"}, output: `Failed to find logical volume "cache/cinder-cache-vol-1-data"`, err: notFound},
+		{cmd: []string{"lvremove", "--yes", "cache/cinder-cache-vol-1-meta"}, output: `Failed to find logical volume "cache/cinder-cache-vol-1-meta"`, err: notFound},
+		// The 10GiB cache is limited to the 2GiB of the volume
+		{cmd: []string{"lvcreate", "--yes", "--name", "cinder-cache-vol-1-data", "--size", "2048m", "cache"}},
+		{cmd: []string{"lvcreate", "--yes", "--name", "cinder-cache-vol-1-meta", "--size", "5m", "cache"}},
+		{cmd: []string{"blkdiscard", "--zeroout", "/dev/cache/cinder-cache-vol-1-meta"}},
+		{cmd: []string{"dmsetup", "create", "cinder-cache-vol-1", "--table", "0 4194304 cache /dev/cache/cinder-cache-vol-1-meta /dev/cache/cinder-cache-vol-1-data /dev/vdb 512 1 writethrough default 0"}},
+	}
+
+	var cmds [][]string
+	devicePath, err := setupReadCache(newFakeExec(commands, &cmds), "cache", "vol-1", "/dev/vdb", 10*1024*1024*1024)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(devMapperDir, "cinder-cache-vol-1"), devicePath)
+	assert.Equal(t, expectedCommands(commands), cmds)
+
+	// An existing cache is reused
+	assert.NoError(t, os.WriteFile(devicePath, nil, 0600))
+	cmds = nil
+	devicePath, err = setupReadCache(newFakeExec(nil, &cmds), "cache", "vol-1", "/dev/vdb", 10*1024*1024*1024)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(devMapperDir, "cinder-cache-vol-1"), devicePath)
+	assert.Empty(t, cmds)
+}
+
+func TestSetupReadCacheFailure(t *testing.T) {
+	devMapperDir = t.TempDir()
+	defer func() { devMapperDir = "/dev/mapper" }()
+
+	commands := []fakeCommand{
+		{cmd: []string{"blockdev", "--getsz", "/dev/vdb"}, output: "41943040"},
+		{cmd: []string{"lvremove", "--yes", "cache/cinder-cache-vol-1-data"}},
+		{cmd: []string{"lvremove", "--yes", "cache/cinder-cache-vol-1-meta"}},
+		{cmd: []string{"lvcreate", "--yes", "--name", "cinder-cache-vol-1-data", "--size", "1024m", "cache"}, output: "Insufficient free space", err: errors.New("exit status 5")},
+		// The logical volumes are cleaned up
+		{cmd: []string{"lvremove", "--yes", "cache/cinder-cache-vol-1-data"}},
+		{cmd: []string{"lvremove", "--yes", "cache/cinder-cache-vol-1-meta"}},
+	}
+
+	var cmds [][]string
+	_, err := setupReadCache(newFakeExec(commands, &cmds), "cache", "vol-1", "/dev/vdb", 1024*1024*1024)
+	assert.ErrorContains(t, err, "Insufficient free space")
+	assert.Equal(t, expectedCommands(commands), cmds)
+}
+
+func TestResizeReadCache(t *testing.T) {
+	table := "0 8388608 cache /dev/cache/cinder-cache-vol-1-meta /dev/cache/cinder-cache-vol-1-data /dev/vdb 512 1 writethrough default 0"
+	commands := []fakeCommand{
+		{cmd: []string{"blockdev", "--getsz", "/dev/vdb"}, output: "8388608"},
+		{cmd: []string{"dmsetup", "reload", "cinder-cache-vol-1", "--table", table}},
+		{cmd: []string{"dmsetup", "suspend", "cinder-cache-vol-1"}},
+		{cmd: []string{"dmsetup", "resume", "cinder-cache-vol-1"}},
+	}
+
+	var cmds [][]string
+	assert.NoError(t, resizeReadCache(newFakeExec(commands, &cmds), "cache", "vol-1", "/dev/vdb"))
+	assert.Equal(t, expectedCommands(commands), cmds)
+}
+
+func TestTeardownReadCache(t *testing.T) {
+	devMapperDir = t.TempDir()
+	defer func() { devMapperDir = "/dev/mapper" }()
+
+	// No cache, no volume group
+	var cmds [][]string
+	assert.NoError(t, teardownReadCache(newFakeExec(nil, &cmds), "", "vol-1"))
+	assert.Empty(t, cmds)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(devMapperDir, "cinder-cache-vol-1"), nil, 0600))
+	commands := []fakeCommand{
+		{cmd: []string{"dmsetup", "remove", "--retry", "cinder-cache-vol-1"}},
+		{cmd: []string{"lvremove", "--yes", "cache/cinder-cache-vol-1-data"}},
+		{cmd: []string{"lvremove", "--yes", "cache/cinder-cache-vol-1-meta"}},
+	}
+	assert.NoError(t, teardownReadCache(newFakeExec(commands, &cmds), "cache", "vol-1"))
+	assert.Equal(t, expectedCommands(commands), cmds)
+
+	// Only an unknown logical volume is ignored
+	commands = []fakeCommand{
+		{cmd: []string{"dmsetup", "remove", "--retry", "cinder-cache-vol-1"}},
+		{cmd: []string{"lvremove", "--yes", "cache/cinder-cache-vol-1-data"}, output: "Logical volume in use", err: errors.New("exit status 5")},
+	}
+	cmds = nil
+	assert.ErrorContains(t, teardownReadCache(newFakeExec(commands, &cmds), "cache", "vol-1"), "in use")
+}
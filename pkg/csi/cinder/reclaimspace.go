@@ -0,0 +1,67 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"k8s.io/cloud-provider-openstack/pkg/util/mount"
+	"k8s.io/klog/v2"
+)
+
+// stagedFilesystems returns the staging paths of the filesystems of the
+// volumes of the driver mounted read-write on the node, i.e. the
+// <kubelet dir>/plugins/kubernetes.io/csi/<driver>/<hash>/globalmount
+// directories.
+func stagedFilesystems(m mount.IMount, driverName string) ([]string, error) {
+	mountPoints, err := m.Mounter().List()
+	if err != nil {
+		return nil, err
+	}
+
+	dir := string(filepath.Separator) + filepath.Join("plugins", "kubernetes.io", "csi", driverName) + string(filepath.Separator)
+	var paths []string
+	for _, mp := range mountPoints {
+		if !strings.Contains(mp.Path, dir) || filepath.Base(mp.Path) != "globalmount" || slices.Contains(mp.Opts, "ro") {
+			continue
+		}
+		paths = append(paths, mp.Path)
+	}
+
+	return paths, nil
+}
+
+// reclaimSpace runs fstrim on the staged filesystems, so that the space of the
+// deleted files is given back to thin-provisioned backends. The block volumes
+// are left alone: blkdiscard discards the whole device, not its free space.
+func reclaimSpace(m mount.IMount, driverName string) {
+	paths, err := stagedFilesystems(m, driverName)
+	if err != nil {
+		klog.Errorf("Failed to list the staged filesystems to reclaim their space: %v", err)
+		return
+	}
+
+	for _, path := range paths {
+		klog.V(4).Infof("Reclaiming the space of the filesystem staged at %s", path)
+		out, err := m.Mounter().Exec.Command("fstrim", path).CombinedOutput()
+		if err != nil {
+			klog.Warningf("fstrim %s failed: %v, output: %s", path, err, string(out))
+		}
+	}
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/cloud-provider-openstack/pkg/util/mount"
+	mountutil "k8s.io/mount-utils"
+)
+
+func TestReclaimSpace(t *testing.T) {
+	staged := "/var/lib/kubelet/plugins/kubernetes.io/csi/" + driverName + "/0123abcd/globalmount"
+	failing := "/var/lib/kubelet/plugins/kubernetes.io/csi/" + driverName + "/4567cdef/globalmount"
+	mounter := mount.NewFakeMounter()
+	mounter.MountPoints = []mountutil.MountPoint{
+		{Device: "/dev/vdb", Path: staged, Type: "ext4", Opts: []string{"rw"}},
+		{Device: "/dev/vdc", Path: failing, Type: "xfs"},
+		{Device: "/dev/vdd", Path: "/var/lib/kubelet/plugins/kubernetes.io/csi/" + driverName + "/89abef01/globalmount", Type: "ext4", Opts: []string{"ro"}},
+		{Device: "/dev/vde", Path: "/var/lib/kubelet/plugins/kubernetes.io/csi/other.csi.k8s.io/0123abcd/globalmount", Type: "ext4"},
+		{Device: "/dev/vdb", Path: "/var/lib/kubelet/pods/pod/volumes/kubernetes.io~csi/pv/mount", Type: "ext4"},
+	}
+
+	commands := []fakeCommand{
+		{cmd: []string{"fstrim", staged}},
+		{cmd: []string{"fstrim", failing}, err: errors.New("not supported")},
+	}
+	var cmds [][]string
+	m := &sharedMountMock{
+		MountMock: new(mount.MountMock),
+		mounter:   &mountutil.SafeFormatAndMount{Interface: mounter, Exec: newFakeExec(commands, &cmds)},
+	}
+
+	paths, err := stagedFilesystems(m, driverName)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{staged, failing}, paths)
+
+	// A failing fstrim doesn't stop the others
+	reclaimSpace(m, driverName)
+	assert.Equal(t, expectedCommands(commands), cmds)
+}
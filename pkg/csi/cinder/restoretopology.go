@@ -0,0 +1,77 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"slices"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/snapshots"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+// snapshotRestoreAvailability returns the availability zone to restore the
+// snapshot in: Cinder creates the volumes restored from a snapshot in the
+// availability zone of its source volume. It's empty if the source volume is
+// unknown, the zone from the topology is then used.
+func snapshotRestoreAvailability(cloud openstack.IOpenStack, snap *snapshots.Snapshot, requirement *csi.TopologyRequirement) (string, error) {
+	source, err := cloud.GetVolume(snap.VolumeID)
+	if err != nil {
+		if cpoerrors.IsNotFound(err) {
+			klog.V(4).Infof("Source volume %s of snapshot %s not found, restoring it in the availability zone of the topology", snap.VolumeID, snap.ID)
+			return "", nil
+		}
+		return "", status.Errorf(codes.Internal, "failed to retrieve the source volume %s of snapshot %s: %v", snap.VolumeID, snap.ID, err)
+	}
+
+	return restoreZone(snap.ID, source.AvailabilityZone, requirement)
+}
+
+// restoreZone returns the availability zone of the snapshot if the topology
+// requirement allows it. Otherwise, e.g. if the node selected for a
+// WaitForFirstConsumer volume is in another zone, ResourceExhausted is
+// returned, for which the external-provisioner has the pod rescheduled
+// instead of retrying the provisioning on the same node.
+func restoreZone(snapshotID, zone string, requirement *csi.TopologyRequirement) (string, error) {
+	if zone == "" {
+		return "", nil
+	}
+
+	// The requisite topologies are the allowed ones, the preferred ones are
+	// a subset of them
+	topologies := requirement.GetRequisite()
+	if len(topologies) == 0 {
+		topologies = requirement.GetPreferred()
+	}
+
+	var zones []string
+	for _, topology := range topologies {
+		if z, ok := topology.GetSegments()[topologyKey]; ok {
+			zones = append(zones, z)
+		}
+	}
+	if len(zones) > 0 && !slices.Contains(zones, zone) {
+		return "", status.Errorf(codes.ResourceExhausted, "snapshot %s can only be restored in availability zone %s, which is not allowed by the topology requirement %v", snapshotID, zone, zones)
+	}
+
+	return zone, nil
+}
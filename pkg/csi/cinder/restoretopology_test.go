@@ -0,0 +1,112 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func zoneTopology(zones ...string) []*csi.Topology {
+	var topologies []*csi.Topology
+	for _, zone := range zones {
+		topologies = append(topologies, &csi.Topology{Segments: map[string]string{topologyKey: zone}})
+	}
+	return topologies
+}
+
+func TestRestoreZone(t *testing.T) {
+	tests := []struct {
+		name        string
+		zone        string
+		requirement *csi.TopologyRequirement
+		expected    string
+		code        codes.Code
+	}{
+		{
+			name:        "selected node in the snapshot zone",
+			zone:        "az-1",
+			requirement: &csi.TopologyRequirement{Requisite: zoneTopology("az-1"), Preferred: zoneTopology("az-1")},
+			expected:    "az-1",
+		},
+		{
+			name:        "snapshot zone allowed but not preferred",
+			zone:        "az-2",
+			requirement: &csi.TopologyRequirement{Requisite: zoneTopology("az-1", "az-2"), Preferred: zoneTopology("az-1", "az-2")},
+			expected:    "az-2",
+		},
+		{
+			name:        "selected node in another zone",
+			zone:        "az-2",
+			requirement: &csi.TopologyRequirement{Requisite: zoneTopology("az-1"), Preferred: zoneTopology("az-1")},
+			code:        codes.ResourceExhausted,
+		},
+		{
+			name:        "only preferred topologies",
+			zone:        "az-2",
+			requirement: &csi.TopologyRequirement{Preferred: zoneTopology("az-1")},
+			code:        codes.ResourceExhausted,
+		},
+		{
+			name:        "topology without zone",
+			zone:        "az-2",
+			requirement: &csi.TopologyRequirement{Requisite: []*csi.Topology{{Segments: map[string]string{"other": "value"}}}},
+			expected:    "az-2",
+		},
+		{
+			name:        "unknown snapshot zone",
+			requirement: &csi.TopologyRequirement{Requisite: zoneTopology("az-1")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zone, err := restoreZone(FakeSnapshotID, tt.zone, tt.requirement)
+			assert.Equal(t, tt.code, status.Code(err))
+			assert.Equal(t, tt.expected, zone)
+		})
+	}
+}
+
+func TestCreateVolumeFromSnapshotInAnotherZone(t *testing.T) {
+	osmock.On("GetVolumesByName", FakeVolName).Return(FakeVolListEmpty, nil)
+
+	// The source volume of the snapshot is in the nova zone
+	_, err := fakeCs.CreateVolume(FakeCtx, &csi.CreateVolumeRequest{
+		Name: FakeVolName,
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			},
+		},
+		VolumeContentSource: &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{
+					SnapshotId: FakeSnapshotID,
+				},
+			},
+		},
+		AccessibilityRequirements: &csi.TopologyRequirement{Requisite: zoneTopology("az-1"), Preferred: zoneTopology("az-1")},
+	})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
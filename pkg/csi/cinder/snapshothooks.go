@@ -0,0 +1,199 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/klog/v2"
+)
+
+// SnapshotHooksOpts configures the snapshot hooks: commands run in the pods
+// using a volume before and after taking its snapshots, e.g. to quiesce a
+// database for application-consistent snapshots. The commands are set in the
+// annotations of the pods.
+type SnapshotHooksOpts struct {
+	Enabled bool
+	// Timeout of each hook command
+	Timeout time.Duration
+	// KubeClient and RESTConfig are used to find the pods and exec the hooks
+	KubeClient kubernetes.Interface
+	RESTConfig *rest.Config
+}
+
+const (
+	// The hook commands are run with /bin/sh -c in the container of the
+	// snapshotHookContainerAnnotation, the first container of the pod by
+	// default.
+	preSnapshotHookAnnotation       = "cinder.csi.openstack.org/pre-snapshot-hook"
+	postSnapshotHookAnnotation      = "cinder.csi.openstack.org/post-snapshot-hook"
+	snapshotHookContainerAnnotation = "cinder.csi.openstack.org/snapshot-hook-container"
+)
+
+// podExecutor runs the command in the container of the pod and returns its
+// output.
+type podExecutor func(ctx context.Context, pod *v1.Pod, container string, command []string) (string, error)
+
+type snapshotHooks struct {
+	kubeClient kubernetes.Interface
+	exec       podExecutor
+	timeout    time.Duration
+}
+
+func newSnapshotHooks(opts SnapshotHooksOpts) *snapshotHooks {
+	return &snapshotHooks{
+		kubeClient: opts.KubeClient,
+		exec:       newPodExecutor(opts.KubeClient, opts.RESTConfig),
+		timeout:    opts.Timeout,
+	}
+}
+
+func newPodExecutor(kubeClient kubernetes.Interface, config *rest.Config) podExecutor {
+	return func(ctx context.Context, pod *v1.Pod, container string, command []string) (string, error) {
+		req := kubeClient.CoreV1().RESTClient().Post().
+			Resource("pods").
+			Namespace(pod.Namespace).
+			Name(pod.Name).
+			SubResource("exec").
+			VersionedParams(&v1.PodExecOptions{
+				Container: container,
+				Command:   command,
+				Stdout:    true,
+				Stderr:    true,
+			}, scheme.ParameterCodec)
+
+		executor, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+		if err != nil {
+			return "", err
+		}
+
+		var out bytes.Buffer
+		err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{Stdout: &out, Stderr: &out})
+		return out.String(), err
+	}
+}
+
+// hookedPods returns the running pods using the volume which have snapshot
+// hooks.
+func (h *snapshotHooks) hookedPods(ctx context.Context, volumeID string) ([]*v1.Pod, error) {
+	pvs, err := h.kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list PersistentVolumes: %v", err)
+	}
+
+	var claim *v1.ObjectReference
+	for _, pv := range pvs.Items {
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == driverName && pv.Spec.CSI.VolumeHandle == volumeID {
+			claim = pv.Spec.ClaimRef
+			break
+		}
+	}
+	if claim == nil {
+		return nil, nil
+	}
+
+	pods, err := h.kubeClient.CoreV1().Pods(claim.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods in namespace %s: %v", claim.Namespace, err)
+	}
+
+	var hooked []*v1.Pod
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase != v1.PodRunning {
+			continue
+		}
+		if pod.Annotations[preSnapshotHookAnnotation] == "" && pod.Annotations[postSnapshotHookAnnotation] == "" {
+			continue
+		}
+		for _, vol := range pod.Spec.Volumes {
+			if vol.PersistentVolumeClaim != nil && vol.PersistentVolumeClaim.ClaimName == claim.Name {
+				hooked = append(hooked, pod)
+				break
+			}
+		}
+	}
+
+	return hooked, nil
+}
+
+func (h *snapshotHooks) runHook(ctx context.Context, pod *v1.Pod, annotation string) error {
+	hook := pod.Annotations[annotation]
+	if hook == "" {
+		return nil
+	}
+
+	container := pod.Annotations[snapshotHookContainerAnnotation]
+	if container == "" && len(pod.Spec.Containers) > 0 {
+		container = pod.Spec.Containers[0].Name
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	klog.V(4).Infof("Running %s of pod %s/%s in container %s", annotation, pod.Namespace, pod.Name, container)
+	out, err := h.exec(ctx, pod, container, []string{"/bin/sh", "-c", hook})
+	if err != nil {
+		return fmt.Errorf("%s of pod %s/%s failed: %v, output: %s", annotation, pod.Namespace, pod.Name, err, strings.TrimSpace(out))
+	}
+
+	return nil
+}
+
+// run runs the pre-snapshot hooks of the pods using the volume and returns a
+// function running their post-snapshot hooks, to be called once the snapshot
+// is taken. If a pre-snapshot hook fails, the post-snapshot hooks of the pods
+// already quiesced are run before returning the error.
+func (h *snapshotHooks) run(ctx context.Context, volumeID string) (func(), error) {
+	pods, err := h.hookedPods(ctx, volumeID)
+	if err != nil {
+		return nil, err
+	}
+
+	var quiesced []*v1.Pod
+	release := func() {
+		// The post-snapshot hooks must run even if the request is cancelled
+		ctx := context.Background()
+		for _, pod := range quiesced {
+			if err := h.runHook(ctx, pod, postSnapshotHookAnnotation); err != nil {
+				klog.Errorf("Failed to run the post-snapshot hook of volume %s: %v", volumeID, err)
+			}
+		}
+	}
+
+	for _, pod := range pods {
+		// The post-snapshot hook runs even if the pre-snapshot one fails, as
+		// it may have partially quiesced the application
+		quiesced = append(quiesced, pod)
+		if err := h.runHook(ctx, pod, preSnapshotHookAnnotation); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	return release, nil
+}
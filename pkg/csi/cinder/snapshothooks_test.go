@@ -0,0 +1,131 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func hookedPod(name, claim string, phase v1.PodPhase, annotations map[string]string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "db", Annotations: annotations},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "postgres"}, {Name: "exporter"}},
+			Volumes: []v1.Volume{{
+				Name: "data",
+				VolumeSource: v1.VolumeSource{
+					PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+				},
+			}},
+		},
+		Status: v1.PodStatus{Phase: phase},
+	}
+}
+
+func fakeSnapshotHooks(failing string, objects ...runtime.Object) (*snapshotHooks, *[]string) {
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-data"},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{Driver: driverName, VolumeHandle: FakeVolID},
+			},
+			ClaimRef: &v1.ObjectReference{Namespace: "db", Name: "data"},
+		},
+	}
+
+	var calls []string
+	hooks := &snapshotHooks{
+		kubeClient: fake.NewSimpleClientset(append(objects, pv)...),
+		timeout:    time.Second,
+		exec: func(ctx context.Context, pod *v1.Pod, container string, command []string) (string, error) {
+			calls = append(calls, fmt.Sprintf("%s/%s: %s", pod.Name, container, command[2]))
+			if command[2] == failing {
+				return "frozen already", fmt.Errorf("exit 1")
+			}
+			return "", nil
+		},
+	}
+	return hooks, &calls
+}
+
+func TestSnapshotHooks(t *testing.T) {
+	annotations := map[string]string{
+		preSnapshotHookAnnotation:  "freeze",
+		postSnapshotHookAnnotation: "thaw",
+	}
+	hooks, calls := fakeSnapshotHooks("",
+		hookedPod("db-0", "data", v1.PodRunning, annotations),
+		hookedPod("db-1", "data", v1.PodPending, annotations),
+		hookedPod("other", "other", v1.PodRunning, annotations),
+		hookedPod("no-hooks", "data", v1.PodRunning, nil),
+	)
+
+	release, err := hooks.run(context.Background(), FakeVolID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"db-0/postgres: freeze"}, *calls)
+
+	release()
+	assert.Equal(t, []string{"db-0/postgres: freeze", "db-0/postgres: thaw"}, *calls)
+}
+
+func TestSnapshotHooksContainer(t *testing.T) {
+	hooks, calls := fakeSnapshotHooks("",
+		hookedPod("db-0", "data", v1.PodRunning, map[string]string{
+			postSnapshotHookAnnotation:      "thaw",
+			snapshotHookContainerAnnotation: "exporter",
+		}),
+	)
+
+	release, err := hooks.run(context.Background(), FakeVolID)
+	assert.NoError(t, err)
+	assert.Empty(t, *calls)
+
+	release()
+	assert.Equal(t, []string{"db-0/exporter: thaw"}, *calls)
+}
+
+func TestSnapshotHooksFailure(t *testing.T) {
+	hooks, calls := fakeSnapshotHooks("freeze",
+		hookedPod("db-0", "data", v1.PodRunning, map[string]string{
+			preSnapshotHookAnnotation:  "freeze",
+			postSnapshotHookAnnotation: "thaw",
+		}),
+	)
+
+	_, err := hooks.run(context.Background(), FakeVolID)
+	assert.ErrorContains(t, err, "frozen already")
+	// The pod is thawed even if the pre-snapshot hook failed
+	assert.Equal(t, []string{"db-0/postgres: freeze", "db-0/postgres: thaw"}, *calls)
+}
+
+func TestSnapshotHooksUnknownVolume(t *testing.T) {
+	hooks, calls := fakeSnapshotHooks("")
+
+	release, err := hooks.run(context.Background(), "unknown")
+	assert.NoError(t, err)
+	release()
+	assert.Empty(t, *calls)
+}
@@ -0,0 +1,379 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+// VolumeTransferOpts configures the volume transfers: the Cinder volume of a
+// PersistentVolume annotated by an administrator is transferred to another
+// OpenStack project, e.g. when a namespace moves to another tenant, without
+// copying its data.
+type VolumeTransferOpts struct {
+	Enabled bool
+	// KubeClient is used to watch the PersistentVolumes, read the credentials
+	// of the target projects and create the replacement PersistentVolumes
+	KubeClient kubernetes.Interface
+}
+
+const (
+	// transferSecretAnnotation is the <namespace>/<name> of the Secret holding
+	// the cloud config of the target project, in its cloud.conf key
+	transferSecretAnnotation = "cinder.csi.openstack.org/transfer-secret"
+	// transferDriverAnnotation is the driver of the replacement
+	// PersistentVolume, e.g. the one of the CSI driver deployed with the
+	// credentials of the target project
+	transferDriverAnnotation  = "cinder.csi.openstack.org/transfer-driver"
+	transferStatusAnnotation  = "cinder.csi.openstack.org/transfer-status"
+	transferMessageAnnotation = "cinder.csi.openstack.org/transfer-message"
+	transferredFromAnnotation = "cinder.csi.openstack.org/transferred-from"
+
+	transferSecretKey = "cloud.conf"
+
+	transferStatusPending     = "pending"
+	transferStatusTransferred = "transferred"
+	transferStatusFailed      = "failed"
+
+	transferredPVSuffix = "-transferred"
+
+	transferWorkers = 1
+	// transferResync re-evaluates the pending transfers, e.g. of the volumes
+	// still attached when they were annotated
+	transferResync = 10 * time.Minute
+
+	transferRetryBaseDelay = 5 * time.Second
+	transferRetryMaxDelay  = 5 * time.Minute
+	transferMaxRetries     = 10
+)
+
+// errInvalidTransferSecret is returned for the transfer secrets which can't be used until the PersistentVolume
+// annotation or the Secret is fixed, unlike the errors of the API server or OpenStack which are retried.
+var errInvalidTransferSecret = errors.New("invalid transfer secret")
+
+type volumeTransfer struct {
+	cloud      openstack.IOpenStack
+	kubeClient kubernetes.Interface
+
+	// newCloud creates the client of the target project from its cloud config
+	newCloud func(data []byte) (openstack.IOpenStack, error)
+
+	pvInformer cache.SharedIndexInformer
+	queue      workqueue.RateLimitingInterface
+}
+
+func newVolumeTransfer(cloud openstack.IOpenStack, opts VolumeTransferOpts) *volumeTransfer {
+	factory := informers.NewSharedInformerFactory(opts.KubeClient, transferResync)
+
+	t := &volumeTransfer{
+		cloud:      cloud,
+		kubeClient: opts.KubeClient,
+		newCloud:   openstack.NewOpenStackFromConfig,
+		pvInformer: factory.Core().V1().PersistentVolumes().Informer(),
+		queue:      workqueue.NewNamedRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(transferRetryBaseDelay, transferRetryMaxDelay), "cinder-csi-volume-transfer"),
+	}
+
+	_, err := t.pvInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    t.enqueuePV,
+		UpdateFunc: func(_, obj interface{}) { t.enqueuePV(obj) },
+	})
+	if err != nil {
+		klog.Fatalf("Failed to add the PersistentVolume event handler of the volume transfers: %v", err)
+	}
+
+	return t
+}
+
+// Run starts watching the PersistentVolumes and the workers.
+func (t *volumeTransfer) Run(stopCh <-chan struct{}) {
+	klog.Info("Transferring the volumes of the PersistentVolumes annotated with ", transferSecretAnnotation)
+
+	go t.pvInformer.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, t.pvInformer.HasSynced) {
+		klog.Error("Failed to sync the PersistentVolumes of the volume transfers")
+		return
+	}
+
+	for i := 0; i < transferWorkers; i++ {
+		go wait.Until(t.runWorker, time.Second, stopCh)
+	}
+
+	go func() {
+		<-stopCh
+		t.queue.ShutDown()
+	}()
+}
+
+// transferRequested returns whether the volume of the PersistentVolume is to be transferred.
+func transferRequested(pv *v1.PersistentVolume) bool {
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != driverName || pv.Annotations[transferSecretAnnotation] == "" {
+		return false
+	}
+	status := pv.Annotations[transferStatusAnnotation]
+	return status != transferStatusTransferred && status != transferStatusFailed
+}
+
+func (t *volumeTransfer) enqueuePV(obj interface{}) {
+	pv, ok := obj.(*v1.PersistentVolume)
+	if !ok || !transferRequested(pv) {
+		return
+	}
+	t.queue.Add(pv.Name)
+}
+
+func (t *volumeTransfer) runWorker() {
+	for t.processNextItem() {
+	}
+}
+
+func (t *volumeTransfer) processNextItem() bool {
+	item, quit := t.queue.Get()
+	if quit {
+		return false
+	}
+	defer t.queue.Done(item)
+
+	name := item.(string)
+	if err := t.sync(name); err != nil {
+		// The transfer is retried at the next resync after the last attempt
+		if t.queue.NumRequeues(item) < transferMaxRetries {
+			klog.V(4).Infof("Failed to transfer the volume of PersistentVolume %s, will retry: %v", name, err)
+			t.queue.AddRateLimited(item)
+			return true
+		}
+		klog.Warningf("Failed to transfer the volume of PersistentVolume %s: %v", name, err)
+	}
+
+	t.queue.Forget(item)
+	return true
+}
+
+func (t *volumeTransfer) sync(name string) error {
+	obj, exists, err := t.pvInformer.GetStore().GetByKey(name)
+	if err != nil || !exists {
+		return err
+	}
+	pv := obj.(*v1.PersistentVolume)
+	if !transferRequested(pv) {
+		return nil
+	}
+
+	return t.transfer(context.Background(), pv)
+}
+
+// transfer transfers the volume of the PersistentVolume to the project of its transfer secret. The volume keeps its
+// ID, so that only the PersistentVolume using it from the target project has to be created.
+func (t *volumeTransfer) transfer(ctx context.Context, pv *v1.PersistentVolume) error {
+	volumeID := pv.Spec.CSI.VolumeHandle
+
+	target, err := t.targetCloud(ctx, pv.Annotations[transferSecretAnnotation])
+	if errors.Is(err, errInvalidTransferSecret) {
+		return t.fail(ctx, pv, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The volume must not be deleted with the PersistentVolume once it is no longer in the project of the driver
+	if pv.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimRetain {
+		patch := []byte(fmt.Sprintf(`{"spec":{"persistentVolumeReclaimPolicy":%q}}`, v1.PersistentVolumeReclaimRetain))
+		if _, err := t.kubeClient.CoreV1().PersistentVolumes().Patch(ctx, pv.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return fmt.Errorf("failed to retain PersistentVolume %s: %v", pv.Name, err)
+		}
+	}
+
+	vol, err := t.cloud.GetVolume(volumeID)
+	if err != nil {
+		if !cpoerrors.IsNotFound(err) {
+			return err
+		}
+		// The transfer may have been accepted before the PersistentVolume could be annotated
+		if _, err := target.GetVolume(volumeID); err != nil {
+			return t.fail(ctx, pv, fmt.Errorf("volume %s not found: %v", volumeID, err))
+		}
+		return t.complete(ctx, pv)
+	}
+
+	if vol.Status != openstack.VolumeAvailableStatus {
+		msg := fmt.Sprintf("volume %s is %s, it is transferred once it is detached", volumeID, vol.Status)
+		if err := t.setStatus(ctx, pv, transferStatusPending, msg); err != nil {
+			return err
+		}
+		return fmt.Errorf("%s", msg)
+	}
+
+	// Only one transfer of a volume can be pending, e.g. one created before a restart of the driver
+	stale, err := t.cloud.ListTransfers(volumeID)
+	if err != nil {
+		return err
+	}
+	for _, tr := range stale {
+		if err := t.cloud.DeleteTransfer(tr.ID); err != nil && !cpoerrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	tr, err := t.cloud.CreateTransfer(volumeID, "cinder-csi-"+pv.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := target.AcceptTransfer(tr.ID, tr.AuthKey); err != nil {
+		if err := t.cloud.DeleteTransfer(tr.ID); err != nil && !cpoerrors.IsNotFound(err) {
+			klog.Errorf("Failed to delete transfer %s of volume %s: %v", tr.ID, volumeID, err)
+		}
+		return t.fail(ctx, pv, fmt.Errorf("failed to accept transfer of volume %s: %v", volumeID, err))
+	}
+
+	klog.Infof("Transferred volume %s of PersistentVolume %s to project of secret %s", volumeID, pv.Name, pv.Annotations[transferSecretAnnotation])
+
+	return t.complete(ctx, pv)
+}
+
+// targetCloud returns the client of the project of the secret. The errors of an invalid reference or secret wrap
+// errInvalidTransferSecret.
+func (t *volumeTransfer) targetCloud(ctx context.Context, secretRef string) (openstack.IOpenStack, error) {
+	namespace, name, ok := strings.Cut(secretRef, "/")
+	if !ok || namespace == "" || name == "" {
+		return nil, fmt.Errorf("%w: %s %q must be <namespace>/<name>", errInvalidTransferSecret, transferSecretAnnotation, secretRef)
+	}
+
+	secret, err := t.kubeClient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s: %v", secretRef, err)
+	}
+	data, ok := secret.Data[transferSecretKey]
+	if !ok {
+		return nil, fmt.Errorf("%w: secret %s has no %s key", errInvalidTransferSecret, secretRef, transferSecretKey)
+	}
+
+	target, err := t.newCloud(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the client of the project of secret %s: %v", secretRef, err)
+	}
+
+	return target, nil
+}
+
+// complete creates the replacement PersistentVolume if requested and marks the transfer done.
+func (t *volumeTransfer) complete(ctx context.Context, pv *v1.PersistentVolume) error {
+	if driver := pv.Annotations[transferDriverAnnotation]; driver != "" {
+		if err := t.createReplacement(ctx, pv, driver); err != nil {
+			return err
+		}
+	}
+
+	return t.setStatus(ctx, pv, transferStatusTransferred, "")
+}
+
+// createReplacement creates the PersistentVolume of the transferred volume for the driver of the target project.
+// The source of a PersistentVolume is immutable, hence the new object. It is pre-bound to the claim only by name, so
+// that the claim recreated in the new namespace layout binds to it.
+func (t *volumeTransfer) createReplacement(ctx context.Context, pv *v1.PersistentVolume, driver string) error {
+	newPV := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        pv.Name + transferredPVSuffix,
+			Labels:      pv.Labels,
+			Annotations: map[string]string{transferredFromAnnotation: pv.Name},
+		},
+		Spec: *pv.Spec.DeepCopy(),
+	}
+	newPV.Spec.CSI.Driver = driver
+	newPV.Spec.PersistentVolumeReclaimPolicy = v1.PersistentVolumeReclaimRetain
+	if ref := pv.Spec.ClaimRef; ref != nil {
+		newPV.Spec.ClaimRef = &v1.ObjectReference{Namespace: ref.Namespace, Name: ref.Name}
+	}
+
+	// The secrets of the source volume give access to the source project only
+	secretRef := secretReference(pv.Annotations[transferSecretAnnotation])
+	for _, ref := range []**v1.SecretReference{
+		&newPV.Spec.CSI.ControllerPublishSecretRef,
+		&newPV.Spec.CSI.NodeStageSecretRef,
+		&newPV.Spec.CSI.NodePublishSecretRef,
+		&newPV.Spec.CSI.ControllerExpandSecretRef,
+		&newPV.Spec.CSI.NodeExpandSecretRef,
+	} {
+		if *ref != nil {
+			*ref = secretRef
+		}
+	}
+
+	_, err := t.kubeClient.CoreV1().PersistentVolumes().Create(ctx, newPV, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create PersistentVolume %s: %v", newPV.Name, err)
+	}
+
+	return nil
+}
+
+func secretReference(secretRef string) *v1.SecretReference {
+	namespace, name, _ := strings.Cut(secretRef, "/")
+	return &v1.SecretReference{Namespace: namespace, Name: name}
+}
+
+// fail marks the transfer as failed: it is not retried until the status annotation is removed.
+func (t *volumeTransfer) fail(ctx context.Context, pv *v1.PersistentVolume, err error) error {
+	klog.Errorf("Failed to transfer the volume of PersistentVolume %s: %v", pv.Name, err)
+	return t.setStatus(ctx, pv, transferStatusFailed, err.Error())
+}
+
+func (t *volumeTransfer) setStatus(ctx context.Context, pv *v1.PersistentVolume, status, msg string) error {
+	if pv.Annotations[transferStatusAnnotation] == status && pv.Annotations[transferMessageAnnotation] == msg {
+		return nil
+	}
+
+	var message interface{}
+	if msg != "" {
+		message = msg
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				transferStatusAnnotation:  status,
+				transferMessageAnnotation: message,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := t.kubeClient.CoreV1().PersistentVolumes().Patch(ctx, pv.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to annotate PersistentVolume %s: %v", pv.Name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/volumetransfers"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+)
+
+// inUseCloud returns the volume as attached.
+type inUseCloud struct {
+	*openstack.OpenStackMock
+}
+
+func (c inUseCloud) GetVolume(volumeID string) (*volumes.Volume, error) {
+	return &volumes.Volume{ID: volumeID, Status: "in-use"}, nil
+}
+
+func transferPV(annotations map[string]string) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-data", Annotations: annotations},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{
+					Driver:             driverName,
+					VolumeHandle:       FakeVolID,
+					NodeStageSecretRef: &v1.SecretReference{Namespace: "kube-system", Name: "source-project"},
+				},
+			},
+			PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+			ClaimRef:                      &v1.ObjectReference{Namespace: "db", Name: "data", UID: "1234"},
+		},
+	}
+}
+
+func fakeVolumeTransfer(cloud, target openstack.IOpenStack, pv *v1.PersistentVolume) *volumeTransfer {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "target-project", Namespace: "kube-system"},
+		Data:       map[string][]byte{transferSecretKey: []byte("[Global]\nauth-url=https://keystone\n")},
+	}
+
+	t := newVolumeTransfer(cloud, VolumeTransferOpts{Enabled: true, KubeClient: fake.NewSimpleClientset(secret, pv)})
+	t.newCloud = func(data []byte) (openstack.IOpenStack, error) {
+		if string(data) != string(secret.Data[transferSecretKey]) {
+			return nil, errors.New("unexpected cloud config")
+		}
+		return target, nil
+	}
+	return t
+}
+
+func TestTransferRequested(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(transferRequested(transferPV(nil)))
+	assert.True(transferRequested(transferPV(map[string]string{transferSecretAnnotation: "kube-system/target-project"})))
+	assert.True(transferRequested(transferPV(map[string]string{transferSecretAnnotation: "kube-system/target-project", transferStatusAnnotation: transferStatusPending})))
+	assert.False(transferRequested(transferPV(map[string]string{transferSecretAnnotation: "kube-system/target-project", transferStatusAnnotation: transferStatusTransferred})))
+	assert.False(transferRequested(transferPV(map[string]string{transferSecretAnnotation: "kube-system/target-project", transferStatusAnnotation: transferStatusFailed})))
+
+	pv := transferPV(map[string]string{transferSecretAnnotation: "kube-system/target-project"})
+	pv.Spec.CSI.Driver = "manila.csi.openstack.org"
+	assert.False(transferRequested(pv))
+}
+
+func TestVolumeTransfer(t *testing.T) {
+	assert := assert.New(t)
+
+	pv := transferPV(map[string]string{
+		transferSecretAnnotation: "kube-system/target-project",
+		transferDriverAnnotation: "cinder.csi.openstack.org.target",
+	})
+
+	cloud := new(openstack.OpenStackMock)
+	target := new(openstack.OpenStackMock)
+	vt := fakeVolumeTransfer(cloud, target, pv)
+	defer vt.queue.ShutDown()
+
+	// A transfer left over by a previous attempt is deleted first
+	cloud.On("ListTransfers", FakeVolID).Return([]volumetransfers.Transfer{{ID: "stale", VolumeID: FakeVolID}}, nil).Once()
+	cloud.On("DeleteTransfer", "stale").Return(nil).Once()
+	cloud.On("CreateTransfer", FakeVolID, "cinder-csi-pv-data").Return(&volumetransfers.Transfer{ID: "tr-1", AuthKey: "key"}, nil).Once()
+	target.On("AcceptTransfer", "tr-1", "key").Return(nil).Once()
+
+	ctx := context.Background()
+	assert.NoError(vt.transfer(ctx, pv))
+
+	got, err := vt.kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pv-data", metav1.GetOptions{})
+	assert.NoError(err)
+	assert.Equal(v1.PersistentVolumeReclaimRetain, got.Spec.PersistentVolumeReclaimPolicy)
+	assert.Equal(transferStatusTransferred, got.Annotations[transferStatusAnnotation])
+	assert.NotContains(got.Annotations, transferMessageAnnotation)
+
+	newPV, err := vt.kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pv-data-transferred", metav1.GetOptions{})
+	assert.NoError(err)
+	assert.Equal("pv-data", newPV.Annotations[transferredFromAnnotation])
+	assert.Equal("cinder.csi.openstack.org.target", newPV.Spec.CSI.Driver)
+	assert.Equal(FakeVolID, newPV.Spec.CSI.VolumeHandle)
+	assert.Equal(&v1.SecretReference{Namespace: "kube-system", Name: "target-project"}, newPV.Spec.CSI.NodeStageSecretRef)
+	assert.Nil(newPV.Spec.CSI.ControllerPublishSecretRef)
+	assert.Equal(&v1.ObjectReference{Namespace: "db", Name: "data"}, newPV.Spec.ClaimRef)
+
+	cloud.AssertExpectations(t)
+	target.AssertExpectations(t)
+}
+
+func TestVolumeTransferAcceptFailed(t *testing.T) {
+	assert := assert.New(t)
+
+	pv := transferPV(map[string]string{transferSecretAnnotation: "kube-system/target-project"})
+
+	cloud := new(openstack.OpenStackMock)
+	target := new(openstack.OpenStackMock)
+	vt := fakeVolumeTransfer(cloud, target, pv)
+	defer vt.queue.ShutDown()
+
+	cloud.On("ListTransfers", FakeVolID).Return(nil, nil).Once()
+	cloud.On("CreateTransfer", FakeVolID, "cinder-csi-pv-data").Return(&volumetransfers.Transfer{ID: "tr-1", AuthKey: "key"}, nil).Once()
+	target.On("AcceptTransfer", "tr-1", "key").Return(errors.New("quota exceeded")).Once()
+	// The volume stays in the source project
+	cloud.On("DeleteTransfer", "tr-1").Return(nil).Once()
+
+	ctx := context.Background()
+	assert.NoError(vt.transfer(ctx, pv))
+
+	got, err := vt.kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pv-data", metav1.GetOptions{})
+	assert.NoError(err)
+	assert.Equal(transferStatusFailed, got.Annotations[transferStatusAnnotation])
+	assert.Contains(got.Annotations[transferMessageAnnotation], "quota exceeded")
+
+	cloud.AssertExpectations(t)
+	target.AssertExpectations(t)
+}
+
+func TestVolumeTransferInUse(t *testing.T) {
+	assert := assert.New(t)
+
+	pv := transferPV(map[string]string{transferSecretAnnotation: "kube-system/target-project"})
+
+	cloud := inUseCloud{new(openstack.OpenStackMock)}
+	vt := fakeVolumeTransfer(cloud, new(openstack.OpenStackMock), pv)
+	defer vt.queue.ShutDown()
+
+	// The transfer is retried once the volume is detached
+	ctx := context.Background()
+	assert.Error(vt.transfer(ctx, pv))
+
+	got, err := vt.kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pv-data", metav1.GetOptions{})
+	assert.NoError(err)
+	assert.Equal(transferStatusPending, got.Annotations[transferStatusAnnotation])
+
+	cloud.AssertNotCalled(t, "CreateTransfer", FakeVolID, "cinder-csi-pv-data")
+}
+
+func TestVolumeTransferInvalidSecret(t *testing.T) {
+	assert := assert.New(t)
+
+	pv := transferPV(map[string]string{transferSecretAnnotation: "target-project"})
+
+	cloud := new(openstack.OpenStackMock)
+	vt := fakeVolumeTransfer(cloud, new(openstack.OpenStackMock), pv)
+	defer vt.queue.ShutDown()
+
+	ctx := context.Background()
+	assert.NoError(vt.transfer(ctx, pv))
+
+	got, err := vt.kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pv-data", metav1.GetOptions{})
+	assert.NoError(err)
+	assert.Equal(transferStatusFailed, got.Annotations[transferStatusAnnotation])
+	assert.Contains(got.Annotations[transferMessageAnnotation], "must be <namespace>/<name>")
+}
+
+func TestVolumeTransferSecretUnavailable(t *testing.T) {
+	assert := assert.New(t)
+
+	pv := transferPV(map[string]string{transferSecretAnnotation: "kube-system/target-project"})
+
+	cloud := new(openstack.OpenStackMock)
+	vt := fakeVolumeTransfer(cloud, new(openstack.OpenStackMock), pv)
+	defer vt.queue.ShutDown()
+
+	// The errors of the API server are retried
+	kubeClient := vt.kubeClient.(*fake.Clientset)
+	kubeClient.PrependReactor("get", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewServerTimeout(v1.Resource("secrets"), "get", 1)
+	})
+
+	ctx := context.Background()
+	assert.Error(vt.transfer(ctx, pv))
+
+	got, err := vt.kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pv-data", metav1.GetOptions{})
+	assert.NoError(err)
+	assert.Empty(got.Annotations[transferStatusAnnotation])
+	cloud.AssertNotCalled(t, "CreateTransfer", FakeVolID, "cinder-csi-pv-data")
+}
+
+func TestVolumeTransferSecretMissingKey(t *testing.T) {
+	assert := assert.New(t)
+
+	pv := transferPV(map[string]string{transferSecretAnnotation: "kube-system/no-key"})
+
+	cloud := new(openstack.OpenStackMock)
+	vt := fakeVolumeTransfer(cloud, new(openstack.OpenStackMock), pv)
+	defer vt.queue.ShutDown()
+
+	ctx := context.Background()
+	_, err := vt.kubeClient.CoreV1().Secrets("kube-system").Create(ctx, &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "no-key", Namespace: "kube-system"},
+		Data:       map[string][]byte{"clouds.yaml": nil},
+	}, metav1.CreateOptions{})
+	assert.NoError(err)
+
+	assert.NoError(vt.transfer(ctx, pv))
+
+	got, err := vt.kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pv-data", metav1.GetOptions{})
+	assert.NoError(err)
+	assert.Equal(transferStatusFailed, got.Annotations[transferStatusAnnotation])
+	assert.Contains(got.Annotations[transferMessageAnnotation], "has no cloud.conf key")
+}
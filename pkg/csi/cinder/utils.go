@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
@@ -44,11 +45,33 @@ func NewVolumeCapabilityAccessMode(mode csi.VolumeCapability_AccessMode_Mode) *c
 }
 
 //revive:disable:unexported-return
-func NewControllerServer(d *Driver, cloud openstack.IOpenStack) *controllerServer {
-	return &controllerServer{
-		Driver: d,
-		Cloud:  cloud,
+func NewControllerServer(d *Driver, cloud openstack.IOpenStack) (*controllerServer, error) {
+	opts := cloud.GetBlockStorageOpts()
+	owner, err := ownerMetadata(d.cluster, opts.ClusterMetadata)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cluster-metadata option: %v", err)
+	}
+
+	cs := &controllerServer{
+		Driver:      d,
+		Cloud:       cloud,
+		owner:       owner,
+		attachLocks: newVolumeLocks(),
+	}
+
+	if opts.DeletionQueueWorkers > 0 {
+		cs.deletionQueue = newDeletionQueue(cloud, d.cluster, opts)
+	}
+
+	if d.attachAheadOpts.Enabled {
+		cs.attachAhead = newAttachAhead(cloud, d.attachAheadOpts)
 	}
+
+	if d.volumeTransferOpts.Enabled {
+		cs.volumeTransfer = newVolumeTransfer(cloud, d.volumeTransferOpts)
+	}
+
+	return cs, nil
 }
 
 func NewIdentityServer(d *Driver) *identityServer {
@@ -58,11 +81,24 @@ func NewIdentityServer(d *Driver) *identityServer {
 }
 
 func NewNodeServer(d *Driver, mount mount.IMount, metadata metadata.IMetadata, cloud openstack.IOpenStack) *nodeServer {
+	if method := cloud.GetBlockStorageOpts().WipeMethod; !validWipeMethod(method) {
+		klog.Fatalf("Invalid wipe-method %q, expected %s or %s", method, wipeMethodZero, wipeMethodDiscard)
+	}
+	if size := cloud.GetBlockStorageOpts().ReadCacheSize; size != "" {
+		if _, err := parseReadCacheSize(size); err != nil {
+			klog.Fatalf("Invalid read-cache-size: %v", err)
+		}
+	}
+
 	return &nodeServer{
 		Driver:   d,
 		Mount:    mount,
 		Metadata: metadata,
 		Cloud:    cloud,
+
+		volumeLocks: newVolumeLocks(),
+		stagePool:   newWorkerPool(cloud.GetBlockStorageOpts().NodeStageConcurrency),
+		startTime:   time.Now(),
 	}
 }
 
@@ -0,0 +1,87 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"k8s.io/klog/v2"
+	utilpath "k8s.io/utils/path"
+
+	"k8s.io/cloud-provider-openstack/pkg/util/mount"
+)
+
+// volumeCondition returns the condition of the volume from its Cinder status:
+// the error statuses, e.g. error, error_deleting or error_extending, are
+// abnormal.
+func volumeCondition(vol *volumes.Volume) *csi.VolumeCondition {
+	if strings.HasPrefix(vol.Status, "error") {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("Volume %s is in %s status", vol.ID, vol.Status),
+		}
+	}
+
+	return &csi.VolumeCondition{Message: fmt.Sprintf("Volume is %s", vol.Status)}
+}
+
+// nodeVolumeCondition returns the condition of the volume published at the
+// path: it is abnormal if the device it is mounted from is gone, e.g. because
+// the volume was detached from the instance while still mounted.
+func nodeVolumeCondition(m mount.IMount, volumePath string) *csi.VolumeCondition {
+	output, err := m.GetMountFs(volumePath)
+	if err != nil {
+		klog.V(4).Infof("Failed to find the device mounted at %s: %v", volumePath, err)
+		return &csi.VolumeCondition{Message: "Volume is mounted"}
+	}
+
+	device := mountedDevice(strings.TrimSpace(string(output)))
+	if device == "" {
+		return &csi.VolumeCondition{Message: "Volume is mounted"}
+	}
+
+	exists, err := utilpath.Exists(utilpath.CheckFollowSymlink, device)
+	if err != nil {
+		klog.V(4).Infof("Failed to check whether device %s exists: %v", device, err)
+	} else if !exists {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("Device %s of the volume is gone, the volume may have been detached while mounted", device),
+		}
+	}
+
+	return &csi.VolumeCondition{Message: fmt.Sprintf("Volume is mounted from %s", device)}
+}
+
+// mountedDevice returns the device of a mount source reported by findmnt,
+// either the device itself, possibly followed by the mounted subdirectory,
+// e.g. /dev/vdb[/data], or the devtmpfs path of a bind-mounted block device,
+// e.g. udev[/vdb]. It returns an empty string for other sources.
+func mountedDevice(source string) string {
+	src, sub, bracket := strings.Cut(source, "[")
+	if strings.HasPrefix(src, "/dev/") {
+		return src
+	}
+	if bracket && (src == "udev" || src == "devtmpfs") {
+		return "/dev" + strings.TrimSuffix(sub, "]")
+	}
+
+	return ""
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVolumeCondition(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(&csi.VolumeCondition{Message: "Volume is in-use"}, volumeCondition(&volumes.Volume{ID: FakeVolID, Status: "in-use"}))
+	assert.Equal(&csi.VolumeCondition{Message: "Volume is extending"}, volumeCondition(&volumes.Volume{ID: FakeVolID, Status: "extending"}))
+
+	for _, s := range []string{"error", "error_deleting", "error_extending", "error_restoring"} {
+		cond := volumeCondition(&volumes.Volume{ID: FakeVolID, Status: s})
+		assert.True(cond.Abnormal, s)
+		assert.Equal("Volume "+FakeVolID+" is in "+s+" status", cond.Message)
+	}
+}
+
+func TestMountedDevice(t *testing.T) {
+	tests := map[string]string{
+		"/dev/vdb":                          "/dev/vdb",
+		"/dev/vdb[/data]":                   "/dev/vdb",
+		"/dev/mapper/cinder-cache-1234":     "/dev/mapper/cinder-cache-1234",
+		"udev[/vdc]":                        "/dev/vdc",
+		"devtmpfs[/vdc]":                    "/dev/vdc",
+		"tmpfs":                             "",
+		"10.0.0.1:/export/share":            "",
+		"overlay[/var/lib/containers/data]": "",
+	}
+
+	for source, expected := range tests {
+		assert.Equal(t, expected, mountedDevice(source), source)
+	}
+}
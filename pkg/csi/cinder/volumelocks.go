@@ -0,0 +1,114 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// defaultNodeStageConcurrency is the number of NodeStageVolume and
+// NodeUnstageVolume operations run in parallel when not configured.
+const defaultNodeStageConcurrency = 8
+
+// volumeLocks serializes operations on the same volume while letting
+// operations on different volumes run concurrently.
+type volumeLocks struct {
+	mux sync.Mutex
+	// locks holds a channel per locked volume, closed when it's released
+	locks map[string]chan struct{}
+}
+
+func newVolumeLocks() *volumeLocks {
+	return &volumeLocks{
+		locks: make(map[string]chan struct{}),
+	}
+}
+
+// TryAcquire tries to lock volumeID. It returns false if an operation on the
+// volume is already in progress.
+func (vl *volumeLocks) TryAcquire(volumeID string) bool {
+	vl.mux.Lock()
+	defer vl.mux.Unlock()
+
+	if _, ok := vl.locks[volumeID]; ok {
+		return false
+	}
+	vl.locks[volumeID] = make(chan struct{})
+	return true
+}
+
+// Acquire blocks until volumeID is locked or ctx is done.
+func (vl *volumeLocks) Acquire(ctx context.Context, volumeID string) error {
+	for {
+		vl.mux.Lock()
+		released, ok := vl.locks[volumeID]
+		if !ok {
+			vl.locks[volumeID] = make(chan struct{})
+			vl.mux.Unlock()
+			return nil
+		}
+		vl.mux.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Release unlocks volumeID.
+func (vl *volumeLocks) Release(volumeID string) {
+	vl.mux.Lock()
+	defer vl.mux.Unlock()
+
+	if released, ok := vl.locks[volumeID]; ok {
+		close(released)
+		delete(vl.locks, volumeID)
+	}
+}
+
+// workerPool bounds the number of operations running at the same time.
+type workerPool struct {
+	slots chan struct{}
+}
+
+func newWorkerPool(size int) *workerPool {
+	if size <= 0 {
+		size = defaultNodeStageConcurrency
+	}
+	return &workerPool{
+		slots: make(chan struct{}, size),
+	}
+}
+
+// Acquire blocks until a slot is free or ctx is done.
+func (p *workerPool) Acquire(ctx context.Context) error {
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees a slot taken by Acquire.
+func (p *workerPool) Release() {
+	<-p.slots
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVolumeLocks(t *testing.T) {
+	assert := assert.New(t)
+
+	vl := newVolumeLocks()
+	assert.True(vl.TryAcquire("vol-1"))
+	assert.True(vl.TryAcquire("vol-2"))
+	assert.False(vl.TryAcquire("vol-1"))
+
+	vl.Release("vol-1")
+	assert.True(vl.TryAcquire("vol-1"))
+}
+
+func TestVolumeLocksAcquire(t *testing.T) {
+	assert := assert.New(t)
+	vl := newVolumeLocks()
+
+	assert.NoError(vl.Acquire(context.Background(), "vol-1"))
+
+	// Other volumes are not blocked
+	assert.NoError(vl.Acquire(context.Background(), "vol-2"))
+	vl.Release("vol-2")
+
+	// The same volume is blocked until it's released
+	locked := make(chan struct{})
+	go func() {
+		assert.NoError(vl.Acquire(context.Background(), "vol-1"))
+		close(locked)
+		vl.Release("vol-1")
+	}()
+	select {
+	case <-locked:
+		t.Fatal("vol-1 locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	vl.Release("vol-1")
+	<-locked
+
+	// Waiting for the lock is aborted with the context
+	assert.True(vl.TryAcquire("vol-1"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(vl.Acquire(ctx, "vol-1"), context.Canceled)
+	vl.Release("vol-1")
+
+	vl.mux.Lock()
+	assert.Empty(vl.locks)
+	vl.mux.Unlock()
+}
+
+func TestWorkerPool(t *testing.T) {
+	assert := assert.New(t)
+
+	p := newWorkerPool(1)
+	assert.NoError(p.Acquire(context.Background()))
+
+	// The only slot is taken, so acquiring must give up once ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(p.Acquire(ctx), context.Canceled)
+
+	p.Release()
+	assert.NoError(p.Acquire(context.Background()))
+
+	assert.Equal(defaultNodeStageConcurrency, cap(newWorkerPool(0).slots))
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"k8s.io/klog/v2"
+	utilexec "k8s.io/utils/exec"
+)
+
+const (
+	// cinderCSIWipeKey is the volume metadata key requesting the node to wipe
+	// an inline ephemeral volume before deleting it, or a persistent volume
+	// each time it's unstaged, set with the wipe volume attribute or
+	// StorageClass parameter
+	cinderCSIWipeKey = "cinder.csi.openstack.org/wipe"
+
+	wipeMethodZero    = "zero"
+	wipeMethodDiscard = "discard"
+)
+
+// luksMagic starts the header of the LUKS1 and LUKS2 devices
+var luksMagic = []byte{'L', 'U', 'K', 'S', 0xba, 0xbe}
+
+// parseWipeParameter returns the volume metadata for the wipe volume attribute
+// of an inline ephemeral volume or StorageClass parameter.
+func parseWipeParameter(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	wipe, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wipe parameter %q: %v", value, err)
+	}
+	if !wipe {
+		return nil, nil
+	}
+
+	return map[string]string{cinderCSIWipeKey: "true"}, nil
+}
+
+func validWipeMethod(method string) bool {
+	return method == "" || method == wipeMethodZero || method == wipeMethodDiscard
+}
+
+func isLUKS(devicePath string) (bool, error) {
+	f, err := os.Open(devicePath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	header := make([]byte, len(luksMagic))
+	if _, err := io.ReadFull(f, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return bytes.Equal(header, luksMagic), nil
+}
+
+// wipeDevice destroys the data of the device. The key slots of a LUKS device
+// are erased first, making its data unrecoverable, then the device is zeroed
+// out, or only discarded with the discard method, which is faster but doesn't
+// guarantee that the blocks read back as zeros on all backends.
+func wipeDevice(exec utilexec.Interface, devicePath, method string) error {
+	if !validWipeMethod(method) {
+		return fmt.Errorf("unknown wipe method %q", method)
+	}
+	args := []string{"--zeroout", devicePath}
+	if method == wipeMethodDiscard {
+		args = []string{devicePath}
+	}
+
+	luks, err := isLUKS(devicePath)
+	if err != nil {
+		return fmt.Errorf("failed to read the header of %s: %v", devicePath, err)
+	}
+	if luks {
+		klog.V(4).Infof("Erasing the LUKS key slots of %s", devicePath)
+		if out, err := exec.Command("cryptsetup", "erase", "--batch-mode", devicePath).CombinedOutput(); err != nil {
+			return fmt.Errorf("cryptsetup erase %s failed: %v, output: %s", devicePath, err, string(out))
+		}
+	}
+
+	klog.V(4).Infof("Wiping %s with blkdiscard %v", devicePath, args)
+	if out, err := exec.Command("blkdiscard", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("blkdiscard %s failed: %v, output: %s", devicePath, err, string(out))
+	}
+
+	return nil
+}
+
+// wipeVolume wipes the device of an inline ephemeral volume before it's
+// detached and deleted, or of a persistent volume before it's unstaged, if
+// requested in its metadata. The volume must be unmounted and still attached
+// to the node.
+func (ns *nodeServer) wipeVolume(volumeID string, volMetadata map[string]string) error {
+	if volMetadata[cinderCSIWipeKey] != "true" {
+		return nil
+	}
+
+	devicePath, err := getDevicePath(volumeID, ns.Mount)
+	if err != nil {
+		return fmt.Errorf("unable to find the device of volume %s: %v", volumeID, err)
+	}
+
+	if err := wipeDevice(ns.Mount.Mounter().Exec, devicePath, ns.Cloud.GetBlockStorageOpts().WipeMethod); err != nil {
+		return err
+	}
+	klog.Infof("Wiped volume %s on device %s", volumeID, devicePath)
+
+	return nil
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/schedulerhints"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	utilexec "k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+)
+
+func TestParseWipeParameter(t *testing.T) {
+	properties, err := parseWipeParameter("")
+	assert.NoError(t, err)
+	assert.Empty(t, properties)
+
+	properties, err = parseWipeParameter("false")
+	assert.NoError(t, err)
+	assert.Empty(t, properties)
+
+	properties, err = parseWipeParameter("true")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{cinderCSIWipeKey: "true"}, properties)
+
+	_, err = parseWipeParameter("yes please")
+	assert.Error(t, err)
+}
+
+func TestCreateVolumeWipe(t *testing.T) {
+	properties := map[string]string{cinderCSIClusterIDKey: FakeCluster, cinderCSIWipeKey: "true"}
+	osmock.On("GetVolumesByName", "scratch").Return(FakeVolListEmpty, nil)
+	osmock.On("CreateVolume", "scratch", mock.AnythingOfType("int"), "", mock.AnythingOfType("string"), "", "", "", properties, (*schedulerhints.SchedulerHints)(nil)).Return(&FakeVol, nil)
+
+	singleNode := []*csi.VolumeCapability{{AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER}}}
+	_, err := fakeCs.CreateVolume(FakeCtx, &csi.CreateVolumeRequest{
+		Name:               "scratch",
+		VolumeCapabilities: singleNode,
+		Parameters:         map[string]string{"wipe": "true"},
+	})
+	assert.NoError(t, err)
+	osmock.AssertCalled(t, "CreateVolume", "scratch", mock.AnythingOfType("int"), "", mock.AnythingOfType("string"), "", "", "", properties, (*schedulerhints.SchedulerHints)(nil))
+
+	_, err = fakeCs.CreateVolume(FakeCtx, &csi.CreateVolumeRequest{
+		Name:               "scratch",
+		VolumeCapabilities: singleNode,
+		Parameters:         map[string]string{"wipe": "yes please"},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// The volume would be wiped while used by the other nodes
+	_, err = fakeCs.CreateVolume(FakeCtx, &csi.CreateVolumeRequest{
+		Name:               "scratch",
+		VolumeCapabilities: []*csi.VolumeCapability{{AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER}}},
+		Parameters:         map[string]string{"wipe": "true"},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestWipeDevice(t *testing.T) {
+	dir := t.TempDir()
+	plainDevice := filepath.Join(dir, "plain")
+	luksDevice := filepath.Join(dir, "luks")
+	assert.NoError(t, os.WriteFile(plainDevice, []byte("ext4 data"), 0600))
+	assert.NoError(t, os.WriteFile(luksDevice, append(append([]byte{}, luksMagic...), 0, 2), 0600))
+
+	tests := []struct {
+		name         string
+		devicePath   string
+		method       string
+		expectedCmds [][]string
+		wantErr      bool
+	}{
+		{
+			name:         "zero out by default",
+			devicePath:   plainDevice,
+			expectedCmds: [][]string{{"blkdiscard", "--zeroout", plainDevice}},
+		},
+		{
+			name:         "discard",
+			devicePath:   plainDevice,
+			method:       wipeMethodDiscard,
+			expectedCmds: [][]string{{"blkdiscard", plainDevice}},
+		},
+		{
+			name:       "crypto-erase LUKS",
+			devicePath: luksDevice,
+			method:     wipeMethodZero,
+			expectedCmds: [][]string{
+				{"cryptsetup", "erase", "--batch-mode", luksDevice},
+				{"blkdiscard", "--zeroout", luksDevice},
+			},
+		},
+		{
+			name:       "unknown method",
+			devicePath: plainDevice,
+			method:     "shred",
+			wantErr:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var cmds [][]string
+			fakeExec := &testingexec.FakeExec{}
+			for range test.expectedCmds {
+				fakeExec.CommandScript = append(fakeExec.CommandScript, func(cmd string, args ...string) utilexec.Cmd {
+					cmds = append(cmds, append([]string{cmd}, args...))
+					return &testingexec.FakeCmd{
+						CombinedOutputScript: []testingexec.FakeAction{
+							func() ([]byte, []byte, error) { return nil, nil, nil },
+						},
+					}
+				})
+			}
+
+			err := wipeDevice(fakeExec, test.devicePath, test.method)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedCmds, cmds)
+		})
+	}
+}
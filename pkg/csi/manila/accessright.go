@@ -0,0 +1,174 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	clouderrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+// AsyncAccessRightsOpts configures the asynchronous completion of the cephx access rights. Some backends take
+// minutes to assign the cephx key of a new access right, which may exceed the timeout of the external-provisioner.
+// When enabled, CreateVolume returns as soon as the access right is requested and the key is awaited in the
+// background, reporting the progress in the annotations of the PersistentVolume. NodeStageVolume fails with
+// Unavailable until the key is assigned.
+type AsyncAccessRightsOpts struct {
+	Enabled bool
+	// Timeout after which the access right is reported as failed if it still has no key.
+	Timeout time.Duration
+	// KubeClient is used to annotate the PersistentVolumes.
+	KubeClient kubernetes.Interface
+}
+
+const (
+	accessStatusAnnotation        = "manila.csi.openstack.org/access-status"
+	accessStatusMessageAnnotation = "manila.csi.openstack.org/access-status-message"
+
+	accessStatusPending = "pending"
+	accessStatusReady   = "ready"
+	accessStatusFailed  = "failed"
+
+	accessRightStateActive       = "active"
+	accessRightStateError        = "error"
+	accessRightStateQueuedToDeny = "queued_to_deny"
+	accessRightStateDenying      = "denying"
+
+	accessRightPollInterval = 10 * time.Second
+)
+
+// pendingAccessRights holds the IDs of the access rights being awaited
+var pendingAccessRights = sync.Map{}
+
+// accessRightStatus returns the access status of the PV and its message for the access right.
+func accessRightStatus(accessRight *shares.AccessRight) (string, string) {
+	switch {
+	case accessRight == nil:
+		return accessStatusFailed, "access right not found"
+	case accessRight.AccessKey != "":
+		return accessStatusReady, ""
+	case accessRight.State == accessRightStateError:
+		return accessStatusFailed, fmt.Sprintf("access right %s is in error state", accessRight.ID)
+	default:
+		return accessStatusPending, fmt.Sprintf("waiting for the cephx key of access right %s", accessRight.ID)
+	}
+}
+
+func setPVAccessStatus(ctx context.Context, kubeClient kubernetes.Interface, pvName, accessStatus, message string) error {
+	return patchPVAnnotations(ctx, kubeClient, pvName, map[string]string{
+		accessStatusAnnotation:        accessStatus,
+		accessStatusMessageAnnotation: message,
+	})
+}
+
+func patchPVAnnotations(ctx context.Context, kubeClient kubernetes.Interface, pvName string, annotations map[string]string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = kubeClient.CoreV1().PersistentVolumes().Patch(ctx, pvName, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// awaitAccessRight waits in the background for the cephx key of the access right, annotating the PV of the volume
+// with the progress. The PV may not exist yet when the wait starts, as it's created once CreateVolume returns.
+func (cs *controllerServer) awaitAccessRight(manilaClient manilaclient.Interface, shareID, accessRightID, pvName string) {
+	if _, isPending := pendingAccessRights.LoadOrStore(accessRightID, true); isPending {
+		return
+	}
+
+	go func() {
+		defer pendingAccessRights.Delete(accessRightID)
+
+		opts := cs.d.asyncAccessRights
+		ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+		defer cancel()
+
+		var observed, annotated string
+		update := func(ctx context.Context, accessStatus, message string) {
+			if accessStatus == annotated {
+				return
+			}
+			if err := setPVAccessStatus(ctx, opts.KubeClient, pvName, accessStatus, message); err != nil {
+				if !apierrors.IsNotFound(err) {
+					klog.Warningf("failed to annotate PersistentVolume %s with access status %s: %v", pvName, accessStatus, err)
+				}
+				return
+			}
+			annotated = accessStatus
+		}
+
+		err := wait.PollUntilContextCancel(ctx, accessRightPollInterval, true, func(ctx context.Context) (bool, error) {
+			rights, err := manilaClient.GetAccessRights(shareID)
+			if err != nil {
+				if clouderrors.IsNotFound(err) {
+					// The volume was deleted in the meantime
+					observed = accessStatusReady
+					return true, nil
+				}
+				klog.V(4).Infof("failed to list access rights of share %s: %v", shareID, err)
+				return false, nil
+			}
+
+			var accessRight *shares.AccessRight
+			for i := range rights {
+				if rights[i].ID == accessRightID {
+					accessRight = &rights[i]
+					break
+				}
+			}
+
+			accessStatus, message := accessRightStatus(accessRight)
+			observed = accessStatus
+			update(ctx, accessStatus, message)
+
+			if accessStatus == accessStatusPending {
+				return false, nil
+			}
+
+			klog.V(4).Infof("access right %s of share %s is %s", accessRightID, shareID, accessStatus)
+
+			// Keep polling until the PV exists to record the final status
+			return annotated == accessStatus, nil
+		})
+
+		if err != nil && observed != accessStatusReady {
+			klog.Errorf("access right %s of share %s was not completed in %v", accessRightID, shareID, opts.Timeout)
+
+			updateCtx, cancel := context.WithTimeout(context.Background(), accessRightPollInterval)
+			defer cancel()
+			update(updateCtx, accessStatusFailed, fmt.Sprintf("access right %s was not completed in %v", accessRightID, opts.Timeout))
+		}
+	}()
+}
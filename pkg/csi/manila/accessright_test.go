@@ -0,0 +1,73 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAccessRightStatus(t *testing.T) {
+	ts := []struct {
+		accessRight    *shares.AccessRight
+		expectedStatus string
+	}{
+		{nil, accessStatusFailed},
+		{&shares.AccessRight{ID: "a", State: "queued_to_apply"}, accessStatusPending},
+		{&shares.AccessRight{ID: "a", State: "active"}, accessStatusPending},
+		{&shares.AccessRight{ID: "a", State: "active", AccessKey: "key"}, accessStatusReady},
+		{&shares.AccessRight{ID: "a", State: accessRightStateError}, accessStatusFailed},
+	}
+
+	for i, tc := range ts {
+		if accessStatus, _ := accessRightStatus(tc.accessRight); accessStatus != tc.expectedStatus {
+			t.Errorf("test %d: expected status %s, got %s", i, tc.expectedStatus, accessStatus)
+		}
+	}
+}
+
+func TestSetPVAccessStatus(t *testing.T) {
+	ctx := context.Background()
+	kubeClient := fake.NewSimpleClientset(&corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv", Annotations: map[string]string{"other": "value"}},
+	})
+
+	if err := setPVAccessStatus(ctx, kubeClient, "pv", accessStatusPending, "waiting"); err != nil {
+		t.Fatal(err)
+	}
+	if err := setPVAccessStatus(ctx, kubeClient, "pv", accessStatusReady, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	pv, err := kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pv", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pv.Annotations[accessStatusAnnotation] != accessStatusReady || pv.Annotations[accessStatusMessageAnnotation] != "" || pv.Annotations["other"] != "value" {
+		t.Errorf("unexpected annotations %v", pv.Annotations)
+	}
+
+	if err := setPVAccessStatus(ctx, kubeClient, "missing", accessStatusReady, ""); !apierrors.IsNotFound(err) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
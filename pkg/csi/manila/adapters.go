@@ -17,21 +17,28 @@ limitations under the License.
 package manila
 
 import (
-	"strings"
+	"fmt"
 
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/shareadapters"
 	"k8s.io/klog/v2"
 )
 
 func getShareAdapter(proto string) shareadapters.ShareAdapter {
-	switch strings.ToUpper(proto) {
-	case "CEPHFS":
-		return &shareadapters.Cephfs{}
-	case "NFS":
-		return &shareadapters.NFS{}
-	default:
+	ad, ok := shareadapters.GetShareAdapter(proto)
+	if !ok {
 		klog.Fatalf("unknown share adapter %s", proto)
 	}
 
+	return ad
+}
+
+// validateProtocols checks that all protocols have a registered share adapter.
+func validateProtocols(protocols []string) error {
+	for _, proto := range protocols {
+		if _, ok := shareadapters.GetShareAdapter(proto); !ok {
+			return fmt.Errorf("share protocol %q not supported; supported protocols are %v", proto, shareadapters.RegisteredProtocols())
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,117 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/shareadapters"
+)
+
+type fakeShareAdapter struct {
+	shareadapters.NFS
+}
+
+func TestShareAdapterRegistry(t *testing.T) {
+	if _, ok := getShareAdapter("cephfs").(*shareadapters.Cephfs); !ok {
+		t.Errorf("expected the CephFS share adapter for protocol cephfs")
+	}
+	if _, ok := getShareAdapter("NFS").(*shareadapters.NFS); !ok {
+		t.Errorf("expected the NFS share adapter for protocol NFS")
+	}
+
+	if err := validateProtocols([]string{"CEPHFS", "FAKEFS"}); err == nil {
+		t.Errorf("expected an error for the unregistered protocol FAKEFS")
+	}
+
+	shareadapters.RegisterShareAdapter("fakefs", &fakeShareAdapter{})
+
+	if _, ok := getShareAdapter("FAKEFS").(*fakeShareAdapter); !ok {
+		t.Errorf("expected the registered share adapter for protocol FAKEFS")
+	}
+
+	if err := validateProtocols([]string{"CEPHFS", "FAKEFS"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	found := false
+	for _, proto := range shareadapters.RegisteredProtocols() {
+		if proto == "FAKEFS" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected FAKEFS in registered protocols %v", shareadapters.RegisteredProtocols())
+	}
+}
+
+func TestCifsBuildVolumeContext(t *testing.T) {
+	ts := []struct {
+		path     string
+		expected map[string]string
+	}{
+		{`\\10.0.0.10\share-d1e2`, map[string]string{"source": "//10.0.0.10/share-d1e2"}},
+		{"//10.0.0.10/share-d1e2", map[string]string{"source": "//10.0.0.10/share-d1e2"}},
+		{"10.0.0.10:/share-d1e2", nil},
+		{`\\10.0.0.10`, nil},
+	}
+
+	for _, tc := range ts {
+		volCtx, err := (shareadapters.Cifs{}).BuildVolumeContext(&shareadapters.VolumeContextArgs{
+			Locations: []shares.ExportLocation{{Path: tc.path}},
+		})
+		if tc.expected == nil {
+			if err == nil {
+				t.Errorf("%s: expected an error, got volume context %v", tc.path, volCtx)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.path, err)
+		}
+		if !reflect.DeepEqual(volCtx, tc.expected) {
+			t.Errorf("%s: expected volume context %v, got %v", tc.path, tc.expected, volCtx)
+		}
+	}
+}
+
+func TestCifsBuildNodeStageSecret(t *testing.T) {
+	accessRight := &shares.AccessRight{AccessType: "user", AccessTo: "alice"}
+
+	if _, err := (shareadapters.Cifs{}).BuildNodeStageSecret(&shareadapters.SecretArgs{AccessRight: accessRight}); err == nil {
+		t.Errorf("expected an error without %s secret", shareadapters.CifsPasswordSecretKey)
+	}
+
+	secret, err := (shareadapters.Cifs{}).BuildNodeStageSecret(&shareadapters.SecretArgs{
+		AccessRight: accessRight,
+		Secrets: map[string]string{
+			"os-password":                       "os-secret",
+			shareadapters.CifsPasswordSecretKey: "secret",
+			shareadapters.CifsDomainSecretKey:   "EXAMPLE",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]string{"username": "alice", "password": "secret", "domain": "EXAMPLE"}
+	if !reflect.DeepEqual(secret, expected) {
+		t.Errorf("expected secret %v, got %v", expected, secret)
+	}
+}
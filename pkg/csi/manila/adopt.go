@@ -0,0 +1,227 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/shareadapters"
+	manilautil "k8s.io/cloud-provider-openstack/pkg/csi/manila/util"
+	clouderrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+// AdoptOpts configures Adopt.
+type AdoptOpts struct {
+	// DriverName is the driver of the PersistentVolume.
+	DriverName string
+	// ClusterID attributes the share to the cluster in its metadata, if set.
+	ClusterID string
+	// SecretDir is a directory containing the OpenStack credentials, one file per key, in the same
+	// format as the CSI secrets.
+	SecretDir string
+
+	ManilaClientBuilder manilaclient.Builder
+
+	// ShareID is the share to adopt.
+	ShareID string
+	// Protocol is the share protocol of the driver, see --share-protocol-selector.
+	Protocol string
+	// PVName is the name of the PersistentVolume. Defaults to the name of the share, or its ID.
+	PVName string
+	// Parameters are the volume parameters of the access rule and of the node service, e.g. cephfs-clientID
+	// or cephfs-mounter, as in a StorageClass.
+	Parameters map[string]string
+	// ReadOnly grants read-only access to the share, the PersistentVolume is ReadOnlyMany.
+	ReadOnly bool
+	// SecretName and SecretNamespace reference the CSI secrets of the node service.
+	SecretName      string
+	SecretNamespace string
+}
+
+// Adopt prepares a share provisioned outside of the driver to be consumed by a PersistentVolume: the protocol and the
+// export locations of the share are validated, the access rule the driver would create is granted, the share is
+// attributed to the cluster in its metadata, and the PersistentVolume referencing the share is returned.
+// The PersistentVolume retains the share once released, its data is never deleted by the driver.
+func Adopt(o *AdoptOpts) (*v1.PersistentVolume, error) {
+	secrets, err := readSecretDir(o.SecretDir)
+	if err != nil {
+		return nil, err
+	}
+
+	osOpts, err := options.NewOpenstackOptions(secrets)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenStack secrets in %s: %v", o.SecretDir, err)
+	}
+
+	manilaClient, err := o.ManilaClientBuilder.New(osOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Manila v2 client: %v", err)
+	}
+
+	share, err := manilaClient.GetShareByID(o.ShareID)
+	if err != nil {
+		if clouderrors.IsNotFound(err) {
+			return nil, fmt.Errorf("share %s not found", o.ShareID)
+		}
+		return nil, fmt.Errorf("failed to get share %s: %v", o.ShareID, err)
+	}
+
+	if share.Status != shareAvailable {
+		return nil, fmt.Errorf("share %s is in %s state, expected %s", share.ID, share.Status, shareAvailable)
+	}
+
+	if !compareProtocol(share.ShareProto, o.Protocol) {
+		return nil, fmt.Errorf("share %s is a %s share, the driver handles %s shares", share.ID, share.ShareProto, o.Protocol)
+	}
+
+	ad, ok := shareadapters.GetShareAdapter(share.ShareProto)
+	if !ok {
+		return nil, fmt.Errorf("share protocol %s is not supported", share.ShareProto)
+	}
+
+	if clusterID := share.Metadata[clusterMetadataKey]; clusterID != "" && clusterID != o.ClusterID {
+		return nil, fmt.Errorf("share %s belongs to cluster %s", share.ID, clusterID)
+	}
+
+	locs, err := manilaClient.GetExportLocations(share.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get export locations of share %s: %v", share.ID, err)
+	}
+
+	if _, err := manilautil.FindExportLocation(locs, manilautil.AnyExportLocation); err != nil {
+		return nil, fmt.Errorf("share %s has no usable export location: %v", share.ID, err)
+	}
+
+	pvName := o.PVName
+	if pvName == "" {
+		pvName = defaultPVName(share)
+	} else if errs := validation.IsDNS1123Subdomain(pvName); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid PersistentVolume name %q: %s", pvName, strings.Join(errs, ", "))
+	}
+
+	params := make(map[string]string, len(o.Parameters)+1)
+	for k, v := range o.Parameters {
+		params[k] = v
+	}
+	params["protocol"] = share.ShareProto
+
+	shareOpts, err := options.NewControllerVolumeContext(params)
+	if err != nil {
+		return nil, fmt.Errorf("invalid volume parameters: %v", err)
+	}
+
+	// The access right of the PersistentVolume is granted the way CreateVolume would
+	accessLevel := "rw"
+	if o.ReadOnly {
+		accessLevel = "ro"
+	}
+
+	accessRight, err := ad.GetOrGrantAccess(&shareadapters.GrantAccessArgs{
+		Share:        share,
+		ManilaClient: manilaClient,
+		Options:      shareOpts,
+		AccessLevel:  accessLevel,
+		KeyTimeout:   defaultAccessKeyWaitTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to grant access to share %s: %v", share.ID, err)
+	}
+
+	md := map[string]string{pvNameMetadataKey: pvName}
+	if o.ClusterID != "" {
+		md[clusterMetadataKey] = o.ClusterID
+	}
+	if _, err := manilaClient.SetShareMetadata(share.ID, shares.SetMetadataOpts{Metadata: md}); err != nil {
+		return nil, fmt.Errorf("failed to set metadata of share %s: %v", share.ID, err)
+	}
+
+	klog.V(4).Infof("Adopted share %s with access right %s as PersistentVolume %s", share.ID, accessRight.ID, pvName)
+
+	return adoptedPersistentVolume(o, share, accessRight.ID, pvName, params), nil
+}
+
+// defaultPVName returns the name of the share turned into a DNS-1123 subdomain, i.e. lowercased with the other
+// characters replaced by dashes, or the ID of the share if its name can't be used.
+func defaultPVName(share *shares.Share) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return unicode.ToLower(r)
+		default:
+			return '-'
+		}
+	}, share.Name)
+	name = strings.Trim(name, "-.")
+
+	if name == "" || len(validation.IsDNS1123Subdomain(name)) > 0 {
+		return share.ID
+	}
+	return name
+}
+
+func adoptedPersistentVolume(o *AdoptOpts, share *shares.Share, accessID, pvName string, params map[string]string) *v1.PersistentVolume {
+	volCtx := filterParametersForVolumeContext(params, options.NodeVolumeContextFields())
+	volCtx["shareID"] = share.ID
+	volCtx["shareAccessID"] = accessID
+
+	accessMode := v1.ReadWriteMany
+	if o.ReadOnly {
+		accessMode = v1.ReadOnlyMany
+	}
+
+	var secretRef *v1.SecretReference
+	if o.SecretName != "" {
+		secretRef = &v1.SecretReference{Name: o.SecretName, Namespace: o.SecretNamespace}
+	}
+
+	return &v1.PersistentVolume{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "PersistentVolume"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: pvName,
+		},
+		Spec: v1.PersistentVolumeSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{accessMode},
+			Capacity: v1.ResourceList{
+				v1.ResourceStorage: *resource.NewQuantity(int64(share.Size)*bytesInGiB, resource.BinarySI),
+			},
+			// The data of an adopted share must outlive the claims
+			PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimRetain,
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{
+					Driver:               o.DriverName,
+					VolumeHandle:         share.ID,
+					ReadOnly:             o.ReadOnly,
+					VolumeAttributes:     volCtx,
+					NodeStageSecretRef:   secretRef,
+					NodePublishSecretRef: secretRef,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-openstack/pkg/client"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+)
+
+type fakeAdoptClient struct {
+	manilaclient.Interface
+
+	share    shares.Share
+	locs     []shares.ExportLocation
+	rights   []shares.AccessRight
+	metadata map[string]string
+}
+
+func (c *fakeAdoptClient) GetShareByID(shareID string) (*shares.Share, error) {
+	if shareID != c.share.ID {
+		return nil, gophercloud.ErrDefault404{}
+	}
+	share := c.share
+	return &share, nil
+}
+
+func (c *fakeAdoptClient) GetExportLocations(shareID string) ([]shares.ExportLocation, error) {
+	return c.locs, nil
+}
+
+func (c *fakeAdoptClient) GetAccessRights(shareID string) ([]shares.AccessRight, error) {
+	return c.rights, nil
+}
+
+func (c *fakeAdoptClient) GrantAccess(shareID string, opts shares.GrantAccessOptsBuilder) (*shares.AccessRight, error) {
+	o := opts.(shares.GrantAccessOpts)
+	right := shares.AccessRight{ID: "granted", ShareID: shareID, AccessType: o.AccessType, AccessTo: o.AccessTo, AccessLevel: o.AccessLevel}
+	c.rights = append(c.rights, right)
+	return &right, nil
+}
+
+func (c *fakeAdoptClient) SetShareMetadata(shareID string, opts shares.SetMetadataOptsBuilder) (map[string]string, error) {
+	c.metadata = opts.(shares.SetMetadataOpts).Metadata
+	return c.metadata, nil
+}
+
+type fakeAdoptClientBuilder struct {
+	c *fakeAdoptClient
+}
+
+func (b fakeAdoptClientBuilder) New(*client.AuthOpts) (manilaclient.Interface, error) {
+	return b.c, nil
+}
+
+func TestAdopt(t *testing.T) {
+	dir := t.TempDir()
+	for k, v := range map[string]string{"os-authURL": "https://keystone", "os-userName": "admin", "os-password": "secret", "os-projectName": "admin", "os-domainName": "default", "os-region": "RegionOne"} {
+		if err := os.WriteFile(filepath.Join(dir, k), []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newClient := func() *fakeAdoptClient {
+		return &fakeAdoptClient{
+			share: shares.Share{ID: "share-id", Name: "legacy", Status: shareAvailable, ShareProto: "NFS", Size: 10},
+			locs:  []shares.ExportLocation{{Path: "10.0.0.1:/shares/legacy"}},
+		}
+	}
+
+	newOpts := func(c *fakeAdoptClient) *AdoptOpts {
+		return &AdoptOpts{
+			DriverName:          "nfs.manila.csi.openstack.org",
+			ClusterID:           "cluster",
+			SecretDir:           dir,
+			ManilaClientBuilder: fakeAdoptClientBuilder{c: c},
+			ShareID:             "share-id",
+			Protocol:            "NFS",
+			Parameters:          map[string]string{"nfs-shareClient": "10.0.0.0/24", "nfs-security": "krb5"},
+			SecretName:          "csi-manila-secrets",
+			SecretNamespace:     "default",
+		}
+	}
+
+	c := newClient()
+	pv, err := Adopt(newOpts(c))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(c.rights) != 1 || c.rights[0].AccessTo != "10.0.0.0/24" {
+		t.Errorf("expected an access right for 10.0.0.0/24, got %+v", c.rights)
+	}
+
+	if expected := map[string]string{pvNameMetadataKey: "legacy", clusterMetadataKey: "cluster"}; !reflect.DeepEqual(c.metadata, expected) {
+		t.Errorf("expected share metadata %v, got %v", expected, c.metadata)
+	}
+
+	if pv.Name != "legacy" || pv.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimRetain {
+		t.Errorf("expected retained PersistentVolume legacy, got %s with reclaim policy %s", pv.Name, pv.Spec.PersistentVolumeReclaimPolicy)
+	}
+
+	if storage := pv.Spec.Capacity[v1.ResourceStorage]; storage.String() != "10Gi" {
+		t.Errorf("expected capacity 10Gi, got %s", storage.String())
+	}
+
+	csiSource := pv.Spec.CSI
+	if expected := map[string]string{"shareID": "share-id", "shareAccessID": "granted", "nfs-security": "krb5", "cephfs-mounter": "fuse"}; !reflect.DeepEqual(csiSource.VolumeAttributes, expected) {
+		t.Errorf("expected volume attributes %v, got %v", expected, csiSource.VolumeAttributes)
+	}
+
+	if csiSource.Driver != "nfs.manila.csi.openstack.org" || csiSource.VolumeHandle != "share-id" || csiSource.NodeStageSecretRef.Name != "csi-manila-secrets" {
+		t.Errorf("unexpected CSI source %+v", csiSource)
+	}
+
+	// The access right granted by a previous adoption is reused
+	c.share.Metadata = c.metadata
+	if pv, err = Adopt(newOpts(c)); err != nil || len(c.rights) != 1 || pv.Spec.CSI.VolumeAttributes["shareAccessID"] != "granted" {
+		t.Errorf("expected the access right to be reused, got %+v, error %v", c.rights, err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*fakeAdoptClient, *AdoptOpts)
+	}{
+		{"missing share", func(c *fakeAdoptClient, o *AdoptOpts) { o.ShareID = "other" }},
+		{"share not available", func(c *fakeAdoptClient, o *AdoptOpts) { c.share.Status = shareError }},
+		{"other protocol", func(c *fakeAdoptClient, o *AdoptOpts) { c.share.ShareProto = "CEPHFS" }},
+		{"share of another cluster", func(c *fakeAdoptClient, o *AdoptOpts) {
+			c.share.Metadata = map[string]string{clusterMetadataKey: "other"}
+		}},
+		{"no export location", func(c *fakeAdoptClient, o *AdoptOpts) { c.locs = nil }},
+		{"invalid parameters", func(c *fakeAdoptClient, o *AdoptOpts) { o.Parameters["nfs-accessType"] = "cert" }},
+		{"invalid PersistentVolume name", func(c *fakeAdoptClient, o *AdoptOpts) { o.PVName = "Legacy_Share" }},
+	}
+
+	for _, tt := range tests {
+		c := newClient()
+		o := newOpts(c)
+		tt.modify(c, o)
+
+		if _, err := Adopt(o); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+		if len(c.rights) != 0 || c.metadata != nil {
+			t.Errorf("%s: expected the share to be left alone, got access rights %+v and metadata %v", tt.name, c.rights, c.metadata)
+		}
+	}
+}
+
+func TestDefaultPVName(t *testing.T) {
+	ts := []struct {
+		name, expected string
+	}{
+		{"legacy", "legacy"},
+		{"Legacy Share_01", "legacy-share-01"},
+		{"-db.backup-", "db.backup"},
+		{"__", "share-id"},
+		{"", "share-id"},
+		{"a..b", "share-id"},
+		{strings.Repeat("a", 254), "share-id"},
+	}
+
+	for _, tc := range ts {
+		if name := defaultPVName(&shares.Share{ID: "share-id", Name: tc.name}); name != tc.expected {
+			t.Errorf("defaultPVName(%q): expected %q, got %q", tc.name, tc.expected, name)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// AutoExpansionOpts configures the automatic expansion of the volumes whose usage crosses the threshold set
+// with the autoExpansionThreshold parameter of their StorageClass.
+type AutoExpansionOpts struct {
+	// Interval between two checks of the volume usage. The automatic expansion is disabled if zero.
+	Interval time.Duration
+	// KubeClient is used to list the StorageClasses and the PersistentVolumeClaims, to get the volume
+	// usage from the kubelets and to expand the PersistentVolumeClaims.
+	KubeClient kubernetes.Interface
+}
+
+const (
+	defaultAutoExpansionStep = "10%"
+)
+
+// autoExpansionPolicy is the automatic expansion policy of the volumes of a StorageClass.
+type autoExpansionPolicy struct {
+	// Percentage of the capacity of the volume used, above which the volume is expanded
+	threshold int64
+	// Size added to the volume, in bytes if stepPercent is zero
+	step int64
+	// Size added to the volume, in percent of its capacity
+	stepPercent int64
+	// Size the volume is never expanded beyond, in bytes. Zero means unlimited.
+	maxSize int64
+}
+
+// newAutoExpansionPolicy parses the autoExpansion* volume parameters, it returns nil if the automatic expansion
+// is not enabled, i.e. the threshold is empty.
+func newAutoExpansionPolicy(threshold, step, maxSize string) (*autoExpansionPolicy, error) {
+	if threshold == "" {
+		return nil, nil
+	}
+
+	p := &autoExpansionPolicy{}
+
+	t, err := strconv.ParseInt(strings.TrimSuffix(threshold, "%"), 10, 64)
+	if err != nil || t < 1 || t > 99 {
+		return nil, fmt.Errorf("autoExpansionThreshold must be a percentage between 1 and 99, got %q", threshold)
+	}
+	p.threshold = t
+
+	if step == "" {
+		step = defaultAutoExpansionStep
+	}
+	if s, ok := strings.CutSuffix(step, "%"); ok {
+		p.stepPercent, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || p.stepPercent < 1 {
+			return nil, fmt.Errorf("autoExpansionStep must be a positive percentage or quantity, got %q", step)
+		}
+	} else {
+		q, err := resource.ParseQuantity(step)
+		if err != nil || q.Sign() <= 0 {
+			return nil, fmt.Errorf("autoExpansionStep must be a positive percentage or quantity, got %q", step)
+		}
+		p.step = q.Value()
+	}
+
+	if maxSize != "" {
+		q, err := resource.ParseQuantity(maxSize)
+		if err != nil || q.Sign() <= 0 {
+			return nil, fmt.Errorf("autoExpansionMaxSize must be a positive quantity, got %q", maxSize)
+		}
+		p.maxSize = q.Value()
+	}
+
+	return p, nil
+}
+
+// expandedSize returns the size in bytes the volume is expanded to, rounded up to GiB, or zero if the volume
+// is not expanded, either because its usage is below the threshold or because it has reached the maximum size.
+func (p *autoExpansionPolicy) expandedSize(capacity, used int64) int64 {
+	if capacity <= 0 || used*100 < p.threshold*capacity {
+		return 0
+	}
+
+	step := p.step
+	if p.stepPercent != 0 {
+		step = capacity * p.stepPercent / 100
+	}
+
+	size := (capacity + step + bytesInGiB - 1) / bytesInGiB * bytesInGiB
+	if p.maxSize != 0 && size > p.maxSize {
+		// Shares are sized in GiB, never exceed the maximum size
+		size = p.maxSize / bytesInGiB * bytesInGiB
+	}
+
+	if size <= capacity {
+		return 0
+	}
+
+	return size
+}
+
+func (d *Driver) runAutoExpansion() {
+	klog.Infof("Checking the usage of the volumes for automatic expansion every %v", d.autoExpansion.Interval)
+
+	go wait.Forever(func() {
+		ctx := context.Background()
+
+		usage, err := kubeletVolumeUsage(ctx, d.autoExpansion.KubeClient)
+		if err != nil {
+			klog.Errorf("failed to get the volume usage: %v", err)
+			return
+		}
+
+		if err := d.autoExpandVolumes(ctx, usage); err != nil {
+			klog.Errorf("failed to expand volumes: %v", err)
+		}
+	}, d.autoExpansion.Interval)
+}
+
+// autoExpansionPolicies returns the automatic expansion policies of the StorageClasses of the driver, by
+// StorageClass name.
+func (d *Driver) autoExpansionPolicies(ctx context.Context) (map[string]*autoExpansionPolicy, error) {
+	scs, err := d.autoExpansion.KubeClient.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list StorageClasses: %v", err)
+	}
+
+	policies := make(map[string]*autoExpansionPolicy)
+
+	for i := range scs.Items {
+		sc := &scs.Items[i]
+		if sc.Provisioner != d.name {
+			continue
+		}
+
+		p, err := newAutoExpansionPolicy(
+			sc.Parameters["autoExpansionThreshold"],
+			sc.Parameters["autoExpansionStep"],
+			sc.Parameters["autoExpansionMaxSize"],
+		)
+		if err != nil {
+			klog.Errorf("invalid automatic expansion policy of StorageClass %s: %v", sc.Name, err)
+			continue
+		}
+		if p == nil {
+			continue
+		}
+
+		if sc.AllowVolumeExpansion == nil || !*sc.AllowVolumeExpansion {
+			klog.Warningf("Not expanding the volumes of StorageClass %s automatically, it doesn't allow volume expansion", sc.Name)
+			continue
+		}
+
+		policies[sc.Name] = p
+	}
+
+	return policies, nil
+}
+
+// autoExpandVolumes expands the PersistentVolumeClaims whose usage crossed the threshold of their StorageClass.
+// usage is the used bytes of the volumes, by PersistentVolumeClaim namespace/name.
+func (d *Driver) autoExpandVolumes(ctx context.Context, usage map[string]int64) error {
+	policies, err := d.autoExpansionPolicies(ctx)
+	if err != nil {
+		return err
+	}
+	if len(policies) == 0 {
+		return nil
+	}
+
+	pvcs, err := d.autoExpansion.KubeClient.CoreV1().PersistentVolumeClaims(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list PersistentVolumeClaims: %v", err)
+	}
+
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if pvc.Spec.StorageClassName == nil || pvc.Status.Phase != v1.ClaimBound {
+			continue
+		}
+
+		p, ok := policies[*pvc.Spec.StorageClassName]
+		if !ok {
+			continue
+		}
+
+		used, ok := usage[pvc.Namespace+"/"+pvc.Name]
+		if !ok {
+			// The volume isn't mounted anywhere
+			continue
+		}
+
+		if err := d.autoExpandVolume(ctx, pvc, p, used); err != nil {
+			klog.Errorf("failed to expand PersistentVolumeClaim %s/%s: %v", pvc.Namespace, pvc.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (d *Driver) autoExpandVolume(ctx context.Context, pvc *v1.PersistentVolumeClaim, p *autoExpansionPolicy, used int64) error {
+	capacity, ok := pvc.Status.Capacity[v1.ResourceStorage]
+	if !ok {
+		return nil
+	}
+
+	if request, ok := pvc.Spec.Resources.Requests[v1.ResourceStorage]; ok && request.Cmp(capacity) > 0 {
+		klog.V(4).Infof("Not expanding PersistentVolumeClaim %s/%s, an expansion to %s is in progress", pvc.Namespace, pvc.Name, request.String())
+		return nil
+	}
+
+	size := p.expandedSize(capacity.Value(), used)
+	if size == 0 {
+		if p.maxSize != 0 && used*100 >= p.threshold*capacity.Value() {
+			klog.Warningf("PersistentVolumeClaim %s/%s uses %d of %s bytes, but has reached its maximum size", pvc.Namespace, pvc.Name, used, capacity.String())
+		}
+		return nil
+	}
+
+	q := resource.NewQuantity(size, resource.BinarySI)
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"resources": map[string]interface{}{
+				"requests": map[string]string{
+					string(v1.ResourceStorage): q.String(),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := d.autoExpansion.KubeClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Patch(ctx, pvc.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to patch the storage request: %v", err)
+	}
+
+	klog.Infof("Expanding PersistentVolumeClaim %s/%s from %s to %s, %d bytes used", pvc.Namespace, pvc.Name, capacity.String(), q.String(), used)
+
+	return nil
+}
+
+// kubeletStatsSummary is the subset of the kubelet stats summary holding the volume usage, as reported by
+// NodeGetVolumeStats.
+type kubeletStatsSummary struct {
+	Pods []struct {
+		Volumes []struct {
+			UsedBytes *int64 `json:"usedBytes"`
+			PVCRef    *struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"pvcRef"`
+		} `json:"volume"`
+	} `json:"pods"`
+}
+
+// kubeletVolumeUsage returns the used bytes of the volumes of the PersistentVolumeClaims mounted on the nodes,
+// by PersistentVolumeClaim namespace/name, from the stats summary of the kubelets.
+func kubeletVolumeUsage(ctx context.Context, kubeClient kubernetes.Interface) (map[string]int64, error) {
+	nodes, err := kubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+
+	usage := make(map[string]int64)
+
+	for _, node := range nodes.Items {
+		data, err := kubeClient.CoreV1().RESTClient().Get().
+			Resource("nodes").Name(node.Name).SubResource("proxy").Suffix("stats/summary").
+			DoRaw(ctx)
+		if err != nil {
+			klog.Errorf("failed to get the stats summary of node %s: %v", node.Name, err)
+			continue
+		}
+
+		if err := addVolumeUsage(usage, data); err != nil {
+			klog.Errorf("failed to parse the stats summary of node %s: %v", node.Name, err)
+		}
+	}
+
+	return usage, nil
+}
+
+// addVolumeUsage adds the usage of the volumes found in a kubelet stats summary. A volume mounted by several
+// pods, possibly on several nodes, is accounted once with the highest usage.
+func addVolumeUsage(usage map[string]int64, data []byte) error {
+	var summary kubeletStatsSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		return err
+	}
+
+	for _, pod := range summary.Pods {
+		for _, vol := range pod.Volumes {
+			if vol.PVCRef == nil || vol.UsedBytes == nil {
+				continue
+			}
+
+			key := vol.PVCRef.Namespace + "/" + vol.PVCRef.Name
+			if used, ok := usage[key]; !ok || *vol.UsedBytes > used {
+				usage[key] = *vol.UsedBytes
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAutoExpansionPolicy(t *testing.T) {
+	ts := []struct {
+		threshold, step, maxSize string
+		capacity, used           int64
+		expected                 int64
+		err                      bool
+	}{
+		{threshold: "", expected: -1},
+		{threshold: "80", capacity: 10 * bytesInGiB, used: 7 * bytesInGiB, expected: 0},
+		// Default step of 10%, rounded up to GiB
+		{threshold: "80", capacity: 10 * bytesInGiB, used: 8 * bytesInGiB, expected: 11 * bytesInGiB},
+		{threshold: "80%", step: "25%", capacity: 10 * bytesInGiB, used: 9 * bytesInGiB, expected: 13 * bytesInGiB},
+		{threshold: "90", step: "5Gi", capacity: 10 * bytesInGiB, used: 9 * bytesInGiB, expected: 15 * bytesInGiB},
+		{threshold: "90", step: "5Gi", maxSize: "12Gi", capacity: 10 * bytesInGiB, used: 10 * bytesInGiB, expected: 12 * bytesInGiB},
+		{threshold: "90", step: "5Gi", maxSize: "12Gi", capacity: 12 * bytesInGiB, used: 12 * bytesInGiB, expected: 0},
+		{threshold: "0", err: true},
+		{threshold: "100", err: true},
+		{threshold: "eighty", err: true},
+		{threshold: "80", step: "0%", err: true},
+		{threshold: "80", step: "-1Gi", err: true},
+		{threshold: "80", maxSize: "big", err: true},
+	}
+
+	for _, tc := range ts {
+		p, err := newAutoExpansionPolicy(tc.threshold, tc.step, tc.maxSize)
+		if tc.err {
+			if err == nil {
+				t.Errorf("newAutoExpansionPolicy(%q, %q, %q): expected an error", tc.threshold, tc.step, tc.maxSize)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("newAutoExpansionPolicy(%q, %q, %q): unexpected error: %v", tc.threshold, tc.step, tc.maxSize, err)
+			continue
+		}
+		if p == nil {
+			if tc.expected != -1 {
+				t.Errorf("newAutoExpansionPolicy(%q, %q, %q): expected a policy", tc.threshold, tc.step, tc.maxSize)
+			}
+			continue
+		}
+
+		if size := p.expandedSize(tc.capacity, tc.used); size != tc.expected {
+			t.Errorf("policy (%q, %q, %q): expected size %d for %d bytes used of %d, got %d", tc.threshold, tc.step, tc.maxSize, tc.expected, tc.used, tc.capacity, size)
+		}
+	}
+}
+
+func TestAddVolumeUsage(t *testing.T) {
+	usage := map[string]int64{"default/data": 5}
+
+	summary := `{"pods": [
+		{"volume": [{"name": "data", "usedBytes": 3, "pvcRef": {"name": "data", "namespace": "default"}}]},
+		{"volume": [{"name": "data", "usedBytes": 7, "pvcRef": {"name": "data", "namespace": "default"}}]},
+		{"volume": [{"name": "cache", "usedBytes": 1}, {"name": "logs", "usedBytes": 2, "pvcRef": {"name": "logs", "namespace": "app"}}]}
+	]}`
+
+	if err := addVolumeUsage(usage, []byte(summary)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(usage) != 2 || usage["default/data"] != 7 || usage["app/logs"] != 2 {
+		t.Errorf("unexpected volume usage: %v", usage)
+	}
+}
+
+func TestAutoExpandVolumes(t *testing.T) {
+	allow := true
+
+	newSC := func(name, provisioner string, params map[string]string) *storagev1.StorageClass {
+		return &storagev1.StorageClass{
+			ObjectMeta:           metav1.ObjectMeta{Name: name},
+			Provisioner:          provisioner,
+			Parameters:           params,
+			AllowVolumeExpansion: &allow,
+		}
+	}
+
+	newPVC := func(name, sc, request, capacity string) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				StorageClassName: &sc,
+				Resources: corev1.VolumeResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(request)},
+				},
+			},
+			Status: corev1.PersistentVolumeClaimStatus{
+				Phase:    corev1.ClaimBound,
+				Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(capacity)},
+			},
+		}
+	}
+
+	kubeClient := fake.NewSimpleClientset(
+		newSC("auto", "manila.csi.openstack.org", map[string]string{"autoExpansionThreshold": "80", "autoExpansionStep": "5Gi"}),
+		newSC("manual", "manila.csi.openstack.org", nil),
+		newSC("other", "cinder.csi.openstack.org", map[string]string{"autoExpansionThreshold": "80"}),
+		newPVC("full", "auto", "10Gi", "10Gi"),
+		newPVC("empty", "auto", "10Gi", "10Gi"),
+		newPVC("resizing", "auto", "20Gi", "10Gi"),
+		newPVC("unmounted", "auto", "10Gi", "10Gi"),
+		newPVC("manual", "manual", "10Gi", "10Gi"),
+		newPVC("other", "other", "10Gi", "10Gi"),
+	)
+
+	d := &Driver{
+		name:          "manila.csi.openstack.org",
+		autoExpansion: AutoExpansionOpts{KubeClient: kubeClient},
+	}
+
+	usage := map[string]int64{
+		"default/full":     9 * bytesInGiB,
+		"default/empty":    1 * bytesInGiB,
+		"default/resizing": 10 * bytesInGiB,
+		"default/manual":   10 * bytesInGiB,
+		"default/other":    10 * bytesInGiB,
+	}
+
+	if err := d.autoExpandVolumes(context.Background(), usage); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]string{
+		"full":      "15Gi",
+		"empty":     "10Gi",
+		"resizing":  "20Gi",
+		"unmounted": "10Gi",
+		"manual":    "10Gi",
+		"other":     "10Gi",
+	}
+
+	for name, size := range expected {
+		pvc, err := kubeClient.CoreV1().PersistentVolumeClaims("default").Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get PersistentVolumeClaim %s: %v", name, err)
+		}
+
+		request := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		if request.Cmp(resource.MustParse(size)) != 0 {
+			t.Errorf("PersistentVolumeClaim %s: expected request %s, got %s", name, size, request.String())
+		}
+	}
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/schedulerstats"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/services"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
+	"k8s.io/klog/v2"
+)
+
+// CapacityOpts configures GetCapacity, which reports the free capacity of the Manila scheduler pools
+// for the capacity tracking of the external-provisioner.
+type CapacityOpts struct {
+	// SecretDir is a directory containing the OpenStack credentials, one file per key, in the same
+	// format as the CSI secrets. GetCapacity requests don't carry secrets, and GetCapacity is
+	// disabled if empty. Listing the scheduler pools is usually restricted to administrators.
+	SecretDir string
+	// CacheTTL is the time the capacity of a share type and availability zone is cached for.
+	CacheTTL time.Duration
+}
+
+const shareServiceUp = "up"
+
+type capacityKey struct {
+	shareType    string
+	availability string
+}
+
+type capacityEntry struct {
+	available int64
+	maximum   int64
+	expires   time.Time
+}
+
+// capacityCache caches the capacity per share type and availability zone, the external-provisioner
+// queries it for every StorageClass and topology segment.
+type capacityCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[capacityKey]capacityEntry
+}
+
+func newCapacityCache(ttl time.Duration) *capacityCache {
+	return &capacityCache{ttl: ttl, entries: make(map[capacityKey]capacityEntry)}
+}
+
+func (c *capacityCache) get(key capacityKey, now time.Time) (capacityEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || now.After(e.expires) {
+		return capacityEntry{}, false
+	}
+
+	return e, true
+}
+
+func (c *capacityCache) set(key capacityKey, available, maximum int64, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = capacityEntry{available: available, maximum: maximum, expires: now.Add(c.ttl)}
+}
+
+// getCapacity returns the free capacity of the pools of the share type in the availability zone, and the free
+// capacity of the largest pool, in bytes.
+func (d *Driver) getCapacity(shareType, availability string) (available, maximum int64, err error) {
+	key := capacityKey{shareType: shareType, availability: availability}
+	now := time.Now()
+	if e, ok := d.cs.capacityCache.get(key, now); ok {
+		return e.available, e.maximum, nil
+	}
+
+	secrets, err := readSecretDir(d.capacity.SecretDir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	osOpts, err := options.NewOpenstackOptions(secrets)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid OpenStack secrets in %s: %v", d.capacity.SecretDir, err)
+	}
+
+	manilaClient, err := d.manilaClientBuilder.New(osOpts)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to create Manila v2 client: %v", err)
+	}
+
+	pools, err := manilaClient.GetPools(shareType)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list the pools of share type %s: %v", shareType, err)
+	}
+
+	var backends map[string]bool
+	if availability != "" {
+		svcs, err := manilaClient.GetShareServices()
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to list share services: %v", err)
+		}
+		backends = zoneBackends(svcs, availability)
+	}
+
+	available, maximum = poolsCapacity(pools, backends)
+	klog.V(4).Infof("capacity of share type %s in availability zone %q: %d bytes, largest pool %d bytes",
+		shareType, availability, available, maximum)
+
+	d.cs.capacityCache.set(key, available, maximum, now)
+
+	return available, maximum, nil
+}
+
+// zoneBackends returns the backends, in host@backend format, of the share services up in the availability zone.
+func zoneBackends(svcs []services.Service, availability string) map[string]bool {
+	backends := make(map[string]bool)
+	for _, svc := range svcs {
+		if svc.Zone == availability && svc.State == shareServiceUp {
+			backends[svc.Host] = true
+		}
+	}
+
+	return backends
+}
+
+// poolsCapacity sums the free capacity of the pools of the backends, all pools if backends is nil, minus their
+// reserved capacity. Pools reporting an unknown free capacity are ignored, the capacity of pools reporting an
+// infinite free capacity is capped to math.MaxInt64.
+func poolsCapacity(pools []schedulerstats.Pool, backends map[string]bool) (available, maximum int64) {
+	for _, p := range pools {
+		if backends != nil && !backends[poolBackend(&p)] {
+			continue
+		}
+
+		c := p.Capabilities
+		free := c.FreeCapacityGB
+		if !math.IsInf(c.TotalCapacityGB, 1) {
+			free -= c.TotalCapacityGB * float64(c.ReservedPercentage) / 100
+		}
+		if free <= 0 {
+			continue
+		}
+
+		bytes := gbToBytes(free)
+		if available > math.MaxInt64-bytes {
+			available = math.MaxInt64
+		} else {
+			available += bytes
+		}
+		if bytes > maximum {
+			maximum = bytes
+		}
+	}
+
+	return available, maximum
+}
+
+// poolBackend returns the host@backend the pool belongs to, as reported by the share services.
+func poolBackend(p *schedulerstats.Pool) string {
+	if p.Host != "" && p.Backend != "" {
+		return p.Host + "@" + p.Backend
+	}
+
+	// The pool name is host@backend#pool
+	backend, _, _ := strings.Cut(p.Name, "#")
+	return backend
+}
+
+func gbToBytes(gb float64) int64 {
+	if gb >= float64(math.MaxInt64/bytesInGiB) {
+		return math.MaxInt64
+	}
+
+	return int64(gb * float64(bytesInGiB))
+}
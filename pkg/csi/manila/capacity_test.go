@@ -0,0 +1,199 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/schedulerstats"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/services"
+	"k8s.io/cloud-provider-openstack/pkg/client"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+)
+
+// fakeCapacityClient serves the pools and share services and records the share types queried.
+type fakeCapacityClient struct {
+	manilaclient.Interface
+	pools      []schedulerstats.Pool
+	services   []services.Service
+	shareTypes []string
+}
+
+func (c *fakeCapacityClient) GetPools(shareType string) ([]schedulerstats.Pool, error) {
+	c.shareTypes = append(c.shareTypes, shareType)
+	return c.pools, nil
+}
+
+func (c *fakeCapacityClient) GetShareServices() ([]services.Service, error) {
+	return c.services, nil
+}
+
+type fakeCapacityClientBuilder struct {
+	c *fakeCapacityClient
+}
+
+func (b fakeCapacityClientBuilder) New(*client.AuthOpts) (manilaclient.Interface, error) {
+	return b.c, nil
+}
+
+func testPool(host, backend string, free, total float64, reserved int64) schedulerstats.Pool {
+	return schedulerstats.Pool{
+		Name:    host + "@" + backend + "#pool",
+		Host:    host,
+		Backend: backend,
+		Capabilities: schedulerstats.Capabilities{
+			FreeCapacityGB:     free,
+			TotalCapacityGB:    total,
+			ReservedPercentage: reserved,
+		},
+	}
+}
+
+func TestPoolsCapacity(t *testing.T) {
+	pools := []schedulerstats.Pool{
+		testPool("host-a", "cephfs", 100, 200, 0),
+		// 10% of 200 GiB reserved
+		testPool("host-b", "cephfs", 50, 200, 10),
+		// Unknown free capacity
+		testPool("host-c", "cephfs", 0, 0, 0),
+		// Fully reserved
+		testPool("host-d", "cephfs", 5, 100, 10),
+	}
+
+	ts := []struct {
+		backends          map[string]bool
+		expectedAvailable int64
+		expectedMaximum   int64
+	}{
+		{nil, 130 * bytesInGiB, 100 * bytesInGiB},
+		{map[string]bool{"host-b@cephfs": true, "host-c@cephfs": true}, 30 * bytesInGiB, 30 * bytesInGiB},
+		{map[string]bool{}, 0, 0},
+	}
+
+	for i, tc := range ts {
+		available, maximum := poolsCapacity(pools, tc.backends)
+		if available != tc.expectedAvailable || maximum != tc.expectedMaximum {
+			t.Errorf("test case %d: expected (%d, %d), got (%d, %d)", i, tc.expectedAvailable, tc.expectedMaximum, available, maximum)
+		}
+	}
+
+	// Infinite free capacity is capped
+	available, maximum := poolsCapacity(append(pools, testPool("host-e", "generic", math.Inf(1), math.Inf(1), 0)), nil)
+	if available != math.MaxInt64 || maximum != math.MaxInt64 {
+		t.Errorf("expected infinite capacity to be capped, got (%d, %d)", available, maximum)
+	}
+}
+
+func TestZoneBackends(t *testing.T) {
+	svcs := []services.Service{
+		{Host: "host-a@cephfs", Zone: "zone-1", State: "up"},
+		{Host: "host-b@cephfs", Zone: "zone-1", State: "down"},
+		{Host: "host-c@cephfs", Zone: "zone-2", State: "up"},
+	}
+
+	expected := map[string]bool{"host-a@cephfs": true}
+	if backends := zoneBackends(svcs, "zone-1"); !reflect.DeepEqual(backends, expected) {
+		t.Errorf("expected backends %v, got %v", expected, backends)
+	}
+
+	// Pools reported without host and backend fall back to their name
+	p := schedulerstats.Pool{Name: "host-a@cephfs#pool"}
+	if backend := poolBackend(&p); backend != "host-a@cephfs" {
+		t.Errorf("expected backend host-a@cephfs, got %s", backend)
+	}
+}
+
+func TestCapacityCache(t *testing.T) {
+	c := newCapacityCache(time.Minute)
+	key := capacityKey{shareType: "default", availability: "zone-1"}
+	now := time.Now()
+
+	if _, ok := c.get(key, now); ok {
+		t.Error("expected an empty cache")
+	}
+
+	c.set(key, 10, 5, now)
+	if e, ok := c.get(key, now.Add(30*time.Second)); !ok || e.available != 10 || e.maximum != 5 {
+		t.Errorf("expected cached capacity (10, 5), got %v, %t", e, ok)
+	}
+	if _, ok := c.get(capacityKey{shareType: "default"}, now); ok {
+		t.Error("expected the capacity of another availability zone not to be cached")
+	}
+	if _, ok := c.get(key, now.Add(2*time.Minute)); ok {
+		t.Error("expected the cached capacity to expire")
+	}
+}
+
+func TestGetCapacity(t *testing.T) {
+	dir := t.TempDir()
+	for k, v := range map[string]string{"os-authURL": "https://keystone", "os-userName": "admin", "os-password": "secret", "os-projectName": "admin", "os-domainName": "default", "os-region": "RegionOne"} {
+		if err := os.WriteFile(filepath.Join(dir, k), []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &fakeCapacityClient{
+		pools: []schedulerstats.Pool{
+			testPool("host-a", "cephfs", 100, 200, 0),
+			testPool("host-b", "cephfs", 50, 200, 0),
+		},
+		services: []services.Service{
+			{Host: "host-a@cephfs", Zone: "zone-1", State: "up"},
+			{Host: "host-b@cephfs", Zone: "zone-2", State: "up"},
+		},
+	}
+	d := &Driver{
+		shareProto:          "CEPHFS",
+		withTopology:        true,
+		capacity:            CapacityOpts{SecretDir: dir, CacheTTL: time.Minute},
+		manilaClientBuilder: fakeCapacityClientBuilder{c},
+	}
+	d.cs = &controllerServer{d: d, capacityCache: newCapacityCache(time.Minute)}
+
+	ts := []struct {
+		params            map[string]string
+		topology          *csi.Topology
+		expectedAvailable int64
+	}{
+		{map[string]string{"type": "gold"}, nil, 150 * bytesInGiB},
+		{map[string]string{"type": "gold", "availability": "zone-1"}, nil, 100 * bytesInGiB},
+		{map[string]string{"type": "gold", "autoTopology": "true"}, &csi.Topology{Segments: map[string]string{topologyKey: "zone-2"}}, 50 * bytesInGiB},
+		// Served from the cache
+		{map[string]string{"type": "gold"}, nil, 150 * bytesInGiB},
+	}
+
+	for i, tc := range ts {
+		resp, err := d.cs.GetCapacity(context.Background(), &csi.GetCapacityRequest{Parameters: tc.params, AccessibleTopology: tc.topology})
+		if err != nil {
+			t.Fatalf("test case %d: unexpected error: %v", i, err)
+		}
+		if resp.GetAvailableCapacity() != tc.expectedAvailable {
+			t.Errorf("test case %d: expected available capacity %d, got %d", i, tc.expectedAvailable, resp.GetAvailableCapacity())
+		}
+	}
+
+	if !reflect.DeepEqual(c.shareTypes, []string{"gold", "gold", "gold"}) {
+		t.Errorf("expected 3 queries of the pools of share type gold, got %v", c.shareTypes)
+	}
+}
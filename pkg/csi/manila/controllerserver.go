@@ -19,13 +19,18 @@ package manila
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"strings"
 	"sync"
+	"text/template"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/shareadapters"
@@ -34,10 +39,19 @@ import (
 	"k8s.io/klog/v2"
 )
 
-const clusterMetadataKey = "manila.csi.openstack.org/cluster"
+const (
+	clusterMetadataKey    = "manila.csi.openstack.org/cluster"
+	volumeNameMetadataKey = "manila.csi.openstack.org/volume-name"
+
+	// Maximum length of a Manila share name
+	maxShareNameLength = 255
+)
 
 type controllerServer struct {
 	d *Driver
+
+	// capacityCache is nil if GetCapacity is disabled
+	capacityCache *capacityCache
 }
 
 var (
@@ -54,9 +68,9 @@ var (
 	}
 )
 
-func getVolumeCreator(source *csi.VolumeContentSource) (volumeCreator, error) {
+func getVolumeCreator(source *csi.VolumeContentSource, waitTimeout time.Duration) (volumeCreator, error) {
 	if source == nil {
-		return &blankVolume{}, nil
+		return &blankVolume{waitTimeout: waitTimeout}, nil
 	}
 
 	if source.GetVolume() != nil {
@@ -64,7 +78,7 @@ func getVolumeCreator(source *csi.VolumeContentSource) (volumeCreator, error) {
 	}
 
 	if source.GetSnapshot() != nil {
-		return &volumeFromSnapshot{}, nil
+		return &volumeFromSnapshot{waitTimeout: waitTimeout}, nil
 	}
 
 	return nil, status.Error(codes.InvalidArgument, "invalid volume content source")
@@ -101,10 +115,76 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 		return nil, status.Errorf(codes.InvalidArgument, "invalid volume parameters: %v", err)
 	}
 
+	shareWaitTimeout, err := parseWaitTimeout(shareOpts.ShareWaitTimeout, cs.d.shareWaitTimeout)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid volume parameter shareWaitTimeout: %v", err)
+	}
+
+	accessKeyWaitTimeout, err := parseWaitTimeout(shareOpts.CephfsAccessKeyTimeout, cs.d.accessKeyWaitTimeout)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid volume parameter cephfs-accessKeyTimeout: %v", err)
+	}
+
+	if _, err := parseZoneMap(shareOpts.AutoTopologyZoneMap); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid volume parameter autoTopologyZoneMap: %v", err)
+	}
+
+	nodeAccess, err := cs.validateNFSNodeAccess(shareOpts)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid volume parameters: %v", err)
+	}
+
+	if _, err := newAutoExpansionPolicy(shareOpts.AutoExpansionThreshold, shareOpts.AutoExpansionStep, shareOpts.AutoExpansionMaxSize); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid volume parameters: %v", err)
+	}
+
+	sizePolicy, err := newShareSizePolicy(shareOpts.MinShareSize, shareOpts.ShareSizeGranularity, shareOpts.ShareSizePolicy)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid volume parameters: %v", err)
+	}
+
+	requestedSize := req.GetCapacityRange().GetRequiredBytes()
+	if requestedSize == 0 {
+		// At least 1GiB
+		requestedSize = 1 * bytesInGiB
+	}
+
+	sizeInGiB, err := sizePolicy.shareSize(requestedSize, req.GetCapacityRange().GetLimitBytes())
+	if err != nil {
+		return nil, shareSizeError(err)
+	}
+
 	shareMetadata, err := prepareShareMetadata(shareOpts.AppendShareMetadata, cs.d.clusterID, params)
 	if err != nil {
 		return nil, err
 	}
+	sizePolicy.metadata(shareMetadata)
+
+	if shareOpts.ShareMetadataLabels != "" {
+		if err := cs.addLabelShareMetadata(ctx, shareOpts.ShareMetadataLabels, params, shareMetadata); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+	}
+
+	if err := cs.addAnnotationSchedulerHints(ctx, shareOpts, params); err != nil {
+		return nil, err
+	}
+
+	mountOptions, err := cs.annotationMountOptions(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	shareName := req.GetName()
+	if cs.d.shareNameTemplate != nil {
+		if shareName, err = renderShareName(cs.d.shareNameTemplate, cs.d.clusterID, req.GetName(), params); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "failed to generate share name for volume %s: %v", req.GetName(), err)
+		}
+
+		// Shares named by a template are no longer guaranteed to be unique per volume,
+		// remember which volume the share belongs to so that collisions can be detected.
+		shareMetadata[volumeNameMetadataKey] = req.GetName()
+	}
 
 	osOpts, err := options.NewOpenstackOptions(req.GetSecrets())
 	if err != nil {
@@ -119,17 +199,9 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 
 	manilaClient, err := cs.d.manilaClientBuilder.New(osOpts)
 	if err != nil {
-		return nil, status.Errorf(codes.Unauthenticated, "failed to create Manila v2 client: %v", err)
+		return nil, statusErrorf(codes.Unauthenticated, "failed to create Manila v2 client: %w", err)
 	}
 
-	requestedSize := req.GetCapacityRange().GetRequiredBytes()
-	if requestedSize == 0 {
-		// At least 1GiB
-		requestedSize = 1 * bytesInGiB
-	}
-
-	sizeInGiB := bytesToGiB(requestedSize)
-
 	var accessibleTopology []*csi.Topology
 	accessibleTopologyReq := req.GetAccessibilityRequirements()
 	if cs.d.withTopology && accessibleTopologyReq != nil {
@@ -138,47 +210,124 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 		accessibleTopology = accessibleTopologyReq.GetPreferred()
 
 		// When "autoTopology" is enabled and "availability" is empty, obtain the AZ from the target node.
+		// The AZ of the node may be mapped to a Manila AZ of another name, and to a share type.
 		if shareOpts.AvailabilityZone == "" && strings.EqualFold(shareOpts.AutoTopology, "true") {
-			shareOpts.AvailabilityZone = util.GetAZFromTopology(topologyKey, accessibleTopologyReq)
+			nodeAZ := util.GetAZFromTopology(topologyKey, accessibleTopologyReq)
+			if shareOpts.AvailabilityZone, shareOpts.Type, err = mapZone(shareOpts, nodeAZ); err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to map availability zone %s: %v", nodeAZ, err)
+			}
 			accessibleTopology = []*csi.Topology{{
-				Segments: map[string]string{topologyKey: shareOpts.AvailabilityZone},
+				Segments: map[string]string{topologyKey: nodeAZ},
 			}}
 		}
 	}
 
+	if shareOpts.ShareNetworkID != "" || shareOpts.ShareNetworkName != "" {
+		if shareOpts.ShareNetworkID, err = resolveShareNetwork(manilaClient, shareOpts); err != nil {
+			return nil, err
+		}
+	}
+
+	if shareOpts.EncryptionKeyRef != "" {
+		if shareOpts.EncryptionKeyRef, err = resolveEncryptionKeyRef(manilaClient, shareOpts.EncryptionKeyRef); err != nil {
+			return nil, err
+		}
+	}
+
+	if shareOpts.SecurityServiceID != "" {
+		if err := ensureSecurityService(manilaClient, shareOpts.ShareNetworkID, shareOpts.SecurityServiceID); err != nil {
+			return nil, err
+		}
+	}
+
 	// Retrieve an existing share or create a new one
 
-	volCreator, err := getVolumeCreator(req.GetVolumeContentSource())
+	volCreator, err := getVolumeCreator(req.GetVolumeContentSource(), shareWaitTimeout)
 	if err != nil {
 		return nil, err
 	}
 
-	share, err := volCreator.create(manilaClient, req, req.GetName(), sizeInGiB, shareOpts, shareMetadata)
+	protocols, err := cs.shareProtocols(shareOpts)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	share, err := createWithProtocolFallback(volCreator, manilaClient, req, shareName, sizeInGiB, shareOpts, shareMetadata, protocols)
 	if err != nil {
 		return nil, err
 	}
 
+	if cs.d.shareNameTemplate != nil && share.Metadata[volumeNameMetadataKey] != req.GetName() {
+		return nil, status.Errorf(codes.AlreadyExists, "share name %s for volume %s collides with an existing share %s owned by volume %s",
+			shareName, req.GetName(), share.ID, coalesceValue(share.Metadata[volumeNameMetadataKey]))
+	}
+
 	err = verifyVolumeCompatibility(sizeInGiB, req, share, shareOpts)
 	if err != nil {
 		return nil, status.Errorf(codes.AlreadyExists, "volume %s already exists, but is incompatible with the request: %v", req.GetName(), err)
 	}
 
-	// Grant access to the share
-
-	ad := getShareAdapter(shareOpts.Protocol)
-
-	accessRight, err := ad.GetOrGrantAccess(&shareadapters.GrantAccessArgs{Share: share, ManilaClient: manilaClient, Options: shareOpts})
-	if err != nil {
-		if wait.Interrupted(err) {
-			return nil, status.Errorf(codes.DeadlineExceeded, "deadline exceeded while waiting for access rule %s for volume %s to become available", accessRight.ID, share.Name)
+	if shareOpts.ReplicaAvailability != "" {
+		if _, err := getOrCreateShareReplica(manilaClient, share, shareOpts.ReplicaAvailability); err != nil {
+			return nil, err
 		}
+		cs.awaitReplicas(manilaClient, share.ID, req.GetName())
 
-		return nil, status.Errorf(codes.Internal, "failed to grant access to volume %s: %v", share.Name, err)
+		if cs.d.withTopology {
+			accessibleTopology = withReplicaTopology(accessibleTopology, shareOpts.ReplicaAvailability)
+		}
 	}
 
 	volCtx := filterParametersForVolumeContext(params, options.NodeVolumeContextFields())
+	if _, ok := volCtx["subPathPattern"]; ok {
+		// The sub-path pattern is rendered on the node, pass on the values it may reference
+		for k, v := range filterParametersForVolumeContext(params, subPathVolumeContextKeys) {
+			volCtx[k] = v
+		}
+	}
+	// Read-only access is only meant for pre-provisioned volumes, the provisioned share is accessed with its own access right
+	delete(volCtx, "readOnlyAccessTo")
 	volCtx["shareID"] = share.ID
-	volCtx["shareAccessID"] = accessRight.ID
+	for k, v := range mountOptions {
+		volCtx[k] = v
+	}
+
+	if nodeAccess {
+		// Access is granted to the nodes the volume is attached to, see ControllerPublishVolume
+		volCtx[nodeAccessVolumeContextKey] = "true"
+		if shareOpts.NFSNodeCIDRs != "" {
+			volCtx[nodeCIDRsVolumeContextKey] = shareOpts.NFSNodeCIDRs
+		}
+	} else {
+		// Grant access to the share
+
+		ad := getShareAdapter(shareOpts.Protocol)
+
+		async := cs.d.asyncAccessRights.Enabled && strings.EqualFold(shareOpts.Protocol, "CEPHFS")
+
+		accessRight, err := ad.GetOrGrantAccess(&shareadapters.GrantAccessArgs{
+			Share:        share,
+			ManilaClient: manilaClient,
+			Options:      shareOpts,
+			AccessLevel:  accessLevelForCapabilities(req.GetVolumeCapabilities()),
+			Async:        async,
+			KeyTimeout:   accessKeyWaitTimeout,
+		})
+		if err != nil {
+			if wait.Interrupted(err) {
+				return nil, status.Errorf(codes.DeadlineExceeded, "deadline exceeded while waiting for access rule %s for volume %s to become available", accessRight.ID, share.Name)
+			}
+
+			return nil, statusErrorf(codes.Internal, "failed to grant access to volume %s: %w", share.Name, err)
+		}
+
+		if async && accessRight.AccessKey == "" {
+			// The PV is named after the volume by the external-provisioner
+			cs.awaitAccessRight(manilaClient, share.ID, accessRight.ID, req.GetName())
+		}
+
+		volCtx["shareAccessID"] = accessRight.ID
+	}
 
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
@@ -203,11 +352,16 @@ func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVol
 
 	manilaClient, err := cs.d.manilaClientBuilder.New(osOpts)
 	if err != nil {
-		return nil, status.Errorf(codes.Unauthenticated, "failed to create Manila v2 client: %v", err)
+		return nil, statusErrorf(codes.Unauthenticated, "failed to create Manila v2 client: %w", err)
+	}
+
+	if err := deleteShareReplicas(manilaClient, req.GetVolumeId()); err != nil {
+		return nil, err
 	}
 
 	if err := deleteShare(manilaClient, req.GetVolumeId()); err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to delete volume %s: %v", req.GetVolumeId(), err)
+		releaseUndeletedShare(manilaClient, req.GetVolumeId())
+		return nil, statusErrorf(codes.Internal, "failed to delete volume %s: %w", req.GetVolumeId(), err)
 	}
 
 	return &csi.DeleteVolumeResponse{}, nil
@@ -233,7 +387,7 @@ func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateS
 
 	manilaClient, err := cs.d.manilaClientBuilder.New(osOpts)
 	if err != nil {
-		return nil, status.Errorf(codes.Unauthenticated, "failed to create Manila v2 client: %v", err)
+		return nil, statusErrorf(codes.Unauthenticated, "failed to create Manila v2 client: %w", err)
 	}
 
 	// Retrieve the source share
@@ -241,10 +395,10 @@ func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateS
 	sourceShare, err := manilaClient.GetShareByID(req.GetSourceVolumeId())
 	if err != nil {
 		if clouderrors.IsNotFound(err) {
-			return nil, status.Errorf(codes.NotFound, "failed to create snapshot %s for volume %s because the volume doesn't exist: %v", req.GetName(), req.GetSourceVolumeId(), err)
+			return nil, statusErrorf(codes.NotFound, "failed to create snapshot %s for volume %s because the volume doesn't exist: %w", req.GetName(), req.GetSourceVolumeId(), err)
 		}
 
-		return nil, status.Errorf(codes.Internal, "failed to retrieve source volume %s when creating snapshot %s: %v", req.GetSourceVolumeId(), req.GetName(), err)
+		return nil, statusErrorf(codes.Internal, "failed to retrieve source volume %s when creating snapshot %s: %w", req.GetSourceVolumeId(), req.GetName(), err)
 	}
 
 	if strings.ToUpper(sourceShare.ShareProto) != cs.d.shareProto {
@@ -272,10 +426,10 @@ func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateS
 		}
 
 		if clouderrors.IsNotFound(err) {
-			return nil, status.Errorf(codes.NotFound, "failed to create snapshot %s for volume %s because the volume doesn't exist: %v", req.GetName(), req.GetSourceVolumeId(), err)
+			return nil, statusErrorf(codes.NotFound, "failed to create snapshot %s for volume %s because the volume doesn't exist: %w", req.GetName(), req.GetSourceVolumeId(), err)
 		}
 
-		return nil, status.Errorf(codes.Internal, "failed to create snapshot %s of volume  %s: %v", req.GetName(), req.GetSourceVolumeId(), err)
+		return nil, statusErrorf(codes.Internal, "failed to create snapshot %s of volume  %s: %w", req.GetName(), req.GetSourceVolumeId(), err)
 	}
 
 	if err = verifySnapshotCompatibility(snapshot, req); err != nil {
@@ -293,11 +447,11 @@ func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateS
 		readyToUse = true
 	case snapshotError:
 		// An error occurred, try to roll-back the snapshot
-		tryDeleteSnapshot(manilaClient, snapshot)
+		tryDeleteSnapshot(manilaClient, snapshot, cs.d.shareWaitTimeout)
 
 		manilaErrMsg, err := lastResourceError(manilaClient, snapshot.ID)
 		if err != nil {
-			return nil, status.Errorf(codes.Internal, "snapshot %s of volume %s is in error state, error description could not be retrieved: %v", snapshot.ID, req.GetSourceVolumeId(), err)
+			return nil, statusErrorf(codes.Internal, "snapshot %s of volume %s is in error state, error description could not be retrieved: %w", snapshot.ID, req.GetSourceVolumeId(), err)
 		}
 
 		return nil, status.Errorf(manilaErrMsg.errCode.toRPCErrorCode(), "snapshot %s of volume %s is in error state: %s", snapshot.ID, req.GetSourceVolumeId(), manilaErrMsg.message)
@@ -335,11 +489,17 @@ func (cs *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteS
 
 	manilaClient, err := cs.d.manilaClientBuilder.New(osOpts)
 	if err != nil {
-		return nil, status.Errorf(codes.Unauthenticated, "failed to create Manila v2 client: %v", err)
+		return nil, statusErrorf(codes.Unauthenticated, "failed to create Manila v2 client: %w", err)
+	}
+
+	if _, _, ok := parseMemberSnapshotID(req.GetSnapshotId()); ok {
+		// The snapshots of the members are deleted with their group snapshot
+		klog.V(4).Infof("snapshot %s is the snapshot of a group snapshot member, it is deleted with the group snapshot", req.GetSnapshotId())
+		return &csi.DeleteSnapshotResponse{}, nil
 	}
 
 	if err := deleteSnapshot(manilaClient, req.GetSnapshotId()); err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to delete snapshot %s: %v", req.GetSnapshotId(), err)
+		return nil, statusErrorf(codes.Internal, "failed to delete snapshot %s: %w", req.GetSnapshotId(), err)
 	}
 
 	return &csi.DeleteSnapshotResponse{}, nil
@@ -377,16 +537,16 @@ func (cs *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req
 
 	manilaClient, err := cs.d.manilaClientBuilder.New(osOpts)
 	if err != nil {
-		return nil, status.Errorf(codes.Unauthenticated, "failed to create Manila v2 client: %v", err)
+		return nil, statusErrorf(codes.Unauthenticated, "failed to create Manila v2 client: %w", err)
 	}
 
 	share, err := manilaClient.GetShareByID(req.GetVolumeId())
 	if err != nil {
 		if clouderrors.IsNotFound(err) {
-			return nil, status.Errorf(codes.NotFound, "volume %s not found: %v", req.GetVolumeId(), err)
+			return nil, statusErrorf(codes.NotFound, "volume %s not found: %w", req.GetVolumeId(), err)
 		}
 
-		return nil, status.Errorf(codes.Internal, "failed to retrieve volume %s: %v", req.GetVolumeId(), err)
+		return nil, statusErrorf(codes.Internal, "failed to retrieve volume %s: %w", req.GetVolumeId(), err)
 	}
 
 	if share.Status != shareAvailable {
@@ -397,7 +557,7 @@ func (cs *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req
 		return nil, status.Errorf(codes.FailedPrecondition, "volume %s is in an unexpected state: wanted %s, got %s", req.GetVolumeId(), shareAvailable, share.Status)
 	}
 
-	if !compareProtocol(share.ShareProto, cs.d.shareProto) {
+	if _, ok := cs.d.fwdEndpointFor(share.ShareProto); !ok {
 		return nil, status.Errorf(codes.InvalidArgument, "share protocol mismatch: wanted %s, got %s", cs.d.shareProto, share.ShareProto)
 	}
 
@@ -410,20 +570,44 @@ func (cs *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req
 	}, nil
 }
 
-func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
+func (cs *controllerServer) ListVolumes(context.Context, *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "")
 }
 
-func (cs *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
-}
+func (cs *controllerServer) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
+	if cs.capacityCache == nil {
+		return nil, status.Error(codes.Unimplemented, "")
+	}
 
-func (cs *controllerServer) ListVolumes(context.Context, *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
-}
+	params := make(map[string]string, len(req.GetParameters())+1)
+	for k, v := range req.GetParameters() {
+		params[k] = v
+	}
+	params["protocol"] = cs.d.shareProto
 
-func (cs *controllerServer) GetCapacity(context.Context, *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+	shareOpts, err := options.NewControllerVolumeContext(params)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid volume parameters: %v", err)
+	}
+
+	// Same availability zone and share type as the shares created by CreateVolume
+	availability, shareType := shareOpts.AvailabilityZone, shareOpts.Type
+	if availability == "" && cs.d.withTopology && strings.EqualFold(shareOpts.AutoTopology, "true") {
+		nodeAZ := req.GetAccessibleTopology().GetSegments()[topologyKey]
+		if availability, shareType, err = mapZone(shareOpts, nodeAZ); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to map availability zone %s: %v", nodeAZ, err)
+		}
+	}
+
+	available, maximum, err := cs.d.getCapacity(shareType, availability)
+	if err != nil {
+		return nil, statusErrorf(codes.Internal, "failed to get capacity of share type %s: %w", shareType, err)
+	}
+
+	return &csi.GetCapacityResponse{
+		AvailableCapacity: available,
+		MaximumVolumeSize: wrapperspb.Int64(maximum),
+	}, nil
 }
 
 func (cs *controllerServer) ListSnapshots(context.Context, *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
@@ -444,7 +628,7 @@ func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi
 
 	manilaClient, err := cs.d.manilaClientBuilder.New(osOpts)
 	if err != nil {
-		return nil, status.Errorf(codes.Unauthenticated, "failed to create Manila v2 client: %v", err)
+		return nil, statusErrorf(codes.Unauthenticated, "failed to create Manila v2 client: %w", err)
 	}
 
 	// Retrieve the share by its ID
@@ -452,10 +636,10 @@ func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi
 	share, err := manilaClient.GetShareByID(req.GetVolumeId())
 	if err != nil {
 		if clouderrors.IsNotFound(err) {
-			return nil, status.Errorf(codes.NotFound, "volume %s not found: %v", req.GetVolumeId(), err)
+			return nil, statusErrorf(codes.NotFound, "volume %s not found: %w", req.GetVolumeId(), err)
 		}
 
-		return nil, status.Errorf(codes.Internal, "failed to retrieve volume %s: %v", req.GetVolumeId(), err)
+		return nil, statusErrorf(codes.Internal, "failed to retrieve volume %s: %w", req.GetVolumeId(), err)
 	}
 
 	// Check for pending operations on this volume
@@ -466,7 +650,10 @@ func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi
 
 	// Try to expand the share
 
-	desiredSizeInGiB := bytesToGiB(req.GetCapacityRange().GetRequiredBytes())
+	desiredSizeInGiB, err := expandedShareSize(bytesToGiB(req.GetCapacityRange().GetRequiredBytes()), share.Metadata, req.GetCapacityRange().GetLimitBytes())
+	if err != nil {
+		return nil, shareSizeError(err)
+	}
 
 	if share.Size >= desiredSizeInGiB {
 		// Share is already larger than requested size
@@ -476,7 +663,7 @@ func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi
 		}, nil
 	}
 
-	share, err = extendShare(manilaClient, share.ID, desiredSizeInGiB)
+	share, err = extendShare(manilaClient, share.ID, desiredSizeInGiB, cs.d.shareWaitTimeout)
 	if err != nil {
 		return nil, err
 	}
@@ -490,6 +677,52 @@ func (cs *controllerServer) ControllerGetVolume(context.Context, *csi.Controller
 	return nil, status.Error(codes.Unimplemented, "")
 }
 
+func (cs *controllerServer) ControllerModifyVolume(ctx context.Context, req *csi.ControllerModifyVolumeRequest) (*csi.ControllerModifyVolumeResponse, error) {
+	if err := validateControllerModifyVolumeRequest(req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	osOpts, err := options.NewOpenstackOptions(req.GetSecrets())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid OpenStack secrets: %v", err)
+	}
+
+	manilaClient, err := cs.d.manilaClientBuilder.New(osOpts)
+	if err != nil {
+		return nil, statusErrorf(codes.Unauthenticated, "failed to create Manila v2 client: %w", err)
+	}
+
+	share, err := manilaClient.GetShareByID(req.GetVolumeId())
+	if err != nil {
+		if clouderrors.IsNotFound(err) {
+			return nil, statusErrorf(codes.NotFound, "volume %s not found: %w", req.GetVolumeId(), err)
+		}
+
+		return nil, statusErrorf(codes.Internal, "failed to retrieve volume %s: %w", req.GetVolumeId(), err)
+	}
+
+	promoted, err := promoteShareReplica(manilaClient, share.ID, req.GetMutableParameters()[activeReplicaAvailabilityParam])
+	if err != nil {
+		return nil, err
+	}
+
+	// The PV is named after the volume by the external-provisioner
+	pvName := share.Name
+	if volName, ok := share.Metadata[volumeNameMetadataKey]; ok {
+		pvName = volName
+	}
+
+	if promoted != nil && cs.d.replicaEventRecorder != nil {
+		cs.d.replicaEventRecorder.Eventf(pvReference(pvName), corev1.EventTypeNormal, "ReplicaPromoted",
+			"promoting replica %s in availability zone %s, the volume must be unstaged from the nodes for its pods to use the export locations of the replica",
+			promoted.ID, promoted.AvailabilityZone)
+	}
+
+	cs.awaitReplicas(manilaClient, share.ID, pvName)
+
+	return &csi.ControllerModifyVolumeResponse{}, nil
+}
+
 func parseStringMapFromJSON(data string) (m map[string]string, err error) {
 	if data == "" {
 		return
@@ -536,3 +769,41 @@ func prepareShareMetadata(appendShareMetadata, clusterID string, volumeParams ma
 
 	return shareMetadata, nil
 }
+
+// renderShareName executes the share name template. The template may reference
+// {{ .ClusterID }}, {{ .PVName }}, and, when csi-provisioner runs with
+// --extra-create-metadata, {{ .PVCNamespace }} and {{ .PVCName }}.
+// Referencing a field that is not available is an error.
+func renderShareName(tmpl *template.Template, clusterID, volName string, volumeParams map[string]string) (string, error) {
+	data := map[string]string{
+		"PVName": volName,
+	}
+
+	if clusterID != "" {
+		data["ClusterID"] = clusterID
+	}
+
+	if v, ok := volumeParams["csi.storage.k8s.io/pvc/namespace"]; ok {
+		data["PVCNamespace"] = v
+	}
+
+	if v, ok := volumeParams["csi.storage.k8s.io/pvc/name"]; ok {
+		data["PVCName"] = v
+	}
+
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	name := buf.String()
+	if name == "" {
+		return "", fmt.Errorf("share name template produced an empty name")
+	}
+
+	if len(name) > maxShareNameLength {
+		return "", fmt.Errorf("share name %q exceeds the maximum length of %d characters", name, maxShareNameLength)
+	}
+
+	return name, nil
+}
@@ -14,8 +14,17 @@ limitations under the License.
 package manila
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 	"testing"
+	"text/template"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
 )
 
 func TestPrepareShareMetadata(t *testing.T) {
@@ -121,3 +130,158 @@ func TestPrepareShareMetadata(t *testing.T) {
 		}
 	}
 }
+
+func TestRenderShareName(t *testing.T) {
+	pvcParams := map[string]string{
+		"csi.storage.k8s.io/pvc/name":      "pvc-name",
+		"csi.storage.k8s.io/pvc/namespace": "pvc-namespace",
+	}
+
+	ts := []struct {
+		template       string
+		cluster        string
+		volumeParams   map[string]string
+		expectedResult string
+		expectedError  bool
+	}{
+		{
+			// Cluster, namespace and PVC name
+			template:       "{{ .ClusterID }}-{{ .PVCNamespace }}-{{ .PVCName }}",
+			cluster:        "MyCluster",
+			volumeParams:   pvcParams,
+			expectedResult: "MyCluster-pvc-namespace-pvc-name",
+		},
+		{
+			// Static prefix with PV name
+			template:       "k8s-{{ .PVName }}",
+			volumeParams:   map[string]string{},
+			expectedResult: "k8s-pv-name",
+		},
+		{
+			// PVC metadata not provided by csi-provisioner
+			template:      "{{ .PVCNamespace }}-{{ .PVCName }}",
+			volumeParams:  map[string]string{},
+			expectedError: true,
+		},
+		{
+			// Cluster ID not set
+			template:      "{{ .ClusterID }}-{{ .PVName }}",
+			volumeParams:  pvcParams,
+			expectedError: true,
+		},
+		{
+			// Empty result
+			template:      "{{ if false }}x{{ end }}",
+			volumeParams:  pvcParams,
+			expectedError: true,
+		},
+	}
+
+	for i := range ts {
+		tmpl := template.Must(template.New("shareName").Option("missingkey=error").Parse(ts[i].template))
+		result, err := renderShareName(tmpl, ts[i].cluster, "pv-name", ts[i].volumeParams)
+
+		if err != nil && !ts[i].expectedError {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+		}
+
+		if err == nil && ts[i].expectedError {
+			t.Errorf("test %d: expected an error, got result %q", i, result)
+		}
+
+		if result != ts[i].expectedResult {
+			t.Errorf("test %d: returned an incorrect result: got %q, expected %q", i, result, ts[i].expectedResult)
+		}
+	}
+}
+
+func TestParseWaitTimeout(t *testing.T) {
+	ts := []struct {
+		value          string
+		expectedResult time.Duration
+		expectedError  bool
+	}{
+		{
+			// Default
+			value:          "",
+			expectedResult: time.Minute,
+		},
+		{
+			value:          "10m",
+			expectedResult: 10 * time.Minute,
+		},
+		{
+			// Missing unit
+			value:         "600",
+			expectedError: true,
+		},
+		{
+			value:         "0s",
+			expectedError: true,
+		},
+		{
+			value:         "-1m",
+			expectedError: true,
+		},
+	}
+
+	for i := range ts {
+		result, err := parseWaitTimeout(ts[i].value, time.Minute)
+
+		if err != nil && !ts[i].expectedError {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+		}
+
+		if err == nil && ts[i].expectedError {
+			t.Errorf("test %d: expected an error, got result %v", i, result)
+		}
+
+		if result != ts[i].expectedResult {
+			t.Errorf("test %d: returned an incorrect result: got %v, expected %v", i, result, ts[i].expectedResult)
+		}
+	}
+}
+
+func TestCircuitOpenToUnavailable(t *testing.T) {
+	// The transport errors are wrapped by the HTTP client
+	circuitOpenErr := &url.Error{Op: "Get", URL: "http://manila/v2/shares/share", Err: manilaclient.ErrCircuitOpen}
+
+	ts := []struct {
+		err          error
+		expectedCode codes.Code
+	}{
+		{
+			err:          nil,
+			expectedCode: codes.OK,
+		},
+		{
+			err:          statusErrorf(codes.Internal, "failed to retrieve volume %s: %w", "share", circuitOpenErr),
+			expectedCode: codes.Unavailable,
+		},
+		{
+			err:          statusErrorf(codes.Unauthenticated, "failed to create Manila v2 client: %w", manilaclient.ErrCircuitOpen),
+			expectedCode: codes.Unavailable,
+		},
+		{
+			// The cause isn't wrapped
+			err:          status.Errorf(codes.Internal, "failed to retrieve volume %s: %v", "share", circuitOpenErr),
+			expectedCode: codes.Internal,
+		},
+		{
+			err:          statusErrorf(codes.NotFound, "volume %s not found: %w", "share", errors.New("not found")),
+			expectedCode: codes.NotFound,
+		},
+	}
+
+	for i := range ts {
+		err := circuitOpenToUnavailable(ts[i].err)
+
+		if code := status.Code(err); code != ts[i].expectedCode {
+			t.Errorf("test %d: returned an incorrect code: got %v, expected %v", i, code, ts[i].expectedCode)
+		}
+
+		if ts[i].err != nil && status.Convert(err).Message() != status.Convert(ts[i].err).Message() {
+			t.Errorf("test %d: returned an incorrect message: got %q, expected %q", i, status.Convert(err).Message(), status.Convert(ts[i].err).Message())
+		}
+	}
+}
@@ -21,18 +21,28 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
 	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/csiclient"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	manilautil "k8s.io/cloud-provider-openstack/pkg/csi/manila/util"
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
 	"k8s.io/cloud-provider-openstack/pkg/version"
 	"k8s.io/klog/v2"
+	mountutil "k8s.io/mount-utils"
 )
 
 type DriverOpts struct {
@@ -43,9 +53,93 @@ type DriverOpts struct {
 	ShareProto   string
 	ClusterID    string
 
+	// ShareNameTemplate is an optional text/template used to name newly
+	// created shares. See renderShareName for the available fields.
+	ShareNameTemplate string
+
+	// HTTPEndpoint is the TCP address of the HTTP server serving the
+	// metrics, e.g. of the Manila API requests. Optional.
+	HTTPEndpoint string
+
+	// ShareMetrics configures the optional exporter of share capacity metrics.
+	ShareMetrics ShareMetricsOpts
+
+	// Capacity configures GetCapacity, see capacity.go. Optional.
+	Capacity CapacityOpts
+
+	// AsyncAccessRights configures the asynchronous completion of the
+	// cephx access rights, see accessright.go.
+	AsyncAccessRights AsyncAccessRightsOpts
+
+	// KeyRotation configures the rotation of the cephx keys, see
+	// keyrotation.go. Optional.
+	KeyRotation KeyRotationOpts
+
+	// ShareMetadataSync configures the sync of the share metadata mapping
+	// the shares to their PVs and PVCs, see sharemetadata.go. Optional.
+	ShareMetadataSync ShareMetadataSyncOpts
+
+	// GC configures the garbage collection of the shares of the deleted
+	// PVs, see gc.go. Optional.
+	GC GCOpts
+
+	// AutoExpansion configures the automatic expansion of the volumes
+	// filling up, see autoexpansion.go. Optional.
+	AutoExpansion AutoExpansionOpts
+
+	// NFSNodeAccess configures the NFS access rules granted to the nodes
+	// the volumes are attached to, see nodeaccess.go. Optional.
+	NFSNodeAccess NFSNodeAccessOpts
+
+	// SchedulerHintAnnotations configures the scheduler hints read from
+	// the annotations of the PersistentVolumeClaims, see schedulerhints.go.
+	// Optional.
+	SchedulerHintAnnotations SchedulerHintAnnotationsOpts
+
+	// MountOptionOverrides configures the CephFS mount options overridden
+	// by the annotations of the PersistentVolumeClaims, see
+	// mountoptions.go. Optional.
+	MountOptionOverrides MountOptionOverridesOpts
+
+	// ExportLocationPolicy ranks the export locations the node service
+	// mounts the shares with, see manilautil.ParseExportLocationPolicy.
+	// Defaults to manilautil.DefaultExportLocationPolicy.
+	ExportLocationPolicy string
+
+	// NFSKrb5KeytabFile is the path where the Kerberos keytab of the node
+	// stage secret is written when staging an NFS share with sec=krb5*.
+	NFSKrb5KeytabFile string
+
+	// ModifyVolume advertises the MODIFY_VOLUME capability, i.e. the
+	// promotion of share replicas with a VolumeAttributesClass.
+	ModifyVolume bool
+
+	// VolumeGroupSnapshots provides the group controller service, i.e. the
+	// snapshots of the volumes of a share group, see groupcontrollerserver.go.
+	VolumeGroupSnapshots bool
+
+	// ReplicaStateKubeClient is used to report the state of the share
+	// replicas in the annotations of the PVs, see replica.go. Optional.
+	ReplicaStateKubeClient kubernetes.Interface
+
+	// ShareWaitTimeout is how long the controller waits for a share or
+	// a snapshot to reach the desired status, unless the StorageClass
+	// overrides it. Defaults to 1 minute.
+	ShareWaitTimeout time.Duration
+
+	// AccessKeyWaitTimeout is how long the controller waits for the cephx
+	// key of an access right, unless the StorageClass overrides it.
+	// Defaults to 90 seconds.
+	AccessKeyWaitTimeout time.Duration
+
 	ServerCSIEndpoint string
 	FwdCSIEndpoint    string
 
+	// FallbackFwdCSIEndpoints maps the additional share protocols allowed in
+	// the protocolFallback volume parameter to the CSI Node Plugin endpoints
+	// handling them. Optional.
+	FallbackFwdCSIEndpoints map[string]string
+
 	ManilaClientBuilder manilaclient.Builder
 	CSIClientBuilder    csiclient.Builder
 }
@@ -59,11 +153,52 @@ type Driver struct {
 	shareProto   string
 	clusterID    string
 
+	shareNameTemplate *template.Template
+
+	httpEndpoint string
+	shareMetrics ShareMetricsOpts
+
+	capacity CapacityOpts
+
+	asyncAccessRights AsyncAccessRightsOpts
+
+	keyRotation        KeyRotationOpts
+	keyRotationListers *keyRotationListers
+
+	shareMetadataSync ShareMetadataSyncOpts
+
+	gc GCOpts
+
+	autoExpansion AutoExpansionOpts
+
+	nfsNodeAccess NFSNodeAccessOpts
+
+	schedulerHintAnnotations SchedulerHintAnnotationsOpts
+
+	mountOptionOverrides MountOptionOverridesOpts
+
+	nfsKrb5KeytabFile string
+
+	exportLocationPolicy *manilautil.ExportLocationPolicy
+
+	modifyVolume           bool
+	replicaStateKubeClient kubernetes.Interface
+	replicaEventRecorder   record.EventRecorder
+
+	volumeGroupSnapshots bool
+
+	shareWaitTimeout     time.Duration
+	accessKeyWaitTimeout time.Duration
+
 	serverEndpoint string
 	fwdEndpoint    string
 
+	// Endpoints of the CSI Node Plugins of the protocolFallback protocols, by share protocol
+	fallbackFwdEndpoints map[string]string
+
 	ids *identityServer
 	cs  *controllerServer
+	gcs *groupControllerServer
 	ns  *nodeServer
 
 	vcaps  []*csi.VolumeCapability_AccessMode
@@ -80,7 +215,7 @@ type nonBlockingGRPCServer struct {
 }
 
 const (
-	specVersion   = "1.8.0"
+	specVersion   = "1.9.0"
 	driverVersion = "0.9.0"
 	topologyKey   = "topology.manila.csi.openstack.org/zone"
 )
@@ -111,17 +246,20 @@ func NewDriver(o *DriverOpts) (*Driver, error) {
 	}
 
 	d := &Driver{
-		fqVersion:           fmt.Sprintf("%s@%s", driverVersion, version.Version),
-		nodeID:              o.NodeID,
-		nodeAZ:              o.NodeAZ,
-		withTopology:        o.WithTopology,
-		name:                o.DriverName,
-		serverEndpoint:      o.ServerCSIEndpoint,
-		fwdEndpoint:         o.FwdCSIEndpoint,
-		shareProto:          strings.ToUpper(o.ShareProto),
-		manilaClientBuilder: o.ManilaClientBuilder,
-		csiClientBuilder:    o.CSIClientBuilder,
-		clusterID:           o.ClusterID,
+		fqVersion:            fmt.Sprintf("%s@%s", driverVersion, version.Version),
+		nodeID:               o.NodeID,
+		nodeAZ:               o.NodeAZ,
+		withTopology:         o.WithTopology,
+		name:                 o.DriverName,
+		serverEndpoint:       o.ServerCSIEndpoint,
+		fwdEndpoint:          o.FwdCSIEndpoint,
+		shareProto:           strings.ToUpper(o.ShareProto),
+		manilaClientBuilder:  o.ManilaClientBuilder,
+		csiClientBuilder:     o.CSIClientBuilder,
+		clusterID:            o.ClusterID,
+		nfsKrb5KeytabFile:    o.NFSKrb5KeytabFile,
+		modifyVolume:         o.ModifyVolume,
+		volumeGroupSnapshots: o.VolumeGroupSnapshots,
 	}
 
 	klog.Info("Driver: ", d.name)
@@ -131,6 +269,159 @@ func NewDriver(o *DriverOpts) (*Driver, error) {
 	getShareAdapter(d.shareProto) // The program will terminate with a non-zero exit code if the share protocol selector is wrong
 	klog.Infof("Operating on %s shares", d.shareProto)
 
+	if o.ShareNameTemplate != "" {
+		tmpl, err := template.New("shareName").Option("missingkey=error").Parse(o.ShareNameTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse share name template %q: %v", o.ShareNameTemplate, err)
+		}
+		d.shareNameTemplate = tmpl
+		klog.Infof("Naming new shares using template %q", o.ShareNameTemplate)
+	}
+
+	d.httpEndpoint = o.HTTPEndpoint
+
+	if o.ShareMetrics.Endpoint != "" {
+		if o.ShareMetrics.SecretDir == "" {
+			return nil, fmt.Errorf("share metrics secret directory is missing")
+		}
+		if o.ClusterID == "" {
+			return nil, fmt.Errorf("share metrics require the cluster ID to be set")
+		}
+		if o.ShareMetrics.Interval <= 0 {
+			return nil, fmt.Errorf("share metrics interval must be positive, got %v", o.ShareMetrics.Interval)
+		}
+		d.shareMetrics = o.ShareMetrics
+	}
+
+	exportLocationPolicy := o.ExportLocationPolicy
+	if exportLocationPolicy == "" {
+		exportLocationPolicy = manilautil.DefaultExportLocationPolicy
+	}
+	policy, err := manilautil.ParseExportLocationPolicy(exportLocationPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid export location policy: %v", err)
+	}
+	d.exportLocationPolicy = policy
+	klog.Infof("Ranking export locations with policy %q", exportLocationPolicy)
+
+	d.shareWaitTimeout = o.ShareWaitTimeout
+	if d.shareWaitTimeout == 0 {
+		d.shareWaitTimeout = defaultShareWaitTimeout
+	}
+	d.accessKeyWaitTimeout = o.AccessKeyWaitTimeout
+	if d.accessKeyWaitTimeout == 0 {
+		d.accessKeyWaitTimeout = defaultAccessKeyWaitTimeout
+	}
+	if d.shareWaitTimeout < 0 || d.accessKeyWaitTimeout < 0 {
+		return nil, fmt.Errorf("share and access key wait timeouts must not be negative, got %v and %v", d.shareWaitTimeout, d.accessKeyWaitTimeout)
+	}
+
+	if o.Capacity.SecretDir != "" {
+		if o.Capacity.CacheTTL < 0 {
+			return nil, fmt.Errorf("capacity cache TTL must not be negative, got %v", o.Capacity.CacheTTL)
+		}
+		d.capacity = o.Capacity
+		klog.Infof("Reporting the capacity of the Manila pools, cache TTL %v", o.Capacity.CacheTTL)
+	}
+
+	if o.AsyncAccessRights.Enabled {
+		if o.AsyncAccessRights.KubeClient == nil {
+			return nil, fmt.Errorf("asynchronous access rights require a Kubernetes client")
+		}
+		if o.AsyncAccessRights.Timeout <= 0 {
+			return nil, fmt.Errorf("asynchronous access rights timeout must be positive, got %v", o.AsyncAccessRights.Timeout)
+		}
+		d.asyncAccessRights = o.AsyncAccessRights
+		klog.Infof("Completing cephx access rights asynchronously, timeout %v", o.AsyncAccessRights.Timeout)
+	}
+
+	if o.KeyRotation.SecretDir != "" {
+		if d.shareProto != "CEPHFS" {
+			return nil, fmt.Errorf("cephx key rotation requires the CEPHFS share protocol, got %s", d.shareProto)
+		}
+		if o.KeyRotation.KubeClient == nil {
+			return nil, fmt.Errorf("cephx key rotation requires a Kubernetes client")
+		}
+		if o.KeyRotation.Interval <= 0 {
+			return nil, fmt.Errorf("cephx key rotation interval must be positive, got %v", o.KeyRotation.Interval)
+		}
+		if o.KeyRotation.Period < 0 || o.KeyRotation.GracePeriod < 0 {
+			return nil, fmt.Errorf("cephx key rotation period and grace period must not be negative, got %v and %v", o.KeyRotation.Period, o.KeyRotation.GracePeriod)
+		}
+		d.keyRotation = o.KeyRotation
+	}
+
+	if o.ShareMetadataSync.SecretDir != "" {
+		if o.ShareMetadataSync.KubeClient == nil {
+			return nil, fmt.Errorf("share metadata sync requires a Kubernetes client")
+		}
+		if o.ShareMetadataSync.Interval <= 0 {
+			return nil, fmt.Errorf("share metadata sync interval must be positive, got %v", o.ShareMetadataSync.Interval)
+		}
+		d.shareMetadataSync = o.ShareMetadataSync
+	}
+
+	if o.GC.SecretDir != "" {
+		if o.ClusterID == "" {
+			return nil, fmt.Errorf("garbage collection requires a cluster ID to find the shares of the cluster")
+		}
+		if o.GC.KubeClient == nil {
+			return nil, fmt.Errorf("garbage collection requires a Kubernetes client")
+		}
+		if o.GC.Interval <= 0 {
+			return nil, fmt.Errorf("garbage collection interval must be positive, got %v", o.GC.Interval)
+		}
+		d.gc = o.GC
+	}
+
+	if o.AutoExpansion.Interval != 0 {
+		if o.AutoExpansion.KubeClient == nil {
+			return nil, fmt.Errorf("automatic volume expansion requires a Kubernetes client")
+		}
+		if o.AutoExpansion.Interval < 0 {
+			return nil, fmt.Errorf("automatic volume expansion interval must not be negative, got %v", o.AutoExpansion.Interval)
+		}
+		d.autoExpansion = o.AutoExpansion
+	}
+
+	if o.NFSNodeAccess.Enabled {
+		if d.shareProto != "NFS" {
+			return nil, fmt.Errorf("NFS node access rules require the NFS share protocol, got %s", d.shareProto)
+		}
+		if o.NFSNodeAccess.KubeClient == nil {
+			return nil, fmt.Errorf("NFS node access rules require a Kubernetes client")
+		}
+		d.nfsNodeAccess = o.NFSNodeAccess
+		klog.Info("Granting NFS access to the nodes the volumes are attached to")
+	}
+
+	if o.SchedulerHintAnnotations.Enabled {
+		if o.SchedulerHintAnnotations.KubeClient == nil {
+			return nil, fmt.Errorf("scheduler hint annotations require a Kubernetes client")
+		}
+		d.schedulerHintAnnotations = o.SchedulerHintAnnotations
+		klog.Info("Reading the scheduler hints of new volumes from the PersistentVolumeClaim annotations")
+	}
+
+	if len(o.MountOptionOverrides.Allowed) > 0 {
+		if o.MountOptionOverrides.KubeClient == nil {
+			return nil, fmt.Errorf("mount option overrides require a Kubernetes client")
+		}
+		d.mountOptionOverrides = o.MountOptionOverrides
+		klog.Infof("Overriding the mount options %v of new volumes from the PersistentVolumeClaim annotations", o.MountOptionOverrides.Allowed)
+	}
+
+	if o.ReplicaStateKubeClient != nil {
+		d.replicaStateKubeClient = o.ReplicaStateKubeClient
+
+		eventBroadcaster := record.NewBroadcaster()
+		eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+			Interface: o.ReplicaStateKubeClient.CoreV1().Events(""),
+		})
+		d.replicaEventRecorder = eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: d.name})
+		klog.Info("Reporting the state of the share replicas in the PersistentVolume annotations and events")
+	}
+
 	if d.withTopology {
 		klog.Infof("Topology awareness enabled, node availability zone: %s", d.nodeAZ)
 	} else {
@@ -150,6 +441,27 @@ func NewDriver(o *DriverOpts) (*Driver, error) {
 	d.serverEndpoint = endpointAddress(serverProto, serverAddr)
 	d.fwdEndpoint = endpointAddress(fwdProto, fwdAddr)
 
+	for proto, endpoint := range o.FallbackFwdCSIEndpoints {
+		proto = strings.ToUpper(proto)
+		if err = validateProtocols([]string{proto}); err != nil {
+			return nil, fmt.Errorf("invalid fallback fwd endpoint: %v", err)
+		}
+		if proto == d.shareProto {
+			return nil, fmt.Errorf("fallback fwd endpoint set for the %s share protocol selector, use the fwd endpoint instead", proto)
+		}
+
+		fwdProto, fwdAddr, err := parseGRPCEndpoint(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse fallback proxy client address %s: %v", endpoint, err)
+		}
+
+		if d.fallbackFwdEndpoints == nil {
+			d.fallbackFwdEndpoints = make(map[string]string)
+		}
+		d.fallbackFwdEndpoints[proto] = endpointAddress(fwdProto, fwdAddr)
+		klog.Infof("Operating on %s shares created by protocolFallback through %s", proto, d.fallbackFwdEndpoints[proto])
+	}
+
 	d.ids = &identityServer{d: d}
 
 	return d, nil
@@ -158,11 +470,21 @@ func NewDriver(o *DriverOpts) (*Driver, error) {
 func (d *Driver) SetupControllerService() error {
 	klog.Info("Providing controller service")
 
-	d.addControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{
+	controllerCaps := []csi.ControllerServiceCapability_RPC_Type{
 		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
 		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
-	})
+	}
+	if d.modifyVolume {
+		controllerCaps = append(controllerCaps, csi.ControllerServiceCapability_RPC_MODIFY_VOLUME)
+	}
+	if d.capacity.SecretDir != "" {
+		controllerCaps = append(controllerCaps, csi.ControllerServiceCapability_RPC_GET_CAPACITY)
+	}
+	if d.nfsNodeAccess.Enabled {
+		controllerCaps = append(controllerCaps, csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME)
+	}
+	d.addControllerServiceCapabilities(controllerCaps)
 
 	d.addVolumeCapabilityAccessModes([]csi.VolumeCapability_AccessMode_Mode{
 		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
@@ -173,6 +495,13 @@ func (d *Driver) SetupControllerService() error {
 	})
 
 	d.cs = &controllerServer{d: d}
+	if d.capacity.SecretDir != "" {
+		d.cs.capacityCache = newCapacityCache(d.capacity.CacheTTL)
+	}
+	if d.volumeGroupSnapshots {
+		klog.Info("Providing group controller service")
+		d.gcs = &groupControllerServer{d: d}
+	}
 	return nil
 }
 
@@ -185,10 +514,27 @@ func (d *Driver) SetupNodeService() error {
 
 	var supportsNodeStage bool
 
-	nodeCapsMap, err := d.initProxiedDriver()
+	nodeCapsMap, err := d.initProxiedDriver(d.fwdEndpoint)
 	if err != nil {
 		return fmt.Errorf("failed to initialize proxied CSI driver: %v", err)
 	}
+
+	for proto, endpoint := range d.fallbackFwdEndpoints {
+		fallbackCapsMap, err := d.initProxiedDriver(endpoint)
+		if err != nil {
+			return fmt.Errorf("failed to initialize proxied CSI driver for %s shares: %v", proto, err)
+		}
+
+		// The volumes are staged or not regardless of their protocol
+		_, stages := nodeCapsMap[csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME]
+		if _, fallbackStages := fallbackCapsMap[csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME]; stages != fallbackStages {
+			return fmt.Errorf("proxied CSI driver for %s shares must support STAGE_UNSTAGE_VOLUME if and only if the one for %s shares does", proto, d.shareProto)
+		}
+	}
+	// The stats of the volumes are reported regardless of the proxied CSI drivers, see volumestats.go
+	nodeCapsMap[csi.NodeServiceCapability_RPC_GET_VOLUME_STATS] = true
+	nodeCapsMap[csi.NodeServiceCapability_RPC_VOLUME_CONDITION] = true
+
 	nscaps := make([]csi.NodeServiceCapability_RPC_Type, 0, len(nodeCapsMap))
 	for c := range nodeCapsMap {
 		nscaps = append(nscaps, c)
@@ -200,7 +546,12 @@ func (d *Driver) SetupNodeService() error {
 
 	d.addNodeServiceCapabilities(nscaps)
 
-	d.ns = &nodeServer{d: d, supportsNodeStage: supportsNodeStage, nodeStageCache: make(map[volumeID]stageCacheEntry)}
+	d.ns = &nodeServer{
+		d:                 d,
+		supportsNodeStage: supportsNodeStage,
+		nodeStageCache:    make(map[volumeID]stageCacheEntry),
+		mounter:           mountutil.New(""),
+	}
 	return nil
 }
 
@@ -209,8 +560,33 @@ func (d *Driver) Run() {
 		klog.Fatal("No CSI services initialized")
 	}
 
+	metrics.RegisterMetrics("manila-csi")
+	if d.httpEndpoint != "" {
+		serveMetrics(d.httpEndpoint)
+	}
+
+	if d.shareMetrics.Endpoint != "" && d.cs != nil {
+		d.runShareMetricsExporter()
+	}
+
+	if d.keyRotation.SecretDir != "" && d.cs != nil {
+		d.runKeyRotation()
+	}
+
+	if d.shareMetadataSync.SecretDir != "" && d.cs != nil {
+		d.runShareMetadataSync()
+	}
+
+	if d.gc.SecretDir != "" && d.cs != nil {
+		d.runGC()
+	}
+
+	if d.autoExpansion.Interval > 0 && d.cs != nil {
+		d.runAutoExpansion()
+	}
+
 	s := nonBlockingGRPCServer{}
-	s.start(d.serverEndpoint, d.ids, d.cs, d.ns)
+	s.start(d.serverEndpoint, d.ids, d.cs, d.gcs, d.ns)
 	s.wait()
 }
 
@@ -263,10 +639,38 @@ func (d *Driver) addNodeServiceCapabilities(ns []csi.NodeServiceCapability_RPC_T
 	d.nscaps = caps
 }
 
-func (d *Driver) initProxiedDriver() (csiNodeCapabilitySet, error) {
-	conn, err := d.csiClientBuilder.NewConnection(d.fwdEndpoint)
+// fwdEndpointFor returns the endpoint of the CSI Node Plugin handling the shares of the protocol,
+// and false if the protocol isn't served by this plugin.
+func (d *Driver) fwdEndpointFor(proto string) (string, bool) {
+	proto = strings.ToUpper(proto)
+	if proto == d.shareProto {
+		return d.fwdEndpoint, true
+	}
+
+	endpoint, ok := d.fallbackFwdEndpoints[proto]
+	return endpoint, ok
+}
+
+// fwdEndpoints returns the endpoints of all the proxied CSI Node Plugins, the one of
+// the share protocol selector first.
+func (d *Driver) fwdEndpoints() []string {
+	endpoints := []string{d.fwdEndpoint}
+	protos := make([]string, 0, len(d.fallbackFwdEndpoints))
+	for proto := range d.fallbackFwdEndpoints {
+		protos = append(protos, proto)
+	}
+	sort.Strings(protos)
+	for _, proto := range protos {
+		endpoints = append(endpoints, d.fallbackFwdEndpoints[proto])
+	}
+
+	return endpoints
+}
+
+func (d *Driver) initProxiedDriver(endpoint string) (csiNodeCapabilitySet, error) {
+	conn, err := d.csiClientBuilder.NewConnection(endpoint)
 	if err != nil {
-		return nil, fmt.Errorf("connecting to %s endpoint failed: %v", d.fwdEndpoint, err)
+		return nil, fmt.Errorf("connecting to %s endpoint failed: %v", endpoint, err)
 	}
 	defer conn.Close()
 
@@ -294,16 +698,16 @@ func (d *Driver) initProxiedDriver() (csiNodeCapabilitySet, error) {
 	return nodeCaps, nil
 }
 
-func (s *nonBlockingGRPCServer) start(endpoint string, ids *identityServer, cs *controllerServer, ns *nodeServer) {
+func (s *nonBlockingGRPCServer) start(endpoint string, ids *identityServer, cs *controllerServer, gcs *groupControllerServer, ns *nodeServer) {
 	s.wg.Add(1)
-	go s.serve(endpoint, ids, cs, ns)
+	go s.serve(endpoint, ids, cs, gcs, ns)
 }
 
 func (s *nonBlockingGRPCServer) wait() {
 	s.wg.Wait()
 }
 
-func (s *nonBlockingGRPCServer) serve(endpoint string, ids *identityServer, cs *controllerServer, ns *nodeServer) {
+func (s *nonBlockingGRPCServer) serve(endpoint string, ids *identityServer, cs *controllerServer, gcs *groupControllerServer, ns *nodeServer) {
 	defer s.wg.Done()
 
 	proto, addr, err := parseGRPCEndpoint(endpoint)
@@ -328,6 +732,7 @@ func (s *nonBlockingGRPCServer) serve(endpoint string, ids *identityServer, cs *
 		klog.V(3).Infof("[ID:%d] GRPC call: %s", callID, info.FullMethod)
 		klog.V(5).Infof("[ID:%d] GRPC request: %s", callID, protosanitizer.StripSecrets(req))
 		resp, err := handler(ctx, req)
+		err = circuitOpenToUnavailable(err)
 		if err != nil {
 			klog.Errorf("[ID:%d] GRPC error: %v", callID, err)
 		} else {
@@ -344,6 +749,9 @@ func (s *nonBlockingGRPCServer) serve(endpoint string, ids *identityServer, cs *
 	if cs != nil {
 		csi.RegisterControllerServer(server, cs)
 	}
+	if gcs != nil {
+		csi.RegisterGroupControllerServer(server, gcs)
+	}
 	if ns != nil {
 		csi.RegisterNodeServer(server, ns)
 	}
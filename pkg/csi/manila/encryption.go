@@ -0,0 +1,91 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	clouderrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+var barbicanUUIDRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-([0-9a-fA-F]{4}-){3}[0-9a-fA-F]{12}$`)
+
+// parseBarbicanRef parses the encryptionKeyRef volume parameter, either the UUID of a Barbican secret or the href
+// of a secret or of a secret container, e.g. https://barbican.example.com/v1/containers/<UUID>.
+func parseBarbicanRef(ref string) (isContainer bool, id string, err error) {
+	if barbicanUUIDRegexp.MatchString(ref) {
+		return false, ref, nil
+	}
+
+	u, err := url.Parse(ref)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false, "", fmt.Errorf("encryptionKeyRef must be a Barbican secret UUID or a secret or secret container href, got %q", ref)
+	}
+
+	dir, id := path.Split(strings.TrimSuffix(u.Path, "/"))
+	if !barbicanUUIDRegexp.MatchString(id) {
+		return false, "", fmt.Errorf("encryptionKeyRef %q doesn't end with a Barbican UUID", ref)
+	}
+
+	switch path.Base(dir) {
+	case "secrets":
+		return false, id, nil
+	case "containers":
+		return true, id, nil
+	default:
+		return false, "", fmt.Errorf("encryptionKeyRef %q is neither a Barbican secret nor a secret container", ref)
+	}
+}
+
+// resolveEncryptionKeyRef returns the UUID of the Barbican secret referenced by the encryptionKeyRef volume
+// parameter. A secret container must hold a single secret, which is the encryption key of the share.
+func resolveEncryptionKeyRef(manilaClient manilaclient.Interface, ref string) (string, error) {
+	isContainer, id, err := parseBarbicanRef(ref)
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "invalid volume parameter encryptionKeyRef: %v", err)
+	}
+	if !isContainer {
+		return id, nil
+	}
+
+	container, err := manilaClient.GetSecretContainer(id)
+	if err != nil {
+		if clouderrors.IsNotFound(err) {
+			return "", status.Errorf(codes.InvalidArgument, "secret container %s of encryptionKeyRef not found", id)
+		}
+		return "", status.Errorf(codes.Internal, "failed to retrieve secret container %s of encryptionKeyRef: %v", id, err)
+	}
+
+	if len(container.SecretRefs) != 1 {
+		return "", status.Errorf(codes.InvalidArgument, "secret container %s of encryptionKeyRef must hold a single secret, it holds %d", id, len(container.SecretRefs))
+	}
+
+	isContainer, id, err = parseBarbicanRef(container.SecretRefs[0].SecretRef)
+	if err != nil || isContainer {
+		return "", status.Errorf(codes.Internal, "invalid secret reference %q in secret container %s", container.SecretRefs[0].SecretRef, container.ContainerRef)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/keymanager/v1/containers"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
+)
+
+const (
+	testSecretID    = "2c1ea1b6-84a4-4c73-9d8b-6b3d0c1f0e7a"
+	testContainerID = "8f6e1d0c-5b1a-4e5f-9c2d-3a4b5c6d7e8f"
+)
+
+// fakeKeyManagerClient serves the Barbican secret containers.
+type fakeKeyManagerClient struct {
+	manilaclient.Interface
+	containers map[string]containers.Container
+}
+
+func (c *fakeKeyManagerClient) GetSecretContainer(containerID string) (*containers.Container, error) {
+	container, ok := c.containers[containerID]
+	if !ok {
+		return nil, gophercloud.ErrDefault404{}
+	}
+	return &container, nil
+}
+
+func TestParseBarbicanRef(t *testing.T) {
+	ts := []struct {
+		ref         string
+		isContainer bool
+		id          string
+		err         bool
+	}{
+		{ref: testSecretID, id: testSecretID},
+		{ref: "https://barbican.example.com/v1/secrets/" + testSecretID, id: testSecretID},
+		{ref: "https://barbican.example.com:9311/key-manager/v1/containers/" + testContainerID + "/", isContainer: true, id: testContainerID},
+		{ref: "my-key", err: true},
+		{ref: "https://barbican.example.com/v1/secrets/my-key", err: true},
+		{ref: "https://barbican.example.com/v1/orders/" + testSecretID, err: true},
+		{ref: "/v1/secrets/" + testSecretID, err: true},
+	}
+
+	for _, tc := range ts {
+		isContainer, id, err := parseBarbicanRef(tc.ref)
+		if tc.err {
+			if err == nil {
+				t.Errorf("parseBarbicanRef(%q): expected an error", tc.ref)
+			}
+			continue
+		}
+		if err != nil || isContainer != tc.isContainer || id != tc.id {
+			t.Errorf("parseBarbicanRef(%q): expected %t, %q, got %t, %q, %v", tc.ref, tc.isContainer, tc.id, isContainer, id, err)
+		}
+	}
+}
+
+func TestResolveEncryptionKeyRef(t *testing.T) {
+	secretRef := containers.SecretRef{Name: "key", SecretRef: "https://barbican.example.com/v1/secrets/" + testSecretID}
+	c := &fakeKeyManagerClient{containers: map[string]containers.Container{
+		testContainerID:                        {SecretRefs: []containers.SecretRef{secretRef}},
+		"11111111-2222-3333-4444-555555555555": {SecretRefs: []containers.SecretRef{secretRef, secretRef}},
+	}}
+	containerRef := func(id string) string {
+		return "https://barbican.example.com/v1/containers/" + id
+	}
+
+	ts := []struct {
+		ref      string
+		expected string
+		code     codes.Code
+	}{
+		{ref: testSecretID, expected: testSecretID},
+		{ref: containerRef(testContainerID), expected: testSecretID},
+		{ref: containerRef("11111111-2222-3333-4444-555555555555"), code: codes.InvalidArgument},
+		{ref: containerRef("99999999-2222-3333-4444-555555555555"), code: codes.InvalidArgument},
+		{ref: "my-key", code: codes.InvalidArgument},
+	}
+
+	for _, tc := range ts {
+		id, err := resolveEncryptionKeyRef(c, tc.ref)
+		if code := status.Code(err); code != tc.code {
+			t.Errorf("resolveEncryptionKeyRef(%q): expected code %v, got %v", tc.ref, tc.code, err)
+			continue
+		}
+		if id != tc.expected {
+			t.Errorf("resolveEncryptionKeyRef(%q): expected %q, got %q", tc.ref, tc.expected, id)
+		}
+	}
+}
+
+func TestWithShareCreateOptsEncryption(t *testing.T) {
+	shareOpts := &options.ControllerVolumeContext{EncryptionKeyRef: testSecretID}
+
+	createOpts := &shares.CreateOpts{ShareProto: "NFS", Size: 1}
+	expected := manilaclient.ShareCreateOpts{CreateOpts: *createOpts, EncryptionKeyRef: testSecretID}
+	if opts := withShareCreateOpts(createOpts, shareOpts); !reflect.DeepEqual(opts, expected) {
+		t.Errorf("expected share create options %v, got %v", expected, opts)
+	}
+
+	// The share created from a snapshot keeps the key of its source
+	fromSnapshot := &shares.CreateOpts{ShareProto: "NFS", Size: 1, SnapshotID: "snapshot"}
+	if opts := withShareCreateOpts(fromSnapshot, shareOpts); opts != fromSnapshot {
+		t.Errorf("expected the share create options to be unchanged, got %v", opts)
+	}
+}
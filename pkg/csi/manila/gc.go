@@ -0,0 +1,242 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
+	clouderrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+// GCOpts configures the garbage collection of the shares left behind by the PersistentVolumes deleted from the
+// cluster.
+type GCOpts struct {
+	// SecretDir is a directory containing the OpenStack credentials, one file per key, in the same
+	// format as the CSI secrets. The garbage collection is disabled if empty.
+	SecretDir string
+	// Interval between two garbage collections.
+	Interval time.Duration
+	// DeleteOrphanedShares deletes the orphaned shares which failed to be deleted, instead of only
+	// revoking their access rights.
+	DeleteOrphanedShares bool
+	// KubeClient is used to list the PersistentVolumes.
+	KubeClient kubernetes.Interface
+}
+
+const (
+	// deleteFailedMetadataKey is set on the shares which failed to be deleted, to the time of the failure. Only
+	// these orphaned shares are collected: the ones of the PersistentVolumes with the Retain reclaim policy are
+	// left behind on purpose.
+	deleteFailedMetadataKey = "manila.csi.openstack.org/delete-failed"
+
+	// orphanedShareMinAge leaves the new shares alone, whose PersistentVolume may not be created yet.
+	orphanedShareMinAge = time.Hour
+)
+
+var (
+	orphanedShares = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Name: "manila_csi_orphaned_shares",
+			Help: "Number of shares of the cluster whose PersistentVolume doesn't exist anymore",
+		})
+	gcRevokedAccessRights = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Name: "manila_csi_gc_revoked_access_rights_total",
+			Help: "Number of access rights of the orphaned shares revoked by the garbage collection",
+		})
+	gcDeletedShares = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Name: "manila_csi_gc_deleted_shares_total",
+			Help: "Number of orphaned shares deleted by the garbage collection",
+		})
+
+	registerGCMetrics sync.Once
+)
+
+// releaseUndeletedShare revokes the access rights of a share which failed to be deleted, which nothing should
+// use anymore, and marks it for the garbage collection. It is best effort, the errors are only logged.
+func releaseUndeletedShare(manilaClient manilaclient.Interface, shareID string) {
+	if _, err := manilaClient.SetShareMetadata(shareID, shares.SetMetadataOpts{
+		Metadata: map[string]string{deleteFailedMetadataKey: time.Now().UTC().Format(time.RFC3339)},
+	}); err != nil && !clouderrors.IsNotFound(err) {
+		klog.Errorf("failed to mark share %s which failed to be deleted: %v", shareID, err)
+	}
+
+	if _, err := revokeShareAccessRights(manilaClient, shareID); err != nil {
+		klog.Errorf("failed to revoke the access rights of share %s which failed to be deleted: %v", shareID, err)
+	}
+}
+
+// revokeShareAccessRights revokes all the access rights of a share, and returns how many were revoked.
+func revokeShareAccessRights(manilaClient manilaclient.Interface, shareID string) (int, error) {
+	rights, err := manilaClient.GetAccessRights(shareID)
+	if err != nil {
+		if clouderrors.IsNotFound(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to list access rights: %v", err)
+	}
+
+	revoked := 0
+	for i := range rights {
+		if rights[i].State == accessRightStateQueuedToDeny || rights[i].State == accessRightStateDenying {
+			continue
+		}
+		if err := manilaClient.RevokeAccess(shareID, rights[i].ID); err != nil && !clouderrors.IsNotFound(err) {
+			return revoked, fmt.Errorf("failed to revoke access right %s: %v", rights[i].ID, err)
+		}
+		revoked++
+	}
+
+	return revoked, nil
+}
+
+// runGC periodically collects the orphaned shares.
+func (d *Driver) runGC() {
+	registerGCMetrics.Do(func() {
+		legacyregistry.MustRegister(orphanedShares, gcRevokedAccessRights, gcDeletedShares)
+	})
+
+	klog.Infof("Collecting the orphaned shares every %v", d.gc.Interval)
+
+	go wait.Forever(func() {
+		if err := d.collectOrphanedShares(context.Background()); err != nil {
+			klog.Errorf("failed to collect the orphaned shares: %v", err)
+		}
+	}, d.gc.Interval)
+}
+
+func (d *Driver) collectOrphanedShares(ctx context.Context) error {
+	secrets, err := readSecretDir(d.gc.SecretDir)
+	if err != nil {
+		return err
+	}
+
+	osOpts, err := options.NewOpenstackOptions(secrets)
+	if err != nil {
+		return fmt.Errorf("invalid OpenStack secrets in %s: %v", d.gc.SecretDir, err)
+	}
+
+	manilaClient, err := d.manilaClientBuilder.New(osOpts)
+	if err != nil {
+		return fmt.Errorf("failed to create Manila v2 client: %v", err)
+	}
+
+	shs, err := manilaClient.ListShares(shares.ListOpts{Metadata: map[string]string{clusterMetadataKey: d.clusterID}})
+	if err != nil {
+		return fmt.Errorf("failed to list shares: %v", err)
+	}
+
+	pvs, err := d.gc.KubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list PersistentVolumes: %v", err)
+	}
+
+	orphans := findOrphanedShares(shs, pvs.Items, d.name, time.Now())
+	orphanedShares.Set(float64(len(orphans)))
+
+	for _, share := range orphans {
+		pvName := share.Metadata[pvNameMetadataKey]
+		if share.Metadata[deleteFailedMetadataKey] == "" {
+			klog.Warningf("share %s of the deleted PersistentVolume %s is orphaned, leaving it as it was not deleted by the driver", share.ID, pvName)
+			continue
+		}
+
+		if err := d.collectOrphanedShare(manilaClient, share); err != nil {
+			klog.Errorf("failed to collect share %s of the deleted PersistentVolume %s: %v", share.ID, pvName, err)
+		}
+	}
+
+	return nil
+}
+
+// collectOrphanedShare revokes the access rights of an orphaned share, and deletes it if DeleteOrphanedShares is set.
+func (d *Driver) collectOrphanedShare(manilaClient manilaclient.Interface, share *shares.Share) error {
+	revoked, err := revokeShareAccessRights(manilaClient, share.ID)
+	gcRevokedAccessRights.Add(float64(revoked))
+	if err != nil {
+		return err
+	}
+	if revoked > 0 {
+		klog.Infof("revoked %d access rights of orphaned share %s", revoked, share.ID)
+	}
+
+	if !d.gc.DeleteOrphanedShares {
+		return nil
+	}
+
+	if err := deleteShareReplicas(manilaClient, share.ID); err != nil {
+		return err
+	}
+	if err := deleteShare(manilaClient, share.ID); err != nil {
+		return fmt.Errorf("failed to delete share: %v", err)
+	}
+
+	gcDeletedShares.Inc()
+	klog.Infof("deleted orphaned share %s of the deleted PersistentVolume %s", share.ID, share.Metadata[pvNameMetadataKey])
+
+	return nil
+}
+
+// findOrphanedShares returns the shares whose metadata names a PersistentVolume which doesn't exist anymore, and
+// which no PersistentVolume of the driver references.
+func findOrphanedShares(shs []shares.Share, pvs []v1.PersistentVolume, driverName string, now time.Time) []*shares.Share {
+	pvNames := sets.New[string]()
+	referencedIDs := sets.New[string]()
+	referencedNames := sets.New[string]()
+	for i := range pvs {
+		pv := &pvs[i]
+		pvNames.Insert(pv.Name)
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != driverName {
+			continue
+		}
+		if id := pv.Spec.CSI.VolumeAttributes["shareID"]; id != "" {
+			referencedIDs.Insert(id)
+		} else if name := pv.Spec.CSI.VolumeAttributes["shareName"]; name != "" {
+			referencedNames.Insert(name)
+		}
+	}
+
+	var orphans []*shares.Share
+	for i := range shs {
+		share := &shs[i]
+		pvName := share.Metadata[pvNameMetadataKey]
+		if pvName == "" || pvNames.Has(pvName) || referencedIDs.Has(share.ID) || referencedNames.Has(share.Name) {
+			continue
+		}
+		if share.Status == shareDeleting || now.Sub(share.CreatedAt) < orphanedShareMinAge {
+			continue
+		}
+		orphans = append(orphans, share)
+	}
+
+	return orphans
+}
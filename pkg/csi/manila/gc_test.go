@@ -0,0 +1,126 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+)
+
+type fakeGCClient struct {
+	manilaclient.Interface
+
+	share    shares.Share
+	rights   []shares.AccessRight
+	revoked  []string
+	deleted  []string
+	metadata map[string]string
+}
+
+func (c *fakeGCClient) GetShareByID(shareID string) (*shares.Share, error) {
+	return &c.share, nil
+}
+
+func (c *fakeGCClient) SetShareMetadata(shareID string, opts shares.SetMetadataOptsBuilder) (map[string]string, error) {
+	c.metadata = opts.(shares.SetMetadataOpts).Metadata
+	return c.metadata, nil
+}
+
+func (c *fakeGCClient) GetAccessRights(shareID string) ([]shares.AccessRight, error) {
+	return c.rights, nil
+}
+
+func (c *fakeGCClient) RevokeAccess(shareID, accessID string) error {
+	c.revoked = append(c.revoked, accessID)
+	return nil
+}
+
+func (c *fakeGCClient) DeleteShare(shareID string) error {
+	c.deleted = append(c.deleted, shareID)
+	return nil
+}
+
+func TestFindOrphanedShares(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-2 * orphanedShareMinAge)
+
+	shs := []shares.Share{
+		{ID: "bound", CreatedAt: old, Metadata: map[string]string{pvNameMetadataKey: "pv-bound"}},
+		{ID: "orphan", CreatedAt: old, Metadata: map[string]string{pvNameMetadataKey: "pv-deleted"}},
+		{ID: "new", CreatedAt: now, Metadata: map[string]string{pvNameMetadataKey: "pv-not-created-yet"}},
+		{ID: "deleting", CreatedAt: old, Status: shareDeleting, Metadata: map[string]string{pvNameMetadataKey: "pv-deleted"}},
+		{ID: "no-pv-name", CreatedAt: old},
+		{ID: "adopted", CreatedAt: old, Metadata: map[string]string{pvNameMetadataKey: "pv-renamed"}},
+		{ID: "by-name", Name: "static", CreatedAt: old, Metadata: map[string]string{pvNameMetadataKey: "pv-renamed"}},
+	}
+	pvs := []v1.PersistentVolume{
+		*inspectedPV("pv-bound", map[string]string{"shareID": "bound"}),
+		*inspectedPV("pv-adopted", map[string]string{"shareID": "adopted"}),
+		*inspectedPV("pv-static", map[string]string{"shareName": "static"}),
+	}
+
+	var ids []string
+	for _, share := range findOrphanedShares(shs, pvs, "manila.csi.openstack.org", now) {
+		ids = append(ids, share.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"orphan"}) {
+		t.Errorf("expected the orphaned shares [orphan], got %v", ids)
+	}
+}
+
+func TestReleaseUndeletedShare(t *testing.T) {
+	c := &fakeGCClient{rights: []shares.AccessRight{
+		{ID: "active", State: accessRightStateActive},
+		{ID: "denying", State: accessRightStateDenying},
+	}}
+
+	releaseUndeletedShare(c, "share")
+
+	if c.metadata[deleteFailedMetadataKey] == "" {
+		t.Errorf("expected the share to be marked with %s, got %v", deleteFailedMetadataKey, c.metadata)
+	}
+	if !reflect.DeepEqual(c.revoked, []string{"active"}) {
+		t.Errorf("expected the access right [active] to be revoked, got %v", c.revoked)
+	}
+}
+
+func TestCollectOrphanedShare(t *testing.T) {
+	share := &shares.Share{ID: "orphan", Metadata: map[string]string{deleteFailedMetadataKey: "2024-01-01T00:00:00Z"}}
+
+	c := &fakeGCClient{share: *share, rights: []shares.AccessRight{{ID: "rule", State: accessRightStateActive}}}
+	d := &Driver{}
+	if err := d.collectOrphanedShare(c, share); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.revoked, []string{"rule"}) || len(c.deleted) != 0 {
+		t.Errorf("expected the access rights to be revoked and the share to be kept, got revoked %v and deleted %v", c.revoked, c.deleted)
+	}
+
+	c = &fakeGCClient{share: *share}
+	d = &Driver{gc: GCOpts{DeleteOrphanedShares: true}}
+	if err := d.collectOrphanedShare(c, share); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.deleted, []string{"orphan"}) {
+		t.Errorf("expected the share to be deleted, got %v", c.deleted)
+	}
+}
@@ -0,0 +1,397 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
+	clouderrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+// groupControllerServer snapshots the volumes of a Manila share group together, with a share group snapshot.
+// The volumes are placed in a share group with the shareGroupID StorageClass parameter.
+type groupControllerServer struct {
+	d *Driver
+}
+
+var (
+	pendingGroupSnapshots = sync.Map{}
+	pendingGroupRestores  = sync.Map{}
+)
+
+// memberSnapshotIDSeparator separates the IDs of the share group snapshot and of its member in the ID of the
+// snapshot of a member, which is not a Manila snapshot.
+const memberSnapshotIDSeparator = "/"
+
+func memberSnapshotID(groupSnapshotID, memberID string) string {
+	return groupSnapshotID + memberSnapshotIDSeparator + memberID
+}
+
+// parseMemberSnapshotID returns the IDs of the share group snapshot and of its member of the snapshot ID of a
+// member, ok is false if the snapshot ID is the ID of a Manila snapshot.
+func parseMemberSnapshotID(snapshotID string) (groupSnapshotID, memberID string, ok bool) {
+	groupSnapshotID, memberID, ok = strings.Cut(snapshotID, memberSnapshotIDSeparator)
+	return groupSnapshotID, memberID, ok && groupSnapshotID != "" && memberID != ""
+}
+
+// restoredShareGroupName is the name of the share group the share group snapshot is restored into.
+func restoredShareGroupName(groupSnapshotID string) string {
+	return "csi-restore-" + groupSnapshotID
+}
+
+func (gcs *groupControllerServer) GroupControllerGetCapabilities(ctx context.Context, req *csi.GroupControllerGetCapabilitiesRequest) (*csi.GroupControllerGetCapabilitiesResponse, error) {
+	return &csi.GroupControllerGetCapabilitiesResponse{
+		Capabilities: []*csi.GroupControllerServiceCapability{
+			{
+				Type: &csi.GroupControllerServiceCapability_Rpc{
+					Rpc: &csi.GroupControllerServiceCapability_RPC{
+						Type: csi.GroupControllerServiceCapability_RPC_CREATE_DELETE_GET_VOLUME_GROUP_SNAPSHOT,
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+func (gcs *groupControllerServer) CreateVolumeGroupSnapshot(ctx context.Context, req *csi.CreateVolumeGroupSnapshotRequest) (*csi.CreateVolumeGroupSnapshotResponse, error) {
+	if err := validateCreateVolumeGroupSnapshotRequest(req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	osOpts, err := options.NewOpenstackOptions(req.GetSecrets())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid OpenStack secrets: %v", err)
+	}
+
+	// Check for pending CreateVolumeGroupSnapshots for this group snapshot name
+	if _, isPending := pendingGroupSnapshots.LoadOrStore(req.GetName(), true); isPending {
+		return nil, status.Errorf(codes.Aborted, "group snapshot %s is already being processed", req.GetName())
+	}
+	defer pendingGroupSnapshots.Delete(req.GetName())
+
+	manilaClient, err := gcs.d.manilaClientBuilder.New(osOpts)
+	if err != nil {
+		return nil, statusErrorf(codes.Unauthenticated, "failed to create Manila v2 client: %w", err)
+	}
+
+	shareGroupID, err := sourceShareGroup(manilaClient, req.GetSourceVolumeIds())
+	if err != nil {
+		return nil, err
+	}
+
+	// Retrieve an existing group snapshot or create a new one
+
+	groupSnapshot, err := manilaClient.GetShareGroupSnapshotByName(req.GetName())
+	if err != nil {
+		if !clouderrors.IsNotFound(err) {
+			return nil, statusErrorf(codes.Internal, "failed to probe for a group snapshot named %s: %w", req.GetName(), err)
+		}
+
+		groupSnapshot, err = manilaClient.CreateShareGroupSnapshot(manilaclient.ShareGroupSnapshotCreateOpts{
+			ShareGroupID: shareGroupID,
+			Name:         req.GetName(),
+			Description:  snapshotDescription,
+		})
+		if err != nil {
+			return nil, statusErrorf(codes.Internal, "failed to create group snapshot %s of share group %s: %w", req.GetName(), shareGroupID, err)
+		}
+	} else {
+		klog.V(4).Infof("a group snapshot named %s already exists", req.GetName())
+	}
+
+	if groupSnapshot.ShareGroupID != shareGroupID {
+		return nil, status.Errorf(codes.AlreadyExists, "group snapshot %s already exists, but is a snapshot of share group %s instead of %s", req.GetName(), groupSnapshot.ShareGroupID, shareGroupID)
+	}
+
+	// Check for group snapshot status, determine whether it's ready
+
+	switch groupSnapshot.Status {
+	case snapshotCreating, snapshotAvailable:
+	case snapshotError:
+		// An error occurred, try to roll-back the group snapshot
+		if err := manilaClient.DeleteShareGroupSnapshot(groupSnapshot.ID); err != nil && !clouderrors.IsNotFound(err) {
+			klog.Errorf("couldn't delete group snapshot %s in a roll-back procedure: %v", groupSnapshot.ID, err)
+		}
+
+		manilaErrMsg, err := lastResourceError(manilaClient, groupSnapshot.ID)
+		if err != nil {
+			return nil, statusErrorf(codes.Internal, "group snapshot %s of share group %s is in error state, error description could not be retrieved: %w", groupSnapshot.ID, shareGroupID, err)
+		}
+
+		return nil, status.Errorf(manilaErrMsg.errCode.toRPCErrorCode(), "group snapshot %s of share group %s is in error state: %s", groupSnapshot.ID, shareGroupID, manilaErrMsg.message)
+	default:
+		return nil, status.Errorf(codes.Internal, "an error occurred while creating group snapshot %s of share group %s: group snapshot is in an unexpected state: wanted creating/available, got %s",
+			req.GetName(), shareGroupID, groupSnapshot.Status)
+	}
+
+	return &csi.CreateVolumeGroupSnapshotResponse{
+		GroupSnapshot: volumeGroupSnapshot(groupSnapshot),
+	}, nil
+}
+
+func (gcs *groupControllerServer) DeleteVolumeGroupSnapshot(ctx context.Context, req *csi.DeleteVolumeGroupSnapshotRequest) (*csi.DeleteVolumeGroupSnapshotResponse, error) {
+	if err := validateDeleteVolumeGroupSnapshotRequest(req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	osOpts, err := options.NewOpenstackOptions(req.GetSecrets())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid OpenStack secrets: %v", err)
+	}
+
+	manilaClient, err := gcs.d.manilaClientBuilder.New(osOpts)
+	if err != nil {
+		return nil, statusErrorf(codes.Unauthenticated, "failed to create Manila v2 client: %w", err)
+	}
+
+	if err := manilaClient.DeleteShareGroupSnapshot(req.GetGroupSnapshotId()); err != nil {
+		if !clouderrors.IsNotFound(err) {
+			return nil, statusErrorf(codes.Internal, "failed to delete group snapshot %s: %w", req.GetGroupSnapshotId(), err)
+		}
+
+		klog.V(4).Infof("group snapshot %s not found, assuming it to be already deleted", req.GetGroupSnapshotId())
+	}
+
+	return &csi.DeleteVolumeGroupSnapshotResponse{}, nil
+}
+
+func (gcs *groupControllerServer) GetVolumeGroupSnapshot(ctx context.Context, req *csi.GetVolumeGroupSnapshotRequest) (*csi.GetVolumeGroupSnapshotResponse, error) {
+	if err := validateGetVolumeGroupSnapshotRequest(req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	osOpts, err := options.NewOpenstackOptions(req.GetSecrets())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid OpenStack secrets: %v", err)
+	}
+
+	manilaClient, err := gcs.d.manilaClientBuilder.New(osOpts)
+	if err != nil {
+		return nil, statusErrorf(codes.Unauthenticated, "failed to create Manila v2 client: %w", err)
+	}
+
+	groupSnapshot, err := manilaClient.GetShareGroupSnapshot(req.GetGroupSnapshotId())
+	if err != nil {
+		if clouderrors.IsNotFound(err) {
+			return nil, statusErrorf(codes.NotFound, "group snapshot %s not found: %w", req.GetGroupSnapshotId(), err)
+		}
+
+		return nil, statusErrorf(codes.Internal, "failed to retrieve group snapshot %s: %w", req.GetGroupSnapshotId(), err)
+	}
+
+	if groupSnapshot.Status == snapshotError {
+		return nil, status.Errorf(codes.Internal, "group snapshot %s is in error state", groupSnapshot.ID)
+	}
+
+	return &csi.GetVolumeGroupSnapshotResponse{
+		GroupSnapshot: volumeGroupSnapshot(groupSnapshot),
+	}, nil
+}
+
+// sourceShareGroup returns the share group of the shares, which must be all of its shares: Manila snapshots
+// whole share groups only.
+func sourceShareGroup(manilaClient manilaclient.Interface, shareIDs []string) (string, error) {
+	var shareGroupID string
+
+	for _, shareID := range shareIDs {
+		id, err := manilaClient.GetShareGroupID(shareID)
+		if err != nil {
+			if clouderrors.IsNotFound(err) {
+				return "", statusErrorf(codes.NotFound, "source volume %s not found: %w", shareID, err)
+			}
+
+			return "", statusErrorf(codes.Internal, "failed to retrieve the share group of volume %s: %w", shareID, err)
+		}
+
+		if id == "" {
+			return "", status.Errorf(codes.InvalidArgument, "volume %s is not in a share group, see the shareGroupID StorageClass parameter", shareID)
+		}
+
+		if shareGroupID == "" {
+			shareGroupID = id
+		} else if id != shareGroupID {
+			return "", status.Errorf(codes.InvalidArgument, "volumes %s and %s are in different share groups %s and %s", shareIDs[0], shareID, shareGroupID, id)
+		}
+	}
+
+	groupShares, err := manilaClient.GetShareGroupShares(shareGroupID)
+	if err != nil {
+		return "", statusErrorf(codes.Internal, "failed to list the shares of share group %s: %w", shareGroupID, err)
+	}
+
+	requested := make(map[string]struct{}, len(shareIDs))
+	for _, shareID := range shareIDs {
+		requested[shareID] = struct{}{}
+	}
+
+	var missing []string
+	for _, share := range groupShares {
+		if _, ok := requested[share.ID]; !ok {
+			missing = append(missing, share.ID)
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return "", status.Errorf(codes.InvalidArgument, "share group %s is snapshotted as a whole, but volumes %s of the group are missing from the request",
+			shareGroupID, strings.Join(missing, ", "))
+	}
+
+	return shareGroupID, nil
+}
+
+// volumeGroupSnapshot converts a share group snapshot to a CSI volume group snapshot, its members
+// being the snapshots of the volumes.
+func volumeGroupSnapshot(groupSnapshot *manilaclient.ShareGroupSnapshot) *csi.VolumeGroupSnapshot {
+	readyToUse := groupSnapshot.Status == snapshotAvailable
+
+	ctime := timestamppb.New(groupSnapshot.CreatedAt)
+	if err := ctime.CheckValid(); err != nil {
+		klog.Warningf("couldn't parse timestamp %v from group snapshot %s: %v", groupSnapshot.CreatedAt, groupSnapshot.ID, err)
+	}
+
+	snaps := make([]*csi.Snapshot, 0, len(groupSnapshot.Members))
+	for _, member := range groupSnapshot.Members {
+		snaps = append(snaps, &csi.Snapshot{
+			SnapshotId:      memberSnapshotID(groupSnapshot.ID, member.ID),
+			SourceVolumeId:  member.ShareID,
+			SizeBytes:       int64(member.Size) * bytesInGiB,
+			CreationTime:    ctime,
+			ReadyToUse:      readyToUse,
+			GroupSnapshotId: groupSnapshot.ID,
+		})
+	}
+
+	return &csi.VolumeGroupSnapshot{
+		GroupSnapshotId: groupSnapshot.ID,
+		Snapshots:       snaps,
+		CreationTime:    ctime,
+		ReadyToUse:      readyToUse,
+	}
+}
+
+// restoreGroupSnapshotMember restores the snapshot of a member of a share group snapshot into the volume. Manila
+// only restores share group snapshots as a whole, into new share groups: the share group snapshot is restored once
+// into the share group named after it, whose share restored from the member is then renamed after the volume.
+func restoreGroupSnapshotMember(manilaClient manilaclient.Interface, snapshotID, shareName string, sizeInGiB int, shareMetadata map[string]string, timeout time.Duration) (*shares.Share, error) {
+	groupSnapshotID, memberID, _ := parseMemberSnapshotID(snapshotID)
+
+	// The volume may already be restored
+	if share, err := manilaClient.GetShareByName(shareName); err == nil {
+		return share, nil
+	} else if !clouderrors.IsNotFound(err) {
+		return nil, statusErrorf(codes.Internal, "failed to retrieve volume %s: %w", shareName, err)
+	}
+
+	groupSnapshot, err := manilaClient.GetShareGroupSnapshot(groupSnapshotID)
+	if err != nil {
+		if clouderrors.IsNotFound(err) {
+			return nil, statusErrorf(codes.NotFound, "source group snapshot %s not found: %w", groupSnapshotID, err)
+		}
+
+		return nil, statusErrorf(codes.Internal, "failed to retrieve group snapshot %s: %w", groupSnapshotID, err)
+	}
+
+	if groupSnapshot.Status != snapshotAvailable {
+		if groupSnapshot.Status == snapshotCreating {
+			return nil, status.Errorf(codes.Unavailable, "group snapshot %s is in transient creating state", groupSnapshotID)
+		}
+
+		return nil, status.Errorf(codes.FailedPrecondition, "group snapshot %s is in invalid state: expected 'available', got '%s'", groupSnapshotID, groupSnapshot.Status)
+	}
+
+	var member *manilaclient.ShareGroupSnapshotMember
+	for i := range groupSnapshot.Members {
+		if groupSnapshot.Members[i].ID == memberID {
+			member = &groupSnapshot.Members[i]
+		}
+	}
+	if member == nil {
+		return nil, status.Errorf(codes.NotFound, "source snapshot %s not found: group snapshot %s has no member %s", snapshotID, groupSnapshotID, memberID)
+	}
+
+	// The members of the group snapshot are restored concurrently into the same share group
+	if _, isPending := pendingGroupRestores.LoadOrStore(groupSnapshotID, true); isPending {
+		return nil, status.Errorf(codes.Aborted, "group snapshot %s is already being restored", groupSnapshotID)
+	}
+	defer pendingGroupRestores.Delete(groupSnapshotID)
+
+	groupName := restoredShareGroupName(groupSnapshotID)
+	group, err := manilaClient.GetShareGroupByName(groupName)
+	if err != nil {
+		if !clouderrors.IsNotFound(err) {
+			return nil, statusErrorf(codes.Internal, "failed to probe for a share group named %s: %w", groupName, err)
+		}
+
+		if group, err = manilaClient.CreateShareGroup(manilaclient.ShareGroupCreateOpts{
+			Name:                       groupName,
+			Description:                shareDescription,
+			SourceShareGroupSnapshotID: groupSnapshotID,
+		}); err != nil {
+			return nil, statusErrorf(codes.Internal, "failed to restore group snapshot %s into share group %s: %w", groupSnapshotID, groupName, err)
+		}
+	}
+
+	switch group.Status {
+	case shareAvailable:
+	case shareCreating, shareCreatingFromSnapshot:
+		return nil, status.Errorf(codes.Unavailable, "group snapshot %s is being restored into share group %s", groupSnapshotID, group.ID)
+	default:
+		return nil, status.Errorf(codes.Internal, "failed to restore group snapshot %s into share group %s: share group is in %s state", groupSnapshotID, group.ID, group.Status)
+	}
+
+	shareID, err := manilaClient.GetShareGroupMemberShareID(group.ID, memberID)
+	if err != nil {
+		return nil, statusErrorf(codes.Internal, "failed to find the share restored from snapshot %s in share group %s: %w", snapshotID, group.ID, err)
+	}
+
+	share, err := manilaClient.GetShareByID(shareID)
+	if err != nil {
+		return nil, statusErrorf(codes.Internal, "failed to retrieve share %s restored from snapshot %s: %w", shareID, snapshotID, err)
+	}
+	if share.Name != "" && share.Name != shareName {
+		return nil, status.Errorf(codes.FailedPrecondition, "snapshot %s is already restored into volume %s, the snapshot of a group snapshot member can be restored only once", snapshotID, share.Name)
+	}
+
+	// Renamed last: once renamed, the volume is considered restored
+	if sizeInGiB > share.Size {
+		if share, err = extendShare(manilaClient, share.ID, sizeInGiB, timeout); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := manilaClient.SetShareMetadata(share.ID, shares.SetMetadataOpts{Metadata: shareMetadata}); err != nil {
+		return nil, statusErrorf(codes.Internal, "failed to set the metadata of share %s restored from snapshot %s: %w", share.ID, snapshotID, err)
+	}
+	if err := manilaClient.RenameShare(share.ID, shareName); err != nil {
+		return nil, statusErrorf(codes.Internal, "failed to rename share %s restored from snapshot %s to %s: %w", share.ID, snapshotID, shareName, err)
+	}
+	share.Name = shareName
+
+	return share, nil
+}
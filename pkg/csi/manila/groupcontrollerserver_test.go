@@ -0,0 +1,334 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/client"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
+)
+
+// fakeShareGroupClient serves the share groups of the shares and records the share group snapshots and the share
+// groups restored from them.
+type fakeShareGroupClient struct {
+	manilaclient.Interface
+	shareGroups    map[string]string
+	groupSnapshots map[string]*manilaclient.ShareGroupSnapshot
+
+	restoredGroups map[string]*manilaclient.ShareGroup
+	// restoredShares are the shares of the restored share groups by member ID
+	restoredShares map[string]*shares.Share
+}
+
+func (c *fakeShareGroupClient) CreateShareGroup(opts manilaclient.ShareGroupCreateOpts) (*manilaclient.ShareGroup, error) {
+	g := &manilaclient.ShareGroup{ID: "g-" + opts.Name, Name: opts.Name, Status: shareCreating}
+	for _, m := range c.groupSnapshots[opts.SourceShareGroupSnapshotID].Members {
+		c.restoredShares[m.ID] = &shares.Share{ID: "s-" + m.ID, Size: m.Size, Status: shareAvailable, Metadata: map[string]string{}}
+	}
+	c.restoredGroups[g.Name] = g
+	return g, nil
+}
+
+func (c *fakeShareGroupClient) GetShareGroupByName(name string) (*manilaclient.ShareGroup, error) {
+	g, ok := c.restoredGroups[name]
+	if !ok {
+		return nil, gophercloud.ErrResourceNotFound{}
+	}
+	return g, nil
+}
+
+func (c *fakeShareGroupClient) GetShareGroupMemberShareID(shareGroupID, memberID string) (string, error) {
+	s, ok := c.restoredShares[memberID]
+	if !ok {
+		return "", gophercloud.ErrResourceNotFound{}
+	}
+	return s.ID, nil
+}
+
+func (c *fakeShareGroupClient) restoredShare(shareID string) *shares.Share {
+	for _, s := range c.restoredShares {
+		if s.ID == shareID {
+			return s
+		}
+	}
+	return nil
+}
+
+func (c *fakeShareGroupClient) GetShareByID(shareID string) (*shares.Share, error) {
+	s := c.restoredShare(shareID)
+	if s == nil {
+		return nil, gophercloud.ErrDefault404{}
+	}
+	share := *s
+	return &share, nil
+}
+
+func (c *fakeShareGroupClient) GetShareByName(name string) (*shares.Share, error) {
+	for _, s := range c.restoredShares {
+		if s.Name == name {
+			share := *s
+			return &share, nil
+		}
+	}
+	return nil, gophercloud.ErrResourceNotFound{}
+}
+
+func (c *fakeShareGroupClient) RenameShare(shareID, name string) error {
+	c.restoredShare(shareID).Name = name
+	return nil
+}
+
+func (c *fakeShareGroupClient) SetShareMetadata(shareID string, opts shares.SetMetadataOptsBuilder) (map[string]string, error) {
+	s := c.restoredShare(shareID)
+	for k, v := range opts.(shares.SetMetadataOpts).Metadata {
+		s.Metadata[k] = v
+	}
+	return s.Metadata, nil
+}
+
+func (c *fakeShareGroupClient) ExtendShare(shareID string, opts shares.ExtendOptsBuilder) error {
+	c.restoredShare(shareID).Size = opts.(shares.ExtendOpts).NewSize
+	return nil
+}
+
+func (c *fakeShareGroupClient) GetShareGroupID(shareID string) (string, error) {
+	shareGroupID, ok := c.shareGroups[shareID]
+	if !ok {
+		return "", gophercloud.ErrDefault404{}
+	}
+	return shareGroupID, nil
+}
+
+func (c *fakeShareGroupClient) GetShareGroupShares(shareGroupID string) ([]shares.Share, error) {
+	var ss []shares.Share
+	for shareID, id := range c.shareGroups {
+		if id == shareGroupID {
+			ss = append(ss, shares.Share{ID: shareID, Size: 1})
+		}
+	}
+	return ss, nil
+}
+
+func (c *fakeShareGroupClient) CreateShareGroupSnapshot(opts manilaclient.ShareGroupSnapshotCreateOpts) (*manilaclient.ShareGroupSnapshot, error) {
+	s := &manilaclient.ShareGroupSnapshot{
+		ID:           "gs-" + opts.Name,
+		Name:         opts.Name,
+		Status:       snapshotCreating,
+		ShareGroupID: opts.ShareGroupID,
+	}
+	for shareID, id := range c.shareGroups {
+		if id == opts.ShareGroupID {
+			s.Members = append(s.Members, manilaclient.ShareGroupSnapshotMember{ID: "m-" + shareID, ShareID: shareID, Size: 1})
+		}
+	}
+	c.groupSnapshots[s.ID] = s
+	return s, nil
+}
+
+func (c *fakeShareGroupClient) GetShareGroupSnapshot(snapshotID string) (*manilaclient.ShareGroupSnapshot, error) {
+	s, ok := c.groupSnapshots[snapshotID]
+	if !ok {
+		return nil, gophercloud.ErrDefault404{}
+	}
+	return s, nil
+}
+
+func (c *fakeShareGroupClient) GetShareGroupSnapshotByName(name string) (*manilaclient.ShareGroupSnapshot, error) {
+	for _, s := range c.groupSnapshots {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return nil, gophercloud.ErrResourceNotFound{}
+}
+
+func (c *fakeShareGroupClient) DeleteShareGroupSnapshot(snapshotID string) error {
+	if _, ok := c.groupSnapshots[snapshotID]; !ok {
+		return gophercloud.ErrDefault404{}
+	}
+	delete(c.groupSnapshots, snapshotID)
+	return nil
+}
+
+type fakeShareGroupClientBuilder struct {
+	c *fakeShareGroupClient
+}
+
+func (b fakeShareGroupClientBuilder) New(*client.AuthOpts) (manilaclient.Interface, error) {
+	return b.c, nil
+}
+
+func TestVolumeGroupSnapshots(t *testing.T) {
+	c := &fakeShareGroupClient{
+		shareGroups: map[string]string{
+			"data":  "group-1",
+			"logs":  "group-1",
+			"other": "group-2",
+			"alone": "",
+		},
+		groupSnapshots: make(map[string]*manilaclient.ShareGroupSnapshot),
+	}
+	gcs := &groupControllerServer{d: &Driver{manilaClientBuilder: fakeShareGroupClientBuilder{c}}}
+
+	secrets := map[string]string{"os-authURL": "https://keystone", "os-userName": "admin", "os-password": "secret", "os-projectName": "admin", "os-domainName": "default", "os-region": "RegionOne"}
+	create := func(name string, volumeIDs ...string) (*csi.CreateVolumeGroupSnapshotResponse, error) {
+		return gcs.CreateVolumeGroupSnapshot(context.TODO(), &csi.CreateVolumeGroupSnapshotRequest{
+			Name:            name,
+			SourceVolumeIds: volumeIDs,
+			Secrets:         secrets,
+		})
+	}
+
+	for _, tc := range []struct {
+		name      string
+		volumeIDs []string
+		code      codes.Code
+	}{
+		{name: "not in a share group", volumeIDs: []string{"alone"}, code: codes.InvalidArgument},
+		{name: "different share groups", volumeIDs: []string{"data", "other"}, code: codes.InvalidArgument},
+		{name: "share group partially requested", volumeIDs: []string{"data"}, code: codes.InvalidArgument},
+		{name: "missing volume", volumeIDs: []string{"data", "missing"}, code: codes.NotFound},
+	} {
+		if _, err := create("snap", tc.volumeIDs...); status.Code(err) != tc.code {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.code, err)
+		}
+	}
+	if len(c.groupSnapshots) != 0 {
+		t.Fatalf("expected no group snapshot to be created, got %v", c.groupSnapshots)
+	}
+
+	resp, err := create("snap", "logs", "data")
+	if err != nil {
+		t.Fatalf("failed to create the group snapshot: %v", err)
+	}
+	gs := resp.GetGroupSnapshot()
+	if gs.GetGroupSnapshotId() != "gs-snap" || gs.GetReadyToUse() || len(gs.GetSnapshots()) != 2 {
+		t.Errorf("unexpected group snapshot %v", gs)
+	}
+	for _, s := range gs.GetSnapshots() {
+		if s.GetSnapshotId() != "gs-snap/m-"+s.GetSourceVolumeId() || s.GetGroupSnapshotId() != "gs-snap" || s.GetSizeBytes() != bytesInGiB {
+			t.Errorf("unexpected snapshot %v", s)
+		}
+	}
+
+	// Creating the group snapshot again returns it once available
+	c.groupSnapshots["gs-snap"].Status = snapshotAvailable
+	if resp, err = create("snap", "data", "logs"); err != nil || !resp.GetGroupSnapshot().GetReadyToUse() || len(c.groupSnapshots) != 1 {
+		t.Errorf("expected the existing group snapshot to be ready, got %v, %v", resp, err)
+	}
+
+	got, err := gcs.GetVolumeGroupSnapshot(context.TODO(), &csi.GetVolumeGroupSnapshotRequest{GroupSnapshotId: "gs-snap", Secrets: secrets})
+	if err != nil || !got.GetGroupSnapshot().GetReadyToUse() || len(got.GetGroupSnapshot().GetSnapshots()) != 2 {
+		t.Errorf("unexpected group snapshot %v, %v", got, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := gcs.DeleteVolumeGroupSnapshot(context.TODO(), &csi.DeleteVolumeGroupSnapshotRequest{GroupSnapshotId: "gs-snap", Secrets: secrets}); err != nil {
+			t.Errorf("failed to delete the group snapshot: %v", err)
+		}
+	}
+	if len(c.groupSnapshots) != 0 {
+		t.Errorf("expected the group snapshot to be deleted, got %v", c.groupSnapshots)
+	}
+
+	if _, err := gcs.GetVolumeGroupSnapshot(context.TODO(), &csi.GetVolumeGroupSnapshotRequest{GroupSnapshotId: "gs-snap", Secrets: secrets}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+}
+
+func TestRestoreGroupSnapshotMember(t *testing.T) {
+	c := &fakeShareGroupClient{
+		shareGroups:    map[string]string{"data": "group-1", "logs": "group-1"},
+		groupSnapshots: make(map[string]*manilaclient.ShareGroupSnapshot),
+		restoredGroups: make(map[string]*manilaclient.ShareGroup),
+		restoredShares: make(map[string]*shares.Share),
+	}
+	gs, err := c.CreateShareGroupSnapshot(manilaclient.ShareGroupSnapshotCreateOpts{ShareGroupID: "group-1", Name: "snap"})
+	if err != nil {
+		t.Fatalf("failed to create the group snapshot: %v", err)
+	}
+
+	// The member snapshot IDs reported by the group controller service are restored
+	var dataSnapshotID, logsSnapshotID string
+	for _, s := range volumeGroupSnapshot(gs).GetSnapshots() {
+		switch s.GetSourceVolumeId() {
+		case "data":
+			dataSnapshotID = s.GetSnapshotId()
+		case "logs":
+			logsSnapshotID = s.GetSnapshotId()
+		}
+	}
+
+	restore := func(snapshotID, name string, sizeInGiB int) (*shares.Share, error) {
+		req := &csi.CreateVolumeRequest{
+			Name: name,
+			VolumeContentSource: &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Snapshot{Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: snapshotID}},
+			},
+		}
+		return volumeFromSnapshot{}.create(c, req, name, sizeInGiB, &options.ControllerVolumeContext{}, map[string]string{"manila.csi.openstack.org/cluster": "cluster"})
+	}
+
+	if _, err := restore(dataSnapshotID, "pvc-data", 1); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable while the group snapshot is being created, got %v", err)
+	}
+	gs.Status = snapshotAvailable
+
+	if _, err := restore(dataSnapshotID, "pvc-data", 1); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable while the share group is being restored, got %v", err)
+	}
+	if len(c.restoredGroups) != 1 {
+		t.Fatalf("expected the group snapshot to be restored into a share group, got %v", c.restoredGroups)
+	}
+	c.restoredGroups[restoredShareGroupName(gs.ID)].Status = shareAvailable
+
+	share, err := restore(dataSnapshotID, "pvc-data", 2)
+	if err != nil {
+		t.Fatalf("failed to restore %s: %v", dataSnapshotID, err)
+	}
+	if share.ID != "s-m-data" || share.Name != "pvc-data" || share.Size != 2 || share.Metadata["manila.csi.openstack.org/cluster"] != "cluster" {
+		t.Errorf("unexpected restored share %+v", share)
+	}
+
+	// Restoring the volume again returns it, and the other member is restored from the same share group
+	if share, err = restore(dataSnapshotID, "pvc-data", 2); err != nil || share.ID != "s-m-data" {
+		t.Errorf("expected the restored share, got %v, %v", share, err)
+	}
+	if share, err = restore(logsSnapshotID, "pvc-logs", 1); err != nil || share.ID != "s-m-logs" || share.Name != "pvc-logs" {
+		t.Errorf("expected the share restored from %s, got %v, %v", logsSnapshotID, share, err)
+	}
+	if len(c.restoredGroups) != 1 {
+		t.Errorf("expected a single restored share group, got %v", c.restoredGroups)
+	}
+
+	// A member is restored once
+	if _, err := restore(dataSnapshotID, "pvc-data-2", 1); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition, got %v", err)
+	}
+
+	if _, err := restore(gs.ID+"/m-missing", "pvc-missing", 1); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+}
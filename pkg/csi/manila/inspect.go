@@ -0,0 +1,343 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/snapshots"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
+	clouderrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+var volumeSnapshotContentsResource = schema.GroupVersionResource{
+	Group:    "snapshot.storage.k8s.io",
+	Version:  "v1",
+	Resource: "volumesnapshotcontents",
+}
+
+// InspectOpts configures Inspect.
+type InspectOpts struct {
+	// DriverName is the driver of the PersistentVolumes and VolumeSnapshotContents.
+	DriverName string
+	// ClusterID is the cluster the shares are attributed to by their metadata.
+	ClusterID string
+	// SecretDir is a directory containing the OpenStack credentials, one file per key, in the same
+	// format as the CSI secrets.
+	SecretDir string
+
+	ManilaClientBuilder manilaclient.Builder
+	KubeClient          kubernetes.Interface
+	DynamicClient       dynamic.Interface
+}
+
+// InspectReport lists the shares attributed to a cluster, with their snapshots and access rules,
+// and the Kubernetes objects referencing them.
+type InspectReport struct {
+	Shares []InspectedShare
+	// MissingShares maps the PersistentVolumes of the driver to the shares they reference which don't exist.
+	MissingShares map[string]string
+}
+
+type InspectedShare struct {
+	ID     string
+	Name   string
+	Status string
+	// PersistentVolumes referencing the share, none if the share is orphaned.
+	PersistentVolumes []string
+
+	Snapshots   []InspectedSnapshot
+	AccessRules []InspectedAccessRule
+}
+
+type InspectedSnapshot struct {
+	ID     string
+	Name   string
+	Status string
+	// VolumeSnapshotContent referencing the snapshot, empty if the snapshot is orphaned.
+	VolumeSnapshotContent string
+}
+
+type InspectedAccessRule struct {
+	ID         string
+	AccessType string
+	AccessTo   string
+	State      string
+	// Referenced is false if neither a PersistentVolume nor the key rotation use the access rule.
+	Referenced bool
+}
+
+// Orphans returns the number of orphaned shares, snapshots and access rules, and of missing shares.
+func (r *InspectReport) Orphans() int {
+	n := len(r.MissingShares)
+	for i := range r.Shares {
+		s := &r.Shares[i]
+		if len(s.PersistentVolumes) == 0 {
+			n++
+		}
+		for j := range s.Snapshots {
+			if s.Snapshots[j].VolumeSnapshotContent == "" {
+				n++
+			}
+		}
+		for j := range s.AccessRules {
+			if !s.AccessRules[j].Referenced {
+				n++
+			}
+		}
+	}
+
+	return n
+}
+
+// Write writes the report as a table, only the orphans if orphansOnly is set.
+func (r *InspectReport) Write(w io.Writer, orphansOnly bool) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tID\tNAME\tSTATUS\tREFERENCED BY")
+
+	row := func(kind, id, name, status, referencedBy string) {
+		if referencedBy == "" {
+			referencedBy = "<orphan>"
+		} else if orphansOnly {
+			return
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", kind, id, name, status, referencedBy)
+	}
+
+	for i := range r.Shares {
+		s := &r.Shares[i]
+		row("share", s.ID, s.Name, s.Status, strings.Join(s.PersistentVolumes, ","))
+
+		for j := range s.Snapshots {
+			snap := &s.Snapshots[j]
+			row("snapshot", snap.ID, snap.Name, snap.Status, snap.VolumeSnapshotContent)
+		}
+
+		for j := range s.AccessRules {
+			rule := &s.AccessRules[j]
+			var referencedBy string
+			if rule.Referenced {
+				referencedBy = strings.Join(s.PersistentVolumes, ",")
+			}
+			row("access-rule", rule.ID, rule.AccessType+":"+rule.AccessTo, rule.State, referencedBy)
+		}
+	}
+
+	for _, pv := range sets.List(sets.KeySet(r.MissingShares)) {
+		fmt.Fprintf(tw, "share\t%s\t\tmissing\t%s\n", r.MissingShares[pv], pv)
+	}
+
+	return tw.Flush()
+}
+
+// Inspect lists the shares attributed to the cluster by their metadata, their snapshots and access rules, and
+// cross-references them with the PersistentVolumes and VolumeSnapshotContents of the driver, so that the
+// operators can audit the resources left behind in Manila.
+func Inspect(ctx context.Context, o *InspectOpts) (*InspectReport, error) {
+	if o.ClusterID == "" {
+		return nil, fmt.Errorf("the cluster ID is required to find the shares of the cluster")
+	}
+
+	secrets, err := readSecretDir(o.SecretDir)
+	if err != nil {
+		return nil, err
+	}
+
+	osOpts, err := options.NewOpenstackOptions(secrets)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenStack secrets in %s: %v", o.SecretDir, err)
+	}
+
+	manilaClient, err := o.ManilaClientBuilder.New(osOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Manila v2 client: %v", err)
+	}
+
+	shs, err := manilaClient.ListShares(shares.ListOpts{Metadata: map[string]string{clusterMetadataKey: o.ClusterID}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list shares: %v", err)
+	}
+
+	pvs, err := o.KubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list PersistentVolumes: %v", err)
+	}
+
+	contents, err := volumeSnapshotContents(ctx, o.DynamicClient, o.DriverName)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		// The PersistentVolumes referencing each share, by ID or by name
+		byID   = make(map[string][]string)
+		byName = make(map[string][]string)
+		// The access rules of each share referenced by its PersistentVolumes, by ID or access_to
+		accessIDs = make(map[string]sets.Set[string])
+		accessTos = make(map[string]sets.Set[string])
+	)
+
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != o.DriverName {
+			continue
+		}
+
+		attrs := pv.Spec.CSI.VolumeAttributes
+		shareID := attrs["shareID"]
+		if shareID == "" {
+			if attrs["shareName"] != "" {
+				byName[attrs["shareName"]] = append(byName[attrs["shareName"]], pv.Name)
+			}
+			continue
+		}
+
+		byID[shareID] = append(byID[shareID], pv.Name)
+		if id := attrs["shareAccessID"]; id != "" {
+			addToSet(accessIDs, shareID, id)
+		}
+		if to := attrs["readOnlyAccessTo"]; to != "" {
+			addToSet(accessTos, shareID, to)
+		}
+	}
+
+	r := &InspectReport{MissingShares: make(map[string]string)}
+	listed := sets.New[string]()
+
+	for i := range shs {
+		share := &shs[i]
+		listed.Insert(share.ID)
+
+		s := InspectedShare{
+			ID:                share.ID,
+			Name:              share.Name,
+			Status:            share.Status,
+			PersistentVolumes: append(byID[share.ID], byName[share.Name]...),
+		}
+
+		snaps, err := manilaClient.ListSnapshots(snapshots.ListOpts{ShareID: share.ID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list snapshots of share %s: %v", share.ID, err)
+		}
+		for j := range snaps {
+			s.Snapshots = append(s.Snapshots, InspectedSnapshot{
+				ID:                    snaps[j].ID,
+				Name:                  snaps[j].Name,
+				Status:                snaps[j].Status,
+				VolumeSnapshotContent: contents[snaps[j].ID],
+			})
+		}
+
+		rights, err := manilaClient.GetAccessRights(share.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list access rights of share %s: %v", share.ID, err)
+		}
+
+		// The access rights of a rotated key are recorded in the share metadata
+		referenced := sets.New[string]()
+		if id := share.Metadata[accessIDMetadataKey]; id != "" {
+			referenced.Insert(id)
+		}
+		if revoke := strings.Fields(share.Metadata[accessRevokeMetadataKey]); len(revoke) == 2 && revoke[1] != accessRevoked {
+			referenced.Insert(revoke[0])
+		}
+
+		for j := range rights {
+			right := &rights[j]
+			s.AccessRules = append(s.AccessRules, InspectedAccessRule{
+				ID:         right.ID,
+				AccessType: right.AccessType,
+				AccessTo:   right.AccessTo,
+				State:      right.State,
+				Referenced: len(s.PersistentVolumes) > 0 &&
+					(referenced.Has(right.ID) || accessIDs[share.ID].Has(right.ID) || accessTos[share.ID].Has(right.AccessTo)),
+			})
+		}
+
+		r.Shares = append(r.Shares, s)
+	}
+
+	// The shares referenced by the PersistentVolumes which aren't attributed to the cluster may
+	// belong to other clusters, or have been deleted
+	for shareID, names := range byID {
+		if listed.Has(shareID) {
+			continue
+		}
+
+		if _, err := manilaClient.GetShareByID(shareID); err != nil {
+			if !clouderrors.IsNotFound(err) {
+				return nil, fmt.Errorf("failed to get share %s: %v", shareID, err)
+			}
+			for _, name := range names {
+				r.MissingShares[name] = shareID
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// volumeSnapshotContents maps the snapshot handles of the VolumeSnapshotContents of the driver to their names.
+func volumeSnapshotContents(ctx context.Context, dynamicClient dynamic.Interface, driverName string) (map[string]string, error) {
+	list, err := dynamicClient.Resource(volumeSnapshotContentsResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// The snapshot CRDs aren't installed, no snapshot is managed by Kubernetes
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list VolumeSnapshotContents: %v", err)
+	}
+
+	contents := make(map[string]string)
+	for i := range list.Items {
+		obj := list.Items[i].Object
+		if driver, _, _ := unstructured.NestedString(obj, "spec", "driver"); driver != driverName {
+			continue
+		}
+
+		// Pre-provisioned contents reference the snapshot in their spec, dynamically provisioned ones in their status
+		handle, _, _ := unstructured.NestedString(obj, "status", "snapshotHandle")
+		if handle == "" {
+			handle, _, _ = unstructured.NestedString(obj, "spec", "source", "snapshotHandle")
+		}
+		if handle != "" {
+			contents[handle] = list.Items[i].GetName()
+		}
+	}
+
+	return contents, nil
+}
+
+func addToSet(m map[string]sets.Set[string], key, value string) {
+	if m[key] == nil {
+		m[key] = sets.New[string]()
+	}
+	m[key].Insert(value)
+}
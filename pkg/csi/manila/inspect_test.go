@@ -0,0 +1,229 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/snapshots"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/cloud-provider-openstack/pkg/client"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+)
+
+type fakeInspectClient struct {
+	manilaclient.Interface
+
+	shares    []shares.Share
+	snapshots []snapshots.Snapshot
+	rights    map[string][]shares.AccessRight
+	listOpts  shares.ListOpts
+}
+
+func (c *fakeInspectClient) ListShares(opts shares.ListOptsBuilder) ([]shares.Share, error) {
+	c.listOpts = opts.(shares.ListOpts)
+	return c.shares, nil
+}
+
+func (c *fakeInspectClient) GetShareByID(shareID string) (*shares.Share, error) {
+	if shareID == "other-cluster" {
+		return &shares.Share{ID: shareID}, nil
+	}
+	return nil, gophercloud.ErrDefault404{}
+}
+
+func (c *fakeInspectClient) ListSnapshots(opts snapshots.ListOptsBuilder) ([]snapshots.Snapshot, error) {
+	var res []snapshots.Snapshot
+	for _, snap := range c.snapshots {
+		if snap.ShareID == opts.(snapshots.ListOpts).ShareID {
+			res = append(res, snap)
+		}
+	}
+	return res, nil
+}
+
+func (c *fakeInspectClient) GetAccessRights(shareID string) ([]shares.AccessRight, error) {
+	return c.rights[shareID], nil
+}
+
+type fakeInspectClientBuilder struct {
+	c *fakeInspectClient
+}
+
+func (b fakeInspectClientBuilder) New(*client.AuthOpts) (manilaclient.Interface, error) {
+	return b.c, nil
+}
+
+func inspectedPV(name string, attrs map[string]string) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{Driver: "manila.csi.openstack.org", VolumeAttributes: attrs},
+			},
+		},
+	}
+}
+
+func volumeSnapshotContent(name, driver, handle string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "snapshot.storage.k8s.io/v1",
+		"kind":       "VolumeSnapshotContent",
+		"metadata":   map[string]interface{}{"name": name},
+		"spec":       map[string]interface{}{"driver": driver},
+		"status":     map[string]interface{}{"snapshotHandle": handle},
+	}}
+}
+
+func TestInspect(t *testing.T) {
+	dir := t.TempDir()
+	for k, v := range map[string]string{"os-authURL": "https://keystone", "os-userName": "admin", "os-password": "secret", "os-projectName": "admin", "os-domainName": "default", "os-region": "RegionOne"} {
+		if err := os.WriteFile(filepath.Join(dir, k), []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manilaClient := &fakeInspectClient{
+		shares: []shares.Share{
+			{ID: "used", Name: "pvc-used", Status: "available", Metadata: map[string]string{
+				accessIDMetadataKey:     "rotated",
+				accessRevokeMetadataKey: "previous 2024-01-01T00:00:00Z",
+			}},
+			{ID: "orphan", Name: "pvc-orphan", Status: "available"},
+		},
+		snapshots: []snapshots.Snapshot{
+			{ID: "snap-used", ShareID: "used", Status: "available"},
+			{ID: "snap-orphan", ShareID: "used", Status: "available"},
+		},
+		rights: map[string][]shares.AccessRight{
+			"used": {
+				{ID: "original", AccessType: "cephx", AccessTo: "pvc-used"},
+				{ID: "rotated", AccessType: "cephx", AccessTo: "pvc-used-1"},
+				{ID: "previous", AccessType: "cephx", AccessTo: "pvc-used-0"},
+				{ID: "ro", AccessType: "cephx", AccessTo: "reader"},
+				{ID: "stale", AccessType: "cephx", AccessTo: "someone"},
+			},
+			"orphan": {
+				{ID: "orphan-rule", AccessType: "cephx", AccessTo: "pvc-orphan"},
+			},
+		},
+	}
+
+	kubeClient := fake.NewSimpleClientset(
+		inspectedPV("pv-used", map[string]string{"shareID": "used", "shareAccessID": "original"}),
+		inspectedPV("pv-ro", map[string]string{"shareID": "used", "readOnlyAccessTo": "reader"}),
+		inspectedPV("pv-missing", map[string]string{"shareID": "deleted", "shareAccessID": "x"}),
+		inspectedPV("pv-other", map[string]string{"shareID": "other-cluster", "shareAccessID": "y"}),
+	)
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{volumeSnapshotContentsResource: "VolumeSnapshotContentList"},
+		volumeSnapshotContent("snapcontent-used", "manila.csi.openstack.org", "snap-used"),
+		volumeSnapshotContent("snapcontent-other", "cinder.csi.openstack.org", "snap-orphan"),
+	)
+
+	r, err := Inspect(context.Background(), &InspectOpts{
+		DriverName:          "manila.csi.openstack.org",
+		ClusterID:           "cluster",
+		SecretDir:           dir,
+		ManilaClientBuilder: fakeInspectClientBuilder{c: manilaClient},
+		KubeClient:          kubeClient,
+		DynamicClient:       dynamicClient,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if manilaClient.listOpts.Metadata[clusterMetadataKey] != "cluster" {
+		t.Errorf("expected the shares to be listed by cluster metadata, got %v", manilaClient.listOpts.Metadata)
+	}
+
+	if len(r.Shares) != 2 {
+		t.Fatalf("expected 2 shares, got %+v", r.Shares)
+	}
+
+	used := r.Shares[0]
+	if !reflect.DeepEqual(used.PersistentVolumes, []string{"pv-ro", "pv-used"}) && !reflect.DeepEqual(used.PersistentVolumes, []string{"pv-used", "pv-ro"}) {
+		t.Errorf("expected share used to be referenced by pv-used and pv-ro, got %v", used.PersistentVolumes)
+	}
+
+	snaps := make(map[string]string)
+	for _, snap := range used.Snapshots {
+		snaps[snap.ID] = snap.VolumeSnapshotContent
+	}
+	if expected := map[string]string{"snap-used": "snapcontent-used", "snap-orphan": ""}; !reflect.DeepEqual(snaps, expected) {
+		t.Errorf("expected snapshots %v, got %v", expected, snaps)
+	}
+
+	rules := make(map[string]bool)
+	for _, rule := range used.AccessRules {
+		rules[rule.ID] = rule.Referenced
+	}
+	if expected := map[string]bool{"original": true, "rotated": true, "previous": true, "ro": true, "stale": false}; !reflect.DeepEqual(rules, expected) {
+		t.Errorf("expected access rules %v, got %v", expected, rules)
+	}
+
+	orphan := r.Shares[1]
+	if len(orphan.PersistentVolumes) != 0 || len(orphan.AccessRules) != 1 || orphan.AccessRules[0].Referenced {
+		t.Errorf("expected share orphan and its access rule to be orphaned, got %+v", orphan)
+	}
+
+	if expected := map[string]string{"pv-missing": "deleted"}; !reflect.DeepEqual(r.MissingShares, expected) {
+		t.Errorf("expected missing shares %v, got %v", expected, r.MissingShares)
+	}
+
+	// share orphan, its access rule, snap-orphan, stale and the share of pv-missing
+	if n := r.Orphans(); n != 5 {
+		t.Errorf("expected 5 orphans, got %d", n)
+	}
+
+	var buf bytes.Buffer
+	if err := r.Write(&buf, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := buf.String()
+	for _, id := range []string{"orphan", "orphan-rule", "snap-orphan", "stale", "deleted"} {
+		if !strings.Contains(out, id) {
+			t.Errorf("expected %s in the orphans, got:\n%s", id, out)
+		}
+	}
+	for _, id := range []string{"snap-used", "original", "pvc-used-1"} {
+		if strings.Contains(out, id) {
+			t.Errorf("expected %s not to be in the orphans, got:\n%s", id, out)
+		}
+	}
+}
+
+func TestInspectRequiresClusterID(t *testing.T) {
+	if _, err := Inspect(context.Background(), &InspectOpts{}); err == nil {
+		t.Error("expected an error without cluster ID")
+	}
+}
@@ -0,0 +1,223 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// nfsKrb5KeytabSecretKey is the key of the node stage secret holding the
+// base64-encoded Kerberos keytab used to mount NFS shares with sec=krb5*.
+const nfsKrb5KeytabSecretKey = "nfs-krb5Keytab"
+
+// keytabVersion is the version of the keytab file format written by MIT
+// Kerberos and Heimdal, with big-endian integers.
+const keytabVersion = 0x0502
+
+// nfsKeytabMu serializes the updates of the node keytab.
+var nfsKeytabMu sync.Mutex
+
+// writeNFSKeytab merges the keytab found in the node stage secrets into the
+// keytab at path, where the rpc.gssd daemon of the node is expected to read
+// it. The shares staged on the node may use different principals, the entries
+// of the other principals are kept, and the entries of the same principal,
+// key version and encryption type are replaced. It's a no-op if the secrets
+// contain no keytab. The file is replaced atomically so that rpc.gssd never
+// reads a partially written keytab.
+func writeNFSKeytab(path string, secrets map[string]string) error {
+	data, ok := secrets[nfsKrb5KeytabSecretKey]
+	if !ok {
+		return nil
+	}
+
+	keytab, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s secret: %v", nfsKrb5KeytabSecretKey, err)
+	}
+
+	entries, err := parseKeytab(keytab)
+	if err != nil {
+		return fmt.Errorf("invalid keytab in %s secret: %v", nfsKrb5KeytabSecretKey, err)
+	}
+
+	nfsKeytabMu.Lock()
+	defer nfsKeytabMu.Unlock()
+
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(existing) > 0 {
+		existingEntries, err := parseKeytab(existing)
+		if err != nil {
+			return fmt.Errorf("failed to parse the keytab %s: %v", path, err)
+		}
+		entries = mergeKeytabEntries(existingEntries, entries)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".keytab-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(encodeKeytab(entries)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// keytabEntry is an entry of a keytab file. id identifies the principal, key
+// version and encryption type of the entry, raw is its encoded form.
+type keytabEntry struct {
+	id  string
+	raw []byte
+}
+
+// parseKeytab returns the entries of a keytab file, skipping the holes left by
+// deleted entries.
+func parseKeytab(data []byte) ([]keytabEntry, error) {
+	if len(data) < 2 || binary.BigEndian.Uint16(data) != keytabVersion {
+		return nil, fmt.Errorf("unsupported keytab format, expected version 0x%04x", keytabVersion)
+	}
+
+	var entries []keytabEntry
+	for rest := data[2:]; len(rest) > 0; {
+		if len(rest) < 4 {
+			return nil, errors.New("truncated entry size")
+		}
+		size := int32(binary.BigEndian.Uint32(rest))
+		rest = rest[4:]
+
+		n := int(size)
+		if size < 0 {
+			n = -n
+		}
+		if n > len(rest) {
+			return nil, errors.New("truncated entry")
+		}
+		if size > 0 {
+			id, err := keytabEntryID(rest[:n])
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, keytabEntry{id: id, raw: rest[:n]})
+		}
+		rest = rest[n:]
+	}
+
+	return entries, nil
+}
+
+// keytabEntryID returns a key made of the principal, key version and
+// encryption type of the entry.
+func keytabEntryID(entry []byte) (string, error) {
+	r := bytes.NewReader(entry)
+
+	var numComponents uint16
+	if err := binary.Read(r, binary.BigEndian, &numComponents); err != nil {
+		return "", errors.New("truncated principal")
+	}
+
+	// The realm and the components of the principal
+	var principal []string
+	for i := 0; i <= int(numComponents); i++ {
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return "", errors.New("truncated principal")
+		}
+		component := make([]byte, length)
+		if _, err := io.ReadFull(r, component); err != nil {
+			return "", errors.New("truncated principal")
+		}
+		principal = append(principal, string(component))
+	}
+
+	var fields struct {
+		NameType  uint32
+		Timestamp uint32
+		KVNO8     uint8
+		KeyType   uint16
+		KeyLength uint16
+	}
+	if err := binary.Read(r, binary.BigEndian, &fields); err != nil {
+		return "", errors.New("truncated entry")
+	}
+	if int(fields.KeyLength) > r.Len() {
+		return "", errors.New("truncated key")
+	}
+	if _, err := r.Seek(int64(fields.KeyLength), io.SeekCurrent); err != nil {
+		return "", err
+	}
+
+	// The 32-bit key version, if present, supersedes the 8-bit one
+	kvno := uint32(fields.KVNO8)
+	if r.Len() >= 4 {
+		var kvno32 uint32
+		if err := binary.Read(r, binary.BigEndian, &kvno32); err == nil && kvno32 != 0 {
+			kvno = kvno32
+		}
+	}
+
+	return fmt.Sprintf("%q/%d/%d/%d", principal, fields.NameType, kvno, fields.KeyType), nil
+}
+
+// mergeKeytabEntries returns the existing entries without those of the same
+// principal, key version and encryption type as one of the new entries,
+// followed by the new entries.
+func mergeKeytabEntries(existing, entries []keytabEntry) []keytabEntry {
+	ids := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		ids[e.id] = true
+	}
+
+	var merged []keytabEntry
+	for _, e := range existing {
+		if !ids[e.id] {
+			merged = append(merged, e)
+		}
+	}
+
+	return append(merged, entries...)
+}
+
+// encodeKeytab returns the keytab file made of the entries.
+func encodeKeytab(entries []keytabEntry) []byte {
+	buf := binary.BigEndian.AppendUint16(nil, keytabVersion)
+	for _, e := range entries {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(e.raw)))
+		buf = append(buf, e.raw...)
+	}
+
+	return buf
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testKeytabEntry returns an encoded keytab entry of the principal name@realm.
+func testKeytabEntry(name, realm string, kvno uint8, keyType uint16, key string) []byte {
+	countedString := func(buf []byte, s string) []byte {
+		return append(binary.BigEndian.AppendUint16(buf, uint16(len(s))), s...)
+	}
+
+	entry := binary.BigEndian.AppendUint16(nil, 1)
+	entry = countedString(entry, realm)
+	entry = countedString(entry, name)
+	entry = binary.BigEndian.AppendUint32(entry, 1) // KRB5_NT_PRINCIPAL
+	entry = binary.BigEndian.AppendUint32(entry, 0) // timestamp
+	entry = append(entry, kvno)
+	entry = binary.BigEndian.AppendUint16(entry, keyType)
+	entry = countedString(entry, key)
+
+	return entry
+}
+
+// testKeytab returns a keytab file made of the entries.
+func testKeytab(entries ...[]byte) []byte {
+	keytab := binary.BigEndian.AppendUint16(nil, keytabVersion)
+	for _, e := range entries {
+		keytab = binary.BigEndian.AppendUint32(keytab, uint32(len(e)))
+		keytab = append(keytab, e...)
+	}
+
+	return keytab
+}
+
+func TestWriteNFSKeytab(t *testing.T) {
+	alice := testKeytabEntry("alice", "EXAMPLE.COM", 1, 18, "alice-key")
+	aliceRotated := testKeytabEntry("alice", "EXAMPLE.COM", 1, 18, "alice-new-key")
+	aliceKVNO2 := testKeytabEntry("alice", "EXAMPLE.COM", 2, 18, "alice-key-2")
+	bob := testKeytabEntry("bob", "EXAMPLE.COM", 1, 18, "bob-key")
+
+	// A hole left by a deleted entry
+	withHole := binary.BigEndian.AppendUint16(nil, keytabVersion)
+	withHole = binary.BigEndian.AppendUint32(withHole, uint32(0xfffffffc)) // -4
+	withHole = append(withHole, 0, 0, 0, 0)
+	withHole = binary.BigEndian.AppendUint32(withHole, uint32(len(alice)))
+	withHole = append(withHole, alice...)
+
+	encode := func(keytab []byte) string {
+		return base64.StdEncoding.EncodeToString(keytab)
+	}
+
+	ts := []struct {
+		name             string
+		existing         []byte
+		secrets          map[string]string
+		expectedKeytab   []byte
+		expectedErrorSet bool
+	}{
+		{
+			name:           "no existing keytab",
+			secrets:        map[string]string{nfsKrb5KeytabSecretKey: encode(testKeytab(alice))},
+			expectedKeytab: testKeytab(alice),
+		},
+		{
+			name:           "entries of another principal are kept",
+			existing:       testKeytab(bob),
+			secrets:        map[string]string{nfsKrb5KeytabSecretKey: encode(testKeytab(alice))},
+			expectedKeytab: testKeytab(bob, alice),
+		},
+		{
+			name:           "entries of the same principal, key version and type are replaced",
+			existing:       testKeytab(alice, aliceKVNO2, bob),
+			secrets:        map[string]string{nfsKrb5KeytabSecretKey: encode(testKeytab(aliceRotated))},
+			expectedKeytab: testKeytab(aliceKVNO2, bob, aliceRotated),
+		},
+		{
+			name:           "holes are dropped",
+			existing:       withHole,
+			secrets:        map[string]string{nfsKrb5KeytabSecretKey: encode(testKeytab(bob))},
+			expectedKeytab: testKeytab(alice, bob),
+		},
+		{
+			name:           "no keytab in the secrets, the existing file is kept",
+			existing:       testKeytab(bob),
+			secrets:        map[string]string{"os-authURL": "http://keystone"},
+			expectedKeytab: testKeytab(bob),
+		},
+		{
+			name:             "invalid base64",
+			secrets:          map[string]string{nfsKrb5KeytabSecretKey: "not base64"},
+			expectedErrorSet: true,
+		},
+		{
+			name:             "unsupported keytab version",
+			secrets:          map[string]string{nfsKrb5KeytabSecretKey: encode([]byte{0x05, 0x01})},
+			expectedErrorSet: true,
+		},
+		{
+			name:             "truncated keytab",
+			secrets:          map[string]string{nfsKrb5KeytabSecretKey: encode(testKeytab(alice)[:20])},
+			expectedErrorSet: true,
+		},
+		{
+			name:             "invalid existing keytab",
+			existing:         []byte("previous"),
+			secrets:          map[string]string{nfsKrb5KeytabSecretKey: encode(testKeytab(alice))},
+			expectedErrorSet: true,
+		},
+	}
+
+	for _, tc := range ts {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "krb5.keytab")
+			if tc.existing != nil {
+				if err := os.WriteFile(path, tc.existing, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := writeNFSKeytab(path, tc.secrets)
+			if tc.expectedErrorSet {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			keytab, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(keytab, tc.expectedKeytab) {
+				t.Errorf("expected keytab %x, got %x", tc.expectedKeytab, keytab)
+			}
+
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != 0600 {
+				t.Errorf("expected mode 0600, got %v", fi.Mode().Perm())
+			}
+		})
+	}
+}
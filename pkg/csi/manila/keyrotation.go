@@ -0,0 +1,457 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
+	clouderrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+// KeyRotationOpts configures the rotation of the cephx keys of the CephFS volumes. A rotation grants a new
+// access right to the share, makes it the access right the node service stages the volume with, and revokes
+// the previous access right once the grace period is over. The key of a volume is rotated when it is older
+// than Period, or when its PersistentVolume is annotated with manila.csi.openstack.org/rotate-access-key=true.
+type KeyRotationOpts struct {
+	// SecretDir is a directory containing the OpenStack credentials, one file per key, in the same
+	// format as the CSI secrets. The rotation is disabled if empty.
+	SecretDir string
+	// Period is the maximum age of a cephx key. Zero disables the periodic rotation, the keys are
+	// then only rotated on request.
+	Period time.Duration
+	// GracePeriod the previous access right is kept for after a rotation. The volumes staged in the
+	// meantime use the new key. The previous access right is only revoked once the volume isn't staged
+	// with the previous key anymore, i.e. once the pods using it at the rotation are gone.
+	GracePeriod time.Duration
+	// Interval between two checks of the volumes.
+	Interval time.Duration
+	// KubeClient is used to watch and annotate the PersistentVolumes, and to watch the pods using them.
+	KubeClient kubernetes.Interface
+}
+
+const (
+	rotateAccessKeyAnnotation    = "manila.csi.openstack.org/rotate-access-key"
+	accessKeyRotatedAtAnnotation = "manila.csi.openstack.org/access-key-rotated-at"
+	// Comma separated list of the nodes which may still have the volume staged with the previous key
+	previousAccessKeyNodesAnnotation = "manila.csi.openstack.org/previous-access-key-nodes"
+
+	// The state of the rotation is kept in the share metadata, where the node service looks up
+	// the access right to stage the volume with.
+	accessIDMetadataKey         = "manila.csi.openstack.org/access-id"
+	accessGenerationMetadataKey = "manila.csi.openstack.org/access-generation"
+	accessRotatedAtMetadataKey  = "manila.csi.openstack.org/access-rotated-at"
+	// Holds "<access right ID> <RFC 3339 time>", the previous access right and when to revoke it,
+	// or "<access right ID> revoked" once done.
+	accessRevokeMetadataKey = "manila.csi.openstack.org/access-revoke"
+
+	accessRevoked = "revoked"
+
+	// Time a rotation waits for the cephx key of the new access right. A rotation that times out
+	// is resumed on the next check, reusing the access right granted already.
+	keyRotationKeyTimeout = 2 * time.Minute
+
+	// podsByClaimIndex indexes the pods by the PersistentVolumeClaims they use
+	podsByClaimIndex = "pvc"
+)
+
+// keyRotationListers serves the PersistentVolumes and the pods using them from the caches of informers.
+type keyRotationListers struct {
+	pvLister   corelisters.PersistentVolumeLister
+	podIndexer cache.Indexer
+}
+
+func newKeyRotationListers(kubeClient kubernetes.Interface, stopCh <-chan struct{}) (*keyRotationListers, error) {
+	factory := informers.NewSharedInformerFactory(kubeClient, 0)
+
+	pvLister := factory.Core().V1().PersistentVolumes().Lister()
+	podInformer := factory.Core().V1().Pods().Informer()
+	if err := podInformer.AddIndexers(cache.Indexers{podsByClaimIndex: podClaims}); err != nil {
+		return nil, fmt.Errorf("failed to index the pods by PersistentVolumeClaim: %v", err)
+	}
+
+	factory.Start(stopCh)
+	for typ, synced := range factory.WaitForCacheSync(stopCh) {
+		if !synced {
+			return nil, fmt.Errorf("failed to sync the cache of %v", typ)
+		}
+	}
+
+	return &keyRotationListers{pvLister: pvLister, podIndexer: podInformer.GetIndexer()}, nil
+}
+
+// podClaims returns the keys of the PersistentVolumeClaims used by the pod, if it's scheduled and not terminated.
+func podClaims(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok || pod.Spec.NodeName == "" || pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return nil, nil
+	}
+
+	var claims []string
+	for _, vol := range pod.Spec.Volumes {
+		if vol.PersistentVolumeClaim != nil {
+			claims = append(claims, pod.Namespace+"/"+vol.PersistentVolumeClaim.ClaimName)
+		}
+	}
+
+	return claims, nil
+}
+
+// nodesUsingVolume returns the sorted names of the nodes running pods using the volume, where the volume is
+// staged or about to be.
+func (l *keyRotationListers) nodesUsingVolume(pv *v1.PersistentVolume) ([]string, error) {
+	if l == nil || pv.Spec.ClaimRef == nil {
+		return nil, nil
+	}
+
+	pods, err := l.podIndexer.ByIndex(podsByClaimIndex, pv.Spec.ClaimRef.Namespace+"/"+pv.Spec.ClaimRef.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := sets.New[string]()
+	for _, obj := range pods {
+		nodes.Insert(obj.(*v1.Pod).Spec.NodeName)
+	}
+
+	return sets.List(nodes), nil
+}
+
+// runKeyRotation periodically rotates the cephx keys of the volumes that are due.
+func (d *Driver) runKeyRotation() {
+	klog.Infof("Rotating cephx keys, period %v, grace period %v", d.keyRotation.Period, d.keyRotation.GracePeriod)
+
+	listers, err := newKeyRotationListers(d.keyRotation.KubeClient, wait.NeverStop)
+	if err != nil {
+		klog.Fatalf("failed to watch the PersistentVolumes and the pods to rotate cephx keys: %v", err)
+	}
+	d.keyRotationListers = listers
+
+	go wait.Forever(func() {
+		if err := d.rotateAccessKeys(context.Background()); err != nil {
+			klog.Errorf("failed to rotate cephx keys: %v", err)
+		}
+	}, d.keyRotation.Interval)
+}
+
+func (d *Driver) rotateAccessKeys(ctx context.Context) error {
+	secrets, err := readSecretDir(d.keyRotation.SecretDir)
+	if err != nil {
+		return err
+	}
+
+	osOpts, err := options.NewOpenstackOptions(secrets)
+	if err != nil {
+		return fmt.Errorf("invalid OpenStack secrets in %s: %v", d.keyRotation.SecretDir, err)
+	}
+
+	manilaClient, err := d.manilaClientBuilder.New(osOpts)
+	if err != nil {
+		return fmt.Errorf("failed to create Manila v2 client: %v", err)
+	}
+
+	pvs, err := d.keyRotationListers.pvLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list PersistentVolumes: %v", err)
+	}
+
+	for _, pv := range pvs {
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != d.name {
+			continue
+		}
+
+		if err := d.syncAccessKey(ctx, manilaClient, pv, time.Now()); err != nil {
+			klog.Errorf("failed to rotate the cephx key of volume %s (PersistentVolume %s): %v", pv.Spec.CSI.VolumeHandle, pv.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// syncAccessKey revokes the previous access right of the volume if its grace period is over, and
+// rotates the key of the volume if due.
+func (d *Driver) syncAccessKey(ctx context.Context, manilaClient manilaclient.Interface, pv *v1.PersistentVolume, now time.Time) error {
+	shareID := pv.Spec.CSI.VolumeHandle
+
+	// Volumes referencing their share with readOnlyAccessTo use the access rights of another volume
+	if pv.Spec.CSI.VolumeAttributes["shareAccessID"] == "" {
+		return nil
+	}
+
+	share, err := manilaClient.GetShareByID(shareID)
+	if err != nil {
+		if clouderrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get share: %v", err)
+	}
+
+	if revoke := share.Metadata[accessRevokeMetadataKey]; revoke != "" && !strings.HasSuffix(revoke, " "+accessRevoked) {
+		nodes, err := d.nodesWithPreviousKey(ctx, pv)
+		if err != nil {
+			return err
+		}
+
+		pending, err := revokeAccessIfExpired(manilaClient, share, revoke, nodes, now)
+		if err != nil || pending {
+			// Only one rotation at a time
+			return err
+		}
+	}
+
+	requested := pv.Annotations[rotateAccessKeyAnnotation] == "true"
+	if !requested && !accessKeyRotationDue(share, d.keyRotation.Period, now) {
+		return nil
+	}
+
+	if err := rotateAccessKey(ctx, manilaClient, share, pv.Spec.CSI.VolumeAttributes["shareAccessID"], d.keyRotation.GracePeriod, now); err != nil {
+		return err
+	}
+
+	// The volume is staged with the previous key on the nodes already using it
+	nodes, err := d.keyRotationListers.nodesUsingVolume(pv)
+	if err != nil {
+		return err
+	}
+
+	return setPVAccessKeyRotated(ctx, d.keyRotation.KubeClient, pv.Name, nodes, now)
+}
+
+// nodesWithPreviousKey returns the nodes which may still have the volume staged with the previous key: the nodes
+// using the volume at the rotation, as long as they are seen using it at every check. A node seen not using the
+// volume had it unstaged, and stages it with the new key from then on. The nodes are tracked in the
+// previous-access-key-nodes annotation of the PV.
+func (d *Driver) nodesWithPreviousKey(ctx context.Context, pv *v1.PersistentVolume) ([]string, error) {
+	nodes, err := d.keyRotationListers.nodesUsingVolume(pv)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded, ok := pv.Annotations[previousAccessKeyNodesAnnotation]
+	if ok {
+		// Interrupted rotations don't record the nodes, all the nodes using the volume are then assumed to use the
+		// previous key
+		nodes = sets.List(sets.New(nodes...).Intersection(sets.New(strings.Split(recorded, ",")...)))
+	}
+
+	if remaining := strings.Join(nodes, ","); !ok || remaining != recorded {
+		err := patchPVAnnotations(ctx, d.keyRotation.KubeClient, pv.Name, map[string]string{previousAccessKeyNodesAnnotation: remaining})
+		if err != nil {
+			return nil, fmt.Errorf("failed to record the nodes using the previous key: %v", err)
+		}
+	}
+
+	return nodes, nil
+}
+
+// revokeAccessIfExpired revokes the previous access right recorded in revoke if its grace period
+// is over and no node uses it anymore, and returns whether it's still pending.
+func revokeAccessIfExpired(manilaClient manilaclient.Interface, share *shares.Share, revoke string, nodes []string, now time.Time) (bool, error) {
+	accessID, after, ok := strings.Cut(revoke, " ")
+	if !ok || after == accessRevoked {
+		return false, nil
+	}
+
+	revokeAt, err := time.Parse(time.RFC3339, after)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s share metadata %q: %v", accessRevokeMetadataKey, revoke, err)
+	}
+
+	if now.Before(revokeAt) {
+		return true, nil
+	}
+
+	if len(nodes) > 0 {
+		klog.V(4).Infof("not revoking access right %s of share %s yet, the volume may still be staged with it on nodes %s", accessID, share.ID, strings.Join(nodes, ", "))
+		return true, nil
+	}
+
+	if err := manilaClient.RevokeAccess(share.ID, accessID); err != nil && !clouderrors.IsNotFound(err) {
+		return true, fmt.Errorf("failed to revoke access right %s: %v", accessID, err)
+	}
+
+	_, err = manilaClient.SetShareMetadata(share.ID, shares.SetMetadataOpts{Metadata: map[string]string{
+		accessRevokeMetadataKey: fmt.Sprintf("%s %s", accessID, accessRevoked),
+	}})
+	if err != nil {
+		return true, fmt.Errorf("failed to record the revocation of access right %s: %v", accessID, err)
+	}
+
+	klog.Infof("revoked the previous access right %s of share %s", accessID, share.ID)
+
+	return false, nil
+}
+
+// accessKeyRotationDue returns whether the key of the share is older than period.
+func accessKeyRotationDue(share *shares.Share, period time.Duration, now time.Time) bool {
+	if period <= 0 {
+		return false
+	}
+
+	rotatedAt := share.CreatedAt
+	if v := share.Metadata[accessRotatedAtMetadataKey]; v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			klog.Warningf("invalid %s metadata %q of share %s, rotating its key: %v", accessRotatedAtMetadataKey, v, share.ID, err)
+			return true
+		}
+		rotatedAt = t
+	}
+
+	return now.Sub(rotatedAt) >= period
+}
+
+// rotatedAccessTo returns the cephx ID of the given generation of the access right. Manila rejects
+// a second access right for the same cephx ID, so each generation gets its own.
+func rotatedAccessTo(accessTo string, generation, nextGeneration int) string {
+	if generation > 0 {
+		accessTo = strings.TrimSuffix(accessTo, fmt.Sprintf("-r%d", generation))
+	}
+
+	return fmt.Sprintf("%s-r%d", accessTo, nextGeneration)
+}
+
+// rotateAccessKey grants a new access right to the share, waits for its key, and makes it the
+// access right of the volume. The previous access right is scheduled for revocation after gracePeriod.
+func rotateAccessKey(ctx context.Context, manilaClient manilaclient.Interface, share *shares.Share, shareAccessID string, gracePeriod time.Duration, now time.Time) error {
+	currentID := share.Metadata[accessIDMetadataKey]
+	if currentID == "" {
+		currentID = shareAccessID
+	}
+
+	var generation int
+	if v := share.Metadata[accessGenerationMetadataKey]; v != "" {
+		var err error
+		if generation, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("invalid %s share metadata %q: %v", accessGenerationMetadataKey, v, err)
+		}
+	}
+
+	rights, err := manilaClient.GetAccessRights(share.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list access rights: %v", err)
+	}
+
+	current := findAccessRight(rights, func(r *shares.AccessRight) bool { return r.ID == currentID })
+	if current == nil {
+		return fmt.Errorf("cannot find access right %s", currentID)
+	}
+
+	accessTo := rotatedAccessTo(current.AccessTo, generation, generation+1)
+
+	// Reuse the access right of an interrupted rotation
+	next := findAccessRight(rights, func(r *shares.AccessRight) bool { return r.AccessType == "cephx" && r.AccessTo == accessTo })
+	if next == nil {
+		next, err = manilaClient.GrantAccess(share.ID, shares.GrantAccessOpts{
+			AccessType:  "cephx",
+			AccessLevel: current.AccessLevel,
+			AccessTo:    accessTo,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to grant access to %s: %v", accessTo, err)
+		}
+
+		klog.V(4).Infof("granted access right %s to %s for the rotation of the cephx key of share %s", next.ID, accessTo, share.ID)
+	}
+
+	nextID := next.ID
+	ctx, cancel := context.WithTimeout(ctx, keyRotationKeyTimeout)
+	defer cancel()
+
+	err = wait.PollUntilContextCancel(ctx, accessRightPollInterval, true, func(context.Context) (bool, error) {
+		rights, err := manilaClient.GetAccessRights(share.ID)
+		if err != nil {
+			klog.V(4).Infof("failed to list access rights of share %s: %v", share.ID, err)
+			return false, nil
+		}
+
+		next = findAccessRight(rights, func(r *shares.AccessRight) bool { return r.ID == nextID })
+		if next == nil {
+			return false, fmt.Errorf("cannot find access right %s", nextID)
+		}
+		if next.State == accessRightStateError {
+			return false, fmt.Errorf("access right %s is in error state", nextID)
+		}
+
+		return next.AccessKey != "", nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to wait for the cephx key of access right %s: %v", nextID, err)
+	}
+
+	_, err = manilaClient.SetShareMetadata(share.ID, shares.SetMetadataOpts{Metadata: map[string]string{
+		accessIDMetadataKey:         nextID,
+		accessGenerationMetadataKey: strconv.Itoa(generation + 1),
+		accessRotatedAtMetadataKey:  now.UTC().Format(time.RFC3339),
+		accessRevokeMetadataKey:     fmt.Sprintf("%s %s", current.ID, now.Add(gracePeriod).UTC().Format(time.RFC3339)),
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to switch to access right %s: %v", nextID, err)
+	}
+
+	klog.Infof("rotated the cephx key of share %s: access right %s replaces %s, which is revoked after %v", share.ID, nextID, current.ID, gracePeriod)
+
+	return nil
+}
+
+func findAccessRight(rights []shares.AccessRight, match func(*shares.AccessRight) bool) *shares.AccessRight {
+	for i := range rights {
+		if match(&rights[i]) {
+			return &rights[i]
+		}
+	}
+
+	return nil
+}
+
+// setPVAccessKeyRotated records the rotation and the nodes using the previous key in the annotations of the PV,
+// clearing the request.
+func setPVAccessKeyRotated(ctx context.Context, kubeClient kubernetes.Interface, pvName string, nodes []string, now time.Time) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				rotateAccessKeyAnnotation:        nil,
+				accessKeyRotatedAtAnnotation:     now.UTC().Format(time.RFC3339),
+				previousAccessKeyNodesAnnotation: strings.Join(nodes, ","),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = kubeClient.CoreV1().PersistentVolumes().Patch(ctx, pvName, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
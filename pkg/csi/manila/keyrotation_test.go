@@ -0,0 +1,327 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+)
+
+type fakeKeyRotationClient struct {
+	manilaclient.Interface
+
+	share  shares.Share
+	rights []shares.AccessRight
+	nextID int
+}
+
+func (c *fakeKeyRotationClient) GetShareByID(shareID string) (*shares.Share, error) {
+	if shareID != c.share.ID {
+		return nil, gophercloud.ErrDefault404{}
+	}
+	share := c.share
+	share.Metadata = make(map[string]string)
+	for k, v := range c.share.Metadata {
+		share.Metadata[k] = v
+	}
+	return &share, nil
+}
+
+func (c *fakeKeyRotationClient) GetAccessRights(shareID string) ([]shares.AccessRight, error) {
+	return append([]shares.AccessRight(nil), c.rights...), nil
+}
+
+func (c *fakeKeyRotationClient) GrantAccess(shareID string, opts shares.GrantAccessOptsBuilder) (*shares.AccessRight, error) {
+	o := opts.(shares.GrantAccessOpts)
+	c.nextID++
+	r := shares.AccessRight{
+		ID:          fmt.Sprintf("access-%d", c.nextID),
+		ShareID:     shareID,
+		AccessType:  o.AccessType,
+		AccessTo:    o.AccessTo,
+		AccessLevel: o.AccessLevel,
+		AccessKey:   fmt.Sprintf("key-%d", c.nextID),
+		State:       "active",
+	}
+	c.rights = append(c.rights, r)
+	return &r, nil
+}
+
+func (c *fakeKeyRotationClient) RevokeAccess(shareID, accessID string) error {
+	for i := range c.rights {
+		if c.rights[i].ID == accessID {
+			c.rights = append(c.rights[:i], c.rights[i+1:]...)
+			return nil
+		}
+	}
+	return gophercloud.ErrDefault404{}
+}
+
+func (c *fakeKeyRotationClient) SetShareMetadata(shareID string, opts shares.SetMetadataOptsBuilder) (map[string]string, error) {
+	if c.share.Metadata == nil {
+		c.share.Metadata = make(map[string]string)
+	}
+	for k, v := range opts.(shares.SetMetadataOpts).Metadata {
+		c.share.Metadata[k] = v
+	}
+	return c.share.Metadata, nil
+}
+
+func (c *fakeKeyRotationClient) accessTos() []string {
+	var ts []string
+	for _, r := range c.rights {
+		ts = append(ts, r.AccessTo)
+	}
+	return ts
+}
+
+func TestRotatedAccessTo(t *testing.T) {
+	ts := []struct {
+		accessTo       string
+		generation     int
+		nextGeneration int
+		expected       string
+	}{
+		{"pvc-1", 0, 1, "pvc-1-r1"},
+		{"pvc-1-r1", 1, 2, "pvc-1-r2"},
+		{"client-r1", 0, 1, "client-r1-r1"},
+	}
+
+	for _, tc := range ts {
+		if accessTo := rotatedAccessTo(tc.accessTo, tc.generation, tc.nextGeneration); accessTo != tc.expected {
+			t.Errorf("rotatedAccessTo(%q, %d, %d): expected %q, got %q", tc.accessTo, tc.generation, tc.nextGeneration, tc.expected, accessTo)
+		}
+	}
+}
+
+func TestAccessKeyRotationDue(t *testing.T) {
+	now := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	ts := []struct {
+		createdAt time.Time
+		metadata  map[string]string
+		period    time.Duration
+		expected  bool
+	}{
+		{now.Add(-48 * time.Hour), nil, 0, false},
+		{now.Add(-48 * time.Hour), nil, 24 * time.Hour, true},
+		{now.Add(-time.Hour), nil, 24 * time.Hour, false},
+		{now.Add(-48 * time.Hour), map[string]string{accessRotatedAtMetadataKey: now.Add(-time.Hour).Format(time.RFC3339)}, 24 * time.Hour, false},
+		{now.Add(-48 * time.Hour), map[string]string{accessRotatedAtMetadataKey: "invalid"}, 24 * time.Hour, true},
+	}
+
+	for i, tc := range ts {
+		share := &shares.Share{ID: "share", CreatedAt: tc.createdAt, Metadata: tc.metadata}
+		if due := accessKeyRotationDue(share, tc.period, now); due != tc.expected {
+			t.Errorf("test %d: expected %t, got %t", i, tc.expected, due)
+		}
+	}
+}
+
+func TestSyncAccessKey(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv"},
+		Spec: corev1.PersistentVolumeSpec{
+			ClaimRef: &corev1.ObjectReference{Namespace: "default", Name: "claim"},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:           "manila.csi.openstack.org",
+					VolumeHandle:     "share",
+					VolumeAttributes: map[string]string{"shareID": "share", "shareAccessID": "access-0"},
+				},
+			},
+		},
+	}
+	kubeClient := fake.NewSimpleClientset(pv)
+
+	manilaClient := &fakeKeyRotationClient{
+		share: shares.Share{ID: "share", ShareProto: "CEPHFS", CreatedAt: now.Add(-48 * time.Hour)},
+		rights: []shares.AccessRight{
+			{ID: "access-0", ShareID: "share", AccessType: "cephx", AccessTo: "pvc-1", AccessLevel: "rw", AccessKey: "key-0", State: "active"},
+		},
+	}
+
+	podIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{podsByClaimIndex: podClaims})
+	newPod := func(name, node string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec: corev1.PodSpec{
+				NodeName: node,
+				Volumes: []corev1.Volume{{
+					Name:         "data",
+					VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "claim"}},
+				}},
+			},
+		}
+	}
+	pod1 := newPod("pod-1", "node-1")
+	if err := podIndexer.Add(pod1); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Driver{
+		name: "manila.csi.openstack.org",
+		keyRotation: KeyRotationOpts{
+			Period:      24 * time.Hour,
+			GracePeriod: time.Hour,
+			KubeClient:  kubeClient,
+		},
+		keyRotationListers: &keyRotationListers{podIndexer: podIndexer},
+	}
+
+	sync := func(now time.Time) {
+		t.Helper()
+		pv, err := kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pv", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.syncAccessKey(ctx, manilaClient, pv, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The key is older than the period, a new access right replaces it
+	sync(now)
+
+	if accessTos := strings.Join(manilaClient.accessTos(), ","); accessTos != "pvc-1,pvc-1-r1" {
+		t.Fatalf("expected access rights pvc-1,pvc-1-r1, got %s", accessTos)
+	}
+	md := manilaClient.share.Metadata
+	if md[accessIDMetadataKey] != "access-1" || md[accessGenerationMetadataKey] != "1" ||
+		md[accessRevokeMetadataKey] != "access-0 "+now.Add(time.Hour).Format(time.RFC3339) {
+		t.Fatalf("unexpected share metadata %v", md)
+	}
+
+	pv, err := kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pv", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pv.Annotations[accessKeyRotatedAtAnnotation] != now.Format(time.RFC3339) ||
+		pv.Annotations[previousAccessKeyNodesAnnotation] != "node-1" {
+		t.Errorf("unexpected annotations %v", pv.Annotations)
+	}
+
+	// The previous access right is kept during the grace period, even if a rotation is requested
+	pv.Annotations[rotateAccessKeyAnnotation] = "true"
+	if _, err := kubeClient.CoreV1().PersistentVolumes().Update(ctx, pv, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	sync(now.Add(30 * time.Minute))
+
+	if accessTos := strings.Join(manilaClient.accessTos(), ","); accessTos != "pvc-1,pvc-1-r1" {
+		t.Fatalf("expected access rights pvc-1,pvc-1-r1, got %s", accessTos)
+	}
+
+	// The previous access right is kept after the grace period while node-1 may still use it
+	sync(now.Add(2 * time.Hour))
+
+	if accessTos := strings.Join(manilaClient.accessTos(), ","); accessTos != "pvc-1,pvc-1-r1" {
+		t.Fatalf("expected access rights pvc-1,pvc-1-r1, got %s", accessTos)
+	}
+
+	// Once the pod on node-1 is gone, the previous access right is revoked and the requested rotation
+	// happens. The volume is then staged on node-2 with the new key only.
+	if err := podIndexer.Delete(pod1); err != nil {
+		t.Fatal(err)
+	}
+	pod2 := newPod("pod-2", "node-2")
+	if err := podIndexer.Add(pod2); err != nil {
+		t.Fatal(err)
+	}
+	sync(now.Add(150 * time.Minute))
+
+	if accessTos := strings.Join(manilaClient.accessTos(), ","); accessTos != "pvc-1-r1,pvc-1-r2" {
+		t.Fatalf("expected access rights pvc-1-r1,pvc-1-r2, got %s", accessTos)
+	}
+	md = manilaClient.share.Metadata
+	if md[accessIDMetadataKey] != "access-2" || md[accessGenerationMetadataKey] != "2" ||
+		md[accessRevokeMetadataKey] != "access-1 "+now.Add(210*time.Minute).Format(time.RFC3339) {
+		t.Fatalf("unexpected share metadata %v", md)
+	}
+
+	pv, err = kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pv", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pv.Annotations[rotateAccessKeyAnnotation]; ok {
+		t.Errorf("expected the rotation request to be cleared, got annotations %v", pv.Annotations)
+	}
+	if nodes := pv.Annotations[previousAccessKeyNodesAnnotation]; nodes != "node-2" {
+		t.Errorf("expected node-2 to use the previous key, got %q", nodes)
+	}
+
+	// A terminated pod doesn't keep the volume staged
+	pod2 = pod2.DeepCopy()
+	pod2.Status.Phase = corev1.PodSucceeded
+	if err := podIndexer.Update(pod2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing to do until the next revocation
+	sync(now.Add(4 * time.Hour))
+
+	if accessTos := strings.Join(manilaClient.accessTos(), ","); accessTos != "pvc-1-r2" {
+		t.Fatalf("expected access rights pvc-1-r2, got %s", accessTos)
+	}
+	if md := manilaClient.share.Metadata; md[accessRevokeMetadataKey] != "access-1 "+accessRevoked {
+		t.Fatalf("unexpected share metadata %v", md)
+	}
+	sync(now.Add(5 * time.Hour))
+
+	if accessTos := strings.Join(manilaClient.accessTos(), ","); accessTos != "pvc-1-r2" {
+		t.Fatalf("expected access rights pvc-1-r2, got %s", accessTos)
+	}
+}
+
+func TestSyncAccessKeyReadOnlyAccessTo(t *testing.T) {
+	manilaClient := &fakeKeyRotationClient{}
+	d := &Driver{keyRotation: KeyRotationOpts{Period: time.Nanosecond}}
+
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv", Annotations: map[string]string{rotateAccessKeyAnnotation: "true"}},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					VolumeHandle:     "share",
+					VolumeAttributes: map[string]string{"shareID": "share", "readOnlyAccessTo": "source"},
+				},
+			},
+		},
+	}
+
+	// The access rights of the source volume are not rotated with the read-only volume
+	if err := d.syncAccessKey(context.Background(), manilaClient, pv, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if len(manilaClient.rights) != 0 {
+		t.Errorf("unexpected access rights %v", manilaClient.rights)
+	}
+}
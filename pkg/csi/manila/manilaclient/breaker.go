@@ -0,0 +1,148 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manilaclient
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// maxBreakerCooldown caps the cooldown of the circuit breaker, which doubles each time a probe fails.
+const maxBreakerCooldown = 5 * time.Minute
+
+// ErrCircuitOpen is returned instead of sending requests to Manila while the circuit breaker is open.
+var ErrCircuitOpen = errors.New("Manila is unavailable: too many consecutive server errors, retry later")
+
+// CircuitBreaker stops sending requests to Manila after consecutive server errors, so that the retries of the CSI
+// sidecars don't add load to a struggling Manila control plane. Once open, requests fail fast with ErrCircuitOpen for
+// the cooldown, after which a single probe request is let through: the breaker closes if it succeeds, and opens again
+// with twice the cooldown otherwise.
+type CircuitBreaker struct {
+	threshold    int
+	baseCooldown time.Duration
+
+	mu       sync.Mutex
+	failures int
+	cooldown time.Duration
+	openedAt time.Time
+	probing  bool
+	now      func() time.Time
+}
+
+// NewCircuitBreaker returns a circuit breaker opening after threshold consecutive server errors for cooldown.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold:    threshold,
+		baseCooldown: cooldown,
+		cooldown:     cooldown,
+		now:          time.Now,
+	}
+}
+
+func (cb *CircuitBreaker) open() bool {
+	return cb.failures >= cb.threshold
+}
+
+// Check returns ErrCircuitOpen while the breaker is open and its cooldown is not over.
+func (cb *CircuitBreaker) Check() error {
+	if cb == nil {
+		return nil
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.open() && cb.now().Sub(cb.openedAt) < cb.cooldown {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// allow returns whether a request can be sent, letting a single probe request through once the cooldown is over.
+func (cb *CircuitBreaker) allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if !cb.open() {
+		return nil
+	}
+	if cb.probing || cb.now().Sub(cb.openedAt) < cb.cooldown {
+		return ErrCircuitOpen
+	}
+
+	klog.V(2).Info("Manila circuit breaker cooldown is over, probing Manila")
+	cb.probing = true
+	return nil
+}
+
+// record records the result of a request.
+func (cb *CircuitBreaker) record(serverError bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	probe := cb.probing
+	cb.probing = false
+
+	if !serverError {
+		if cb.open() {
+			klog.Info("Manila recovered, closing the circuit breaker")
+		}
+		cb.failures = 0
+		cb.cooldown = cb.baseCooldown
+		return
+	}
+
+	cb.failures++
+	switch {
+	case probe:
+		cb.cooldown *= 2
+		if cb.cooldown > maxBreakerCooldown {
+			cb.cooldown = max(maxBreakerCooldown, cb.baseCooldown)
+		}
+		cb.openedAt = cb.now()
+		klog.Warningf("Manila probe failed, keeping the circuit breaker open for %v", cb.cooldown)
+	case cb.failures == cb.threshold:
+		cb.openedAt = cb.now()
+		klog.Warningf("%d consecutive Manila server errors, opening the circuit breaker for %v", cb.failures, cb.cooldown)
+	}
+}
+
+// breakerTransport sends the requests to the Manila endpoint through the circuit breaker. The requests to the other
+// services, e.g. Keystone, are not affected.
+type breakerTransport struct {
+	rt   http.RoundTripper
+	cb   *CircuitBreaker
+	host string
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host {
+		return t.rt.RoundTrip(req)
+	}
+
+	if err := t.cb.allow(); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.rt.RoundTrip(req)
+	t.cb.record(err != nil || resp.StatusCode >= http.StatusInternalServerError)
+	return resp, err
+}
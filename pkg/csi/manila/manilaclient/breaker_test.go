@@ -0,0 +1,129 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manilaclient
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	cb := NewCircuitBreaker(3, time.Minute)
+	cb.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		cb.record(true)
+	}
+	cb.record(false)
+	cb.record(true)
+	cb.record(true)
+	if err := cb.allow(); err != nil {
+		t.Fatalf("expected the breaker to be closed after a success, got %v", err)
+	}
+
+	cb.record(true)
+	if err := cb.Check(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected the breaker to open after 3 consecutive server errors, got %v", err)
+	}
+	if err := cb.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected the requests to fail fast, got %v", err)
+	}
+
+	// A single probe is let through once the cooldown is over
+	now = now.Add(time.Minute)
+	if err := cb.Check(); err != nil {
+		t.Fatalf("expected the cooldown to be over, got %v", err)
+	}
+	if err := cb.allow(); err != nil {
+		t.Fatalf("expected a probe, got %v", err)
+	}
+	if err := cb.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected a single probe, got %v", err)
+	}
+
+	// A failed probe doubles the cooldown
+	cb.record(true)
+	now = now.Add(time.Minute)
+	if err := cb.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected the cooldown to be doubled, got %v", err)
+	}
+	now = now.Add(time.Minute)
+	if err := cb.allow(); err != nil {
+		t.Fatalf("expected a probe, got %v", err)
+	}
+
+	cb.record(false)
+	if err := cb.allow(); err != nil {
+		t.Fatalf("expected the breaker to close after a successful probe, got %v", err)
+	}
+	if cb.cooldown != time.Minute {
+		t.Errorf("expected the cooldown to be reset, got %v", cb.cooldown)
+	}
+
+	var disabled *CircuitBreaker
+	if err := disabled.Check(); err != nil {
+		t.Errorf("expected a nil breaker to be closed, got %v", err)
+	}
+}
+
+func TestBreakerTransport(t *testing.T) {
+	code := http.StatusServiceUnavailable
+	manila := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+	}))
+	defer manila.Close()
+	keystone := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer keystone.Close()
+
+	endpoint, _ := url.Parse(manila.URL)
+	cb := NewCircuitBreaker(2, time.Minute)
+	c := &http.Client{Transport: &breakerTransport{rt: http.DefaultTransport, cb: cb, host: endpoint.Host}}
+
+	get := func(url string) error {
+		resp, err := c.Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	// The server errors of the other services are not counted
+	for i := 0; i < 3; i++ {
+		if err := get(keystone.URL); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := get(manila.URL); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := get(manila.URL); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected the request to fail fast, got %v", err)
+	}
+	if err := get(keystone.URL); err != nil {
+		t.Fatalf("expected the other services to be reachable, got %v", err)
+	}
+}
@@ -17,10 +17,14 @@ limitations under the License.
 package manilaclient
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
@@ -39,13 +43,93 @@ var (
 type ClientBuilder struct {
 	UserAgent          string
 	ExtraUserAgentData []string
+
+	// CircuitBreaker is shared by the clients, optional
+	CircuitBreaker *CircuitBreaker
+
+	// ClientTTL is how long a client is reused for the same credentials, optional. The clients are not cached if 0.
+	ClientTTL time.Duration
+
+	mu      sync.Mutex
+	clients map[[sha256.Size]byte]cachedClient
+	now     func() time.Time
+}
+
+type cachedClient struct {
+	client  Interface
+	expires time.Time
 }
 
 func (cb *ClientBuilder) New(o *client.AuthOpts) (Interface, error) {
-	return New(o, cb.UserAgent, cb.ExtraUserAgentData)
+	// Fail fast without authenticating while Manila is unavailable
+	if err := cb.CircuitBreaker.Check(); err != nil {
+		return nil, err
+	}
+
+	if cb.ClientTTL <= 0 {
+		return newClient(o, cb.UserAgent, cb.ExtraUserAgentData, cb.CircuitBreaker)
+	}
+
+	key := authOptsKey(o)
+	if c, ok := cb.cachedClient(key); ok {
+		return c, nil
+	}
+
+	c, err := newClient(o, cb.UserAgent, cb.ExtraUserAgentData, cb.CircuitBreaker)
+	if err != nil {
+		return nil, err
+	}
+	cb.cacheClient(key, c)
+
+	return c, nil
+}
+
+// authOptsKey identifies the credentials, the cloud and the region of the client without keeping the secrets
+// in memory.
+func authOptsKey(o *client.AuthOpts) [sha256.Size]byte {
+	return sha256.Sum256([]byte(fmt.Sprintf("%#v", *o)))
+}
+
+func (cb *ClientBuilder) timeNow() time.Time {
+	if cb.now != nil {
+		return cb.now()
+	}
+	return time.Now()
+}
+
+func (cb *ClientBuilder) cachedClient(key [sha256.Size]byte) (Interface, bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	c, ok := cb.clients[key]
+	if !ok || !cb.timeNow().Before(c.expires) {
+		return nil, false
+	}
+	return c.client, true
+}
+
+// cacheClient caches the client for ClientTTL, and evicts the expired clients.
+func (cb *ClientBuilder) cacheClient(key [sha256.Size]byte, c Interface) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	now := cb.timeNow()
+	if cb.clients == nil {
+		cb.clients = make(map[[sha256.Size]byte]cachedClient)
+	}
+	for k, cached := range cb.clients {
+		if !now.Before(cached.expires) {
+			delete(cb.clients, k)
+		}
+	}
+	cb.clients[key] = cachedClient{client: c, expires: now.Add(cb.ClientTTL)}
 }
 
 func New(o *client.AuthOpts, userAgent string, extraUserAgentData []string) (*Client, error) {
+	return newClient(o, userAgent, extraUserAgentData, nil)
+}
+
+func newClient(o *client.AuthOpts, userAgent string, extraUserAgentData []string, breaker *CircuitBreaker) (*Client, error) {
 	// Authenticate and create Manila v2 client
 	provider, err := client.NewOpenStackClient(o, userAgent, extraUserAgentData...)
 	if err != nil {
@@ -60,14 +144,29 @@ func New(o *client.AuthOpts, userAgent string, extraUserAgentData []string) (*Cl
 		return nil, fmt.Errorf("failed to create Manila v2 client: %v", err)
 	}
 
+	if breaker != nil {
+		endpoint, err := url.Parse(client.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse Manila endpoint %s: %v", client.Endpoint, err)
+		}
+		provider.HTTPClient.Transport = &breakerTransport{rt: provider.HTTPClient.Transport, cb: breaker, host: endpoint.Host}
+	}
+
 	// Check client's and server's versions for compatibility
 
 	client.Microversion = minimumManilaVersion
 	if err = validateManilaClient(client); err != nil {
-		return nil, fmt.Errorf("Manila v2 client validation failed: %v", err)
+		return nil, fmt.Errorf("Manila v2 client validation failed: %w", err)
+	}
+
+	keyManager := func() (*gophercloud.ServiceClient, error) {
+		return openstack.NewKeyManagerV1(provider, gophercloud.EndpointOpts{
+			Region:       o.Region,
+			Availability: o.EndpointType,
+		})
 	}
 
-	return &Client{c: client}, nil
+	return &Client{c: client, keyManager: keyManager}, nil
 }
 
 func splitManilaMicroversion(microversion string) (major, minor int) {
@@ -100,7 +199,7 @@ func compareManilaVersionsLessThan(a, b string) bool {
 func validateManilaClient(c *gophercloud.ServiceClient) error {
 	serverVersion, err := apiversions.Get(c, "v2").Extract()
 	if err != nil {
-		return fmt.Errorf("failed to get Manila v2 API microversions: %v", err)
+		return fmt.Errorf("failed to get Manila v2 API microversions: %w", err)
 	}
 
 	if err = validateManilaMicroversion(serverVersion.MinVersion); err != nil {
@@ -0,0 +1,58 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manilaclient
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/cloud-provider-openstack/pkg/client"
+)
+
+func TestClientCache(t *testing.T) {
+	now := time.Now()
+	cb := &ClientBuilder{ClientTTL: time.Minute, now: func() time.Time { return now }}
+
+	regionOne := &client.AuthOpts{AuthURL: "https://keystone", Region: "RegionOne", Username: "user", Password: "secret"}
+	regionTwo := &client.AuthOpts{AuthURL: "https://keystone", Region: "RegionTwo", Username: "user", Password: "secret"}
+	c := &Client{}
+
+	if authOptsKey(regionOne) == authOptsKey(regionTwo) {
+		t.Errorf("expected different keys for the clients of different regions")
+	}
+	if authOptsKey(regionOne) != authOptsKey(&client.AuthOpts{AuthURL: "https://keystone", Region: "RegionOne", Username: "user", Password: "secret"}) {
+		t.Errorf("expected the same key for the same credentials")
+	}
+
+	cb.cacheClient(authOptsKey(regionOne), c)
+	if cached, ok := cb.cachedClient(authOptsKey(regionOne)); !ok || cached != c {
+		t.Errorf("expected the client of RegionOne to be cached")
+	}
+	if _, ok := cb.cachedClient(authOptsKey(regionTwo)); ok {
+		t.Errorf("expected no client for RegionTwo")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := cb.cachedClient(authOptsKey(regionOne)); ok {
+		t.Errorf("expected the client of RegionOne to be expired")
+	}
+
+	cb.cacheClient(authOptsKey(regionTwo), c)
+	if len(cb.clients) != 1 {
+		t.Errorf("expected the expired client to be evicted, got %d clients", len(cb.clients))
+	}
+}
@@ -17,89 +17,305 @@ limitations under the License.
 package manilaclient
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/keymanager/v1/containers"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/messages"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/replicas"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/schedulerstats"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/securityservices"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/services"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/sharenetworks"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/sharetypes"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/snapshots"
 	shares_utils "github.com/gophercloud/utils/openstack/sharedfilesystems/v2/shares"
 	sharetypes_utils "github.com/gophercloud/utils/openstack/sharedfilesystems/v2/sharetypes"
 	snapshots_utils "github.com/gophercloud/utils/openstack/sharedfilesystems/v2/snapshots"
+
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
 )
 
+// replicasManilaVersion is the microversion in which the share replicas API is no longer experimental
+const replicasManilaVersion = "2.56"
+
+// shareNetworkSubnetsManilaVersion is the microversion in which the share networks list their subnets
+const shareNetworkSubnetsManilaVersion = "2.51"
+
+// schedulerHintsManilaVersion is the microversion in which new shares accept scheduler hints
+const schedulerHintsManilaVersion = "2.65"
+
+// encryptionManilaVersion is the microversion in which new shares accept an encryption key reference
+const encryptionManilaVersion = "2.90"
+
+// shareServiceBinary is the binary of the Manila share services, i.e. of the share backends
+const shareServiceBinary = "manila-share"
+
+// poolsListOpts filters the scheduler pools by share type. The filters of schedulerstats.ListDetailOpts
+// lack query tags and are not sent.
+type poolsListOpts struct {
+	ShareType string `q:"share_type"`
+}
+
+func (opts poolsListOpts) ToPoolsListQuery() (string, error) {
+	q, err := gophercloud.BuildQueryString(opts)
+	return q.String(), err
+}
+
+// servicesListOpts filters the services by binary, for the same reason as poolsListOpts.
+type servicesListOpts struct {
+	Binary string `q:"binary"`
+}
+
+func (opts servicesListOpts) ToServiceListQuery() (string, error) {
+	q, err := gophercloud.BuildQueryString(opts)
+	return q.String(), err
+}
+
+// ShareCreateOpts are the options of a new share which shares.CreateOpts lacks.
+type ShareCreateOpts struct {
+	shares.CreateOpts
+
+	// ShareGroupID is the share group the share is created in
+	ShareGroupID string
+
+	// SchedulerHints place the share relative to existing shares
+	SchedulerHints SchedulerHints
+
+	// EncryptionKeyRef is the UUID of the Barbican secret the share is encrypted with
+	EncryptionKeyRef string
+}
+
+// SchedulerHints are the IDs of the shares whose backend a new share is placed on, or not placed on.
+type SchedulerHints struct {
+	SameHost      []string
+	DifferentHost []string
+}
+
+func (opts ShareCreateOpts) ToShareCreateMap() (map[string]interface{}, error) {
+	b, err := opts.CreateOpts.ToShareCreateMap()
+	if err != nil {
+		return nil, err
+	}
+
+	share := b["share"].(map[string]interface{})
+
+	if opts.ShareGroupID != "" {
+		share["share_group_id"] = opts.ShareGroupID
+	}
+
+	hints := make(map[string]interface{})
+	if len(opts.SchedulerHints.SameHost) > 0 {
+		hints["same_host"] = strings.Join(opts.SchedulerHints.SameHost, ",")
+	}
+	if len(opts.SchedulerHints.DifferentHost) > 0 {
+		hints["different_host"] = strings.Join(opts.SchedulerHints.DifferentHost, ",")
+	}
+	if len(hints) > 0 {
+		share["scheduler_hints"] = hints
+	}
+
+	if opts.EncryptionKeyRef != "" {
+		share["encryption_key_ref"] = opts.EncryptionKeyRef
+	}
+
+	return b, nil
+}
+
+// microversion returns the microversion supporting the options, or an empty string if the
+// default one does.
+func (opts ShareCreateOpts) microversion() string {
+	switch {
+	case opts.EncryptionKeyRef != "":
+		return encryptionManilaVersion
+	case len(opts.SchedulerHints.SameHost) > 0 || len(opts.SchedulerHints.DifferentHost) > 0:
+		return schedulerHintsManilaVersion
+	case opts.ShareGroupID != "":
+		return shareGroupsManilaVersion
+	default:
+		return ""
+	}
+}
+
 type Client struct {
 	c *gophercloud.ServiceClient
+
+	// keyManager is the Barbican client used to resolve the secret containers
+	keyManager func() (*gophercloud.ServiceClient, error)
+}
+
+// replicasClient returns a copy of the service client requesting the microversion of the share replicas API.
+func (c Client) replicasClient() *gophercloud.ServiceClient {
+	rc := *c.c
+	rc.Microversion = replicasManilaVersion
+	return &rc
 }
 
 func (c Client) GetShareByID(shareID string) (*shares.Share, error) {
-	return shares.Get(c.c, shareID).Extract()
+	mc := metrics.NewMetricContext("share", "get")
+	share, err := shares.Get(c.c, shareID).Extract()
+	return share, mc.ObserveRequest(err)
 }
 
 func (c Client) GetShareByName(shareName string) (*shares.Share, error) {
+	mc := metrics.NewMetricContext("share", "list")
 	shareID, err := shares_utils.IDFromName(c.c, shareName)
-	if err != nil {
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	return c.GetShareByID(shareID)
+}
+
+func (c Client) ListShares(opts shares.ListOptsBuilder) ([]shares.Share, error) {
+	mc := metrics.NewMetricContext("share", "list")
+	allPages, err := shares.ListDetail(c.c, opts).AllPages()
+	if mc.ObserveRequest(err) != nil {
 		return nil, err
 	}
 
-	return shares.Get(c.c, shareID).Extract()
+	return shares.ExtractShares(allPages)
 }
 
 func (c Client) CreateShare(opts shares.CreateOptsBuilder) (*shares.Share, error) {
-	return shares.Create(c.c, opts).Extract()
+	sc := c.c
+	if o, ok := opts.(ShareCreateOpts); ok && o.microversion() != "" {
+		vc := *c.c
+		vc.Microversion = o.microversion()
+		sc = &vc
+	}
+
+	mc := metrics.NewMetricContext("share", "create")
+	share, err := shares.Create(sc, opts).Extract()
+	return share, mc.ObserveRequest(err)
 }
 
 func (c Client) DeleteShare(shareID string) error {
-	return shares.Delete(c.c, shareID).ExtractErr()
+	mc := metrics.NewMetricContext("share", "delete")
+	err := shares.Delete(c.c, shareID).ExtractErr()
+	return mc.ObserveRequest(err)
+}
+
+func (c Client) RenameShare(shareID, name string) error {
+	mc := metrics.NewMetricContext("share", "update")
+	_, err := shares.Update(c.c, shareID, shares.UpdateOpts{DisplayName: &name}).Extract()
+	return mc.ObserveRequest(err)
 }
 
 func (c Client) ExtendShare(shareID string, opts shares.ExtendOptsBuilder) error {
-	return shares.Extend(c.c, shareID, opts).ExtractErr()
+	mc := metrics.NewMetricContext("share", "extend")
+	err := shares.Extend(c.c, shareID, opts).ExtractErr()
+	return mc.ObserveRequest(err)
 }
 
 func (c Client) GetExportLocations(shareID string) ([]shares.ExportLocation, error) {
-	return shares.ListExportLocations(c.c, shareID).Extract()
+	mc := metrics.NewMetricContext("share_export_location", "list")
+	exportLocations, err := shares.ListExportLocations(c.c, shareID).Extract()
+	return exportLocations, mc.ObserveRequest(err)
 }
 
 func (c Client) SetShareMetadata(shareID string, opts shares.SetMetadataOptsBuilder) (map[string]string, error) {
-	return shares.SetMetadata(c.c, shareID, opts).Extract()
+	mc := metrics.NewMetricContext("share_metadata", "set")
+	metadata, err := shares.SetMetadata(c.c, shareID, opts).Extract()
+	return metadata, mc.ObserveRequest(err)
 }
 
 func (c Client) GetAccessRights(shareID string) ([]shares.AccessRight, error) {
-	return shares.ListAccessRights(c.c, shareID).Extract()
+	mc := metrics.NewMetricContext("share_access", "list")
+	accessRights, err := shares.ListAccessRights(c.c, shareID).Extract()
+	return accessRights, mc.ObserveRequest(err)
 }
 
 func (c Client) GrantAccess(shareID string, opts shares.GrantAccessOptsBuilder) (*shares.AccessRight, error) {
-	return shares.GrantAccess(c.c, shareID, opts).Extract()
+	mc := metrics.NewMetricContext("share_access", "grant")
+	accessRight, err := shares.GrantAccess(c.c, shareID, opts).Extract()
+	return accessRight, mc.ObserveRequest(err)
+}
+
+func (c Client) RevokeAccess(shareID, accessID string) error {
+	mc := metrics.NewMetricContext("share_access", "revoke")
+	err := shares.RevokeAccess(c.c, shareID, shares.RevokeAccessOpts{AccessID: accessID}).ExtractErr()
+	return mc.ObserveRequest(err)
+}
+
+func (c Client) GetShareReplicas(shareID string) ([]replicas.Replica, error) {
+	mc := metrics.NewMetricContext("share_replica", "list")
+	allPages, err := replicas.ListDetail(c.replicasClient(), replicas.ListOpts{ShareID: shareID}).AllPages()
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	return replicas.ExtractReplicas(allPages)
+}
+
+func (c Client) CreateShareReplica(opts replicas.CreateOptsBuilder) (*replicas.Replica, error) {
+	mc := metrics.NewMetricContext("share_replica", "create")
+	replica, err := replicas.Create(c.replicasClient(), opts).Extract()
+	return replica, mc.ObserveRequest(err)
+}
+
+func (c Client) DeleteShareReplica(replicaID string) error {
+	mc := metrics.NewMetricContext("share_replica", "delete")
+	err := replicas.Delete(c.replicasClient(), replicaID).ExtractErr()
+	return mc.ObserveRequest(err)
+}
+
+func (c Client) PromoteShareReplica(replicaID string) error {
+	mc := metrics.NewMetricContext("share_replica", "promote")
+	err := replicas.Promote(c.replicasClient(), replicaID, replicas.PromoteOpts{}).ExtractErr()
+	return mc.ObserveRequest(err)
 }
 
 func (c Client) GetSnapshotByID(snapID string) (*snapshots.Snapshot, error) {
-	return snapshots.Get(c.c, snapID).Extract()
+	mc := metrics.NewMetricContext("snapshot", "get")
+	snapshot, err := snapshots.Get(c.c, snapID).Extract()
+	return snapshot, mc.ObserveRequest(err)
 }
 
 func (c Client) GetSnapshotByName(snapName string) (*snapshots.Snapshot, error) {
+	mc := metrics.NewMetricContext("snapshot", "list")
 	snapID, err := snapshots_utils.IDFromName(c.c, snapName)
-	if err != nil {
+	if mc.ObserveRequest(err) != nil {
 		return nil, err
 	}
 
-	return snapshots.Get(c.c, snapID).Extract()
+	return c.GetSnapshotByID(snapID)
+}
+
+func (c Client) ListSnapshots(opts snapshots.ListOptsBuilder) ([]snapshots.Snapshot, error) {
+	mc := metrics.NewMetricContext("snapshot", "list")
+	allPages, err := snapshots.ListDetail(c.c, opts).AllPages()
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	return snapshots.ExtractSnapshots(allPages)
 }
 
 func (c Client) CreateSnapshot(opts snapshots.CreateOptsBuilder) (*snapshots.Snapshot, error) {
-	return snapshots.Create(c.c, opts).Extract()
+	mc := metrics.NewMetricContext("snapshot", "create")
+	snapshot, err := snapshots.Create(c.c, opts).Extract()
+	return snapshot, mc.ObserveRequest(err)
 }
 
 func (c Client) DeleteSnapshot(snapID string) error {
-	return snapshots.Delete(c.c, snapID).ExtractErr()
+	mc := metrics.NewMetricContext("snapshot", "delete")
+	err := snapshots.Delete(c.c, snapID).ExtractErr()
+	return mc.ObserveRequest(err)
 }
 
 func (c Client) GetExtraSpecs(shareTypeID string) (sharetypes.ExtraSpecs, error) {
-	return sharetypes.GetExtraSpecs(c.c, shareTypeID).Extract()
+	mc := metrics.NewMetricContext("share_type_extra_specs", "get")
+	extraSpecs, err := sharetypes.GetExtraSpecs(c.c, shareTypeID).Extract()
+	return extraSpecs, mc.ObserveRequest(err)
 }
 
 func (c Client) GetShareTypes() ([]sharetypes.ShareType, error) {
+	mc := metrics.NewMetricContext("share_type", "list")
 	allPages, err := sharetypes.List(c.c, sharetypes.ListOpts{}).AllPages()
-	if err != nil {
+	if mc.ObserveRequest(err) != nil {
 		return nil, err
 	}
 
@@ -107,14 +323,137 @@ func (c Client) GetShareTypes() ([]sharetypes.ShareType, error) {
 }
 
 func (c Client) GetShareTypeIDFromName(shareTypeName string) (string, error) {
-	return sharetypes_utils.IDFromName(c.c, shareTypeName)
+	mc := metrics.NewMetricContext("share_type", "list")
+	shareTypeID, err := sharetypes_utils.IDFromName(c.c, shareTypeName)
+	return shareTypeID, mc.ObserveRequest(err)
+}
+
+func (c Client) GetSecurityService(securityServiceID string) (*securityservices.SecurityService, error) {
+	mc := metrics.NewMetricContext("security_service", "get")
+	securityService, err := securityservices.Get(c.c, securityServiceID).Extract()
+	return securityService, mc.ObserveRequest(err)
+}
+
+func (c Client) GetShareNetwork(shareNetworkID string) (*ShareNetwork, error) {
+	sc := *c.c
+	sc.Microversion = shareNetworkSubnetsManilaVersion
+
+	mc := metrics.NewMetricContext("share_network", "get")
+	r := sharenetworks.Get(&sc, shareNetworkID)
+	sn, err := r.Extract()
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	var s struct {
+		ShareNetwork struct {
+			Subnets []struct {
+				AvailabilityZone *string `json:"availability_zone"`
+			} `json:"share_network_subnets"`
+		} `json:"share_network"`
+	}
+	if err := r.ExtractInto(&s); err != nil {
+		return nil, err
+	}
+
+	zones := make([]string, 0, len(s.ShareNetwork.Subnets))
+	for _, subnet := range s.ShareNetwork.Subnets {
+		if subnet.AvailabilityZone == nil {
+			zones = append(zones, "")
+		} else {
+			zones = append(zones, *subnet.AvailabilityZone)
+		}
+	}
+
+	return &ShareNetwork{ShareNetwork: *sn, AvailabilityZones: zones}, nil
+}
+
+func (c Client) GetShareNetworkIDFromName(shareNetworkName string) (string, error) {
+	mc := metrics.NewMetricContext("share_network", "list")
+	allPages, err := sharenetworks.ListDetail(c.c, sharenetworks.ListOpts{Name: shareNetworkName}).AllPages()
+	if mc.ObserveRequest(err) != nil {
+		return "", err
+	}
+
+	sns, err := sharenetworks.ExtractShareNetworks(allPages)
+	if err != nil {
+		return "", err
+	}
+
+	// The name filter of older Manila releases is a substring match
+	var ids []string
+	for i := range sns {
+		if sns[i].Name == shareNetworkName {
+			ids = append(ids, sns[i].ID)
+		}
+	}
+
+	switch len(ids) {
+	case 0:
+		return "", gophercloud.ErrResourceNotFound{Name: shareNetworkName, ResourceType: "share network"}
+	case 1:
+		return ids[0], nil
+	default:
+		return "", gophercloud.ErrMultipleResourcesFound{Name: shareNetworkName, Count: len(ids), ResourceType: "share network"}
+	}
+}
+
+func (c Client) GetShareNetworkSecurityServices(shareNetworkID string) ([]securityservices.SecurityService, error) {
+	mc := metrics.NewMetricContext("security_service", "list")
+	allPages, err := securityservices.List(c.c, securityservices.ListOpts{ShareNetworkID: shareNetworkID}).AllPages()
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	return securityservices.ExtractSecurityServices(allPages)
+}
+
+func (c Client) AddShareNetworkSecurityService(shareNetworkID, securityServiceID string) error {
+	mc := metrics.NewMetricContext("share_network_security_service", "add")
+	_, err := sharenetworks.AddSecurityService(c.c, shareNetworkID, sharenetworks.AddSecurityServiceOpts{SecurityServiceID: securityServiceID}).Extract()
+	return mc.ObserveRequest(err)
+}
+
+func (c Client) GetPools(shareType string) ([]schedulerstats.Pool, error) {
+	mc := metrics.NewMetricContext("scheduler_pool", "list")
+	allPages, err := schedulerstats.ListDetail(c.c, poolsListOpts{ShareType: shareType}).AllPages()
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	return schedulerstats.ExtractPools(allPages)
+}
+
+func (c Client) GetShareServices() ([]services.Service, error) {
+	mc := metrics.NewMetricContext("share_service", "list")
+	allPages, err := services.List(c.c, servicesListOpts{Binary: shareServiceBinary}).AllPages()
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	return services.ExtractServices(allPages)
 }
 
 func (c Client) GetUserMessages(opts messages.ListOptsBuilder) ([]messages.Message, error) {
+	mc := metrics.NewMetricContext("user_message", "list")
 	allPages, err := messages.List(c.c, opts).AllPages()
-	if err != nil {
+	if mc.ObserveRequest(err) != nil {
 		return nil, err
 	}
 
 	return messages.ExtractMessages(allPages)
 }
+
+func (c Client) GetSecretContainer(containerID string) (*containers.Container, error) {
+	if c.keyManager == nil {
+		return nil, fmt.Errorf("key manager client is not available")
+	}
+	km, err := c.keyManager()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key manager client: %v", err)
+	}
+
+	mc := metrics.NewMetricContext("secret_container", "get")
+	container, err := containers.Get(km, containerID).Extract()
+	return container, mc.ObserveRequest(err)
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manilaclient
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	fake "github.com/gophercloud/gophercloud/testhelper/client"
+	"k8s.io/component-base/metrics/testutil"
+
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+)
+
+func TestShareCreateOpts(t *testing.T) {
+	for _, tc := range []struct {
+		opts         ShareCreateOpts
+		expected     map[string]interface{}
+		microversion string
+	}{
+		{
+			opts:     ShareCreateOpts{CreateOpts: shares.CreateOpts{ShareProto: "CEPHFS", Size: 1}},
+			expected: map[string]interface{}{"share_proto": "CEPHFS", "size": float64(1)},
+		},
+		{
+			opts:         ShareCreateOpts{CreateOpts: shares.CreateOpts{ShareProto: "CEPHFS", Size: 1}, ShareGroupID: "group-1"},
+			expected:     map[string]interface{}{"share_proto": "CEPHFS", "size": float64(1), "share_group_id": "group-1"},
+			microversion: shareGroupsManilaVersion,
+		},
+		{
+			opts: ShareCreateOpts{
+				CreateOpts:     shares.CreateOpts{ShareProto: "NFS", Size: 2},
+				ShareGroupID:   "group-1",
+				SchedulerHints: SchedulerHints{SameHost: []string{"a", "b"}, DifferentHost: []string{"c"}},
+			},
+			expected: map[string]interface{}{"share_proto": "NFS", "size": float64(2), "share_group_id": "group-1", "scheduler_hints": map[string]interface{}{
+				"same_host":      "a,b",
+				"different_host": "c",
+			}},
+			microversion: schedulerHintsManilaVersion,
+		},
+		{
+			opts: ShareCreateOpts{
+				CreateOpts:       shares.CreateOpts{ShareProto: "NFS", Size: 2},
+				SchedulerHints:   SchedulerHints{SameHost: []string{"a"}},
+				EncryptionKeyRef: "2c1ea1b6-84a4-4c73-9d8b-6b3d0c1f0e7a",
+			},
+			expected: map[string]interface{}{"share_proto": "NFS", "size": float64(2), "encryption_key_ref": "2c1ea1b6-84a4-4c73-9d8b-6b3d0c1f0e7a", "scheduler_hints": map[string]interface{}{
+				"same_host": "a",
+			}},
+			microversion: encryptionManilaVersion,
+		},
+	} {
+		b, err := tc.opts.ToShareCreateMap()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if share := b["share"]; !reflect.DeepEqual(share, tc.expected) {
+			t.Errorf("expected share create map %v, got %v", tc.expected, share)
+		}
+		if v := tc.opts.microversion(); v != tc.microversion {
+			t.Errorf("expected microversion %q, got %q", tc.microversion, v)
+		}
+	}
+}
+
+func TestClientMetrics(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/shares/share-1", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	th.Mux.HandleFunc("/shares/share-2", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	metrics.RegisterMetrics("manila-csi")
+	total := func() float64 {
+		v, err := testutil.GetCounterMetricValue(metrics.APIRequestMetrics.Total.WithLabelValues("share_delete"))
+		if err != nil {
+			t.Fatalf("failed to get the requests total: %v", err)
+		}
+		return v
+	}
+	errors := func() float64 {
+		v, err := testutil.GetCounterMetricValue(metrics.APIRequestMetrics.Errors.WithLabelValues("share_delete"))
+		if err != nil {
+			t.Fatalf("failed to get the request errors total: %v", err)
+		}
+		return v
+	}
+	totalBefore, errorsBefore := total(), errors()
+
+	c := Client{c: fake.ServiceClient()}
+	if err := c.DeleteShare("share-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.DeleteShare("share-2"); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	if v := total() - totalBefore; v != 2 {
+		t.Errorf("expected 2 share_delete requests, got %v", v)
+	}
+	if v := errors() - errorsBefore; v != 1 {
+		t.Errorf("expected 1 share_delete request error, got %v", v)
+	}
+}
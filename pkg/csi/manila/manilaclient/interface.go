@@ -17,7 +17,13 @@ limitations under the License.
 package manilaclient
 
 import (
+	"github.com/gophercloud/gophercloud/openstack/keymanager/v1/containers"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/messages"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/replicas"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/schedulerstats"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/securityservices"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/services"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/sharenetworks"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/sharetypes"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/snapshots"
@@ -27,8 +33,10 @@ import (
 type Interface interface {
 	GetShareByID(shareID string) (*shares.Share, error)
 	GetShareByName(shareName string) (*shares.Share, error)
+	ListShares(opts shares.ListOptsBuilder) ([]shares.Share, error)
 	CreateShare(opts shares.CreateOptsBuilder) (*shares.Share, error)
 	DeleteShare(shareID string) error
+	RenameShare(shareID, name string) error
 	ExtendShare(shareID string, opts shares.ExtendOptsBuilder) error
 
 	GetExportLocations(shareID string) ([]shares.ExportLocation, error)
@@ -37,9 +45,16 @@ type Interface interface {
 
 	GetAccessRights(shareID string) ([]shares.AccessRight, error)
 	GrantAccess(shareID string, opts shares.GrantAccessOptsBuilder) (*shares.AccessRight, error)
+	RevokeAccess(shareID, accessID string) error
+
+	GetShareReplicas(shareID string) ([]replicas.Replica, error)
+	CreateShareReplica(opts replicas.CreateOptsBuilder) (*replicas.Replica, error)
+	DeleteShareReplica(replicaID string) error
+	PromoteShareReplica(replicaID string) error
 
 	GetSnapshotByID(snapID string) (*snapshots.Snapshot, error)
 	GetSnapshotByName(snapName string) (*snapshots.Snapshot, error)
+	ListSnapshots(opts snapshots.ListOptsBuilder) ([]snapshots.Snapshot, error)
 	CreateSnapshot(opts snapshots.CreateOptsBuilder) (*snapshots.Snapshot, error)
 	DeleteSnapshot(snapID string) error
 
@@ -47,7 +62,38 @@ type Interface interface {
 	GetShareTypes() ([]sharetypes.ShareType, error)
 	GetShareTypeIDFromName(shareTypeName string) (string, error)
 
+	GetSecurityService(securityServiceID string) (*securityservices.SecurityService, error)
+	GetShareNetworkSecurityServices(shareNetworkID string) ([]securityservices.SecurityService, error)
+	AddShareNetworkSecurityService(shareNetworkID, securityServiceID string) error
+
+	GetShareNetwork(shareNetworkID string) (*ShareNetwork, error)
+	GetShareNetworkIDFromName(shareNetworkName string) (string, error)
+
+	GetPools(shareType string) ([]schedulerstats.Pool, error)
+	GetShareServices() ([]services.Service, error)
+
 	GetUserMessages(opts messages.ListOptsBuilder) ([]messages.Message, error)
+
+	GetShareGroupID(shareID string) (string, error)
+	GetShareGroupShares(shareGroupID string) ([]shares.Share, error)
+	CreateShareGroupSnapshot(opts ShareGroupSnapshotCreateOpts) (*ShareGroupSnapshot, error)
+	GetShareGroupSnapshot(snapshotID string) (*ShareGroupSnapshot, error)
+	GetShareGroupSnapshotByName(name string) (*ShareGroupSnapshot, error)
+	DeleteShareGroupSnapshot(snapshotID string) error
+	CreateShareGroup(opts ShareGroupCreateOpts) (*ShareGroup, error)
+	GetShareGroupByName(name string) (*ShareGroup, error)
+	GetShareGroupMemberShareID(shareGroupID, memberID string) (string, error)
+
+	GetSecretContainer(containerID string) (*containers.Container, error)
+}
+
+// ShareNetwork is a share network along with the availability zones of its subnets.
+type ShareNetwork struct {
+	sharenetworks.ShareNetwork
+
+	// AvailabilityZones of the subnets of the share network. An empty zone is
+	// the default subnet, which serves all the availability zones.
+	AvailabilityZones []string
 }
 
 type Builder interface {
@@ -0,0 +1,287 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manilaclient
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+)
+
+// shareGroupsManilaVersion is the microversion in which the share groups API is no longer experimental. gophercloud
+// lacks the share groups API, its requests are built here.
+const shareGroupsManilaVersion = "2.55"
+
+// ShareGroupSnapshot is a snapshot of all the shares of a share group, taken consistently.
+type ShareGroupSnapshot struct {
+	ID           string
+	Name         string
+	Description  string
+	Status       string
+	ShareGroupID string
+	CreatedAt    time.Time
+	Members      []ShareGroupSnapshotMember
+}
+
+// ShareGroupSnapshotMember is the snapshot of a share within a share group snapshot.
+type ShareGroupSnapshotMember struct {
+	ID      string `json:"id"`
+	ShareID string `json:"share_id"`
+	Size    int    `json:"size"`
+}
+
+func (s *ShareGroupSnapshot) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		ID           string                          `json:"id"`
+		Name         string                          `json:"name"`
+		Description  string                          `json:"description"`
+		Status       string                          `json:"status"`
+		ShareGroupID string                          `json:"share_group_id"`
+		CreatedAt    gophercloud.JSONRFC3339MilliNoZ `json:"created_at"`
+		Members      []ShareGroupSnapshotMember      `json:"members"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	*s = ShareGroupSnapshot{
+		ID:           raw.ID,
+		Name:         raw.Name,
+		Description:  raw.Description,
+		Status:       raw.Status,
+		ShareGroupID: raw.ShareGroupID,
+		CreatedAt:    time.Time(raw.CreatedAt),
+		Members:      raw.Members,
+	}
+
+	return nil
+}
+
+// ShareGroupSnapshotCreateOpts are the options of a new share group snapshot.
+type ShareGroupSnapshotCreateOpts struct {
+	ShareGroupID string `json:"share_group_id"`
+	Name         string `json:"name,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+// ShareGroup is a group of shares, which are snapshotted together.
+type ShareGroup struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// ShareGroupCreateOpts are the options of a new share group restored from a share group snapshot, with the share
+// types and the share network of the snapshotted share group.
+type ShareGroupCreateOpts struct {
+	Name                       string `json:"name,omitempty"`
+	Description                string `json:"description,omitempty"`
+	SourceShareGroupSnapshotID string `json:"source_share_group_snapshot_id"`
+}
+
+// shareGroupsClient returns a copy of the service client requesting the microversion of the share groups API.
+func (c Client) shareGroupsClient() *gophercloud.ServiceClient {
+	sc := *c.c
+	sc.Microversion = shareGroupsManilaVersion
+	return &sc
+}
+
+func (c Client) GetShareGroupID(shareID string) (string, error) {
+	var s struct {
+		Share struct {
+			ShareGroupID *string `json:"share_group_id"`
+		} `json:"share"`
+	}
+	mc := metrics.NewMetricContext("share", "get")
+	if err := mc.ObserveRequest(shares.Get(c.shareGroupsClient(), shareID).ExtractInto(&s)); err != nil {
+		return "", err
+	}
+
+	if s.Share.ShareGroupID == nil {
+		return "", nil
+	}
+	return *s.Share.ShareGroupID, nil
+}
+
+func (c Client) GetShareGroupShares(shareGroupID string) ([]shares.Share, error) {
+	mc := metrics.NewMetricContext("share", "list")
+	allPages, err := shares.ListDetail(c.shareGroupsClient(), shares.ListOpts{ShareGroupID: shareGroupID}).AllPages()
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	return shares.ExtractShares(allPages)
+}
+
+func (c Client) CreateShareGroupSnapshot(opts ShareGroupSnapshotCreateOpts) (*ShareGroupSnapshot, error) {
+	sc := c.shareGroupsClient()
+
+	var r struct {
+		ShareGroupSnapshot ShareGroupSnapshot `json:"share_group_snapshot"`
+	}
+	mc := metrics.NewMetricContext("share_group_snapshot", "create")
+	_, err := sc.Post(sc.ServiceURL("share-group-snapshots"), map[string]interface{}{"share_group_snapshot": opts}, &r, &gophercloud.RequestOpts{
+		OkCodes: []int{202},
+	})
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	return &r.ShareGroupSnapshot, nil
+}
+
+func (c Client) GetShareGroupSnapshot(snapshotID string) (*ShareGroupSnapshot, error) {
+	sc := c.shareGroupsClient()
+
+	var r struct {
+		ShareGroupSnapshot ShareGroupSnapshot `json:"share_group_snapshot"`
+	}
+	mc := metrics.NewMetricContext("share_group_snapshot", "get")
+	if _, err := sc.Get(sc.ServiceURL("share-group-snapshots", snapshotID), &r, nil); mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	return &r.ShareGroupSnapshot, nil
+}
+
+func (c Client) GetShareGroupSnapshotByName(name string) (*ShareGroupSnapshot, error) {
+	sc := c.shareGroupsClient()
+
+	q, err := gophercloud.BuildQueryString(struct {
+		Name string `q:"name"`
+	}{Name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	var r struct {
+		ShareGroupSnapshots []ShareGroupSnapshot `json:"share_group_snapshots"`
+	}
+	mc := metrics.NewMetricContext("share_group_snapshot", "list")
+	if _, err := sc.Get(sc.ServiceURL("share-group-snapshots", "detail")+q.String(), &r, nil); mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	// The name filter of older Manila releases is a substring match
+	var found []ShareGroupSnapshot
+	for _, s := range r.ShareGroupSnapshots {
+		if s.Name == name {
+			found = append(found, s)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, gophercloud.ErrResourceNotFound{Name: name, ResourceType: "share group snapshot"}
+	case 1:
+		return &found[0], nil
+	default:
+		return nil, gophercloud.ErrMultipleResourcesFound{Name: name, Count: len(found), ResourceType: "share group snapshot"}
+	}
+}
+
+func (c Client) DeleteShareGroupSnapshot(snapshotID string) error {
+	sc := c.shareGroupsClient()
+
+	mc := metrics.NewMetricContext("share_group_snapshot", "delete")
+	_, err := sc.Delete(sc.ServiceURL("share-group-snapshots", snapshotID), &gophercloud.RequestOpts{
+		OkCodes: []int{202},
+	})
+	return mc.ObserveRequest(err)
+}
+
+func (c Client) CreateShareGroup(opts ShareGroupCreateOpts) (*ShareGroup, error) {
+	sc := c.shareGroupsClient()
+
+	var r struct {
+		ShareGroup ShareGroup `json:"share_group"`
+	}
+	mc := metrics.NewMetricContext("share_group", "create")
+	_, err := sc.Post(sc.ServiceURL("share-groups"), map[string]interface{}{"share_group": opts}, &r, &gophercloud.RequestOpts{
+		OkCodes: []int{202},
+	})
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	return &r.ShareGroup, nil
+}
+
+func (c Client) GetShareGroupByName(name string) (*ShareGroup, error) {
+	sc := c.shareGroupsClient()
+
+	q, err := gophercloud.BuildQueryString(struct {
+		Name string `q:"name"`
+	}{Name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	var r struct {
+		ShareGroups []ShareGroup `json:"share_groups"`
+	}
+	mc := metrics.NewMetricContext("share_group", "list")
+	if _, err := sc.Get(sc.ServiceURL("share-groups", "detail")+q.String(), &r, nil); mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	// The name filter of older Manila releases is a substring match
+	var found []ShareGroup
+	for _, g := range r.ShareGroups {
+		if g.Name == name {
+			found = append(found, g)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, gophercloud.ErrResourceNotFound{Name: name, ResourceType: "share group"}
+	case 1:
+		return &found[0], nil
+	default:
+		return nil, gophercloud.ErrMultipleResourcesFound{Name: name, Count: len(found), ResourceType: "share group"}
+	}
+}
+
+func (c Client) GetShareGroupMemberShareID(shareGroupID, memberID string) (string, error) {
+	var r struct {
+		Shares []struct {
+			ID       string `json:"id"`
+			MemberID string `json:"source_share_group_snapshot_member_id"`
+		} `json:"shares"`
+	}
+	mc := metrics.NewMetricContext("share", "list")
+	allPages, err := shares.ListDetail(c.shareGroupsClient(), shares.ListOpts{ShareGroupID: shareGroupID}).AllPages()
+	if mc.ObserveRequest(err) != nil {
+		return "", err
+	}
+	if err := allPages.(shares.SharePage).ExtractInto(&r); err != nil {
+		return "", err
+	}
+
+	for _, share := range r.Shares {
+		if share.MemberID == memberID {
+			return share.ID, nil
+		}
+	}
+
+	return "", gophercloud.ErrResourceNotFound{Name: memberID, ResourceType: "share of share group snapshot member"}
+}
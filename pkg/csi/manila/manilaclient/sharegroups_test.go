@@ -0,0 +1,97 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manilaclient
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+func TestGetShareGroupSnapshotByName(t *testing.T) {
+	var microversion string
+	manila := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		microversion = r.Header.Get("X-OpenStack-Manila-API-Version")
+		if r.URL.Path != "/share-group-snapshots/detail" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"share_group_snapshots": [
+			{"id": "gs-1", "name": "snap", "status": "available", "share_group_id": "group-1", "created_at": "2024-01-02T03:04:05.000000",
+			 "members": [{"id": "m-1", "share_id": "data", "size": 2}]},
+			{"id": "gs-2", "name": "snap-2", "status": "creating", "share_group_id": "group-1", "created_at": "2024-01-02T03:04:05.000000"}
+		]}`)
+	}))
+	defer manila.Close()
+
+	c := Client{c: &gophercloud.ServiceClient{
+		ProviderClient: &gophercloud.ProviderClient{HTTPClient: http.Client{Transport: http.DefaultTransport}},
+		Endpoint:       manila.URL + "/",
+		Type:           "sharev2",
+	}}
+
+	s, err := c.GetShareGroupSnapshotByName("snap")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.ID != "gs-1" || s.ShareGroupID != "group-1" || s.CreatedAt.IsZero() || len(s.Members) != 1 || s.Members[0].ShareID != "data" || s.Members[0].Size != 2 {
+		t.Errorf("unexpected share group snapshot %+v", s)
+	}
+	if microversion != shareGroupsManilaVersion {
+		t.Errorf("expected microversion %s, got %q", shareGroupsManilaVersion, microversion)
+	}
+
+	if _, err := c.GetShareGroupSnapshotByName("sna"); err == nil {
+		t.Errorf("expected the name to match exactly")
+	}
+}
+
+func TestGetShareGroupMemberShareID(t *testing.T) {
+	manila := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/shares/detail" || r.URL.Query().Get("share_group_id") != "group-2" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"shares": [
+			{"id": "share-1", "share_group_id": "group-2", "source_share_group_snapshot_member_id": "m-1"},
+			{"id": "share-2", "share_group_id": "group-2", "source_share_group_snapshot_member_id": "m-2"}
+		]}`)
+	}))
+	defer manila.Close()
+
+	c := Client{c: &gophercloud.ServiceClient{
+		ProviderClient: &gophercloud.ProviderClient{HTTPClient: http.Client{Transport: http.DefaultTransport}},
+		Endpoint:       manila.URL + "/",
+		Type:           "sharev2",
+	}}
+
+	shareID, err := c.GetShareGroupMemberShareID("group-2", "m-2")
+	if err != nil || shareID != "share-2" {
+		t.Errorf("expected share-2, got %q, %v", shareID, err)
+	}
+
+	if _, err := c.GetShareGroupMemberShareID("group-2", "m-3"); err == nil {
+		t.Errorf("expected an error for a member without share")
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// MountOptionOverridesOpts configures the CephFS mount options of new volumes overridden by the annotations of
+// their PersistentVolumeClaims.
+type MountOptionOverridesOpts struct {
+	// Allowed are the names of the mount options which may be overridden, e.g. rasize or recover_session.
+	Allowed []string
+	// KubeClient is used to look up the PersistentVolumeClaims.
+	KubeClient kubernetes.Interface
+}
+
+// Comma-separated CephFS mount options of the annotated PersistentVolumeClaim, overriding the ones of its
+// StorageClass, e.g. "rasize=16777216,recover_session=clean"
+var mountOptionAnnotations = map[string]string{
+	"manila.csi.openstack.org/cephfs-kernel-mount-options": "cephfs-kernelMountOptions",
+	"manila.csi.openstack.org/cephfs-fuse-mount-options":   "cephfs-fuseMountOptions",
+}
+
+func mountOptionName(option string) string {
+	name, _, _ := strings.Cut(option, "=")
+	return strings.TrimSpace(name)
+}
+
+func splitMountOptions(options string) []string {
+	var res []string
+	for _, o := range strings.Split(options, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			res = append(res, o)
+		}
+	}
+	return res
+}
+
+// overrideMountOptions replaces the options of base by the ones of overrides with the same name, and appends the
+// others. Only the allowed options may be overridden.
+func overrideMountOptions(base, overrides string, allowed sets.Set[string]) (string, error) {
+	options := splitMountOptions(base)
+
+	for _, o := range splitMountOptions(overrides) {
+		name := mountOptionName(o)
+		if !allowed.Has(name) {
+			return "", fmt.Errorf("mount option %s is not allowed to be overridden, allowed options are %v", name, sets.List(allowed))
+		}
+
+		replaced := false
+		for i := range options {
+			if mountOptionName(options[i]) == name {
+				options[i] = o
+				replaced = true
+			}
+		}
+		if !replaced {
+			options = append(options, o)
+		}
+	}
+
+	return strings.Join(options, ","), nil
+}
+
+// annotationMountOptions returns the CephFS mount options of the volume context of a new volume, overridden by the
+// annotations of its PersistentVolumeClaim. The PersistentVolumeClaim is only known when the csi-provisioner runs
+// with --extra-create-metadata, otherwise the annotations are ignored.
+func (cs *controllerServer) annotationMountOptions(ctx context.Context, params map[string]string) (map[string]string, error) {
+	if len(cs.d.mountOptionOverrides.Allowed) == 0 || !strings.EqualFold(cs.d.shareProto, "CEPHFS") {
+		return nil, nil
+	}
+
+	name, namespace := params[pvcNameMetadataKey], params[pvcNamespaceMetadataKey]
+	if name == "" || namespace == "" {
+		klog.V(4).Infof("PersistentVolumeClaim of the volume unknown, ignoring its mount options")
+		return nil, nil
+	}
+
+	pvc, err := cs.d.mountOptionOverrides.KubeClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, status.Errorf(codes.Internal, "failed to get PersistentVolumeClaim %s/%s: %v", namespace, name, err)
+	}
+
+	allowed := sets.New(cs.d.mountOptionOverrides.Allowed...)
+	volCtx := make(map[string]string)
+
+	for annotation, key := range mountOptionAnnotations {
+		overrides, ok := pvc.Annotations[annotation]
+		if !ok {
+			continue
+		}
+
+		options, err := overrideMountOptions(params[key], overrides, allowed)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid annotation %s of PersistentVolumeClaim %s/%s: %v", annotation, namespace, name, err)
+		}
+		volCtx[key] = options
+	}
+
+	return volCtx, nil
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestOverrideMountOptions(t *testing.T) {
+	allowed := sets.New("rasize", "recover_session", "noatime")
+
+	for _, tc := range []struct {
+		base, overrides, expected string
+		fails                     bool
+	}{
+		{base: "", overrides: "rasize=16777216", expected: "rasize=16777216"},
+		{base: "noatime, rasize=8388608", overrides: "rasize=16777216, recover_session=clean", expected: "noatime,rasize=16777216,recover_session=clean"},
+		{base: "ms_mode=secure", overrides: "noatime", expected: "ms_mode=secure,noatime"},
+		{base: "ms_mode=secure", overrides: "", expected: "ms_mode=secure"},
+		{base: "rasize=8388608", overrides: "ms_mode=legacy", fails: true},
+	} {
+		options, err := overrideMountOptions(tc.base, tc.overrides, allowed)
+		if tc.fails {
+			if err == nil {
+				t.Errorf("expected overriding %q with %q to fail", tc.base, tc.overrides)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error overriding %q with %q: %v", tc.base, tc.overrides, err)
+		} else if options != tc.expected {
+			t.Errorf("expected %q overridden with %q to be %q, got %q", tc.base, tc.overrides, tc.expected, options)
+		}
+	}
+}
+
+func TestAnnotationMountOptions(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset(
+		testHintClaim("tuned", "", map[string]string{
+			"manila.csi.openstack.org/cephfs-kernel-mount-options": "rasize=16777216,recover_session=clean",
+		}),
+		testHintClaim("forbidden", "", map[string]string{
+			"manila.csi.openstack.org/cephfs-fuse-mount-options": "client_mountpoint=/",
+		}),
+		testHintClaim("plain", "", nil),
+	)
+	cs := &controllerServer{d: &Driver{
+		shareProto:           "CEPHFS",
+		mountOptionOverrides: MountOptionOverridesOpts{Allowed: []string{"rasize", "recover_session"}, KubeClient: kubeClient},
+	}}
+
+	params := func(name string) map[string]string {
+		return map[string]string{
+			pvcNameMetadataKey:          name,
+			pvcNamespaceMetadataKey:     "ns",
+			"cephfs-kernelMountOptions": "ms_mode=secure,rasize=8388608",
+		}
+	}
+
+	volCtx, err := cs.annotationMountOptions(context.TODO(), params("tuned"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := map[string]string{"cephfs-kernelMountOptions": "ms_mode=secure,rasize=16777216,recover_session=clean"}; !reflect.DeepEqual(volCtx, expected) {
+		t.Errorf("expected volume context %v, got %v", expected, volCtx)
+	}
+
+	for _, name := range []string{"plain", "missing"} {
+		if volCtx, err := cs.annotationMountOptions(context.TODO(), params(name)); err != nil || len(volCtx) != 0 {
+			t.Errorf("expected no mount options for %s, got %v, %v", name, volCtx, err)
+		}
+	}
+
+	if _, err := cs.annotationMountOptions(context.TODO(), params("forbidden")); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for a forbidden mount option, got %v", err)
+	}
+
+	// The annotations are ignored for NFS shares
+	cs.d.shareProto = "NFS"
+	if volCtx, err := cs.annotationMountOptions(context.TODO(), params("tuned")); err != nil || len(volCtx) != 0 {
+		t.Errorf("expected no mount options for NFS, got %v, %v", volCtx, err)
+	}
+}
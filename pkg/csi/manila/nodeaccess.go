@@ -0,0 +1,400 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
+	clouderrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+// NFSNodeAccessOpts configures the NFS access rules granted to the nodes the volumes are attached to.
+// When enabled, the volumes of a StorageClass setting nfs-nodeAccess are not exported to nfs-shareClient
+// when they are provisioned. Instead, ControllerPublishVolume grants access to the addresses of the node
+// the volume is attached to, and ControllerUnpublishVolume revokes it once the volume is detached.
+type NFSNodeAccessOpts struct {
+	Enabled bool
+	// KubeClient is used to look up the addresses of the nodes.
+	KubeClient kubernetes.Interface
+}
+
+const (
+	// Volume context keys of the volumes provisioned with nfs-nodeAccess
+	nodeAccessVolumeContextKey = "nfs-nodeAccess"
+	nodeCIDRsVolumeContextKey  = "nfs-nodeCIDRs"
+
+	// The ID of the access right of the node is passed on to the node service in the publish context
+	shareAccessIDPublishContextKey = "shareAccessID"
+
+	// Comma-separated IDs of the access rights granted to a node, the key is suffixed with the node ID
+	nodeAccessMetadataKeyPrefix = "manila.csi.openstack.org/node-access/"
+)
+
+// validateNFSNodeAccess checks the nfs-nodeAccess parameters of a StorageClass.
+// Returns whether the access rights of the volume are granted per node.
+func (cs *controllerServer) validateNFSNodeAccess(shareOpts *options.ControllerVolumeContext) (bool, error) {
+	if !strings.EqualFold(shareOpts.NFSNodeAccess, "true") {
+		if shareOpts.NFSNodeCIDRs != "" {
+			return false, fmt.Errorf("nfs-nodeCIDRs requires nfs-nodeAccess")
+		}
+		return false, nil
+	}
+
+	if !cs.d.nfsNodeAccess.Enabled {
+		return false, fmt.Errorf("nfs-nodeAccess requires the plugin to run with --nfs-node-access")
+	}
+	if !strings.EqualFold(shareOpts.Protocol, "NFS") || shareOpts.ProtocolFallback != "" {
+		return false, fmt.Errorf("nfs-nodeAccess requires the NFS share protocol without protocolFallback")
+	}
+	if shareOpts.NFSAccessType != "ip" {
+		return false, fmt.Errorf("nfs-nodeAccess requires nfs-accessType ip, got %s", shareOpts.NFSAccessType)
+	}
+	if _, err := parseCIDRs(shareOpts.NFSNodeCIDRs); err != nil {
+		return false, fmt.Errorf("invalid nfs-nodeCIDRs: %v", err)
+	}
+
+	return true, nil
+}
+
+// parseCIDRs parses a comma-separated list of CIDRs.
+func parseCIDRs(value string) ([]*net.IPNet, error) {
+	var cidrs []*net.IPNet
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		_, cidr, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	return cidrs, nil
+}
+
+// nodeAddresses returns the addresses of the node granted access to the volumes attached to it.
+// Those are the internal IPs of the node, or any of its IPs inside of cidrs if set.
+func nodeAddresses(node *corev1.Node, cidrs []*net.IPNet) []string {
+	var addrs []string
+	seen := make(map[string]bool)
+
+	for _, a := range node.Status.Addresses {
+		if a.Type != corev1.NodeInternalIP && (len(cidrs) == 0 || a.Type != corev1.NodeExternalIP) {
+			continue
+		}
+
+		ip := net.ParseIP(a.Address)
+		if ip == nil || seen[ip.String()] {
+			continue
+		}
+
+		if len(cidrs) > 0 {
+			contained := false
+			for _, cidr := range cidrs {
+				if cidr.Contains(ip) {
+					contained = true
+					break
+				}
+			}
+			if !contained {
+				continue
+			}
+		}
+
+		seen[ip.String()] = true
+		addrs = append(addrs, ip.String())
+	}
+
+	return addrs
+}
+
+// nodeAccessMetadataKey returns the share metadata key recording the access rights granted to a node.
+// Node names too long to fit in a metadata key are hashed.
+func nodeAccessMetadataKey(nodeID string) string {
+	if len(nodeAccessMetadataKeyPrefix+nodeID) > maxMetadataKeyLength {
+		sum := sha256.Sum256([]byte(nodeID))
+		return nodeAccessMetadataKeyPrefix + hex.EncodeToString(sum[:])
+	}
+	return nodeAccessMetadataKeyPrefix + nodeID
+}
+
+func splitAccessIDs(value string) []string {
+	var ids []string
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// getOrGrantNodeAccess returns the ip access rights of the share for addrs, granting the missing ones.
+func getOrGrantNodeAccess(manilaClient manilaclient.Interface, shareID string, addrs []string, accessLevel string) ([]shares.AccessRight, error) {
+	rights, err := manilaClient.GetAccessRights(shareID)
+	if err != nil {
+		if _, ok := err.(gophercloud.ErrResourceNotFound); !ok {
+			return nil, fmt.Errorf("failed to list access rights: %w", err)
+		}
+	}
+
+	granted := make([]shares.AccessRight, 0, len(addrs))
+
+	for _, addr := range addrs {
+		var accessRight *shares.AccessRight
+
+		for i := range rights {
+			if rights[i].AccessType == "ip" && rights[i].AccessTo == addr && rights[i].AccessLevel == accessLevel {
+				accessRight = &rights[i]
+				break
+			}
+		}
+
+		if accessRight == nil {
+			accessRight, err = manilaClient.GrantAccess(shareID, shares.GrantAccessOpts{
+				AccessType:  "ip",
+				AccessLevel: accessLevel,
+				AccessTo:    addr,
+			})
+			if err != nil {
+				return granted, fmt.Errorf("failed to grant %s access to %s: %w", accessLevel, addr, err)
+			}
+
+			klog.V(4).Infof("granted %s access to %s for share %s", accessLevel, addr, shareID)
+		}
+
+		granted = append(granted, *accessRight)
+	}
+
+	return granted, nil
+}
+
+// recordNodeAccess adds the IDs of the access rights granted to a node to the share metadata,
+// so that they're revoked when the volume is detached from the node, even if its addresses changed.
+func recordNodeAccess(manilaClient manilaclient.Interface, share *shares.Share, nodeID string, rights []shares.AccessRight) error {
+	key := nodeAccessMetadataKey(nodeID)
+	ids := splitAccessIDs(share.Metadata[key])
+
+	added := false
+	for _, r := range rights {
+		found := false
+		for _, id := range ids {
+			if id == r.ID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ids = append(ids, r.ID)
+			added = true
+		}
+	}
+
+	if !added {
+		return nil
+	}
+
+	_, err := manilaClient.SetShareMetadata(share.ID, shares.SetMetadataOpts{Metadata: map[string]string{
+		key: strings.Join(ids, ","),
+	}})
+
+	return err
+}
+
+func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
+	if !cs.d.nfsNodeAccess.Enabled {
+		return nil, status.Error(codes.Unimplemented, "")
+	}
+
+	if err := validateControllerPublishVolumeRequest(req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	volCtx := req.GetVolumeContext()
+	if !strings.EqualFold(volCtx[nodeAccessVolumeContextKey], "true") {
+		// The volume is accessed with the access right granted when it was provisioned
+		return &csi.ControllerPublishVolumeResponse{}, nil
+	}
+
+	cidrs, err := parseCIDRs(volCtx[nodeCIDRsVolumeContextKey])
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid volume context %s: %v", nodeCIDRsVolumeContextKey, err)
+	}
+
+	osOpts, err := options.NewOpenstackOptions(req.GetSecrets())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid OpenStack secrets: %v", err)
+	}
+
+	node, err := cs.d.nfsNodeAccess.KubeClient.CoreV1().Nodes().Get(ctx, req.GetNodeId(), metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "node %s not found", req.GetNodeId())
+		}
+		return nil, status.Errorf(codes.Internal, "failed to get node %s: %v", req.GetNodeId(), err)
+	}
+
+	addrs := nodeAddresses(node, cidrs)
+	if len(addrs) == 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "node %s has no address to grant access to volume %s to", req.GetNodeId(), req.GetVolumeId())
+	}
+
+	manilaClient, err := cs.d.manilaClientBuilder.New(osOpts)
+	if err != nil {
+		return nil, statusErrorf(codes.Unauthenticated, "failed to create Manila v2 client: %w", err)
+	}
+
+	share, err := manilaClient.GetShareByID(req.GetVolumeId())
+	if err != nil {
+		if clouderrors.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "volume %s not found", req.GetVolumeId())
+		}
+		return nil, statusErrorf(codes.Internal, "failed to retrieve volume %s: %w", req.GetVolumeId(), err)
+	}
+
+	accessLevel := accessLevelForCapabilities([]*csi.VolumeCapability{req.GetVolumeCapability()})
+	if req.GetReadonly() {
+		accessLevel = accessLevelReadOnly
+	}
+
+	rights, grantErr := getOrGrantNodeAccess(manilaClient, share.ID, addrs, accessLevel)
+
+	// Record the access rights granted so far, they must be revoked even if granting the next ones failed
+	if err := recordNodeAccess(manilaClient, share, req.GetNodeId(), rights); err != nil {
+		return nil, statusErrorf(codes.Internal, "failed to record the access rights of node %s for volume %s: %w", req.GetNodeId(), req.GetVolumeId(), err)
+	}
+
+	if grantErr != nil {
+		return nil, statusErrorf(codes.Internal, "failed to grant node %s access to volume %s: %w", req.GetNodeId(), req.GetVolumeId(), grantErr)
+	}
+
+	for _, r := range rights {
+		switch r.State {
+		case accessRightStateActive:
+		case accessRightStateError:
+			return nil, status.Errorf(codes.FailedPrecondition, "access right %s of node %s for volume %s is in error state", r.ID, req.GetNodeId(), req.GetVolumeId())
+		default:
+			// The external-attacher retries until the rules are applied
+			return nil, status.Errorf(codes.Unavailable, "access right %s of node %s for volume %s is not active yet", r.ID, req.GetNodeId(), req.GetVolumeId())
+		}
+	}
+
+	return &csi.ControllerPublishVolumeResponse{
+		PublishContext: map[string]string{
+			shareAccessIDPublishContextKey: rights[0].ID,
+		},
+	}, nil
+}
+
+func (cs *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
+	if !cs.d.nfsNodeAccess.Enabled {
+		return nil, status.Error(codes.Unimplemented, "")
+	}
+
+	if err := validateControllerUnpublishVolumeRequest(req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	osOpts, err := options.NewOpenstackOptions(req.GetSecrets())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid OpenStack secrets: %v", err)
+	}
+
+	manilaClient, err := cs.d.manilaClientBuilder.New(osOpts)
+	if err != nil {
+		return nil, statusErrorf(codes.Unauthenticated, "failed to create Manila v2 client: %w", err)
+	}
+
+	share, err := manilaClient.GetShareByID(req.GetVolumeId())
+	if err != nil {
+		if clouderrors.IsNotFound(err) {
+			// Nothing left to revoke
+			return &csi.ControllerUnpublishVolumeResponse{}, nil
+		}
+		return nil, statusErrorf(codes.Internal, "failed to retrieve volume %s: %w", req.GetVolumeId(), err)
+	}
+
+	key := nodeAccessMetadataKey(req.GetNodeId())
+	ids := splitAccessIDs(share.Metadata[key])
+	if len(ids) == 0 {
+		// The volume wasn't provisioned with nfs-nodeAccess, or it's already been unpublished
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	}
+
+	// Access rights still used by other nodes sharing an address with this one are kept
+	inUse := make(map[string]bool)
+	for k, v := range share.Metadata {
+		if k != key && strings.HasPrefix(k, nodeAccessMetadataKeyPrefix) {
+			for _, id := range splitAccessIDs(v) {
+				inUse[id] = true
+			}
+		}
+	}
+
+	for _, id := range ids {
+		if inUse[id] {
+			continue
+		}
+
+		if err := manilaClient.RevokeAccess(share.ID, id); err != nil && !clouderrors.IsNotFound(err) {
+			return nil, statusErrorf(codes.Internal, "failed to revoke access right %s of node %s for volume %s: %w", id, req.GetNodeId(), req.GetVolumeId(), err)
+		}
+
+		klog.V(4).Infof("revoked access right %s of node %s for volume %s", id, req.GetNodeId(), req.GetVolumeId())
+	}
+
+	_, err = manilaClient.SetShareMetadata(share.ID, shares.SetMetadataOpts{Metadata: map[string]string{key: ""}})
+	if err != nil {
+		return nil, statusErrorf(codes.Internal, "failed to clear the access rights of node %s for volume %s: %w", req.GetNodeId(), req.GetVolumeId(), err)
+	}
+
+	return &csi.ControllerUnpublishVolumeResponse{}, nil
+}
+
+// withPublishContext returns the volume context of a volume whose access right
+// was granted to the node by ControllerPublishVolume, see NFSNodeAccessOpts.
+func withPublishContext(volCtx, publishCtx map[string]string) map[string]string {
+	accessID := publishCtx[shareAccessIDPublishContextKey]
+	if accessID == "" {
+		return volCtx
+	}
+
+	merged := make(map[string]string, len(volCtx)+1)
+	for k, v := range volCtx {
+		merged[k] = v
+	}
+	merged["shareAccessID"] = accessID
+
+	return merged
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/cloud-provider-openstack/pkg/client"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+)
+
+type fakeNodeAccessClientBuilder struct {
+	c *fakeKeyRotationClient
+}
+
+func (b fakeNodeAccessClientBuilder) New(*client.AuthOpts) (manilaclient.Interface, error) {
+	return b.c, nil
+}
+
+func testNode(name string, addrs ...corev1.NodeAddress) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     corev1.NodeStatus{Addresses: addrs},
+	}
+}
+
+func TestNodeAddresses(t *testing.T) {
+	node := testNode("node-1",
+		corev1.NodeAddress{Type: corev1.NodeHostName, Address: "node-1"},
+		corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.5"},
+		corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "192.168.1.5"},
+		corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: "172.24.4.5"},
+		corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.5"},
+	)
+
+	ts := []struct {
+		cidrs    string
+		expected []string
+	}{
+		{"", []string{"10.0.0.5", "192.168.1.5"}},
+		{"10.0.0.0/24", []string{"10.0.0.5"}},
+		{"172.24.4.0/24, 192.168.0.0/16", []string{"192.168.1.5", "172.24.4.5"}},
+		{"10.1.0.0/16", nil},
+	}
+
+	for _, tc := range ts {
+		cidrs, err := parseCIDRs(tc.cidrs)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.cidrs, err)
+		}
+		if addrs := nodeAddresses(node, cidrs); !reflect.DeepEqual(addrs, tc.expected) {
+			t.Errorf("%q: expected %v, got %v", tc.cidrs, tc.expected, addrs)
+		}
+	}
+
+	if _, err := parseCIDRs("10.0.0.0/24,10.1.0.1"); err == nil {
+		t.Error("expected an error for an address without prefix length")
+	}
+}
+
+func TestNodeAccessMetadataKey(t *testing.T) {
+	if key := nodeAccessMetadataKey("node-1"); key != nodeAccessMetadataKeyPrefix+"node-1" {
+		t.Errorf("unexpected key %s", key)
+	}
+
+	long := strings.Repeat("n", 253)
+	if key := nodeAccessMetadataKey(long); len(key) > maxMetadataKeyLength || !strings.HasPrefix(key, nodeAccessMetadataKeyPrefix) {
+		t.Errorf("unexpected key %s for a long node name", key)
+	}
+}
+
+func TestWithPublishContext(t *testing.T) {
+	volCtx := map[string]string{"shareID": "share", nodeAccessVolumeContextKey: "true"}
+
+	if merged := withPublishContext(volCtx, nil); !reflect.DeepEqual(merged, volCtx) {
+		t.Errorf("expected the volume context unchanged, got %v", merged)
+	}
+
+	merged := withPublishContext(volCtx, map[string]string{shareAccessIDPublishContextKey: "access-1"})
+	if merged["shareAccessID"] != "access-1" || merged["shareID"] != "share" {
+		t.Errorf("unexpected volume context %v", merged)
+	}
+	if _, ok := volCtx["shareAccessID"]; ok {
+		t.Error("the volume context of the request was modified")
+	}
+}
+
+func TestControllerPublishUnpublishNodeAccess(t *testing.T) {
+	c := &fakeKeyRotationClient{
+		share: shares.Share{ID: "share", ShareProto: "NFS", Status: shareAvailable},
+	}
+
+	kubeClient := fake.NewSimpleClientset(
+		testNode("node-1", corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.5"}),
+		testNode("node-2",
+			corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.6"},
+			corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "192.168.1.6"},
+		),
+	)
+
+	d := &Driver{
+		shareProto:          "NFS",
+		nfsNodeAccess:       NFSNodeAccessOpts{Enabled: true, KubeClient: kubeClient},
+		manilaClientBuilder: fakeNodeAccessClientBuilder{c},
+	}
+	cs := &controllerServer{d: d}
+
+	secrets := map[string]string{"os-authURL": "https://keystone", "os-userName": "admin", "os-password": "secret", "os-projectName": "admin", "os-domainName": "default", "os-region": "RegionOne"}
+	volCap := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+	}
+
+	publish := func(nodeID string, volCtx map[string]string, readonly bool) (*csi.ControllerPublishVolumeResponse, error) {
+		return cs.ControllerPublishVolume(context.TODO(), &csi.ControllerPublishVolumeRequest{
+			VolumeId:         "share",
+			NodeId:           nodeID,
+			VolumeCapability: volCap,
+			Readonly:         readonly,
+			Secrets:          secrets,
+			VolumeContext:    volCtx,
+		})
+	}
+	unpublish := func(nodeID string) error {
+		_, err := cs.ControllerUnpublishVolume(context.TODO(), &csi.ControllerUnpublishVolumeRequest{
+			VolumeId: "share",
+			NodeId:   nodeID,
+			Secrets:  secrets,
+		})
+		return err
+	}
+
+	// Volumes provisioned without nfs-nodeAccess are left alone
+	resp, err := publish("node-1", map[string]string{"shareAccessID": "static"}, false)
+	if err != nil || len(resp.GetPublishContext()) != 0 || len(c.rights) != 0 {
+		t.Fatalf("expected no access right to be granted, got %v, %v, %v", resp, err, c.rights)
+	}
+
+	volCtx := map[string]string{nodeAccessVolumeContextKey: "true"}
+
+	resp, err = publish("node-1", volCtx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := resp.GetPublishContext()[shareAccessIDPublishContextKey]; id != "access-1" {
+		t.Errorf("expected access right access-1 in the publish context, got %q", id)
+	}
+	if c.rights[0].AccessTo != "10.0.0.5" || c.rights[0].AccessLevel != accessLevelReadWrite {
+		t.Errorf("unexpected access right %+v", c.rights[0])
+	}
+
+	// Publishing again is idempotent
+	if _, err = publish("node-1", volCtx, false); err != nil || len(c.rights) != 1 {
+		t.Fatalf("expected a single access right, got %v, %v", c.rights, err)
+	}
+
+	// Only the addresses inside of nfs-nodeCIDRs are granted access
+	resp, err = publish("node-2", map[string]string{nodeAccessVolumeContextKey: "true", nodeCIDRsVolumeContextKey: "192.168.0.0/16"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accessTos := c.accessTos(); !reflect.DeepEqual(accessTos, []string{"10.0.0.5", "192.168.1.6"}) {
+		t.Errorf("unexpected access rights %v", accessTos)
+	}
+	if c.rights[1].AccessLevel != accessLevelReadOnly {
+		t.Errorf("expected a read-only access right, got %+v", c.rights[1])
+	}
+	if ids := c.share.Metadata[nodeAccessMetadataKey("node-2")]; ids != "access-2" {
+		t.Errorf("expected access-2 recorded for node-2, got %q", ids)
+	}
+
+	if _, err = publish("node-3", volCtx, false); err == nil {
+		t.Error("expected an error for a missing node")
+	}
+
+	// Detaching the volume revokes the access rights of the node only
+	if err = unpublish("node-1"); err != nil {
+		t.Fatal(err)
+	}
+	if accessTos := c.accessTos(); !reflect.DeepEqual(accessTos, []string{"192.168.1.6"}) {
+		t.Errorf("unexpected access rights %v", accessTos)
+	}
+	if ids := c.share.Metadata[nodeAccessMetadataKey("node-1")]; ids != "" {
+		t.Errorf("expected the access rights of node-1 to be cleared, got %q", ids)
+	}
+
+	// Unpublishing again is idempotent
+	if err = unpublish("node-1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestControllerPublishNodeAccessPending(t *testing.T) {
+	c := &fakeKeyRotationClient{
+		share: shares.Share{ID: "share", ShareProto: "NFS", Status: shareAvailable},
+		rights: []shares.AccessRight{
+			{ID: "queued", AccessType: "ip", AccessTo: "10.0.0.5", AccessLevel: "rw", State: "queued_to_apply"},
+		},
+	}
+
+	d := &Driver{
+		shareProto:          "NFS",
+		nfsNodeAccess:       NFSNodeAccessOpts{Enabled: true, KubeClient: fake.NewSimpleClientset(testNode("node-1", corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.5"}))},
+		manilaClientBuilder: fakeNodeAccessClientBuilder{c},
+	}
+	cs := &controllerServer{d: d}
+
+	_, err := cs.ControllerPublishVolume(context.TODO(), &csi.ControllerPublishVolumeRequest{
+		VolumeId: "share",
+		NodeId:   "node-1",
+		VolumeCapability: &csi.VolumeCapability{
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+		},
+		Secrets:       map[string]string{"os-authURL": "https://keystone", "os-userName": "admin", "os-password": "secret", "os-projectName": "admin", "os-domainName": "default", "os-region": "RegionOne"},
+		VolumeContext: map[string]string{nodeAccessVolumeContextKey: "true"},
+	})
+	if err == nil {
+		t.Fatal("expected an error while the access right isn't active yet")
+	}
+
+	// The access right is recorded anyway, to be revoked if the volume is never attached
+	if ids := c.share.Metadata[nodeAccessMetadataKey("node-1")]; ids != "queued" {
+		t.Errorf("expected the pending access right to be recorded, got %q", ids)
+	}
+}
@@ -18,6 +18,8 @@ package manila
 
 import (
 	"context"
+	"errors"
+	"os"
 	"strings"
 	"sync"
 
@@ -26,10 +28,13 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"k8s.io/cloud-provider-openstack/pkg/client"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/csiclient"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/shareadapters"
+	manilautil "k8s.io/cloud-provider-openstack/pkg/csi/manila/util"
 	clouderrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
 	"k8s.io/klog/v2"
+	mountutil "k8s.io/mount-utils"
 )
 
 type nodeServer struct {
@@ -39,20 +44,27 @@ type nodeServer struct {
 	// The result of NodeStageVolume is stashed away for NodePublishVolume(s) that will follow
 	nodeStageCache    map[volumeID]stageCacheEntry
 	nodeStageCacheMtx sync.RWMutex
+
+	// Used for bind-mounting sub-paths of shares, see subPathPattern volume context parameter
+	mounter mountutil.Interface
 }
 
 type stageCacheEntry struct {
+	shareProto    string
 	volumeContext map[string]string
 	stageSecret   map[string]string
 	publishSecret map[string]string
 }
 
-func (ns *nodeServer) buildVolumeContext(volID volumeID, shareOpts *options.NodeVolumeContext, osOpts *client.AuthOpts) (
-	volumeContext map[string]string, accessRight *shares.AccessRight, err error,
+// buildVolumeContexts returns the volume contexts of the usable export locations of the share,
+// ordered by preference. The first one is used for mounting the share, the next ones on failover.
+// The share protocol selects the proxied CSI Node Plugin the share is mounted with.
+func (ns *nodeServer) buildVolumeContexts(volID volumeID, shareOpts *options.NodeVolumeContext, osOpts *client.AuthOpts) (
+	volumeContexts []map[string]string, accessRight *shares.AccessRight, shareProto string, err error,
 ) {
 	manilaClient, err := ns.d.manilaClientBuilder.New(osOpts)
 	if err != nil {
-		return nil, nil, status.Errorf(codes.Unauthenticated, "failed to create Manila v2 client: %v", err)
+		return nil, nil, "", statusErrorf(codes.Unauthenticated, "failed to create Manila v2 client: %w", err)
 	}
 
 	// Retrieve the share by its ID or name
@@ -67,7 +79,7 @@ func (ns *nodeServer) buildVolumeContext(volID volumeID, shareOpts *options.Node
 				errCode = codes.NotFound
 			}
 
-			return nil, nil, status.Errorf(errCode, "failed to retrieve volume with share ID %s: %v", shareOpts.ShareID, err)
+			return nil, nil, "", statusErrorf(errCode, "failed to retrieve volume with share ID %s: %w", shareOpts.ShareID, err)
 		}
 	} else {
 		share, err = manilaClient.GetShareByName(shareOpts.ShareName)
@@ -77,44 +89,68 @@ func (ns *nodeServer) buildVolumeContext(volID volumeID, shareOpts *options.Node
 				errCode = codes.NotFound
 			}
 
-			return nil, nil, status.Errorf(errCode, "failed to retrieve volume with share name %s: %v", shareOpts.ShareName, err)
+			return nil, nil, "", statusErrorf(errCode, "failed to retrieve volume with share name %s: %w", shareOpts.ShareName, err)
 		}
 	}
 
 	// Verify the plugin supports this share
 
-	if !strings.EqualFold(share.ShareProto, ns.d.shareProto) {
-		return nil, nil, status.Errorf(codes.InvalidArgument,
+	if _, ok := ns.d.fwdEndpointFor(share.ShareProto); !ok {
+		return nil, nil, "", status.Errorf(codes.InvalidArgument,
 			"wrong share protocol %s for volume %s, the plugin is set to operate in %s",
 			share.ShareProto, volID, ns.d.shareProto)
 	}
 
 	if share.Status != shareAvailable {
 		if share.Status == shareCreating {
-			return nil, nil, status.Errorf(codes.Unavailable, "volume %s is in transient creating state", volID)
+			return nil, nil, "", status.Errorf(codes.Unavailable, "volume %s is in transient creating state", volID)
 		}
 
-		return nil, nil, status.Errorf(codes.FailedPrecondition, "invalid share status for volume %s: expected 'available', got '%s'",
+		return nil, nil, "", status.Errorf(codes.FailedPrecondition, "invalid share status for volume %s: expected 'available', got '%s'",
 			volID, share.Status)
 	}
 
 	// Get the access right for this share
 
-	accessRights, err := manilaClient.GetAccessRights(share.ID)
-	if err != nil {
-		return nil, nil, status.Errorf(codes.Internal, "failed to list access rights for volume %s: %v", volID, err)
-	}
+	if shareOpts.ReadOnlyAccessTo != "" {
+		accessRight, err = getOrGrantReadOnlyAccess(manilaClient, share, shareOpts.ReadOnlyAccessTo, volID)
+		if err != nil {
+			return nil, nil, "", err
+		}
+	} else {
+		accessRights, err := manilaClient.GetAccessRights(share.ID)
+		if err != nil {
+			return nil, nil, "", statusErrorf(codes.Internal, "failed to list access rights for volume %s: %w", volID, err)
+		}
+
+		// The access right is replaced when its cephx key is rotated, see keyrotation.go
+		accessID := shareOpts.ShareAccessID
+		if rotatedAccessID := share.Metadata[accessIDMetadataKey]; rotatedAccessID != "" {
+			accessID = rotatedAccessID
+		}
 
-	for i := range accessRights {
-		if accessRights[i].ID == shareOpts.ShareAccessID {
-			accessRight = &accessRights[i]
-			break
+		for i := range accessRights {
+			if accessRights[i].ID == accessID {
+				accessRight = &accessRights[i]
+				break
+			}
+		}
+
+		if accessRight == nil {
+			return nil, nil, "", status.Errorf(codes.InvalidArgument, "cannot find access right %s for volume %s",
+				accessID, volID)
 		}
 	}
 
-	if accessRight == nil {
-		return nil, nil, status.Errorf(codes.InvalidArgument, "cannot find access right %s for volume %s",
-			shareOpts.ShareAccessID, volID)
+	if strings.EqualFold(share.ShareProto, "CEPHFS") && accessRight.AccessKey == "" {
+		// The cephx key may still be assigned asynchronously, let the CO retry
+		if accessRight.State == accessRightStateError {
+			return nil, nil, "", status.Errorf(codes.FailedPrecondition, "access right %s for volume %s is in error state",
+				accessRight.ID, volID)
+		}
+
+		return nil, nil, "", status.Errorf(codes.Unavailable, "access right %s for volume %s has no cephx key assigned yet",
+			accessRight.ID, volID)
 	}
 
 	// Retrieve list of all export locations for this share.
@@ -122,27 +158,55 @@ func (ns *nodeServer) buildVolumeContext(volID volumeID, shareOpts *options.Node
 
 	availableExportLocations, err := manilaClient.GetExportLocations(share.ID)
 	if err != nil {
-		return nil, nil, status.Errorf(codes.Internal, "failed to list export locations for volume %s: %v", volID, err)
+		return nil, nil, "", statusErrorf(codes.Internal, "failed to list export locations for volume %s: %w", volID, err)
 	}
 
-	// Build volume context for fwd plugin
+	// Build volume contexts for fwd plugin, one for each export location
+	// accepted by the share adapter
 
-	sa := getShareAdapter(ns.d.shareProto)
-	opts := &shareadapters.VolumeContextArgs{
-		Locations: availableExportLocations,
-		Options:   shareOpts,
+	policy := ns.d.exportLocationPolicy
+	if shareOpts.ExportLocationPolicy != "" {
+		if policy, err = manilautil.ParseExportLocationPolicy(shareOpts.ExportLocationPolicy); err != nil {
+			return nil, nil, "", status.Errorf(codes.InvalidArgument, "invalid export location policy for volume %s: %v", volID, err)
+		}
 	}
-	volumeContext, err = sa.BuildVolumeContext(opts)
-	if err != nil {
-		return nil, nil, status.Errorf(codes.InvalidArgument, "failed to build volume context for volume %s: %v", volID, err)
+
+	var zoneOf func(*shares.ExportLocation) string
+	if policy.UsesZones() {
+		zoneOf = exportLocationZones(manilaClient, share)
+	}
+
+	shareProto = strings.ToUpper(share.ShareProto)
+	sa := getShareAdapter(shareProto)
+	for _, i := range policy.Sort(availableExportLocations, ns.d.nodeAZ, zoneOf) {
+		opts := &shareadapters.VolumeContextArgs{
+			Locations: availableExportLocations[i : i+1],
+			Options:   shareOpts,
+		}
+		volumeContext, buildErr := sa.BuildVolumeContext(opts)
+		if buildErr != nil {
+			klog.V(4).Infof("skipping export location %s for volume %s: %v", availableExportLocations[i].Path, volID, buildErr)
+			err = buildErr
+			continue
+		}
+
+		volumeContexts = append(volumeContexts, volumeContext)
 	}
 
-	return
+	if len(volumeContexts) == 0 {
+		if err == nil {
+			err = errors.New("no suitable non-admin export locations available")
+		}
+		return nil, nil, "", status.Errorf(codes.InvalidArgument, "failed to build volume context for volume %s: %v", volID, err)
+	}
+
+	return volumeContexts, accessRight, shareProto, nil
 }
 
-func buildNodePublishSecret(accessRight *shares.AccessRight, sa shareadapters.ShareAdapter, volID volumeID) (map[string]string, error) {
+func buildNodePublishSecret(accessRight *shares.AccessRight, sa shareadapters.ShareAdapter, volID volumeID, secrets map[string]string) (map[string]string, error) {
 	opts := &shareadapters.SecretArgs{
 		AccessRight: accessRight,
+		Secrets:     secrets,
 	}
 	secret, err := sa.BuildNodePublishSecret(opts)
 	if err != nil {
@@ -152,9 +216,10 @@ func buildNodePublishSecret(accessRight *shares.AccessRight, sa shareadapters.Sh
 	return secret, nil
 }
 
-func buildNodeStageSecret(accessRight *shares.AccessRight, sa shareadapters.ShareAdapter, volID volumeID) (map[string]string, error) {
+func buildNodeStageSecret(accessRight *shares.AccessRight, sa shareadapters.ShareAdapter, volID volumeID, secrets map[string]string) (map[string]string, error) {
 	opts := &shareadapters.SecretArgs{
 		AccessRight: accessRight,
+		Secrets:     secrets,
 	}
 	secret, err := sa.BuildNodeStageSecret(opts)
 	if err != nil {
@@ -171,7 +236,7 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 
 	// Configuration
 
-	shareOpts, err := options.NewNodeVolumeContext(req.GetVolumeContext())
+	shareOpts, err := options.NewNodeVolumeContext(withPublishContext(req.GetVolumeContext(), req.GetPublishContext()))
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "invalid volume context: %v", err)
 	}
@@ -181,11 +246,20 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		return nil, status.Errorf(codes.InvalidArgument, "invalid OpenStack secrets: %v", err)
 	}
 
+	var subPath string
+	if shareOpts.SubPathPattern != "" {
+		if subPath, err = renderSubPath(shareOpts.SubPathPattern, req.GetVolumeContext()); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid volume context: %v", err)
+		}
+	}
+
 	volID := volumeID(req.GetVolumeId())
 
 	var (
 		accessRight       *shares.AccessRight
+		volumeCtxs        []map[string]string
 		volumeCtx, secret map[string]string
+		shareProto        string
 	)
 
 	if ns.supportsNodeStage {
@@ -196,18 +270,20 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		ns.nodeStageCacheMtx.RUnlock()
 
 		if ok {
-			volumeCtx, secret = cacheEntry.volumeContext, cacheEntry.publishSecret
+			volumeCtx, secret, shareProto = cacheEntry.volumeContext, cacheEntry.publishSecret, cacheEntry.shareProto
 		} else {
 			klog.Warningf("STAGE_UNSTAGE_VOLUME capability is enabled, but node stage cache doesn't contain an entry for %s - this is most likely a bug! Rebuilding staging data anyway...", volID)
-			volumeCtx, accessRight, err = ns.buildVolumeContext(volID, shareOpts, osOpts)
+			volumeCtxs, accessRight, shareProto, err = ns.buildVolumeContexts(volID, shareOpts, osOpts)
 			if err == nil {
-				secret, err = buildNodePublishSecret(accessRight, getShareAdapter(ns.d.shareProto), volID)
+				volumeCtx = volumeCtxs[0]
+				secret, err = buildNodePublishSecret(accessRight, getShareAdapter(shareProto), volID, req.GetSecrets())
 			}
 		}
 	} else {
-		volumeCtx, accessRight, err = ns.buildVolumeContext(volID, shareOpts, osOpts)
+		volumeCtxs, accessRight, shareProto, err = ns.buildVolumeContexts(volID, shareOpts, osOpts)
 		if err == nil {
-			secret, err = buildNodePublishSecret(accessRight, getShareAdapter(ns.d.shareProto), volID)
+			volumeCtx = volumeCtxs[0]
+			secret, err = buildNodePublishSecret(accessRight, getShareAdapter(shareProto), volID, req.GetSecrets())
 		}
 	}
 	if err != nil {
@@ -216,16 +292,27 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 
 	// Forward the RPC
 
-	csiConn, err := ns.d.csiClientBuilder.NewConnectionWithContext(ctx, ns.d.fwdEndpoint)
+	fwdEndpoint, _ := ns.d.fwdEndpointFor(shareProto)
+	csiConn, err := ns.d.csiClientBuilder.NewConnectionWithContext(ctx, fwdEndpoint)
 	if err != nil {
-		return nil, status.Error(codes.Unavailable, fmtGrpcConnError(ns.d.fwdEndpoint, err))
+		return nil, status.Error(codes.Unavailable, fmtGrpcConnError(fwdEndpoint, err))
 	}
 	defer csiConn.Close()
 
 	req.Secrets = secret
 	req.VolumeContext = volumeCtx
+	if shareOpts.ReadOnlyAccessTo != "" {
+		// The access right doesn't allow writing to the share anyway
+		req.Readonly = true
+	}
+
+	nodeClient := ns.d.csiClientBuilder.NewNodeServiceClient(csiConn)
+
+	if subPath != "" {
+		return ns.publishSubPath(ctx, nodeClient, req, subPath)
+	}
 
-	return ns.d.csiClientBuilder.NewNodeServiceClient(csiConn).PublishVolume(ctx, req)
+	return nodeClient.PublishVolume(ctx, req)
 }
 
 func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
@@ -233,13 +320,49 @@ func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	csiConn, err := ns.d.csiClientBuilder.NewConnectionWithContext(ctx, ns.d.fwdEndpoint)
+	for _, fwdEndpoint := range ns.volumeFwdEndpoints(volumeID(req.GetVolumeId())) {
+		if err := ns.unpublishVolume(ctx, fwdEndpoint, req); err != nil {
+			return nil, err
+		}
+	}
+
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+func (ns *nodeServer) unpublishVolume(ctx context.Context, fwdEndpoint string, req *csi.NodeUnpublishVolumeRequest) error {
+	csiConn, err := ns.d.csiClientBuilder.NewConnectionWithContext(ctx, fwdEndpoint)
 	if err != nil {
-		return nil, status.Error(codes.Unavailable, fmtGrpcConnError(ns.d.fwdEndpoint, err))
+		return status.Error(codes.Unavailable, fmtGrpcConnError(fwdEndpoint, err))
 	}
 	defer csiConn.Close()
 
-	return ns.d.csiClientBuilder.NewNodeServiceClient(csiConn).UnpublishVolume(ctx, req)
+	nodeClient := ns.d.csiClientBuilder.NewNodeServiceClient(csiConn)
+
+	hasSubPath, err := ns.unpublishSubPath(ctx, nodeClient, req)
+	if err != nil || hasSubPath {
+		return err
+	}
+
+	_, err = nodeClient.UnpublishVolume(ctx, req)
+	return err
+}
+
+// volumeFwdEndpoints returns the endpoints of the proxied CSI Node Plugins the RPCs of a volume
+// are forwarded to: the one of its share protocol if the volume was staged by this instance,
+// all of them otherwise. Unpublishing and unstaging are idempotent, so a plugin which didn't
+// mount the volume has nothing to do.
+func (ns *nodeServer) volumeFwdEndpoints(volID volumeID) []string {
+	ns.nodeStageCacheMtx.RLock()
+	cacheEntry, ok := ns.nodeStageCache[volID]
+	ns.nodeStageCacheMtx.RUnlock()
+
+	if ok {
+		if fwdEndpoint, ok := ns.d.fwdEndpointFor(cacheEntry.shareProto); ok {
+			return []string{fwdEndpoint}
+		}
+	}
+
+	return ns.d.fwdEndpoints()
 }
 
 func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
@@ -251,12 +374,13 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 
 	var (
 		accessRight                *shares.AccessRight
-		volumeCtx                  map[string]string
+		volumeCtxs                 []map[string]string
 		stageSecret, publishSecret map[string]string
+		shareProto                 string
 		err                        error
 	)
 
-	shareOpts, err := options.NewNodeVolumeContext(req.GetVolumeContext())
+	shareOpts, err := options.NewNodeVolumeContext(withPublishContext(req.GetVolumeContext(), req.GetPublishContext()))
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "invalid volume context: %v", err)
 	}
@@ -268,22 +392,26 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 
 	volID := volumeID(req.GetVolumeId())
 
+	if shareOpts.NFSSecurity != "" && ns.d.nfsKrb5KeytabFile != "" {
+		if err := writeNFSKeytab(ns.d.nfsKrb5KeytabFile, req.GetSecrets()); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to write Kerberos keytab for volume %s: %v", volID, err)
+		}
+	}
+
 	ns.nodeStageCacheMtx.Lock()
-	if cacheEntry, ok := ns.nodeStageCache[volID]; ok {
-		volumeCtx, stageSecret = cacheEntry.volumeContext, cacheEntry.stageSecret
+	cacheEntry, ok := ns.nodeStageCache[volID]
+	if ok {
+		// The volume was already staged, keep using the export location it was staged with
+		volumeCtxs, stageSecret, shareProto = []map[string]string{cacheEntry.volumeContext}, cacheEntry.stageSecret, cacheEntry.shareProto
 	} else {
-		volumeCtx, accessRight, err = ns.buildVolumeContext(volID, shareOpts, osOpts)
+		volumeCtxs, accessRight, shareProto, err = ns.buildVolumeContexts(volID, shareOpts, osOpts)
 
 		if err == nil {
-			stageSecret, err = buildNodeStageSecret(accessRight, getShareAdapter(ns.d.shareProto), volID)
+			stageSecret, err = buildNodeStageSecret(accessRight, getShareAdapter(shareProto), volID, req.GetSecrets())
 		}
 
 		if err == nil {
-			publishSecret, err = buildNodePublishSecret(accessRight, getShareAdapter(ns.d.shareProto), volID)
-		}
-
-		if err == nil {
-			ns.nodeStageCache[volID] = stageCacheEntry{volumeContext: volumeCtx, stageSecret: stageSecret, publishSecret: publishSecret}
+			publishSecret, err = buildNodePublishSecret(accessRight, getShareAdapter(shareProto), volID, req.GetSecrets())
 		}
 	}
 	ns.nodeStageCacheMtx.Unlock()
@@ -293,16 +421,61 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 
 	// Forward the RPC
 
-	csiConn, err := ns.d.csiClientBuilder.NewConnectionWithContext(ctx, ns.d.fwdEndpoint)
+	fwdEndpoint, _ := ns.d.fwdEndpointFor(shareProto)
+	csiConn, err := ns.d.csiClientBuilder.NewConnectionWithContext(ctx, fwdEndpoint)
 	if err != nil {
-		return nil, status.Error(codes.Unavailable, fmtGrpcConnError(ns.d.fwdEndpoint, err))
+		return nil, status.Error(codes.Unavailable, fmtGrpcConnError(fwdEndpoint, err))
 	}
 	defer csiConn.Close()
 
 	req.Secrets = stageSecret
-	req.VolumeContext = volumeCtx
 
-	return ns.d.csiClientBuilder.NewNodeServiceClient(csiConn).StageVolume(ctx, req)
+	resp, err := stageWithFailover(ctx, ns.d.csiClientBuilder.NewNodeServiceClient(csiConn), req, volumeCtxs)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		// Record the export location the volume was staged with for the NodePublishVolume(s) that will follow
+		ns.nodeStageCacheMtx.Lock()
+		ns.nodeStageCache[volID] = stageCacheEntry{shareProto: shareProto, volumeContext: req.VolumeContext, stageSecret: stageSecret, publishSecret: publishSecret}
+		ns.nodeStageCacheMtx.Unlock()
+	}
+
+	return resp, nil
+}
+
+// stageWithFailover forwards the NodeStageVolume request with each of the volume contexts
+// in turn, until the share is mounted. req.VolumeContext is set to the volume context
+// the share was staged with.
+func stageWithFailover(ctx context.Context, nodeClient csiclient.Node, req *csi.NodeStageVolumeRequest, volumeCtxs []map[string]string) (
+	resp *csi.NodeStageVolumeResponse, err error,
+) {
+	for i, volumeCtx := range volumeCtxs {
+		req.VolumeContext = volumeCtx
+
+		resp, err = nodeClient.StageVolume(ctx, req)
+		if err == nil || !isMountFailure(err) || ctx.Err() != nil {
+			return resp, err
+		}
+
+		if i < len(volumeCtxs)-1 {
+			klog.Warningf("failed to stage volume %s, retrying with the next export location: %v", req.GetVolumeId(), err)
+		}
+	}
+
+	return resp, err
+}
+
+// isMountFailure returns true if the error returned by the fwd plugin may be
+// caused by an unreachable export location.
+func isMountFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Internal, codes.Unknown, codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+
+	return false
 }
 
 func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
@@ -310,17 +483,30 @@ func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
+	fwdEndpoints := ns.volumeFwdEndpoints(volumeID(req.VolumeId))
+
 	ns.nodeStageCacheMtx.Lock()
 	delete(ns.nodeStageCache, volumeID(req.VolumeId))
 	ns.nodeStageCacheMtx.Unlock()
 
-	csiConn, err := ns.d.csiClientBuilder.NewConnectionWithContext(ctx, ns.d.fwdEndpoint)
+	for _, fwdEndpoint := range fwdEndpoints {
+		if err := ns.unstageVolume(ctx, fwdEndpoint, req); err != nil {
+			return nil, err
+		}
+	}
+
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
+func (ns *nodeServer) unstageVolume(ctx context.Context, fwdEndpoint string, req *csi.NodeUnstageVolumeRequest) error {
+	csiConn, err := ns.d.csiClientBuilder.NewConnectionWithContext(ctx, fwdEndpoint)
 	if err != nil {
-		return nil, status.Error(codes.Unavailable, fmtGrpcConnError(ns.d.fwdEndpoint, err))
+		return status.Error(codes.Unavailable, fmtGrpcConnError(fwdEndpoint, err))
 	}
 	defer csiConn.Close()
 
-	return ns.d.csiClientBuilder.NewNodeServiceClient(csiConn).UnstageVolume(ctx, req)
+	_, err = ns.d.csiClientBuilder.NewNodeServiceClient(csiConn).UnstageVolume(ctx, req)
+	return err
 }
 
 func (ns *nodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
@@ -344,13 +530,29 @@ func (ns *nodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetC
 }
 
 func (ns *nodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
-	csiConn, err := ns.d.csiClientBuilder.NewConnectionWithContext(ctx, ns.d.fwdEndpoint)
+	if err := validateNodeGetVolumeStatsRequest(req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// The shares are mounted by the proxied CSI Node Plugins, but their stats don't depend on the share protocol
+
+	stats, err := getVolumeStats(req.GetVolumePath(), volumeStatsTimeout)
 	if err != nil {
-		return nil, status.Error(codes.Unavailable, fmtGrpcConnError(ns.d.fwdEndpoint, err))
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound, "volume %s not found at %s", req.GetVolumeId(), req.GetVolumePath())
+		}
+
+		if condition := abnormalVolumeCondition(req.GetVolumePath(), err); condition != nil {
+			return &csi.NodeGetVolumeStatsResponse{VolumeCondition: condition}, nil
+		}
+
+		return nil, status.Errorf(codes.Internal, "failed to get the stats of volume %s: %v", req.GetVolumeId(), err)
 	}
-	defer csiConn.Close()
 
-	return ns.d.csiClientBuilder.NewNodeServiceClient(csiConn).GetVolumeStats(ctx, req)
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage:           stats.usage(),
+		VolumeCondition: &csi.VolumeCondition{Message: "Volume is mounted"},
+	}, nil
 }
 
 func (ns *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
@@ -0,0 +1,270 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/client"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/csiclient"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	manilautil "k8s.io/cloud-provider-openstack/pkg/csi/manila/util"
+)
+
+type fakeStageNodeClient struct {
+	csiclient.Node
+
+	// Errors returned by StageVolume for each server
+	errs   map[string]error
+	staged []string
+}
+
+func (c *fakeStageNodeClient) StageVolume(_ context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+	server := req.GetVolumeContext()["server"]
+	c.staged = append(c.staged, server)
+
+	if err := c.errs[server]; err != nil {
+		return nil, err
+	}
+
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+func TestStageWithFailover(t *testing.T) {
+	volumeCtxs := []map[string]string{
+		{"server": "10.0.0.1"},
+		{"server": "10.0.0.2"},
+		{"server": "10.0.0.3"},
+	}
+
+	ts := []struct {
+		name           string
+		errs           map[string]error
+		expectedStaged []string
+		expectedCode   codes.Code
+	}{
+		{
+			name:           "first location",
+			expectedStaged: []string{"10.0.0.1"},
+			expectedCode:   codes.OK,
+		},
+		{
+			name: "failover to the next location",
+			errs: map[string]error{
+				"10.0.0.1": status.Error(codes.Internal, "mount failed"),
+				"10.0.0.2": status.Error(codes.DeadlineExceeded, "mount timed out"),
+			},
+			expectedStaged: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+			expectedCode:   codes.OK,
+		},
+		{
+			name: "all locations fail",
+			errs: map[string]error{
+				"10.0.0.1": status.Error(codes.Internal, "mount failed"),
+				"10.0.0.2": status.Error(codes.Internal, "mount failed"),
+				"10.0.0.3": status.Error(codes.Unavailable, "mount failed"),
+			},
+			expectedStaged: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+			expectedCode:   codes.Unavailable,
+		},
+		{
+			name: "no failover on invalid request",
+			errs: map[string]error{
+				"10.0.0.1": status.Error(codes.InvalidArgument, "missing staging path"),
+			},
+			expectedStaged: []string{"10.0.0.1"},
+			expectedCode:   codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range ts {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &fakeStageNodeClient{errs: tt.errs}
+			req := &csi.NodeStageVolumeRequest{VolumeId: "vol"}
+
+			_, err := stageWithFailover(context.Background(), c, req, volumeCtxs)
+			if code := status.Code(err); code != tt.expectedCode {
+				t.Fatalf("unexpected error code: got %s, expected %s: %v", code, tt.expectedCode, err)
+			}
+
+			if len(c.staged) != len(tt.expectedStaged) {
+				t.Fatalf("unexpected stage attempts: got %v, expected %v", c.staged, tt.expectedStaged)
+			}
+			for i := range tt.expectedStaged {
+				if c.staged[i] != tt.expectedStaged[i] {
+					t.Fatalf("unexpected stage attempts: got %v, expected %v", c.staged, tt.expectedStaged)
+				}
+			}
+
+			// The request carries the volume context of the last attempt
+			last := tt.expectedStaged[len(tt.expectedStaged)-1]
+			if server := req.GetVolumeContext()["server"]; server != last {
+				t.Errorf("unexpected volume context: got server %s, expected %s", server, last)
+			}
+		})
+	}
+}
+
+type fakeExportLocationsClient struct {
+	fakeKeyRotationClient
+
+	locations []shares.ExportLocation
+}
+
+func (c *fakeExportLocationsClient) GetExportLocations(shareID string) ([]shares.ExportLocation, error) {
+	return c.locations, nil
+}
+
+type fakeExportLocationsClientBuilder struct {
+	c *fakeExportLocationsClient
+}
+
+func (b fakeExportLocationsClientBuilder) New(*client.AuthOpts) (manilaclient.Interface, error) {
+	return b.c, nil
+}
+
+// fakeRoutingNodeClient records the Node Service RPCs forwarded to the endpoint of a proxied CSI Node Plugin
+type fakeRoutingNodeClient struct {
+	csiclient.Node
+
+	endpoint string
+	calls    *[]string
+}
+
+func (c fakeRoutingNodeClient) StageVolume(_ context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+	*c.calls = append(*c.calls, "stage "+c.endpoint+" "+req.GetVolumeContext()["server"])
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+func (c fakeRoutingNodeClient) UnstageVolume(context.Context, *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	*c.calls = append(*c.calls, "unstage "+c.endpoint)
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
+func (c fakeRoutingNodeClient) PublishVolume(context.Context, *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	*c.calls = append(*c.calls, "publish "+c.endpoint)
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+type fakeRoutingCSIClientBuilder struct {
+	csiclient.Builder
+
+	calls *[]string
+}
+
+func (b fakeRoutingCSIClientBuilder) NewConnectionWithContext(_ context.Context, endpoint string) (*grpc.ClientConn, error) {
+	return grpc.Dial(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+}
+
+func (b fakeRoutingCSIClientBuilder) NewNodeServiceClient(conn *grpc.ClientConn) csiclient.Node {
+	return fakeRoutingNodeClient{endpoint: conn.Target(), calls: b.calls}
+}
+
+func TestNodeStageVolumeProtocolFallback(t *testing.T) {
+	c := &fakeExportLocationsClient{
+		fakeKeyRotationClient: fakeKeyRotationClient{
+			share:  shares.Share{ID: "share", ShareProto: "NFS", Status: shareAvailable},
+			rights: []shares.AccessRight{{ID: "access", AccessType: "ip", AccessTo: "10.0.0.0/24", AccessLevel: "rw", State: "active"}},
+		},
+		locations: []shares.ExportLocation{{Path: "10.0.0.1:/share"}},
+	}
+
+	policy, err := manilautil.ParseExportLocationPolicy(manilautil.DefaultExportLocationPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls []string
+	d := &Driver{
+		shareProto:           "CEPHFS",
+		fwdEndpoint:          "unix:///cephfs.sock",
+		fallbackFwdEndpoints: map[string]string{"NFS": "unix:///nfs.sock"},
+		exportLocationPolicy: policy,
+		manilaClientBuilder:  fakeExportLocationsClientBuilder{c},
+		csiClientBuilder:     fakeRoutingCSIClientBuilder{calls: &calls},
+	}
+	ns := &nodeServer{d: d, supportsNodeStage: true, nodeStageCache: make(map[volumeID]stageCacheEntry)}
+
+	secrets := map[string]string{"os-authURL": "https://keystone", "os-userName": "admin", "os-password": "secret", "os-projectName": "admin", "os-domainName": "default", "os-region": "RegionOne"}
+	capability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+	}
+	volumeCtx := map[string]string{"shareID": "share", "shareAccessID": "access"}
+
+	if _, err = ns.NodeStageVolume(context.TODO(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "share",
+		StagingTargetPath: "/staging",
+		VolumeCapability:  capability,
+		VolumeContext:     volumeCtx,
+		Secrets:           secrets,
+	}); err != nil {
+		t.Fatalf("failed to stage the NFS share: %v", err)
+	}
+
+	if _, err = ns.NodePublishVolume(context.TODO(), &csi.NodePublishVolumeRequest{
+		VolumeId:          "share",
+		StagingTargetPath: "/staging",
+		TargetPath:        "/target",
+		VolumeCapability:  capability,
+		VolumeContext:     volumeCtx,
+		Secrets:           secrets,
+	}); err != nil {
+		t.Fatalf("failed to publish the NFS share: %v", err)
+	}
+
+	if _, err = ns.NodeUnstageVolume(context.TODO(), &csi.NodeUnstageVolumeRequest{VolumeId: "share", StagingTargetPath: "/staging"}); err != nil {
+		t.Fatalf("failed to unstage the NFS share: %v", err)
+	}
+
+	// Without the stage cache, e.g. after a restart, the volume is unstaged by all the proxied plugins
+	if _, err = ns.NodeUnstageVolume(context.TODO(), &csi.NodeUnstageVolumeRequest{VolumeId: "share", StagingTargetPath: "/staging"}); err != nil {
+		t.Fatalf("failed to unstage the NFS share: %v", err)
+	}
+
+	expected := []string{
+		"stage unix:///nfs.sock 10.0.0.1",
+		"publish unix:///nfs.sock",
+		"unstage unix:///nfs.sock",
+		"unstage unix:///cephfs.sock",
+		"unstage unix:///nfs.sock",
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected the RPCs %v, got %v", expected, calls)
+	}
+
+	// A protocol without fallback endpoint can't be staged
+	delete(d.fallbackFwdEndpoints, "NFS")
+	_, err = ns.NodeStageVolume(context.TODO(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "share",
+		StagingTargetPath: "/staging",
+		VolumeCapability:  capability,
+		VolumeContext:     volumeCtx,
+		Secrets:           secrets,
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for a protocol which isn't served, got %v", err)
+	}
+}
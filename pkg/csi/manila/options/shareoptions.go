@@ -21,12 +21,50 @@ import (
 )
 
 type ControllerVolumeContext struct {
-	Protocol            string `name:"protocol" matches:"^(?i)CEPHFS|NFS$"`
+	Protocol            string `name:"protocol" matches:"^\\w+$"`
 	Type                string `name:"type" value:"default:default"`
-	ShareNetworkID      string `name:"shareNetworkID" value:"optional"`
+	ShareNetworkID      string `name:"shareNetworkID" value:"optional" precludes:"shareNetworkName"`
+	ShareNetworkName    string `name:"shareNetworkName" value:"optional" precludes:"shareNetworkID"`
 	AutoTopology        string `name:"autoTopology" value:"default:false" matches:"(?i)^true|false$"`
 	AvailabilityZone    string `name:"availability" value:"optional"`
 	AppendShareMetadata string `name:"appendShareMetadata" value:"optional"`
+	// ShareMetadataLabels is a comma-separated list of the PVC labels propagated to the share metadata.
+	ShareMetadataLabels string `name:"shareMetadataLabels" value:"optional"`
+	// AutoTopologyZoneMap is a comma-separated list of <node AZ>:<Manila AZ>[:<share type>] entries
+	// mapping the availability zones of the nodes to the shares provisioned with autoTopology.
+	AutoTopologyZoneMap string `name:"autoTopologyZoneMap" value:"optional"`
+	// ReplicaAvailability is the availability zone of a replica of the share.
+	ReplicaAvailability string `name:"replicaAvailability" value:"optional"`
+	// SecurityServiceID is a security service associated with the share network before the share is created.
+	SecurityServiceID string `name:"securityServiceID" value:"optional" dependsOn:"shareNetworkID|shareNetworkName"`
+	// ProtocolFallback is a comma-separated list of share protocols tried in order when creating a share.
+	ProtocolFallback string `name:"protocolFallback" value:"optional" matches:"^\\s*\\w+\\s*(,\\s*\\w+\\s*)*$"`
+	// ShareWaitTimeout is how long to wait for the share to become available, e.g. "5m".
+	// Overrides the --share-wait-timeout flag of the plugin.
+	ShareWaitTimeout string `name:"shareWaitTimeout" value:"optional"`
+	// AutoExpansionThreshold is the percentage of the capacity used above which the volume is expanded automatically.
+	AutoExpansionThreshold string `name:"autoExpansionThreshold" value:"optional"`
+	// AutoExpansionStep is the size added by an automatic expansion, a quantity or a percentage of the capacity.
+	AutoExpansionStep string `name:"autoExpansionStep" value:"optional"`
+	// AutoExpansionMaxSize is the size the volume is never automatically expanded beyond.
+	AutoExpansionMaxSize string `name:"autoExpansionMaxSize" value:"optional"`
+	// ShareGroupID is the share group the share is created in, allowing its snapshot along with the other shares
+	// of the group in a volume group snapshot.
+	ShareGroupID string `name:"shareGroupID" value:"optional"`
+	// SchedulerHintSameHost is a comma-separated list of the shares whose backend the share is placed on.
+	SchedulerHintSameHost string `name:"schedulerHintSameHost" value:"optional"`
+	// SchedulerHintDifferentHost is a comma-separated list of the shares whose backends the share is kept off.
+	SchedulerHintDifferentHost string `name:"schedulerHintDifferentHost" value:"optional"`
+	// MinShareSize is the minimum size of the shares, e.g. "10Gi".
+	MinShareSize string `name:"minShareSize" value:"optional"`
+	// ShareSizeGranularity is the size the shares are a multiple of, e.g. "4Gi".
+	ShareSizeGranularity string `name:"shareSizeGranularity" value:"optional"`
+	// ShareSizePolicy is whether the requested sizes below minShareSize or not a multiple of shareSizeGranularity
+	// are rounded up or rejected.
+	ShareSizePolicy string `name:"shareSizePolicy" value:"default:round" matches:"^(round|reject)$"`
+	// EncryptionKeyRef is the Barbican secret, or the secret container holding it, the share is encrypted with,
+	// either a UUID or an href.
+	EncryptionKeyRef string `name:"encryptionKeyRef" value:"optional"`
 
 	// Adapter options
 
@@ -34,19 +72,40 @@ type ControllerVolumeContext struct {
 	CephfsClientID           string `name:"cephfs-clientID" value:"optional"`
 	CephfsKernelMountOptions string `name:"cephfs-kernelMountOptions" value:"optional"`
 	CephfsFuseMountOptions   string `name:"cephfs-fuseMountOptions" value:"optional"`
-	NFSShareClient           string `name:"nfs-shareClient" value:"default:0.0.0.0/0"`
+	// NFSShareClient is a comma-separated list of the client IPs or CIDRs granted access to NFS shares.
+	NFSShareClient string `name:"nfs-shareClient" value:"default:0.0.0.0/0"`
+	// NFSAccessType is the type of the NFS access rule, "user" rules grant access to a Kerberos principal.
+	NFSAccessType string `name:"nfs-accessType" value:"default:ip" matches:"^(ip|user)$"`
+	NFSShareUser  string `name:"nfs-shareUser" value:"requiredIf:nfs-accessType=^user$"`
+	// NFSNodeAccess grants access to the nodes the volume is attached to instead of nfs-shareClient.
+	NFSNodeAccess string `name:"nfs-nodeAccess" value:"default:false" matches:"(?i)^true|false$"`
+	// NFSNodeCIDRs is a comma-separated list of CIDRs restricting the node addresses granted access with nfs-nodeAccess.
+	NFSNodeCIDRs string `name:"nfs-nodeCIDRs" value:"optional"`
+	// CifsShareUser is the user granted access to CIFS shares.
+	CifsShareUser string `name:"cifs-shareUser" value:"requiredIf:protocol=^(?i)CIFS$"`
+	// CephfsAccessKeyTimeout is how long to wait for the cephx key of the access right, e.g. "5m".
+	// Overrides the --access-key-wait-timeout flag of the plugin.
+	CephfsAccessKeyTimeout string `name:"cephfs-accessKeyTimeout" value:"optional"`
 }
 
 type NodeVolumeContext struct {
 	ShareID       string `name:"shareID" value:"optionalIf:shareName=." precludes:"shareName"`
 	ShareName     string `name:"shareName" value:"optionalIf:shareID=." precludes:"shareID"`
-	ShareAccessID string `name:"shareAccessID"`
+	ShareAccessID string `name:"shareAccessID" value:"optionalIf:readOnlyAccessTo=." precludes:"readOnlyAccessTo"`
+	// ReadOnlyAccessTo is the cephx ID or client CIDR of a read-only access right granted by the node plugin,
+	// e.g. to consume a share provisioned by another cluster.
+	ReadOnlyAccessTo string `name:"readOnlyAccessTo" value:"optionalIf:shareAccessID=." precludes:"shareAccessID"`
+	// SubPathPattern is a text/template of a directory inside the share which is published instead of the whole share.
+	SubPathPattern string `name:"subPathPattern" value:"optional"`
+	// ExportLocationPolicy ranks the export locations the share is mounted with, overriding the policy of the node plugin.
+	ExportLocationPolicy string `name:"exportLocationPolicy" value:"optional" matches:"^\\s*(preferred|zone|cidr:[0-9a-fA-F.:/]+)\\s*(,\\s*(preferred|zone|cidr:[0-9a-fA-F.:/]+)\\s*)*$"`
 
 	// Adapter options
 
 	CephfsMounter            string `name:"cephfs-mounter" value:"default:fuse" matches:"^kernel|fuse$"`
 	CephfsKernelMountOptions string `name:"cephfs-kernelMountOptions" value:"optional"`
 	CephfsFuseMountOptions   string `name:"cephfs-fuseMountOptions" value:"optional"`
+	NFSSecurity              string `name:"nfs-security" value:"optional" matches:"^(krb5|krb5i|krb5p)$"`
 }
 
 var (
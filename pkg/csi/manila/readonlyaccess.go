@@ -0,0 +1,121 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	"k8s.io/klog/v2"
+)
+
+// Read-only access rights let a share provisioned in one cluster be consumed by another one, through a pre-provisioned
+// volume referencing the share with the readOnlyAccessTo volume context parameter instead of shareAccessID. The node
+// plugin of the consuming cluster grants the access right itself, with its own cephx ID or client CIDR, so that the
+// credentials of the provisioning cluster are never shared.
+
+const (
+	accessLevelReadOnly  = "ro"
+	accessLevelReadWrite = "rw"
+)
+
+// accessLevelForCapabilities returns the access level of the access right granted to a provisioned volume, read-only
+// if all of its capabilities have a read-only access mode.
+func accessLevelForCapabilities(volCaps []*csi.VolumeCapability) string {
+	if len(volCaps) == 0 {
+		return accessLevelReadWrite
+	}
+
+	for _, volCap := range volCaps {
+		switch volCap.GetAccessMode().GetMode() {
+		case csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY, csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY:
+		default:
+			return accessLevelReadWrite
+		}
+	}
+
+	return accessLevelReadOnly
+}
+
+// readOnlyAccessType returns the type of the access rights granted to readOnlyAccessTo for the share protocol.
+func readOnlyAccessType(shareProto string) string {
+	if strings.EqualFold(shareProto, "CEPHFS") {
+		return "cephx"
+	}
+	if strings.EqualFold(shareProto, "CIFS") {
+		return "user"
+	}
+	return "ip"
+}
+
+// findReadOnlyAccessRight returns the access right of the share for accessTo, or nil if there is none. It fails if
+// accessTo was granted another access level.
+func findReadOnlyAccessRight(rights []shares.AccessRight, accessType, accessTo string) (*shares.AccessRight, error) {
+	for i := range rights {
+		if rights[i].AccessTo != accessTo || rights[i].AccessType != accessType {
+			continue
+		}
+
+		if rights[i].AccessLevel != accessLevelReadOnly {
+			return nil, fmt.Errorf("%s access right %s for %s has access level %s, expected %s",
+				accessType, rights[i].ID, accessTo, rights[i].AccessLevel, accessLevelReadOnly)
+		}
+
+		return &rights[i], nil
+	}
+
+	return nil, nil
+}
+
+// getOrGrantReadOnlyAccess returns the read-only access right of the share for accessTo, granting it if needed.
+// The cephx key of a new access right is assigned asynchronously, the caller is expected to let the CO retry until
+// it's there.
+func getOrGrantReadOnlyAccess(manilaClient manilaclient.Interface, share *shares.Share, accessTo string, volID volumeID) (*shares.AccessRight, error) {
+	accessType := readOnlyAccessType(share.ShareProto)
+
+	rights, err := manilaClient.GetAccessRights(share.ID)
+	if err != nil {
+		return nil, statusErrorf(codes.Internal, "failed to list access rights for volume %s: %w", volID, err)
+	}
+
+	accessRight, err := findReadOnlyAccessRight(rights, accessType, accessTo)
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "cannot use read-only access for volume %s: %v", volID, err)
+	}
+
+	if accessRight != nil {
+		return accessRight, nil
+	}
+
+	klog.V(4).Infof("granting read-only %s access right for %s to volume %s", accessType, accessTo, volID)
+
+	accessRight, err = manilaClient.GrantAccess(share.ID, shares.GrantAccessOpts{
+		AccessType:  accessType,
+		AccessLevel: accessLevelReadOnly,
+		AccessTo:    accessTo,
+	})
+	if err != nil {
+		return nil, statusErrorf(codes.Internal, "failed to grant read-only access for %s to volume %s: %w", accessTo, volID, err)
+	}
+
+	return accessRight, nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
+)
+
+func TestReadOnlyAccessType(t *testing.T) {
+	if accessType := readOnlyAccessType("CEPHFS"); accessType != "cephx" {
+		t.Errorf("expected cephx access type for CEPHFS, got %s", accessType)
+	}
+	if accessType := readOnlyAccessType("NFS"); accessType != "ip" {
+		t.Errorf("expected ip access type for NFS, got %s", accessType)
+	}
+	if accessType := readOnlyAccessType("CIFS"); accessType != "user" {
+		t.Errorf("expected user access type for CIFS, got %s", accessType)
+	}
+}
+
+func TestAccessLevelForCapabilities(t *testing.T) {
+	volCap := func(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		return &csi.VolumeCapability{AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode}}
+	}
+
+	ts := []struct {
+		volCaps  []*csi.VolumeCapability
+		expected string
+	}{
+		{nil, "rw"},
+		{[]*csi.VolumeCapability{volCap(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY)}, "ro"},
+		{[]*csi.VolumeCapability{volCap(csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY), volCap(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY)}, "ro"},
+		{[]*csi.VolumeCapability{volCap(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY), volCap(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)}, "rw"},
+		{[]*csi.VolumeCapability{volCap(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)}, "rw"},
+	}
+
+	for i, tc := range ts {
+		if level := accessLevelForCapabilities(tc.volCaps); level != tc.expected {
+			t.Errorf("test %d: expected access level %s, got %s", i, tc.expected, level)
+		}
+	}
+}
+
+func TestFindReadOnlyAccessRight(t *testing.T) {
+	rights := []shares.AccessRight{
+		{ID: "rw", AccessType: "cephx", AccessTo: "cluster-a", AccessLevel: "rw"},
+		{ID: "ro", AccessType: "cephx", AccessTo: "cluster-b", AccessLevel: "ro"},
+		{ID: "ip", AccessType: "ip", AccessTo: "10.0.0.0/24", AccessLevel: "ro"},
+	}
+
+	ts := []struct {
+		accessType, accessTo string
+		expectedID           string
+		expectedErr          bool
+	}{
+		{"cephx", "cluster-b", "ro", false},
+		{"ip", "10.0.0.0/24", "ip", false},
+		{"cephx", "cluster-c", "", false},
+		{"ip", "cluster-b", "", false},
+		{"cephx", "cluster-a", "", true},
+	}
+
+	for i, tc := range ts {
+		accessRight, err := findReadOnlyAccessRight(rights, tc.accessType, tc.accessTo)
+		if (err != nil) != tc.expectedErr {
+			t.Errorf("test %d: unexpected error %v", i, err)
+			continue
+		}
+
+		id := ""
+		if accessRight != nil {
+			id = accessRight.ID
+		}
+		if id != tc.expectedID {
+			t.Errorf("test %d: expected access right %q, got %q", i, tc.expectedID, id)
+		}
+	}
+}
+
+func TestReadOnlyAccessToVolumeContext(t *testing.T) {
+	if _, err := options.NewNodeVolumeContext(map[string]string{"shareID": "share", "readOnlyAccessTo": "cluster-b"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := options.NewNodeVolumeContext(map[string]string{"shareID": "share", "shareAccessID": "access", "readOnlyAccessTo": "cluster-b"}); err == nil {
+		t.Error("expected shareAccessID and readOnlyAccessTo to preclude each other")
+	}
+	if _, err := options.NewNodeVolumeContext(map[string]string{"shareID": "share"}); err == nil {
+		t.Error("expected either shareAccessID or readOnlyAccessTo to be required")
+	}
+}
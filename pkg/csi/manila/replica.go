@@ -0,0 +1,310 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/replicas"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	clouderrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+// Shares may be replicated to another availability zone for disaster recovery. A replica is requested with the
+// replicaAvailability volume parameter, and promoted with the activeReplicaAvailability mutable parameter of a
+// VolumeAttributesClass, i.e. ControllerModifyVolume. The state of the replicas is optionally reported in the
+// annotations and the events of the PersistentVolume.
+
+const (
+	// activeReplicaAvailabilityParam is the mutable parameter selecting the availability zone of the active replica
+	activeReplicaAvailabilityParam = "activeReplicaAvailability"
+
+	replicaStateActive = "active"
+	replicaStateInSync = "in_sync"
+
+	replicaStatusError             = "error"
+	replicaStatusDeleting          = "deleting"
+	replicaStatusReplicationChange = "replication_change"
+
+	replicaStateAnnotation        = "manila.csi.openstack.org/replica-state"
+	replicaStateMessageAnnotation = "manila.csi.openstack.org/replica-state-message"
+
+	// Values of the replica-state annotation
+	volumeReplicaInSync    = "in-sync"
+	volumeReplicaSyncing   = "syncing"
+	volumeReplicaPromoting = "promoting"
+	volumeReplicaError     = "error"
+
+	replicaPollInterval = 30 * time.Second
+	replicaStateTimeout = time.Hour
+)
+
+// pendingReplicaStates holds the IDs of the shares whose replicas are being awaited
+var pendingReplicaStates = sync.Map{}
+
+// getOrCreateShareReplica returns the replica of the share in the availability zone, creating it if it doesn't exist.
+func getOrCreateShareReplica(manilaClient manilaclient.Interface, share *shares.Share, availability string) (*replicas.Replica, error) {
+	rs, err := manilaClient.GetShareReplicas(share.ID)
+	if err != nil {
+		return nil, statusErrorf(codes.Internal, "failed to list replicas of volume %s: %w", share.ID, err)
+	}
+
+	for i := range rs {
+		if rs[i].AvailabilityZone != availability {
+			continue
+		}
+
+		if rs[i].State == replicaStateActive {
+			return nil, status.Errorf(codes.InvalidArgument, "volume %s is already in availability zone %s, the replica must be in another one", share.ID, availability)
+		}
+
+		klog.V(4).Infof("replica %s of volume %s in availability zone %s already exists", rs[i].ID, share.ID, availability)
+		return &rs[i], nil
+	}
+
+	replica, err := manilaClient.CreateShareReplica(replicas.CreateOpts{
+		ShareID:          share.ID,
+		AvailabilityZone: availability,
+	})
+	if err != nil {
+		return nil, statusErrorf(codes.Internal, "failed to create replica of volume %s in availability zone %s: %w", share.ID, availability, err)
+	}
+
+	klog.V(4).Infof("created replica %s of volume %s in availability zone %s", replica.ID, share.ID, availability)
+
+	return replica, nil
+}
+
+// promoteShareReplica makes the replica of the share in the availability zone the active one, returning the
+// promoted replica. It's a no-op returning nil if the replica is already active.
+func promoteShareReplica(manilaClient manilaclient.Interface, shareID, availability string) (*replicas.Replica, error) {
+	rs, err := manilaClient.GetShareReplicas(shareID)
+	if err != nil {
+		return nil, statusErrorf(codes.Internal, "failed to list replicas of volume %s: %w", shareID, err)
+	}
+
+	var replica *replicas.Replica
+	for i := range rs {
+		if rs[i].AvailabilityZone == availability {
+			replica = &rs[i]
+			break
+		}
+	}
+
+	switch {
+	case replica == nil:
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s has no replica in availability zone %s", shareID, availability)
+	case replica.State == replicaStateActive:
+		return nil, nil
+	case replica.Status == replicaStatusReplicationChange:
+		return nil, status.Errorf(codes.Unavailable, "replica %s of volume %s is being promoted", replica.ID, shareID)
+	case replica.State != replicaStateInSync:
+		return nil, status.Errorf(codes.FailedPrecondition, "replica %s of volume %s is %s, only in_sync replicas can be promoted", replica.ID, shareID, coalesceValue(replica.State))
+	}
+
+	if err := manilaClient.PromoteShareReplica(replica.ID); err != nil {
+		return nil, statusErrorf(codes.Internal, "failed to promote replica %s of volume %s: %w", replica.ID, shareID, err)
+	}
+
+	klog.V(4).Infof("promoting replica %s of volume %s in availability zone %s", replica.ID, shareID, availability)
+
+	return replica, nil
+}
+
+// deleteShareReplicas deletes all the replicas of the share but the active one, as Manila refuses to delete
+// a share with replicas. It fails with Unavailable until they are deleted.
+func deleteShareReplicas(manilaClient manilaclient.Interface, shareID string) error {
+	share, err := manilaClient.GetShareByID(shareID)
+	if err != nil {
+		if clouderrors.IsNotFound(err) {
+			return nil
+		}
+		return statusErrorf(codes.Internal, "failed to retrieve volume %s: %w", shareID, err)
+	}
+
+	if !share.HasReplicas {
+		return nil
+	}
+
+	rs, err := manilaClient.GetShareReplicas(shareID)
+	if err != nil {
+		return statusErrorf(codes.Internal, "failed to list replicas of volume %s: %w", shareID, err)
+	}
+
+	remaining := 0
+	for _, r := range rs {
+		if r.State == replicaStateActive {
+			continue
+		}
+
+		remaining++
+
+		if r.Status == replicaStatusDeleting {
+			continue
+		}
+
+		if err := manilaClient.DeleteShareReplica(r.ID); err != nil && !clouderrors.IsNotFound(err) {
+			return statusErrorf(codes.Internal, "failed to delete replica %s of volume %s: %w", r.ID, shareID, err)
+		}
+	}
+
+	if remaining > 0 {
+		return status.Errorf(codes.Unavailable, "waiting for %d replica(s) of volume %s to be deleted", remaining, shareID)
+	}
+
+	return nil
+}
+
+// replicasState returns the replica-state annotation of the PV and its message for the replicas of its share.
+func replicasState(rs []replicas.Replica) (string, string) {
+	var (
+		active                     string
+		failed, promoting, syncing []string
+	)
+
+	for _, r := range rs {
+		desc := fmt.Sprintf("%s (%s)", r.ID, r.AvailabilityZone)
+
+		switch {
+		case r.Status == replicaStatusError:
+			failed = append(failed, desc)
+		case r.Status == replicaStatusReplicationChange:
+			promoting = append(promoting, desc)
+		case r.State == replicaStateActive:
+			active = desc
+		case r.State != replicaStateInSync:
+			syncing = append(syncing, fmt.Sprintf("%s is %s", desc, coalesceValue(r.State)))
+		}
+	}
+
+	switch {
+	case len(failed) > 0:
+		return volumeReplicaError, "replicas in error state: " + strings.Join(failed, ", ")
+	case len(promoting) > 0:
+		return volumeReplicaPromoting, "promoting replicas: " + strings.Join(promoting, ", ")
+	case len(syncing) > 0:
+		return volumeReplicaSyncing, "replicas not in sync: " + strings.Join(syncing, ", ")
+	default:
+		return volumeReplicaInSync, "active replica: " + coalesceValue(active)
+	}
+}
+
+// withReplicaTopology returns the accessible topology of a volume whose share is replicated to the availability
+// zone, adding the zone so that the pods may run there once the replica is promoted. The availability zone of the
+// replica is assumed to be named after the zone of the nodes.
+func withReplicaTopology(topology []*csi.Topology, availability string) []*csi.Topology {
+	if topology == nil {
+		return nil
+	}
+
+	for _, t := range topology {
+		if len(t.GetSegments()) == 1 && t.GetSegments()[topologyKey] == availability {
+			return topology
+		}
+	}
+
+	return append(topology, &csi.Topology{Segments: map[string]string{topologyKey: availability}})
+}
+
+// replicaStateEvent returns the type and the reason of the PersistentVolume event reporting the replica state.
+func replicaStateEvent(state string) (string, string) {
+	switch state {
+	case volumeReplicaError:
+		return corev1.EventTypeWarning, "ReplicaError"
+	case volumeReplicaPromoting:
+		return corev1.EventTypeNormal, "ReplicaPromoting"
+	case volumeReplicaSyncing:
+		return corev1.EventTypeNormal, "ReplicaSyncing"
+	default:
+		return corev1.EventTypeNormal, "ReplicaInSync"
+	}
+}
+
+// pvReference returns the reference to the PersistentVolume the events are recorded for.
+func pvReference(pvName string) *corev1.ObjectReference {
+	return &corev1.ObjectReference{Kind: "PersistentVolume", APIVersion: "v1", Name: pvName}
+}
+
+// awaitReplicas waits in the background for the replicas of the share to be in sync, annotating the PV of
+// the volume with their state and recording an event at each change. It's a no-op unless the replica states are
+// reported.
+func (cs *controllerServer) awaitReplicas(manilaClient manilaclient.Interface, shareID, pvName string) {
+	kubeClient := cs.d.replicaStateKubeClient
+	if kubeClient == nil {
+		return
+	}
+
+	if _, isPending := pendingReplicaStates.LoadOrStore(shareID, true); isPending {
+		return
+	}
+
+	go func() {
+		defer pendingReplicaStates.Delete(shareID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), replicaStateTimeout)
+		defer cancel()
+
+		var annotated string
+		err := wait.PollUntilContextCancel(ctx, replicaPollInterval, true, func(ctx context.Context) (bool, error) {
+			rs, err := manilaClient.GetShareReplicas(shareID)
+			if err != nil {
+				if clouderrors.IsNotFound(err) {
+					// The volume was deleted in the meantime
+					return true, nil
+				}
+				klog.V(4).Infof("failed to list replicas of share %s: %v", shareID, err)
+				return false, nil
+			}
+
+			state, message := replicasState(rs)
+			if state != annotated {
+				err := patchPVAnnotations(ctx, kubeClient, pvName, map[string]string{
+					replicaStateAnnotation:        state,
+					replicaStateMessageAnnotation: message,
+				})
+				if err != nil {
+					if !apierrors.IsNotFound(err) {
+						klog.Warningf("failed to annotate PersistentVolume %s with replica state %s: %v", pvName, state, err)
+					}
+					return false, nil
+				}
+				annotated = state
+
+				eventType, reason := replicaStateEvent(state)
+				cs.d.replicaEventRecorder.Event(pvReference(pvName), eventType, reason, message)
+			}
+
+			return state == volumeReplicaInSync || state == volumeReplicaError, nil
+		})
+
+		if err != nil {
+			klog.Warningf("replicas of share %s are still %s after %v", shareID, annotated, replicaStateTimeout)
+		}
+	}()
+}
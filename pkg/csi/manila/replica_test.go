@@ -0,0 +1,261 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/replicas"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+)
+
+// fakeReplicaClient serves the share and its replicas and records the replicas created, deleted and promoted.
+type fakeReplicaClient struct {
+	manilaclient.Interface
+	share    *shares.Share
+	replicas []replicas.Replica
+
+	created  []string
+	deleted  []string
+	promoted []string
+}
+
+func (c *fakeReplicaClient) GetShareByID(shareID string) (*shares.Share, error) {
+	if c.share == nil {
+		return nil, gophercloud.ErrDefault404{}
+	}
+	return c.share, nil
+}
+
+func (c *fakeReplicaClient) GetShareReplicas(shareID string) ([]replicas.Replica, error) {
+	return c.replicas, nil
+}
+
+func (c *fakeReplicaClient) CreateShareReplica(opts replicas.CreateOptsBuilder) (*replicas.Replica, error) {
+	availability := opts.(replicas.CreateOpts).AvailabilityZone
+	c.created = append(c.created, availability)
+	return &replicas.Replica{ID: "new", AvailabilityZone: availability}, nil
+}
+
+func (c *fakeReplicaClient) DeleteShareReplica(replicaID string) error {
+	c.deleted = append(c.deleted, replicaID)
+	return nil
+}
+
+func (c *fakeReplicaClient) PromoteShareReplica(replicaID string) error {
+	c.promoted = append(c.promoted, replicaID)
+	return nil
+}
+
+var testReplicas = []replicas.Replica{
+	{ID: "r1", AvailabilityZone: "az-1", Status: "available", State: "active"},
+	{ID: "r2", AvailabilityZone: "az-2", Status: "available", State: "in_sync"},
+	{ID: "r3", AvailabilityZone: "az-3", Status: "available", State: "out_of_sync"},
+}
+
+func TestGetOrCreateShareReplica(t *testing.T) {
+	share := &shares.Share{ID: "share"}
+
+	c := &fakeReplicaClient{replicas: testReplicas}
+	if r, err := getOrCreateShareReplica(c, share, "az-2"); err != nil || r.ID != "r2" {
+		t.Errorf("expected existing replica r2, got %v, %v", r, err)
+	}
+	if _, err := getOrCreateShareReplica(c, share, "az-1"); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for the availability zone of the active replica, got %v", err)
+	}
+	if r, err := getOrCreateShareReplica(c, share, "az-4"); err != nil || r.AvailabilityZone != "az-4" {
+		t.Errorf("expected new replica in az-4, got %v, %v", r, err)
+	}
+	if !reflect.DeepEqual(c.created, []string{"az-4"}) {
+		t.Errorf("expected a replica to be created in az-4, got %v", c.created)
+	}
+}
+
+func TestPromoteShareReplica(t *testing.T) {
+	ts := []struct {
+		availability string
+		expectedCode codes.Code
+		promoted     []string
+	}{
+		{"az-1", codes.OK, nil},
+		{"az-2", codes.OK, []string{"r2"}},
+		{"az-3", codes.FailedPrecondition, nil},
+		{"az-4", codes.FailedPrecondition, nil},
+	}
+
+	for _, tc := range ts {
+		c := &fakeReplicaClient{replicas: testReplicas}
+		promoted, err := promoteShareReplica(c, "share", tc.availability)
+		if status.Code(err) != tc.expectedCode {
+			t.Errorf("%s: expected code %v, got %v", tc.availability, tc.expectedCode, err)
+		}
+		if !reflect.DeepEqual(c.promoted, tc.promoted) {
+			t.Errorf("%s: expected promoted replicas %v, got %v", tc.availability, tc.promoted, c.promoted)
+		}
+		if (promoted != nil) != (tc.promoted != nil) {
+			t.Errorf("%s: expected the promoted replica to be returned, got %v", tc.availability, promoted)
+		}
+	}
+
+	c := &fakeReplicaClient{replicas: []replicas.Replica{{ID: "r2", AvailabilityZone: "az-2", Status: "replication_change", State: "in_sync"}}}
+	if _, err := promoteShareReplica(c, "share", "az-2"); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable while the replica is being promoted, got %v", err)
+	}
+}
+
+func TestDeleteShareReplicas(t *testing.T) {
+	c := &fakeReplicaClient{}
+	if err := deleteShareReplicas(c, "share"); err != nil {
+		t.Errorf("unexpected error for a deleted share: %v", err)
+	}
+
+	c = &fakeReplicaClient{share: &shares.Share{ID: "share"}, replicas: testReplicas}
+	if err := deleteShareReplicas(c, "share"); err != nil || c.deleted != nil {
+		t.Errorf("expected the replicas of a share without replicas to be ignored, got %v, deleted %v", err, c.deleted)
+	}
+
+	c = &fakeReplicaClient{share: &shares.Share{ID: "share", HasReplicas: true}, replicas: append([]replicas.Replica{
+		{ID: "r4", AvailabilityZone: "az-4", Status: "deleting", State: "out_of_sync"},
+	}, testReplicas...)}
+	if err := deleteShareReplicas(c, "share"); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable while the replicas are deleted, got %v", err)
+	}
+	if !reflect.DeepEqual(c.deleted, []string{"r2", "r3"}) {
+		t.Errorf("expected replicas r2 and r3 to be deleted, got %v", c.deleted)
+	}
+
+	c = &fakeReplicaClient{share: &shares.Share{ID: "share", HasReplicas: true}, replicas: testReplicas[:1]}
+	if err := deleteShareReplicas(c, "share"); err != nil {
+		t.Errorf("unexpected error with only the active replica left: %v", err)
+	}
+}
+
+func TestReplicasState(t *testing.T) {
+	ts := []struct {
+		replicas      []replicas.Replica
+		expectedState string
+	}{
+		{testReplicas[:2], volumeReplicaInSync},
+		{testReplicas, volumeReplicaSyncing},
+		{append([]replicas.Replica{{ID: "r4", Status: "replication_change", State: "in_sync"}}, testReplicas...), volumeReplicaPromoting},
+		{append([]replicas.Replica{{ID: "r4", Status: "error", State: "error"}}, testReplicas...), volumeReplicaError},
+	}
+
+	for i, tc := range ts {
+		if state, message := replicasState(tc.replicas); state != tc.expectedState {
+			t.Errorf("test %d: expected state %s, got %s (%s)", i, tc.expectedState, state, message)
+		}
+	}
+}
+
+func TestWithReplicaTopology(t *testing.T) {
+	if topology := withReplicaTopology(nil, "az-2"); topology != nil {
+		t.Errorf("expected no topology without topology awareness, got %v", topology)
+	}
+
+	topology := withReplicaTopology([]*csi.Topology{{Segments: map[string]string{topologyKey: "az-1"}}}, "az-2")
+	expected := []*csi.Topology{
+		{Segments: map[string]string{topologyKey: "az-1"}},
+		{Segments: map[string]string{topologyKey: "az-2"}},
+	}
+	if !reflect.DeepEqual(topology, expected) {
+		t.Errorf("expected topology %v, got %v", expected, topology)
+	}
+
+	if topology := withReplicaTopology(expected, "az-2"); len(topology) != 2 {
+		t.Errorf("expected the zone of the replica not to be added twice, got %v", topology)
+	}
+}
+
+func TestAwaitReplicas(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset(&corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv"}})
+	recorder := record.NewFakeRecorder(10)
+	cs := &controllerServer{d: &Driver{replicaStateKubeClient: kubeClient, replicaEventRecorder: recorder}}
+
+	rs := append([]replicas.Replica{{ID: "r4", AvailabilityZone: "az-4", Status: "error", State: "error"}}, testReplicas...)
+	cs.awaitReplicas(&fakeReplicaClient{replicas: rs}, "share", "pv")
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Warning ReplicaError") {
+			t.Errorf("expected a ReplicaError warning, got %s", event)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no event recorded for the replica state")
+	}
+
+	pv, err := kubeClient.CoreV1().PersistentVolumes().Get(context.TODO(), "pv", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := pv.Annotations[replicaStateAnnotation]; state != volumeReplicaError {
+		t.Errorf("expected replica state %s, got %s", volumeReplicaError, state)
+	}
+}
+
+func TestValidateControllerModifyVolumeRequest(t *testing.T) {
+	secrets := map[string]string{"os-authURL": "http://keystone"}
+
+	ts := []struct {
+		params map[string]string
+		valid  bool
+	}{
+		{map[string]string{activeReplicaAvailabilityParam: "az-2"}, true},
+		{map[string]string{activeReplicaAvailabilityParam: ""}, false},
+		{map[string]string{"type": "gold"}, false},
+		{nil, false},
+	}
+
+	for _, tc := range ts {
+		err := validateControllerModifyVolumeRequest(&csi.ControllerModifyVolumeRequest{VolumeId: "share", MutableParameters: tc.params, Secrets: secrets})
+		if (err == nil) != tc.valid {
+			t.Errorf("%v: expected valid=%t, got %v", tc.params, tc.valid, err)
+		}
+	}
+}
+
+func TestExportLocationZones(t *testing.T) {
+	share := &shares.Share{ID: "share", AvailabilityZone: "az-1"}
+	c := &fakeReplicaClient{replicas: testReplicas}
+
+	zoneOf := exportLocationZones(c, share)
+	if zone := zoneOf(&shares.ExportLocation{ShareInstanceID: "r2"}); zone != "az-1" {
+		t.Errorf("expected the zone of a share without replication, got %s", zone)
+	}
+
+	share.ReplicationType = "writable"
+	zoneOf = exportLocationZones(c, share)
+	if zone := zoneOf(&shares.ExportLocation{ShareInstanceID: "r2"}); zone != "az-2" {
+		t.Errorf("expected the zone of replica r2, got %s", zone)
+	}
+	if zone := zoneOf(&shares.ExportLocation{ShareInstanceID: "unknown"}); zone != "az-1" {
+		t.Errorf("expected the zone of the share for an unknown instance, got %s", zone)
+	}
+}
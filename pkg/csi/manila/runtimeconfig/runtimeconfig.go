@@ -27,7 +27,8 @@ var (
 )
 
 type RuntimeConfig struct {
-	Nfs *NfsConfig `json:"nfs,omitempty"`
+	Nfs      *NfsConfig      `json:"nfs,omitempty"`
+	Topology *TopologyConfig `json:"topology,omitempty"`
 }
 
 func Get() (*RuntimeConfig, error) {
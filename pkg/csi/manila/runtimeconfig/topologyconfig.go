@@ -0,0 +1,31 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runtimeconfig
+
+type TopologyConfig struct {
+	// Maps the availability zones of the nodes to the Manila availability zones
+	// and share types of the shares provisioned with autoTopology for them.
+	// Used when the StorageClass doesn't set autoTopologyZoneMap.
+	ZoneMap map[string]ZoneMapping `json:"zoneMap,omitempty"`
+}
+
+type ZoneMapping struct {
+	// Manila availability zone of the shares. Defaults to the availability zone of the node.
+	AvailabilityZone string `json:"availabilityZone,omitempty"`
+	// Share type of the shares, overriding the type volume parameter. Optional.
+	ShareType string `json:"shareType,omitempty"`
+}
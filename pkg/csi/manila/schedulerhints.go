@@ -0,0 +1,135 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
+	"k8s.io/klog/v2"
+)
+
+// SchedulerHintAnnotationsOpts configures the scheduler hints read from the annotations of the
+// PersistentVolumeClaims of new volumes.
+type SchedulerHintAnnotationsOpts struct {
+	Enabled bool
+	// KubeClient is used to look up the PersistentVolumeClaims and their volumes.
+	KubeClient kubernetes.Interface
+}
+
+const (
+	// Comma-separated lists of the PersistentVolumeClaims, in the namespace of the annotated one,
+	// whose shares are on the backend the new share is placed on, or kept off
+	sameHostAnnotation      = "manila.csi.openstack.org/same-host-as"
+	differentHostAnnotation = "manila.csi.openstack.org/different-host-from"
+
+	// Manila availability zone of the new share, when the StorageClass doesn't set it
+	availabilityAnnotation = "manila.csi.openstack.org/availability"
+)
+
+func splitShareIDs(value string) []string {
+	var ids []string
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// addAnnotationSchedulerHints adds the scheduler hints of the annotations of the PersistentVolumeClaim
+// of a new volume to shareOpts. The PersistentVolumeClaim is only known when the csi-provisioner runs
+// with --extra-create-metadata, otherwise the annotations are ignored.
+func (cs *controllerServer) addAnnotationSchedulerHints(ctx context.Context, shareOpts *options.ControllerVolumeContext, params map[string]string) error {
+	if !cs.d.schedulerHintAnnotations.Enabled {
+		return nil
+	}
+
+	name, namespace := params[pvcNameMetadataKey], params[pvcNamespaceMetadataKey]
+	if name == "" || namespace == "" {
+		klog.V(4).Infof("PersistentVolumeClaim of the volume unknown, ignoring its scheduler hints")
+		return nil
+	}
+
+	kubeClient := cs.d.schedulerHintAnnotations.KubeClient
+
+	pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return status.Errorf(codes.Internal, "failed to get PersistentVolumeClaim %s/%s: %v", namespace, name, err)
+	}
+
+	if az := pvc.Annotations[availabilityAnnotation]; az != "" {
+		if shareOpts.AvailabilityZone != "" || strings.EqualFold(shareOpts.AutoTopology, "true") {
+			return status.Errorf(codes.InvalidArgument, "annotation %s of PersistentVolumeClaim %s/%s conflicts with the availability or autoTopology parameter of its StorageClass",
+				availabilityAnnotation, namespace, name)
+		}
+		shareOpts.AvailabilityZone = az
+	}
+
+	for annotation, hint := range map[string]*string{
+		sameHostAnnotation:      &shareOpts.SchedulerHintSameHost,
+		differentHostAnnotation: &shareOpts.SchedulerHintDifferentHost,
+	} {
+		ids := splitShareIDs(*hint)
+		for _, claimName := range splitShareIDs(pvc.Annotations[annotation]) {
+			shareID, err := cs.claimShareID(ctx, kubeClient, namespace, claimName)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, shareID)
+		}
+		*hint = strings.Join(ids, ",")
+	}
+
+	return nil
+}
+
+// claimShareID returns the ID of the share of the volume bound to a PersistentVolumeClaim.
+func (cs *controllerServer) claimShareID(ctx context.Context, kubeClient kubernetes.Interface, namespace, name string) (string, error) {
+	pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", status.Errorf(codes.InvalidArgument, "scheduler hint PersistentVolumeClaim %s/%s not found", namespace, name)
+		}
+		return "", status.Errorf(codes.Internal, "failed to get scheduler hint PersistentVolumeClaim %s/%s: %v", namespace, name, err)
+	}
+
+	if pvc.Spec.VolumeName == "" {
+		// The volume is awaited, the provisioning is retried
+		return "", status.Errorf(codes.Unavailable, "scheduler hint PersistentVolumeClaim %s/%s is not bound yet", namespace, name)
+	}
+
+	pv, err := kubeClient.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "failed to get PersistentVolume %s of scheduler hint PersistentVolumeClaim %s/%s: %v", pvc.Spec.VolumeName, namespace, name, err)
+	}
+
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != cs.d.name {
+		return "", status.Errorf(codes.InvalidArgument, "scheduler hint PersistentVolumeClaim %s/%s is not a volume of %s", namespace, name, cs.d.name)
+	}
+
+	return pv.Spec.CSI.VolumeHandle, nil
+}
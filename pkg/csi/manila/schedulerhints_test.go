@@ -0,0 +1,129 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
+)
+
+func testHintClaim(name, volumeName string, annotations map[string]string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", Annotations: annotations},
+		Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: volumeName},
+	}
+}
+
+func testHintVolume(name, driver, handle string) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: driver, VolumeHandle: handle},
+			},
+		},
+	}
+}
+
+func TestAddAnnotationSchedulerHints(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset(
+		testHintClaim("db-0", "pv-0", nil),
+		testHintClaim("db-1", "pv-1", nil),
+		testHintClaim("pending", "", nil),
+		testHintClaim("cinder", "pv-cinder", nil),
+		testHintVolume("pv-0", "nfs.manila.csi.openstack.org", "share-0"),
+		testHintVolume("pv-1", "nfs.manila.csi.openstack.org", "share-1"),
+		testHintVolume("pv-cinder", "cinder.csi.openstack.org", "volume"),
+		testHintClaim("new", "", map[string]string{
+			sameHostAnnotation:      "db-0",
+			differentHostAnnotation: "db-1, pending",
+		}),
+		testHintClaim("spread", "", map[string]string{
+			differentHostAnnotation: "db-0,db-1",
+			availabilityAnnotation:  "zone-b",
+		}),
+		testHintClaim("other", "", map[string]string{sameHostAnnotation: "cinder"}),
+	)
+	cs := &controllerServer{d: &Driver{
+		name:                     "nfs.manila.csi.openstack.org",
+		schedulerHintAnnotations: SchedulerHintAnnotationsOpts{Enabled: true, KubeClient: kubeClient},
+	}}
+
+	params := func(name string) map[string]string {
+		return map[string]string{pvcNameMetadataKey: name, pvcNamespaceMetadataKey: "ns"}
+	}
+
+	// The hints of the annotations are added to those of the StorageClass
+	shareOpts := &options.ControllerVolumeContext{SchedulerHintDifferentHost: "share-x"}
+	if err := cs.addAnnotationSchedulerHints(context.TODO(), shareOpts, params("spread")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if shareOpts.SchedulerHintDifferentHost != "share-x,share-0,share-1" || shareOpts.SchedulerHintSameHost != "" || shareOpts.AvailabilityZone != "zone-b" {
+		t.Errorf("unexpected volume parameters %+v", shareOpts)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		shareOpts *options.ControllerVolumeContext
+		code      codes.Code
+	}{
+		{name: "new", shareOpts: &options.ControllerVolumeContext{}, code: codes.Unavailable},
+		{name: "other", shareOpts: &options.ControllerVolumeContext{}, code: codes.InvalidArgument},
+		{name: "spread", shareOpts: &options.ControllerVolumeContext{AvailabilityZone: "zone-a"}, code: codes.InvalidArgument},
+		{name: "spread", shareOpts: &options.ControllerVolumeContext{AutoTopology: "true"}, code: codes.InvalidArgument},
+	} {
+		if err := cs.addAnnotationSchedulerHints(context.TODO(), tc.shareOpts, params(tc.name)); status.Code(err) != tc.code {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.code, err)
+		}
+	}
+
+	// The hints are ignored if the PersistentVolumeClaim is unknown
+	shareOpts = &options.ControllerVolumeContext{}
+	if err := cs.addAnnotationSchedulerHints(context.TODO(), shareOpts, nil); err != nil || !reflect.DeepEqual(shareOpts, &options.ControllerVolumeContext{}) {
+		t.Errorf("expected the hints to be ignored, got %+v, %v", shareOpts, err)
+	}
+}
+
+func TestWithShareCreateOpts(t *testing.T) {
+	createOpts := &shares.CreateOpts{ShareProto: "NFS", Size: 1}
+
+	if opts := withShareCreateOpts(createOpts, &options.ControllerVolumeContext{}); opts != createOpts {
+		t.Errorf("expected the share create options to be unchanged, got %v", opts)
+	}
+
+	opts := withShareCreateOpts(createOpts, &options.ControllerVolumeContext{
+		SchedulerHintSameHost:      "a, b",
+		SchedulerHintDifferentHost: "c",
+	})
+	expected := manilaclient.ShareCreateOpts{
+		CreateOpts:     *createOpts,
+		SchedulerHints: manilaclient.SchedulerHints{SameHost: []string{"a", "b"}, DifferentHost: []string{"c"}},
+	}
+	if !reflect.DeepEqual(opts, expected) {
+		t.Errorf("expected share create options %v, got %v", expected, opts)
+	}
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	clouderrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+// ensureSecurityService verifies that the security service exists, and associates it with the share network
+// if it isn't yet. Manila then configures the share servers of the share network with the security service,
+// e.g. to join an Active Directory domain or to export Kerberos-secured NFS shares.
+func ensureSecurityService(manilaClient manilaclient.Interface, shareNetworkID, securityServiceID string) error {
+	if _, err := manilaClient.GetSecurityService(securityServiceID); err != nil {
+		if clouderrors.IsNotFound(err) {
+			return status.Errorf(codes.InvalidArgument, "security service %s not found", securityServiceID)
+		}
+
+		return statusErrorf(codes.Internal, "failed to retrieve security service %s: %w", securityServiceID, err)
+	}
+
+	securityServices, err := manilaClient.GetShareNetworkSecurityServices(shareNetworkID)
+	if err != nil {
+		return statusErrorf(codes.Internal, "failed to retrieve security services of share network %s: %w", shareNetworkID, err)
+	}
+
+	for i := range securityServices {
+		if securityServices[i].ID == securityServiceID {
+			return nil
+		}
+	}
+
+	klog.V(4).Infof("associating security service %s with share network %s", securityServiceID, shareNetworkID)
+
+	if err := manilaClient.AddShareNetworkSecurityService(shareNetworkID, securityServiceID); err != nil {
+		if clouderrors.IsNotFound(err) {
+			return status.Errorf(codes.InvalidArgument, "share network %s not found", shareNetworkID)
+		}
+
+		// Manila refuses the association e.g. if the share network already has a security service
+		// of the same type, or if it's in use and the backend can't update its share servers
+		return statusErrorf(codes.FailedPrecondition, "failed to associate security service %s with share network %s: %w", securityServiceID, shareNetworkID, err)
+	}
+
+	return nil
+}
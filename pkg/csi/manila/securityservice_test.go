@@ -0,0 +1,120 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/securityservices"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
+)
+
+// fakeSecurityServiceClient serves security services and the security services associated with share networks.
+type fakeSecurityServiceClient struct {
+	manilaclient.Interface
+	securityServices map[string]securityservices.SecurityService
+	shareNetworks    map[string][]string
+	addErr           error
+}
+
+func (c *fakeSecurityServiceClient) GetSecurityService(securityServiceID string) (*securityservices.SecurityService, error) {
+	ss, ok := c.securityServices[securityServiceID]
+	if !ok {
+		return nil, gophercloud.ErrDefault404{}
+	}
+	return &ss, nil
+}
+
+func (c *fakeSecurityServiceClient) GetShareNetworkSecurityServices(shareNetworkID string) ([]securityservices.SecurityService, error) {
+	var ss []securityservices.SecurityService
+	for _, id := range c.shareNetworks[shareNetworkID] {
+		ss = append(ss, c.securityServices[id])
+	}
+	return ss, nil
+}
+
+func (c *fakeSecurityServiceClient) AddShareNetworkSecurityService(shareNetworkID, securityServiceID string) error {
+	if c.addErr != nil {
+		return c.addErr
+	}
+	if _, ok := c.shareNetworks[shareNetworkID]; !ok {
+		return gophercloud.ErrDefault404{}
+	}
+	c.shareNetworks[shareNetworkID] = append(c.shareNetworks[shareNetworkID], securityServiceID)
+	return nil
+}
+
+func TestEnsureSecurityService(t *testing.T) {
+	newClient := func() *fakeSecurityServiceClient {
+		return &fakeSecurityServiceClient{
+			securityServices: map[string]securityservices.SecurityService{
+				"ad":  {ID: "ad", Type: "active_directory"},
+				"krb": {ID: "krb", Type: "kerberos"},
+			},
+			shareNetworks: map[string][]string{
+				"net": {"ad"},
+			},
+		}
+	}
+
+	ts := []struct {
+		shareNetworkID    string
+		securityServiceID string
+		addErr            error
+		expectedCode      codes.Code
+		expectedServices  []string
+	}{
+		{"net", "ad", nil, codes.OK, []string{"ad"}},
+		{"net", "krb", nil, codes.OK, []string{"ad", "krb"}},
+		{"net", "ldap", nil, codes.InvalidArgument, []string{"ad"}},
+		{"other", "krb", nil, codes.InvalidArgument, nil},
+		{"net", "krb", errors.New("share network in use"), codes.FailedPrecondition, []string{"ad"}},
+	}
+
+	for _, tc := range ts {
+		c := newClient()
+		c.addErr = tc.addErr
+
+		err := ensureSecurityService(c, tc.shareNetworkID, tc.securityServiceID)
+		if status.Code(err) != tc.expectedCode {
+			t.Errorf("%s/%s: expected code %v, got %v", tc.shareNetworkID, tc.securityServiceID, tc.expectedCode, err)
+		}
+		if !reflect.DeepEqual(c.shareNetworks[tc.shareNetworkID], tc.expectedServices) {
+			t.Errorf("%s/%s: expected security services %v, got %v", tc.shareNetworkID, tc.securityServiceID, tc.expectedServices, c.shareNetworks[tc.shareNetworkID])
+		}
+	}
+}
+
+func TestSecurityServiceRequiresShareNetwork(t *testing.T) {
+	if _, err := options.NewControllerVolumeContext(map[string]string{"protocol": "CIFS", "cifs-shareUser": "user", "securityServiceID": "ad"}); err == nil {
+		t.Error("expected securityServiceID without shareNetworkID to be rejected")
+	}
+
+	opts, err := options.NewControllerVolumeContext(map[string]string{"protocol": "CIFS", "cifs-shareUser": "user", "securityServiceID": "ad", "shareNetworkID": "net"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.SecurityServiceID != "ad" {
+		t.Errorf("expected security service ad, got %q", opts.SecurityServiceID)
+	}
+}
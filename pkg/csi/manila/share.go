@@ -25,13 +25,19 @@ import (
 	"google.golang.org/grpc/status"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
+	manilautil "k8s.io/cloud-provider-openstack/pkg/csi/manila/util"
 	clouderrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
 	"k8s.io/klog/v2"
 )
 
 const (
-	waitForAvailableShareTimeout = 3
-	waitForAvailableShareRetries = 10
+	// shareWaitInterval is the initial interval between two polls of a share or a snapshot
+	// whose status is awaited, see manilautil.WaitBackoff.
+	shareWaitInterval = 3 * time.Second
+
+	defaultShareWaitTimeout     = time.Minute
+	defaultAccessKeyWaitTimeout = 90 * time.Second
 
 	shareCreating             = "creating"
 	shareCreatingFromSnapshot = "creating_from_snapshot"
@@ -59,8 +65,8 @@ func isShareInErrorState(s string) bool {
 }
 
 // getOrCreateShare first retrieves an existing share with name=shareName, or creates a new one if it doesn't exist yet.
-// Once the share is created, an exponential back-off is used to wait till the status of the share is "available".
-func getOrCreateShare(manilaClient manilaclient.Interface, shareName string, createOpts *shares.CreateOpts) (*shares.Share, manilaError, error) {
+// Once the share is created, an exponential back-off is used to wait up to timeout till the status of the share is "available".
+func getOrCreateShare(manilaClient manilaclient.Interface, shareName string, createOpts shares.CreateOptsBuilder, timeout time.Duration) (*shares.Share, manilaError, error) {
 	var (
 		share *shares.Share
 		err   error
@@ -78,7 +84,7 @@ func getOrCreateShare(manilaClient manilaclient.Interface, shareName string, cre
 			}
 		} else {
 			// Something else is wrong
-			return nil, 0, fmt.Errorf("failed to retrieve volume %s: %v", shareName, err)
+			return nil, 0, fmt.Errorf("failed to retrieve volume %s: %w", shareName, err)
 		}
 	} else {
 		klog.V(4).Infof("volume %s already exists", shareName)
@@ -90,7 +96,28 @@ func getOrCreateShare(manilaClient manilaclient.Interface, shareName string, cre
 		return share, 0, nil
 	}
 
-	return waitForShareStatus(manilaClient, share.ID, []string{shareCreating, shareCreatingFromSnapshot}, shareAvailable, false)
+	return waitForShareStatus(manilaClient, share.ID, []string{shareCreating, shareCreatingFromSnapshot}, shareAvailable, false, timeout)
+}
+
+// withShareCreateOpts returns the options creating the share in the share group, with the scheduler hints and
+// encrypted with the key of the volume parameters, if set.
+func withShareCreateOpts(createOpts *shares.CreateOpts, shareOpts *options.ControllerVolumeContext) shares.CreateOptsBuilder {
+	hints := manilaclient.SchedulerHints{
+		SameHost:      splitShareIDs(shareOpts.SchedulerHintSameHost),
+		DifferentHost: splitShareIDs(shareOpts.SchedulerHintDifferentHost),
+	}
+
+	// A share created from a snapshot is encrypted with the key of its source share.
+	encryptionKeyRef := shareOpts.EncryptionKeyRef
+	if createOpts.SnapshotID != "" {
+		encryptionKeyRef = ""
+	}
+
+	if shareOpts.ShareGroupID == "" && len(hints.SameHost) == 0 && len(hints.DifferentHost) == 0 && encryptionKeyRef == "" {
+		return createOpts
+	}
+
+	return manilaclient.ShareCreateOpts{CreateOpts: *createOpts, ShareGroupID: shareOpts.ShareGroupID, SchedulerHints: hints, EncryptionKeyRef: encryptionKeyRef}
 }
 
 func deleteShare(manilaClient manilaclient.Interface, shareID string) error {
@@ -105,24 +132,24 @@ func deleteShare(manilaClient manilaclient.Interface, shareID string) error {
 	return nil
 }
 
-func tryDeleteShare(manilaClient manilaclient.Interface, share *shares.Share) {
+func tryDeleteShare(manilaClient manilaclient.Interface, share *shares.Share, timeout time.Duration) {
 	if share == nil {
 		return
 	}
 
 	if err := manilaClient.DeleteShare(share.ID); err != nil {
-		// TODO failure to delete a share in an error state needs proper monitoring support
 		klog.Errorf("couldn't delete volume %s in a roll-back procedure: %v", share.Name, err)
+		releaseUndeletedShare(manilaClient, share.ID)
 		return
 	}
 
-	_, _, err := waitForShareStatus(manilaClient, share.ID, []string{shareDeleting}, "", true)
+	_, _, err := waitForShareStatus(manilaClient, share.ID, []string{shareDeleting}, "", true, timeout)
 	if err != nil && !wait.Interrupted(err) {
 		klog.Errorf("couldn't retrieve volume %s in a roll-back procedure: %v", share.Name, err)
 	}
 }
 
-func extendShare(manilaClient manilaclient.Interface, shareID string, newSizeInGiB int) (*shares.Share, error) {
+func extendShare(manilaClient manilaclient.Interface, shareID string, newSizeInGiB int, timeout time.Duration) (*shares.Share, error) {
 	opts := shares.ExtendOpts{
 		NewSize: newSizeInGiB,
 	}
@@ -131,25 +158,21 @@ func extendShare(manilaClient manilaclient.Interface, shareID string, newSizeInG
 		return nil, err
 	}
 
-	share, manilaErrCode, err := waitForShareStatus(manilaClient, shareID, []string{shareExtending}, shareAvailable, false)
+	share, manilaErrCode, err := waitForShareStatus(manilaClient, shareID, []string{shareExtending}, shareAvailable, false, timeout)
 	if err != nil {
 		if wait.Interrupted(err) {
 			return nil, status.Errorf(codes.DeadlineExceeded, "deadline exceeded while waiting for volume ID %s to become available", share.Name)
 		}
 
-		return nil, status.Errorf(manilaErrCode.toRPCErrorCode(), "failed to resize volume %s: %v", share.Name, err)
+		return nil, statusErrorf(manilaErrCode.toRPCErrorCode(), "failed to resize volume %s: %w", share.Name, err)
 	}
 
 	return share, nil
 }
 
-func waitForShareStatus(manilaClient manilaclient.Interface, shareID string, validTransientStates []string, desiredStatus string, successOnNotFound bool) (*shares.Share, manilaError, error) {
+func waitForShareStatus(manilaClient manilaclient.Interface, shareID string, validTransientStates []string, desiredStatus string, successOnNotFound bool, timeout time.Duration) (*shares.Share, manilaError, error) {
 	var (
-		backoff = wait.Backoff{
-			Duration: time.Second * waitForAvailableShareTimeout,
-			Factor:   1.2,
-			Steps:    waitForAvailableShareRetries,
-		}
+		backoff = manilautil.WaitBackoff(shareWaitInterval, timeout)
 
 		share         *shares.Share
 		manilaErrCode manilaError
@@ -41,6 +41,11 @@ func (Cephfs) GetOrGrantAccess(args *GrantAccessArgs) (accessRight *shares.Acces
 		accessTo = args.Share.Name
 	}
 
+	accessLevel := args.AccessLevel
+	if accessLevel == "" {
+		accessLevel = "rw"
+	}
+
 	rights, err = args.ManilaClient.GetAccessRights(args.Share.ID)
 	if err != nil {
 		if _, ok := err.(gophercloud.ErrResourceNotFound); !ok {
@@ -50,12 +55,19 @@ func (Cephfs) GetOrGrantAccess(args *GrantAccessArgs) (accessRight *shares.Acces
 		// Try to find the access right
 
 		for _, r := range rights {
-			if r.AccessTo == accessTo && r.AccessType == "cephx" && r.AccessLevel == "rw" {
-				klog.V(4).Infof("cephx access right for share %s already exists", args.Share.Name)
+			if r.AccessTo != accessTo || r.AccessType != "cephx" {
+				continue
+			}
 
-				accessRight = &r
-				break
+			// Manila rejects a second access right for the same cephx ID
+			if r.AccessLevel != accessLevel {
+				return nil, fmt.Errorf("cephx access right %s for %s has access level %s, expected %s", r.ID, accessTo, r.AccessLevel, accessLevel)
 			}
+
+			klog.V(4).Infof("cephx %s access right for share %s already exists", accessLevel, args.Share.Name)
+
+			accessRight = &r
+			break
 		}
 	}
 
@@ -64,7 +76,7 @@ func (Cephfs) GetOrGrantAccess(args *GrantAccessArgs) (accessRight *shares.Acces
 
 		accessRight, err = args.ManilaClient.GrantAccess(args.Share.ID, shares.GrantAccessOpts{
 			AccessType:  "cephx",
-			AccessLevel: "rw",
+			AccessLevel: accessLevel,
 			AccessTo:    accessTo,
 		})
 
@@ -73,18 +85,14 @@ func (Cephfs) GetOrGrantAccess(args *GrantAccessArgs) (accessRight *shares.Acces
 		}
 	}
 
-	if accessRight.AccessKey != "" {
-		// The access right is ready
+	if accessRight.AccessKey != "" || args.Async {
+		// The access right is ready, or the caller waits for the key itself
 		return
 	}
 
 	// Wait till a ceph key is assigned to the access right
 
-	backoff := wait.Backoff{
-		Duration: time.Second * 5,
-		Factor:   1.2,
-		Steps:    10,
-	}
+	backoff := manilautil.WaitBackoff(5*time.Second, args.KeyTimeout)
 
 	return accessRight, wait.ExponentialBackoff(backoff, func() (bool, error) {
 		rights, err := args.ManilaClient.GetAccessRights(args.Share.ID)
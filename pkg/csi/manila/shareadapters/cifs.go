@@ -0,0 +1,134 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shareadapters
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	manilautil "k8s.io/cloud-provider-openstack/pkg/csi/manila/util"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// CifsPasswordSecretKey is the key of the node stage secret holding the password of the CIFS share user.
+	CifsPasswordSecretKey = "cifs-password"
+	// CifsDomainSecretKey is the key of the node stage secret holding the optional domain of the CIFS share user.
+	CifsDomainSecretKey = "cifs-domain"
+)
+
+type Cifs struct{}
+
+var _ ShareAdapter = &Cifs{}
+
+func (Cifs) GetOrGrantAccess(args *GrantAccessArgs) (*shares.AccessRight, error) {
+	// First, check if the access right exists or needs to be created
+
+	accessTo := args.Options.CifsShareUser
+	if accessTo == "" {
+		return nil, fmt.Errorf("cifs-shareUser is required for CIFS shares")
+	}
+
+	accessLevel := args.AccessLevel
+	if accessLevel == "" {
+		accessLevel = "rw"
+	}
+
+	rights, err := args.ManilaClient.GetAccessRights(args.Share.ID)
+	if err != nil {
+		if _, ok := err.(gophercloud.ErrResourceNotFound); !ok {
+			return nil, fmt.Errorf("failed to list access rights: %v", err)
+		}
+	}
+
+	// Try to find the access right
+
+	for _, r := range rights {
+		if r.AccessTo != accessTo || r.AccessType != "user" {
+			continue
+		}
+
+		// Manila rejects a second access right for the same user
+		if r.AccessLevel != accessLevel {
+			return nil, fmt.Errorf("user access right %s for %s has access level %s, expected %s", r.ID, accessTo, r.AccessLevel, accessLevel)
+		}
+
+		klog.V(4).Infof("user %s access right for share %s already exists", accessLevel, args.Share.Name)
+		return &r, nil
+	}
+
+	// Not found, create it
+
+	return args.ManilaClient.GrantAccess(args.Share.ID, shares.GrantAccessOpts{
+		AccessType:  "user",
+		AccessLevel: accessLevel,
+		AccessTo:    accessTo,
+	})
+}
+
+func (Cifs) BuildVolumeContext(args *VolumeContextArgs) (volumeContext map[string]string, err error) {
+	chosenExportLocationIdx, err := manilautil.FindExportLocation(args.Locations, manilautil.AnyExportLocation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to choose an export location: %v", err)
+	}
+
+	source, err := cifsSourceFromExportLocation(args.Locations[chosenExportLocationIdx].Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{"source": source}, nil
+}
+
+func (Cifs) BuildNodeStageSecret(args *SecretArgs) (secret map[string]string, err error) {
+	// Manila knows only the user the share was granted to,
+	// the password is provided with the secrets of the request
+	password, ok := args.Secrets[CifsPasswordSecretKey]
+	if !ok {
+		return nil, fmt.Errorf("missing %s secret", CifsPasswordSecretKey)
+	}
+
+	secret = map[string]string{
+		"username": args.AccessRight.AccessTo,
+		"password": password,
+	}
+
+	if domain := args.Secrets[CifsDomainSecretKey]; domain != "" {
+		secret["domain"] = domain
+	}
+
+	return secret, nil
+}
+
+func (Cifs) BuildNodePublishSecret(args *SecretArgs) (secret map[string]string, err error) {
+	return nil, nil
+}
+
+// cifsSourceFromExportLocation converts a CIFS export location, e.g. \\10.0.0.1\share-1234,
+// to the //10.0.0.1/share-1234 form expected by SMB mounters.
+func cifsSourceFromExportLocation(exportLocationPath string) (string, error) {
+	source := strings.ReplaceAll(exportLocationPath, `\`, "/")
+
+	server, share, ok := strings.Cut(strings.TrimPrefix(source, "//"), "/")
+	if !strings.HasPrefix(source, "//") || !ok || server == "" || share == "" {
+		return "", fmt.Errorf("failed to parse server and share from export location '%s'", exportLocationPath)
+	}
+
+	return source, nil
+}
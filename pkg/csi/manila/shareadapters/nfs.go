@@ -33,7 +33,17 @@ type NFS struct{}
 var _ ShareAdapter = &NFS{}
 
 func (NFS) GetOrGrantAccess(args *GrantAccessArgs) (*shares.AccessRight, error) {
-	// First, check if the access right exists or needs to be created
+	// First, check if the access rights exist or need to be created
+
+	accessType, accessTos := "ip", splitShareClients(args.Options.NFSShareClient)
+	if args.Options.NFSAccessType == "user" {
+		// Access for a Kerberos principal, the share is then mounted with sec=krb5*
+		accessType, accessTos = "user", []string{args.Options.NFSShareUser}
+	}
+
+	if len(accessTos) == 0 {
+		return nil, fmt.Errorf("no client to grant access to")
+	}
 
 	rights, err := args.ManilaClient.GetAccessRights(args.Share.ID)
 	if err != nil {
@@ -42,11 +52,30 @@ func (NFS) GetOrGrantAccess(args *GrantAccessArgs) (*shares.AccessRight, error)
 		}
 	}
 
+	// Grant access to each client, the access right of the first one is returned
+
+	var first *shares.AccessRight
+
+	for _, accessTo := range accessTos {
+		accessRight, err := nfsGetOrGrantAccessTo(args, rights, accessType, accessTo)
+		if err != nil {
+			return nil, err
+		}
+
+		if first == nil {
+			first = accessRight
+		}
+	}
+
+	return first, nil
+}
+
+func nfsGetOrGrantAccessTo(args *GrantAccessArgs, rights []shares.AccessRight, accessType, accessTo string) (*shares.AccessRight, error) {
 	// Try to find the access right
 
 	for _, r := range rights {
-		if r.AccessTo == args.Options.NFSShareClient && r.AccessType == "ip" && r.AccessLevel == "rw" {
-			klog.V(4).Infof("IP access right for share %s already exists", args.Share.Name)
+		if r.AccessTo == accessTo && r.AccessType == accessType && r.AccessLevel == "rw" {
+			klog.V(4).Infof("%s access right for share %s already exists", accessType, args.Share.Name)
 			return &r, nil
 		}
 	}
@@ -54,12 +83,23 @@ func (NFS) GetOrGrantAccess(args *GrantAccessArgs) (*shares.AccessRight, error)
 	// Not found, create it
 
 	return args.ManilaClient.GrantAccess(args.Share.ID, shares.GrantAccessOpts{
-		AccessType:  "ip",
+		AccessType:  accessType,
 		AccessLevel: "rw",
-		AccessTo:    args.Options.NFSShareClient,
+		AccessTo:    accessTo,
 	})
 }
 
+// splitShareClients splits the comma-separated list of nfs-shareClient.
+func splitShareClients(value string) []string {
+	var clients []string
+	for _, c := range strings.Split(value, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			clients = append(clients, c)
+		}
+	}
+	return clients
+}
+
 func (NFS) BuildVolumeContext(args *VolumeContextArgs) (volumeContext map[string]string, err error) {
 	chosenExportLocationIdx, err := nfsChooseExportLocation(args.Locations)
 	if err != nil {
@@ -68,10 +108,16 @@ func (NFS) BuildVolumeContext(args *VolumeContextArgs) (volumeContext map[string
 
 	server, share, err := splitExportLocationPath(args.Locations[chosenExportLocationIdx].Path)
 
-	return map[string]string{
+	volCtx := map[string]string{
 		"server": server,
 		"share":  share,
-	}, err
+	}
+
+	if args.Options.NFSSecurity != "" {
+		volCtx["mountOptions"] = "sec=" + args.Options.NFSSecurity
+	}
+
+	return volCtx, err
 }
 
 func (NFS) BuildNodeStageSecret(args *SecretArgs) (secret map[string]string, err error) {
@@ -0,0 +1,81 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shareadapters
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]ShareAdapter)
+)
+
+func init() {
+	RegisterShareAdapter("CEPHFS", &Cephfs{})
+	RegisterShareAdapter("CIFS", &Cifs{})
+	RegisterShareAdapter("NFS", &NFS{})
+}
+
+// RegisterShareAdapter makes a share adapter available for a Manila share protocol.
+// Protocols are case-insensitive. Out-of-tree adapters should be registered
+// from an init function, before the driver is started.
+// Registering a protocol twice is a programming error and terminates the program.
+func RegisterShareAdapter(protocol string, adapter ShareAdapter) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	proto := strings.ToUpper(protocol)
+
+	if proto == "" || adapter == nil {
+		klog.Fatalf("invalid share adapter registration for protocol %q", protocol)
+	}
+
+	if _, ok := registry[proto]; ok {
+		klog.Fatalf("share adapter for protocol %s is already registered", proto)
+	}
+
+	registry[proto] = adapter
+}
+
+// GetShareAdapter returns the share adapter registered for protocol.
+func GetShareAdapter(protocol string) (ShareAdapter, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	adapter, ok := registry[strings.ToUpper(protocol)]
+	return adapter, ok
+}
+
+// RegisteredProtocols returns the sorted list of share protocols with a registered share adapter.
+func RegisteredProtocols() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	protocols := make([]string, 0, len(registry))
+	for proto := range registry {
+		protocols = append(protocols, proto)
+	}
+
+	sort.Strings(protocols)
+
+	return protocols
+}
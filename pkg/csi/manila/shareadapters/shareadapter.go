@@ -17,6 +17,8 @@ limitations under the License.
 package shareadapters
 
 import (
+	"time"
+
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
@@ -26,6 +28,17 @@ type GrantAccessArgs struct {
 	ManilaClient manilaclient.Interface
 	Share        *shares.Share
 	Options      *options.ControllerVolumeContext
+
+	// AccessLevel is the access level of the access right, "rw" or "ro". Default: "rw"
+	AccessLevel string
+
+	// Async makes GetOrGrantAccess return the access right without waiting
+	// for the backend to complete it, e.g. to assign a cephx key.
+	Async bool
+
+	// KeyTimeout is how long GetOrGrantAccess waits for the backend
+	// to assign a key to the access right, e.g. a cephx key.
+	KeyTimeout time.Duration
 }
 
 type VolumeContextArgs struct {
@@ -38,6 +51,9 @@ type VolumeContextArgs struct {
 
 type SecretArgs struct {
 	AccessRight *shares.AccessRight
+
+	// Secrets of the CSI request, e.g. credentials Manila doesn't know about.
+	Secrets map[string]string
 }
 
 type ShareAdapter interface {
@@ -0,0 +1,276 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
+	clouderrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+// ShareMetadataSyncOpts configures the sync of the share metadata mapping the shares back to their Kubernetes
+// objects: the names of the PersistentVolume and PersistentVolumeClaim, and the PersistentVolumeClaim labels
+// selected with the shareMetadataLabels volume parameter.
+type ShareMetadataSyncOpts struct {
+	// SecretDir is a directory containing the OpenStack credentials, one file per key, in the same
+	// format as the CSI secrets. The sync is disabled if empty.
+	SecretDir string
+	// Interval between two syncs.
+	Interval time.Duration
+	// KubeClient is used to list the PersistentVolumes and get the PersistentVolumeClaims.
+	KubeClient kubernetes.Interface
+}
+
+const (
+	pvNameMetadataKey       = "csi.storage.k8s.io/pv/name"
+	pvcNameMetadataKey      = "csi.storage.k8s.io/pvc/name"
+	pvcNamespaceMetadataKey = "csi.storage.k8s.io/pvc/namespace"
+
+	// Comma-separated list of the PersistentVolumeClaim labels propagated to the share metadata,
+	// each one under labelMetadataKeyPrefix followed by the label key.
+	labelsMetadataKey      = "manila.csi.openstack.org/labels"
+	labelMetadataKeyPrefix = "manila.csi.openstack.org/label/"
+
+	// Maximum length of a Manila share metadata key
+	maxMetadataKeyLength = 255
+)
+
+// parseShareMetadataLabels returns the label keys of the shareMetadataLabels volume parameter.
+func parseShareMetadataLabels(value string) ([]string, error) {
+	var keys []string
+	for _, k := range strings.Split(value, ",") {
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid label key %q: %s", k, strings.Join(errs, ", "))
+		}
+		if len(labelMetadataKeyPrefix+k) > maxMetadataKeyLength {
+			return nil, fmt.Errorf("label key %q exceeds the maximum length of %d characters of the share metadata keys", k, maxMetadataKeyLength-len(labelMetadataKeyPrefix))
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, nil
+}
+
+// labelShareMetadata returns the share metadata of the propagated labels. The labels missing from the
+// PersistentVolumeClaim are set to an empty value, as Manila can't delete the metadata keys containing a slash.
+func labelShareMetadata(keys []string, labels map[string]string) map[string]string {
+	md := make(map[string]string, len(keys))
+	for _, k := range keys {
+		md[labelMetadataKeyPrefix+k] = labels[k]
+	}
+
+	return md
+}
+
+// addLabelShareMetadata adds the labels of the PersistentVolumeClaim selected with the shareMetadataLabels
+// volume parameter to the metadata of a new share. The PersistentVolumeClaim is only known when the
+// csi-provisioner runs with --extra-create-metadata, otherwise the labels are set by the next sync.
+func (cs *controllerServer) addLabelShareMetadata(ctx context.Context, shareMetadataLabels string, params, shareMetadata map[string]string) error {
+	keys, err := parseShareMetadataLabels(shareMetadataLabels)
+	if err != nil {
+		return fmt.Errorf("invalid shareMetadataLabels parameter: %v", err)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	kubeClient := cs.d.shareMetadataSync.KubeClient
+	if kubeClient == nil {
+		return fmt.Errorf("shareMetadataLabels parameter requires the share metadata sync to be enabled")
+	}
+
+	shareMetadata[labelsMetadataKey] = strings.Join(keys, ",")
+
+	name, namespace := params[pvcNameMetadataKey], params[pvcNamespaceMetadataKey]
+	if name == "" || namespace == "" {
+		return nil
+	}
+
+	pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get PersistentVolumeClaim %s/%s: %v", namespace, name, err)
+	}
+
+	for k, v := range labelShareMetadata(keys, pvc.Labels) {
+		if v != "" {
+			shareMetadata[k] = v
+		}
+	}
+
+	return nil
+}
+
+// runShareMetadataSync periodically syncs the share metadata of the volumes.
+func (d *Driver) runShareMetadataSync() {
+	klog.Infof("Syncing share metadata every %v", d.shareMetadataSync.Interval)
+
+	go wait.Forever(func() {
+		if err := d.syncSharesMetadata(context.Background()); err != nil {
+			klog.Errorf("failed to sync share metadata: %v", err)
+		}
+	}, d.shareMetadataSync.Interval)
+}
+
+func (d *Driver) syncSharesMetadata(ctx context.Context) error {
+	secrets, err := readSecretDir(d.shareMetadataSync.SecretDir)
+	if err != nil {
+		return err
+	}
+
+	osOpts, err := options.NewOpenstackOptions(secrets)
+	if err != nil {
+		return fmt.Errorf("invalid OpenStack secrets in %s: %v", d.shareMetadataSync.SecretDir, err)
+	}
+
+	manilaClient, err := d.manilaClientBuilder.New(osOpts)
+	if err != nil {
+		return fmt.Errorf("failed to create Manila v2 client: %v", err)
+	}
+
+	pvs, err := d.shareMetadataSync.KubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list PersistentVolumes: %v", err)
+	}
+
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != d.name {
+			continue
+		}
+
+		if err := d.syncShareMetadata(ctx, manilaClient, pv); err != nil {
+			klog.Errorf("failed to sync the share metadata of PersistentVolume %s: %v", pv.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// syncShareMetadata updates the metadata of the share of the PersistentVolume which differ from the
+// PersistentVolume and its PersistentVolumeClaim.
+func (d *Driver) syncShareMetadata(ctx context.Context, manilaClient manilaclient.Interface, pv *v1.PersistentVolume) error {
+	// Volumes referencing their share by name, or with readOnlyAccessTo, are usually shares of another cluster
+	shareID := pv.Spec.CSI.VolumeAttributes["shareID"]
+	if shareID == "" || pv.Spec.CSI.VolumeAttributes["readOnlyAccessTo"] != "" {
+		return nil
+	}
+
+	share, err := manilaClient.GetShareByID(shareID)
+	if err != nil {
+		if clouderrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get share %s: %v", shareID, err)
+	}
+
+	if clusterID := share.Metadata[clusterMetadataKey]; clusterID != "" && d.clusterID != "" && clusterID != d.clusterID {
+		klog.V(4).Infof("Not syncing the metadata of share %s of cluster %s", share.ID, clusterID)
+		return nil
+	}
+
+	pvc, err := d.boundClaim(ctx, pv)
+	if err != nil {
+		return err
+	}
+
+	md := shareMetadataUpdates(share, pv, pvc)
+	if len(md) == 0 {
+		return nil
+	}
+
+	if _, err := manilaClient.SetShareMetadata(share.ID, shares.SetMetadataOpts{Metadata: md}); err != nil {
+		return fmt.Errorf("failed to set metadata of share %s: %v", share.ID, err)
+	}
+
+	klog.V(4).Infof("Updated the metadata of share %s of PersistentVolume %s: %v", share.ID, pv.Name, md)
+
+	return nil
+}
+
+// boundClaim returns the PersistentVolumeClaim bound to the PersistentVolume, nil if there is none.
+func (d *Driver) boundClaim(ctx context.Context, pv *v1.PersistentVolume) (*v1.PersistentVolumeClaim, error) {
+	ref := pv.Spec.ClaimRef
+	if ref == nil || ref.Name == "" {
+		return nil, nil
+	}
+
+	pvc, err := d.shareMetadataSync.KubeClient.CoreV1().PersistentVolumeClaims(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get PersistentVolumeClaim %s/%s: %v", ref.Namespace, ref.Name, err)
+	}
+	if ref.UID != "" && pvc.UID != ref.UID {
+		// The claim was deleted and recreated, the PersistentVolume is released
+		return nil, nil
+	}
+
+	return pvc, nil
+}
+
+// shareMetadataUpdates returns the share metadata to set for the share to match the PersistentVolume and its
+// PersistentVolumeClaim, if any.
+func shareMetadataUpdates(share *shares.Share, pv *v1.PersistentVolume, pvc *v1.PersistentVolumeClaim) map[string]string {
+	want := map[string]string{
+		pvNameMetadataKey: pv.Name,
+	}
+
+	if pvc != nil {
+		want[pvcNameMetadataKey] = pvc.Name
+		want[pvcNamespaceMetadataKey] = pvc.Namespace
+
+		if value := share.Metadata[labelsMetadataKey]; value != "" {
+			keys, err := parseShareMetadataLabels(value)
+			if err != nil {
+				klog.Warningf("Ignoring invalid %s metadata of share %s: %v", labelsMetadataKey, share.ID, err)
+			}
+			for k, v := range labelShareMetadata(keys, pvc.Labels) {
+				want[k] = v
+			}
+		}
+	}
+
+	md := make(map[string]string)
+	for k, v := range want {
+		if share.Metadata[k] != v {
+			md[k] = v
+		}
+	}
+
+	return md
+}
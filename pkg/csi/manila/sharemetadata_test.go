@@ -0,0 +1,174 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseShareMetadataLabels(t *testing.T) {
+	ts := []struct {
+		value    string
+		expected []string
+		err      bool
+	}{
+		{value: "app", expected: []string{"app"}},
+		{value: " app , app.kubernetes.io/name,", expected: []string{"app", "app.kubernetes.io/name"}},
+		{value: "not a label", err: true},
+		{value: "example.com/" + strings.Repeat("a", 63) + ",x.example.com/" + strings.Repeat("b", 63), expected: []string{"example.com/" + strings.Repeat("a", 63), "x.example.com/" + strings.Repeat("b", 63)}},
+		{value: strings.Repeat("a", 220) + ".com/name", err: true},
+	}
+
+	for _, tc := range ts {
+		keys, err := parseShareMetadataLabels(tc.value)
+		if tc.err {
+			if err == nil {
+				t.Errorf("parseShareMetadataLabels(%q): expected an error", tc.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseShareMetadataLabels(%q): unexpected error: %v", tc.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(keys, tc.expected) {
+			t.Errorf("parseShareMetadataLabels(%q): expected %v, got %v", tc.value, tc.expected, keys)
+		}
+	}
+}
+
+func TestShareMetadataUpdates(t *testing.T) {
+	pv := &corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-1"}}
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "data",
+			Namespace: "ns-1",
+			Labels:    map[string]string{"app": "db", "team": "storage"},
+		},
+	}
+
+	share := &shares.Share{
+		ID: "share-1",
+		Metadata: map[string]string{
+			pvNameMetadataKey:               "pv-1",
+			pvcNameMetadataKey:              "data",
+			pvcNamespaceMetadataKey:         "ns-1",
+			labelsMetadataKey:               "app,team,tier",
+			labelMetadataKeyPrefix + "app":  "web",
+			labelMetadataKeyPrefix + "tier": "backend",
+			clusterMetadataKey:              "c1",
+		},
+	}
+
+	md := shareMetadataUpdates(share, pv, pvc)
+	expected := map[string]string{
+		labelMetadataKeyPrefix + "app":  "db",
+		labelMetadataKeyPrefix + "team": "storage",
+		labelMetadataKeyPrefix + "tier": "",
+	}
+	if !reflect.DeepEqual(md, expected) {
+		t.Errorf("expected %v, got %v", expected, md)
+	}
+
+	// Without claim, only the name of the PersistentVolume is synced
+	md = shareMetadataUpdates(&shares.Share{ID: "share-2"}, pv, nil)
+	expected = map[string]string{pvNameMetadataKey: "pv-1"}
+	if !reflect.DeepEqual(md, expected) {
+		t.Errorf("expected %v, got %v", expected, md)
+	}
+}
+
+func TestSyncShareMetadata(t *testing.T) {
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "data",
+			Namespace: "ns-1",
+			UID:       "uid-1",
+			Labels:    map[string]string{"app": "db"},
+		},
+	}
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-1"},
+		Spec: corev1.PersistentVolumeSpec{
+			ClaimRef: &corev1.ObjectReference{Name: "data", Namespace: "ns-1", UID: "uid-1"},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:           "manila.csi.openstack.org",
+					VolumeHandle:     "share-1",
+					VolumeAttributes: map[string]string{"shareID": "share-1", "shareAccessID": "access-1"},
+				},
+			},
+		},
+	}
+
+	d := &Driver{
+		name:              "manila.csi.openstack.org",
+		clusterID:         "c1",
+		shareMetadataSync: ShareMetadataSyncOpts{KubeClient: fake.NewSimpleClientset(pvc)},
+	}
+
+	client := &fakeKeyRotationClient{
+		share: shares.Share{
+			ID:       "share-1",
+			Metadata: map[string]string{clusterMetadataKey: "c1", labelsMetadataKey: "app"},
+		},
+	}
+
+	if err := d.syncShareMetadata(context.Background(), client, pv); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]string{
+		clusterMetadataKey:             "c1",
+		labelsMetadataKey:              "app",
+		pvNameMetadataKey:              "pv-1",
+		pvcNameMetadataKey:             "data",
+		pvcNamespaceMetadataKey:        "ns-1",
+		labelMetadataKeyPrefix + "app": "db",
+	}
+	if !reflect.DeepEqual(client.share.Metadata, expected) {
+		t.Errorf("expected %v, got %v", expected, client.share.Metadata)
+	}
+
+	// The shares of other clusters are left alone
+	client.share.Metadata = map[string]string{clusterMetadataKey: "c2"}
+	if err := d.syncShareMetadata(context.Background(), client, pv); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(client.share.Metadata) != 1 {
+		t.Errorf("expected the metadata of the share of another cluster to be unchanged, got %v", client.share.Metadata)
+	}
+
+	// A recreated claim isn't bound to the PersistentVolume
+	client.share.Metadata = map[string]string{}
+	pv.Spec.ClaimRef.UID = "uid-0"
+	if err := d.syncShareMetadata(context.Background(), client, pv); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected = map[string]string{pvNameMetadataKey: "pv-1"}
+	if !reflect.DeepEqual(client.share.Metadata, expected) {
+		t.Errorf("expected %v, got %v", expected, client.share.Metadata)
+	}
+}
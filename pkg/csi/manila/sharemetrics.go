@@ -0,0 +1,178 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+// ShareMetricsOpts configures the exporter of the share capacity metrics.
+type ShareMetricsOpts struct {
+	// Endpoint is the TCP address the metrics HTTP server listens on. The exporter is disabled if empty.
+	Endpoint string
+	// SecretDir is a directory containing the OpenStack credentials, one file per key, in the same
+	// format as the CSI secrets. Typically a Kubernetes Secret mounted as a volume.
+	SecretDir string
+	// Interval between two queries of Manila.
+	Interval time.Duration
+	// UsedSizeMetadataKey is the share metadata key holding the used size of the share in bytes.
+	// Manila doesn't report share usage in its API, the used size gauge is only exported for
+	// shares whose backend or tooling publishes it in the share metadata.
+	UsedSizeMetadataKey string
+}
+
+var (
+	shareCapacityBytes = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name: "manila_csi_share_capacity_bytes",
+			Help: "Provisioned capacity of the Manila share backing a PersistentVolume",
+		}, []string{"persistent_volume", "share_id"})
+	shareUsedBytes = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name: "manila_csi_share_used_bytes",
+			Help: "Used capacity of the Manila share backing a PersistentVolume, as reported by the share backend",
+		}, []string{"persistent_volume", "share_id"})
+
+	registerShareMetrics sync.Once
+)
+
+// runShareMetricsExporter serves the share capacity metrics and periodically refreshes them from Manila.
+func (d *Driver) runShareMetricsExporter() {
+	registerShareMetrics.Do(func() {
+		legacyregistry.MustRegister(shareCapacityBytes, shareUsedBytes)
+	})
+
+	// The HTTP endpoint already serves all the metrics
+	if d.shareMetrics.Endpoint != d.httpEndpoint {
+		serveMetrics(d.shareMetrics.Endpoint)
+	}
+
+	go wait.Forever(func() {
+		if err := d.collectShareMetrics(); err != nil {
+			klog.Errorf("failed to collect share metrics: %v", err)
+		}
+	}, d.shareMetrics.Interval)
+}
+
+// serveMetrics serves the registered metrics, e.g. of the Manila API requests and of the shares, on the endpoint.
+func serveMetrics(endpoint string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", legacyregistry.HandlerWithReset())
+	go func() {
+		klog.Infof("metrics available in %q", endpoint)
+		if err := http.ListenAndServe(endpoint, mux); err != nil {
+			klog.Fatalf("failed to listen & serve metrics from %q: %v", endpoint, err)
+		}
+	}()
+}
+
+func (d *Driver) collectShareMetrics() error {
+	secrets, err := readSecretDir(d.shareMetrics.SecretDir)
+	if err != nil {
+		return err
+	}
+
+	osOpts, err := options.NewOpenstackOptions(secrets)
+	if err != nil {
+		return fmt.Errorf("invalid OpenStack secrets in %s: %v", d.shareMetrics.SecretDir, err)
+	}
+
+	manilaClient, err := d.manilaClientBuilder.New(osOpts)
+	if err != nil {
+		return fmt.Errorf("failed to create Manila v2 client: %v", err)
+	}
+
+	shs, err := manilaClient.ListShares(shares.ListOpts{Metadata: map[string]string{clusterMetadataKey: d.clusterID}})
+	if err != nil {
+		return fmt.Errorf("failed to list shares: %v", err)
+	}
+
+	shareCapacityBytes.Reset()
+	shareUsedBytes.Reset()
+
+	for i := range shs {
+		pvName, capacity, used, hasUsed := shareUsage(&shs[i], d.shareMetrics.UsedSizeMetadataKey)
+		shareCapacityBytes.WithLabelValues(pvName, shs[i].ID).Set(capacity)
+		if hasUsed {
+			shareUsedBytes.WithLabelValues(pvName, shs[i].ID).Set(used)
+		}
+	}
+
+	return nil
+}
+
+// shareUsage returns the name of the volume backed by the share, its capacity and, if reported, its used size.
+func shareUsage(share *shares.Share, usedSizeMetadataKey string) (pvName string, capacity, used float64, hasUsed bool) {
+	pvName = share.Metadata[volumeNameMetadataKey]
+	if pvName == "" {
+		// Shares not named by a template are named after the volume
+		pvName = share.Name
+	}
+
+	capacity = float64(int64(share.Size) * bytesInGiB)
+
+	if usedSizeMetadataKey != "" {
+		if v, ok := share.Metadata[usedSizeMetadataKey]; ok {
+			usedBytes, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				klog.Warningf("invalid used size %q in metadata %s of share %s: %v", v, usedSizeMetadataKey, share.ID, err)
+			} else {
+				used, hasUsed = float64(usedBytes), true
+			}
+		}
+	}
+
+	return
+}
+
+// readSecretDir reads a directory with one file per secret key, as created by mounting a Kubernetes Secret.
+func readSecretDir(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret directory %s: %v", dir, err)
+	}
+
+	secrets := make(map[string]string)
+	for _, e := range entries {
+		// Skip the hidden files and directories maintained by kubelet for atomic updates
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secret file %s: %v", e.Name(), err)
+		}
+		secrets[e.Name()] = string(data)
+	}
+
+	return secrets, nil
+}
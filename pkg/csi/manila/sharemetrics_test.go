@@ -0,0 +1,92 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+)
+
+func TestShareUsage(t *testing.T) {
+	ts := []struct {
+		share               shares.Share
+		usedSizeMetadataKey string
+		expectedPVName      string
+		expectedCapacity    float64
+		expectedUsed        float64
+		expectedHasUsed     bool
+	}{
+		{
+			// Share named after the volume, no used size key
+			share:            shares.Share{Name: "pvc-1", Size: 1},
+			expectedPVName:   "pvc-1",
+			expectedCapacity: 1 << 30,
+		},
+		{
+			// Share named by a template reports the volume name from its metadata
+			share:            shares.Share{Name: "cluster-ns-claim", Size: 2, Metadata: map[string]string{volumeNameMetadataKey: "pvc-2"}},
+			expectedPVName:   "pvc-2",
+			expectedCapacity: 2 << 30,
+		},
+		{
+			// Used size published in the share metadata
+			share:               shares.Share{Name: "pvc-3", Size: 1, Metadata: map[string]string{"used": "1024"}},
+			usedSizeMetadataKey: "used",
+			expectedPVName:      "pvc-3",
+			expectedCapacity:    1 << 30,
+			expectedUsed:        1024,
+			expectedHasUsed:     true,
+		},
+		{
+			// Invalid used size is ignored
+			share:               shares.Share{Name: "pvc-4", Size: 1, Metadata: map[string]string{"used": "a lot"}},
+			usedSizeMetadataKey: "used",
+			expectedPVName:      "pvc-4",
+			expectedCapacity:    1 << 30,
+		},
+	}
+
+	for i := range ts {
+		pvName, capacity, used, hasUsed := shareUsage(&ts[i].share, ts[i].usedSizeMetadataKey)
+		if pvName != ts[i].expectedPVName || capacity != ts[i].expectedCapacity || used != ts[i].expectedUsed || hasUsed != ts[i].expectedHasUsed {
+			t.Errorf("test case %d: expected (%s, %v, %v, %v), got (%s, %v, %v, %v)", i,
+				ts[i].expectedPVName, ts[i].expectedCapacity, ts[i].expectedUsed, ts[i].expectedHasUsed,
+				pvName, capacity, used, hasUsed)
+		}
+	}
+}
+
+func TestReadSecretDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "os-authURL"), []byte("https://keystone"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "..data"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	secrets, err := readSecretDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(secrets) != 1 || secrets["os-authURL"] != "https://keystone" {
+		t.Errorf("unexpected secrets: %v", secrets)
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
+	clouderrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+// resolveShareNetwork looks up the share network of the volume parameters, by ID or by name, and returns its ID.
+// The share network must have a subnet in the availability zone of the share, if any, otherwise Manila fails
+// to create the share server with an error only reported asynchronously in its user messages.
+func resolveShareNetwork(manilaClient manilaclient.Interface, shareOpts *options.ControllerVolumeContext) (string, error) {
+	shareNetworkID := shareOpts.ShareNetworkID
+
+	if shareOpts.ShareNetworkName != "" {
+		id, err := manilaClient.GetShareNetworkIDFromName(shareOpts.ShareNetworkName)
+		if err != nil {
+			if clouderrors.IsNotFound(err) {
+				return "", status.Errorf(codes.InvalidArgument, "share network %q not found", shareOpts.ShareNetworkName)
+			}
+			if _, ok := err.(gophercloud.ErrMultipleResourcesFound); ok {
+				return "", status.Errorf(codes.InvalidArgument, "share network name %q is ambiguous, use shareNetworkID instead: %v", shareOpts.ShareNetworkName, err)
+			}
+			return "", statusErrorf(codes.Internal, "failed to look up share network %q: %w", shareOpts.ShareNetworkName, err)
+		}
+		shareNetworkID = id
+	}
+
+	sn, err := manilaClient.GetShareNetwork(shareNetworkID)
+	if err != nil {
+		if clouderrors.IsNotFound(err) {
+			return "", status.Errorf(codes.InvalidArgument, "share network %s not found", shareNetworkID)
+		}
+		return "", statusErrorf(codes.Internal, "failed to retrieve share network %s: %w", shareNetworkID, err)
+	}
+
+	if az := shareOpts.AvailabilityZone; az != "" && !shareNetworkServesZone(sn, az) {
+		return "", status.Errorf(codes.InvalidArgument, "share network %s has no subnet in availability zone %s, its subnets are in %s",
+			sn.ID, az, strings.Join(sn.AvailabilityZones, ", "))
+	}
+
+	return sn.ID, nil
+}
+
+// shareNetworkServesZone reports whether the share network has a subnet in the availability zone, or a default
+// subnet serving all the zones.
+func shareNetworkServesZone(sn *manilaclient.ShareNetwork, az string) bool {
+	for _, zone := range sn.AvailabilityZones {
+		if zone == "" || zone == az {
+			return true
+		}
+	}
+
+	return false
+}
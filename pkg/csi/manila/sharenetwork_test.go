@@ -0,0 +1,117 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/sharenetworks"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
+)
+
+// fakeShareNetworkClient serves share networks by ID and by name.
+type fakeShareNetworkClient struct {
+	manilaclient.Interface
+	shareNetworks []manilaclient.ShareNetwork
+}
+
+func (c *fakeShareNetworkClient) GetShareNetwork(shareNetworkID string) (*manilaclient.ShareNetwork, error) {
+	for i := range c.shareNetworks {
+		if c.shareNetworks[i].ID == shareNetworkID {
+			return &c.shareNetworks[i], nil
+		}
+	}
+	return nil, gophercloud.ErrDefault404{}
+}
+
+func (c *fakeShareNetworkClient) GetShareNetworkIDFromName(shareNetworkName string) (string, error) {
+	var ids []string
+	for i := range c.shareNetworks {
+		if c.shareNetworks[i].Name == shareNetworkName {
+			ids = append(ids, c.shareNetworks[i].ID)
+		}
+	}
+
+	switch len(ids) {
+	case 0:
+		return "", gophercloud.ErrResourceNotFound{Name: shareNetworkName}
+	case 1:
+		return ids[0], nil
+	default:
+		return "", gophercloud.ErrMultipleResourcesFound{Name: shareNetworkName, Count: len(ids)}
+	}
+}
+
+func TestResolveShareNetwork(t *testing.T) {
+	newShareNetwork := func(id, name string, zones ...string) manilaclient.ShareNetwork {
+		return manilaclient.ShareNetwork{
+			ShareNetwork:      sharenetworks.ShareNetwork{ID: id, Name: name},
+			AvailabilityZones: zones,
+		}
+	}
+
+	c := &fakeShareNetworkClient{
+		shareNetworks: []manilaclient.ShareNetwork{
+			newShareNetwork("net-default", "default", ""),
+			newShareNetwork("net-zoned", "zoned", "zone-a", "zone-b"),
+			newShareNetwork("net-dup-1", "dup", ""),
+			newShareNetwork("net-dup-2", "dup", ""),
+		},
+	}
+
+	ts := []struct {
+		id, name, az string
+		expectedID   string
+		expectedCode codes.Code
+	}{
+		{id: "net-default", az: "zone-c", expectedID: "net-default"},
+		{name: "default", expectedID: "net-default"},
+		{name: "zoned", az: "zone-b", expectedID: "net-zoned"},
+		{id: "net-zoned", expectedID: "net-zoned"},
+		{id: "net-zoned", az: "zone-c", expectedCode: codes.InvalidArgument},
+		{id: "net-missing", expectedCode: codes.InvalidArgument},
+		{name: "missing", expectedCode: codes.InvalidArgument},
+		{name: "dup", expectedCode: codes.InvalidArgument},
+	}
+
+	for _, tc := range ts {
+		shareOpts := &options.ControllerVolumeContext{ShareNetworkID: tc.id, ShareNetworkName: tc.name, AvailabilityZone: tc.az}
+
+		id, err := resolveShareNetwork(c, shareOpts)
+		if status.Code(err) != tc.expectedCode {
+			t.Errorf("%q/%q in %q: expected code %v, got %v", tc.id, tc.name, tc.az, tc.expectedCode, err)
+			continue
+		}
+		if id != tc.expectedID {
+			t.Errorf("%q/%q in %q: expected share network %q, got %q", tc.id, tc.name, tc.az, tc.expectedID, id)
+		}
+	}
+}
+
+func TestShareNetworkNameAndID(t *testing.T) {
+	if _, err := options.NewControllerVolumeContext(map[string]string{"protocol": "NFS", "shareNetworkID": "net", "shareNetworkName": "net"}); err == nil {
+		t.Error("expected shareNetworkID and shareNetworkName to be mutually exclusive")
+	}
+
+	if _, err := options.NewControllerVolumeContext(map[string]string{"protocol": "CIFS", "cifs-shareUser": "user", "shareNetworkName": "net", "securityServiceID": "ad"}); err != nil {
+		t.Errorf("expected securityServiceID with shareNetworkName to be accepted: %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// sizeGranularityMetadataKey records the shareSizeGranularity of the share in GiB, so that its expansions are
+// rounded up too.
+const sizeGranularityMetadataKey = "manila.csi.openstack.org/size-granularity"
+
+// errExceedsLimit is returned when the rounded share size exceeds the limit of the capacity range.
+var errExceedsLimit = errors.New("share size exceeds the capacity range limit")
+
+// Policies for the requested sizes not matching the share size constraints, see ControllerVolumeContext.ShareSizePolicy
+const (
+	shareSizePolicyRound  = "round"
+	shareSizePolicyReject = "reject"
+)
+
+// shareSizePolicy is the minimum size and the granularity of the shares, set with the minShareSize and
+// shareSizeGranularity parameters of the StorageClass for the backends rejecting the other sizes.
+type shareSizePolicy struct {
+	minGiB         int
+	granularityGiB int
+	reject         bool
+}
+
+// parseGiB parses a quantity which must be a positive number of GiB.
+func parseGiB(param, value string) (int, error) {
+	q, err := resource.ParseQuantity(value)
+	if err != nil || q.Sign() <= 0 || q.Value()%bytesInGiB != 0 {
+		return 0, fmt.Errorf("%s must be a positive multiple of 1Gi, got %q", param, value)
+	}
+	return int(q.Value() / bytesInGiB), nil
+}
+
+// newShareSizePolicy parses the share size volume parameters.
+func newShareSizePolicy(minSize, granularity, policy string) (*shareSizePolicy, error) {
+	p := &shareSizePolicy{
+		minGiB:         1,
+		granularityGiB: 1,
+		reject:         policy == shareSizePolicyReject,
+	}
+
+	var err error
+	if minSize != "" {
+		if p.minGiB, err = parseGiB("minShareSize", minSize); err != nil {
+			return nil, err
+		}
+	}
+	if granularity != "" {
+		if p.granularityGiB, err = parseGiB("shareSizeGranularity", granularity); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// roundUp rounds the size up to a multiple of the granularity.
+func roundUp(sizeInGiB, granularityGiB int) int {
+	if r := sizeInGiB % granularityGiB; r != 0 {
+		sizeInGiB += granularityGiB - r
+	}
+	return sizeInGiB
+}
+
+// shareSize returns the size of the share of the requested capacity range. With the reject policy, a required size
+// which isn't at least the minimum size or not a multiple of the granularity is an error instead of being rounded up.
+func (p *shareSizePolicy) shareSize(requiredBytes, limitBytes int64) (int, error) {
+	sizeInGiB := bytesToGiB(requiredBytes)
+
+	if sizeInGiB < p.minGiB {
+		if p.reject {
+			return 0, fmt.Errorf("requested size %dGiB is below the minimum share size %dGiB", sizeInGiB, p.minGiB)
+		}
+		sizeInGiB = p.minGiB
+	}
+
+	if rounded := roundUp(sizeInGiB, p.granularityGiB); rounded != sizeInGiB {
+		if p.reject {
+			return 0, fmt.Errorf("requested size %dGiB is not a multiple of the share size granularity %dGiB", sizeInGiB, p.granularityGiB)
+		}
+		sizeInGiB = rounded
+	}
+
+	if limitBytes > 0 && int64(sizeInGiB)*bytesInGiB > limitBytes {
+		return 0, fmt.Errorf("%w: %dGiB > %d bytes", errExceedsLimit, sizeInGiB, limitBytes)
+	}
+
+	return sizeInGiB, nil
+}
+
+// metadata adds the granularity to the share metadata unless it's the default one.
+func (p *shareSizePolicy) metadata(shareMetadata map[string]string) {
+	if p.granularityGiB > 1 {
+		shareMetadata[sizeGranularityMetadataKey] = strconv.Itoa(p.granularityGiB)
+	}
+}
+
+// expandedShareSize returns the size the share is expanded to, rounded up to the granularity recorded in its
+// metadata.
+func expandedShareSize(desiredSizeInGiB int, shareMetadata map[string]string, limitBytes int64) (int, error) {
+	if g, err := strconv.Atoi(shareMetadata[sizeGranularityMetadataKey]); err == nil && g > 1 {
+		desiredSizeInGiB = roundUp(desiredSizeInGiB, g)
+	}
+
+	if limitBytes > 0 && int64(desiredSizeInGiB)*bytesInGiB > limitBytes {
+		return 0, fmt.Errorf("%w: %dGiB > %d bytes", errExceedsLimit, desiredSizeInGiB, limitBytes)
+	}
+
+	return desiredSizeInGiB, nil
+}
+
+// shareSizeError converts an error of shareSize or expandedShareSize to a gRPC status: OutOfRange when the capacity
+// range limit can't be satisfied, InvalidArgument otherwise.
+func shareSizeError(err error) error {
+	if errors.Is(err, errExceedsLimit) {
+		return status.Errorf(codes.OutOfRange, "invalid volume size: %v", err)
+	}
+	return status.Errorf(codes.InvalidArgument, "invalid volume size: %v", err)
+}
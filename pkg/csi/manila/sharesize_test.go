@@ -0,0 +1,113 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestShareSizePolicy(t *testing.T) {
+	ts := []struct {
+		minSize, granularity, policy string
+		required, limit              int64
+		expected                     int
+		err                          bool
+	}{
+		{required: 1, expected: 1},
+		{required: 5*bytesInGiB + 1, expected: 6},
+		{minSize: "10Gi", required: bytesInGiB, expected: 10},
+		{minSize: "10Gi", required: 12 * bytesInGiB, expected: 12},
+		{granularity: "4Gi", required: 5 * bytesInGiB, expected: 8},
+		{minSize: "10Gi", granularity: "4Gi", required: bytesInGiB, expected: 12},
+		{granularity: "4Gi", required: 5 * bytesInGiB, limit: 6 * bytesInGiB, err: true},
+		{granularity: "4Gi", required: 5 * bytesInGiB, limit: 8 * bytesInGiB, expected: 8},
+		{minSize: "10Gi", policy: shareSizePolicyReject, required: bytesInGiB, err: true},
+		{granularity: "4Gi", policy: shareSizePolicyReject, required: 5 * bytesInGiB, err: true},
+		{minSize: "10Gi", granularity: "4Gi", policy: shareSizePolicyReject, required: 12 * bytesInGiB, expected: 12},
+		{minSize: "0", required: bytesInGiB, err: true},
+		{minSize: "1500Mi", required: bytesInGiB, err: true},
+		{granularity: "four", required: bytesInGiB, err: true},
+	}
+
+	for _, tc := range ts {
+		p, err := newShareSizePolicy(tc.minSize, tc.granularity, tc.policy)
+		var size int
+		if err == nil {
+			size, err = p.shareSize(tc.required, tc.limit)
+		}
+		if tc.err {
+			if err == nil {
+				t.Errorf("shareSize(%q, %q, %q, %d, %d): expected an error", tc.minSize, tc.granularity, tc.policy, tc.required, tc.limit)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("shareSize(%q, %q, %q, %d, %d): unexpected error: %v", tc.minSize, tc.granularity, tc.policy, tc.required, tc.limit, err)
+			continue
+		}
+		if size != tc.expected {
+			t.Errorf("shareSize(%q, %q, %q, %d, %d): expected %dGiB, got %dGiB", tc.minSize, tc.granularity, tc.policy, tc.required, tc.limit, tc.expected, size)
+		}
+	}
+}
+
+func TestExpandedShareSize(t *testing.T) {
+	p, err := newShareSizePolicy("", "4Gi", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	md := make(map[string]string)
+	p.metadata(md)
+	if md[sizeGranularityMetadataKey] != "4" {
+		t.Fatalf("expected the granularity in the share metadata, got %v", md)
+	}
+
+	if size, err := expandedShareSize(9, md, 0); err != nil || size != 12 {
+		t.Errorf("expected 12GiB, got %dGiB, %v", size, err)
+	}
+	if size, err := expandedShareSize(9, nil, 0); err != nil || size != 9 {
+		t.Errorf("expected 9GiB without granularity, got %dGiB, %v", size, err)
+	}
+	if _, err := expandedShareSize(9, md, 10*bytesInGiB); !errors.Is(err, errExceedsLimit) {
+		t.Errorf("expected errExceedsLimit when the rounded size exceeds the limit, got %v", err)
+	}
+}
+
+func TestShareSizeError(t *testing.T) {
+	round, err := newShareSizePolicy("", "4Gi", shareSizePolicyRound)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reject, err := newShareSizePolicy("", "4Gi", shareSizePolicyReject)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = round.shareSize(5*bytesInGiB, 6*bytesInGiB)
+	if code := status.Code(shareSizeError(err)); code != codes.OutOfRange {
+		t.Errorf("expected OutOfRange when the rounded size exceeds the limit, got %s", code)
+	}
+
+	_, err = reject.shareSize(5*bytesInGiB, 0)
+	if code := status.Code(shareSizeError(err)); code != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for a size rejected by the policy, got %s", code)
+	}
+}
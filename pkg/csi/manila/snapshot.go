@@ -23,6 +23,7 @@ import (
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/snapshots"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	manilautil "k8s.io/cloud-provider-openstack/pkg/csi/manila/util"
 	clouderrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
 	"k8s.io/klog/v2"
 )
@@ -63,7 +64,7 @@ func getOrCreateSnapshot(manilaClient manilaclient.Interface, snapName, sourceSh
 
 		} else {
 			// Something else is wrong
-			return nil, fmt.Errorf("failed to probe for a snapshot named %s: %v", snapName, err)
+			return nil, fmt.Errorf("failed to probe for a snapshot named %s: %w", snapName, err)
 		}
 	} else {
 		klog.V(4).Infof("a snapshot named %s already exists", snapName)
@@ -84,7 +85,7 @@ func deleteSnapshot(manilaClient manilaclient.Interface, snapID string) error {
 	return nil
 }
 
-func tryDeleteSnapshot(manilaClient manilaclient.Interface, snapshot *snapshots.Snapshot) {
+func tryDeleteSnapshot(manilaClient manilaclient.Interface, snapshot *snapshots.Snapshot, timeout time.Duration) {
 	if snapshot == nil {
 		return
 	}
@@ -95,19 +96,15 @@ func tryDeleteSnapshot(manilaClient manilaclient.Interface, snapshot *snapshots.
 		return
 	}
 
-	_, _, err := waitForSnapshotStatus(manilaClient, snapshot.ID, snapshotDeleting, "", true)
+	_, _, err := waitForSnapshotStatus(manilaClient, snapshot.ID, snapshotDeleting, "", true, timeout)
 	if err != nil && !wait.Interrupted(err) {
 		klog.Errorf("couldn't retrieve snapshot %s in a roll-back procedure: %v", snapshot.ID, err)
 	}
 }
 
-func waitForSnapshotStatus(manilaClient manilaclient.Interface, snapshotID, currentStatus, desiredStatus string, successOnNotFound bool) (*snapshots.Snapshot, manilaError, error) {
+func waitForSnapshotStatus(manilaClient manilaclient.Interface, snapshotID, currentStatus, desiredStatus string, successOnNotFound bool, timeout time.Duration) (*snapshots.Snapshot, manilaError, error) {
 	var (
-		backoff = wait.Backoff{
-			Duration: time.Second * waitForAvailableShareTimeout,
-			Factor:   1.2,
-			Steps:    waitForAvailableShareRetries,
-		}
+		backoff = manilautil.WaitBackoff(shareWaitInterval, timeout)
 
 		snapshot      *snapshots.Snapshot
 		manilaErrCode manilaError
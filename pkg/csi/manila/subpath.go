@@ -0,0 +1,176 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/csiclient"
+	"k8s.io/klog/v2"
+	mountutil "k8s.io/mount-utils"
+)
+
+const (
+	// shareRootDirName is the directory next to the publish target path where
+	// the whole share is mounted when only a sub-path of it is published.
+	shareRootDirName = "manila-share-root"
+
+	subPathDirPerm = 0755
+)
+
+// subPathVolumeContextKeys are the csi-provisioner --extra-create-metadata
+// parameters which are passed on to the volume context so that
+// subPathPattern can reference them.
+var subPathVolumeContextKeys = []string{
+	"csi.storage.k8s.io/pv/name",
+	"csi.storage.k8s.io/pvc/namespace",
+	"csi.storage.k8s.io/pvc/name",
+}
+
+// renderSubPath executes the subPathPattern template. The template may reference
+// {{ .PVName }}, {{ .PVCNamespace }} and {{ .PVCName }}, which are taken from the
+// csi.storage.k8s.io/* keys of the volume context. Referencing a field that is
+// not available is an error. The result must be a relative path inside the share.
+func renderSubPath(pattern string, volumeContext map[string]string) (string, error) {
+	tmpl, err := template.New("subPath").Option("missingkey=error").Parse(pattern)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse sub-path pattern %q: %v", pattern, err)
+	}
+
+	data := make(map[string]string)
+
+	if v, ok := volumeContext["csi.storage.k8s.io/pv/name"]; ok {
+		data["PVName"] = v
+	}
+
+	if v, ok := volumeContext["csi.storage.k8s.io/pvc/namespace"]; ok {
+		data["PVCNamespace"] = v
+	}
+
+	if v, ok := volumeContext["csi.storage.k8s.io/pvc/name"]; ok {
+		data["PVCName"] = v
+	}
+
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render sub-path pattern %q: %v", pattern, err)
+	}
+
+	subPath := filepath.Clean(buf.String())
+	if subPath == "." || filepath.IsAbs(subPath) || subPath == ".." || strings.HasPrefix(subPath, "../") {
+		return "", fmt.Errorf("sub-path pattern %q produced an invalid path %q", pattern, buf.String())
+	}
+
+	return subPath, nil
+}
+
+func shareRootPath(targetPath string) string {
+	return filepath.Join(filepath.Dir(filepath.Clean(targetPath)), shareRootDirName)
+}
+
+// publishSubPath publishes the whole share into a directory next to the target
+// path using the partner node plugin, creates the sub-path directory if it
+// doesn't exist yet and bind-mounts it to the target path. Symlinks in the
+// sub-path are refused.
+func (ns *nodeServer) publishSubPath(ctx context.Context, nodeClient csiclient.Node, req *csi.NodePublishVolumeRequest, subPath string) (*csi.NodePublishVolumeResponse, error) {
+	targetPath := req.GetTargetPath()
+
+	notMnt, err := ns.mounter.IsLikelyNotMountPoint(targetPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, status.Errorf(codes.Internal, "failed to check mount point %s: %v", targetPath, err)
+	}
+	if err == nil && !notMnt {
+		// Already published
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	// The share root is always published read-write so that the sub-path can be
+	// created, the read-only flag is applied to the bind mount instead.
+	rootPath := shareRootPath(targetPath)
+	rootReq := *req
+	rootReq.TargetPath = rootPath
+	rootReq.Readonly = false
+
+	if _, err := nodeClient.PublishVolume(ctx, &rootReq); err != nil {
+		return nil, err
+	}
+
+	// The share is writable by its users, the sub-path is resolved without
+	// following symlinks and the resolved directory is bind-mounted through its
+	// file descriptor, so that it cannot be swapped for a symlink in the meantime.
+	subPathDir, err := openSubPath(rootPath, subPath)
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "failed to open sub-path %s in volume %s: %v", subPath, req.GetVolumeId(), err)
+	}
+	defer subPathDir.Close()
+	sourcePath := fmt.Sprintf("/proc/%d/fd/%d", os.Getpid(), subPathDir.Fd())
+
+	if err := os.MkdirAll(targetPath, 0750); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create target path %s: %v", targetPath, err)
+	}
+
+	mountOptions := []string{"bind"}
+	if req.GetReadonly() {
+		mountOptions = append(mountOptions, "ro")
+	}
+
+	if err := ns.mounter.Mount(sourcePath, targetPath, "", mountOptions); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to bind-mount sub-path %s of volume %s to %s: %v", subPath, req.GetVolumeId(), targetPath, err)
+	}
+
+	klog.V(4).Infof("published sub-path %s of volume %s to %s", subPath, req.GetVolumeId(), targetPath)
+
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// unpublishSubPath reverts publishSubPath. It returns false if the volume was
+// not published with a sub-path.
+func (ns *nodeServer) unpublishSubPath(ctx context.Context, nodeClient csiclient.Node, req *csi.NodeUnpublishVolumeRequest) (bool, error) {
+	rootPath := shareRootPath(req.GetTargetPath())
+	if _, err := os.Stat(rootPath); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, status.Errorf(codes.Internal, "failed to stat %s: %v", rootPath, err)
+	}
+
+	if err := mountutil.CleanupMountPoint(req.GetTargetPath(), ns.mounter, false); err != nil {
+		return true, status.Errorf(codes.Internal, "failed to unmount %s: %v", req.GetTargetPath(), err)
+	}
+
+	rootReq := *req
+	rootReq.TargetPath = rootPath
+
+	if _, err := nodeClient.UnpublishVolume(ctx, &rootReq); err != nil {
+		return true, err
+	}
+
+	// Only an empty directory is removed, in case the share is still mounted
+	if err := os.Remove(rootPath); err != nil && !os.IsNotExist(err) {
+		return true, status.Errorf(codes.Internal, "failed to remove %s: %v", rootPath, err)
+	}
+
+	return true, nil
+}
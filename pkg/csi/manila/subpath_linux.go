@@ -0,0 +1,80 @@
+//go:build linux
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// openSubPath opens the sub-path directory of the share mounted at root and
+// creates its missing directories. Each component is opened relative to its
+// parent without following symlinks, so that a symlink created in the share
+// by its users cannot make the sub-path resolve outside of it. The returned
+// file is an O_PATH descriptor of the sub-path directory.
+func openSubPath(root, subPath string) (*os.File, error) {
+	fd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", root, err)
+	}
+
+	for _, name := range strings.Split(subPath, "/") {
+		child, err := openSubPathDir(fd, name)
+		unix.Close(fd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %q of sub-path %s: %v", name, subPath, err)
+		}
+		fd = child
+	}
+
+	return os.NewFile(uintptr(fd), filepath.Join(root, subPath)), nil
+}
+
+// openSubPathDir opens, and creates if needed, the directory name of parent.
+// It fails if name is anything else than a directory, e.g. a symlink.
+func openSubPathDir(parent int, name string) (int, error) {
+	if name == "" || name == "." || name == ".." {
+		return -1, fmt.Errorf("invalid path component")
+	}
+
+	if err := unix.Mkdirat(parent, name, subPathDirPerm); err != nil && err != unix.EEXIST {
+		return -1, err
+	}
+
+	fd, err := unix.Openat(parent, name, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		unix.Close(fd)
+		return -1, fmt.Errorf("not a directory")
+	}
+
+	return fd, nil
+}
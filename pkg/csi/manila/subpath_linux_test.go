@@ -0,0 +1,73 @@
+//go:build linux
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenSubPath(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	// Missing directories are created
+	f, err := openSubPath(root, "ns/pvc")
+	if err != nil {
+		t.Fatalf("failed to open sub-path: %v", err)
+	}
+	f.Close()
+	if fi, err := os.Stat(filepath.Join(root, "ns", "pvc")); err != nil || !fi.IsDir() {
+		t.Errorf("sub-path directory not created: %v", err)
+	}
+
+	// Existing directories are opened
+	f, err = openSubPath(root, "ns/pvc")
+	if err != nil {
+		t.Fatalf("failed to open existing sub-path: %v", err)
+	}
+	f.Close()
+
+	// Symlinks are refused, wherever they are in the sub-path
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "ns", "escape")); err != nil {
+		t.Fatal(err)
+	}
+	for _, subPath := range []string{"escape", "escape/pvc", "ns/escape", "ns/escape/pvc"} {
+		if f, err := openSubPath(root, subPath); err == nil {
+			f.Close()
+			t.Errorf("sub-path %s through a symlink was opened", subPath)
+		}
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("directories created outside of the share: %v", entries)
+	}
+
+	// Files are refused
+	if err := os.WriteFile(filepath.Join(root, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := openSubPath(root, "file"); err == nil {
+		f.Close()
+		t.Error("file opened as a sub-path")
+	}
+}
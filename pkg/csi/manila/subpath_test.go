@@ -0,0 +1,99 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"testing"
+)
+
+func TestRenderSubPath(t *testing.T) {
+	volCtx := map[string]string{
+		"csi.storage.k8s.io/pv/name":       "pv-1",
+		"csi.storage.k8s.io/pvc/namespace": "ns",
+		"csi.storage.k8s.io/pvc/name":      "claim",
+	}
+
+	ts := []struct {
+		pattern          string
+		volumeContext    map[string]string
+		expectedSubPath  string
+		expectedErrorSet bool
+	}{
+		{
+			pattern:         "{{ .PVCNamespace }}/{{ .PVCName }}",
+			volumeContext:   volCtx,
+			expectedSubPath: "ns/claim",
+		},
+		{
+			pattern:         "volumes/{{ .PVName }}/",
+			volumeContext:   volCtx,
+			expectedSubPath: "volumes/pv-1",
+		},
+		{
+			// Static sub-path
+			pattern:         "data",
+			volumeContext:   map[string]string{},
+			expectedSubPath: "data",
+		},
+		{
+			// Referenced field is not available
+			pattern:          "{{ .PVCName }}",
+			volumeContext:    map[string]string{},
+			expectedErrorSet: true,
+		},
+		{
+			// Escaping the share is not allowed
+			pattern:          "../{{ .PVCName }}",
+			volumeContext:    volCtx,
+			expectedErrorSet: true,
+		},
+		{
+			pattern:          "/{{ .PVCName }}",
+			volumeContext:    volCtx,
+			expectedErrorSet: true,
+		},
+		{
+			// Empty result
+			pattern:          "{{ if false }}x{{ end }}",
+			volumeContext:    volCtx,
+			expectedErrorSet: true,
+		},
+	}
+
+	for i := range ts {
+		subPath, err := renderSubPath(ts[i].pattern, ts[i].volumeContext)
+		if ts[i].expectedErrorSet {
+			if err == nil {
+				t.Errorf("test case %d: expected an error, got sub-path %q", i, subPath)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("test case %d: unexpected error: %v", i, err)
+		} else if subPath != ts[i].expectedSubPath {
+			t.Errorf("test case %d: expected sub-path %q, got %q", i, ts[i].expectedSubPath, subPath)
+		}
+	}
+}
+
+func TestShareRootPath(t *testing.T) {
+	expected := "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pv/" + shareRootDirName
+	if p := shareRootPath("/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pv/mount/"); p != expected {
+		t.Errorf("expected %q, got %q", expected, p)
+	}
+}
@@ -20,12 +20,14 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/messages"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/snapshots"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
 	"k8s.io/klog/v2"
@@ -82,6 +84,59 @@ type manilaErrorMessage struct {
 	message string
 }
 
+// statusError is a gRPC status error keeping the error it was formatted from, so that the causes formatted with %w
+// can be checked with errors.Is.
+type statusError struct {
+	status *status.Status
+	err    error
+}
+
+func (e *statusError) Error() string {
+	return e.status.Err().Error()
+}
+
+func (e *statusError) GRPCStatus() *status.Status {
+	return e.status
+}
+
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
+// statusErrorf is status.Errorf wrapping the errors formatted with %w.
+func statusErrorf(c codes.Code, format string, a ...interface{}) error {
+	err := fmt.Errorf(format, a...)
+	return &statusError{status: status.New(c, err.Error()), err: err}
+}
+
+// circuitOpenToUnavailable returns the errors caused by the open Manila circuit breaker with the Unavailable code, so
+// that the CSI sidecars back off.
+func circuitOpenToUnavailable(err error) error {
+	if err == nil || status.Code(err) == codes.Unavailable || !errors.Is(err, manilaclient.ErrCircuitOpen) {
+		return err
+	}
+
+	return status.Error(codes.Unavailable, status.Convert(err).Message())
+}
+
+// parseWaitTimeout parses the wait timeout of a volume parameter, defaulting to def when empty.
+func parseWaitTimeout(value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if timeout <= 0 {
+		return 0, fmt.Errorf("timeout must be positive, got %v", timeout)
+	}
+
+	return timeout, nil
+}
+
 func parseGRPCEndpoint(endpoint string) (proto, addr string, err error) {
 	const (
 		unixScheme = "unix://"
@@ -224,6 +279,56 @@ func validateDeleteSnapshotRequest(req *csi.DeleteSnapshotRequest) error {
 	return nil
 }
 
+func validateCreateVolumeGroupSnapshotRequest(req *csi.CreateVolumeGroupSnapshotRequest) error {
+	if req.GetName() == "" {
+		return errors.New("group snapshot name cannot be empty")
+	}
+
+	if len(req.GetSourceVolumeIds()) == 0 {
+		return errors.New("source volume IDs cannot be empty")
+	}
+
+	for _, id := range req.GetSourceVolumeIds() {
+		if id == "" {
+			return errors.New("source volume ID cannot be empty")
+		}
+	}
+
+	if req.GetSecrets() == nil || len(req.GetSecrets()) == 0 {
+		return errors.New("secrets cannot be nil or empty")
+	}
+
+	if req.GetParameters() != nil {
+		klog.Info("parameters in CreateVolumeGroupSnapshot requests are ignored")
+	}
+
+	return nil
+}
+
+func validateDeleteVolumeGroupSnapshotRequest(req *csi.DeleteVolumeGroupSnapshotRequest) error {
+	if req.GetGroupSnapshotId() == "" {
+		return errors.New("group snapshot ID cannot be empty")
+	}
+
+	if req.GetSecrets() == nil || len(req.GetSecrets()) == 0 {
+		return errors.New("secrets cannot be nil or empty")
+	}
+
+	return nil
+}
+
+func validateGetVolumeGroupSnapshotRequest(req *csi.GetVolumeGroupSnapshotRequest) error {
+	if req.GetGroupSnapshotId() == "" {
+		return errors.New("group snapshot ID cannot be empty")
+	}
+
+	if req.GetSecrets() == nil || len(req.GetSecrets()) == 0 {
+		return errors.New("secrets cannot be nil or empty")
+	}
+
+	return nil
+}
+
 func coalesceValue(v string) string {
 	if v == "" {
 		return "<none>"
@@ -304,10 +409,73 @@ func validateControllerExpandVolumeRequest(req *csi.ControllerExpandVolumeReques
 	return nil
 }
 
+func validateControllerModifyVolumeRequest(req *csi.ControllerModifyVolumeRequest) error {
+	if req.GetVolumeId() == "" {
+		return errors.New("volume ID missing in request")
+	}
+
+	params := req.GetMutableParameters()
+	if len(params) == 0 {
+		return errors.New("mutable parameters cannot be nil or empty")
+	}
+
+	for k := range params {
+		if k != activeReplicaAvailabilityParam {
+			return fmt.Errorf("unsupported mutable parameter %q", k)
+		}
+	}
+
+	if params[activeReplicaAvailabilityParam] == "" {
+		return fmt.Errorf("%s cannot be empty", activeReplicaAvailabilityParam)
+	}
+
+	if req.GetSecrets() == nil || len(req.GetSecrets()) == 0 {
+		return errors.New("volume modify secrets cannot be nil or empty")
+	}
+
+	return nil
+}
+
 //
 // Node service request validation
 //
 
+func validateControllerPublishVolumeRequest(req *csi.ControllerPublishVolumeRequest) error {
+	if req.GetVolumeId() == "" {
+		return errors.New("volume ID missing in request")
+	}
+
+	if req.GetNodeId() == "" {
+		return errors.New("node ID missing in request")
+	}
+
+	if req.GetVolumeCapability() == nil {
+		return errors.New("volume capability missing in request")
+	}
+
+	if req.GetSecrets() == nil || len(req.GetSecrets()) == 0 {
+		return errors.New("controller publish secrets cannot be nil or empty")
+	}
+
+	return nil
+}
+
+func validateControllerUnpublishVolumeRequest(req *csi.ControllerUnpublishVolumeRequest) error {
+	if req.GetVolumeId() == "" {
+		return errors.New("volume ID missing in request")
+	}
+
+	if req.GetNodeId() == "" {
+		return errors.New("node ID missing in request")
+	}
+
+	if req.GetSecrets() == nil || len(req.GetSecrets()) == 0 {
+		return errors.New("controller unpublish secrets cannot be nil or empty")
+	}
+
+	return nil
+}
+
 func validateNodeStageVolumeRequest(req *csi.NodeStageVolumeRequest) error {
 	if req.GetVolumeCapability() == nil {
 		return errors.New("volume capability missing in request")
@@ -371,3 +539,38 @@ func validateNodeUnpublishVolumeRequest(req *csi.NodeUnpublishVolumeRequest) err
 
 	return nil
 }
+
+func validateNodeGetVolumeStatsRequest(req *csi.NodeGetVolumeStatsRequest) error {
+	if req.GetVolumeId() == "" {
+		return errors.New("volume ID missing in request")
+	}
+
+	if req.GetVolumePath() == "" {
+		return errors.New("volume path missing in request")
+	}
+
+	return nil
+}
+
+// exportLocationZones returns the availability zone of the export locations of the share: the zone of their share
+// instance, i.e. of their replica, for replicated shares, and the zone of the share otherwise.
+func exportLocationZones(manilaClient manilaclient.Interface, share *shares.Share) func(*shares.ExportLocation) string {
+	zones := make(map[string]string)
+	if share.ReplicationType != "" {
+		rs, err := manilaClient.GetShareReplicas(share.ID)
+		if err != nil {
+			klog.Warningf("failed to list replicas of share %s, assuming its export locations are in availability zone %s: %v",
+				share.ID, share.AvailabilityZone, err)
+		}
+		for _, r := range rs {
+			zones[r.ID] = r.AvailabilityZone
+		}
+	}
+
+	return func(loc *shares.ExportLocation) string {
+		if zone, ok := zones[loc.ShareInstanceID]; ok {
+			return zone
+		}
+		return share.AvailabilityZone
+	}
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// WaitBackoff returns the backoff of a wait polling Manila every interval at first,
+// increasing the interval by 20% after each poll. The last poll happens once
+// the time slept in between the polls reaches timeout.
+func WaitBackoff(interval, timeout time.Duration) wait.Backoff {
+	steps := 1
+
+	for slept, d := time.Duration(0), interval; slept < timeout && d > 0; d = time.Duration(float64(d) * 1.2) {
+		slept += d
+		steps++
+	}
+
+	return wait.Backoff{
+		Duration: interval,
+		Factor:   1.2,
+		Steps:    steps,
+	}
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaitBackoff(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		timeout  time.Duration
+		steps    int
+	}{
+		{3 * time.Second, time.Minute, 10},
+		{5 * time.Second, 90 * time.Second, 10},
+		{3 * time.Second, 10 * time.Minute, 22},
+		{3 * time.Second, time.Second, 2},
+		{3 * time.Second, 0, 1},
+	}
+
+	for _, tt := range tests {
+		b := WaitBackoff(tt.interval, tt.timeout)
+		if b.Steps != tt.steps {
+			t.Errorf("WaitBackoff(%v, %v): expected %d steps, got %d", tt.interval, tt.timeout, tt.steps, b.Steps)
+		}
+
+		// The polls of the backoff must cover the timeout
+		var slept time.Duration
+		for b.Steps > 1 {
+			slept += b.Step()
+		}
+		if slept < tt.timeout {
+			t.Errorf("WaitBackoff(%v, %v): sleeps only %v", tt.interval, tt.timeout, slept)
+		}
+	}
+}
@@ -15,6 +15,9 @@ package util
 
 import (
 	"errors"
+	"fmt"
+	"net"
+	"sort"
 	"strings"
 
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
@@ -66,3 +69,135 @@ func FindExportLocation(locs []shares.ExportLocation, pred ExportLocationPredica
 
 	return firstMatchNotPreferred, err
 }
+
+// Returns indices of the non-admin, non-empty export locations from the `locs` slice,
+// in the order they should be tried when mounting the share, following the same bias
+// as FindExportLocation: Preferred locations first, then the lower indices first.
+func SortExportLocations(locs []shares.ExportLocation) []int {
+	p, _ := ParseExportLocationPolicy(DefaultExportLocationPolicy)
+	return p.Sort(locs, "", nil)
+}
+
+const (
+	// ExportLocationRulePreferred ranks the locations marked as preferred by Manila first
+	ExportLocationRulePreferred = "preferred"
+	// ExportLocationRuleZone ranks the locations of the share instances in the availability zone of the node first
+	ExportLocationRuleZone = "zone"
+	// ExportLocationRuleCIDRPrefix prefixes a CIDR, ranking the locations whose address is in the CIDR first
+	ExportLocationRuleCIDRPrefix = "cidr:"
+
+	// DefaultExportLocationPolicy is the ranking of SortExportLocations
+	DefaultExportLocationPolicy = ExportLocationRulePreferred
+)
+
+type exportLocationRule struct {
+	preferred bool
+	zone      bool
+	cidr      *net.IPNet
+}
+
+// ExportLocationPolicy ranks the export locations of a share: the locations matching the first rule
+// come first, the ties are broken by the next rules, and finally by the order of the locations.
+type ExportLocationPolicy struct {
+	rules []exportLocationRule
+}
+
+// ParseExportLocationPolicy parses a comma-separated list of rules,
+// e.g. "zone,cidr:10.0.0.0/24,preferred".
+func ParseExportLocationPolicy(policy string) (*ExportLocationPolicy, error) {
+	p := &ExportLocationPolicy{}
+
+	for _, r := range strings.Split(policy, ",") {
+		r = strings.TrimSpace(r)
+
+		switch {
+		case r == ExportLocationRulePreferred:
+			p.rules = append(p.rules, exportLocationRule{preferred: true})
+		case r == ExportLocationRuleZone:
+			p.rules = append(p.rules, exportLocationRule{zone: true})
+		case strings.HasPrefix(r, ExportLocationRuleCIDRPrefix):
+			_, cidr, err := net.ParseCIDR(strings.TrimPrefix(r, ExportLocationRuleCIDRPrefix))
+			if err != nil {
+				return nil, fmt.Errorf("invalid export location rule %q: %v", r, err)
+			}
+			p.rules = append(p.rules, exportLocationRule{cidr: cidr})
+		default:
+			return nil, fmt.Errorf("invalid export location rule %q, expected %s, %s or %s<CIDR>",
+				r, ExportLocationRulePreferred, ExportLocationRuleZone, ExportLocationRuleCIDRPrefix)
+		}
+	}
+
+	return p, nil
+}
+
+// UsesZones returns whether the policy ranks the locations by availability zone.
+func (p *ExportLocationPolicy) UsesZones() bool {
+	for _, r := range p.rules {
+		if r.zone {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Sort returns indices of the non-admin, non-empty export locations from the `locs` slice, ranked by the policy.
+// zoneOf returns the availability zone of a location, it's only called by the zone rule.
+func (p *ExportLocationPolicy) Sort(locs []shares.ExportLocation, nodeAZ string, zoneOf func(loc *shares.ExportLocation) string) []int {
+	var idxs []int
+	for i := range locs {
+		if !locs[i].IsAdminOnly && strings.TrimSpace(locs[i].Path) != "" {
+			idxs = append(idxs, i)
+		}
+	}
+
+	matches := make(map[int][]bool, len(idxs))
+	for _, i := range idxs {
+		m := make([]bool, len(p.rules))
+		for j, r := range p.rules {
+			switch {
+			case r.preferred:
+				m[j] = locs[i].Preferred
+			case r.zone:
+				m[j] = nodeAZ != "" && zoneOf != nil && zoneOf(&locs[i]) == nodeAZ
+			case r.cidr != nil:
+				ip := net.ParseIP(ExportLocationHost(locs[i].Path))
+				m[j] = ip != nil && r.cidr.Contains(ip)
+			}
+		}
+		matches[i] = m
+	}
+
+	sort.SliceStable(idxs, func(a, b int) bool {
+		ma, mb := matches[idxs[a]], matches[idxs[b]]
+		for j := range ma {
+			if ma[j] != mb[j] {
+				return ma[j]
+			}
+		}
+		return false
+	})
+
+	return idxs
+}
+
+// ExportLocationHost returns the host of the first server of an export location path, e.g. 10.0.0.1 for
+// NFS 10.0.0.1:/share, CephFS 10.0.0.1:6789,10.0.0.2:6789:/volumes/share or CIFS \\10.0.0.1\share.
+func ExportLocationHost(path string) string {
+	if strings.HasPrefix(path, `\\`) {
+		host, _, _ := strings.Cut(strings.TrimPrefix(path, `\\`), `\`)
+		return host
+	}
+
+	delimPos := strings.LastIndexByte(path, ':')
+	if delimPos <= 0 {
+		return ""
+	}
+
+	host, _, _ := strings.Cut(path[:delimPos], ",")
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return strings.Trim(host, "[]")
+}
@@ -125,3 +125,125 @@ func TestFindExportLocationAny(t *testing.T) {
 		}
 	}
 }
+
+func TestSortExportLocations(t *testing.T) {
+	locs := []shares.ExportLocation{
+		{
+			Path:        "loc-0",
+			IsAdminOnly: true,
+			Preferred:   true,
+		},
+		{
+			Path:        "loc-1",
+			IsAdminOnly: false,
+			Preferred:   false,
+		},
+		{
+			Path:        "loc-2",
+			IsAdminOnly: false,
+			Preferred:   true,
+		},
+		{
+			Path:        " ",
+			IsAdminOnly: false,
+			Preferred:   true,
+		},
+		{
+			Path:        "loc-4",
+			IsAdminOnly: false,
+			Preferred:   false,
+		},
+		{
+			Path:        "loc-5",
+			IsAdminOnly: false,
+			Preferred:   true,
+		},
+	}
+
+	// Preferred locations 2 and 5 go first, admin-only and empty locations are skipped
+	expected := []int{2, 5, 1, 4}
+
+	result := SortExportLocations(locs)
+	if len(result) != len(expected) {
+		t.Fatalf("returned incorrect indices: got %v, expected %v", result, expected)
+	}
+
+	for i := range expected {
+		if result[i] != expected[i] {
+			t.Fatalf("returned incorrect indices: got %v, expected %v", result, expected)
+		}
+	}
+
+	// The first location is the one found by FindExportLocation
+	if idx, _ := FindExportLocation(locs, AnyExportLocation); idx != result[0] {
+		t.Errorf("first index %d doesn't match FindExportLocation: %d", result[0], idx)
+	}
+}
+
+func TestExportLocationPolicy(t *testing.T) {
+	locs := []shares.ExportLocation{
+		{Path: "10.0.1.10:/share", ShareInstanceID: "i-1"},
+		{Path: "10.0.0.10:/share", ShareInstanceID: "i-2", Preferred: true},
+		{Path: "10.0.0.11:/share", ShareInstanceID: "i-1"},
+		{Path: "10.0.1.11:/share", ShareInstanceID: "i-2", Preferred: true},
+		{Path: "10.0.0.12:/share", ShareInstanceID: "i-1", IsAdminOnly: true},
+	}
+	zones := map[string]string{"i-1": "zone-1", "i-2": "zone-2"}
+	zoneOf := func(loc *shares.ExportLocation) string { return zones[loc.ShareInstanceID] }
+
+	ts := []struct {
+		policy   string
+		nodeAZ   string
+		expected []int
+	}{
+		{"preferred", "", []int{1, 3, 0, 2}},
+		{"zone", "zone-1", []int{0, 2, 1, 3}},
+		// The node AZ is unknown, the locations keep their order
+		{"zone", "", []int{0, 1, 2, 3}},
+		{"zone, preferred", "zone-2", []int{1, 3, 0, 2}},
+		{"cidr:10.0.0.0/24", "", []int{1, 2, 0, 3}},
+		{"cidr:10.0.1.0/24,preferred", "", []int{3, 0, 1, 2}},
+		{"zone,cidr:10.0.1.0/24", "zone-2", []int{3, 1, 0, 2}},
+	}
+
+	for _, tc := range ts {
+		p, err := ParseExportLocationPolicy(tc.policy)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tc.policy, err)
+		}
+
+		result := p.Sort(locs, tc.nodeAZ, zoneOf)
+		if len(result) != len(tc.expected) {
+			t.Fatalf("%q: returned incorrect indices: got %v, expected %v", tc.policy, result, tc.expected)
+		}
+		for i := range tc.expected {
+			if result[i] != tc.expected[i] {
+				t.Fatalf("%q: returned incorrect indices: got %v, expected %v", tc.policy, result, tc.expected)
+			}
+		}
+	}
+
+	for _, policy := range []string{"", "nearest", "cidr:10.0.0.0", "preferred,"} {
+		if _, err := ParseExportLocationPolicy(policy); err == nil {
+			t.Errorf("%q: expected an error", policy)
+		}
+	}
+}
+
+func TestExportLocationHost(t *testing.T) {
+	ts := map[string]string{
+		"10.0.0.1:/share":                        "10.0.0.1",
+		"10.0.0.1:6789,10.0.0.2:6789:/volumes/a": "10.0.0.1",
+		"[fd00::1]:/share":                       "fd00::1",
+		"[fd00::1]:6789,[fd00::2]:6789:/volumes": "fd00::1",
+		`\\10.0.0.1\share`:                       "10.0.0.1",
+		"nfs.example.com:/share":                 "nfs.example.com",
+		"share":                                  "",
+	}
+
+	for path, expected := range ts {
+		if host := ExportLocationHost(path); host != expected {
+			t.Errorf("%s: expected host %q, got %q", path, expected, host)
+		}
+	}
+}
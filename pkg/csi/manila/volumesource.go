@@ -17,6 +17,10 @@ limitations under the License.
 package manila
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
 	"google.golang.org/grpc/codes"
@@ -25,15 +29,94 @@ import (
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
 	clouderrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/klog/v2"
 )
 
 type volumeCreator interface {
 	create(manilaClient manilaclient.Interface, req *csi.CreateVolumeRequest, shareName string, sizeInGiB int, shareOpts *options.ControllerVolumeContext, shareMetadata map[string]string) (*shares.Share, error)
 }
 
-type blankVolume struct{}
+// parseProtocolFallback parses the protocolFallback volume parameter, a comma-separated list of share protocols.
+func parseProtocolFallback(protocolFallback string) []string {
+	var protocols []string
+	for _, proto := range strings.Split(protocolFallback, ",") {
+		protocols = append(protocols, strings.ToUpper(strings.TrimSpace(proto)))
+	}
+
+	return protocols
+}
+
+// shareProtocols returns the protocols the share is created with, in order. The protocols of
+// protocolFallback must be served by the Node Plugin, either as the share protocol selector or
+// through a fallback fwd endpoint.
+func (cs *controllerServer) shareProtocols(shareOpts *options.ControllerVolumeContext) ([]string, error) {
+	if shareOpts.ProtocolFallback == "" {
+		return []string{cs.d.shareProto}, nil
+	}
+
+	protocols := parseProtocolFallback(shareOpts.ProtocolFallback)
+	if err := validateProtocols(protocols); err != nil {
+		return nil, err
+	}
+
+	for _, proto := range protocols {
+		if _, ok := cs.d.fwdEndpointFor(proto); !ok {
+			return nil, fmt.Errorf("share protocol %s of protocolFallback is not served by this plugin, see --fallback-fwdendpoint", proto)
+		}
+	}
+
+	return protocols, nil
+}
+
+// createWithProtocolFallback creates the share with the first protocol accepted by Manila, trying the protocols
+// in order. A share which already exists with one of the protocols is kept. shareOpts.Protocol is set to the
+// protocol of the returned share.
+func createWithProtocolFallback(volCreator volumeCreator, manilaClient manilaclient.Interface, req *csi.CreateVolumeRequest, shareName string, sizeInGiB int, shareOpts *options.ControllerVolumeContext, shareMetadata map[string]string, protocols []string) (*shares.Share, error) {
+	if len(protocols) > 1 {
+		// The share may have been created with any of the protocols by a previous call
+		if share, err := manilaClient.GetShareByName(shareName); err == nil {
+			for _, proto := range protocols {
+				if strings.EqualFold(share.ShareProto, proto) {
+					protocols = []string{proto}
+					break
+				}
+			}
+		}
+	}
+
+	var (
+		share *shares.Share
+		err   error
+	)
 
-func (blankVolume) create(manilaClient manilaclient.Interface, req *csi.CreateVolumeRequest, shareName string, sizeInGiB int, shareOpts *options.ControllerVolumeContext, shareMetadata map[string]string) (*shares.Share, error) {
+	for i, proto := range protocols {
+		shareOpts.Protocol = proto
+
+		if share, err = volCreator.create(manilaClient, req, shareName, sizeInGiB, shareOpts, shareMetadata); err == nil {
+			return share, nil
+		}
+
+		if status.Code(err) == codes.DeadlineExceeded {
+			// The share may still become available, don't create another one
+			break
+		}
+
+		if i == len(protocols)-1 {
+			break
+		}
+
+		klog.Warningf("failed to create volume %s with protocol %s, falling back to %s: %v", shareName, proto, protocols[i+1], err)
+	}
+
+	return nil, err
+}
+
+type blankVolume struct {
+	// waitTimeout is how long to wait for the share to become available.
+	waitTimeout time.Duration
+}
+
+func (v blankVolume) create(manilaClient manilaclient.Interface, req *csi.CreateVolumeRequest, shareName string, sizeInGiB int, shareOpts *options.ControllerVolumeContext, shareMetadata map[string]string) (*shares.Share, error) {
 	createOpts := &shares.CreateOpts{
 		AvailabilityZone: shareOpts.AvailabilityZone,
 		ShareProto:       shareOpts.Protocol,
@@ -45,7 +128,7 @@ func (blankVolume) create(manilaClient manilaclient.Interface, req *csi.CreateVo
 		Metadata:         shareMetadata,
 	}
 
-	share, manilaErrCode, err := getOrCreateShare(manilaClient, shareName, createOpts)
+	share, manilaErrCode, err := getOrCreateShare(manilaClient, shareName, withShareCreateOpts(createOpts, shareOpts), v.waitTimeout)
 	if err != nil {
 		if wait.Interrupted(err) {
 			return nil, status.Errorf(codes.DeadlineExceeded, "deadline exceeded while waiting for volume %s to become available", shareName)
@@ -53,31 +136,38 @@ func (blankVolume) create(manilaClient manilaclient.Interface, req *csi.CreateVo
 
 		if manilaErrCode != 0 {
 			// An error has occurred, try to roll-back the share
-			tryDeleteShare(manilaClient, share)
+			tryDeleteShare(manilaClient, share, v.waitTimeout)
 		}
 
-		return nil, status.Errorf(manilaErrCode.toRPCErrorCode(), "failed to create volume %s: %v", shareName, err)
+		return nil, statusErrorf(manilaErrCode.toRPCErrorCode(), "failed to create volume %s: %w", shareName, err)
 	}
 
 	return share, err
 }
 
-type volumeFromSnapshot struct{}
+type volumeFromSnapshot struct {
+	// waitTimeout is how long to wait for the share to become available.
+	waitTimeout time.Duration
+}
 
-func (volumeFromSnapshot) create(manilaClient manilaclient.Interface, req *csi.CreateVolumeRequest, shareName string, sizeInGiB int, shareOpts *options.ControllerVolumeContext, shareMetadata map[string]string) (*shares.Share, error) {
+func (v volumeFromSnapshot) create(manilaClient manilaclient.Interface, req *csi.CreateVolumeRequest, shareName string, sizeInGiB int, shareOpts *options.ControllerVolumeContext, shareMetadata map[string]string) (*shares.Share, error) {
 	snapshotSource := req.GetVolumeContentSource().GetSnapshot()
 
 	if snapshotSource.GetSnapshotId() == "" {
 		return nil, status.Error(codes.InvalidArgument, "snapshot ID cannot be empty")
 	}
 
+	if _, _, ok := parseMemberSnapshotID(snapshotSource.GetSnapshotId()); ok {
+		return restoreGroupSnapshotMember(manilaClient, snapshotSource.GetSnapshotId(), shareName, sizeInGiB, shareMetadata, v.waitTimeout)
+	}
+
 	snapshot, err := manilaClient.GetSnapshotByID(snapshotSource.GetSnapshotId())
 	if err != nil {
 		if clouderrors.IsNotFound(err) {
-			return nil, status.Errorf(codes.NotFound, "source snapshot %s not found: %v", snapshotSource.GetSnapshotId(), err)
+			return nil, statusErrorf(codes.NotFound, "source snapshot %s not found: %w", snapshotSource.GetSnapshotId(), err)
 		}
 
-		return nil, status.Errorf(codes.Internal, "failed to retrieve snapshot %s: %v", snapshotSource.GetSnapshotId(), err)
+		return nil, statusErrorf(codes.Internal, "failed to retrieve snapshot %s: %w", snapshotSource.GetSnapshotId(), err)
 	}
 
 	if snapshot.Status != snapshotAvailable {
@@ -100,7 +190,7 @@ func (volumeFromSnapshot) create(manilaClient manilaclient.Interface, req *csi.C
 		Metadata:         shareMetadata,
 	}
 
-	share, manilaErrCode, err := getOrCreateShare(manilaClient, shareName, createOpts)
+	share, manilaErrCode, err := getOrCreateShare(manilaClient, shareName, withShareCreateOpts(createOpts, shareOpts), v.waitTimeout)
 	if err != nil {
 		if wait.Interrupted(err) {
 			return nil, status.Errorf(codes.DeadlineExceeded, "deadline exceeded while waiting for volume %s to become available", share.Name)
@@ -108,10 +198,10 @@ func (volumeFromSnapshot) create(manilaClient manilaclient.Interface, req *csi.C
 
 		if manilaErrCode != 0 {
 			// An error has occurred, try to roll-back the share
-			tryDeleteShare(manilaClient, share)
+			tryDeleteShare(manilaClient, share, v.waitTimeout)
 		}
 
-		return nil, status.Errorf(manilaErrCode.toRPCErrorCode(), "failed to restore snapshot %s into volume %s: %v", snapshotSource.GetSnapshotId(), shareName, err)
+		return nil, statusErrorf(manilaErrCode.toRPCErrorCode(), "failed to restore snapshot %s into volume %s: %w", snapshotSource.GetSnapshotId(), shareName, err)
 	}
 
 	return share, err
@@ -0,0 +1,180 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
+)
+
+type fakeShareLookup struct {
+	manilaclient.Interface
+	share *shares.Share
+}
+
+func (c *fakeShareLookup) GetShareByName(name string) (*shares.Share, error) {
+	if c.share == nil {
+		return nil, gophercloud.ErrDefault404{}
+	}
+
+	return c.share, nil
+}
+
+// fakeVolumeCreator creates shares only with the accepted protocols and records the protocols tried.
+type fakeVolumeCreator struct {
+	accepted map[string]codes.Code
+	tried    []string
+}
+
+func (c *fakeVolumeCreator) create(manilaClient manilaclient.Interface, req *csi.CreateVolumeRequest, shareName string, sizeInGiB int, shareOpts *options.ControllerVolumeContext, shareMetadata map[string]string) (*shares.Share, error) {
+	c.tried = append(c.tried, shareOpts.Protocol)
+
+	if code, ok := c.accepted[shareOpts.Protocol]; !ok || code != codes.OK {
+		if !ok {
+			code = codes.Internal
+		}
+		return nil, status.Errorf(code, "failed to create volume %s", shareName)
+	}
+
+	return &shares.Share{Name: shareName, ShareProto: shareOpts.Protocol}, nil
+}
+
+func TestParseProtocolFallback(t *testing.T) {
+	ts := []struct {
+		protocolFallback string
+		expected         []string
+	}{
+		{"NFS", []string{"NFS"}},
+		{"CEPHFS,NFS", []string{"CEPHFS", "NFS"}},
+		{"cephfs, nfs", []string{"CEPHFS", "NFS"}},
+	}
+
+	for _, tt := range ts {
+		if protocols := parseProtocolFallback(tt.protocolFallback); !reflect.DeepEqual(protocols, tt.expected) {
+			t.Errorf("parseProtocolFallback(%q): expected %v, got %v", tt.protocolFallback, tt.expected, protocols)
+		}
+	}
+}
+
+func TestShareProtocols(t *testing.T) {
+	cs := &controllerServer{d: &Driver{
+		shareProto:           "CEPHFS",
+		fallbackFwdEndpoints: map[string]string{"NFS": "unix:///nfs.sock"},
+	}}
+
+	ts := []struct {
+		protocolFallback string
+		expected         []string
+		expectErr        bool
+	}{
+		{"", []string{"CEPHFS"}, false},
+		{"CEPHFS,NFS", []string{"CEPHFS", "NFS"}, false},
+		{"nfs", []string{"NFS"}, false},
+		{"CEPHFS,CIFS", nil, true},
+		{"CEPHFS,FOO", nil, true},
+	}
+
+	for _, tt := range ts {
+		protocols, err := cs.shareProtocols(&options.ControllerVolumeContext{ProtocolFallback: tt.protocolFallback})
+		if tt.expectErr {
+			if err == nil {
+				t.Errorf("shareProtocols(%q): expected an error", tt.protocolFallback)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("shareProtocols(%q): unexpected error: %v", tt.protocolFallback, err)
+		} else if !reflect.DeepEqual(protocols, tt.expected) {
+			t.Errorf("shareProtocols(%q): expected %v, got %v", tt.protocolFallback, tt.expected, protocols)
+		}
+	}
+}
+
+func TestCreateWithProtocolFallback(t *testing.T) {
+	ts := []struct {
+		name          string
+		protocols     []string
+		accepted      map[string]codes.Code
+		existing      *shares.Share
+		expectedTried []string
+		expectedProto string
+		expectedError bool
+	}{
+		{
+			name:          "first protocol accepted",
+			protocols:     []string{"CEPHFS", "NFS"},
+			accepted:      map[string]codes.Code{"CEPHFS": codes.OK, "NFS": codes.OK},
+			expectedTried: []string{"CEPHFS"},
+			expectedProto: "CEPHFS",
+		},
+		{
+			name:          "fall back to the second protocol",
+			protocols:     []string{"CEPHFS", "NFS"},
+			accepted:      map[string]codes.Code{"NFS": codes.OK},
+			expectedTried: []string{"CEPHFS", "NFS"},
+			expectedProto: "NFS",
+		},
+		{
+			name:          "no protocol accepted",
+			protocols:     []string{"CEPHFS", "NFS"},
+			accepted:      map[string]codes.Code{},
+			expectedTried: []string{"CEPHFS", "NFS"},
+			expectedError: true,
+		},
+		{
+			name:          "no fallback after a timeout",
+			protocols:     []string{"CEPHFS", "NFS"},
+			accepted:      map[string]codes.Code{"CEPHFS": codes.DeadlineExceeded, "NFS": codes.OK},
+			expectedTried: []string{"CEPHFS"},
+			expectedError: true,
+		},
+		{
+			name:          "existing share keeps its protocol",
+			protocols:     []string{"CEPHFS", "NFS"},
+			accepted:      map[string]codes.Code{"CEPHFS": codes.OK, "NFS": codes.OK},
+			existing:      &shares.Share{Name: "pv", ShareProto: "NFS"},
+			expectedTried: []string{"NFS"},
+			expectedProto: "NFS",
+		},
+	}
+
+	for _, tt := range ts {
+		volCreator := &fakeVolumeCreator{accepted: tt.accepted}
+		shareOpts := &options.ControllerVolumeContext{}
+
+		share, err := createWithProtocolFallback(volCreator, &fakeShareLookup{share: tt.existing}, &csi.CreateVolumeRequest{}, "pv", 1, shareOpts, nil, tt.protocols)
+		if tt.expectedError != (err != nil) {
+			t.Errorf("%s: expected error %t, got %v", tt.name, tt.expectedError, err)
+		}
+
+		if !reflect.DeepEqual(volCreator.tried, tt.expectedTried) {
+			t.Errorf("%s: expected protocols %v to be tried, got %v", tt.name, tt.expectedTried, volCreator.tried)
+		}
+
+		if err == nil && (share.ShareProto != tt.expectedProto || shareOpts.Protocol != tt.expectedProto) {
+			t.Errorf("%s: expected protocol %s, got share %s and options %s", tt.name, tt.expectedProto, share.ShareProto, shareOpts.Protocol)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	mountutil "k8s.io/mount-utils"
+)
+
+// volumeStatsTimeout is how long the stats of a volume are awaited. The stat calls on the mount of
+// a share whose server doesn't respond block, possibly forever.
+const volumeStatsTimeout = 10 * time.Second
+
+var errVolumeStatsTimeout = errors.New("volume stats timed out")
+
+// volumeStats are the capacity and inode usage of the filesystem of a mounted share.
+type volumeStats struct {
+	totalBytes, availableBytes, usedBytes    int64
+	totalInodes, availableInodes, usedInodes int64
+}
+
+// volumeStatCalls runs at most one stat call per volume path. A stat call on the mount of an
+// unreachable share may never return: the NodeGetVolumeStats calls polling it would otherwise
+// each leave a goroutine behind, blocked forever.
+type volumeStatCalls struct {
+	stat func(volumePath string) (*volumeStats, error)
+
+	mu      sync.Mutex
+	pending map[string]*volumeStatCall
+}
+
+type volumeStatCall struct {
+	done  chan struct{}
+	stats *volumeStats
+	err   error
+}
+
+func newVolumeStatCalls(stat func(volumePath string) (*volumeStats, error)) *volumeStatCalls {
+	return &volumeStatCalls{
+		stat:    stat,
+		pending: make(map[string]*volumeStatCall),
+	}
+}
+
+var volumeStatsCalls = newVolumeStatCalls(statVolume)
+
+// get returns the stats of the share mounted at volumePath, or errVolumeStatsTimeout if they're
+// not available after timeout. The stat call still pending for the path is awaited instead of
+// starting another one.
+func (c *volumeStatCalls) get(volumePath string, timeout time.Duration) (*volumeStats, error) {
+	c.mu.Lock()
+	call, ok := c.pending[volumePath]
+	if !ok {
+		call = &volumeStatCall{done: make(chan struct{})}
+		c.pending[volumePath] = call
+		go func() {
+			call.stats, call.err = c.stat(volumePath)
+
+			c.mu.Lock()
+			delete(c.pending, volumePath)
+			c.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	c.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-call.done:
+		return call.stats, call.err
+	case <-timer.C:
+		return nil, errVolumeStatsTimeout
+	}
+}
+
+// getVolumeStats returns the stats of the share mounted at volumePath, or errVolumeStatsTimeout
+// if they're not available after timeout.
+func getVolumeStats(volumePath string, timeout time.Duration) (*volumeStats, error) {
+	return volumeStatsCalls.get(volumePath, timeout)
+}
+
+// abnormalVolumeCondition returns the condition of a volume whose stats failed with err because
+// its mount is unusable, or nil if err is due to something else.
+func abnormalVolumeCondition(volumePath string, err error) *csi.VolumeCondition {
+	if errors.Is(err, errVolumeStatsTimeout) {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("Volume mounted at %s is not responding, the share may be unreachable", volumePath),
+		}
+	}
+
+	if mountutil.IsCorruptedMnt(err) {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("Volume mount at %s is corrupted: %v", volumePath, err),
+		}
+	}
+
+	return nil
+}
+
+func (s *volumeStats) usage() []*csi.VolumeUsage {
+	return []*csi.VolumeUsage{
+		{Total: s.totalBytes, Available: s.availableBytes, Used: s.usedBytes, Unit: csi.VolumeUsage_BYTES},
+		{Total: s.totalInodes, Available: s.availableInodes, Used: s.usedInodes, Unit: csi.VolumeUsage_INODES},
+	}
+}
@@ -0,0 +1,42 @@
+//go:build linux
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func statVolume(volumePath string) (*volumeStats, error) {
+	var statfs unix.Statfs_t
+	if err := unix.Statfs(volumePath, &statfs); err != nil {
+		return nil, &os.PathError{Op: "statfs", Path: volumePath, Err: err}
+	}
+
+	return &volumeStats{
+		totalBytes:     int64(statfs.Blocks) * int64(statfs.Bsize),
+		availableBytes: int64(statfs.Bavail) * int64(statfs.Bsize),
+		usedBytes:      (int64(statfs.Blocks) - int64(statfs.Bfree)) * int64(statfs.Bsize),
+
+		totalInodes:     int64(statfs.Files),
+		availableInodes: int64(statfs.Ffree),
+		usedInodes:      int64(statfs.Files) - int64(statfs.Ffree),
+	}, nil
+}
@@ -0,0 +1,79 @@
+//go:build linux
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNodeGetVolumeStats(t *testing.T) {
+	ns := &nodeServer{d: &Driver{}}
+	volumePath := t.TempDir()
+
+	for _, req := range []*csi.NodeGetVolumeStatsRequest{
+		{VolumePath: volumePath},
+		{VolumeId: "volume"},
+	} {
+		if _, err := ns.NodeGetVolumeStats(context.TODO(), req); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expected InvalidArgument for %v, got %v", req, err)
+		}
+	}
+
+	_, err := ns.NodeGetVolumeStats(context.TODO(), &csi.NodeGetVolumeStatsRequest{VolumeId: "volume", VolumePath: filepath.Join(volumePath, "missing")})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+
+	resp, err := ns.NodeGetVolumeStats(context.TODO(), &csi.NodeGetVolumeStatsRequest{VolumeId: "volume", VolumePath: volumePath})
+	if err != nil {
+		t.Fatalf("failed to get the volume stats: %v", err)
+	}
+	if resp.GetVolumeCondition().GetAbnormal() || len(resp.GetUsage()) != 2 {
+		t.Fatalf("unexpected volume stats %v", resp)
+	}
+	for _, u := range resp.GetUsage() {
+		if u.GetTotal() <= 0 || u.GetUsed() < 0 || u.GetAvailable() > u.GetTotal() {
+			t.Errorf("unexpected %v usage %v", u.GetUnit(), u)
+		}
+	}
+}
+
+func TestAbnormalVolumeCondition(t *testing.T) {
+	for _, tc := range []struct {
+		err      error
+		abnormal bool
+	}{
+		{err: errVolumeStatsTimeout, abnormal: true},
+		{err: &os.PathError{Op: "statfs", Path: "/mnt", Err: syscall.ESTALE}, abnormal: true},
+		{err: &os.PathError{Op: "statfs", Path: "/mnt", Err: syscall.ENOTCONN}, abnormal: true},
+		{err: &os.PathError{Op: "statfs", Path: "/mnt", Err: syscall.ENOMEM}, abnormal: false},
+	} {
+		if condition := abnormalVolumeCondition("/mnt", tc.err); (condition != nil) != tc.abnormal || (condition != nil && !condition.GetAbnormal()) {
+			t.Errorf("%v: expected abnormal %v, got %v", tc.err, tc.abnormal, condition)
+		}
+	}
+}
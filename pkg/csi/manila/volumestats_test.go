@@ -0,0 +1,58 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestVolumeStatCalls(t *testing.T) {
+	var calls int32
+	unblock := make(chan struct{})
+	c := newVolumeStatCalls(func(volumePath string) (*volumeStats, error) {
+		atomic.AddInt32(&calls, 1)
+		<-unblock
+		return &volumeStats{totalBytes: 1}, nil
+	})
+
+	// The share doesn't respond, the stat call is awaited again instead of starting others
+	for i := 0; i < 3; i++ {
+		if _, err := c.get("/mnt", 10*time.Millisecond); !errors.Is(err, errVolumeStatsTimeout) {
+			t.Fatalf("expected errVolumeStatsTimeout, got %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("expected 1 stat call pending, got %d", n)
+	}
+
+	// The share responds again
+	close(unblock)
+	stats, err := c.get("/mnt", time.Second)
+	if err != nil || stats.totalBytes != 1 {
+		t.Fatalf("unexpected stats %v, error %v", stats, err)
+	}
+
+	if _, err := c.get("/mnt", time.Second); err != nil {
+		t.Fatalf("failed to get the volume stats: %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("expected a new stat call once the previous one returned, got %d calls", n)
+	}
+}
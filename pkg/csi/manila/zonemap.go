@@ -0,0 +1,97 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/runtimeconfig"
+)
+
+// parseZoneMap parses the autoTopologyZoneMap volume parameter, a comma-separated list
+// of <node AZ>:<Manila AZ>[:<share type>] entries. An empty Manila AZ keeps the AZ of the node.
+func parseZoneMap(value string) (map[string]runtimeconfig.ZoneMapping, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	zoneMap := make(map[string]runtimeconfig.ZoneMapping)
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("invalid entry %q, expected <node AZ>:<Manila AZ>[:<share type>]", entry)
+		}
+
+		nodeAZ := strings.TrimSpace(parts[0])
+		if nodeAZ == "" {
+			return nil, fmt.Errorf("invalid entry %q, the node availability zone is empty", entry)
+		}
+		if _, ok := zoneMap[nodeAZ]; ok {
+			return nil, fmt.Errorf("duplicate entry for node availability zone %s", nodeAZ)
+		}
+
+		m := runtimeconfig.ZoneMapping{AvailabilityZone: strings.TrimSpace(parts[1])}
+		if len(parts) == 3 {
+			m.ShareType = strings.TrimSpace(parts[2])
+		}
+
+		zoneMap[nodeAZ] = m
+	}
+
+	return zoneMap, nil
+}
+
+// mapZone returns the Manila availability zone and share type of the shares provisioned
+// for the nodes in nodeAZ. The zone map of the StorageClass takes precedence over the one
+// of the runtime configuration file. Unmapped zones keep their name and the share type of shareOpts.
+func mapZone(shareOpts *options.ControllerVolumeContext, nodeAZ string) (availability, shareType string, err error) {
+	availability, shareType = nodeAZ, shareOpts.Type
+
+	zoneMap, err := parseZoneMap(shareOpts.AutoTopologyZoneMap)
+	if err != nil {
+		return "", "", err
+	}
+
+	if zoneMap == nil {
+		conf, err := runtimeconfig.Get()
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read runtime config file %s: %v", runtimeconfig.RuntimeConfigFilename, err)
+		}
+		if conf != nil && conf.Topology != nil {
+			zoneMap = conf.Topology.ZoneMap
+		}
+	}
+
+	if m, ok := zoneMap[nodeAZ]; ok {
+		if m.AvailabilityZone != "" {
+			availability = m.AvailabilityZone
+		}
+		if m.ShareType != "" {
+			shareType = m.ShareType
+		}
+	}
+
+	return availability, shareType, nil
+}
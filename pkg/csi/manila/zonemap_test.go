@@ -0,0 +1,100 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/runtimeconfig"
+)
+
+func TestParseZoneMap(t *testing.T) {
+	ts := []struct {
+		value    string
+		expected map[string]runtimeconfig.ZoneMapping
+		valid    bool
+	}{
+		{"", nil, true},
+		{
+			"nova-1:manila-a, nova-2:manila-b:ssd,nova-3::hdd",
+			map[string]runtimeconfig.ZoneMapping{
+				"nova-1": {AvailabilityZone: "manila-a"},
+				"nova-2": {AvailabilityZone: "manila-b", ShareType: "ssd"},
+				"nova-3": {ShareType: "hdd"},
+			},
+			true,
+		},
+		{"nova-1", nil, false},
+		{"nova-1:manila-a:ssd:extra", nil, false},
+		{":manila-a", nil, false},
+		{"nova-1:manila-a,nova-1:manila-b", nil, false},
+	}
+
+	for _, tc := range ts {
+		zoneMap, err := parseZoneMap(tc.value)
+		if (err == nil) != tc.valid {
+			t.Errorf("%q: expected valid=%t, got %v", tc.value, tc.valid, err)
+			continue
+		}
+		if tc.valid && !reflect.DeepEqual(zoneMap, tc.expected) {
+			t.Errorf("%q: expected %v, got %v", tc.value, tc.expected, zoneMap)
+		}
+	}
+}
+
+func TestMapZone(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "runtimeconfig.json")
+	if err := os.WriteFile(configFile, []byte(`{"topology": {"zoneMap": {"nova-1": {"availabilityZone": "manila-x", "shareType": "gold"}}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	prevFilename := runtimeconfig.RuntimeConfigFilename
+	runtimeconfig.RuntimeConfigFilename = configFile
+	defer func() { runtimeconfig.RuntimeConfigFilename = prevFilename }()
+
+	ts := []struct {
+		zoneMap      string
+		nodeAZ       string
+		availability string
+		shareType    string
+	}{
+		// The zone map of the runtime configuration file
+		{"", "nova-1", "manila-x", "gold"},
+		{"", "nova-2", "nova-2", "default"},
+		// The zone map of the StorageClass takes precedence
+		{"nova-1:manila-a", "nova-1", "manila-a", "default"},
+		{"nova-1::ssd", "nova-1", "nova-1", "ssd"},
+		{"nova-1:manila-a:ssd", "nova-2", "nova-2", "default"},
+	}
+
+	for _, tc := range ts {
+		shareOpts := &options.ControllerVolumeContext{Type: "default", AutoTopologyZoneMap: tc.zoneMap}
+
+		availability, shareType, err := mapZone(shareOpts, tc.nodeAZ)
+		if err != nil {
+			t.Errorf("%q, %s: %v", tc.zoneMap, tc.nodeAZ, err)
+			continue
+		}
+		if availability != tc.availability || shareType != tc.shareType {
+			t.Errorf("%q, %s: expected %s and %s, got %s and %s", tc.zoneMap, tc.nodeAZ, tc.availability, tc.shareType, availability, shareType)
+		}
+	}
+}
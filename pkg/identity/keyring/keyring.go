@@ -0,0 +1,148 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package keyring stores the secrets of client-keystone-auth in the keyring of the operating system: the Windows
+// Credential Manager, the macOS Keychain or the Secret Service of the desktop on the other systems.
+package keyring
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	utilexec "k8s.io/utils/exec"
+)
+
+// Service is the service the secrets are stored under in the keyring.
+const Service = "client-keystone-auth"
+
+// ErrNotFound is returned when the keyring has no secret for the account.
+var ErrNotFound = errors.New("secret not found in keyring")
+
+// Keyring gets, stores and deletes the secrets of the accounts of a service.
+type Keyring interface {
+	Get(service, account string) (string, error)
+	Set(service, account, secret string) error
+	Delete(service, account string) error
+}
+
+// New returns the keyring of the operating system.
+func New() Keyring {
+	return newDefault()
+}
+
+// Account returns the keyring account of the Keystone credentials: the application credential if any, the user
+// otherwise.
+func Account(authURL, domain, user, applicationCredentialID, applicationCredentialName string) (string, error) {
+	switch {
+	case authURL == "":
+		return "", fmt.Errorf("keystone URL is required")
+	case applicationCredentialID != "":
+		return fmt.Sprintf("application-credential:%s@%s", applicationCredentialID, authURL), nil
+	case applicationCredentialName != "" && user != "":
+		return fmt.Sprintf("application-credential:%s/%s/%s@%s", domain, user, applicationCredentialName, authURL), nil
+	case user != "":
+		return fmt.Sprintf("password:%s/%s@%s", domain, user, authURL), nil
+	}
+	return "", fmt.Errorf("user name or application credential is required")
+}
+
+func exitStatus(err error) int {
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus()
+	}
+	return -1
+}
+
+// secretServiceKeyring uses the secret-tool command of libsecret to talk to the Secret Service, e.g. GNOME Keyring or
+// KWallet.
+type secretServiceKeyring struct {
+	exec utilexec.Interface
+}
+
+func (k *secretServiceKeyring) Get(service, account string) (string, error) {
+	out, err := k.exec.Command("secret-tool", "lookup", "service", service, "account", account).Output()
+	if err != nil {
+		// secret-tool exits with 1 and no output if there is no such secret
+		if exitStatus(err) == 1 && len(out) == 0 {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("secret-tool lookup failed: %v", err)
+	}
+	if len(out) == 0 {
+		return "", ErrNotFound
+	}
+	return string(out), nil
+}
+
+func (k *secretServiceKeyring) Set(service, account, secret string) error {
+	cmd := k.exec.Command("secret-tool", "store", "--label", fmt.Sprintf("%s %s", service, account), "service", service, "account", account)
+	// The secret is passed on stdin to keep it out of the process list
+	cmd.SetStdin(strings.NewReader(secret))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("secret-tool store failed: %v, output: %s", err, string(out))
+	}
+	return nil
+}
+
+func (k *secretServiceKeyring) Delete(service, account string) error {
+	if out, err := k.exec.Command("secret-tool", "clear", "service", service, "account", account).CombinedOutput(); err != nil {
+		return fmt.Errorf("secret-tool clear failed: %v, output: %s", err, string(out))
+	}
+	return nil
+}
+
+// keychainKeyring uses the security command to talk to the macOS Keychain.
+type keychainKeyring struct {
+	exec utilexec.Interface
+}
+
+// errSecItemNotFound is the exit status of the security command when the item doesn't exist
+const errSecItemNotFound = 44
+
+func (k *keychainKeyring) Get(service, account string) (string, error) {
+	out, err := k.exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w").Output()
+	if err != nil {
+		if exitStatus(err) == errSecItemNotFound {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("security find-generic-password failed: %v", err)
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func (k *keychainKeyring) Set(service, account, secret string) error {
+	// The command is read from stdin by the interactive mode to keep the secret out of the process list. The secret
+	// is hex encoded so that it doesn't need to be quoted.
+	cmd := k.exec.Command("security", "-i")
+	cmd.SetStdin(strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -X %s\n",
+		strconv.Quote(service), strconv.Quote(account), hex.EncodeToString([]byte(secret)))))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("security add-generic-password failed: %v, output: %s", err, string(out))
+	}
+	return nil
+}
+
+func (k *keychainKeyring) Delete(service, account string) error {
+	out, err := k.exec.Command("security", "delete-generic-password", "-s", service, "-a", account).CombinedOutput()
+	if err != nil && exitStatus(err) != errSecItemNotFound {
+		return fmt.Errorf("security delete-generic-password failed: %v, output: %s", err, string(out))
+	}
+	return nil
+}
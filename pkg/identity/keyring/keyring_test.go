@@ -0,0 +1,117 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keyring
+
+import (
+	"io"
+	"testing"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+	utilexec "k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+)
+
+func fakeExec(cmds ...*testingexec.FakeCmd) *testingexec.FakeExec {
+	fexec := &testingexec.FakeExec{}
+	for _, fcmd := range cmds {
+		fcmd := fcmd
+		fexec.CommandScript = append(fexec.CommandScript, func(cmd string, args ...string) utilexec.Cmd {
+			return testingexec.InitFakeCmd(fcmd, cmd, args...)
+		})
+	}
+	return fexec
+}
+
+func outputCmd(out string, err error) *testingexec.FakeCmd {
+	return &testingexec.FakeCmd{
+		OutputScript: []testingexec.FakeAction{
+			func() ([]byte, []byte, error) { return []byte(out), nil, err },
+		},
+		CombinedOutputScript: []testingexec.FakeAction{
+			func() ([]byte, []byte, error) { return []byte(out), nil, err },
+		},
+	}
+}
+
+func stdin(t *testing.T, fcmd *testingexec.FakeCmd) string {
+	data, err := io.ReadAll(fcmd.Stdin)
+	th.AssertNoErr(t, err)
+	return string(data)
+}
+
+func TestAccount(t *testing.T) {
+	account, err := Account("https://keystone", "default", "alice", "", "")
+	th.AssertNoErr(t, err)
+	th.AssertEquals(t, "password:default/alice@https://keystone", account)
+
+	account, err = Account("https://keystone", "default", "alice", "", "ci")
+	th.AssertNoErr(t, err)
+	th.AssertEquals(t, "application-credential:default/alice/ci@https://keystone", account)
+
+	account, err = Account("https://keystone", "default", "alice", "0123", "ci")
+	th.AssertNoErr(t, err)
+	th.AssertEquals(t, "application-credential:0123@https://keystone", account)
+
+	_, err = Account("", "default", "alice", "", "")
+	th.AssertErr(t, err)
+
+	_, err = Account("https://keystone", "default", "", "", "ci")
+	th.AssertErr(t, err)
+}
+
+func TestSecretServiceKeyring(t *testing.T) {
+	lookup := outputCmd("s3cret", nil)
+	missing := outputCmd("", testingexec.FakeExitError{Status: 1})
+	store := outputCmd("", nil)
+	fexec := fakeExec(lookup, missing, store)
+	k := &secretServiceKeyring{exec: fexec}
+
+	secret, err := k.Get(Service, "password:default/alice@https://keystone")
+	th.AssertNoErr(t, err)
+	th.AssertEquals(t, "s3cret", secret)
+	th.AssertDeepEquals(t, []string{"secret-tool", "lookup", "service", Service, "account", "password:default/alice@https://keystone"}, lookup.Argv)
+
+	_, err = k.Get(Service, "password:default/bob@https://keystone")
+	th.AssertEquals(t, ErrNotFound, err)
+
+	th.AssertNoErr(t, k.Set(Service, "password:default/alice@https://keystone", "n3w"))
+	th.AssertEquals(t, "n3w", stdin(t, store))
+	th.AssertEquals(t, "store", store.Argv[1])
+}
+
+func TestKeychainKeyring(t *testing.T) {
+	find := outputCmd("s3cret\n", nil)
+	missing := outputCmd("", testingexec.FakeExitError{Status: errSecItemNotFound})
+	add := outputCmd("", nil)
+	del := outputCmd("", testingexec.FakeExitError{Status: errSecItemNotFound})
+	fexec := fakeExec(find, missing, add, del)
+	k := &keychainKeyring{exec: fexec}
+
+	secret, err := k.Get(Service, "password:default/alice@https://keystone")
+	th.AssertNoErr(t, err)
+	th.AssertEquals(t, "s3cret", secret)
+
+	_, err = k.Get(Service, "password:default/bob@https://keystone")
+	th.AssertEquals(t, ErrNotFound, err)
+
+	th.AssertNoErr(t, k.Set(Service, "password:default/alice@https://keystone", "n3w"))
+	th.AssertDeepEquals(t, []string{"security", "-i"}, add.Argv)
+	th.AssertEquals(t, `add-generic-password -U -s "client-keystone-auth" -a "password:default/alice@https://keystone" -X 6e3377`+"\n", stdin(t, add))
+
+	// Deleting a missing secret is not an error
+	th.AssertNoErr(t, k.Delete(Service, "password:default/bob@https://keystone"))
+}
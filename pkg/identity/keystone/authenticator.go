@@ -18,12 +18,14 @@ package keystone
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/identity/v3/groups"
 	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
 	"github.com/gophercloud/gophercloud/openstack/identity/v3/users"
 	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/klog/v2"
 )
 
 type tokenInfo struct {
@@ -34,6 +36,8 @@ type tokenInfo struct {
 	projectID   string
 	domainName  string
 	domainID    string
+	auditIDs    []string
+	expiresAt   time.Time
 }
 
 type IKeystone interface {
@@ -58,7 +62,7 @@ func (k *Keystoner) GetTokenInfo(token string) (*tokenInfo, error) {
 
 	tokenUser, err := ret.ExtractUser()
 	if err != nil {
-		return nil, fmt.Errorf("failed to extract user information from Keystone response: %v", err)
+		return nil, fmt.Errorf("failed to extract user information from Keystone response: %w", err)
 	}
 
 	project, err := ret.ExtractProject()
@@ -71,6 +75,18 @@ func (k *Keystoner) GetTokenInfo(token string) (*tokenInfo, error) {
 		return nil, fmt.Errorf("failed to extract roles information from Keystone response: %v", err)
 	}
 
+	keystoneToken, err := ret.ExtractToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract token information from Keystone response: %v", err)
+	}
+
+	var audit struct {
+		AuditIDs []string `json:"audit_ids"`
+	}
+	if err := ret.ExtractIntoStructPtr(&audit, "token"); err != nil {
+		return nil, fmt.Errorf("failed to extract audit IDs from Keystone response: %v", err)
+	}
+
 	userRoles := make([]string, 0, len(roles))
 	for _, role := range roles {
 		userRoles = append(userRoles, role.Name)
@@ -84,6 +100,8 @@ func (k *Keystoner) GetTokenInfo(token string) (*tokenInfo, error) {
 		roles:       userRoles,
 		domainID:    tokenUser.Domain.ID,
 		domainName:  tokenUser.Domain.Name,
+		auditIDs:    audit.AuditIDs,
+		expiresAt:   keystoneToken.ExpiresAt,
 	}, nil
 }
 
@@ -112,18 +130,44 @@ func (k *Keystoner) GetGroups(token string, userID string) ([]string, error) {
 // Authenticator contacts openstack keystone to validate user's token passed in the request.
 type Authenticator struct {
 	keystoner IKeystone
+	// groupsLookup enables resolving the user's Keystone groups, which are
+	// then included in the Kubernetes groups of the authenticated user.
+	groupsLookup bool
+	// groupPrefix is prepended to every Keystone group name.
+	groupPrefix string
+	// revocation, if set, rejects the tokens whose audit IDs are denylisted.
+	revocation *revocationList
+	// fallbackTokens are the break-glass identities authenticated when
+	// Keystone is unavailable.
+	fallbackTokens []staticToken
 }
 
 // AuthenticateToken checks the token via Keystone call
 func (a *Authenticator) AuthenticateToken(token string) (user.Info, bool, error) {
 	tokenInfo, err := a.keystoner.GetTokenInfo(token)
 	if err != nil {
+		if len(a.fallbackTokens) > 0 && isKeystoneUnavailable(err) {
+			if info := lookupStaticToken(a.fallbackTokens, token); info != nil {
+				klog.Warningf("Keystone is unavailable (%v), user %s authenticated with the fallback token file", err, info.Name)
+				return info, true, nil
+			}
+		}
 		return nil, false, fmt.Errorf("failed to authenticate: %v", err)
 	}
 
-	userGroups, err := a.keystoner.GetGroups(token, tokenInfo.userID)
-	if err != nil {
-		return nil, false, fmt.Errorf("failed to authenticate: %v", err)
+	if a.revocation != nil && a.revocation.isRevoked(tokenInfo.auditIDs) {
+		return nil, false, fmt.Errorf("failed to authenticate: token of user %s has been revoked", tokenInfo.userName)
+	}
+
+	var userGroups []string
+	if a.groupsLookup {
+		keystoneGroups, err := a.keystoner.GetGroups(token, tokenInfo.userID)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to authenticate: %v", err)
+		}
+		for _, g := range keystoneGroups {
+			userGroups = append(userGroups, a.groupPrefix+g)
+		}
 	}
 
 	extra := map[string][]string{
@@ -133,6 +177,9 @@ func (a *Authenticator) AuthenticateToken(token string) (user.Info, bool, error)
 		DomainID:    {tokenInfo.domainID},
 		DomainName:  {tokenInfo.domainName},
 	}
+	if !tokenInfo.expiresAt.IsZero() {
+		extra[ExpiresAt] = []string{tokenInfo.expiresAt.UTC().Format(time.RFC3339)}
+	}
 
 	userGroups = append(userGroups, tokenInfo.projectID)
 	authenticatedUser := &user.DefaultInfo{
@@ -40,7 +40,8 @@ func TestAuthenticateToken(t *testing.T) {
 		Once()
 
 	a := &Authenticator{
-		keystoner: keystone,
+		keystoner:    keystone,
+		groupsLookup: true,
 	}
 	userInfo, allowed, err := a.AuthenticateToken("token")
 
@@ -50,7 +51,7 @@ func TestAuthenticateToken(t *testing.T) {
 	expectedUserInfo := &user.DefaultInfo{
 		Name:   "user-name",
 		UID:    "user-id",
-		Groups: []string{"group1", "group2"},
+		Groups: []string{"group1", "group2", "project-id"},
 		Extra: map[string][]string{
 			Roles:       {"role1", "role2"},
 			ProjectID:   {"project-id"},
@@ -63,3 +64,86 @@ func TestAuthenticateToken(t *testing.T) {
 
 	keystone.AssertExpectations(t)
 }
+
+func TestAuthenticateTokenGroupsLookup(t *testing.T) {
+	ts := []struct {
+		name           string
+		groupsLookup   bool
+		groupPrefix    string
+		expectedGroups []string
+	}{
+		{
+			name:           "lookup disabled",
+			groupsLookup:   false,
+			expectedGroups: []string{"project-id"},
+		},
+		{
+			name:           "lookup enabled with prefix",
+			groupsLookup:   true,
+			groupPrefix:    "keystone:",
+			expectedGroups: []string{"keystone:group1", "keystone:group2", "project-id"},
+		},
+	}
+
+	for _, tt := range ts {
+		t.Run(tt.name, func(t *testing.T) {
+			keystone := &MockIKeystone{}
+			keystone.
+				On("GetTokenInfo", "token").
+				Return(&tokenInfo{
+					userName:  "user-name",
+					userID:    "user-id",
+					projectID: "project-id",
+				}, nil).
+				Once()
+			if tt.groupsLookup {
+				keystone.
+					On("GetGroups", "token", "user-id").
+					Return([]string{"group1", "group2"}, nil).
+					Once()
+			}
+
+			a := &Authenticator{
+				keystoner:    keystone,
+				groupsLookup: tt.groupsLookup,
+				groupPrefix:  tt.groupPrefix,
+			}
+			userInfo, allowed, err := a.AuthenticateToken("token")
+
+			th.AssertNoErr(t, err)
+			th.AssertEquals(t, true, allowed)
+			th.AssertDeepEquals(t, tt.expectedGroups, userInfo.GetGroups())
+
+			keystone.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAuthenticateTokenRevoked(t *testing.T) {
+	keystone := &MockIKeystone{}
+	keystone.
+		On("GetTokenInfo", "token").
+		Return(&tokenInfo{
+			userName: "user-name",
+			userID:   "user-id",
+			auditIDs: []string{"audit-id", "audit-chain-id"},
+		}, nil).
+		Twice()
+
+	revocation := newRevocationList()
+	a := &Authenticator{
+		keystoner:  keystone,
+		revocation: revocation,
+	}
+
+	_, allowed, err := a.AuthenticateToken("token")
+	th.AssertNoErr(t, err)
+	th.AssertEquals(t, true, allowed)
+
+	revocation.setConfigMapIDs([]string{"audit-chain-id"})
+	_, allowed, err = a.AuthenticateToken("token")
+	th.AssertErr(t, err)
+	th.AssertEquals(t, false, allowed)
+
+	keystone.AssertExpectations(t)
+}
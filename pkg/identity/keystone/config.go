@@ -18,7 +18,9 @@ package keystone
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"time"
 
 	"github.com/spf13/pflag"
 	"k8s.io/klog/v2"
@@ -36,6 +38,20 @@ type Config struct {
 	SyncConfigFile      string
 	SyncConfigMapName   string
 	Kubeconfig          string
+	GroupsLookup        bool
+	GroupPrefix         string
+	FallbackTokenFile   string
+	ExtraFields         string
+
+	KeystoneEndpointPolicy      string
+	KeystoneHealthCheckInterval time.Duration
+
+	RevocationConfigMapName string
+
+	RBACSyncInterval time.Duration
+
+	OPAURL     string
+	OPATimeout time.Duration
 }
 
 // NewConfig returns a Config
@@ -51,6 +67,18 @@ func NewConfig() *Config {
 		SyncConfigFile:      os.Getenv("KEYSTONE_SYNC_CONFIG_FILE"),
 		SyncConfigMapName:   os.Getenv("KEYSTONE_SYNC_CONFIGMAP_NAME"),
 		Kubeconfig:          os.Getenv("KEYSTONE_KUBECONFIG_FILE"),
+		GroupsLookup:        true,
+		GroupPrefix:         os.Getenv("KEYSTONE_GROUP_PREFIX"),
+		FallbackTokenFile:   os.Getenv("KEYSTONE_FALLBACK_TOKEN_FILE"),
+		ExtraFields:         defaultExtraFields,
+
+		KeystoneEndpointPolicy:      endpointPolicyFailover,
+		KeystoneHealthCheckInterval: 30 * time.Second,
+
+		RevocationConfigMapName: os.Getenv("KEYSTONE_REVOCATION_CONFIGMAP_NAME"),
+
+		OPAURL:     os.Getenv("KEYSTONE_OPA_URL"),
+		OPATimeout: 5 * time.Second,
 	}
 }
 
@@ -62,17 +90,53 @@ func (c *Config) ValidateFlags() error {
 		errorsFound = true
 		klog.Errorf("please specify --keystone-url or set the OS_AUTH_URL environment variable.")
 	}
+	if c.KeystoneEndpointPolicy != endpointPolicyFailover && c.KeystoneEndpointPolicy != endpointPolicyRoundRobin {
+		errorsFound = true
+		klog.Errorf("invalid --keystone-endpoint-policy %q, supported values are %s and %s.", c.KeystoneEndpointPolicy, endpointPolicyFailover, endpointPolicyRoundRobin)
+	}
+	for _, u := range splitKeystoneURLs(c.KeystoneURL) {
+		if parsed, err := url.Parse(u); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			errorsFound = true
+			klog.Errorf("invalid Keystone URL %q in --keystone-url.", u)
+		}
+	}
+	if len(splitKeystoneURLs(c.KeystoneURL)) > 1 && c.KeystoneHealthCheckInterval <= 0 {
+		errorsFound = true
+		klog.Errorf("--keystone-health-check-interval must be positive.")
+	}
 	if c.CertFile == "" || c.KeyFile == "" {
 		errorsFound = true
 		klog.Errorf("Please specify --tls-cert-file and --tls-private-key-file arguments.")
 	}
-	if c.PolicyFile == "" && c.PolicyConfigMapName == "" {
-		klog.Warning("Argument --keystone-policy-file or --policy-configmap-name missing. Only keystone authentication will work. Use RBAC for authorization.")
+	if c.OPAURL != "" {
+		if c.PolicyFile != "" || c.PolicyConfigMapName != "" {
+			klog.Warning("Argument --opa-url is set, --keystone-policy-file and --policy-configmap-name are ignored for authorization.")
+		}
+		if c.OPATimeout <= 0 {
+			errorsFound = true
+			klog.Errorf("--opa-timeout must be positive.")
+		}
+	} else if c.PolicyFile == "" && c.PolicyConfigMapName == "" {
+		klog.Warning("Argument --keystone-policy-file, --policy-configmap-name or --opa-url missing. Only keystone authentication will work. Use RBAC for authorization.")
 	}
 	if c.SyncConfigFile == "" && c.SyncConfigMapName == "" {
 		klog.Warning("Argument --sync-config-file or --sync-configmap-name missing. Data synchronization between Keystone and Kubernetes is disabled.")
 	}
 
+	if c.RBACSyncInterval < 0 {
+		errorsFound = true
+		klog.Errorf("--rbac-sync-interval must not be negative.")
+	}
+	if c.RBACSyncInterval > 0 && c.SyncConfigFile == "" && c.SyncConfigMapName == "" {
+		errorsFound = true
+		klog.Errorf("--rbac-sync-interval requires --sync-config-file or --sync-configmap-name with rbac-mappings.")
+	}
+
+	if _, err := parseExtraFields(c.ExtraFields); err != nil {
+		errorsFound = true
+		klog.Errorf("invalid --token-review-extra-fields: %v", err)
+	}
+
 	if errorsFound {
 		return fmt.Errorf("failed to validate the input parameters")
 	}
@@ -84,11 +148,21 @@ func (c *Config) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&c.Address, "listen", c.Address, "<address>:<port> to listen on")
 	fs.StringVar(&c.CertFile, "tls-cert-file", c.CertFile, "File containing the default x509 Certificate for HTTPS.")
 	fs.StringVar(&c.KeyFile, "tls-private-key-file", c.KeyFile, "File containing the default x509 private key matching --tls-cert-file.")
-	fs.StringVar(&c.KeystoneURL, "keystone-url", c.KeystoneURL, "URL for the OpenStack Keystone API")
+	fs.StringVar(&c.KeystoneURL, "keystone-url", c.KeystoneURL, "URL for the OpenStack Keystone API, or a comma-separated list of the URLs of the Keystone API nodes.")
+	fs.StringVar(&c.KeystoneEndpointPolicy, "keystone-endpoint-policy", c.KeystoneEndpointPolicy, "How the requests are spread across the Keystone URLs: 'failover' sends them to the first available URL, 'round-robin' to the available URLs in turn.")
+	fs.DurationVar(&c.KeystoneHealthCheckInterval, "keystone-health-check-interval", c.KeystoneHealthCheckInterval, "Interval at which the unavailable Keystone URLs are checked, so that they get requests again once they recover.")
 	fs.StringVar(&c.KeystoneCA, "keystone-ca-file", c.KeystoneCA, "File containing the certificate authority for Keystone Service.")
 	fs.StringVar(&c.PolicyFile, "keystone-policy-file", c.PolicyFile, "File containing the policy, if provided, it takes precedence over the policy configmap.")
 	fs.StringVar(&c.PolicyConfigMapName, "policy-configmap-name", c.PolicyConfigMapName, "ConfigMap in kube-system namespace containing the policy configuration, the ConfigMap data must contain the key 'policies'")
 	fs.StringVar(&c.SyncConfigFile, "sync-config-file", c.SyncConfigFile, "File containing config values for data synchronization between Keystone and Kubernetes.")
 	fs.StringVar(&c.SyncConfigMapName, "sync-configmap-name", "", "ConfigMap in kube-system namespace containing config values for data synchronization between Keystone and Kubernetes.")
 	fs.StringVar(&c.Kubeconfig, "kubeconfig", c.Kubeconfig, "Kubeconfig file used to connect to Kubernetes API to get policy configmap. If the service is running inside the pod, this option is not necessary, will use in-cluster config instead.")
+	fs.BoolVar(&c.GroupsLookup, "keystone-groups-lookup", c.GroupsLookup, "Resolve the user's Keystone groups during token validation and include them as Kubernetes groups.")
+	fs.StringVar(&c.GroupPrefix, "keystone-group-prefix", c.GroupPrefix, "Prefix prepended to the Keystone group names included as Kubernetes groups, e.g. 'keystone:'.")
+	fs.StringVar(&c.FallbackTokenFile, "fallback-token-file", c.FallbackTokenFile, "CSV file of break-glass tokens, in the format of the API server --token-auth-file, only accepted when Keystone is unavailable.")
+	fs.StringVar(&c.ExtraFields, "token-review-extra-fields", c.ExtraFields, "Comma-separated list of the Keystone attributes emitted in the extra fields of the TokenReviews, each optionally followed by '=' and its key, e.g. 'project_id=example.com/project-id,roles'. Supported attributes are project_id, project_name, domain_id, domain_name, roles and expires_at.")
+	fs.StringVar(&c.RevocationConfigMapName, "revocation-configmap-name", c.RevocationConfigMapName, "ConfigMap in kube-system namespace containing the audit IDs of revoked Keystone tokens, one per line in the 'auditIDs' key.")
+	fs.DurationVar(&c.RBACSyncInterval, "rbac-sync-interval", c.RBACSyncInterval, "Interval at which the Keystone role assignments are synced to RoleBindings according to the rbac-mappings of the sync config, 0 disables the sync. The Keystone credentials are read from the OS_* environment variables.")
+	fs.StringVar(&c.OPAURL, "opa-url", c.OPAURL, "URL of the OPA Data API document queried for the authorization decisions, e.g. 'http://127.0.0.1:8181/v1/data/kubernetes/authz'. If set, the Rego policies loaded by OPA replace the JSON policy.")
+	fs.DurationVar(&c.OPATimeout, "opa-timeout", c.OPATimeout, "Timeout of the queries to OPA.")
 }
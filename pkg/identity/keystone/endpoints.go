@@ -0,0 +1,251 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// endpointPolicyFailover sends the requests to the first healthy Keystone endpoint.
+	endpointPolicyFailover = "failover"
+	// endpointPolicyRoundRobin spreads the requests across the healthy Keystone endpoints.
+	endpointPolicyRoundRobin = "round-robin"
+
+	healthCheckTimeout = 10 * time.Second
+)
+
+// keystoneEndpoint is a Keystone API URL and its health.
+type keystoneEndpoint struct {
+	url string
+	// parsed is the parsed url, nil if it is invalid
+	parsed  *url.URL
+	healthy bool
+}
+
+// endpointPool is a http.RoundTripper sending the Keystone requests to the
+// configured Keystone endpoints. The clients are created with the first
+// endpoint, the requests for it are redirected to a healthy endpoint according
+// to the policy and retried on the next one when Keystone is unavailable.
+type endpointPool struct {
+	mu         sync.Mutex
+	endpoints  []*keystoneEndpoint
+	roundRobin bool
+	next       int
+	transport  http.RoundTripper
+}
+
+// newEndpointPool returns an endpointPool of the Keystone URLs, the requests
+// are sent with the transport, or the default transport if nil.
+func newEndpointPool(urls []string, policy string, transport http.RoundTripper) *endpointPool {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	p := &endpointPool{
+		roundRobin: policy == endpointPolicyRoundRobin,
+		transport:  transport,
+	}
+	for _, u := range urls {
+		u = normalizeURL(u)
+		parsed, err := url.Parse(u)
+		if err != nil {
+			klog.Errorf("Invalid Keystone URL %s: %v", u, err)
+			parsed = nil
+		}
+		p.endpoints = append(p.endpoints, &keystoneEndpoint{url: u, parsed: parsed, healthy: true})
+	}
+	return p
+}
+
+// splitKeystoneURLs returns the URLs of the comma-separated list.
+func splitKeystoneURLs(urls string) []string {
+	var ret []string
+	for _, u := range strings.Split(urls, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			ret = append(ret, u)
+		}
+	}
+	return ret
+}
+
+// normalizeURL makes sure the URL ends with a slash, so that the URL prefixes
+// are replaced on path boundaries.
+func normalizeURL(u string) string {
+	if !strings.HasSuffix(u, "/") {
+		return u + "/"
+	}
+	return u
+}
+
+// primary returns the URL the Keystone clients are created with.
+func (p *endpointPool) primary() string {
+	return p.endpoints[0].url
+}
+
+// candidates returns the endpoints to try for a request, the healthy ones
+// first in the order of the policy, then the unhealthy ones as a last resort.
+func (p *endpointPool) candidates() []*keystoneEndpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	start := 0
+	if p.roundRobin {
+		start = p.next
+		p.next = (p.next + 1) % len(p.endpoints)
+	}
+
+	var healthy, unhealthy []*keystoneEndpoint
+	for i := range p.endpoints {
+		e := p.endpoints[(start+i)%len(p.endpoints)]
+		if e.healthy {
+			healthy = append(healthy, e)
+		} else {
+			unhealthy = append(unhealthy, e)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// setHealthy updates the health of the endpoint.
+func (p *endpointPool) setHealthy(e *keystoneEndpoint, healthy bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e.healthy != healthy {
+		if healthy {
+			klog.Infof("Keystone endpoint %s is available again", e.url)
+		} else {
+			klog.Warningf("Keystone endpoint %s is unavailable", e.url)
+		}
+	}
+	e.healthy = healthy
+}
+
+// isPrimary returns whether the request is sent to the primary endpoint, i.e.
+// has its scheme and host and a path below its path.
+func (p *endpointPool) isPrimary(u *url.URL) bool {
+	primary := p.endpoints[0].parsed
+	return primary != nil && strings.EqualFold(u.Scheme, primary.Scheme) && strings.EqualFold(u.Host, primary.Host) &&
+		strings.HasPrefix(u.Path+"/", primary.Path)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (p *endpointPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(p.endpoints) == 1 || !p.isPrimary(req.URL) {
+		return p.transport.RoundTrip(req)
+	}
+
+	candidates := p.candidates()
+	if req.Body != nil && req.GetBody == nil {
+		// The request body can't be sent again.
+		candidates = candidates[:1]
+	}
+
+	var resp *http.Response
+	var err error
+	for i, e := range candidates {
+		r, rErr := p.rewriteRequest(req, e, i > 0)
+		if rErr != nil {
+			return nil, rErr
+		}
+
+		resp, err = p.transport.RoundTrip(r)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			p.setHealthy(e, true)
+			return resp, nil
+		}
+
+		p.setHealthy(e, false)
+		if i < len(candidates)-1 {
+			if err != nil {
+				klog.V(4).Infof("Failed to send the request to Keystone endpoint %s, trying the next one: %v", e.url, err)
+			} else {
+				klog.V(4).Infof("Keystone endpoint %s returned %d, trying the next one", e.url, resp.StatusCode)
+				resp.Body.Close()
+			}
+		}
+	}
+
+	return resp, err
+}
+
+// rewriteRequest returns a copy of the request for the primary endpoint sent
+// to the endpoint e instead, with a new copy of the body when the request is
+// retried.
+func (p *endpointPool) rewriteRequest(req *http.Request, e *keystoneEndpoint, retry bool) (*http.Request, error) {
+	if e.parsed == nil {
+		return nil, fmt.Errorf("invalid Keystone URL %s", e.url)
+	}
+
+	r := req.Clone(req.Context())
+	u := *req.URL
+	u.Scheme = e.parsed.Scheme
+	u.Host = e.parsed.Host
+	rel := strings.TrimPrefix(req.URL.Path, p.endpoints[0].parsed.Path)
+	if rel == req.URL.Path {
+		// The request is for the primary URL itself, without its trailing slash.
+		rel = ""
+	}
+	u.Path = e.parsed.Path + rel
+	u.RawPath = ""
+	r.URL = &u
+	// The Host header follows the URL.
+	r.Host = ""
+
+	if retry && req.Body != nil {
+		var err error
+		if r.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// checkHealth probes the unhealthy endpoints, so that they get requests again
+// once they recover.
+func (p *endpointPool) checkHealth() {
+	p.mu.Lock()
+	var unhealthy []*keystoneEndpoint
+	for _, e := range p.endpoints {
+		if !e.healthy {
+			unhealthy = append(unhealthy, e)
+		}
+	}
+	p.mu.Unlock()
+
+	client := &http.Client{Transport: p.transport, Timeout: healthCheckTimeout}
+	for _, e := range unhealthy {
+		resp, err := client.Get(e.url)
+		if err != nil {
+			klog.V(4).Infof("Keystone endpoint %s is still unavailable: %v", e.url, err)
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode < http.StatusInternalServerError {
+			p.setHealthy(e, true)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+)
+
+// newKeystoneServer returns a server answering with the status code and its
+// name, and counting the requests.
+func newKeystoneServer(name string, status *int, count *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*count++
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(*status)
+		_, _ = w.Write([]byte(name + ":" + r.URL.Path + ":" + string(body)))
+	}))
+}
+
+func doRequest(t *testing.T, p *endpointPool, method, url, body string) (int, string) {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	th.AssertNoErr(t, err)
+
+	resp, err := p.RoundTrip(req)
+	th.AssertNoErr(t, err)
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	th.AssertNoErr(t, err)
+	return resp.StatusCode, string(data)
+}
+
+func TestSplitKeystoneURLs(t *testing.T) {
+	th.AssertDeepEquals(t, []string{"https://a:5000/v3", "https://b:5000/v3"}, splitKeystoneURLs(" https://a:5000/v3, https://b:5000/v3,"))
+	th.AssertEquals(t, 0, len(splitKeystoneURLs("")))
+}
+
+func TestEndpointPoolFailover(t *testing.T) {
+	statusA, statusB := http.StatusServiceUnavailable, http.StatusOK
+	var countA, countB int
+	a := newKeystoneServer("a", &statusA, &countA)
+	defer a.Close()
+	b := newKeystoneServer("b", &statusB, &countB)
+	defer b.Close()
+
+	p := newEndpointPool([]string{a.URL + "/v3", b.URL + "/identity/v3"}, endpointPolicyFailover, nil)
+	th.AssertEquals(t, a.URL+"/v3/", p.primary())
+
+	// The request is retried with its body on the next endpoint
+	code, body := doRequest(t, p, http.MethodPost, p.primary()+"auth/tokens", "payload")
+	th.AssertEquals(t, http.StatusOK, code)
+	th.AssertEquals(t, "b:/identity/v3/auth/tokens:payload", body)
+	th.AssertEquals(t, 1, countA)
+
+	// The unhealthy endpoint is skipped until it recovers
+	code, _ = doRequest(t, p, http.MethodGet, p.primary()+"users", "")
+	th.AssertEquals(t, http.StatusOK, code)
+	th.AssertEquals(t, 1, countA)
+	th.AssertEquals(t, 2, countB)
+
+	statusA = http.StatusOK
+	p.checkHealth()
+	th.AssertEquals(t, 2, countA)
+
+	_, body = doRequest(t, p, http.MethodGet, p.primary()+"users", "")
+	th.AssertEquals(t, "a:/v3/users:", body)
+
+	// The error of the last endpoint is returned when all are unavailable
+	statusA, statusB = http.StatusBadGateway, http.StatusServiceUnavailable
+	code, _ = doRequest(t, p, http.MethodGet, p.primary()+"users", "")
+	th.AssertEquals(t, http.StatusServiceUnavailable, code)
+
+	// The requests for other URLs are left untouched
+	statusA = http.StatusOK
+	_, body = doRequest(t, p, http.MethodGet, a.URL+"/other", "")
+	th.AssertEquals(t, "a:/other:", body)
+}
+
+func TestEndpointPoolRoundRobin(t *testing.T) {
+	statusA, statusB := http.StatusOK, http.StatusOK
+	var countA, countB int
+	a := newKeystoneServer("a", &statusA, &countA)
+	defer a.Close()
+	b := newKeystoneServer("b", &statusB, &countB)
+	defer b.Close()
+
+	p := newEndpointPool([]string{a.URL, b.URL}, endpointPolicyRoundRobin, nil)
+	for i := 0; i < 4; i++ {
+		doRequest(t, p, http.MethodGet, p.primary()+"v3/", "")
+	}
+	th.AssertEquals(t, 2, countA)
+	th.AssertEquals(t, 2, countB)
+
+	// A unavailable endpoint gets no request
+	b.Close()
+	for i := 0; i < 4; i++ {
+		code, _ := doRequest(t, p, http.MethodGet, p.primary()+"v3/", "")
+		th.AssertEquals(t, http.StatusOK, code)
+	}
+	th.AssertEquals(t, 6, countA)
+}
+
+func TestEndpointPoolIsPrimary(t *testing.T) {
+	p := newEndpointPool([]string{"https://keystone:5000/v3", "https://keystone-2:5000/v3"}, endpointPolicyFailover, nil)
+
+	for u, expected := range map[string]bool{
+		"https://keystone:5000/v3":              true,
+		"https://keystone:5000/v3/":             true,
+		"https://KEYSTONE:5000/v3/auth/tokens":  true,
+		"https://keystone:5000/v3/users?name=a": true,
+		"https://keystone:5000/v30/users":       false,
+		"https://keystone.evil:5000/v3/users":   false,
+		"https://keystone:5001/v3/users":        false,
+		"http://keystone:5000/v3/users":         false,
+		"https://other/keystone:5000/v3/users":  false,
+	} {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		th.AssertNoErr(t, err)
+		if p.isPrimary(req.URL) != expected {
+			t.Errorf("expected isPrimary %v for %s", expected, u)
+		}
+	}
+
+	// The query is kept when the request is sent to another endpoint
+	req, err := http.NewRequest(http.MethodGet, "https://keystone:5000/v3/users?name=a", nil)
+	th.AssertNoErr(t, err)
+	r, err := p.rewriteRequest(req, p.endpoints[1], false)
+	th.AssertNoErr(t, err)
+	th.AssertEquals(t, "https://keystone-2:5000/v3/users?name=a", r.URL.String())
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// extraAttributes maps the Keystone attributes which may be emitted in the
+// extra fields of the TokenReview to their default key. The authenticator
+// always fills the default keys, which the authorizer and the syncer rely on.
+var extraAttributes = map[string]string{
+	"project_id":   ProjectID,
+	"project_name": ProjectName,
+	"domain_id":    DomainID,
+	"domain_name":  DomainName,
+	"roles":        Roles,
+	"expires_at":   ExpiresAt,
+}
+
+// defaultExtraFields are emitted when --token-review-extra-fields isn't set.
+const defaultExtraFields = "project_id,project_name,domain_id,domain_name,roles"
+
+// extraFields maps the default keys of the emitted extra fields to the keys
+// they are emitted with.
+type extraFields map[string]string
+
+// parseExtraFields parses a comma-separated list of Keystone attributes, each
+// optionally followed by "=" and the key it is emitted with, e.g.
+// "project_id=example.com/project-id,roles".
+func parseExtraFields(s string) (extraFields, error) {
+	fields := make(extraFields)
+	keys := make(map[string]bool)
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		attribute, key, renamed := strings.Cut(field, "=")
+		attribute = strings.TrimSpace(attribute)
+		defaultKey, ok := extraAttributes[attribute]
+		if !ok {
+			return nil, fmt.Errorf("unknown Keystone attribute %q, expected one of %s", attribute, strings.Join(extraAttributeNames(), ", "))
+		}
+		if !renamed {
+			key = defaultKey
+		}
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("empty key for Keystone attribute %q", attribute)
+		}
+		if _, ok := fields[defaultKey]; ok {
+			return nil, fmt.Errorf("duplicate Keystone attribute %q", attribute)
+		}
+		if keys[key] {
+			return nil, fmt.Errorf("duplicate key %q", key)
+		}
+
+		fields[defaultKey] = key
+		keys[key] = true
+	}
+
+	return fields, nil
+}
+
+func extraAttributeNames() []string {
+	names := make([]string, 0, len(extraAttributes))
+	for name := range extraAttributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// emit returns the extra fields of the TokenReview, keeping the selected
+// fields only under their configured key.
+func (f extraFields) emit(extra map[string][]string) map[string][]string {
+	if f == nil || extra == nil {
+		return extra
+	}
+
+	emitted := make(map[string][]string, len(f))
+	for defaultKey, values := range extra {
+		if key, ok := f[defaultKey]; ok {
+			emitted[key] = values
+		}
+	}
+	return emitted
+}
+
+// restore returns the extra fields of a SubjectAccessReview under their
+// default keys, so that the policies still match renamed fields.
+func (f extraFields) restore(extra map[string][]string) map[string][]string {
+	if f == nil || extra == nil {
+		return extra
+	}
+
+	restored := make(map[string][]string, len(extra))
+	for key, values := range extra {
+		restored[key] = values
+	}
+	for defaultKey, key := range f {
+		if key == defaultKey {
+			continue
+		}
+		delete(restored, defaultKey)
+		if values, ok := extra[key]; ok {
+			delete(restored, key)
+			restored[defaultKey] = values
+		}
+	}
+	return restored
+}
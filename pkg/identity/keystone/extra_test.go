@@ -0,0 +1,82 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"testing"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+)
+
+func TestParseExtraFields(t *testing.T) {
+	fields, err := parseExtraFields(defaultExtraFields)
+	th.AssertNoErr(t, err)
+	th.AssertDeepEquals(t, extraFields{
+		ProjectID:   ProjectID,
+		ProjectName: ProjectName,
+		DomainID:    DomainID,
+		DomainName:  DomainName,
+		Roles:       Roles,
+	}, fields)
+
+	fields, err = parseExtraFields(" project_id = example.com/project-id , roles, expires_at=example.com/expires-at")
+	th.AssertNoErr(t, err)
+	th.AssertDeepEquals(t, extraFields{
+		ProjectID: "example.com/project-id",
+		Roles:     Roles,
+		ExpiresAt: "example.com/expires-at",
+	}, fields)
+
+	fields, err = parseExtraFields("")
+	th.AssertNoErr(t, err)
+	th.AssertEquals(t, 0, len(fields))
+
+	for _, s := range []string{"project", "roles=", "roles,roles", "project_id=key,project_name=key"} {
+		if _, err := parseExtraFields(s); err == nil {
+			t.Errorf("expected an error parsing %q", s)
+		}
+	}
+}
+
+func TestExtraFieldsEmitRestore(t *testing.T) {
+	fields, err := parseExtraFields("project_id=example.com/project-id,roles")
+	th.AssertNoErr(t, err)
+
+	extra := map[string][]string{
+		ProjectID:   {"project-id"},
+		ProjectName: {"project-name"},
+		Roles:       {"role1", "role2"},
+	}
+	emitted := fields.emit(extra)
+	th.AssertDeepEquals(t, map[string][]string{
+		"example.com/project-id": {"project-id"},
+		Roles:                    {"role1", "role2"},
+	}, emitted)
+
+	// The extra fields sent back by the API server in the SubjectAccessReviews
+	emitted["other"] = []string{"value"}
+	th.AssertDeepEquals(t, map[string][]string{
+		ProjectID: {"project-id"},
+		Roles:     {"role1", "role2"},
+		"other":   {"value"},
+	}, fields.restore(emitted))
+
+	var unset extraFields
+	th.AssertDeepEquals(t, extra, unset.emit(extra))
+	th.AssertDeepEquals(t, extra, unset.restore(extra))
+	th.AssertDeepEquals(t, map[string][]string(nil), fields.emit(nil))
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"crypto/subtle"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+// staticToken is a break-glass identity of the fallback token file.
+type staticToken struct {
+	token string
+	user  *user.DefaultInfo
+}
+
+// loadStaticTokens reads the fallback token file. It has the format of the
+// token file of the API server, i.e. a CSV file with the token, user name,
+// user ID and optionally a quoted, comma-separated list of groups per line.
+func loadStaticTokens(path string) ([]staticToken, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	var tokens []staticToken
+	seen := make(map[string]bool)
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		if len(record) < 3 {
+			return nil, fmt.Errorf("line %d of %s has %d columns, at least the token, user name and user ID are required", line, path, len(record))
+		}
+
+		token := strings.TrimSpace(record[0])
+		if token == "" || record[1] == "" {
+			return nil, fmt.Errorf("line %d of %s has an empty token or user name", line, path)
+		}
+		if seen[token] {
+			return nil, fmt.Errorf("line %d of %s has a duplicate token", line, path)
+		}
+		seen[token] = true
+
+		info := &user.DefaultInfo{Name: record[1], UID: record[2]}
+		if len(record) > 3 && record[3] != "" {
+			for _, g := range strings.Split(record[3], ",") {
+				if g = strings.TrimSpace(g); g != "" {
+					info.Groups = append(info.Groups, g)
+				}
+			}
+		}
+		tokens = append(tokens, staticToken{token: token, user: info})
+	}
+
+	return tokens, nil
+}
+
+// lookupStaticToken returns the identity of the token in the fallback token
+// file. All the tokens are compared so that the lookup time doesn't depend on
+// the matching token.
+func lookupStaticToken(tokens []staticToken, token string) *user.DefaultInfo {
+	var found *user.DefaultInfo
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t.token), []byte(token)) == 1 {
+			found = t.user
+		}
+	}
+	return found
+}
+
+// isKeystoneUnavailable returns true if the error is a failure to reach
+// Keystone, as opposed to Keystone rejecting the token.
+func isKeystoneUnavailable(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var codeErr gophercloud.StatusCodeError
+	if errors.As(err, &codeErr) {
+		return codeErr.GetStatusCode() >= 500
+	}
+
+	return false
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+func TestLoadStaticTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.csv")
+	data := `# break-glass admins
+token1,admin,admin-uid,"system:masters,breakglass"
+token2,operator,operator-uid
+`
+	th.AssertNoErr(t, os.WriteFile(path, []byte(data), 0600))
+
+	tokens, err := loadStaticTokens(path)
+	th.AssertNoErr(t, err)
+	th.AssertEquals(t, 2, len(tokens))
+	th.AssertDeepEquals(t, &user.DefaultInfo{Name: "admin", UID: "admin-uid", Groups: []string{"system:masters", "breakglass"}}, lookupStaticToken(tokens, "token1"))
+	th.AssertDeepEquals(t, &user.DefaultInfo{Name: "operator", UID: "operator-uid"}, lookupStaticToken(tokens, "token2"))
+	if lookupStaticToken(tokens, "token3") != nil {
+		t.Errorf("unexpected identity for an unknown token")
+	}
+
+	th.AssertNoErr(t, os.WriteFile(path, []byte("token1,admin\n"), 0600))
+	_, err = loadStaticTokens(path)
+	th.AssertErr(t, err)
+
+	th.AssertNoErr(t, os.WriteFile(path, []byte("token1,admin,uid1\ntoken1,other,uid2\n"), 0600))
+	_, err = loadStaticTokens(path)
+	th.AssertErr(t, err)
+}
+
+func TestAuthenticateTokenFallback(t *testing.T) {
+	fallbackTokens := []staticToken{
+		{token: "breakglass", user: &user.DefaultInfo{Name: "admin", UID: "admin-uid", Groups: []string{"system:masters"}}},
+	}
+	unreachable := fmt.Errorf("failed to extract user information from Keystone response: %w",
+		&url.Error{Op: "Get", URL: "https://keystone/v3/auth/tokens", Err: syscall.ECONNREFUSED})
+	unavailable := gophercloud.ErrDefault503{ErrUnexpectedResponseCode: gophercloud.ErrUnexpectedResponseCode{Actual: 503}}
+	rejected := gophercloud.ErrDefault401{ErrUnexpectedResponseCode: gophercloud.ErrUnexpectedResponseCode{Actual: 401}}
+
+	ts := []struct {
+		name          string
+		token         string
+		keystoneErr   error
+		expectedUser  string
+		expectedAllow bool
+	}{
+		{
+			name:          "keystone unreachable",
+			token:         "breakglass",
+			keystoneErr:   unreachable,
+			expectedUser:  "admin",
+			expectedAllow: true,
+		},
+		{
+			name:          "keystone unavailable",
+			token:         "breakglass",
+			keystoneErr:   unavailable,
+			expectedUser:  "admin",
+			expectedAllow: true,
+		},
+		{
+			name:        "token rejected by keystone",
+			token:       "breakglass",
+			keystoneErr: rejected,
+		},
+		{
+			name:        "unknown token with keystone unreachable",
+			token:       "other",
+			keystoneErr: unreachable,
+		},
+	}
+
+	for _, tt := range ts {
+		t.Run(tt.name, func(t *testing.T) {
+			keystone := &MockIKeystone{}
+			keystone.
+				On("GetTokenInfo", tt.token).
+				Return(nil, tt.keystoneErr).
+				Once()
+
+			a := &Authenticator{
+				keystoner:      keystone,
+				fallbackTokens: fallbackTokens,
+			}
+			userInfo, allowed, err := a.AuthenticateToken(tt.token)
+
+			th.AssertEquals(t, tt.expectedAllow, allowed)
+			if tt.expectedAllow {
+				th.AssertNoErr(t, err)
+				th.AssertEquals(t, tt.expectedUser, userInfo.GetName())
+			} else {
+				th.AssertErr(t, err)
+			}
+
+			keystone.AssertExpectations(t)
+		})
+	}
+}
@@ -57,6 +57,7 @@ const (
 	ProjectName = "alpha.kubernetes.io/identity/project/name"
 	DomainID    = "alpha.kubernetes.io/identity/user/domain/id"
 	DomainName  = "alpha.kubernetes.io/identity/user/domain/name"
+	ExpiresAt   = "alpha.kubernetes.io/identity/token/expires-at"
 )
 
 var userAgentData []string
@@ -96,6 +97,16 @@ type Auth struct {
 	informer       informers.SharedInformerFactory
 	cmLister       corelisters.ConfigMapLister
 	cmListerSynced cache.InformerSynced
+	// revocation is the denylist of token audit IDs, nil if disabled.
+	revocation *revocationList
+	// extraFields are the extra fields emitted in the TokenReviews.
+	extraFields extraFields
+	// endpoints spreads the Keystone requests across the Keystone URLs.
+	endpoints *endpointPool
+	// opa authorizes the requests with the Rego policies of an OPA server, nil if disabled.
+	opa *opaAuthorizer
+	// rbac syncs the Keystone role assignments to RoleBindings, nil if disabled.
+	rbac *rbacSyncer
 }
 
 // Run starts the keystone webhook server.
@@ -116,6 +127,14 @@ func (k *Auth) Run() {
 		go wait.Until(k.runWorker, time.Second, k.stopCh)
 	}
 
+	if k.rbac != nil {
+		go wait.Until(k.rbac.run, k.config.RBACSyncInterval, k.stopCh)
+	}
+
+	if len(k.endpoints.endpoints) > 1 {
+		go wait.Until(k.endpoints.checkHealth, k.config.KeystoneHealthCheckInterval, k.stopCh)
+	}
+
 	r := chi.NewRouter()
 	r.HandleFunc("/webhook", k.Handler)
 
@@ -136,7 +155,7 @@ func (k *Auth) enqueueConfigMap(obj interface{}) {
 		return
 	}
 
-	if namespace == cmNamespace && (name == k.config.PolicyConfigMapName || name == k.config.SyncConfigMapName) {
+	if namespace == cmNamespace && (name == k.config.PolicyConfigMapName || name == k.config.SyncConfigMapName || name == k.config.RevocationConfigMapName) {
 		k.queue.Add(key)
 	}
 }
@@ -209,6 +228,13 @@ func (k *Auth) updateSyncConfig(cm *apiv1.ConfigMap, key string) {
 	klog.Infof("Sync configuration updated.")
 }
 
+func (k *Auth) updateRevocations(cm *apiv1.ConfigMap) {
+	ids := parseAuditIDs(cm.Data["auditIDs"])
+	k.revocation.setConfigMapIDs(ids)
+
+	klog.Infof("Token revocation list updated with %d audit IDs.", len(ids))
+}
+
 func (k *Auth) processItem(key string) error {
 	namespace, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
@@ -231,6 +257,10 @@ func (k *Auth) processItem(key string) error {
 			k.syncer.syncConfig = &sc
 			k.syncer.mu.Unlock()
 		}
+		if name == k.config.RevocationConfigMapName {
+			klog.Infof("RevocationConfigmap %v has been deleted.", k.config.RevocationConfigMapName)
+			k.revocation.setConfigMapIDs(nil)
+		}
 	case err != nil:
 		return fmt.Errorf("error fetching object with key %s: %v", key, err)
 	default:
@@ -240,6 +270,9 @@ func (k *Auth) processItem(key string) error {
 		if name == k.config.SyncConfigMapName {
 			k.updateSyncConfig(cm, key)
 		}
+		if name == k.config.RevocationConfigMapName {
+			k.updateRevocations(cm)
+		}
 	}
 
 	return nil
@@ -314,6 +347,7 @@ func (k *Auth) authenticateToken(w http.ResponseWriter, r *http.Request, token s
 
 	// Modify user info according to the sync configuration.
 	response.User = *k.syncer.syncRoles(&info)
+	response.User.Extra = k.extraFields.emit(response.User.Extra)
 
 	data["status"] = response
 
@@ -352,6 +386,7 @@ func (k *Auth) authorizeToken(w http.ResponseWriter, r *http.Request, data map[s
 				}
 			}
 		}
+		usr.Extra = k.extraFields.restore(usr.Extra)
 	}
 
 	if resourceAttributes, ok := spec["resourceAttributes"]; ok {
@@ -376,7 +411,16 @@ func (k *Auth) authorizeToken(w http.ResponseWriter, r *http.Request, data map[s
 	}
 
 	var allowed authorizer.Decision
-	if len(k.authz.pl) > 0 {
+	if k.opa != nil {
+		var reason string
+		var err error
+		allowed, reason, err = k.opa.Authorize(attrs)
+		klog.V(4).Infof("<<<< authorizeToken: %v, %v, %v\n", allowed, reason, err)
+		if err != nil {
+			http.Error(w, reason, http.StatusInternalServerError)
+			return
+		}
+	} else if len(k.authz.pl) > 0 {
 		var reason string
 		var err error
 		allowed, reason, err = k.authz.Authorize(attrs)
@@ -406,13 +450,24 @@ func (k *Auth) authorizeToken(w http.ResponseWriter, r *http.Request, data map[s
 
 // NewKeystoneAuth returns a new KeystoneAuth controller
 func NewKeystoneAuth(c *Config) (*Auth, error) {
-	keystoneClient, err := createKeystoneClient(c.KeystoneURL, c.KeystoneCA)
+	urls := splitKeystoneURLs(c.KeystoneURL)
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("auth URL is empty")
+	}
+	transport, err := createKeystoneTransport(c.KeystoneCA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize keystone client: %v", err)
+	}
+	// The requests are spread across the Keystone URLs by the endpoint pool.
+	endpoints := newEndpointPool(urls, c.KeystoneEndpointPolicy, transport)
+
+	keystoneClient, err := createKeystoneClient(endpoints.primary(), endpoints)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize keystone client: %v", err)
 	}
 
 	var k8sClient *kubernetes.Clientset
-	if c.PolicyConfigMapName != "" || c.SyncConfigMapName != "" || c.SyncConfigFile != "" {
+	if c.PolicyConfigMapName != "" || c.SyncConfigMapName != "" || c.SyncConfigFile != "" || c.RevocationConfigMapName != "" || c.RBACSyncInterval > 0 {
 		k8sClient, err = createKubernetesClient(c.Kubeconfig)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get kubernetes client: %v", err)
@@ -440,6 +495,16 @@ func NewKeystoneAuth(c *Config) (*Auth, error) {
 		}
 	}
 
+	// The Rego policies of the OPA server replace the JSON policy.
+	var opa *opaAuthorizer
+	if c.OPAURL != "" {
+		opa, err = newOPAAuthorizer(c.OPAURL, c.OPATimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OPA URL %s: %v", c.OPAURL, err)
+		}
+		klog.Infof("Authorization decisions are queried from OPA at %s", c.OPAURL)
+	}
+
 	if len(policy) > 0 {
 		output, err := json.MarshalIndent(policy, "", "  ")
 		if err == nil {
@@ -481,13 +546,58 @@ func NewKeystoneAuth(c *Config) (*Auth, error) {
 		}
 	}
 
+	fields, err := parseExtraFields(c.ExtraFields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the TokenReview extra fields: %v", err)
+	}
+
+	// Tokens can be revoked by listing their audit IDs in the revocation configmap.
+	var revocation *revocationList
+	if c.RevocationConfigMapName != "" {
+		revocation = newRevocationList()
+		cm, err := k8sClient.CoreV1().ConfigMaps(cmNamespace).Get(context.TODO(), c.RevocationConfigMapName, metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get configmap %s: %v", c.RevocationConfigMapName, err)
+		}
+		if err == nil {
+			revocation.setConfigMapIDs(parseAuditIDs(cm.Data["auditIDs"]))
+		}
+	}
+	// The break-glass tokens keep the cluster reachable by its operators during a Keystone outage.
+	var fallbackTokens []staticToken
+	if c.FallbackTokenFile != "" {
+		fallbackTokens, err = loadStaticTokens(c.FallbackTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the fallback token file: %v", err)
+		}
+		klog.Infof("Loaded %d fallback tokens, accepted when Keystone is unavailable", len(fallbackTokens))
+	}
+
 	keystoneAuth := &Auth{
-		authn:     &Authenticator{keystoner: NewKeystoner(keystoneClient)},
-		authz:     &Authorizer{authURL: c.KeystoneURL, client: keystoneClient, pl: policy},
-		syncer:    &Syncer{k8sClient: k8sClient, syncConfig: sc},
-		k8sClient: k8sClient,
-		config:    c,
-		stopCh:    make(chan struct{}),
+		authn: &Authenticator{
+			keystoner:      NewKeystoner(keystoneClient),
+			groupsLookup:   c.GroupsLookup,
+			groupPrefix:    c.GroupPrefix,
+			revocation:     revocation,
+			fallbackTokens: fallbackTokens,
+		},
+		authz:       &Authorizer{authURL: endpoints.primary(), client: keystoneClient, pl: policy},
+		syncer:      &Syncer{k8sClient: k8sClient, syncConfig: sc},
+		k8sClient:   k8sClient,
+		config:      c,
+		extraFields: fields,
+		stopCh:      make(chan struct{}),
+		endpoints:   endpoints,
+		opa:         opa,
+
+		revocation: revocation,
+	}
+
+	if c.RBACSyncInterval > 0 {
+		keystoneAuth.rbac, err = newRBACSyncer(k8sClient, keystoneAuth.syncer, endpoints.primary(), endpoints)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize the RBAC sync: %v", err)
+		}
 	}
 
 	if k8sClient != nil {
@@ -578,24 +688,29 @@ func createKubernetesClient(kubeConfig string) (*kubernetes.Clientset, error) {
 	return client, nil
 }
 
-func createKeystoneClient(authURL string, caFile string) (*gophercloud.ServiceClient, error) {
+// createKeystoneTransport returns the transport of the Keystone requests,
+// nil for the default transport if no CA file is specified.
+func createKeystoneTransport(caFile string) (http.RoundTripper, error) {
+	if caFile == "" {
+		return nil, nil
+	}
+	roots, err := certutil.NewPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{}
+	config.RootCAs = roots
+	return netutil.SetOldTransportDefaults(&http.Transport{TLSClientConfig: config}), nil
+}
+
+func createKeystoneClient(authURL string, transport http.RoundTripper) (*gophercloud.ServiceClient, error) {
 	// FIXME: Enable this check later
 	//if !strings.HasPrefix(authURL, "https") {
 	//	return nil, errors.New("Auth URL should be secure and start with https")
 	//}
-	var transport http.RoundTripper
 	if authURL == "" {
 		return nil, fmt.Errorf("auth URL is empty")
 	}
-	if caFile != "" {
-		roots, err := certutil.NewPool(caFile)
-		if err != nil {
-			return nil, err
-		}
-		config := &tls.Config{}
-		config.RootCAs = roots
-		transport = netutil.SetOldTransportDefaults(&http.Transport{TLSClientConfig: config})
-	}
 	opts := gophercloud.AuthOptions{IdentityEndpoint: authURL}
 	provider, err := createIdentityV3Provider(opts, transport)
 	if err != nil {
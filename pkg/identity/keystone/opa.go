@@ -0,0 +1,163 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/klog/v2"
+)
+
+// opaAuthorizer authorizes the SubjectAccessReviews with the Rego policies of an Open Policy Agent server, as an
+// alternative to the JSON policy. The Rego policies are loaded by the OPA server, from local files or from a bundle
+// server, and the decision is queried with the OPA Data API, e.g. POST /v1/data/kubernetes/authz.
+type opaAuthorizer struct {
+	url    string
+	client *http.Client
+}
+
+func newOPAAuthorizer(opaURL string, timeout time.Duration) (*opaAuthorizer, error) {
+	u, err := url.Parse(opaURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q, expected http or https", u.Scheme)
+	}
+
+	return &opaAuthorizer{
+		url:    opaURL,
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// opaInput returns the input document of the query, a SubjectAccessReview built from the attributes. The user
+// extra carries the Keystone roles and projects, with their full names.
+func opaInput(attributes authorizer.Attributes) map[string]interface{} {
+	user := attributes.GetUser()
+	spec := map[string]interface{}{
+		"user":   user.GetName(),
+		"uid":    user.GetUID(),
+		"groups": user.GetGroups(),
+		"extra":  user.GetExtra(),
+	}
+
+	if attributes.IsResourceRequest() {
+		spec["resourceAttributes"] = map[string]interface{}{
+			"namespace":   attributes.GetNamespace(),
+			"verb":        attributes.GetVerb(),
+			"group":       attributes.GetAPIGroup(),
+			"version":     attributes.GetAPIVersion(),
+			"resource":    attributes.GetResource(),
+			"subresource": attributes.GetSubresource(),
+			"name":        attributes.GetName(),
+		}
+	} else {
+		spec["nonResourceAttributes"] = map[string]interface{}{
+			"path": attributes.GetPath(),
+			"verb": attributes.GetVerb(),
+		}
+	}
+
+	return map[string]interface{}{
+		"apiVersion": "authorization.k8s.io/v1",
+		"kind":       "SubjectAccessReview",
+		"spec":       spec,
+	}
+}
+
+// opaDecision is the decision of an object policy document. A policy document may also be a plain boolean.
+type opaDecision struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason"`
+}
+
+// parseOPAResult returns the decision found in the response of the OPA Data API. An undefined document, e.g. a
+// typo in the URL or a policy not loaded yet, denies the request.
+func parseOPAResult(body []byte) (authorizer.Decision, string, error) {
+	var response struct {
+		Result *json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return authorizer.DecisionDeny, "", fmt.Errorf("failed to parse the OPA response: %v", err)
+	}
+	if response.Result == nil {
+		return authorizer.DecisionDeny, "OPA policy decision is undefined", nil
+	}
+
+	var decision opaDecision
+	if err := json.Unmarshal(*response.Result, &decision.Allowed); err != nil {
+		if err := json.Unmarshal(*response.Result, &decision); err != nil {
+			return authorizer.DecisionDeny, "", fmt.Errorf("unexpected OPA policy decision %s, expected a boolean or an object with an allowed field", string(*response.Result))
+		}
+	}
+
+	if decision.Allowed {
+		return authorizer.DecisionAllow, decision.Reason, nil
+	}
+	return authorizer.DecisionDeny, decision.Reason, nil
+}
+
+// Authorize queries the OPA server for the decision about the attributes.
+func (a *opaAuthorizer) Authorize(attributes authorizer.Attributes) (authorizer.Decision, string, error) {
+	// When the user.Extra does not exist, it means that the keystone user authentication has failed, and the authorization verification should not pass.
+	if attributes.GetUser().GetExtra() == nil {
+		return authorizer.DecisionDeny, "No auth info found.", nil
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"input": opaInput(attributes)})
+	if err != nil {
+		return authorizer.DecisionDeny, "", err
+	}
+
+	req, err := http.NewRequestWithContext(context.TODO(), http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return authorizer.DecisionDeny, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return authorizer.DecisionDeny, "OPA server unavailable", fmt.Errorf("failed to query OPA: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return authorizer.DecisionDeny, "OPA server unavailable", fmt.Errorf("failed to read the OPA response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return authorizer.DecisionDeny, "OPA policy evaluation failed", fmt.Errorf("OPA returned %s: %s", resp.Status, string(data))
+	}
+
+	decision, reason, err := parseOPAResult(data)
+	if err != nil {
+		return authorizer.DecisionDeny, "OPA policy evaluation failed", err
+	}
+
+	klog.V(4).Infof("OPA decision for user %s: %v, %s", attributes.GetUser().GetName(), decision == authorizer.DecisionAllow, reason)
+
+	return decision, reason, nil
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+func TestParseOPAResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		decision authorizer.Decision
+		reason   string
+		err      bool
+	}{
+		{name: "boolean allow", body: `{"result": true}`, decision: authorizer.DecisionAllow},
+		{name: "boolean deny", body: `{"result": false}`, decision: authorizer.DecisionDeny},
+		{name: "object allow", body: `{"result": {"allowed": true, "reason": "member of project1"}}`, decision: authorizer.DecisionAllow, reason: "member of project1"},
+		{name: "object deny", body: `{"result": {"allowed": false}}`, decision: authorizer.DecisionDeny},
+		{name: "undefined", body: `{}`, decision: authorizer.DecisionDeny, reason: "OPA policy decision is undefined"},
+		{name: "unexpected result", body: `{"result": "yes"}`, decision: authorizer.DecisionDeny, err: true},
+		{name: "invalid json", body: `{`, decision: authorizer.DecisionDeny, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, reason, err := parseOPAResult([]byte(tt.body))
+			th.AssertEquals(t, tt.err, err != nil)
+			th.AssertEquals(t, tt.decision, decision)
+			th.AssertEquals(t, tt.reason, reason)
+		})
+	}
+}
+
+func TestOPAAuthorizer(t *testing.T) {
+	var input map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		th.AssertEquals(t, http.MethodPost, r.Method)
+		th.AssertEquals(t, "/v1/data/kubernetes/authz", r.URL.Path)
+
+		var body struct {
+			Input map[string]interface{} `json:"input"`
+		}
+		th.AssertNoErr(t, json.NewDecoder(r.Body).Decode(&body))
+		input = body.Input
+
+		spec := input["spec"].(map[string]interface{})
+		allowed := spec["user"] == "user1"
+		_, _ = w.Write([]byte(`{"result": {"allowed": ` + strconv.FormatBool(allowed) + `}}`))
+	}))
+	defer server.Close()
+
+	a, err := newOPAAuthorizer(server.URL+"/v1/data/kubernetes/authz", time.Second)
+	th.AssertNoErr(t, err)
+
+	attrs := authorizer.AttributesRecord{
+		User: &user.DefaultInfo{
+			Name:   "user1",
+			Groups: []string{"group1"},
+			Extra: map[string][]string{
+				ProjectName: {"project1"},
+				Roles:       {"role1"},
+			},
+		},
+		ResourceRequest: true,
+		Verb:            "get",
+		Namespace:       "default",
+		Resource:        "pods",
+	}
+
+	decision, _, err := a.Authorize(attrs)
+	th.AssertNoErr(t, err)
+	th.AssertEquals(t, authorizer.DecisionAllow, decision)
+
+	th.AssertEquals(t, "SubjectAccessReview", input["kind"])
+	spec := input["spec"].(map[string]interface{})
+	th.AssertDeepEquals(t, []interface{}{"group1"}, spec["groups"])
+	th.AssertDeepEquals(t, map[string]interface{}{ProjectName: []interface{}{"project1"}, Roles: []interface{}{"role1"}}, spec["extra"])
+	resourceAttributes := spec["resourceAttributes"].(map[string]interface{})
+	th.AssertEquals(t, "get", resourceAttributes["verb"])
+	th.AssertEquals(t, "pods", resourceAttributes["resource"])
+	th.AssertEquals(t, "default", resourceAttributes["namespace"])
+
+	attrs.User = &user.DefaultInfo{Name: "user2", Extra: map[string][]string{}}
+	attrs.ResourceRequest = false
+	attrs.Path = "/healthz"
+	decision, _, err = a.Authorize(attrs)
+	th.AssertNoErr(t, err)
+	th.AssertEquals(t, authorizer.DecisionDeny, decision)
+	spec = input["spec"].(map[string]interface{})
+	th.AssertDeepEquals(t, map[string]interface{}{"path": "/healthz", "verb": "get"}, spec["nonResourceAttributes"])
+
+	// Users without the extra fields are not Keystone users, OPA is not queried.
+	input = nil
+	attrs.User = &user.DefaultInfo{Name: "user1"}
+	decision, _, err = a.Authorize(attrs)
+	th.AssertNoErr(t, err)
+	th.AssertEquals(t, authorizer.DecisionDeny, decision)
+	th.AssertEquals(t, true, input == nil)
+}
+
+func TestOPAAuthorizerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"code": "internal_error"}`, http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	a, err := newOPAAuthorizer(server.URL, time.Second)
+	th.AssertNoErr(t, err)
+
+	attrs := authorizer.AttributesRecord{
+		User:            &user.DefaultInfo{Name: "user1", Extra: map[string][]string{}},
+		ResourceRequest: true,
+		Verb:            "list",
+		Resource:        "pods",
+	}
+	decision, _, err := a.Authorize(attrs)
+	th.AssertEquals(t, true, err != nil)
+	th.AssertEquals(t, authorizer.DecisionDeny, decision)
+
+	_, err = newOPAAuthorizer("unix:///var/run/opa.sock", time.Second)
+	th.AssertEquals(t, true, err != nil)
+}
@@ -0,0 +1,333 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/roles"
+	rbacv1 "k8s.io/api/rbac/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	cpoutil "k8s.io/cloud-provider-openstack/pkg/util"
+)
+
+// rbacSyncLabel marks the RoleBindings and ClusterRoleBindings managed by the RBAC sync, the other ones are never
+// modified.
+const rbacSyncLabel = "keystone.openstack.org/rbac-sync"
+
+// rbacMapping binds a Kubernetes ClusterRole to the users having a Keystone role in a project, see rbacSyncer.
+type rbacMapping struct {
+	KeystoneRole string `yaml:"keystone-role"`
+	ClusterRole  string `yaml:"cluster-role"`
+
+	// ClusterWide binds the ClusterRole with a ClusterRoleBinding, to the users having the Keystone role in one of
+	// Projects, instead of with a RoleBinding in the namespace of each project.
+	ClusterWide bool     `yaml:"cluster-wide"`
+	Projects    []string `yaml:"projects"`
+}
+
+func (m *rbacMapping) validate() error {
+	if m.KeystoneRole == "" || m.ClusterRole == "" {
+		return fmt.Errorf("keystone-role and cluster-role are required")
+	}
+	if m.ClusterWide && len(m.Projects) == 0 {
+		return fmt.Errorf("cluster-wide mapping of Keystone role %s requires the projects of the role assignments", m.KeystoneRole)
+	}
+	return nil
+}
+
+// bindingName is the name of the RoleBinding or ClusterRoleBinding of the mapping.
+func (m *rbacMapping) bindingName() string {
+	return "keystone:" + m.KeystoneRole + ":" + m.ClusterRole
+}
+
+// rbacBinding is a RoleBinding, or a ClusterRoleBinding if its namespace is empty, wanted by the RBAC sync.
+type rbacBinding struct {
+	namespace   string
+	name        string
+	clusterRole string
+	users       sets.Set[string]
+}
+
+func (b *rbacBinding) key() string {
+	return b.namespace + "/" + b.name
+}
+
+func (b *rbacBinding) subjects() []rbacv1.Subject {
+	var subjects []rbacv1.Subject
+	for _, user := range sets.List(b.users) {
+		subjects = append(subjects, rbacv1.Subject{APIGroup: rbacv1.GroupName, Kind: rbacv1.UserKind, Name: user})
+	}
+	return subjects
+}
+
+func (b *rbacBinding) roleRef() rbacv1.RoleRef {
+	return rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: b.clusterRole}
+}
+
+// rbacSyncer periodically materializes the Keystone role assignments as RoleBindings and ClusterRoleBindings,
+// according to the rbac-mappings of the sync config, so that the requests can be authorized by the native RBAC
+// authorizer instead of the webhook.
+type rbacSyncer struct {
+	k8sClient kubernetes.Interface
+	syncer    *Syncer
+	// listAssignments lists the effective role assignments of the users, with the names of the users and projects.
+	listAssignments func() ([]roles.RoleAssignment, error)
+}
+
+// newRBACSyncer authenticates to authURL with the Keystone credentials of the OS_* environment variables, which
+// must be allowed to list the role assignments.
+func newRBACSyncer(k8sClient kubernetes.Interface, syncer *Syncer, authURL string, transport http.RoundTripper) (*rbacSyncer, error) {
+	opts, err := openstack.AuthOptionsFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the Keystone credentials from the environment: %v", err)
+	}
+	opts.IdentityEndpoint = authURL
+	opts.AllowReauth = true
+
+	provider, err := createIdentityV3Provider(opts, transport)
+	if err != nil {
+		return nil, err
+	}
+	if err := openstack.Authenticate(provider, opts); err != nil {
+		return nil, fmt.Errorf("failed to authenticate: %v", err)
+	}
+	client, err := openstack.NewIdentityV3(provider, gophercloud.EndpointOpts{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &rbacSyncer{
+		k8sClient: k8sClient,
+		syncer:    syncer,
+		listAssignments: func() ([]roles.RoleAssignment, error) {
+			effective, includeNames := true, true
+			pages, err := roles.ListAssignments(client, roles.ListAssignmentsOpts{Effective: &effective, IncludeNames: &includeNames}).AllPages()
+			if err != nil {
+				return nil, err
+			}
+			return roles.ExtractRoleAssignments(pages)
+		},
+	}, nil
+}
+
+// run syncs the bindings, logging the errors.
+func (r *rbacSyncer) run() {
+	if err := r.sync(context.TODO()); err != nil {
+		klog.Errorf("Failed to sync the Keystone role assignments to RBAC: %v", err)
+	}
+}
+
+func (r *rbacSyncer) sync(ctx context.Context) error {
+	r.syncer.mu.Lock()
+	sc := r.syncer.syncConfig
+	r.syncer.mu.Unlock()
+
+	var wanted []*rbacBinding
+	if sc != nil && len(sc.RBACMappings) > 0 {
+		assignments, err := r.listAssignments()
+		if err != nil {
+			return fmt.Errorf("failed to list the Keystone role assignments: %v", err)
+		}
+		wanted = sc.rbacBindings(assignments)
+	}
+
+	return r.apply(ctx, wanted)
+}
+
+// rbacBindings returns the bindings of the role assignments wanted by the RBAC mappings.
+func (sc *syncConfig) rbacBindings(assignments []roles.RoleAssignment) []*rbacBinding {
+	bindings := make(map[string]*rbacBinding)
+	add := func(namespace string, m *rbacMapping, user string) {
+		b := &rbacBinding{namespace: namespace, name: m.bindingName(), clusterRole: m.ClusterRole}
+		if existing, ok := bindings[b.key()]; ok {
+			b = existing
+		} else {
+			b.users = sets.New[string]()
+			bindings[b.key()] = b
+		}
+		b.users.Insert(user)
+	}
+
+	for _, a := range assignments {
+		project := a.Scope.Project
+		if project.ID == "" || a.User.Name == "" {
+			// Domain and system scoped assignments don't map to a namespace
+			continue
+		}
+		if cpoutil.Contains(sc.ProjectBlackList, project.ID) || cpoutil.Contains(sc.ProjectNameBlackList, project.Name) {
+			continue
+		}
+
+		for _, m := range sc.RBACMappings {
+			if m.KeystoneRole != a.Role.Name {
+				continue
+			}
+			if !m.ClusterWide {
+				add(sc.formatNamespaceName(project.ID, project.Name, a.User.Domain.ID), m, a.User.Name)
+			} else if cpoutil.Contains(m.Projects, project.ID) || cpoutil.Contains(m.Projects, project.Name) {
+				add("", m, a.User.Name)
+			}
+		}
+	}
+
+	res := make([]*rbacBinding, 0, len(bindings))
+	for _, b := range bindings {
+		res = append(res, b)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].key() < res[j].key() })
+
+	return res
+}
+
+// apply creates or updates the wanted bindings, and deletes the other bindings managed by the RBAC sync.
+func (r *rbacSyncer) apply(ctx context.Context, wanted []*rbacBinding) error {
+	selector := metav1.ListOptions{LabelSelector: labels.Set{rbacSyncLabel: "true"}.String()}
+	objectMeta := func(b *rbacBinding) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: b.name, Namespace: b.namespace, Labels: map[string]string{rbacSyncLabel: "true"}}
+	}
+
+	roleBindings, err := r.k8sClient.RbacV1().RoleBindings("").List(ctx, selector)
+	if err != nil {
+		return fmt.Errorf("failed to list the RoleBindings: %v", err)
+	}
+	clusterRoleBindings, err := r.k8sClient.RbacV1().ClusterRoleBindings().List(ctx, selector)
+	if err != nil {
+		return fmt.Errorf("failed to list the ClusterRoleBindings: %v", err)
+	}
+
+	existing := make(map[string]metav1.Object)
+	for i := range roleBindings.Items {
+		existing[roleBindings.Items[i].Namespace+"/"+roleBindings.Items[i].Name] = &roleBindings.Items[i]
+	}
+	for i := range clusterRoleBindings.Items {
+		existing["/"+clusterRoleBindings.Items[i].Name] = &clusterRoleBindings.Items[i]
+	}
+
+	var errs []error
+	for _, b := range wanted {
+		current := existing[b.key()]
+		delete(existing, b.key())
+
+		if b.namespace == "" {
+			crb := &rbacv1.ClusterRoleBinding{ObjectMeta: objectMeta(b), Subjects: b.subjects(), RoleRef: b.roleRef()}
+			err = r.applyClusterRoleBinding(ctx, crb, current)
+		} else {
+			rb := &rbacv1.RoleBinding{ObjectMeta: objectMeta(b), Subjects: b.subjects(), RoleRef: b.roleRef()}
+			err = r.applyRoleBinding(ctx, rb, current)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, obj := range existing {
+		if obj.GetNamespace() == "" {
+			err = r.k8sClient.RbacV1().ClusterRoleBindings().Delete(ctx, obj.GetName(), metav1.DeleteOptions{})
+		} else {
+			err = r.k8sClient.RbacV1().RoleBindings(obj.GetNamespace()).Delete(ctx, obj.GetName(), metav1.DeleteOptions{})
+		}
+		if err != nil && !k8serrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to delete the binding %s/%s: %v", obj.GetNamespace(), obj.GetName(), err))
+			continue
+		}
+		klog.Infof("Deleted the binding %s/%s of the Keystone role assignments", obj.GetNamespace(), obj.GetName())
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+func (r *rbacSyncer) applyRoleBinding(ctx context.Context, rb *rbacv1.RoleBinding, current metav1.Object) error {
+	client := r.k8sClient.RbacV1().RoleBindings(rb.Namespace)
+	if current == nil {
+		// The namespaces are not created for the projects unless the projects are synced
+		if _, err := r.k8sClient.CoreV1().Namespaces().Get(ctx, rb.Namespace, metav1.GetOptions{}); k8serrors.IsNotFound(err) {
+			klog.V(4).Infof("Namespace %s not found, skipping the RoleBinding %s", rb.Namespace, rb.Name)
+			return nil
+		}
+		if _, err := client.Create(ctx, rb, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create the RoleBinding %s/%s: %v", rb.Namespace, rb.Name, err)
+		}
+		klog.Infof("Created the RoleBinding %s/%s of the Keystone role assignments", rb.Namespace, rb.Name)
+		return nil
+	}
+
+	existing := current.(*rbacv1.RoleBinding)
+	if reflect.DeepEqual(existing.Subjects, rb.Subjects) && existing.RoleRef == rb.RoleRef {
+		return nil
+	}
+	// The role of a binding can't be changed
+	if existing.RoleRef != rb.RoleRef {
+		if err := client.Delete(ctx, rb.Name, metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete the RoleBinding %s/%s: %v", rb.Namespace, rb.Name, err)
+		}
+		_, err := client.Create(ctx, rb, metav1.CreateOptions{})
+		return err
+	}
+	existing = existing.DeepCopy()
+	existing.Subjects = rb.Subjects
+	if _, err := client.Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update the RoleBinding %s/%s: %v", rb.Namespace, rb.Name, err)
+	}
+	klog.V(2).Infof("Updated the subjects of the RoleBinding %s/%s of the Keystone role assignments", rb.Namespace, rb.Name)
+	return nil
+}
+
+func (r *rbacSyncer) applyClusterRoleBinding(ctx context.Context, crb *rbacv1.ClusterRoleBinding, current metav1.Object) error {
+	client := r.k8sClient.RbacV1().ClusterRoleBindings()
+	if current == nil {
+		if _, err := client.Create(ctx, crb, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create the ClusterRoleBinding %s: %v", crb.Name, err)
+		}
+		klog.Infof("Created the ClusterRoleBinding %s of the Keystone role assignments", crb.Name)
+		return nil
+	}
+
+	existing := current.(*rbacv1.ClusterRoleBinding)
+	if reflect.DeepEqual(existing.Subjects, crb.Subjects) && existing.RoleRef == crb.RoleRef {
+		return nil
+	}
+	if existing.RoleRef != crb.RoleRef {
+		if err := client.Delete(ctx, crb.Name, metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete the ClusterRoleBinding %s: %v", crb.Name, err)
+		}
+		_, err := client.Create(ctx, crb, metav1.CreateOptions{})
+		return err
+	}
+	existing = existing.DeepCopy()
+	existing.Subjects = crb.Subjects
+	if _, err := client.Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update the ClusterRoleBinding %s: %v", crb.Name, err)
+	}
+	klog.V(2).Infof("Updated the subjects of the ClusterRoleBinding %s of the Keystone role assignments", crb.Name)
+	return nil
+}
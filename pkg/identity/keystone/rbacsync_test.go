@@ -0,0 +1,143 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/roles"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newRoleAssignment(role, user, projectID, projectName string) roles.RoleAssignment {
+	var a roles.RoleAssignment
+	a.Role.Name = role
+	a.User.Name = user
+	a.User.Domain.ID = "default"
+	a.Scope.Project.ID = projectID
+	a.Scope.Project.Name = projectName
+	return a
+}
+
+func subjectNames(subjects []rbacv1.Subject) []string {
+	var names []string
+	for _, s := range subjects {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func TestRBACBindings(t *testing.T) {
+	sc := newSyncConfig()
+	sc.ProjectNameBlackList = []string{"blacklisted"}
+	sc.RBACMappings = []*rbacMapping{
+		{KeystoneRole: "member", ClusterRole: "edit"},
+		{KeystoneRole: "admin", ClusterRole: "cluster-admin", ClusterWide: true, Projects: []string{"admin"}},
+	}
+
+	domainAssignment := newRoleAssignment("member", "carol", "", "")
+	domainAssignment.Scope.Domain.ID = "default"
+
+	bindings := sc.rbacBindings([]roles.RoleAssignment{
+		newRoleAssignment("member", "bob", "p1", "project-1"),
+		newRoleAssignment("member", "alice", "p1", "project-1"),
+		newRoleAssignment("member", "alice", "p2", "project-2"),
+		newRoleAssignment("reader", "dave", "p2", "project-2"),
+		newRoleAssignment("member", "eve", "p3", "blacklisted"),
+		newRoleAssignment("admin", "alice", "p4", "admin"),
+		newRoleAssignment("admin", "bob", "p1", "project-1"),
+		domainAssignment,
+	})
+
+	th.AssertEquals(t, 3, len(bindings))
+	th.AssertEquals(t, "/keystone:admin:cluster-admin", bindings[0].key())
+	th.AssertDeepEquals(t, []string{"alice"}, subjectNames(bindings[0].subjects()))
+	th.AssertEquals(t, "p1/keystone:member:edit", bindings[1].key())
+	th.AssertDeepEquals(t, []string{"alice", "bob"}, subjectNames(bindings[1].subjects()))
+	th.AssertEquals(t, "edit", bindings[1].roleRef().Name)
+	th.AssertEquals(t, "p2/keystone:member:edit", bindings[2].key())
+	th.AssertDeepEquals(t, []string{"alice"}, subjectNames(bindings[2].subjects()))
+}
+
+func TestRBACSync(t *testing.T) {
+	ctx := context.TODO()
+	managed := map[string]string{rbacSyncLabel: "true"}
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "p1"}},
+		// Outdated subjects
+		&rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "p1", Name: "keystone:member:edit", Labels: managed},
+			Subjects:   []rbacv1.Subject{{APIGroup: rbacv1.GroupName, Kind: rbacv1.UserKind, Name: "eve"}},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "edit"},
+		},
+		// Not wanted anymore
+		&rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Namespace: "p1", Name: "keystone:reader:view", Labels: managed}},
+		// Not managed by the sync
+		&rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Namespace: "p1", Name: "other"}},
+	)
+
+	sc := newSyncConfig()
+	sc.RBACMappings = []*rbacMapping{
+		{KeystoneRole: "member", ClusterRole: "edit"},
+		{KeystoneRole: "admin", ClusterRole: "cluster-admin", ClusterWide: true, Projects: []string{"p1"}},
+	}
+	r := &rbacSyncer{
+		k8sClient: client,
+		syncer:    &Syncer{syncConfig: &sc},
+		listAssignments: func() ([]roles.RoleAssignment, error) {
+			return []roles.RoleAssignment{
+				newRoleAssignment("member", "alice", "p1", "project-1"),
+				// The namespace of the project doesn't exist
+				newRoleAssignment("member", "alice", "p2", "project-2"),
+				newRoleAssignment("admin", "bob", "p1", "project-1"),
+			}, nil
+		},
+	}
+
+	th.AssertNoErr(t, r.sync(ctx))
+
+	rbs, err := client.RbacV1().RoleBindings("").List(ctx, metav1.ListOptions{})
+	th.AssertNoErr(t, err)
+	th.AssertEquals(t, 2, len(rbs.Items))
+	rb, err := client.RbacV1().RoleBindings("p1").Get(ctx, "keystone:member:edit", metav1.GetOptions{})
+	th.AssertNoErr(t, err)
+	th.AssertDeepEquals(t, []string{"alice"}, subjectNames(rb.Subjects))
+	_, err = client.RbacV1().RoleBindings("p1").Get(ctx, "other", metav1.GetOptions{})
+	th.AssertNoErr(t, err)
+
+	crb, err := client.RbacV1().ClusterRoleBindings().Get(ctx, "keystone:admin:cluster-admin", metav1.GetOptions{})
+	th.AssertNoErr(t, err)
+	th.AssertDeepEquals(t, []string{"bob"}, subjectNames(crb.Subjects))
+	th.AssertEquals(t, "cluster-admin", crb.RoleRef.Name)
+
+	// Removing the mappings deletes the managed bindings
+	sc.RBACMappings = nil
+	th.AssertNoErr(t, r.sync(ctx))
+
+	rbs, err = client.RbacV1().RoleBindings("").List(ctx, metav1.ListOptions{})
+	th.AssertNoErr(t, err)
+	th.AssertEquals(t, 1, len(rbs.Items))
+	th.AssertEquals(t, "other", rbs.Items[0].Name)
+	crbs, err := client.RbacV1().ClusterRoleBindings().List(ctx, metav1.ListOptions{})
+	th.AssertNoErr(t, err)
+	th.AssertEquals(t, 0, len(crbs.Items))
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// revocationList is a denylist of Keystone token audit IDs. A token is
+// rejected when any of its audit IDs, i.e. its own or the one of the token
+// chain it belongs to, is in the list. The audit IDs come from a ConfigMap.
+type revocationList struct {
+	mu        sync.RWMutex
+	configMap sets.Set[string]
+}
+
+func newRevocationList() *revocationList {
+	return &revocationList{
+		configMap: sets.New[string](),
+	}
+}
+
+// isRevoked returns true if any of the audit IDs is denylisted.
+func (r *revocationList) isRevoked(auditIDs []string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, id := range auditIDs {
+		if r.configMap.Has(id) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *revocationList) setConfigMapIDs(ids []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.configMap = sets.New[string](ids...)
+}
+
+// parseAuditIDs parses the auditIDs key of the revocation ConfigMap, which
+// holds one audit ID per line. Empty lines and lines starting with '#' are
+// ignored.
+func parseAuditIDs(data string) []string {
+	var ids []string
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ids = append(ids, line)
+	}
+	return ids
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"testing"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+)
+
+func TestParseAuditIDs(t *testing.T) {
+	data := `
+# revoked on 2024-05-01
+hVbsVDd0TUSRPk3jZuHZCg
+
+  4pnw9mHOSDaNHaMcZfm8wQ  
+`
+	th.AssertDeepEquals(t, []string{"hVbsVDd0TUSRPk3jZuHZCg", "4pnw9mHOSDaNHaMcZfm8wQ"}, parseAuditIDs(data))
+	th.AssertEquals(t, 0, len(parseAuditIDs("")))
+}
+
+func TestRevocationList(t *testing.T) {
+	r := newRevocationList()
+	r.setConfigMapIDs([]string{"from-configmap", "chain"})
+
+	th.AssertEquals(t, true, r.isRevoked([]string{"from-configmap"}))
+	th.AssertEquals(t, true, r.isRevoked([]string{"other", "chain"}))
+	th.AssertEquals(t, false, r.isRevoked([]string{"other"}))
+	th.AssertEquals(t, false, r.isRevoked(nil))
+
+	// The ConfigMap content replaces the denylist
+	r.setConfigMapIDs(nil)
+	th.AssertEquals(t, false, r.isRevoked([]string{"from-configmap"}))
+}
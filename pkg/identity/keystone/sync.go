@@ -66,6 +66,9 @@ type syncConfig struct {
 
 	// List of role mappings that will apply to the user info after authentication.
 	RoleMaps []*roleMap `yaml:"role-mappings"`
+
+	// List of mappings of Keystone roles to ClusterRoles, materialized as RoleBindings by the RBAC sync.
+	RBACMappings []*rbacMapping `yaml:"rbac-mappings"`
 }
 
 func (sc *syncConfig) validate() error {
@@ -105,6 +108,12 @@ func (sc *syncConfig) validate() error {
 		}
 	}
 
+	for _, m := range sc.RBACMappings {
+		if err := m.validate(); err != nil {
+			return fmt.Errorf("invalid rbac-mappings: %v", err)
+		}
+	}
+
 	return nil
 }
 
@@ -37,6 +37,12 @@ func TestSyncConfigFromFile(t *testing.T) {
 	th.AssertEquals(t, "myuser", sc.RoleMaps[0].Username)
 	th.AssertEquals(t, 1, len(sc.RoleMaps[0].Groups))
 	th.AssertEquals(t, "mygroup", sc.RoleMaps[0].Groups[0])
+	th.AssertEquals(t, 2, len(sc.RBACMappings))
+	th.AssertEquals(t, "member", sc.RBACMappings[0].KeystoneRole)
+	th.AssertEquals(t, "edit", sc.RBACMappings[0].ClusterRole)
+	th.AssertEquals(t, false, sc.RBACMappings[0].ClusterWide)
+	th.AssertEquals(t, true, sc.RBACMappings[1].ClusterWide)
+	th.AssertEquals(t, "admin", sc.RBACMappings[1].Projects[0])
 }
 
 func TestSyncConfigValidation(t *testing.T) {
@@ -81,6 +87,18 @@ func TestSyncConfigValidation(t *testing.T) {
 		),
 		err.Error(),
 	)
+
+	sc = newSyncConfig()
+
+	// RBAC mappings require the Keystone role and the ClusterRole
+	sc.RBACMappings = []*rbacMapping{{KeystoneRole: "member"}}
+	err = sc.validate()
+	th.AssertEquals(t, "invalid rbac-mappings: keystone-role and cluster-role are required", err.Error())
+
+	// Cluster-wide RBAC mappings require the projects
+	sc.RBACMappings = []*rbacMapping{{KeystoneRole: "admin", ClusterRole: "cluster-admin", ClusterWide: true}}
+	err = sc.validate()
+	th.AssertEquals(t, "invalid rbac-mappings: cluster-wide mapping of Keystone role admin requires the projects of the role assignments", err.Error())
 }
 
 func TestSyncRoles(t *testing.T) {
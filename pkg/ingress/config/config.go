@@ -17,6 +17,8 @@ limitations under the License.
 package config
 
 import (
+	"time"
+
 	"k8s.io/cloud-provider-openstack/pkg/client"
 )
 
@@ -26,6 +28,10 @@ type Config struct {
 	Kubernetes  kubeConfig      `mapstructure:"kubernetes"`
 	OpenStack   client.AuthOpts `mapstructure:"openstack"`
 	Octavia     octaviaConfig   `mapstructure:"octavia"`
+
+	// (Optional) TCP address the Prometheus metrics are served on, e.g. ":9102".
+	// If empty, the metrics are not served.
+	MetricsAddress string `mapstructure:"metrics-address"`
 }
 
 // Configuration for connecting to Kubernetes API server, either api_host or kubeconfig should be configured.
@@ -62,4 +68,18 @@ type octaviaConfig struct {
 	// the load balancer instead of the bulk update API call.
 	// Default is false.
 	ProviderRequiresSerialAPICalls bool `mapstructure:"provider-requires-serial-api-calls"`
+
+	// (Optional) Interval of the resync comparing the Octavia pools and L7 policies of each Ingress against its
+	// spec and repairing the out-of-band changes, e.g. "10m".
+	// Default is 0, the resync is disabled.
+	ResyncPeriod time.Duration `mapstructure:"resync-period"`
+
+	// (Optional) Designate zone to create the A/AAAA records of the Ingress hosts in, e.g. "example.com.".
+	// It can be overridden per Ingress with the octavia.ingress.kubernetes.io/dns-zone annotation.
+	// If empty, no DNS record is managed.
+	DNSZone string `mapstructure:"dns-zone"`
+
+	// (Optional) TTL of the DNS records created for the Ingress hosts.
+	// Default is 0, the zone TTL is used.
+	DNSRecordTTL int `mapstructure:"dns-record-ttl"`
 }
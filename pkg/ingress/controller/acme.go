@@ -0,0 +1,160 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/l7policies"
+	log "github.com/sirupsen/logrus"
+	nwv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"k8s.io/cloud-provider-openstack/pkg/ingress/controller/openstack"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+// A TLS Ingress only has a TERMINATED_HTTPS listener on port 443, so the ACME HTTP-01 challenges, e.g. of
+// cert-manager, which are always sent over HTTP on port 80, can't reach the solvers. With the acme-http01-passthrough
+// annotation, the load balancer also gets an HTTP listener on port 80 whose l7 policies route the Ingress paths under
+// /.well-known/acme-challenge/ to their pools, and redirect the other requests for the TLS hosts to HTTPS.
+
+// acmeChallengePathPrefix is the prefix of the paths of the ACME HTTP-01 challenges.
+const acmeChallengePathPrefix = "/.well-known/acme-challenge/"
+
+// httpListenerName returns the name of the HTTP listener of the TLS Ingress passing the ACME challenges through.
+func httpListenerName(resName string) string {
+	return resName + "-http"
+}
+
+// getACMEHTTP01Passthrough returns whether the ACME HTTP-01 challenges are passed through to the TLS Ingress.
+func getACMEHTTP01Passthrough(ing *nwv1.Ingress) (bool, error) {
+	passthrough, err := strconv.ParseBool(getStringFromIngressAnnotation(ing, IngressAnnotationACMEHTTP01Passthrough, "false"))
+	if err != nil {
+		return false, fmt.Errorf("unknown annotation %s: %v", IngressAnnotationACMEHTTP01Passthrough, err)
+	}
+	return passthrough && len(ing.Spec.TLS) > 0, nil
+}
+
+// hostRuleOpts returns the rule matching the host of the requests sent to the port.
+func hostRuleOpts(host string, port int) l7policies.CreateRuleOpts {
+	return l7policies.CreateRuleOpts{
+		RuleType:    l7policies.TypeHostName,
+		CompareType: l7policies.CompareTypeRegex,
+		Value:       fmt.Sprintf("^%s(:%d)?$", strings.ReplaceAll(host, ".", "\\."), port),
+	}
+}
+
+// acmeChallengePolicy returns the l7 policy of the HTTP listener routing the challenge path to the pool of its
+// solver. It is inserted first so that the challenges aren't redirected to HTTPS.
+func acmeChallengePolicy(host string, path string, poolName string) openstack.IngPolicy {
+	var rules []l7policies.CreateRuleOpts
+	if host != "" {
+		rules = append(rules, hostRuleOpts(host, 80))
+	}
+	rules = append(rules, l7policies.CreateRuleOpts{
+		RuleType:    l7policies.TypePath,
+		CompareType: l7policies.CompareTypeStartWith,
+		Value:       path,
+	})
+
+	return openstack.IngPolicy{
+		RedirectPoolName: poolName,
+		Opts: l7policies.CreateOpts{
+			Action:      l7policies.ActionRedirectToPool,
+			Position:    1,
+			Description: "Created by kubernetes ingress for ACME HTTP-01 challenges",
+		},
+		RulesOpts: rules,
+	}
+}
+
+// httpsRedirectPolicies returns the l7 policies of the HTTP listener redirecting the requests for the TLS hosts to
+// HTTPS. Octavia can only redirect to a fixed prefix, so the wildcard hosts aren't redirected.
+func httpsRedirectPolicies(ing *nwv1.Ingress) []openstack.IngPolicy {
+	var policies []openstack.IngPolicy
+	hosts := sets.New[string]()
+	for _, tls := range ing.Spec.TLS {
+		for _, host := range tls.Hosts {
+			if host == "" || strings.HasPrefix(host, "*") || hosts.Has(host) {
+				continue
+			}
+			hosts.Insert(host)
+
+			policies = append(policies, openstack.IngPolicy{
+				Opts: l7policies.CreateOpts{
+					Action:           l7policies.ActionRedirectPrefix,
+					RedirectPrefix:   "https://" + host,
+					RedirectHttpCode: 301,
+					Description:      "Created by kubernetes ingress",
+				},
+				RulesOpts: []l7policies.CreateRuleOpts{hostRuleOpts(host, 80)},
+			})
+		}
+	}
+	return policies
+}
+
+// ensureHTTPListener ensures the HTTP listener of the TLS Ingress passing the ACME challenges through, and its l7
+// policies, or deletes it once the passthrough is disabled.
+func (c *Controller) ensureHTTPListener(ing *nwv1.Ingress, rt *openstack.ResourceTracker, lbID string, resName string, passthrough bool, acmePolicies []openstack.IngPolicy,
+	listenerAllowedCIDRs []string, timeoutClientData, timeoutMemberData, timeoutTCPInspect, timeoutMemberConnect *int, tags []string) error {
+	name := httpListenerName(resName)
+
+	if !passthrough {
+		listener, err := openstackutil.GetListenerByName(c.osClient.Octavia, name, lbID)
+		if err != nil {
+			if err == cpoerrors.ErrNotFound {
+				return nil
+			}
+			return fmt.Errorf("error getting listener %s: %v", name, err)
+		}
+		log.WithFields(log.Fields{"lbID": lbID, "listenerID": listener.ID}).Info("deleting the listener of the ACME challenges")
+		return openstackutil.DeleteListener(c.osClient.Octavia, listener.ID, lbID)
+	}
+
+	listener, err := c.osClient.EnsureListener(name, lbID, nil, listenerAllowedCIDRs, timeoutClientData, timeoutMemberData, timeoutTCPInspect, timeoutMemberConnect, tags)
+	if err != nil {
+		return err
+	}
+
+	newPolicies := append(acmePolicies, httpsRedirectPolicies(ing)...)
+	for i := range newPolicies {
+		newPolicies[i].Opts.ListenerID = listener.ID
+	}
+
+	existingPolicies, err := openstackutil.GetL7policies(c.osClient.Octavia, listener.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get l7 policies for listener %s", listener.ID)
+	}
+	var oldPolicies []openstack.ExistingPolicy
+	for _, policy := range existingPolicies {
+		rules, err := openstackutil.GetL7Rules(c.osClient.Octavia, policy.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get l7 rules for policy %s", policy.ID)
+		}
+		oldPolicies = append(oldPolicies, openstack.ExistingPolicy{
+			Policy: policy,
+			Rules:  rules,
+		})
+	}
+
+	return rt.EnsureListenerPolicies(listener.ID, newPolicies, oldPolicies)
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/l7policies"
+	"github.com/stretchr/testify/assert"
+	nwv1 "k8s.io/api/networking/v1"
+	apimetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetACMEHTTP01Passthrough(t *testing.T) {
+	annotated := apimetav1.ObjectMeta{Annotations: map[string]string{IngressAnnotationACMEHTTP01Passthrough: "true"}}
+	tls := nwv1.IngressSpec{TLS: []nwv1.IngressTLS{{Hosts: []string{"example.com"}, SecretName: "cert"}}}
+
+	passthrough, err := getACMEHTTP01Passthrough(&nwv1.Ingress{ObjectMeta: annotated, Spec: tls})
+	assert.NoError(t, err)
+	assert.True(t, passthrough)
+
+	// The port 80 listener of a plain HTTP Ingress already serves the challenges
+	passthrough, err = getACMEHTTP01Passthrough(&nwv1.Ingress{ObjectMeta: annotated})
+	assert.NoError(t, err)
+	assert.False(t, passthrough)
+
+	passthrough, err = getACMEHTTP01Passthrough(&nwv1.Ingress{Spec: tls})
+	assert.NoError(t, err)
+	assert.False(t, passthrough)
+
+	invalid := apimetav1.ObjectMeta{Annotations: map[string]string{IngressAnnotationACMEHTTP01Passthrough: "yes please"}}
+	_, err = getACMEHTTP01Passthrough(&nwv1.Ingress{ObjectMeta: invalid, Spec: tls})
+	assert.Error(t, err)
+}
+
+func TestACMEChallengePolicy(t *testing.T) {
+	policy := acmeChallengePolicy("example.com", acmeChallengePathPrefix+"token", "solver")
+
+	assert.Equal(t, "solver", policy.RedirectPoolName)
+	assert.Equal(t, l7policies.ActionRedirectToPool, policy.Opts.Action)
+	assert.Equal(t, int32(1), policy.Opts.Position)
+	assert.Equal(t, []l7policies.CreateRuleOpts{
+		{RuleType: l7policies.TypeHostName, CompareType: l7policies.CompareTypeRegex, Value: "^example\\.com(:80)?$"},
+		{RuleType: l7policies.TypePath, CompareType: l7policies.CompareTypeStartWith, Value: "/.well-known/acme-challenge/token"},
+	}, policy.RulesOpts)
+
+	assert.Len(t, acmeChallengePolicy("", acmeChallengePathPrefix+"token", "solver").RulesOpts, 1)
+}
+
+func TestHTTPSRedirectPolicies(t *testing.T) {
+	ing := &nwv1.Ingress{Spec: nwv1.IngressSpec{TLS: []nwv1.IngressTLS{
+		{Hosts: []string{"example.com", "*.example.com"}, SecretName: "cert"},
+		{Hosts: []string{"www.example.com", "example.com"}, SecretName: "other"},
+	}}}
+
+	policies := httpsRedirectPolicies(ing)
+	if assert.Len(t, policies, 2) {
+		assert.Equal(t, l7policies.ActionRedirectPrefix, policies[0].Opts.Action)
+		assert.Equal(t, "https://example.com", policies[0].Opts.RedirectPrefix)
+		assert.Equal(t, int32(301), policies[0].Opts.RedirectHttpCode)
+		assert.Equal(t, []l7policies.CreateRuleOpts{
+			{RuleType: l7policies.TypeHostName, CompareType: l7policies.CompareTypeRegex, Value: "^example\\.com(:80)?$"},
+		}, policies[0].RulesOpts)
+		assert.Equal(t, "https://www.example.com", policies[1].Opts.RedirectPrefix)
+	}
+}
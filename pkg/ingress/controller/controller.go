@@ -25,12 +25,17 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"net/http"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/l7policies"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/listeners"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/monitors"
 	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/security/groups"
 	log "github.com/sirupsen/logrus"
@@ -51,12 +56,14 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
+	"k8s.io/component-base/metrics/legacyregistry"
 	klog "k8s.io/klog/v2"
 	pkcs12 "software.sslmate.com/src/go-pkcs12"
 
 	"k8s.io/cloud-provider-openstack/pkg/ingress/config"
 	"k8s.io/cloud-provider-openstack/pkg/ingress/controller/openstack"
 	"k8s.io/cloud-provider-openstack/pkg/ingress/utils"
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
 	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
 	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
 )
@@ -77,6 +84,8 @@ const (
 	UpdateEvent EventType = "UPDATE"
 	// DeleteEvent event associated when an object is removed from an informer
 	DeleteEvent EventType = "DELETE"
+	// ResyncEvent event associated with the periodic resync of the Octavia resources
+	ResyncEvent EventType = "RESYNC"
 
 	// IngressKey picks a specific "class" for the Ingress.
 	// The controller only processes Ingresses with this annotation either
@@ -115,9 +124,19 @@ const (
 	// It should be a comma-separated list of CIDRs.
 	IngressAnnotationSourceRangesKey = "octavia.ingress.kubernetes.io/whitelist-source-range"
 
+	// IngressAnnotationLoadBalancerID is the ID of an existing load balancer, created out of the controller, for the
+	// Ingress to use instead of creating its own. The controller only manages the listener, l7 policies and pools of
+	// the Ingress on it, tagged with the Ingress, and never deletes the load balancer or manages its floating IP.
+	IngressAnnotationLoadBalancerID = "octavia.ingress.kubernetes.io/load-balancer-id"
+
 	// IngressControllerTag is added to the related resources.
 	IngressControllerTag = "octavia.ingress.kubernetes.io"
 
+	// LoadBalancerAdoptableByTagPrefix followed by a namespace is the tag allowing the Ingresses of the namespace to
+	// adopt a load balancer with the IngressAnnotationLoadBalancerID annotation. The owner of the load balancer opts in
+	// by setting it, the Ingresses can't take over any load balancer of the project otherwise.
+	LoadBalancerAdoptableByTagPrefix = "octavia.ingress.kubernetes.io/adoptable-by="
+
 	// IngressAnnotationTimeoutClientData is the timeout for frontend client inactivity.
 	// If not set, this value defaults to the Octavia configuration key `timeout_client_data`.
 	// Refer to https://docs.openstack.org/octavia/latest/configuration/configref.html#haproxy_amphora.timeout_client_data
@@ -138,10 +157,60 @@ const (
 	// Refer to https://docs.openstack.org/octavia/latest/configuration/configref.html#haproxy_amphora.timeout_tcp_inspect
 	IngressAnnotationTimeoutTCPInspect = "octavia.ingress.kubernetes.io/timeout-tcp-inspect"
 
+	// IngressAnnotationDNSZone is the Designate zone to create the DNS records of the Ingress hosts in.
+	// It overrides the dns-zone option of the octavia-ingress-controller configuration.
+	IngressAnnotationDNSZone = "octavia.ingress.kubernetes.io/dns-zone"
+
+	// IngressAnnotationACMEHTTP01Passthrough is the annotation used on a TLS Ingress to also listen on port 80, passing
+	// the ACME HTTP-01 challenges, i.e. the paths under /.well-known/acme-challenge/, through to their backends and
+	// redirecting the other requests to HTTPS.
+	// Default to false.
+	IngressAnnotationACMEHTTP01Passthrough = "octavia.ingress.kubernetes.io/acme-http01-passthrough"
+
+	// ServiceAnnotationBackendProtocol is the annotation used on the Service backing an Ingress path to choose the
+	// protocol used by the load balancer towards the pool members. Supported values are HTTP, HTTPS (re-encryption
+	// to the members) and H2 (HTTP/2 over TLS negotiated with ALPN). The protocol of each Service port can be set
+	// with comma-separated <port>=<protocol> entries instead, <port> being the name or the number of the port, e.g.
+	// "http=HTTP,grpc=H2", the ports not listed using HTTP.
+	// Default to HTTP.
+	ServiceAnnotationBackendProtocol = "octavia.ingress.kubernetes.io/backend-protocol"
+
+	// ServiceAnnotationBackendCASecret is the annotation used on the Service backing an Ingress path to specify
+	// the name of a Secret, in the Service namespace, containing the CA certificate bundle (key `ca.crt`) used to
+	// verify the pool members when the backend protocol is HTTPS or H2.
+	// If not set, the certificates of the members are not verified.
+	ServiceAnnotationBackendCASecret = "octavia.ingress.kubernetes.io/backend-ca-secret"
+
+	// ServiceAnnotationHealthMonitorPath is the annotation used on the Service backing an Ingress path to create an
+	// HTTP health monitor, or HTTPS with the HTTPS and H2 backend protocols, requesting this path of the pool members.
+	// If not set, the pool has no health monitor.
+	ServiceAnnotationHealthMonitorPath = "octavia.ingress.kubernetes.io/health-monitor-path"
+
+	// ServiceAnnotationHealthMonitorExpectedCodes is the list of HTTP status codes expected from healthy members,
+	// e.g. "200", "200,202" or "200-204". Default to 200.
+	ServiceAnnotationHealthMonitorExpectedCodes = "octavia.ingress.kubernetes.io/health-monitor-expected-codes"
+
+	// ServiceAnnotationHealthMonitorDelay is the interval in seconds between the health checks. Default to 10.
+	ServiceAnnotationHealthMonitorDelay = "octavia.ingress.kubernetes.io/health-monitor-delay"
+
+	// ServiceAnnotationHealthMonitorTimeout is the time in seconds after which a health check times out, it must
+	// not be greater than the delay. Default to 5.
+	ServiceAnnotationHealthMonitorTimeout = "octavia.ingress.kubernetes.io/health-monitor-timeout"
+
+	// ServiceAnnotationHealthMonitorMaxRetries is the number of successful checks before changing the status of a
+	// member to ONLINE. Default to 3.
+	ServiceAnnotationHealthMonitorMaxRetries = "octavia.ingress.kubernetes.io/health-monitor-max-retries"
+
 	// IngressSecretCertName is certificate key name defined in the secret data.
 	IngressSecretCertName = "tls.crt"
 	// IngressSecretKeyName is private key name defined in the secret data.
 	IngressSecretKeyName = "tls.key"
+	// IngressSecretCAName is CA certificate key name defined in the secret data.
+	IngressSecretCAName = "ca.crt"
+
+	backendProtocolHTTP  = "HTTP"
+	backendProtocolHTTPS = "HTTPS"
+	backendProtocolH2    = "H2"
 
 	// BarbicanSecretNameTemplate is the name format string to create Barbican secret.
 	BarbicanSecretNameTemplate = "kube_ingress_%s_%s_%s_%s"
@@ -340,6 +409,13 @@ func NewController(conf config.Config) *Controller {
 			addIng := obj.(*nwv1.Ingress)
 			key := fmt.Sprintf("%s/%s", addIng.Namespace, addIng.Name)
 
+			// The teardown of an Ingress deleted while the controller was not running is resumed
+			if isTornDown(addIng) {
+				recorder.Event(addIng, apiv1.EventTypeNormal, "Deleting", fmt.Sprintf("Ingress %s", key))
+				controller.queue.AddRateLimited(Event{Obj: addIng, Type: DeleteEvent})
+				return
+			}
+
 			if !IsValid(addIng) {
 				log.Infof("ignore ingress %s", key)
 				return
@@ -356,12 +432,20 @@ func NewController(conf config.Config) *Controller {
 				// Two different versions of the same Ingress will always have different RVs.
 				return
 			}
+			key := fmt.Sprintf("%s/%s", newIng.Namespace, newIng.Name)
+			if newIng.DeletionTimestamp != nil {
+				if isTornDown(newIng) {
+					recorder.Event(newIng, apiv1.EventTypeNormal, "Deleting", fmt.Sprintf("Ingress %s", key))
+					controller.queue.AddRateLimited(Event{Obj: newIng, Type: DeleteEvent})
+				}
+				return
+			}
+
 			newAnnotations := newIng.ObjectMeta.Annotations
 			oldAnnotations := oldIng.ObjectMeta.Annotations
 			delete(newAnnotations, "kubectl.kubernetes.io/last-applied-configuration")
 			delete(oldAnnotations, "kubectl.kubernetes.io/last-applied-configuration")
 
-			key := fmt.Sprintf("%s/%s", newIng.Namespace, newIng.Name)
 			validOld := IsValid(oldIng)
 			validCur := IsValid(newIng)
 			if !validOld && validCur {
@@ -425,6 +509,10 @@ func (c *Controller) Start() {
 	log.Debug("starting Ingress controller")
 	go c.informer.Start(c.stopCh)
 
+	if c.config.MetricsAddress != "" {
+		go c.serveMetrics()
+	}
+
 	// wait for the caches to synchronize before starting the worker
 	if !cache.WaitForCacheSync(c.stopCh, c.ingressListerSynced, c.serviceListerSynced, c.nodeListerSynced) {
 		utilruntime.HandleError(fmt.Errorf("timed out waiting for caches to sync"))
@@ -447,12 +535,29 @@ func (c *Controller) Start() {
 	}
 	c.subnetCIDR = subnet.CIDR
 
+	c.gcDNSRecords()
+
 	go wait.Until(c.runWorker, time.Second, c.stopCh)
 	go wait.Until(c.nodeSyncLoop, 60*time.Second, c.stopCh)
+	if c.config.Octavia.ResyncPeriod > 0 {
+		go wait.Until(c.resyncLoop, c.config.Octavia.ResyncPeriod, c.stopCh)
+	}
 
 	<-c.stopCh
 }
 
+// serveMetrics serves the Prometheus metrics of the OpenStack API calls and of the resync.
+func (c *Controller) serveMetrics() {
+	metrics.RegisterMetrics("octavia-ingress-controller")
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", legacyregistry.Handler())
+	log.WithFields(log.Fields{"address": c.config.MetricsAddress}).Info("serving metrics")
+	if err := http.ListenAndServe(c.config.MetricsAddress, mux); err != nil {
+		log.WithFields(log.Fields{"address": c.config.MetricsAddress, "error": err}).Fatal("failed to serve metrics")
+	}
+}
+
 // nodeSyncLoop handles updating the hosts pointed to by all load
 // balancers whenever the set of nodes in the cluster changes.
 func (c *Controller) nodeSyncLoop() {
@@ -491,7 +596,7 @@ func (c *Controller) nodeSyncLoop() {
 		log.WithFields(log.Fields{"ingress": ing.Name, "namespace": ing.Namespace}).Debug("Starting to handle ingress")
 
 		lbName := utils.GetResourceName(ing.Namespace, ing.Name, c.config.ClusterName)
-		loadbalancer, err := openstackutil.GetLoadbalancerByName(c.osClient.Octavia, lbName)
+		loadbalancer, err := c.getIngressLoadBalancer(&ing)
 		if err != nil {
 			if err != cpoerrors.ErrNotFound {
 				log.WithFields(log.Fields{"name": lbName}).Errorf("Failed to retrieve loadbalancer from OpenStack: %v", err)
@@ -501,7 +606,13 @@ func (c *Controller) nodeSyncLoop() {
 			continue
 		}
 
-		if err = c.osClient.UpdateLoadbalancerMembers(loadbalancer.ID, readyWorkerNodes); err != nil {
+		// Only the pools of the Ingress are updated on an adopted load balancer.
+		var ownerTag string
+		if getAdoptedLoadBalancerID(&ing) != "" {
+			ownerTag = lbName
+		}
+
+		if err = c.osClient.UpdateLoadbalancerMembers(loadbalancer.ID, readyWorkerNodes, ownerTag); err != nil {
 			log.WithFields(log.Fields{"ingress": ing.Name}).Error("Failed to handle ingress")
 			continue
 		}
@@ -514,6 +625,30 @@ func (c *Controller) nodeSyncLoop() {
 	log.Info("Finished to handle node change")
 }
 
+// resyncLoop queues all the valid Ingresses so that the changes made to their Octavia pools and l7 policies out of
+// band are repaired, even if the Ingresses themselves didn't change. It also deletes the stale DNS records.
+func (c *Controller) resyncLoop() {
+	ings, err := c.ingressLister.List(labels.Everything())
+	if err != nil {
+		log.Errorf("Failed to retrieve current set of ingresses: %v", err)
+		return
+	}
+
+	for _, ing := range ings {
+		if isTornDown(ing) {
+			// Retry the teardowns which gave up
+			c.queue.Add(Event{Obj: ing, Type: DeleteEvent})
+			continue
+		}
+		if !IsValid(ing) {
+			continue
+		}
+		c.queue.Add(Event{Obj: ing, Type: ResyncEvent})
+	}
+
+	c.gcDNSRecords()
+}
+
 func (c *Controller) runWorker() {
 	for c.processNextItem() {
 		// continue looping
@@ -554,7 +689,7 @@ func (c *Controller) processItem(event Event) error {
 	case CreateEvent:
 		logger.Info("creating ingress")
 
-		if err := c.ensureIngress(ing); err != nil {
+		if err := c.ensureIngress(ing, false); err != nil {
 			utilruntime.HandleError(fmt.Errorf("failed to create openstack resources for ingress %s: %v", key, err))
 			c.recorder.Event(ing, apiv1.EventTypeWarning, "Failed", fmt.Sprintf("Failed to create openstack resources for ingress %s: %v", key, err))
 		} else {
@@ -563,7 +698,7 @@ func (c *Controller) processItem(event Event) error {
 	case UpdateEvent:
 		logger.Info("updating ingress")
 
-		if err := c.ensureIngress(ing); err != nil {
+		if err := c.ensureIngress(ing, false); err != nil {
 			utilruntime.HandleError(fmt.Errorf("failed to update openstack resources for ingress %s: %v", key, err))
 			c.recorder.Event(ing, apiv1.EventTypeWarning, "Failed", fmt.Sprintf("Failed to update openstack resources for ingress %s: %v", key, err))
 		} else {
@@ -573,10 +708,31 @@ func (c *Controller) processItem(event Event) error {
 		logger.Info("deleting ingress")
 
 		if err := c.deleteIngress(ing); err != nil {
-			utilruntime.HandleError(fmt.Errorf("failed to delete openstack resources for ingress %s: %v", key, err))
 			c.recorder.Event(ing, apiv1.EventTypeWarning, "Failed", fmt.Sprintf("Failed to delete openstack resources for ingress %s: %v", key, err))
-		} else {
-			c.recorder.Event(ing, apiv1.EventTypeNormal, "Deleted", fmt.Sprintf("Ingress %s", key))
+			// The deletion is retried as long as the finalizer holds the Ingress
+			if hasFinalizer(ing) {
+				return fmt.Errorf("failed to delete openstack resources for ingress %s: %v", key, err)
+			}
+			utilruntime.HandleError(fmt.Errorf("failed to delete openstack resources for ingress %s: %v", key, err))
+			return nil
+		}
+
+		if err := c.removeFinalizer(ing); err != nil {
+			return fmt.Errorf("failed to remove finalizer of ingress %s: %v", key, err)
+		}
+		c.recorder.Event(ing, apiv1.EventTypeNormal, "Deleted", fmt.Sprintf("Ingress %s", key))
+	case ResyncEvent:
+		// The Ingress may have been changed or deleted since it was queued.
+		cur, err := c.ingressLister.Ingresses(ing.Namespace).Get(ing.Name)
+		if err != nil || !IsValid(cur) {
+			return nil
+		}
+
+		logger.Debug("resyncing ingress")
+
+		if err := c.ensureIngress(cur, true); err != nil {
+			utilruntime.HandleError(fmt.Errorf("failed to resync openstack resources for ingress %s: %v", key, err))
+			c.recorder.Event(cur, apiv1.EventTypeWarning, "Failed", fmt.Sprintf("Failed to resync openstack resources for ingress %s: %v", key, err))
 		}
 	}
 
@@ -588,8 +744,17 @@ func (c *Controller) deleteIngress(ing *nwv1.Ingress) error {
 	lbName := utils.GetResourceName(ing.Namespace, ing.Name, c.config.ClusterName)
 	logger := log.WithFields(log.Fields{"ingress": key})
 
+	// An unavailable DNS service must not block the deletion of the Ingress, the records left behind are collected
+	// by gcDNSRecords.
+	if err := c.deleteIngressDNSRecords(ing); err != nil {
+		logger.WithFields(log.Fields{"error": err}).Warn("failed to delete DNS records, continuing with the deletion of the ingress")
+		c.recorder.Event(ing, apiv1.EventTypeWarning, "DNSRecordDeletionFailed", fmt.Sprintf("Failed to delete DNS records for ingress %s: %v", key, err))
+	}
+
+	adopted := getAdoptedLoadBalancerID(ing) != ""
+
 	// If load balancer doesn't exist, assume it's already deleted.
-	loadbalancer, err := openstackutil.GetLoadbalancerByName(c.osClient.Octavia, lbName)
+	loadbalancer, err := c.getIngressLoadBalancer(ing)
 	if err != nil {
 		if err != cpoerrors.ErrNotFound {
 			return fmt.Errorf("error getting loadbalancer %s: %v", ing.Name, err)
@@ -606,18 +771,6 @@ func (c *Controller) deleteIngress(ing *nwv1.Ingress) error {
 		return fmt.Errorf("unknown annotation %s: %v", IngressAnnotationLoadBalancerKeepFloatingIP, err)
 	}
 
-	if !keepFloating {
-		// Delete the floating IP for the load balancer VIP. We don't check if the Ingress is internal or not, just delete
-		// any floating IPs associated with the load balancer VIP port.
-		logger.WithFields(log.Fields{"lbID": loadbalancer.ID, "VIP": loadbalancer.VipAddress}).Info("deleting floating IPs associated with the load balancer VIP port")
-
-		if _, err = c.osClient.EnsureFloatingIP(true, loadbalancer.VipPortID, "", "", ""); err != nil {
-			return fmt.Errorf("failed to delete floating IP: %v", err)
-		}
-
-		logger.WithFields(log.Fields{"lbID": loadbalancer.ID}).Info("VIP or floating IP deleted")
-	}
-
 	// Delete security group managed for the Ingress backend service
 	if c.config.Octavia.ManageSecurityGroups {
 		sgTags := []string{IngressControllerTag, fmt.Sprintf("%s_%s", ing.Namespace, ing.Name)}
@@ -645,15 +798,19 @@ func (c *Controller) deleteIngress(ing *nwv1.Ingress) error {
 		logger.WithFields(log.Fields{"lbID": loadbalancer.ID}).Info("security group deleted")
 	}
 
-	err = openstackutil.DeleteLoadbalancer(c.osClient.Octavia, loadbalancer.ID, true)
-	if err != nil {
-		logger.WithFields(log.Fields{"lbID": loadbalancer.ID}).Infof("loadbalancer delete failed: %v", err)
-	} else {
-		logger.WithFields(log.Fields{"lbID": loadbalancer.ID}).Info("loadbalancer deleted")
+	if adopted {
+		// The adopted load balancer is not ours, only release the resources of the Ingress.
+		if err := c.osClient.ReleaseLoadBalancer(loadbalancer.ID, []string{lbName, httpListenerName(lbName)}, lbName); err != nil {
+			return fmt.Errorf("failed to release loadbalancer %s: %v", loadbalancer.ID, err)
+		}
+
+		logger.WithFields(log.Fields{"lbID": loadbalancer.ID}).Info("loadbalancer released")
+	} else if err := c.teardownLoadBalancer(ing, loadbalancer, keepFloating); err != nil {
+		return fmt.Errorf("failed to delete loadbalancer %s: %v", loadbalancer.ID, err)
 	}
 
-	// Delete Barbican secrets
-	if c.osClient.Barbican != nil && ing.Spec.TLS != nil {
+	// Delete Barbican secrets, including the CA certificates of the HTTPS backends
+	if c.osClient.Barbican != nil {
 		nameFilter := fmt.Sprintf("kube_ingress_%s_%s_%s", c.config.ClusterName, ing.Namespace, ing.Name)
 		if err := openstackutil.DeleteSecrets(c.osClient.Barbican, nameFilter); err != nil {
 			return fmt.Errorf("failed to remove Barbican secrets: %v", err)
@@ -662,7 +819,7 @@ func (c *Controller) deleteIngress(ing *nwv1.Ingress) error {
 		logger.Info("Barbican secrets deleted")
 	}
 
-	return err
+	return nil
 }
 
 func (c *Controller) toBarbicanSecret(name string, namespace string, toSecretName string) (string, error) {
@@ -707,7 +864,202 @@ func (c *Controller) toBarbicanSecret(name string, namespace string, toSecretNam
 	return openstackutil.EnsureSecret(c.osClient.Barbican, toSecretName, "application/octet-stream", encoded)
 }
 
-func (c *Controller) ensureIngress(ing *nwv1.Ingress) error {
+// toBarbicanCASecret stores the CA certificate bundle of the given secret in Barbican.
+func (c *Controller) toBarbicanCASecret(name string, namespace string, toSecretName string) (string, error) {
+	secret, err := c.kubeClient.CoreV1().Secrets(namespace).Get(context.TODO(), name, apimetav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	caBytes, isPresent := secret.Data[IngressSecretCAName]
+	if !isPresent {
+		return "", fmt.Errorf("%s key doesn't exist in the secret %s", IngressSecretCAName, name)
+	}
+	if _, err := parsePEMBundle(caBytes); err != nil {
+		return "", fmt.Errorf("invalid CA certificate in the secret %s: %v", name, err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(caBytes)
+
+	return openstackutil.EnsureSecret(c.osClient.Barbican, toSecretName, "application/octet-stream", encoded)
+}
+
+// getBackendPoolOpts returns the pool create options for the given backend Service according to its backend
+// protocol annotations, along with a key to add to the pool name so that a change of those annotations results in
+// a new pool.
+func (c *Controller) getBackendPoolOpts(ing *nwv1.Ingress, serviceName string, serviceBackend *nwv1.IngressServiceBackend, opts pools.CreateOpts) (pools.CreateOptsBuilder, string, error) {
+	svc, err := c.getService(serviceName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	protocol, err := getBackendProtocol(svc, serviceBackend.Port)
+	if err != nil {
+		return nil, "", err
+	}
+	caSecretName := getStringFromServiceAnnotation(svc, ServiceAnnotationBackendCASecret, "")
+
+	switch protocol {
+	case backendProtocolHTTP:
+		// The CA secret applies to the HTTPS and H2 ports of a Service with protocols per port
+		if caSecretName != "" && !strings.Contains(svc.Annotations[ServiceAnnotationBackendProtocol], "=") {
+			return nil, "", fmt.Errorf("annotation %s of service %s requires %s to be %s or %s", ServiceAnnotationBackendCASecret, serviceName, ServiceAnnotationBackendProtocol, backendProtocolHTTPS, backendProtocolH2)
+		}
+		return opts, "", nil
+	case backendProtocolHTTPS, backendProtocolH2:
+	default:
+		return nil, "", fmt.Errorf("unknown annotation %s of service %s: %s", ServiceAnnotationBackendProtocol, serviceName, protocol)
+	}
+
+	tlsOpts := openstack.TLSPoolCreateOpts{
+		CreateOpts: opts,
+		TLSEnabled: true,
+	}
+	if protocol == backendProtocolH2 {
+		tlsOpts.ALPNProtocols = []string{"h2", "http/1.1"}
+	}
+
+	if caSecretName != "" {
+		if c.osClient.Barbican == nil {
+			return nil, "", fmt.Errorf("annotation %s not supported because of Key Manager service unavailable", ServiceAnnotationBackendCASecret)
+		}
+		secretName := fmt.Sprintf(BarbicanSecretNameTemplate, c.config.ClusterName, ing.Namespace, ing.Name, "ca_"+caSecretName)
+		secretRef, err := c.toBarbicanCASecret(caSecretName, svc.Namespace, secretName)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create Barbican secret for the CA of service %s: %v", serviceName, err)
+		}
+		tlsOpts.CATLSContainerRef = secretRef
+	}
+
+	return tlsOpts, fmt.Sprintf("+%s+%s", protocol, caSecretName), nil
+}
+
+// getBackendProtocol returns the backend protocol of the Service port, set for the whole Service or per port by the
+// backend-protocol annotation.
+func getBackendProtocol(svc *apiv1.Service, port nwv1.ServiceBackendPort) (string, error) {
+	value := getStringFromServiceAnnotation(svc, ServiceAnnotationBackendProtocol, backendProtocolHTTP)
+	if !strings.Contains(value, "=") {
+		return strings.ToUpper(value), nil
+	}
+
+	// The entries may refer to the port by name or number, whichever the Ingress uses
+	var keys []string
+	for _, p := range svc.Spec.Ports {
+		if (port.Name != "" && p.Name == port.Name) || (port.Name == "" && p.Port == port.Number) {
+			keys = []string{p.Name, strconv.Itoa(int(p.Port))}
+			break
+		}
+	}
+
+	protocol := backendProtocolHTTP
+	for _, entry := range strings.Split(value, ",") {
+		key, proto, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || key == "" {
+			return "", fmt.Errorf("invalid annotation %s of service %s/%s: %s", ServiceAnnotationBackendProtocol, svc.Namespace, svc.Name, value)
+		}
+		if slices.Contains(keys, key) {
+			protocol = strings.ToUpper(proto)
+		}
+	}
+
+	return protocol, nil
+}
+
+// getBackendMonitorOpts returns the options of the health monitor of the pool of the backend Service, nil if the
+// pool has no health monitor.
+func (c *Controller) getBackendMonitorOpts(serviceName string, poolName string, poolOpts pools.CreateOptsBuilder) (*monitors.CreateOpts, error) {
+	svc, err := c.getService(serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	path := getStringFromServiceAnnotation(svc, ServiceAnnotationHealthMonitorPath, "")
+	if path == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("annotation %s of service %s must be an absolute path: %s", ServiceAnnotationHealthMonitorPath, serviceName, path)
+	}
+
+	opts := &monitors.CreateOpts{
+		Name:          poolName,
+		Type:          monitors.TypeHTTP,
+		URLPath:       path,
+		HTTPMethod:    "GET",
+		ExpectedCodes: getStringFromServiceAnnotation(svc, ServiceAnnotationHealthMonitorExpectedCodes, "200"),
+	}
+	if _, ok := poolOpts.(openstack.TLSPoolCreateOpts); ok {
+		opts.Type = monitors.TypeHTTPS
+	}
+
+	for _, a := range []struct {
+		annotation string
+		value      *int
+		def        int
+	}{
+		{ServiceAnnotationHealthMonitorDelay, &opts.Delay, 10},
+		{ServiceAnnotationHealthMonitorTimeout, &opts.Timeout, 5},
+		{ServiceAnnotationHealthMonitorMaxRetries, &opts.MaxRetries, 3},
+	} {
+		*a.value = a.def
+		if v, ok := svc.Annotations[a.annotation]; ok {
+			if *a.value, err = strconv.Atoi(v); err != nil || *a.value <= 0 {
+				return nil, fmt.Errorf("annotation %s of service %s must be a positive integer: %s", a.annotation, serviceName, v)
+			}
+		}
+	}
+	if opts.Timeout > opts.Delay {
+		return nil, fmt.Errorf("annotation %s of service %s must not be greater than the delay %d", ServiceAnnotationHealthMonitorTimeout, serviceName, opts.Delay)
+	}
+
+	return opts, nil
+}
+
+// withPoolName sets the name of the pool in the given create options.
+func withPoolName(opts pools.CreateOptsBuilder, name string, tags []string) pools.CreateOptsBuilder {
+	switch o := opts.(type) {
+	case pools.CreateOpts:
+		o.Name = name
+		o.Tags = tags
+		return o
+	case openstack.TLSPoolCreateOpts:
+		o.Name = name
+		o.Tags = tags
+		return o
+	}
+	return opts
+}
+
+// getAdoptedLoadBalancerID returns the ID of the existing load balancer used by the Ingress, if any.
+func getAdoptedLoadBalancerID(ing *nwv1.Ingress) string {
+	return getStringFromIngressAnnotation(ing, IngressAnnotationLoadBalancerID, "")
+}
+
+// isAdoptableBy returns true if the owner of the load balancer allows the Ingresses of the namespace to adopt it.
+func isAdoptableBy(lb *loadbalancers.LoadBalancer, namespace string) bool {
+	return slices.Contains(lb.Tags, LoadBalancerAdoptableByTagPrefix+namespace)
+}
+
+// getIngressLoadBalancer gets the load balancer of the Ingress, adopted or created by the controller. ErrNotFound is
+// returned if it doesn't exist.
+func (c *Controller) getIngressLoadBalancer(ing *nwv1.Ingress) (*loadbalancers.LoadBalancer, error) {
+	lbID := getAdoptedLoadBalancerID(ing)
+	if lbID == "" {
+		return openstackutil.GetLoadbalancerByName(c.osClient.Octavia, utils.GetResourceName(ing.Namespace, ing.Name, c.config.ClusterName))
+	}
+
+	lb, err := openstackutil.GetLoadbalancerByID(c.osClient.Octavia, lbID)
+	if err != nil {
+		if cpoerrors.IsNotFound(err) {
+			return nil, cpoerrors.ErrNotFound
+		}
+		return nil, err
+	}
+	return lb, nil
+}
+
+// ensureIngress creates or updates the openstack resources of the Ingress. Unless resync is set, nothing is done if
+// the load balancer is known to be up to date with the Ingress.
+func (c *Controller) ensureIngress(ing *nwv1.Ingress, resync bool) error {
 	ingName := ing.ObjectMeta.Name
 	ingNamespace := ing.ObjectMeta.Namespace
 	clusterName := c.config.ClusterName
@@ -715,18 +1067,66 @@ func (c *Controller) ensureIngress(ing *nwv1.Ingress) error {
 	ingfullName := fmt.Sprintf("%s/%s", ingNamespace, ingName)
 	resName := utils.GetResourceName(ingNamespace, ingName, clusterName)
 
+	if ing.DeletionTimestamp != nil {
+		// The Ingress is being torn down
+		return nil
+	}
+
 	if len(ing.Spec.TLS) > 0 && c.osClient.Barbican == nil {
 		return fmt.Errorf("TLS Ingress not supported because of Key Manager service unavailable")
 	}
 
-	lb, err := c.osClient.EnsureLoadBalancer(resName, c.config.Octavia.SubnetID, ingNamespace, ingName, clusterName, c.config.Octavia.FlavorID)
+	acmePassthrough, err := getACMEHTTP01Passthrough(ing)
 	if err != nil {
 		return err
 	}
 
+	// The finalizer is added before any OpenStack resource is created
+	if !hasFinalizer(ing) {
+		updated, err := c.addFinalizer(ing)
+		if err != nil {
+			return fmt.Errorf("failed to add finalizer to ingress %s: %v", ingfullName, err)
+		}
+		ing = updated
+	}
+
+	// On an adopted load balancer, the resources of the Ingress are tagged to tell them apart from the others, and the
+	// Ingress version is tracked in the description of its listener instead of the load balancer one.
+	var lb *loadbalancers.LoadBalancer
+	var ownerTags []string
+	var versionDescription string
+	adoptedLBID := getAdoptedLoadBalancerID(ing)
+	if adoptedLBID != "" {
+		lb, err = c.osClient.GetAdoptedLoadBalancer(adoptedLBID)
+		if err != nil {
+			return err
+		}
+		if !isAdoptableBy(lb, ing.Namespace) {
+			msg := fmt.Sprintf("load balancer %s is not tagged with %s%s, it can't be adopted by the Ingresses of the namespace", lb.ID, LoadBalancerAdoptableByTagPrefix, ing.Namespace)
+			c.recorder.Event(ing, apiv1.EventTypeWarning, "LoadBalancerNotAdoptable", msg)
+			return errors.New(msg)
+		}
+		ownerTags = []string{IngressControllerTag, resName}
+
+		listener, err := openstackutil.GetListenerByName(c.osClient.Octavia, resName, lb.ID)
+		if err != nil && err != cpoerrors.ErrNotFound {
+			return fmt.Errorf("error getting listener %s: %v", resName, err)
+		}
+		if listener != nil {
+			versionDescription = listener.Description
+		}
+	} else {
+		lb, err = c.osClient.EnsureLoadBalancer(resName, c.config.Octavia.SubnetID, ingNamespace, ingName, clusterName, c.config.Octavia.FlavorID)
+		if err != nil {
+			return err
+		}
+		versionDescription = lb.Description
+	}
+
 	logger := log.WithFields(log.Fields{"ingress": ingfullName, "lbID": lb.ID})
 
-	if strings.Contains(lb.Description, ing.ResourceVersion) {
+	upToDate := strings.Contains(versionDescription, ing.ResourceVersion)
+	if upToDate && !resync {
 		logger.Info("ingress not changed")
 		return nil
 	}
@@ -773,7 +1173,7 @@ func (c *Controller) ensureIngress(ing *nwv1.Ingress) error {
 	timeoutTCPInspect := maybeGetIntFromIngressAnnotation(ing, IngressAnnotationTimeoutTCPInspect)
 
 	listenerAllowedCIDRs := strings.Split(sourceRanges, ",")
-	listener, err := c.osClient.EnsureListener(resName, lb.ID, secretRefs, listenerAllowedCIDRs, timeoutClientData, timeoutMemberData, timeoutTCPInspect, timeoutMemberConnect)
+	listener, err := c.osClient.EnsureListener(resName, lb.ID, secretRefs, listenerAllowedCIDRs, timeoutClientData, timeoutMemberData, timeoutTCPInspect, timeoutMemberConnect, ownerTags)
 	if err != nil {
 		return err
 	}
@@ -807,6 +1207,7 @@ func (c *Controller) ensureIngress(ing *nwv1.Ingress) error {
 	var newPools []openstack.IngPool
 	var newPolicies []openstack.IngPolicy
 	var oldPolicies []openstack.ExistingPolicy
+	var acmePolicies []openstack.IngPolicy
 
 	existingPolicies, err := openstackutil.GetL7policies(c.osClient.Octavia, listener.ID)
 	if err != nil {
@@ -827,35 +1228,49 @@ func (c *Controller) ensureIngress(ing *nwv1.Ingress) error {
 	if err != nil {
 		return fmt.Errorf("failed to get pools from load balancer %s, error: %v", lb.ID, err)
 	}
+	if adoptedLBID != "" {
+		existingPools = slices.DeleteFunc(existingPools, func(pool pools.Pool) bool {
+			return !slices.Contains(pool.Tags, resName)
+		})
+	}
 
 	// Add default pool for the listener if 'backend' is defined
 	if ing.Spec.DefaultBackend != nil {
-		poolName := utils.Hash(fmt.Sprintf("%s+%s", ing.Spec.DefaultBackend.Service.Name, ing.Spec.DefaultBackend.Service.Port.String()))
-
 		serviceName := fmt.Sprintf("%s/%s", ingNamespace, ing.Spec.DefaultBackend.Service.Name)
 		nodePort, err := c.getServiceNodePort(serviceName, ing.Spec.DefaultBackend.Service)
 		if err != nil {
 			return err
 		}
+
+		// This pool is the default pool of the listener.
+		poolOpts, poolKey, err := c.getBackendPoolOpts(ing, serviceName, ing.Spec.DefaultBackend.Service, pools.CreateOpts{
+			Protocol:    "HTTP",
+			LBMethod:    pools.LBMethodRoundRobin,
+			ListenerID:  listener.ID,
+			Persistence: nil,
+		})
+		if err != nil {
+			return err
+		}
+		poolName := utils.Hash(fmt.Sprintf("%s+%s%s", ing.Spec.DefaultBackend.Service.Name, ing.Spec.DefaultBackend.Service.Port.String(), poolKey))
 		nodePorts = append(nodePorts, nodePort)
 
+		monitorOpts, err := c.getBackendMonitorOpts(serviceName, poolName, poolOpts)
+		if err != nil {
+			return err
+		}
+
 		var members = make([]pools.BatchUpdateMemberOpts, len(updateMemberOpts))
 		copy(members, updateMemberOpts)
 		for index := range members {
 			members[index].ProtocolPort = nodePort
 		}
 
-		// This pool is the default pool of the listener.
 		newPools = append(newPools, openstack.IngPool{
-			Name: poolName,
-			Opts: pools.CreateOpts{
-				Name:        poolName,
-				Protocol:    "HTTP",
-				LBMethod:    pools.LBMethodRoundRobin,
-				ListenerID:  listener.ID,
-				Persistence: nil,
-			},
+			Name:        poolName,
+			Opts:        withPoolName(poolOpts, poolName, ownerTags),
 			PoolMembers: members,
+			Monitor:     monitorOpts,
 		})
 	}
 
@@ -868,39 +1283,46 @@ func (c *Controller) ensureIngress(ing *nwv1.Ingress) error {
 			var policyRules []l7policies.CreateRuleOpts
 
 			if host != "" {
-				policyRules = append(policyRules, l7policies.CreateRuleOpts{
-					RuleType:    l7policies.TypeHostName,
-					CompareType: l7policies.CompareTypeRegex,
-					Value:       fmt.Sprintf("^%s(:%d)?$", strings.ReplaceAll(host, ".", "\\."), port)})
+				policyRules = append(policyRules, hostRuleOpts(host, port))
 			}
 
-			// make the pool name unique in the load balancer
-			poolName := utils.Hash(fmt.Sprintf("%s+%s", path.Backend.Service.Name, path.Backend.Service.Port.String()))
-
 			serviceName := fmt.Sprintf("%s/%s", ingNamespace, path.Backend.Service.Name)
 			nodePort, err := c.getServiceNodePort(serviceName, path.Backend.Service)
 			if err != nil {
 				return err
 			}
+
+			// The pool is a shared pool in a load balancer.
+			poolOpts, poolKey, err := c.getBackendPoolOpts(ing, serviceName, path.Backend.Service, pools.CreateOpts{
+				Protocol:       "HTTP",
+				LBMethod:       pools.LBMethodRoundRobin,
+				LoadbalancerID: lb.ID,
+				Persistence:    nil,
+			})
+			if err != nil {
+				return err
+			}
+
+			// make the pool name unique in the load balancer
+			poolName := utils.Hash(fmt.Sprintf("%s+%s%s", path.Backend.Service.Name, path.Backend.Service.Port.String(), poolKey))
 			nodePorts = append(nodePorts, nodePort)
 
+			monitorOpts, err := c.getBackendMonitorOpts(serviceName, poolName, poolOpts)
+			if err != nil {
+				return err
+			}
+
 			var members = make([]pools.BatchUpdateMemberOpts, len(updateMemberOpts))
 			copy(members, updateMemberOpts)
 			for index := range members {
 				members[index].ProtocolPort = nodePort
 			}
 
-			// The pool is a shared pool in a load balancer.
 			newPools = append(newPools, openstack.IngPool{
-				Name: poolName,
-				Opts: pools.CreateOpts{
-					Name:           poolName,
-					Protocol:       "HTTP",
-					LBMethod:       pools.LBMethodRoundRobin,
-					LoadbalancerID: lb.ID,
-					Persistence:    nil,
-				},
+				Name:        poolName,
+				Opts:        withPoolName(poolOpts, poolName, ownerTags),
 				PoolMembers: members,
+				Monitor:     monitorOpts,
 			})
 
 			policyRules = append(policyRules, l7policies.CreateRuleOpts{
@@ -918,6 +1340,10 @@ func (c *Controller) ensureIngress(ing *nwv1.Ingress) error {
 				},
 				RulesOpts: policyRules,
 			})
+
+			if acmePassthrough && strings.HasPrefix(path.Path, acmeChallengePathPrefix) {
+				acmePolicies = append(acmePolicies, acmeChallengePolicy(host, path.Path, poolName))
+			}
 		}
 	}
 
@@ -926,10 +1352,28 @@ func (c *Controller) ensureIngress(ing *nwv1.Ingress) error {
 	if err := rt.CreateResources(); err != nil {
 		return err
 	}
+	if acmePassthrough || !upToDate {
+		if err := c.ensureHTTPListener(ing, rt, lb.ID, resName, acmePassthrough, acmePolicies, listenerAllowedCIDRs, timeoutClientData, timeoutMemberData, timeoutTCPInspect, timeoutMemberConnect, ownerTags); err != nil {
+			return err
+		}
+	}
 	if err := rt.CleanupResources(); err != nil {
 		return err
 	}
 
+	if upToDate {
+		// The Ingress didn't change since the last sync, so any pool or l7 policy created or deleted was repairing
+		// an out-of-band change.
+		poolChanges, policyChanges := rt.Changes()
+		if poolChanges+policyChanges > 0 {
+			metrics.AddIngressDriftRepairs("pool", poolChanges)
+			metrics.AddIngressDriftRepairs("l7policy", policyChanges)
+			logger.WithFields(log.Fields{"pools": poolChanges, "l7policies": policyChanges}).Warn("repaired out-of-band changes of octavia resources")
+			c.recorder.Event(ing, apiv1.EventTypeWarning, "DriftRepaired", fmt.Sprintf("Repaired out-of-band changes of %d pools and %d l7 policies of ingress %s", poolChanges, policyChanges, ingfullName))
+		}
+		return nil
+	}
+
 	if c.config.Octavia.ManageSecurityGroups {
 		logger.WithFields(log.Fields{"sgID": sgID}).Info("ensuring security group rules")
 
@@ -951,8 +1395,17 @@ func (c *Controller) ensureIngress(ing *nwv1.Ingress) error {
 	}
 
 	address := lb.VipAddress
-	// Allocate floating ip for loadbalancer vip if the external network is configured and the Ingress is not internal.
-	if !isInternal && c.config.Octavia.FloatingIPNetwork != "" {
+	if adoptedLBID != "" {
+		// The floating IP of an adopted load balancer is managed by its owner.
+		fip, err := c.osClient.GetPortFloatingIP(lb.VipPortID)
+		if err != nil {
+			return fmt.Errorf("failed to get the floating IP of loadbalancer %s: %v", lb.ID, err)
+		}
+		if fip != "" {
+			address = fip
+		}
+	} else if !isInternal && c.config.Octavia.FloatingIPNetwork != "" {
+		// Allocate floating ip for loadbalancer vip if the external network is configured and the Ingress is not internal.
 
 		floatingIPSetting := getStringFromIngressAnnotation(ing, IngressAnnotationFloatingIP, "")
 		if err != nil {
@@ -980,9 +1433,17 @@ func (c *Controller) ensureIngress(ing *nwv1.Ingress) error {
 	}
 	c.recorder.Event(ing, apiv1.EventTypeNormal, "Updated", fmt.Sprintf("Successfully associated IP address %s to ingress %s", address, ingfullName))
 
-	// Add ingress resource version to the load balancer description
+	if err := c.ensureIngressDNSRecords(ing, address); err != nil {
+		return fmt.Errorf("failed to ensure DNS records for Ingress %s: %v", ingfullName, err)
+	}
+
+	// Add ingress resource version to the load balancer description, or the listener one on an adopted load balancer
 	newDes := fmt.Sprintf("Kubernetes Ingress %s in namespace %s from cluster %s, version: %s", ingName, ingNamespace, clusterName, newIng.ResourceVersion)
-	if err = c.osClient.UpdateLoadBalancerDescription(lb.ID, newDes); err != nil {
+	if adoptedLBID != "" {
+		if err = openstackutil.UpdateListener(c.osClient.Octavia, lb.ID, listener.ID, listeners.UpdateOpts{Description: &newDes}); err != nil {
+			return fmt.Errorf("failed to update listener description: %v", err)
+		}
+	} else if err = c.osClient.UpdateLoadBalancerDescription(lb.ID, newDes); err != nil {
 		return err
 	}
 
@@ -1070,6 +1531,16 @@ func getStringFromIngressAnnotation(ingress *nwv1.Ingress, annotationKey string,
 	return defaultValue
 }
 
+// getStringFromServiceAnnotation searches a given Service for a specific annotationKey and either returns the
+// annotation's value or a specified defaultSetting
+func getStringFromServiceAnnotation(service *apiv1.Service, annotationKey string, defaultValue string) string {
+	if annotationValue, ok := service.Annotations[annotationKey]; ok {
+		return annotationValue
+	}
+
+	return defaultValue
+}
+
 // maybeGetIntFromIngressAnnotation searches a given Ingress for a specific annotationKey and either returns the
 // annotation's value
 func maybeGetIntFromIngressAnnotation(ingress *nwv1.Ingress, annotationKey string) *int {
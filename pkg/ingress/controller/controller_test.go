@@ -0,0 +1,365 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/monitors"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	nwv1 "k8s.io/api/networking/v1"
+	apimetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	"k8s.io/cloud-provider-openstack/pkg/ingress/controller/openstack"
+)
+
+// newTestController returns a controller whose Service lister holds the given Services
+func newTestController(t *testing.T, services ...*apiv1.Service) *Controller {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, svc := range services {
+		assert.NoError(t, indexer.Add(svc))
+	}
+	return &Controller{
+		serviceLister: corelisters.NewServiceLister(indexer),
+		osClient:      &openstack.OpenStack{},
+	}
+}
+
+func newTestService(annotations map[string]string) *apiv1.Service {
+	return &apiv1.Service{
+		ObjectMeta: apimetav1.ObjectMeta{Name: "backend", Namespace: "default", Annotations: annotations},
+		Spec: apiv1.ServiceSpec{
+			Ports: []apiv1.ServicePort{
+				{Name: "http", Port: 80, NodePort: 30080},
+				{Name: "https", Port: 443, NodePort: 30443},
+			},
+		},
+	}
+}
+
+func TestGetBackendPoolOpts(t *testing.T) {
+	ing := &nwv1.Ingress{ObjectMeta: apimetav1.ObjectMeta{Name: "ing", Namespace: "default"}}
+	backend := &nwv1.IngressServiceBackend{Name: "backend", Port: nwv1.ServiceBackendPort{Number: 443}}
+	opts := pools.CreateOpts{LBMethod: pools.LBMethodRoundRobin, Protocol: pools.ProtocolHTTP}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		wantOpts    pools.CreateOptsBuilder
+		wantSuffix  string
+		wantErr     bool
+	}{
+		{
+			name:     "HTTP by default",
+			wantOpts: opts,
+		},
+		{
+			name:        "HTTPS",
+			annotations: map[string]string{ServiceAnnotationBackendProtocol: "https"},
+			wantOpts:    openstack.TLSPoolCreateOpts{CreateOpts: opts, TLSEnabled: true},
+			wantSuffix:  "+HTTPS+",
+		},
+		{
+			name:        "HTTP/2",
+			annotations: map[string]string{ServiceAnnotationBackendProtocol: "H2"},
+			wantOpts:    openstack.TLSPoolCreateOpts{CreateOpts: opts, TLSEnabled: true, ALPNProtocols: []string{"h2", "http/1.1"}},
+			wantSuffix:  "+H2+",
+		},
+		{
+			name:        "unknown protocol",
+			annotations: map[string]string{ServiceAnnotationBackendProtocol: "grpc"},
+			wantErr:     true,
+		},
+		{
+			name:        "CA secret of an HTTP backend",
+			annotations: map[string]string{ServiceAnnotationBackendCASecret: "ca"},
+			wantErr:     true,
+		},
+		{
+			name: "CA secret without Key Manager service",
+			annotations: map[string]string{
+				ServiceAnnotationBackendProtocol: "HTTPS",
+				ServiceAnnotationBackendCASecret: "ca",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestController(t, newTestService(test.annotations))
+
+			poolOpts, suffix, err := c.getBackendPoolOpts(ing, "default/backend", backend, opts)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.wantOpts, poolOpts)
+			assert.Equal(t, test.wantSuffix, suffix)
+		})
+	}
+
+	t.Run("missing service", func(t *testing.T) {
+		c := newTestController(t)
+		_, _, err := c.getBackendPoolOpts(ing, "default/backend", backend, opts)
+		assert.Error(t, err)
+	})
+}
+
+func TestGetBackendProtocol(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation string
+		port       nwv1.ServiceBackendPort
+		want       string
+		wantErr    bool
+	}{
+		{
+			name: "HTTP by default",
+			port: nwv1.ServiceBackendPort{Number: 443},
+			want: backendProtocolHTTP,
+		},
+		{
+			name:       "whole service",
+			annotation: "https",
+			port:       nwv1.ServiceBackendPort{Number: 80},
+			want:       backendProtocolHTTPS,
+		},
+		{
+			name:       "port by number",
+			annotation: "443=HTTPS",
+			port:       nwv1.ServiceBackendPort{Number: 443},
+			want:       backendProtocolHTTPS,
+		},
+		{
+			name:       "port by name",
+			annotation: "https=h2",
+			port:       nwv1.ServiceBackendPort{Name: "https"},
+			want:       backendProtocolH2,
+		},
+		{
+			name:       "entry by name for a port by number",
+			annotation: "https=HTTPS",
+			port:       nwv1.ServiceBackendPort{Number: 443},
+			want:       backendProtocolHTTPS,
+		},
+		{
+			name:       "entry by number for a port by name",
+			annotation: "80=HTTP, 443=H2",
+			port:       nwv1.ServiceBackendPort{Name: "https"},
+			want:       backendProtocolH2,
+		},
+		{
+			name:       "port without entry",
+			annotation: "443=HTTPS",
+			port:       nwv1.ServiceBackendPort{Name: "http"},
+			want:       backendProtocolHTTP,
+		},
+		{
+			name:       "unknown port",
+			annotation: "443=HTTPS",
+			port:       nwv1.ServiceBackendPort{Number: 8443},
+			want:       backendProtocolHTTP,
+		},
+		{
+			name:       "entry without port",
+			annotation: "443=HTTPS,=H2",
+			port:       nwv1.ServiceBackendPort{Number: 443},
+			wantErr:    true,
+		},
+		{
+			name:       "entry without protocol",
+			annotation: "443=HTTPS,80",
+			port:       nwv1.ServiceBackendPort{Number: 443},
+			wantErr:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var annotations map[string]string
+			if test.annotation != "" {
+				annotations = map[string]string{ServiceAnnotationBackendProtocol: test.annotation}
+			}
+
+			protocol, err := getBackendProtocol(newTestService(annotations), test.port)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, protocol)
+		})
+	}
+}
+
+func TestGetBackendMonitorOpts(t *testing.T) {
+	httpOpts := pools.CreateOpts{LBMethod: pools.LBMethodRoundRobin, Protocol: pools.ProtocolHTTP}
+	tlsOpts := openstack.TLSPoolCreateOpts{CreateOpts: httpOpts, TLSEnabled: true}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		poolOpts    pools.CreateOptsBuilder
+		want        *monitors.CreateOpts
+		wantErr     bool
+	}{
+		{
+			name:     "no health monitor",
+			poolOpts: httpOpts,
+		},
+		{
+			name:        "HTTP with the defaults",
+			annotations: map[string]string{ServiceAnnotationHealthMonitorPath: "/healthz"},
+			poolOpts:    httpOpts,
+			want: &monitors.CreateOpts{
+				Name: "pool", Type: monitors.TypeHTTP, URLPath: "/healthz", HTTPMethod: "GET", ExpectedCodes: "200",
+				Delay: 10, Timeout: 5, MaxRetries: 3,
+			},
+		},
+		{
+			name: "HTTPS with all the annotations",
+			annotations: map[string]string{
+				ServiceAnnotationHealthMonitorPath:          "/ready",
+				ServiceAnnotationHealthMonitorExpectedCodes: "200-204",
+				ServiceAnnotationHealthMonitorDelay:         "20",
+				ServiceAnnotationHealthMonitorTimeout:       "20",
+				ServiceAnnotationHealthMonitorMaxRetries:    "5",
+			},
+			poolOpts: tlsOpts,
+			want: &monitors.CreateOpts{
+				Name: "pool", Type: monitors.TypeHTTPS, URLPath: "/ready", HTTPMethod: "GET", ExpectedCodes: "200-204",
+				Delay: 20, Timeout: 20, MaxRetries: 5,
+			},
+		},
+		{
+			name:        "relative path",
+			annotations: map[string]string{ServiceAnnotationHealthMonitorPath: "healthz"},
+			poolOpts:    httpOpts,
+			wantErr:     true,
+		},
+		{
+			name: "invalid delay",
+			annotations: map[string]string{
+				ServiceAnnotationHealthMonitorPath:  "/healthz",
+				ServiceAnnotationHealthMonitorDelay: "ten",
+			},
+			poolOpts: httpOpts,
+			wantErr:  true,
+		},
+		{
+			name: "non positive max retries",
+			annotations: map[string]string{
+				ServiceAnnotationHealthMonitorPath:       "/healthz",
+				ServiceAnnotationHealthMonitorMaxRetries: "0",
+			},
+			poolOpts: httpOpts,
+			wantErr:  true,
+		},
+		{
+			name: "timeout greater than the delay",
+			annotations: map[string]string{
+				ServiceAnnotationHealthMonitorPath:    "/healthz",
+				ServiceAnnotationHealthMonitorTimeout: "11",
+			},
+			poolOpts: httpOpts,
+			wantErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestController(t, newTestService(test.annotations))
+
+			opts, err := c.getBackendMonitorOpts("default/backend", "pool", test.poolOpts)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, opts)
+		})
+	}
+}
+
+func TestWithPoolName(t *testing.T) {
+	tags := []string{"tag"}
+
+	plain := withPoolName(pools.CreateOpts{Protocol: pools.ProtocolHTTP}, "pool", tags)
+	assert.Equal(t, pools.CreateOpts{Protocol: pools.ProtocolHTTP, Name: "pool", Tags: tags}, plain)
+
+	tls := withPoolName(openstack.TLSPoolCreateOpts{CreateOpts: pools.CreateOpts{Protocol: pools.ProtocolHTTP}, TLSEnabled: true}, "pool", tags)
+	assert.Equal(t, openstack.TLSPoolCreateOpts{CreateOpts: pools.CreateOpts{Protocol: pools.ProtocolHTTP, Name: "pool", Tags: tags}, TLSEnabled: true}, tls)
+}
+
+func TestIsAdoptableBy(t *testing.T) {
+	lb := &loadbalancers.LoadBalancer{Tags: []string{"team-a", LoadBalancerAdoptableByTagPrefix + "default"}}
+
+	assert.True(t, isAdoptableBy(lb, "default"))
+	assert.False(t, isAdoptableBy(lb, "other"))
+	assert.False(t, isAdoptableBy(&loadbalancers.LoadBalancer{}, "default"))
+}
+
+func TestEnsureIngressLoadBalancerNotAdoptable(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.Method != http.MethodGet || r.URL.Path != "/lbaas/loadbalancers/lb-1" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"loadbalancer": {"id": "lb-1", "provisioning_status": "ACTIVE", "tags": ["%sother"]}}`, LoadBalancerAdoptableByTagPrefix)
+	}))
+	defer server.Close()
+
+	ing := &nwv1.Ingress{ObjectMeta: apimetav1.ObjectMeta{
+		Name:        "ing",
+		Namespace:   "default",
+		Annotations: map[string]string{IngressAnnotationLoadBalancerID: "lb-1"},
+	}}
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{
+		osClient: &openstack.OpenStack{
+			Octavia: &gophercloud.ServiceClient{
+				ProviderClient: &gophercloud.ProviderClient{HTTPClient: http.Client{Transport: http.DefaultTransport}},
+				Endpoint:       server.URL + "/",
+			},
+		},
+		kubeClient: fake.NewSimpleClientset(ing),
+		recorder:   recorder,
+	}
+
+	err := c.ensureIngress(ing, false)
+	assert.ErrorContains(t, err, "can't be adopted")
+	assert.Contains(t, <-recorder.Events, "LoadBalancerNotAdoptable")
+	for _, r := range requests {
+		assert.Equal(t, "GET /lbaas/loadbalancers/lb-1", r, "the load balancer must not be modified")
+	}
+}
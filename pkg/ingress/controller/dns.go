@@ -0,0 +1,317 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/dns/v2/recordsets"
+	"github.com/gophercloud/gophercloud/openstack/dns/v2/zones"
+	log "github.com/sirupsen/logrus"
+	apiv1 "k8s.io/api/core/v1"
+	nwv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	netutils "k8s.io/utils/net"
+
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+// Every DNS name managed for an Ingress has a TXT recordset holding the owner record of the Ingress, next to its
+// A or AAAA recordset. The names without an owner record are never modified, and the owner records allow finding
+// the names to clean up once the hosts or the Ingress are removed.
+
+const dnsOwnerHeritage = "heritage=octavia-ingress-controller"
+
+// dnsOwnerRecord returns the TXT record marking the DNS names managed for the Ingress.
+func dnsOwnerRecord(clusterName, namespace, name string) string {
+	return fmt.Sprintf("%q", fmt.Sprintf("%s,cluster=%s,ingress=%s/%s", dnsOwnerHeritage, clusterName, namespace, name))
+}
+
+// parseDNSOwnerRecord returns the namespace and name of the Ingress owning the TXT record, if it's an owner record
+// of the cluster.
+func parseDNSOwnerRecord(clusterName, record string) (string, string, bool) {
+	prefix := fmt.Sprintf("\"%s,cluster=%s,ingress=", dnsOwnerHeritage, clusterName)
+	key, ok := strings.CutPrefix(record, prefix)
+	if !ok {
+		return "", "", false
+	}
+	namespace, name, ok := strings.Cut(strings.TrimSuffix(key, "\""), "/")
+	return namespace, name, ok
+}
+
+func dnsRecordType(addr string) string {
+	if netutils.IsIPv6String(addr) {
+		return "AAAA"
+	}
+	return "A"
+}
+
+// getDNSZone returns the Designate zone of the Ingress, or nil if the Ingress has no DNS zone.
+func (c *Controller) getDNSZone(zoneName string) (*zones.Zone, error) {
+	if zoneName == "" {
+		return nil, nil
+	}
+	if c.osClient.DNS == nil {
+		return nil, fmt.Errorf("DNS zone %s is set but the OpenStack DNS service is not available", zoneName)
+	}
+
+	zone, err := openstackutil.GetZoneByName(c.osClient.DNS, zoneName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get DNS zone %s: %v", zoneName, err)
+	}
+	return zone, nil
+}
+
+// getOwnedDNSNames returns the TXT recordsets holding the owner record, by DNS name.
+func (c *Controller) getOwnedDNSNames(zoneID, owner string) (map[string]recordsets.RecordSet, error) {
+	txts, err := openstackutil.GetRecordSets(c.osClient.DNS, zoneID, recordsets.ListOpts{Type: "TXT", Data: owner})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list DNS owner records: %v", err)
+	}
+
+	owned := make(map[string]recordsets.RecordSet)
+	for _, rs := range txts {
+		if slices.Contains(rs.Records, owner) {
+			owned[rs.Name] = rs
+		}
+	}
+	return owned, nil
+}
+
+// deleteDNSName deletes the address recordsets of an owned DNS name, then its TXT recordset.
+func (c *Controller) deleteDNSName(zoneID string, txt recordsets.RecordSet) error {
+	existing, err := openstackutil.GetRecordSets(c.osClient.DNS, zoneID, recordsets.ListOpts{Name: txt.Name})
+	if err != nil {
+		return fmt.Errorf("failed to list DNS records named %s: %v", txt.Name, err)
+	}
+	for _, rs := range existing {
+		if rs.Type != "A" && rs.Type != "AAAA" {
+			continue
+		}
+		if err := openstackutil.DeleteRecordSet(c.osClient.DNS, zoneID, rs.ID); err != nil {
+			return err
+		}
+	}
+
+	return openstackutil.DeleteRecordSet(c.osClient.DNS, zoneID, txt.ID)
+}
+
+// ensureDNSAddressRecord makes the owned DNS name resolve to the address.
+func (c *Controller) ensureDNSAddressRecord(zoneID, name, address string) error {
+	existing, err := openstackutil.GetRecordSets(c.osClient.DNS, zoneID, recordsets.ListOpts{Name: name})
+	if err != nil {
+		return fmt.Errorf("failed to list DNS records named %s: %v", name, err)
+	}
+
+	recordType := dnsRecordType(address)
+	found := false
+	for _, rs := range existing {
+		switch {
+		case rs.Type == recordType:
+			found = true
+			if len(rs.Records) != 1 || rs.Records[0] != address {
+				if err := openstackutil.UpdateRecordSetRecords(c.osClient.DNS, zoneID, rs.ID, []string{address}); err != nil {
+					return err
+				}
+			}
+		case rs.Type == "A" || rs.Type == "AAAA":
+			// The address family of the load balancer changed
+			if err := openstackutil.DeleteRecordSet(c.osClient.DNS, zoneID, rs.ID); err != nil {
+				return err
+			}
+		}
+	}
+	if found {
+		return nil
+	}
+
+	_, err = openstackutil.CreateRecordSet(c.osClient.DNS, zoneID, recordsets.CreateOpts{
+		Name:    name,
+		Type:    recordType,
+		Records: []string{address},
+		TTL:     c.config.Octavia.DNSRecordTTL,
+	})
+	return err
+}
+
+// ensureIngressDNSRecords makes the hosts of the Ingress resolve to the address of its load balancer, and deletes
+// the DNS records of the hosts removed from the Ingress.
+func (c *Controller) ensureIngressDNSRecords(ing *nwv1.Ingress, address string) error {
+	zone, err := c.getDNSZone(getStringFromIngressAnnotation(ing, IngressAnnotationDNSZone, c.config.Octavia.DNSZone))
+	if err != nil || zone == nil {
+		return err
+	}
+	logger := log.WithFields(log.Fields{"ingress": fmt.Sprintf("%s/%s", ing.Namespace, ing.Name), "zone": zone.Name})
+
+	owner := dnsOwnerRecord(c.config.ClusterName, ing.Namespace, ing.Name)
+	owned, err := c.getOwnedDNSNames(zone.ID, owner)
+	if err != nil {
+		return err
+	}
+
+	hosts := sets.New[string]()
+	for _, rule := range ing.Spec.Rules {
+		if rule.Host == "" {
+			continue
+		}
+		name := openstackutil.ToFQDN(rule.Host)
+		if !openstackutil.IsNameInZone(name, zone.Name) {
+			logger.WithFields(log.Fields{"host": rule.Host}).Warn("host is not in the DNS zone, skipping DNS record")
+			continue
+		}
+		hosts.Insert(name)
+	}
+
+	for name, txt := range owned {
+		if hosts.Has(name) {
+			continue
+		}
+		logger.WithFields(log.Fields{"name": name}).Info("deleting DNS records")
+		if err := c.deleteDNSName(zone.ID, txt); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range sets.List(hosts) {
+		if _, ok := owned[name]; !ok {
+			// Don't take over a name used by someone else.
+			existing, err := openstackutil.GetRecordSets(c.osClient.DNS, zone.ID, recordsets.ListOpts{Name: name})
+			if err != nil {
+				return fmt.Errorf("failed to list DNS records named %s: %v", name, err)
+			}
+			if len(existing) > 0 {
+				logger.WithFields(log.Fields{"name": name}).Warn("DNS name is already used and not managed for the ingress")
+				c.recorder.Event(ing, apiv1.EventTypeWarning, "DNSRecordConflict", fmt.Sprintf("DNS name %s already exists and is not managed for ingress %s/%s", name, ing.Namespace, ing.Name))
+				continue
+			}
+
+			logger.WithFields(log.Fields{"name": name}).Info("creating DNS owner record")
+			_, err = openstackutil.CreateRecordSet(c.osClient.DNS, zone.ID, recordsets.CreateOpts{
+				Name:    name,
+				Type:    "TXT",
+				Records: []string{owner},
+				TTL:     c.config.Octavia.DNSRecordTTL,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		logger.WithFields(log.Fields{"name": name, "address": address}).Info("ensuring DNS record")
+		if err := c.ensureDNSAddressRecord(zone.ID, name, address); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteIngressDNSRecords deletes the DNS records managed for the Ingress.
+func (c *Controller) deleteIngressDNSRecords(ing *nwv1.Ingress) error {
+	zone, err := c.getDNSZone(getStringFromIngressAnnotation(ing, IngressAnnotationDNSZone, c.config.Octavia.DNSZone))
+	if err != nil || zone == nil {
+		return err
+	}
+
+	owned, err := c.getOwnedDNSNames(zone.ID, dnsOwnerRecord(c.config.ClusterName, ing.Namespace, ing.Name))
+	if err != nil {
+		return err
+	}
+	for name, txt := range owned {
+		log.WithFields(log.Fields{"ingress": fmt.Sprintf("%s/%s", ing.Namespace, ing.Name), "name": name}).Info("deleting DNS records")
+		if err := c.deleteDNSName(zone.ID, txt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// gcDNSRecords deletes the DNS records owned by Ingresses of the cluster that no longer exist, e.g. Ingresses deleted
+// while the controller was not running. As the zone of a deleted Ingress is unknown, all the zones of the project are
+// searched, as soon as a DNS zone is configured or set on an Ingress.
+func (c *Controller) gcDNSRecords() {
+	if !c.dnsEnabled() {
+		return
+	}
+	if c.osClient.DNS == nil {
+		log.Error("Failed to collect the stale DNS records: the OpenStack DNS service is not available")
+		return
+	}
+
+	allZones, err := openstackutil.GetZones(c.osClient.DNS, zones.ListOpts{})
+	if err != nil {
+		log.Errorf("Failed to list the DNS zones: %v", err)
+		return
+	}
+	for _, zone := range allZones {
+		c.gcZoneDNSRecords(zone)
+	}
+}
+
+// dnsEnabled returns true if DNS records are managed for the Ingresses, either by the configured DNS zone or by the
+// DNS zone annotation of an Ingress.
+func (c *Controller) dnsEnabled() bool {
+	if c.config.Octavia.DNSZone != "" {
+		return true
+	}
+
+	ings, err := c.ingressLister.List(labels.Everything())
+	if err != nil {
+		log.Errorf("Failed to retrieve current set of ingresses: %v", err)
+		return false
+	}
+	for _, ing := range ings {
+		if getStringFromIngressAnnotation(ing, IngressAnnotationDNSZone, "") != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// gcZoneDNSRecords deletes the DNS records of the zone owned by Ingresses of the cluster that no longer exist.
+func (c *Controller) gcZoneDNSRecords(zone zones.Zone) {
+	ownerPrefix := fmt.Sprintf("\"%s,cluster=%s,*", dnsOwnerHeritage, c.config.ClusterName)
+	txts, err := openstackutil.GetRecordSets(c.osClient.DNS, zone.ID, recordsets.ListOpts{Type: "TXT", Data: ownerPrefix})
+	if err != nil {
+		log.Errorf("Failed to list the DNS owner records of zone %s: %v", zone.Name, err)
+		return
+	}
+
+	for _, rs := range txts {
+		for _, record := range rs.Records {
+			namespace, name, ok := parseDNSOwnerRecord(c.config.ClusterName, record)
+			if !ok {
+				continue
+			}
+			_, err := c.ingressLister.Ingresses(namespace).Get(name)
+			if err == nil || !apierrors.IsNotFound(err) {
+				continue
+			}
+
+			log.WithFields(log.Fields{"ingress": fmt.Sprintf("%s/%s", namespace, name), "name": rs.Name, "zone": zone.Name}).Info("deleting stale DNS records")
+			if err := c.deleteDNSName(zone.ID, rs); err != nil {
+				log.Errorf("Failed to delete the DNS records named %s: %v", rs.Name, err)
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,282 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/dns/v2/recordsets"
+	"github.com/stretchr/testify/assert"
+	nwv1 "k8s.io/api/networking/v1"
+	apimetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	nwlisters "k8s.io/client-go/listers/networking/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	"k8s.io/cloud-provider-openstack/pkg/ingress/config"
+	"k8s.io/cloud-provider-openstack/pkg/ingress/controller/openstack"
+)
+
+// fakeDesignate serves the zones and recordsets of a Designate fake.
+type fakeDesignate struct {
+	// zones maps the zone names to their IDs
+	zones map[string]string
+	// recordsets maps the zone IDs to their recordsets
+	recordsets map[string][]recordsets.RecordSet
+	lastID     int
+}
+
+// matchData matches the records with the data filter of Designate, where * is a wildcard.
+func matchData(records []string, data string) bool {
+	if data == "" {
+		return true
+	}
+	return slices.ContainsFunc(records, func(r string) bool {
+		if prefix, ok := strings.CutSuffix(data, "*"); ok {
+			return strings.HasPrefix(r, prefix)
+		}
+		return r == data
+	})
+}
+
+func (d *fakeDesignate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodGet && len(path) == 1:
+		zones := []map[string]string{}
+		for name, id := range d.zones {
+			if query.Get("name") == "" || query.Get("name") == name {
+				zones = append(zones, map[string]string{"id": id, "name": name})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"zones": zones})
+	case r.Method == http.MethodGet && len(path) == 3:
+		rss := []recordsets.RecordSet{}
+		for _, rs := range d.recordsets[path[1]] {
+			if (query.Get("name") == "" || rs.Name == query.Get("name")) &&
+				(query.Get("type") == "" || rs.Type == query.Get("type")) &&
+				matchData(rs.Records, query.Get("data")) {
+				rss = append(rss, rs)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"recordsets": rss})
+	case r.Method == http.MethodPost && len(path) == 3:
+		var rs recordsets.RecordSet
+		_ = json.NewDecoder(r.Body).Decode(&rs)
+		d.lastID++
+		rs.ID = fmt.Sprintf("rs-%d", d.lastID)
+		d.recordsets[path[1]] = append(d.recordsets[path[1]], rs)
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(rs)
+	case r.Method == http.MethodPut && len(path) == 4:
+		var update recordsets.RecordSet
+		_ = json.NewDecoder(r.Body).Decode(&update)
+		for i, rs := range d.recordsets[path[1]] {
+			if rs.ID == path[3] {
+				d.recordsets[path[1]][i].Records = update.Records
+				_ = json.NewEncoder(w).Encode(d.recordsets[path[1]][i])
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodDelete && len(path) == 4:
+		var kept []recordsets.RecordSet
+		for _, rs := range d.recordsets[path[1]] {
+			if rs.ID != path[3] {
+				kept = append(kept, rs)
+			}
+		}
+		d.recordsets[path[1]] = kept
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// records returns the records of the zone, by type and name.
+func (d *fakeDesignate) records(zoneID string) map[string][]string {
+	records := make(map[string][]string)
+	for _, rs := range d.recordsets[zoneID] {
+		records[rs.Type+" "+rs.Name] = rs.Records
+	}
+	return records
+}
+
+// newDNSTestController returns a controller using the Designate fake, whose Ingress lister holds the given Ingresses.
+func newDNSTestController(t *testing.T, designate *fakeDesignate, dnsZone string, ingresses ...*nwv1.Ingress) (*Controller, *record.FakeRecorder) {
+	server := httptest.NewServer(designate)
+	t.Cleanup(server.Close)
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, ing := range ingresses {
+		assert.NoError(t, indexer.Add(ing))
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	cfg := config.Config{ClusterName: "cluster-1"}
+	cfg.Octavia.DNSZone = dnsZone
+	c := &Controller{
+		config: cfg,
+		osClient: &openstack.OpenStack{
+			DNS: &gophercloud.ServiceClient{
+				ProviderClient: &gophercloud.ProviderClient{HTTPClient: http.Client{Transport: http.DefaultTransport}},
+				Endpoint:       server.URL + "/",
+			},
+		},
+		recorder:      recorder,
+		ingressLister: nwlisters.NewIngressLister(indexer),
+	}
+	return c, recorder
+}
+
+func newDNSTestIngress(name string, annotations map[string]string, hosts ...string) *nwv1.Ingress {
+	ing := &nwv1.Ingress{ObjectMeta: apimetav1.ObjectMeta{Name: name, Namespace: "default", Annotations: annotations}}
+	for _, host := range hosts {
+		ing.Spec.Rules = append(ing.Spec.Rules, nwv1.IngressRule{Host: host})
+	}
+	return ing
+}
+
+func TestParseDNSOwnerRecord(t *testing.T) {
+	record := dnsOwnerRecord("cluster-1", "default", "web")
+
+	namespace, name, ok := parseDNSOwnerRecord("cluster-1", record)
+	assert.True(t, ok)
+	assert.Equal(t, "default", namespace)
+	assert.Equal(t, "web", name)
+
+	_, _, ok = parseDNSOwnerRecord("cluster-2", record)
+	assert.False(t, ok)
+	_, _, ok = parseDNSOwnerRecord("cluster-1", `"v=spf1 -all"`)
+	assert.False(t, ok)
+}
+
+func TestEnsureIngressDNSRecords(t *testing.T) {
+	designate := &fakeDesignate{
+		zones: map[string]string{"example.com.": "zone-1"},
+		recordsets: map[string][]recordsets.RecordSet{
+			"zone-1": {{ID: "foreign", Name: "taken.example.com.", Type: "A", Records: []string{"198.51.100.1"}}},
+		},
+	}
+	c, recorder := newDNSTestController(t, designate, "example.com")
+	owner := dnsOwnerRecord("cluster-1", "default", "web")
+
+	ing := newDNSTestIngress("web", nil, "a.example.com", "b.example.com", "taken.example.com", "other.org", "")
+	assert.NoError(t, c.ensureIngressDNSRecords(ing, "192.0.2.10"))
+	assert.Equal(t, map[string][]string{
+		"A taken.example.com.": {"198.51.100.1"},
+		"TXT a.example.com.":   {owner},
+		"A a.example.com.":     {"192.0.2.10"},
+		"TXT b.example.com.":   {owner},
+		"A b.example.com.":     {"192.0.2.10"},
+	}, designate.records("zone-1"))
+	assert.Contains(t, <-recorder.Events, "DNSRecordConflict")
+
+	// The records of the removed hosts are deleted, the others follow the address of the load balancer
+	ing = newDNSTestIngress("web", nil, "a.example.com")
+	assert.NoError(t, c.ensureIngressDNSRecords(ing, "2001:db8::10"))
+	assert.Equal(t, map[string][]string{
+		"A taken.example.com.": {"198.51.100.1"},
+		"TXT a.example.com.":   {owner},
+		"AAAA a.example.com.":  {"2001:db8::10"},
+	}, designate.records("zone-1"))
+
+	assert.NoError(t, c.deleteIngressDNSRecords(ing))
+	assert.Equal(t, map[string][]string{
+		"A taken.example.com.": {"198.51.100.1"},
+	}, designate.records("zone-1"))
+}
+
+func TestGCDNSRecords(t *testing.T) {
+	owner := func(cluster, name string) []string {
+		return []string{dnsOwnerRecord(cluster, "default", name)}
+	}
+	designate := &fakeDesignate{
+		zones: map[string]string{"example.com.": "zone-1", "example.org.": "zone-2"},
+		recordsets: map[string][]recordsets.RecordSet{
+			"zone-1": {
+				{ID: "1", Name: "web.example.com.", Type: "TXT", Records: owner("cluster-1", "web")},
+				{ID: "2", Name: "web.example.com.", Type: "A", Records: []string{"192.0.2.10"}},
+			},
+			// The zone of a deleted Ingress, set by its annotation
+			"zone-2": {
+				{ID: "3", Name: "gone.example.org.", Type: "TXT", Records: owner("cluster-1", "gone")},
+				{ID: "4", Name: "gone.example.org.", Type: "A", Records: []string{"192.0.2.20"}},
+				{ID: "5", Name: "other.example.org.", Type: "TXT", Records: owner("cluster-2", "gone")},
+				{ID: "6", Name: "other.example.org.", Type: "A", Records: []string{"192.0.2.30"}},
+			},
+		},
+	}
+	web := newDNSTestIngress("web", map[string]string{IngressAnnotationDNSZone: "example.com"}, "web.example.com")
+
+	// Nothing is collected while no DNS zone is used
+	c, _ := newDNSTestController(t, designate, "")
+	c.gcDNSRecords()
+	assert.Len(t, designate.recordsets["zone-2"], 4)
+
+	c, _ = newDNSTestController(t, designate, "", web)
+	c.gcDNSRecords()
+	assert.Len(t, designate.recordsets["zone-1"], 2)
+	assert.Equal(t, map[string][]string{
+		"TXT other.example.org.": owner("cluster-2", "gone"),
+		"A other.example.org.":   {"192.0.2.30"},
+	}, designate.records("zone-2"))
+}
+
+func TestDeleteIngressDNSFailure(t *testing.T) {
+	// Designate is unavailable
+	designate := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(designate.Close)
+	// The load balancer of the Ingress is already deleted
+	octavia := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"loadbalancers": []interface{}{}})
+	}))
+	t.Cleanup(octavia.Close)
+
+	newServiceClient := func(url string) *gophercloud.ServiceClient {
+		return &gophercloud.ServiceClient{
+			ProviderClient: &gophercloud.ProviderClient{HTTPClient: http.Client{Transport: http.DefaultTransport}},
+			Endpoint:       url + "/",
+		}
+	}
+	recorder := record.NewFakeRecorder(10)
+	cfg := config.Config{ClusterName: "cluster-1"}
+	cfg.Octavia.DNSZone = "example.com"
+	c := &Controller{
+		config:   cfg,
+		osClient: &openstack.OpenStack{DNS: newServiceClient(designate.URL), Octavia: newServiceClient(octavia.URL)},
+		recorder: recorder,
+	}
+
+	// The DNS failure is reported and the deletion goes on
+	assert.NoError(t, c.deleteIngress(newDNSTestIngress("web", nil, "web.example.com")))
+	assert.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "DNSRecordDeletionFailed")
+}
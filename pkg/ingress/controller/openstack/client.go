@@ -33,6 +33,7 @@ type OpenStack struct {
 	nova     *gophercloud.ServiceClient
 	neutron  *gophercloud.ServiceClient
 	Barbican *gophercloud.ServiceClient
+	DNS      *gophercloud.ServiceClient
 	config   config.Config
 }
 
@@ -77,11 +78,20 @@ func NewOpenStack(cfg config.Config) (*OpenStack, error) {
 		barbican = nil
 	}
 
+	// Get designate service client.
+	var dns *gophercloud.ServiceClient
+	dns, err = openstack.NewDNSV2(provider, epOpts)
+	if err != nil {
+		log.Warn("Designate not supported.")
+		dns = nil
+	}
+
 	os := OpenStack{
 		Octavia:  lb,
 		nova:     compute,
 		neutron:  network,
 		Barbican: barbican,
+		DNS:      dns,
 		config:   cfg,
 	}
 
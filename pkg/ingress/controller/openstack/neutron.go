@@ -97,6 +97,20 @@ func (os *OpenStack) getPorts(listOpts ports.ListOpts) ([]ports.Port, error) {
 	return allPorts, nil
 }
 
+// GetPortFloatingIP returns the address of the floating IP associated with the port, or an empty string if there is
+// none.
+func (os *OpenStack) GetPortFloatingIP(portID string) (string, error) {
+	fips, err := os.getFloatingIPs(floatingips.ListOpts{PortID: portID})
+	if err != nil {
+		return "", fmt.Errorf("unable to get floating ips: %w", err)
+	}
+	if len(fips) == 0 {
+		return "", nil
+	}
+
+	return fips[0].FloatingIP, nil
+}
+
 // EnsureFloatingIP makes sure a floating IP is allocated for the port
 func (os *OpenStack) EnsureFloatingIP(needDelete bool, portID string, existingfloatingIP string, floatingIPNetwork string, description string) (string, error) {
 	listOpts := floatingips.ListOpts{PortID: portID}
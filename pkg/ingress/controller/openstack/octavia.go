@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"slices"
 	"strings"
 	"time"
 
@@ -27,6 +28,7 @@ import (
 	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/l7policies"
 	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/listeners"
 	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/monitors"
 	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
 	log "github.com/sirupsen/logrus"
 	apiv1 "k8s.io/api/core/v1"
@@ -77,6 +79,27 @@ type IngPool struct {
 	Name        string
 	Opts        pools.CreateOptsBuilder
 	PoolMembers []pools.BatchUpdateMemberOpts
+	// Monitor is the health monitor of the pool, nil if the pool has none.
+	Monitor *monitors.CreateOpts
+}
+
+// TLSPoolCreateOpts adds the backend re-encryption attributes of the Octavia pool which are not supported by
+// gophercloud pools.CreateOpts.
+type TLSPoolCreateOpts struct {
+	pools.CreateOpts
+
+	// TLSEnabled makes the connections to the pool members use TLS encryption.
+	TLSEnabled bool `json:"tls_enabled"`
+	// CATLSContainerRef is the reference to a Barbican secret containing the PEM encoded CA certificate bundle used
+	// to verify the pool members.
+	CATLSContainerRef string `json:"ca_tls_container_ref,omitempty"`
+	// ALPNProtocols is the list of ALPN protocols offered to the pool members, e.g. "h2" for HTTP/2.
+	ALPNProtocols []string `json:"alpn_protocols,omitempty"`
+}
+
+// ToPoolCreateMap builds a request body from TLSPoolCreateOpts.
+func (opts TLSPoolCreateOpts) ToPoolCreateMap() (map[string]interface{}, error) {
+	return gophercloud.BuildRequestBody(opts, "pool")
 }
 
 // ResourceTracker tracks the resources created for Ingress.
@@ -95,19 +118,32 @@ type ResourceTracker struct {
 
 	// A map from pool name to pool ID
 	oldPoolMapping map[string]string
-	oldPools       []pools.Pool
+	// A map from pool name to the ID of its health monitor
+	oldPoolMonitors map[string]string
+	oldPools        []pools.Pool
 	// A map from rule hash key to policy.
 	oldPolicyMapping map[string]ExistingPolicy
+
+	// A map from pool name to pool ID, set by CreateResources.
+	poolMapping map[string]string
+
+	// The number of pools and l7 policies created or deleted.
+	poolChanges   int
+	policyChanges int
 }
 
 func NewResourceTracker(ingressName string, client *gophercloud.ServiceClient, lbID string, listenerID string, newPools []IngPool, newPolicies []IngPolicy, oldPools []pools.Pool, oldPolicies []ExistingPolicy) *ResourceTracker {
 	newPoolNames := sets.New[string]()
 	oldPoolMapping := make(map[string]string)
+	oldPoolMonitors := make(map[string]string)
 	for _, pool := range newPools {
 		newPoolNames.Insert(pool.Name)
 	}
 	for _, pool := range oldPools {
 		oldPoolMapping[pool.Name] = pool.ID
+		if pool.MonitorID != "" {
+			oldPoolMonitors[pool.Name] = pool.MonitorID
+		}
 	}
 
 	oldPolicyMapping := make(map[string]ExistingPolicy)
@@ -145,6 +181,7 @@ func NewResourceTracker(ingressName string, client *gophercloud.ServiceClient, l
 		newPolicyRuleMapping: make(map[string]string),
 		oldPools:             oldPools,
 		oldPoolMapping:       oldPoolMapping,
+		oldPoolMonitors:      oldPoolMonitors,
 		oldPolicyMapping:     oldPolicyMapping,
 	}
 
@@ -169,11 +206,16 @@ func (rt *ResourceTracker) CreateResources() error {
 			}
 
 			poolID = newPool.ID
+			rt.poolChanges++
 			rt.logger.WithFields(log.Fields{"poolName": pool.Name, "poolID": poolID}).Info("pool created")
 		}
 
 		poolMapping[pool.Name] = poolID
 
+		if err := rt.ensurePoolMonitor(pool, poolID); err != nil {
+			return err
+		}
+
 		rt.logger.WithFields(log.Fields{"poolName": pool.Name, "poolID": poolID}).Info("updating pool members")
 		if err := openstackutil.BatchUpdatePoolMembers(rt.client, rt.lbID, poolID, pool.PoolMembers); err != nil {
 			return fmt.Errorf("failed to update pool members, error: %v", err)
@@ -186,7 +228,13 @@ func (rt *ResourceTracker) CreateResources() error {
 		curPoolIDs = append(curPoolIDs, id)
 	}
 	rt.logger.Debugf("Current pools: %v", curPoolIDs)
+	rt.poolMapping = poolMapping
+
+	return rt.createPolicies(poolMapping)
+}
 
+// createPolicies creates the l7 policies of the listener which don't exist with the same rules and pool.
+func (rt *ResourceTracker) createPolicies(poolMapping map[string]string) error {
 	for _, policy := range rt.newPolicies {
 		newRuleIden := sets.NewString()
 		for _, opt := range policy.RulesOpts {
@@ -205,6 +253,7 @@ func (rt *ResourceTracker) CreateResources() error {
 			if err != nil {
 				return fmt.Errorf("failed to create l7policy, error: %v", err)
 			}
+			rt.policyChanges++
 			rt.logger.WithFields(log.Fields{"listenerID": rt.listenerID, "poolID": poolID}).Info("l7 policy created")
 
 			rt.logger.WithFields(log.Fields{"listenerID": rt.listenerID, "policyID": newPolicy.ID}).Info("creating l7 rules")
@@ -225,6 +274,40 @@ func (rt *ResourceTracker) CreateResources() error {
 }
 
 func (rt *ResourceTracker) CleanupResources() error {
+	if err := rt.cleanupPolicies(); err != nil {
+		return err
+	}
+
+	for _, pool := range rt.oldPools {
+		if !rt.newPoolNames.Has(pool.Name) {
+			// Delete unused pool
+			rt.logger.WithFields(log.Fields{"poolID": pool.ID}).Info("deleting pool")
+			if err := openstackutil.DeletePool(rt.client, pool.ID, rt.lbID); err != nil {
+				return fmt.Errorf("failed to delete pool %s, error: %v", pool.ID, err)
+			}
+			rt.poolChanges++
+			rt.logger.WithFields(log.Fields{"poolID": pool.ID}).Info("pool deleted")
+		}
+	}
+
+	return nil
+}
+
+// EnsureListenerPolicies creates and deletes the l7 policies of another listener of the load balancer, redirecting
+// to the pools ensured by CreateResources. It must be called after CreateResources and before CleanupResources.
+func (rt *ResourceTracker) EnsureListenerPolicies(listenerID string, newPolicies []IngPolicy, oldPolicies []ExistingPolicy) error {
+	lt := NewResourceTracker("", rt.client, rt.lbID, listenerID, nil, newPolicies, nil, oldPolicies)
+	lt.logger = rt.logger.WithFields(log.Fields{"listenerID": listenerID})
+	defer func() { rt.policyChanges += lt.policyChanges }()
+
+	if err := lt.createPolicies(rt.poolMapping); err != nil {
+		return err
+	}
+	return lt.cleanupPolicies()
+}
+
+// cleanupPolicies deletes the l7 policies of the listener which are no longer needed.
+func (rt *ResourceTracker) cleanupPolicies() error {
 	for key, oldPolicy := range rt.oldPolicyMapping {
 		poolID, isPresent := rt.newPolicyRuleMapping[key]
 		if !isPresent || poolID != oldPolicy.Policy.RedirectPoolID {
@@ -233,24 +316,77 @@ func (rt *ResourceTracker) CleanupResources() error {
 			if err := openstackutil.DeleteL7policy(rt.client, oldPolicy.Policy.ID, rt.lbID); err != nil {
 				return fmt.Errorf("failed to delete l7 policy %s, error: %v", oldPolicy.Policy.ID, err)
 			}
+			rt.policyChanges++
 			rt.logger.WithFields(log.Fields{"policyID": oldPolicy.Policy.ID}).Info("policy deleted")
 		}
 	}
 
-	for _, pool := range rt.oldPools {
-		if !rt.newPoolNames.Has(pool.Name) {
-			// Delete unused pool
-			rt.logger.WithFields(log.Fields{"poolID": pool.ID}).Info("deleting pool")
-			if err := openstackutil.DeletePool(rt.client, pool.ID, rt.lbID); err != nil {
-				return fmt.Errorf("failed to delete pool %s, error: %v", pool.ID, err)
-			}
-			rt.logger.WithFields(log.Fields{"poolID": pool.ID}).Info("pool deleted")
+	return nil
+}
+
+// ensurePoolMonitor creates, updates or deletes the health monitor of the pool to match pool.Monitor.
+func (rt *ResourceTracker) ensurePoolMonitor(pool IngPool, poolID string) error {
+	monitorID := rt.oldPoolMonitors[pool.Name]
+	logger := rt.logger.WithFields(log.Fields{"poolName": pool.Name, "poolID": poolID})
+
+	if pool.Monitor == nil {
+		if monitorID == "" {
+			return nil
 		}
+		logger.WithFields(log.Fields{"monitorID": monitorID}).Info("deleting health monitor")
+		if err := openstackutil.DeleteHealthMonitor(rt.client, monitorID, rt.lbID); err != nil {
+			return fmt.Errorf("failed to delete health monitor %s of pool %s, error: %v", monitorID, pool.Name, err)
+		}
+		return nil
+	}
+
+	if monitorID == "" {
+		opts := *pool.Monitor
+		opts.PoolID = poolID
+		logger.Info("creating health monitor")
+		if _, err := openstackutil.CreateHealthMonitor(rt.client, opts, rt.lbID); err != nil {
+			return fmt.Errorf("failed to create health monitor of pool %s, error: %v", pool.Name, err)
+		}
+		return nil
+	}
+
+	monitor, err := openstackutil.GetHealthMonitor(rt.client, monitorID)
+	if err != nil {
+		return err
+	}
+	if monitor.Type != pool.Monitor.Type {
+		// The type of a health monitor can't be updated
+		logger.WithFields(log.Fields{"monitorID": monitorID}).Info("recreating health monitor")
+		if err := openstackutil.DeleteHealthMonitor(rt.client, monitorID, rt.lbID); err != nil {
+			return fmt.Errorf("failed to delete health monitor %s of pool %s, error: %v", monitorID, pool.Name, err)
+		}
+		delete(rt.oldPoolMonitors, pool.Name)
+		return rt.ensurePoolMonitor(pool, poolID)
 	}
 
+	if monitor.URLPath == pool.Monitor.URLPath && monitor.ExpectedCodes == pool.Monitor.ExpectedCodes &&
+		monitor.Delay == pool.Monitor.Delay && monitor.Timeout == pool.Monitor.Timeout && monitor.MaxRetries == pool.Monitor.MaxRetries {
+		return nil
+	}
+
+	logger.WithFields(log.Fields{"monitorID": monitorID}).Info("updating health monitor")
+	if err := openstackutil.UpdateHealthMonitor(rt.client, monitorID, monitors.UpdateOpts{
+		Delay:         pool.Monitor.Delay,
+		Timeout:       pool.Monitor.Timeout,
+		MaxRetries:    pool.Monitor.MaxRetries,
+		URLPath:       pool.Monitor.URLPath,
+		ExpectedCodes: pool.Monitor.ExpectedCodes,
+	}, rt.lbID); err != nil {
+		return fmt.Errorf("failed to update health monitor %s of pool %s, error: %v", monitorID, pool.Name, err)
+	}
 	return nil
 }
 
+// Changes returns the number of pools and l7 policies created or deleted by CreateResources and CleanupResources.
+func (rt *ResourceTracker) Changes() (int, int) {
+	return rt.poolChanges, rt.policyChanges
+}
+
 func (os *OpenStack) waitLoadbalancerActiveProvisioningStatus(loadbalancerID string) (string, error) {
 	backoff := wait.Backoff{
 		Duration: loadbalancerActiveInitDealy,
@@ -316,6 +452,56 @@ func (os *OpenStack) EnsureLoadBalancer(name string, subnetID string, ingNamespa
 	return loadbalancer, nil
 }
 
+// GetAdoptedLoadBalancer gets an existing load balancer the Ingress is adopting and waits for it to be ACTIVE.
+func (os *OpenStack) GetAdoptedLoadBalancer(lbID string) (*loadbalancers.LoadBalancer, error) {
+	loadbalancer, err := openstackutil.GetLoadbalancerByID(os.Octavia, lbID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting adopted loadbalancer %s: %v", lbID, err)
+	}
+
+	_, err = os.waitLoadbalancerActiveProvisioningStatus(loadbalancer.ID)
+	if err != nil {
+		return nil, fmt.Errorf("loadbalancer %s not in ACTIVE status, error: %v", loadbalancer.ID, err)
+	}
+
+	return loadbalancer, nil
+}
+
+// ReleaseLoadBalancer deletes the listeners, with their l7 policies, and the pools created for the Ingress on an
+// adopted load balancer. The pools are found by the ownerTag, the other resources of the load balancer are left
+// untouched.
+func (os *OpenStack) ReleaseLoadBalancer(lbID string, listenerNames []string, ownerTag string) error {
+	for _, listenerName := range listenerNames {
+		listener, err := openstackutil.GetListenerByName(os.Octavia, listenerName, lbID)
+		if err != nil && err != cpoerrors.ErrNotFound {
+			return fmt.Errorf("error getting listener %s: %v", listenerName, err)
+		}
+		if listener != nil {
+			log.WithFields(log.Fields{"lbID": lbID, "listenerID": listener.ID}).Info("deleting listener")
+			if err := openstackutil.DeleteListener(os.Octavia, listener.ID, lbID); err != nil {
+				return err
+			}
+		}
+	}
+
+	lbPools, err := openstackutil.GetPools(os.Octavia, lbID)
+	if err != nil {
+		return fmt.Errorf("failed to get pools from load balancer %s, error: %v", lbID, err)
+	}
+	for _, pool := range lbPools {
+		if !slices.Contains(pool.Tags, ownerTag) {
+			continue
+		}
+
+		log.WithFields(log.Fields{"lbID": lbID, "poolID": pool.ID}).Info("deleting pool")
+		if err := openstackutil.DeletePool(os.Octavia, pool.ID, lbID); err != nil {
+			return fmt.Errorf("failed to delete pool %s, error: %v", pool.ID, err)
+		}
+	}
+
+	return nil
+}
+
 // UpdateLoadBalancerDescription updates the load balancer description field.
 func (os *OpenStack) UpdateLoadBalancerDescription(lbID string, newDescription string) error {
 	_, err := loadbalancers.Update(os.Octavia, lbID, loadbalancers.UpdateOpts{
@@ -330,7 +516,8 @@ func (os *OpenStack) UpdateLoadBalancerDescription(lbID string, newDescription s
 }
 
 // EnsureListener creates a loadbalancer listener in octavia if it does not exist, wait for the loadbalancer to be ACTIVE.
-func (os *OpenStack) EnsureListener(name string, lbID string, secretRefs []string, listenerAllowedCIDRs []string, timeoutClientData, timeoutMemberData, timeoutTCPInspect, timeoutMemberConnect *int) (*listeners.Listener, error) {
+// The tags are only set when the listener is created.
+func (os *OpenStack) EnsureListener(name string, lbID string, secretRefs []string, listenerAllowedCIDRs []string, timeoutClientData, timeoutMemberData, timeoutTCPInspect, timeoutMemberConnect *int, tags []string) (*listeners.Listener, error) {
 	listener, err := openstackutil.GetListenerByName(os.Octavia, name, lbID)
 	if err != nil {
 		if err != cpoerrors.ErrNotFound {
@@ -348,6 +535,7 @@ func (os *OpenStack) EnsureListener(name string, lbID string, secretRefs []strin
 			TimeoutMemberData:    timeoutMemberData,
 			TimeoutMemberConnect: timeoutMemberConnect,
 			TimeoutTCPInspect:    timeoutTCPInspect,
+			Tags:                 tags,
 		}
 		if len(secretRefs) > 0 {
 			opts.DefaultTlsContainerRef = secretRefs[0]
@@ -518,14 +706,19 @@ func (os *OpenStack) EnsurePoolMembers(deleted bool, poolName string, lbID strin
 	return &pool.ID, nil
 }
 
-// UpdateLoadbalancerMembers update members for all the pools in the specified load balancer.
-func (os *OpenStack) UpdateLoadbalancerMembers(lbID string, nodes []*apiv1.Node) error {
+// UpdateLoadbalancerMembers update members for all the pools in the specified load balancer. If ownerTag is not empty,
+// only the pools having the tag are updated.
+func (os *OpenStack) UpdateLoadbalancerMembers(lbID string, nodes []*apiv1.Node, ownerTag string) error {
 	lbPools, err := openstackutil.GetPools(os.Octavia, lbID)
 	if err != nil {
 		return err
 	}
 
 	for _, pool := range lbPools {
+		if ownerTag != "" && !slices.Contains(pool.Tags, ownerTag) {
+			continue
+		}
+
 		log.WithFields(log.Fields{"poolID": pool.ID}).Debug("Starting to update pool members")
 
 		members, err := openstackutil.GetMembersbyPool(os.Octavia, pool.ID)
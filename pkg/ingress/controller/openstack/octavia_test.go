@@ -0,0 +1,154 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/monitors"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeOctavia serves the load balancer and the health monitors of the Octavia API, recording the health monitor
+// requests.
+type fakeOctavia struct {
+	monitor  string
+	requests []string
+}
+
+func (f *fakeOctavia) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case r.URL.Path == "/lbaas/loadbalancers/lb":
+		fmt.Fprint(w, `{"loadbalancer": {"id": "lb", "provisioning_status": "ACTIVE"}}`)
+		return
+	case strings.HasPrefix(r.URL.Path, "/lbaas/healthmonitors"):
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var body map[string]any
+	if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}
+	request := r.Method + " " + r.URL.Path
+	if body != nil {
+		b, _ := json.Marshal(body["healthmonitor"])
+		request += " " + string(b)
+	}
+	f.requests = append(f.requests, request)
+
+	switch r.Method {
+	case http.MethodGet:
+		fmt.Fprintf(w, `{"healthmonitor": %s}`, f.monitor)
+	case http.MethodPost:
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"healthmonitor": {"id": "new"}}`)
+	case http.MethodPut:
+		fmt.Fprint(w, `{"healthmonitor": {"id": "monitor"}}`)
+	case http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestEnsurePoolMonitor(t *testing.T) {
+	monitor := &monitors.CreateOpts{
+		Name: "pool", Type: monitors.TypeHTTP, URLPath: "/healthz", HTTPMethod: "GET", ExpectedCodes: "200",
+		Delay: 10, Timeout: 5, MaxRetries: 3,
+	}
+	current := `{"id": "monitor", "type": "HTTP", "url_path": "/healthz", "expected_codes": "200", "delay": 10, "timeout": 5, "max_retries": 3}`
+
+	tests := []struct {
+		name      string
+		monitorID string
+		current   string
+		monitor   *monitors.CreateOpts
+		want      []string
+	}{
+		{
+			name: "no health monitor",
+		},
+		{
+			name:    "created",
+			monitor: monitor,
+			want: []string{
+				`POST /lbaas/healthmonitors {"delay":10,"expected_codes":"200","http_method":"GET","max_retries":3,"name":"pool","pool_id":"pool-id","timeout":5,"type":"HTTP","url_path":"/healthz"}`,
+			},
+		},
+		{
+			name:      "deleted",
+			monitorID: "monitor",
+			want:      []string{"DELETE /lbaas/healthmonitors/monitor"},
+		},
+		{
+			name:      "unchanged",
+			monitorID: "monitor",
+			current:   current,
+			monitor:   monitor,
+			want:      []string{"GET /lbaas/healthmonitors/monitor"},
+		},
+		{
+			name:      "updated",
+			monitorID: "monitor",
+			current:   strings.Replace(current, `"/healthz"`, `"/ready"`, 1),
+			monitor:   monitor,
+			want: []string{
+				"GET /lbaas/healthmonitors/monitor",
+				`PUT /lbaas/healthmonitors/monitor {"delay":10,"expected_codes":"200","max_retries":3,"timeout":5,"url_path":"/healthz"}`,
+			},
+		},
+		{
+			name:      "recreated with another type",
+			monitorID: "monitor",
+			current:   strings.Replace(current, `"HTTP"`, `"HTTPS"`, 1),
+			monitor:   monitor,
+			want: []string{
+				"GET /lbaas/healthmonitors/monitor",
+				"DELETE /lbaas/healthmonitors/monitor",
+				`POST /lbaas/healthmonitors {"delay":10,"expected_codes":"200","http_method":"GET","max_retries":3,"name":"pool","pool_id":"pool-id","timeout":5,"type":"HTTP","url_path":"/healthz"}`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			octavia := &fakeOctavia{monitor: test.current}
+			server := httptest.NewServer(octavia)
+			defer server.Close()
+
+			client := &gophercloud.ServiceClient{
+				ProviderClient: &gophercloud.ProviderClient{HTTPClient: http.Client{Transport: http.DefaultTransport}},
+				Endpoint:       server.URL + "/",
+			}
+			oldPools := []pools.Pool{{ID: "pool-id", Name: "pool", MonitorID: test.monitorID}}
+			rt := NewResourceTracker("ingress", client, "lb", "listener", nil, nil, oldPools, nil)
+
+			err := rt.ensurePoolMonitor(IngPool{Name: "pool", Monitor: test.monitor}, "pool-id")
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, octavia.requests)
+		})
+	}
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	log "github.com/sirupsen/logrus"
+	apiv1 "k8s.io/api/core/v1"
+	nwv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+// IngressFinalizer is added to the Ingresses before their OpenStack resources are created, and removed once they're
+// deleted, so that a deletion interrupted by a restart of the controller is resumed instead of leaving orphaned
+// floating IPs and half-deleted load balancers.
+const IngressFinalizer = "octavia.ingress.kubernetes.io/cleanup"
+
+func hasFinalizer(ing *nwv1.Ingress) bool {
+	return slices.Contains(ing.Finalizers, IngressFinalizer)
+}
+
+// isTornDown returns true if the Ingress is being deleted and its OpenStack resources are still to be deleted.
+func isTornDown(ing *nwv1.Ingress) bool {
+	return ing.DeletionTimestamp != nil && hasFinalizer(ing)
+}
+
+// updateFinalizers adds or removes IngressFinalizer on the latest version of the Ingress, retrying on conflicts, and
+// returns the updated Ingress, nil if it no longer exists.
+func (c *Controller) updateFinalizers(ing *nwv1.Ingress, add bool) (*nwv1.Ingress, error) {
+	var updated *nwv1.Ingress
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cur, err := c.kubeClient.NetworkingV1().Ingresses(ing.Namespace).Get(context.TODO(), ing.Name, apimetav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if hasFinalizer(cur) == add {
+			updated = cur
+			return nil
+		}
+
+		finalizers := slices.DeleteFunc(slices.Clone(cur.Finalizers), func(f string) bool { return f == IngressFinalizer })
+		if add {
+			finalizers = append(finalizers, IngressFinalizer)
+		}
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"finalizers":      finalizers,
+				"resourceVersion": cur.ResourceVersion,
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		updated, err = c.kubeClient.NetworkingV1().Ingresses(ing.Namespace).Patch(context.TODO(), ing.Name, types.MergePatchType, patch, apimetav1.PatchOptions{})
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+
+	return updated, err
+}
+
+// addFinalizer adds IngressFinalizer to the Ingress and returns the updated Ingress.
+func (c *Controller) addFinalizer(ing *nwv1.Ingress) (*nwv1.Ingress, error) {
+	if hasFinalizer(ing) {
+		return ing, nil
+	}
+
+	updated, err := c.updateFinalizers(ing, true)
+	if err == nil && updated == nil {
+		err = fmt.Errorf("ingress %s/%s not found", ing.Namespace, ing.Name)
+	}
+	return updated, err
+}
+
+// removeFinalizer removes IngressFinalizer from the Ingress, if it still exists.
+func (c *Controller) removeFinalizer(ing *nwv1.Ingress) error {
+	_, err := c.updateFinalizers(ing, false)
+	return err
+}
+
+// teardownLoadBalancer deletes the load balancer of the Ingress step by step: the l7 policies, the listeners, the
+// pools, the floating IP unless it's kept, then the load balancer itself. Every step tolerates the resources already
+// deleted, so that a teardown failing or interrupted half-way is resumed by the next attempt. An event is recorded on
+// the Ingress after each step.
+func (c *Controller) teardownLoadBalancer(ing *nwv1.Ingress, lb *loadbalancers.LoadBalancer, keepFloatingIP bool) error {
+	logger := log.WithFields(log.Fields{"ingress": fmt.Sprintf("%s/%s", ing.Namespace, ing.Name), "lbID": lb.ID})
+
+	lbListeners, err := openstackutil.GetListenersByLoadBalancerID(c.osClient.Octavia, lb.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get listeners of load balancer %s: %v", lb.ID, err)
+	}
+
+	policyCount := 0
+	for _, listener := range lbListeners {
+		policies, err := openstackutil.GetL7policies(c.osClient.Octavia, listener.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get l7 policies of listener %s: %v", listener.ID, err)
+		}
+		for _, policy := range policies {
+			if err := openstackutil.DeleteL7policy(c.osClient.Octavia, policy.ID, lb.ID); err != nil && !cpoerrors.IsNotFound(err) {
+				return fmt.Errorf("failed to delete l7 policy %s: %v", policy.ID, err)
+			}
+			policyCount++
+		}
+	}
+	if policyCount > 0 {
+		logger.WithFields(log.Fields{"count": policyCount}).Info("l7 policies deleted")
+		c.recorder.Event(ing, apiv1.EventTypeNormal, "DeletedL7Policies", fmt.Sprintf("Deleted %d l7 policies of load balancer %s", policyCount, lb.ID))
+	}
+
+	for _, listener := range lbListeners {
+		if err := openstackutil.DeleteListener(c.osClient.Octavia, listener.ID, lb.ID); err != nil {
+			return err
+		}
+	}
+
+	lbPools, err := openstackutil.GetPools(c.osClient.Octavia, lb.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get pools of load balancer %s: %v", lb.ID, err)
+	}
+	for _, pool := range lbPools {
+		if err := openstackutil.DeletePool(c.osClient.Octavia, pool.ID, lb.ID); err != nil {
+			return err
+		}
+	}
+	if len(lbListeners) > 0 || len(lbPools) > 0 {
+		logger.Info("listeners and pools deleted")
+		c.recorder.Event(ing, apiv1.EventTypeNormal, "DeletedListeners", fmt.Sprintf("Deleted %d listeners and %d pools of load balancer %s", len(lbListeners), len(lbPools), lb.ID))
+	}
+
+	if !keepFloatingIP {
+		// Delete the floating IP for the load balancer VIP. We don't check if the Ingress is internal or not, just
+		// delete any floating IPs associated with the load balancer VIP port.
+		if _, err := c.osClient.EnsureFloatingIP(true, lb.VipPortID, "", "", ""); err != nil {
+			return fmt.Errorf("failed to delete floating IP: %v", err)
+		}
+		logger.WithFields(log.Fields{"VIP": lb.VipAddress}).Info("floating IP deleted")
+		c.recorder.Event(ing, apiv1.EventTypeNormal, "DeletedFloatingIP", fmt.Sprintf("Deleted the floating IPs of load balancer %s", lb.ID))
+	}
+
+	if err := openstackutil.DeleteLoadbalancer(c.osClient.Octavia, lb.ID, false); err != nil {
+		return err
+	}
+	logger.Info("loadbalancer deleted")
+	c.recorder.Event(ing, apiv1.EventTypeNormal, "DeletedLoadBalancer", fmt.Sprintf("Deleted load balancer %s", lb.ID))
+
+	return nil
+}
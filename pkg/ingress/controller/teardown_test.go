@@ -0,0 +1,169 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	"github.com/stretchr/testify/assert"
+	nwv1 "k8s.io/api/networking/v1"
+	apimetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"k8s.io/cloud-provider-openstack/pkg/ingress/controller/openstack"
+)
+
+// fakeTeardownOctavia serves a load balancer with a listener, an l7 policy and a pool, and records the deletions.
+type fakeTeardownOctavia struct {
+	deleted []string
+	// gone has the resources failing to be deleted as already deleted
+	gone map[string]bool
+}
+
+func (f *fakeTeardownOctavia) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	path := strings.TrimPrefix(r.URL.Path, "/lbaas/")
+
+	if r.Method == http.MethodDelete {
+		f.deleted = append(f.deleted, path)
+		if f.gone[path] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	switch path {
+	case "loadbalancers/lb-1":
+		if len(f.deleted) > 0 && f.deleted[len(f.deleted)-1] == "loadbalancers/lb-1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"loadbalancer": {"id": "lb-1", "provisioning_status": "ACTIVE"}}`)
+	case "listeners":
+		fmt.Fprint(w, `{"listeners": [{"id": "listener-1"}]}`)
+	case "l7policies":
+		fmt.Fprint(w, `{"l7policies": [{"id": "policy-1"}]}`)
+	case "pools":
+		fmt.Fprint(w, `{"pools": [{"id": "pool-1"}]}`)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func TestTeardownLoadBalancer(t *testing.T) {
+	tests := []struct {
+		name       string
+		gone       map[string]bool
+		wantEvents []string
+	}{
+		{
+			name:       "all resources",
+			wantEvents: []string{"DeletedL7Policies", "DeletedListeners", "DeletedLoadBalancer"},
+		},
+		{
+			name:       "resumed after the l7 policies were deleted",
+			gone:       map[string]bool{"l7policies/policy-1": true, "listeners/listener-1": true},
+			wantEvents: []string{"DeletedL7Policies", "DeletedListeners", "DeletedLoadBalancer"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			octavia := &fakeTeardownOctavia{gone: test.gone}
+			server := httptest.NewServer(octavia)
+			defer server.Close()
+
+			recorder := record.NewFakeRecorder(10)
+			c := &Controller{
+				osClient: &openstack.OpenStack{
+					Octavia: &gophercloud.ServiceClient{
+						ProviderClient: &gophercloud.ProviderClient{HTTPClient: http.Client{Transport: http.DefaultTransport}},
+						Endpoint:       server.URL + "/",
+					},
+				},
+				recorder: recorder,
+			}
+			ing := &nwv1.Ingress{ObjectMeta: apimetav1.ObjectMeta{Name: "ing", Namespace: "default"}}
+
+			err := c.teardownLoadBalancer(ing, &loadbalancers.LoadBalancer{ID: "lb-1"}, true)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"l7policies/policy-1", "listeners/listener-1", "pools/pool-1", "loadbalancers/lb-1"}, octavia.deleted)
+
+			close(recorder.Events)
+			var reasons []string
+			for event := range recorder.Events {
+				reasons = append(reasons, strings.Fields(event)[1])
+			}
+			assert.Equal(t, test.wantEvents, reasons)
+		})
+	}
+}
+
+func TestTeardownLoadBalancerFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	c := &Controller{
+		osClient: &openstack.OpenStack{
+			Octavia: &gophercloud.ServiceClient{
+				ProviderClient: &gophercloud.ProviderClient{HTTPClient: http.Client{Transport: http.DefaultTransport}},
+				Endpoint:       server.URL + "/",
+			},
+		},
+		recorder: record.NewFakeRecorder(10),
+	}
+	ing := &nwv1.Ingress{ObjectMeta: apimetav1.ObjectMeta{Name: "ing", Namespace: "default"}}
+
+	err := c.teardownLoadBalancer(ing, &loadbalancers.LoadBalancer{ID: "lb-1"}, true)
+	assert.ErrorContains(t, err, "failed to get listeners")
+}
+
+func TestFinalizers(t *testing.T) {
+	ing := &nwv1.Ingress{ObjectMeta: apimetav1.ObjectMeta{Name: "ing", Namespace: "default", Finalizers: []string{"other"}}}
+	kubeClient := fake.NewSimpleClientset(ing)
+	c := &Controller{kubeClient: kubeClient}
+
+	updated, err := c.addFinalizer(ing)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"other", IngressFinalizer}, updated.Finalizers)
+	assert.False(t, isTornDown(updated))
+
+	now := apimetav1.Now()
+	updated.DeletionTimestamp = &now
+	assert.True(t, isTornDown(updated))
+
+	// The finalizer is removed from the latest version of the Ingress
+	assert.NoError(t, c.removeFinalizer(ing))
+	cur, err := kubeClient.NetworkingV1().Ingresses("default").Get(context.Background(), "ing", apimetav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"other"}, cur.Finalizers)
+
+	// The Ingress may be gone already
+	assert.NoError(t, c.removeFinalizer(&nwv1.Ingress{ObjectMeta: apimetav1.ObjectMeta{Name: "gone", Namespace: "default"}}))
+}
@@ -5,16 +5,21 @@ import (
 	"github.com/gophercloud/gophercloud/openstack"
 	"github.com/gophercloud/gophercloud/openstack/keymanager/v1/secrets"
 	"k8s.io/cloud-provider-openstack/pkg/client"
+	"k8s.io/cloud-provider-openstack/pkg/kms/vault"
 )
 
 type KMSOpts struct {
 	KeyID string `gcfg:"key-id"`
+	// Provider the DEKs are encrypted with, "barbican" (default) or "vault".
+	// The other provider, if configured, is only used to decrypt.
+	Provider string `gcfg:"provider"`
 }
 
 // Config to read config options
 type Config struct {
 	Global     client.AuthOpts
 	KeyManager KMSOpts
+	Vault      vault.Opts
 }
 
 // Barbican is gophercloud service client
@@ -0,0 +1,136 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+
+	"k8s.io/cloud-provider-openstack/pkg/kms/barbican"
+	"k8s.io/cloud-provider-openstack/pkg/kms/encryption/aescbc"
+	"k8s.io/cloud-provider-openstack/pkg/kms/vault"
+	"k8s.io/klog/v2"
+)
+
+const (
+	providerBarbican = "barbican"
+	providerVault    = "vault"
+)
+
+// KeyProvider encrypts and decrypts the DEKs of the API server with a KEK
+type KeyProvider interface {
+	// KeyID identifies the KEK, it's returned to the API server along with the encrypted DEKs
+	KeyID() string
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// barbicanProvider encrypts with AES-CBC, with a key stored in Barbican
+type barbicanProvider struct {
+	keyID    string
+	barbican BarbicanService
+}
+
+func (p *barbicanProvider) KeyID() string {
+	return p.keyID
+}
+
+func (p *barbicanProvider) Encrypt(plaintext []byte) ([]byte, error) {
+	key, err := p.barbican.GetSecret(p.keyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key: %w", err)
+	}
+
+	return aescbc.Encrypt(plaintext, key)
+}
+
+func (p *barbicanProvider) Decrypt(ciphertext []byte) ([]byte, error) {
+	key, err := p.barbican.GetSecret(p.keyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key: %w", err)
+	}
+
+	return aescbc.Decrypt(ciphertext, key)
+}
+
+// initProviders creates the key providers configured in cfg, the first one being the provider
+// the DEKs are encrypted with
+func initProviders(cfg barbican.Config) ([]KeyProvider, error) {
+	var barbicanP, vaultP KeyProvider
+
+	if cfg.KeyManager.KeyID != "" {
+		client, err := barbican.NewBarbicanClient(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get Barbican client: %w", err)
+		}
+		barbicanP = &barbicanProvider{keyID: cfg.KeyManager.KeyID, barbican: &barbican.Barbican{Client: client}}
+	}
+
+	if cfg.Vault.Address != "" {
+		transit, err := vault.NewTransit(cfg.Vault)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get Vault client: %w", err)
+		}
+		vaultP = transit
+	}
+
+	name := cfg.KeyManager.Provider
+	if name == "" {
+		name = providerBarbican
+	}
+
+	primary, secondary := barbicanP, vaultP
+	switch name {
+	case providerBarbican:
+	case providerVault:
+		primary, secondary = vaultP, barbicanP
+	default:
+		return nil, fmt.Errorf("unknown key provider %q, expected %q or %q", name, providerBarbican, providerVault)
+	}
+
+	if primary == nil {
+		return nil, fmt.Errorf("the %s key provider is not configured", name)
+	}
+
+	providers := []KeyProvider{primary}
+	if secondary != nil {
+		klog.Infof("Decrypting with key %s as well, while the DEKs are re-encrypted with key %s", secondary.KeyID(), primary.KeyID())
+		providers = append(providers, secondary)
+	}
+
+	return providers, nil
+}
+
+// decryptProvider returns the provider which encrypted the ciphertext with the key keyID. The
+// ciphertexts of an unknown key, e.g. of a Barbican key replaced in the configuration, are
+// decrypted by the provider of their format.
+func (s *KMSserver) decryptProvider(keyID string, ciphertext []byte) KeyProvider {
+	for _, p := range s.providers {
+		if p.KeyID() == keyID {
+			return p
+		}
+	}
+
+	isVault := bytes.HasPrefix(ciphertext, []byte(vault.CiphertextPrefix))
+	for _, p := range s.providers {
+		if _, ok := p.(*vault.Transit); ok == isVault {
+			return p
+		}
+	}
+
+	return s.providers[0]
+}
@@ -2,7 +2,6 @@ package server
 
 import (
 	"fmt"
-	"net"
 	"os"
 
 	"golang.org/x/net/context"
@@ -10,7 +9,6 @@ import (
 	"google.golang.org/grpc"
 	gcfg "gopkg.in/gcfg.v1"
 	"k8s.io/cloud-provider-openstack/pkg/kms/barbican"
-	"k8s.io/cloud-provider-openstack/pkg/kms/encryption/aescbc"
 	"k8s.io/klog/v2"
 	pb "k8s.io/kms/apis/v2"
 )
@@ -27,8 +25,9 @@ type BarbicanService interface {
 
 // KMSserver struct
 type KMSserver struct {
-	cfg      barbican.Config
-	barbican BarbicanService
+	cfg barbican.Config
+	// providers[0] encrypts, the others only decrypt
+	providers []KeyProvider
 }
 
 func initConfig(configFilePath string, cfg *barbican.Config) error {
@@ -45,7 +44,7 @@ func initConfig(configFilePath string, cfg *barbican.Config) error {
 }
 
 // Run Grpc server for barbican KMS
-func Run(configFilePath string, socketpath string, sigchan <-chan os.Signal) (err error) {
+func Run(configFilePath string, socket SocketOpts, sigchan <-chan os.Signal) (err error) {
 	klog.Infof("Barbican KMS Plugin Starting Version: %s, RunTimeVersion: %s", version, runtimeversion)
 	s := new(KMSserver)
 	err = initConfig(configFilePath, &s.cfg)
@@ -54,19 +53,13 @@ func Run(configFilePath string, socketpath string, sigchan <-chan os.Signal) (er
 		return err
 	}
 
-	client, err := barbican.NewBarbicanClient(s.cfg)
+	s.providers, err = initProviders(s.cfg)
 	if err != nil {
-		klog.V(4).Infof("Failed to get Barbican client: %v", err)
+		klog.V(4).Infof("Failed to initialize the key providers: %v", err)
 		return err
 	}
-	s.barbican = &barbican.Barbican{Client: client}
 
-	// unlink the unix socket
-	if err = unix.Unlink(socketpath); err != nil {
-		klog.V(4).Infof("Error to unlink unix socket: %v", err)
-	}
-
-	listener, err := net.Listen(netProtocol, socketpath)
+	listener, err := listenSocket(socket)
 	if err != nil {
 		klog.Fatalf("Failed to Listen: %v", err)
 		return err
@@ -105,7 +98,7 @@ func (s *KMSserver) Status(ctx context.Context, req *pb.StatusRequest) (*pb.Stat
 	res := &pb.StatusResponse{
 		Version: version,
 		Healthz: "ok",
-		KeyId:   s.cfg.KeyManager.KeyID,
+		KeyId:   s.providers[0].KeyID(),
 	}
 
 	return res, nil
@@ -115,14 +108,7 @@ func (s *KMSserver) Status(ctx context.Context, req *pb.StatusRequest) (*pb.Stat
 func (s *KMSserver) Decrypt(ctx context.Context, req *pb.DecryptRequest) (*pb.DecryptResponse, error) {
 	klog.V(4).Infof("Decrypt Request by Kubernetes api server")
 
-	// TODO: consider using req.KeyId
-	key, err := s.barbican.GetSecret(s.cfg.KeyManager.KeyID)
-	if err != nil {
-		klog.V(4).Infof("Failed to get key %v: ", err)
-		return nil, err
-	}
-
-	plain, err := aescbc.Decrypt(req.Ciphertext, key)
+	plain, err := s.decryptProvider(req.KeyId, req.Ciphertext).Decrypt(req.Ciphertext)
 	if err != nil {
 		klog.V(4).Infof("Failed to decrypt data %v: ", err)
 		return nil, err
@@ -135,18 +121,12 @@ func (s *KMSserver) Decrypt(ctx context.Context, req *pb.DecryptRequest) (*pb.De
 func (s *KMSserver) Encrypt(ctx context.Context, req *pb.EncryptRequest) (*pb.EncryptResponse, error) {
 	klog.V(4).Infof("Encrypt Request by Kubernetes api server")
 
-	key, err := s.barbican.GetSecret(s.cfg.KeyManager.KeyID)
-
-	if err != nil {
-		klog.V(4).Infof("Failed to get key %v: ", err)
-		return nil, err
-	}
-
-	cipher, err := aescbc.Encrypt(req.Plaintext, key)
+	provider := s.providers[0]
+	cipher, err := provider.Encrypt(req.Plaintext)
 
 	if err != nil {
 		klog.V(4).Infof("Failed to encrypt data %v: ", err)
 		return nil, err
 	}
-	return &pb.EncryptResponse{Ciphertext: cipher, KeyId: s.cfg.KeyManager.KeyID}, nil
+	return &pb.EncryptResponse{Ciphertext: cipher, KeyId: provider.KeyID()}, nil
 }
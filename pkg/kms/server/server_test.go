@@ -2,14 +2,19 @@ package server
 
 import (
 	"bytes"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"golang.org/x/net/context"
 	"k8s.io/cloud-provider-openstack/pkg/kms/barbican"
+	"k8s.io/cloud-provider-openstack/pkg/kms/vault"
 	pb "k8s.io/kms/apis/v2"
 )
 
-var s = new(KMSserver)
+var s = &KMSserver{
+	providers: []KeyProvider{&barbicanProvider{keyID: "fake-key", barbican: &barbican.FakeBarbican{}}},
+}
 
 func TestInitConfig(t *testing.T) {
 }
@@ -23,7 +28,6 @@ func TestStatus(t *testing.T) {
 }
 
 func TestEncryptDecrypt(t *testing.T) {
-	s.barbican = &barbican.FakeBarbican{}
 	fakeData := []byte("fakedata")
 	encreq := &pb.EncryptRequest{Plaintext: fakeData}
 	encresp, err := s.Encrypt(context.TODO(), encreq)
@@ -38,3 +42,53 @@ func TestEncryptDecrypt(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestDecryptFallbackProvider(t *testing.T) {
+	server := vault.NewFakeServer()
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("fake-token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	transit, err := vault.NewTransit(vault.Opts{Address: server.URL, KeyName: "k8s", TokenFile: tokenFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	barbicanP := &barbicanProvider{keyID: "fake-key", barbican: &barbican.FakeBarbican{}}
+	fakeData := []byte("fakedata")
+
+	// DEK encrypted with Barbican before the migration to Vault
+	old, err := (&KMSserver{providers: []KeyProvider{barbicanP}}).Encrypt(context.TODO(), &pb.EncryptRequest{Plaintext: fakeData})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrating := &KMSserver{providers: []KeyProvider{transit, barbicanP}}
+
+	status, err := migrating.Status(context.TODO(), &pb.StatusRequest{})
+	if err != nil || status.KeyId != "vault:transit/k8s" {
+		t.Fatalf("expected the Vault key ID, got %v, %v", status, err)
+	}
+
+	encresp, err := migrating.Encrypt(context.TODO(), &pb.EncryptRequest{Plaintext: fakeData})
+	if err != nil || encresp.KeyId != "vault:transit/k8s" {
+		t.Fatalf("expected a DEK encrypted with Vault, got %v, %v", encresp, err)
+	}
+
+	for _, req := range []*pb.DecryptRequest{
+		{Ciphertext: old.Ciphertext, KeyId: old.KeyId},
+		{Ciphertext: encresp.Ciphertext, KeyId: encresp.KeyId},
+		// Unknown key IDs are decrypted by the provider of the ciphertext format
+		{Ciphertext: old.Ciphertext, KeyId: "replaced-key"},
+		{Ciphertext: encresp.Ciphertext},
+	} {
+		// aescbc decrypts in place
+		req.Ciphertext = bytes.Clone(req.Ciphertext)
+		decresp, err := migrating.Decrypt(context.TODO(), req)
+		if err != nil || !bytes.Equal(decresp.Plaintext, fakeData) {
+			t.Errorf("failed to decrypt DEK of key %q: %v", req.KeyId, err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+const (
+	selinuxXattr   = "security.selinux"
+	selinuxFSMount = "/sys/fs/selinux"
+
+	staleSocketDialTimeout = time.Second
+)
+
+// SocketOpts describes the unix socket the KMS plugin listens on
+type SocketOpts struct {
+	// Path of the socket
+	Path string
+	// Mode is the permissions of the socket
+	Mode os.FileMode
+	// UID and GID own the socket, -1 keeps the user or group of the plugin
+	UID int
+	GID int
+	// SELinuxLabel is the SELinux context of the socket, e.g.
+	// "system_u:object_r:container_file_t:s0". It's ignored on hosts without SELinux.
+	SELinuxLabel string
+}
+
+// removeStaleSocket removes the socket left by a previous run of the plugin.
+// It fails if the path is not a socket or if another plugin is still serving it.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a unix socket", path)
+	}
+
+	conn, err := net.DialTimeout(netProtocol, path, staleSocketDialTimeout)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("socket %s is in use by another process", path)
+	}
+
+	klog.Infof("Removing stale unix socket %s", path)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func selinuxEnabled() bool {
+	var st unix.Statfs_t
+	return unix.Statfs(selinuxFSMount, &st) == nil && uint32(st.Type) == unix.SELINUX_MAGIC
+}
+
+// setSocketAttributes sets the ownership, permissions and SELinux label of the socket.
+func setSocketAttributes(opts SocketOpts) error {
+	if opts.UID != -1 || opts.GID != -1 {
+		if err := os.Lchown(opts.Path, opts.UID, opts.GID); err != nil {
+			return fmt.Errorf("failed to change the owner of socket %s: %v", opts.Path, err)
+		}
+	}
+
+	if err := os.Chmod(opts.Path, opts.Mode); err != nil {
+		return fmt.Errorf("failed to change the permissions of socket %s: %v", opts.Path, err)
+	}
+
+	if opts.SELinuxLabel != "" {
+		if !selinuxEnabled() {
+			klog.V(4).Infof("SELinux is not enabled, not labeling socket %s", opts.Path)
+			return nil
+		}
+		if err := unix.Lsetxattr(opts.Path, selinuxXattr, []byte(opts.SELinuxLabel), 0); err != nil {
+			return fmt.Errorf("failed to set the SELinux label %s of socket %s: %v", opts.SELinuxLabel, opts.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// listenSocket creates the unix socket, replacing a stale one, and sets its attributes.
+func listenSocket(opts SocketOpts) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(opts.Path), 0750); err != nil {
+		return nil, err
+	}
+
+	if err := removeStaleSocket(opts.Path); err != nil {
+		return nil, err
+	}
+
+	// Don't let the socket be reachable with wider permissions until they are set.
+	oldUmask := unix.Umask(0777)
+	listener, err := net.Listen(netProtocol, opts.Path)
+	unix.Umask(oldUmask)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setSocketAttributes(opts); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
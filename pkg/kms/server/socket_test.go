@@ -0,0 +1,75 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenSocket(t *testing.T) {
+	opts := SocketOpts{
+		Path: filepath.Join(t.TempDir(), "kms", "kms.sock"),
+		Mode: 0660,
+		UID:  -1,
+		GID:  -1,
+	}
+
+	listener, err := listenSocket(opts)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	fi, err := os.Stat(opts.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != opts.Mode {
+		t.Errorf("expected socket mode %v, got %v", opts.Mode, fi.Mode().Perm())
+	}
+
+	// The socket is still served
+	if _, err := listenSocket(opts); err == nil {
+		t.Errorf("expected an error listening on a socket in use")
+	}
+
+	// Leave a stale socket behind
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+
+	listener, err = listenSocket(opts)
+	if err != nil {
+		t.Fatalf("failed to replace the stale socket: %v", err)
+	}
+	listener.Close()
+}
+
+func TestRemoveStaleSocketNotASocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kms.sock")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := removeStaleSocket(path); err == nil {
+		t.Errorf("expected an error removing a regular file")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("the regular file was removed: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+// NewFakeServer returns a Vault server whose transit secrets engine "encrypts" by prefixing the
+// base64 plaintext with CiphertextPrefix, accepting the token "fake-token" only
+func NewFakeServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "fake-token" {
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(map[string][]string{"errors": {"permission denied"}})
+			return
+		}
+
+		var req map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		data := make(map[string]string)
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/v1/transit/encrypt/"):
+			data["ciphertext"] = CiphertextPrefix + "1:" + req["plaintext"]
+		case strings.HasPrefix(r.URL.Path, "/v1/transit/decrypt/") && strings.HasPrefix(req["ciphertext"], CiphertextPrefix+"1:"):
+			data["plaintext"] = strings.TrimPrefix(req["ciphertext"], CiphertextPrefix+"1:")
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string][]string{"errors": {"invalid request"}})
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTransitMount = "transit"
+	requestTimeout      = 30 * time.Second
+
+	// CiphertextPrefix starts the ciphertexts of the Vault transit secrets engine, followed by the key version
+	CiphertextPrefix = "vault:v"
+)
+
+// Opts configures the Vault transit secrets engine the KMS plugin encrypts with
+type Opts struct {
+	// Address of the Vault server, e.g. https://vault.example.com:8200
+	Address string `gcfg:"address"`
+	// Path the transit secrets engine is mounted at, "transit" by default
+	TransitMount string `gcfg:"transit-mount"`
+	// Name of the transit key
+	KeyName string `gcfg:"key-name"`
+	// File containing the Vault token, read before each request so that it can be renewed, e.g. by a Vault agent
+	TokenFile string `gcfg:"token-file"`
+	// Vault Enterprise namespace
+	Namespace string `gcfg:"namespace"`
+	// CA bundle verifying the certificate of the Vault server, the system CAs are used if empty
+	CAFile string `gcfg:"ca-file"`
+}
+
+// Transit encrypts and decrypts with a key of the Vault transit secrets engine, which never leaves Vault
+type Transit struct {
+	opts   Opts
+	client *http.Client
+}
+
+// NewTransit creates a client of the Vault transit secrets engine
+func NewTransit(opts Opts) (*Transit, error) {
+	if opts.Address == "" || opts.KeyName == "" || opts.TokenFile == "" {
+		return nil, fmt.Errorf("the Vault address, key-name and token-file must be set")
+	}
+	if opts.TransitMount == "" {
+		opts.TransitMount = defaultTransitMount
+	}
+	opts.Address = strings.TrimSuffix(opts.Address, "/")
+	opts.TransitMount = strings.Trim(opts.TransitMount, "/")
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.CAFile != "" {
+		ca, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Vault CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in Vault CA file %s", opts.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	return &Transit{
+		opts:   opts,
+		client: &http.Client{Transport: transport, Timeout: requestTimeout},
+	}, nil
+}
+
+// KeyID identifies the transit key in the KMS responses
+func (t *Transit) KeyID() string {
+	return "vault:" + t.opts.TransitMount + "/" + t.opts.KeyName
+}
+
+// Encrypt encrypts plaintext with the transit key, the ciphertext is prefixed with CiphertextPrefix
+func (t *Transit) Encrypt(plaintext []byte) ([]byte, error) {
+	var resp struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+
+	req := map[string]string{"plaintext": base64.StdEncoding.EncodeToString(plaintext)}
+	if err := t.do("encrypt", req, &resp); err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(resp.Ciphertext, CiphertextPrefix) {
+		return nil, fmt.Errorf("unexpected ciphertext returned by Vault")
+	}
+
+	return []byte(resp.Ciphertext), nil
+}
+
+// Decrypt decrypts a ciphertext returned by Encrypt
+func (t *Transit) Decrypt(ciphertext []byte) ([]byte, error) {
+	var resp struct {
+		Plaintext string `json:"plaintext"`
+	}
+
+	req := map[string]string{"ciphertext": string(ciphertext)}
+	if err := t.do("decrypt", req, &resp); err != nil {
+		return nil, err
+	}
+
+	plaintext, err := base64.StdEncoding.DecodeString(resp.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid plaintext returned by Vault: %v", err)
+	}
+
+	return plaintext, nil
+}
+
+// do calls an operation of the transit key, data is the "data" object of the response
+func (t *Transit) do(op string, body interface{}, data interface{}) error {
+	token, err := os.ReadFile(t.opts.TokenFile)
+	if err != nil {
+		return fmt.Errorf("failed to read Vault token: %v", err)
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/v1/%s/%s/%s", t.opts.Address, t.opts.TransitMount, op, t.opts.KeyName)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", strings.TrimSpace(string(token)))
+	if t.opts.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", t.opts.Namespace)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Vault %s request failed: %v", op, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read Vault %s response: %v", op, err)
+	}
+
+	var r struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []string        `json:"errors"`
+	}
+	if err := json.Unmarshal(respBody, &r); err != nil && resp.StatusCode == http.StatusOK {
+		return fmt.Errorf("invalid Vault %s response: %v", op, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Vault %s request failed with status %d: %s", op, resp.StatusCode, strings.Join(r.Errors, ", "))
+	}
+
+	if err := json.Unmarshal(r.Data, data); err != nil {
+		return fmt.Errorf("invalid Vault %s response: %v", op, err)
+	}
+
+	return nil
+}
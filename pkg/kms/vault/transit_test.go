@@ -0,0 +1,92 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestTransit(t *testing.T, address, token string) *Transit {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte(token+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	transit, err := NewTransit(Opts{Address: address + "/", KeyName: "k8s", TokenFile: tokenFile})
+	if err != nil {
+		t.Fatalf("failed to create transit client: %v", err)
+	}
+
+	return transit
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	server := NewFakeServer()
+	defer server.Close()
+
+	transit := newTestTransit(t, server.URL, "fake-token")
+
+	if keyID := transit.KeyID(); keyID != "vault:transit/k8s" {
+		t.Errorf("unexpected key ID %s", keyID)
+	}
+
+	plaintext := []byte("fakedata")
+	ciphertext, err := transit.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	if !bytes.HasPrefix(ciphertext, []byte(CiphertextPrefix)) {
+		t.Errorf("unexpected ciphertext %s", ciphertext)
+	}
+
+	decrypted, err := transit.Decrypt(ciphertext)
+	if err != nil {
+		t.Fatalf("failed to decrypt: %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("expected %s, got %s", plaintext, decrypted)
+	}
+
+	if _, err := transit.Decrypt([]byte("garbage")); err == nil || !strings.Contains(err.Error(), "invalid request") {
+		t.Errorf("expected the Vault error, got %v", err)
+	}
+}
+
+func TestPermissionDenied(t *testing.T) {
+	server := NewFakeServer()
+	defer server.Close()
+
+	transit := newTestTransit(t, server.URL, "other-token")
+
+	if _, err := transit.Encrypt([]byte("fakedata")); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("expected permission denied, got %v", err)
+	}
+}
+
+func TestNewTransit(t *testing.T) {
+	if _, err := NewTransit(Opts{Address: "https://vault:8200", KeyName: "k8s"}); err == nil {
+		t.Error("expected an error without token file")
+	}
+
+	if _, err := NewTransit(Opts{Address: "https://vault:8200", KeyName: "k8s", TokenFile: "token", CAFile: "/nonexistent"}); err == nil {
+		t.Error("expected an error with a missing CA file")
+	}
+}
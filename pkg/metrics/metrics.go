@@ -64,4 +64,10 @@ func RegisterMetrics(component string) {
 	if component == "occm" {
 		doRegisterOccmMetrics()
 	}
+	if component == "octavia-ingress-controller" {
+		doRegisterIngressMetrics()
+	}
+	if component == "cinder-csi" {
+		doRegisterCinderMetrics()
+	}
 }
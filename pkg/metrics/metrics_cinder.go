@@ -0,0 +1,96 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+var (
+	cinderVolumeOperationsQueued = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name: "cinder_csi_volume_operations_queued",
+			Help: "Number of volume attach and detach operations in progress or waiting per node",
+		}, []string{"node", "operation"})
+
+	cinderVolumeOperationDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Name:    "cinder_csi_volume_operation_duration_seconds",
+			Help:    "Latency of the successful volume attach, detach, stage and unstage operations",
+			Buckets: metrics.ExponentialBuckets(0.5, 2, 10),
+		}, []string{"operation"})
+
+	cinderVolumeCreateToStageDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Name:    "cinder_csi_volume_create_to_stage_duration_seconds",
+			Help:    "Time between the creation of a volume and its successful staging on a node",
+			Buckets: metrics.ExponentialBuckets(1, 2, 14),
+		}, []string{})
+)
+
+// VolumeOperation tracks a volume operation of the Cinder CSI plugin.
+type VolumeOperation struct {
+	node      string
+	operation string
+	start     time.Time
+}
+
+// StartVolumeOperation records the start of a volume operation. If node is not
+// empty, the operation is counted as queued for the node until Done is called.
+func StartVolumeOperation(node, operation string) *VolumeOperation {
+	if node != "" {
+		cinderVolumeOperationsQueued.WithLabelValues(node, operation).Inc()
+	}
+	return &VolumeOperation{
+		node:      node,
+		operation: operation,
+		start:     time.Now(),
+	}
+}
+
+// Succeeded records the latency of the operation.
+func (o *VolumeOperation) Succeeded() {
+	cinderVolumeOperationDuration.WithLabelValues(o.operation).Observe(time.Since(o.start).Seconds())
+}
+
+// Done marks the operation as no longer queued for the node.
+func (o *VolumeOperation) Done() {
+	if o.node != "" {
+		cinderVolumeOperationsQueued.WithLabelValues(o.node, o.operation).Dec()
+	}
+}
+
+// ObserveVolumeCreateToStage records the time between the creation of a volume and its staging.
+func ObserveVolumeCreateToStage(createdAt time.Time) {
+	cinderVolumeCreateToStageDuration.WithLabelValues().Observe(time.Since(createdAt).Seconds())
+}
+
+var registerCinderMetrics sync.Once
+
+func doRegisterCinderMetrics() {
+	registerCinderMetrics.Do(func() {
+		legacyregistry.MustRegister(
+			cinderVolumeOperationsQueued,
+			cinderVolumeOperationDuration,
+			cinderVolumeCreateToStageDuration,
+		)
+	})
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func TestVolumeOperation(t *testing.T) {
+	doRegisterCinderMetrics()
+
+	queued := func() float64 {
+		v, err := testutil.GetGaugeMetricValue(cinderVolumeOperationsQueued.WithLabelValues("node-1", "attach"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	observed := func(operation string) uint64 {
+		n, err := testutil.GetHistogramMetricCount(cinderVolumeOperationDuration.WithLabelValues(operation))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	attaches := observed("attach")
+
+	op1 := StartVolumeOperation("node-1", "attach")
+	op2 := StartVolumeOperation("node-1", "attach")
+	if v := queued(); v != 2 {
+		t.Errorf("expected 2 queued attach operations, got %v", v)
+	}
+
+	// A failed operation is no longer queued, but its latency isn't recorded
+	op1.Done()
+	if v := queued(); v != 1 {
+		t.Errorf("expected 1 queued attach operation, got %v", v)
+	}
+	if n := observed("attach"); n != attaches {
+		t.Errorf("expected the failed attach not to be observed, got %d observations", n-attaches)
+	}
+
+	op2.Succeeded()
+	op2.Done()
+	if v := queued(); v != 0 {
+		t.Errorf("expected no queued attach operation, got %v", v)
+	}
+	if n := observed("attach"); n != attaches+1 {
+		t.Errorf("expected the successful attach to be observed, got %d observations", n-attaches)
+	}
+
+	// The node operations aren't queued per node
+	stages := observed("stage")
+	op := StartVolumeOperation("", "stage")
+	op.Succeeded()
+	op.Done()
+	if n := observed("stage"); n != stages+1 {
+		t.Errorf("expected the stage to be observed, got %d observations", n-stages)
+	}
+}
+
+func TestObserveVolumeCreateToStage(t *testing.T) {
+	doRegisterCinderMetrics()
+
+	before, err := testutil.GetHistogramMetricCount(cinderVolumeCreateToStageDuration.WithLabelValues())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ObserveVolumeCreateToStage(time.Now().Add(-time.Minute))
+
+	after, err := testutil.GetHistogramMetricCount(cinderVolumeCreateToStageDuration.WithLabelValues())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after != before+1 {
+		t.Errorf("expected one observation, got %d", after-before)
+	}
+
+	sum, err := testutil.GetHistogramMetricValue(cinderVolumeCreateToStageDuration.WithLabelValues())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum < 60 {
+		t.Errorf("expected the observed latency to be at least 60s, got %v", sum)
+	}
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"sync"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+var (
+	ingressDriftRepairs = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Name: "octavia_ingress_drift_repairs_total",
+			Help: "Total number of Octavia resources created or deleted by the octavia-ingress-controller resync to repair out-of-band changes",
+		}, []string{"resource"})
+)
+
+// AddIngressDriftRepairs records the number of Octavia resources of the given type repaired by the resync
+func AddIngressDriftRepairs(resource string, count int) {
+	ingressDriftRepairs.WithLabelValues(resource).Add(float64(count))
+}
+
+var registerIngressMetrics sync.Once
+
+func doRegisterIngressMetrics() {
+	registerIngressMetrics.Do(func() {
+		legacyregistry.MustRegister(
+			ingressDriftRepairs,
+		)
+	})
+}
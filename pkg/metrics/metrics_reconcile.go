@@ -42,8 +42,54 @@ var (
 				Help: "Total number of OpenStack cloud controller manager reconciliation errors",
 			}, []string{"operation"}),
 	}
+
+	floatingIPsAvailable = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name: "cloudprovider_openstack_floating_ips_available",
+			Help: "Number of IP addresses still available for floating IPs on the external networks used by load balancers",
+		}, []string{"network_id"})
+
+	vipSubnetIPsAvailable = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name: "cloudprovider_openstack_vip_subnet_ips_available",
+			Help: "Number of IP addresses still available on the VIP subnets when creating load balancers",
+		}, []string{"subnet_id"})
+
+	vipSubnetExhausted = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Name: "cloudprovider_openstack_vip_subnet_exhausted_total",
+			Help: "Total number of load balancer creations finding the VIP subnet without available IP address",
+		}, []string{"subnet_id"})
+
+	quarantinedServices = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name: "cloudprovider_openstack_quarantined_services",
+			Help: "Services whose load balancer reconciliations are quarantined after consecutive failures",
+		}, []string{"namespace", "service"})
 )
 
+// SetFloatingIPsAvailable records the number of available floating IPs of the external network
+func SetFloatingIPsAvailable(networkID string, available float64) {
+	floatingIPsAvailable.WithLabelValues(networkID).Set(available)
+}
+
+// SetVIPSubnetIPsAvailable records the number of available IPs of the VIP subnet
+func SetVIPSubnetIPsAvailable(subnetID string, available float64) {
+	vipSubnetIPsAvailable.WithLabelValues(subnetID).Set(available)
+	if available <= 0 {
+		vipSubnetExhausted.WithLabelValues(subnetID).Inc()
+	}
+}
+
+// SetServiceQuarantined records whether the load balancer of the Service is quarantined
+func SetServiceQuarantined(namespace, service string, quarantined bool) {
+	if quarantined {
+		quarantinedServices.WithLabelValues(namespace, service).Set(1)
+	} else {
+		quarantinedServices.DeleteLabelValues(namespace, service)
+	}
+}
+
 // ObserveReconcile records the request reconciliation duration
 func (mc *MetricContext) ObserveReconcile(err error) error {
 	return mc.Observe(occmReconcileMetrics, err)
@@ -58,6 +104,10 @@ func doRegisterOccmMetrics() {
 			occmReconcileMetrics.Duration,
 			occmReconcileMetrics.Total,
 			occmReconcileMetrics.Errors,
+			floatingIPsAvailable,
+			vipSubnetIPsAvailable,
+			vipSubnetExhausted,
+			quarantinedServices,
 		)
 	})
 }
@@ -0,0 +1,213 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+
+	gcfg "gopkg.in/gcfg.v1"
+	warnings "gopkg.in/warnings.v0"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/util"
+	"k8s.io/cloud-provider-openstack/pkg/util/metadata"
+)
+
+// unknownConfigRegexp matches the gcfg warnings about data which can't be stored in Config
+var unknownConfigRegexp = regexp.MustCompile(`^can't store data at section "([^"]*)"(?:, subsection "[^"]*")?(?:, variable "([^"]*)")?$`)
+
+// ValidateConfig strictly validates a cloud config. Unlike ReadConfig, which silently ignores them, unknown sections
+// and options are errors, e.g. a misspelled subnet_id. Options required by the enabled controllers are checked
+// as well. The deprecated options are returned as warnings.
+func ValidateConfig(config io.Reader) (warns []string, errs []error) {
+	if config == nil {
+		return nil, []error{fmt.Errorf("no OpenStack cloud provider config file given")}
+	}
+
+	data, err := io.ReadAll(config)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	// Unknown sections and options are only warnings of gcfg, reported once per pass
+	var raw Config
+	if err := gcfg.ReadInto(&raw, bytes.NewReader(data)); err != nil {
+		seen := sets.New[string]()
+		for _, w := range warnings.WarningsOnly(err) {
+			if !seen.Has(w.Error()) {
+				seen.Insert(w.Error())
+				errs = append(errs, unknownConfigError(w))
+			}
+		}
+	}
+
+	cfg, err := ReadConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, append(errs, err)
+	}
+
+	if cfg.Global.AuthURL == "" {
+		errs = append(errs, fmt.Errorf("[Global] auth-url is required"))
+	}
+	if cfg.Global.Password == "" && cfg.Global.ApplicationCredentialSecret == "" && cfg.Global.TrusteePassword == "" && cfg.Global.CertFile == "" {
+		errs = append(errs, fmt.Errorf("[Global] no credentials, one of password, application-credential-secret, trustee-password or cert-file is required"))
+	}
+
+	if err := metadata.CheckMetadataSearchOrder(cfg.Metadata.SearchOrder); err != nil {
+		errs = append(errs, fmt.Errorf("[Metadata] %v", err))
+	}
+
+	if cfg.LoadBalancer.Enabled {
+		lbWarns, lbErrs := validateLoadBalancerOpts(&cfg.LoadBalancer)
+		warns = append(warns, lbWarns...)
+		errs = append(errs, lbErrs...)
+	}
+
+	return warns, errs
+}
+
+// validateLoadBalancerOpts checks the options of the load balancer controller, its defaults applied.
+func validateLoadBalancerOpts(opts *LoadBalancerOpts) (warns []string, errs []error) {
+	if opts.LBVersion != "" {
+		if opts.LBVersion != "v2" {
+			errs = append(errs, fmt.Errorf("[LoadBalancer] unsupported lb-version %q, only v2 is supported", opts.LBVersion))
+		} else {
+			warns = append(warns, "[LoadBalancer] lb-version is deprecated, only v2 is supported")
+		}
+	}
+
+	switch {
+	case opts.LBProvider == "octavia":
+		warns = append(warns, `[LoadBalancer] lb-provider "octavia" is deprecated, use "amphora"`)
+	case !util.Contains(supportedLBProvider, opts.LBProvider):
+		warns = append(warns, fmt.Sprintf("[LoadBalancer] lb-provider %q is not officially supported, supported providers are %v", opts.LBProvider, supportedLBProvider))
+	case opts.LBProvider == "ovn" && opts.LBMethod != "SOURCE_IP_PORT":
+		errs = append(errs, fmt.Errorf(`[LoadBalancer] lb-method must be SOURCE_IP_PORT with lb-provider "ovn", got %q`, opts.LBMethod))
+	}
+
+	if !util.Contains(supportedContainerStore, opts.ContainerStore) {
+		errs = append(errs, fmt.Errorf("[LoadBalancer] unsupported container-store %q, supported values are %v", opts.ContainerStore, supportedContainerStore))
+	}
+
+	if opts.SecurityGroupResyncPeriod.Duration > 0 && !opts.ManageSecurityGroups {
+		warns = append(warns, "[LoadBalancer] security-group-resync-period has no effect without manage-security-groups")
+	}
+
+	return warns, errs
+}
+
+// unknownConfigError turns a gcfg warning about an unknown section or option into an error suggesting the option
+// which was probably meant.
+func unknownConfigError(w error) error {
+	m := unknownConfigRegexp.FindStringSubmatch(w.Error())
+	if m == nil {
+		return w
+	}
+
+	section, option := m[1], m[2]
+	if option == "" {
+		return fmt.Errorf("unknown section [%s]", section)
+	}
+
+	if known := suggestConfigOption(section, option); known != "" {
+		return fmt.Errorf("[%s] unknown option %q, did you mean %q?", section, option, known)
+	}
+
+	return fmt.Errorf("[%s] unknown option %q", section, option)
+}
+
+// suggestConfigOption returns the option of the section closest to option, or "" if none is close enough.
+func suggestConfigOption(section, option string) string {
+	field, ok := reflect.TypeOf(Config{}).FieldByNameFunc(func(name string) bool {
+		return strings.EqualFold(name, section)
+	})
+	if !ok {
+		return ""
+	}
+
+	t := field.Type
+	for t.Kind() == reflect.Map || t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return ""
+	}
+
+	normalize := func(s string) string {
+		return strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(s))
+	}
+
+	best, bestDistance := "", 3
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("gcfg"), ",")
+		if name == "" {
+			name = strings.ToLower(t.Field(i).Name)
+		}
+
+		if d := editDistance(normalize(name), normalize(option)); d < bestDistance {
+			best, bestDistance = name, d
+		}
+	}
+
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+
+	return prev[len(b)]
+}
+
+// checkConfig logs the problems found by ValidateConfig at startup. They are fatal with --strict-config.
+func checkConfig(data []byte) error {
+	warns, errs := ValidateConfig(bytes.NewReader(data))
+	for _, w := range warns {
+		klog.Warningf("Config: %s", w)
+	}
+	for _, err := range errs {
+		klog.Warningf("Config error: %v", err)
+	}
+
+	if strictConfig && len(errs) > 0 {
+		return fmt.Errorf("invalid config, %d error(s) found, see the logs or run validate-config", len(errs))
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateConfig(t *testing.T) {
+	const global = `
+[Global]
+auth-url = http://auth.url
+user-id = user
+password = mypass
+tenant-name = demo
+`
+
+	tests := []struct {
+		name          string
+		config        string
+		expectedWarns []string
+		expectedErrs  []string
+	}{
+		{
+			name:   "valid",
+			config: global + "[LoadBalancer]\nsubnet-id = subnet\n",
+		},
+		{
+			name:         "misspelled option",
+			config:       global + "[LoadBalancer]\nsubnt-id = subnet\nfloating-network = net\n",
+			expectedErrs: []string{`[LoadBalancer] unknown option "subnt-id", did you mean "subnet-id"?`, `[LoadBalancer] unknown option "floating-network", did you mean "floating-network-id"?`},
+		},
+		{
+			name:         "unknown option",
+			config:       global + "[Networking]\nfoo = bar\n",
+			expectedErrs: []string{`[Networking] unknown option "foo"`},
+		},
+		{
+			name:         "unknown section",
+			config:       global + "[Loadbalancer-v2]\nsubnet-id = subnet\n",
+			expectedErrs: []string{"unknown section [Loadbalancer-v2]"},
+		},
+		{
+			name:         "missing auth-url and credentials",
+			config:       "[Global]\nuser-id = user\n",
+			expectedErrs: []string{"[Global] auth-url is required", "[Global] no credentials, one of password, application-credential-secret, trustee-password or cert-file is required"},
+		},
+		{
+			name:          "deprecated options",
+			config:        global + "[LoadBalancer]\nlb-version = v2\nlb-provider = octavia\n",
+			expectedWarns: []string{"[LoadBalancer] lb-version is deprecated, only v2 is supported", `[LoadBalancer] lb-provider "octavia" is deprecated, use "amphora"`},
+		},
+		{
+			name:         "ovn lb-method",
+			config:       global + "[LoadBalancer]\nlb-provider = ovn\n",
+			expectedErrs: []string{`[LoadBalancer] lb-method must be SOURCE_IP_PORT with lb-provider "ovn", got "ROUND_ROBIN"`},
+		},
+		{
+			name:   "disabled load balancer",
+			config: global + "[LoadBalancer]\nenabled = false\nlb-provider = ovn\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			warns, errs := ValidateConfig(strings.NewReader(test.config))
+
+			var errMsgs []string
+			for _, err := range errs {
+				errMsgs = append(errMsgs, err.Error())
+			}
+
+			assert.Equal(t, test.expectedWarns, warns)
+			assert.Equal(t, test.expectedErrs, errMsgs)
+		})
+	}
+}
+
+func TestValidateConfigFatal(t *testing.T) {
+	_, errs := ValidateConfig(nil)
+	assert.Len(t, errs, 1)
+
+	_, errs = ValidateConfig(strings.NewReader("[LoadBalancer]\nsubnet_id = subnet\n"))
+	assert.Len(t, errs, 1)
+}
@@ -23,4 +23,14 @@ const (
 	eventLBAZIgnored                   = "LoadBalancerAvailabilityZonesIgnored"
 	eventLBFloatingIPSkipped           = "LoadBalancerFloatingIPSkipped"
 	eventLBRename                      = "LoadBalancerRename"
+	eventLBSecurityGroupDrift          = "LoadBalancerSecurityGroupDrift"
+	eventLBFailover                    = "LoadBalancerFailover"
+	eventLBFloatingIPExhausted         = "LoadBalancerFloatingIPExhausted"
+	eventLBVIPSubnetExhausted          = "LoadBalancerVIPSubnetExhausted"
+	eventLBVIPSubnetFallback           = "LoadBalancerVIPSubnetFallback"
+	eventLBExternalIPsIgnored          = "LoadBalancerExternalIPsIgnored"
+	eventLBDNSRecordConflict           = "LoadBalancerDNSRecordConflict"
+	eventLBDNSRecordDeleteFailed       = "LoadBalancerDNSRecordDeleteFailed"
+	eventLBQuarantined                 = "LoadBalancerQuarantined"
+	eventLBStuck                       = "LoadBalancerStuck"
 )
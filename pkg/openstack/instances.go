@@ -155,6 +155,7 @@ func (os *OpenStack) instances() (*Instances, bool) {
 		klog.Errorf("unable to access network v2 API : %v", err)
 		return nil, false
 	}
+	os.setComponent(componentInstances, compute, network)
 
 	regionalProviderID := false
 	if isRegionalProviderID := sysos.Getenv(RegionalProviderIDEnv); isRegionalProviderID == "true" {
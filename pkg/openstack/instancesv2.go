@@ -62,6 +62,7 @@ func (os *OpenStack) instancesv2() (*InstancesV2, bool) {
 		klog.Errorf("unable to access network v2 API : %v", err)
 		return nil, false
 	}
+	os.setComponent(componentInstances, compute, network)
 
 	regionalProviderID := false
 	if isRegionalProviderID := sysos.Getenv(RegionalProviderIDEnv); isRegionalProviderID == "true" {
@@ -149,6 +150,36 @@ func (i *InstancesV2) makeInstanceID(srv *servers.Server) string {
 	return fmt.Sprintf("%s:///%s", ProviderName, srv.ID)
 }
 
+// getInstanceByMetadata returns the server whose metadata key is set to the given value.
+func (i *InstancesV2) getInstanceByMetadata(key, value string) (*ServerAttributesExt, error) {
+	mc := metrics.NewMetricContext("server", "list")
+	allPages, err := servers.List(i.compute, servers.ListOpts{}).AllPages()
+	if mc.ObserveRequest(err) != nil {
+		return nil, fmt.Errorf("error listing servers: %v", err)
+	}
+
+	serverList := []ServerAttributesExt{}
+	err = servers.ExtractServersInto(allPages, &serverList)
+	if err != nil {
+		return nil, fmt.Errorf("error extracting servers from pages: %v", err)
+	}
+
+	var found *ServerAttributesExt
+	for idx := range serverList {
+		if serverList[idx].Metadata[key] != value {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("getInstanceByMetadata: multiple instances found")
+		}
+		found = &serverList[idx]
+	}
+	if found == nil {
+		return nil, cloudprovider.InstanceNotFound
+	}
+	return found, nil
+}
+
 func (i *InstancesV2) getInstance(ctx context.Context, node *v1.Node) (*ServerAttributesExt, error) {
 	if node.Spec.ProviderID == "" {
 		opt := servers.ListOpts{
@@ -21,6 +21,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -69,6 +70,7 @@ const (
 	ServiceAnnotationLoadBalancerSubnetID             = "loadbalancer.openstack.org/subnet-id"
 	ServiceAnnotationLoadBalancerNetworkID            = "loadbalancer.openstack.org/network-id"
 	ServiceAnnotationLoadBalancerMemberSubnetID       = "loadbalancer.openstack.org/member-subnet-id"
+	ServiceAnnotationLoadBalancerMemberPortSelector   = "loadbalancer.openstack.org/member-port-selector"
 	ServiceAnnotationLoadBalancerTimeoutClientData    = "loadbalancer.openstack.org/timeout-client-data"
 	ServiceAnnotationLoadBalancerTimeoutMemberConnect = "loadbalancer.openstack.org/timeout-member-connect"
 	ServiceAnnotationLoadBalancerTimeoutMemberData    = "loadbalancer.openstack.org/timeout-member-data"
@@ -83,14 +85,25 @@ const (
 	ServiceAnnotationLoadBalancerHealthMonitorTimeout        = "loadbalancer.openstack.org/health-monitor-timeout"
 	ServiceAnnotationLoadBalancerHealthMonitorMaxRetries     = "loadbalancer.openstack.org/health-monitor-max-retries"
 	ServiceAnnotationLoadBalancerHealthMonitorMaxRetriesDown = "loadbalancer.openstack.org/health-monitor-max-retries-down"
-	ServiceAnnotationLoadBalancerLoadbalancerHostname        = "loadbalancer.openstack.org/hostname"
-	ServiceAnnotationLoadBalancerAddress                     = "loadbalancer.openstack.org/load-balancer-address"
+	// ServiceAnnotationLoadBalancerHealthMonitorPaused keeps the health monitors of the load balancer pools but sets them
+	// administratively down, so members are not marked offline during planned node maintenance.
+	ServiceAnnotationLoadBalancerHealthMonitorPaused  = "loadbalancer.openstack.org/health-monitor-paused"
+	ServiceAnnotationLoadBalancerLoadbalancerHostname = "loadbalancer.openstack.org/hostname"
+	ServiceAnnotationLoadBalancerDNSZone              = "loadbalancer.openstack.org/dns-zone"
+	// ServiceAnnotationLoadBalancerDNSRecordZone is set by the OCCM to the Designate zone of the records created
+	// for the Service hostname, so that they are deleted even if the zone of the Service changes.
+	ServiceAnnotationLoadBalancerDNSRecordZone = "loadbalancer.openstack.org/dns-record-zone"
+	ServiceAnnotationLoadBalancerAddress       = "loadbalancer.openstack.org/load-balancer-address"
 	// revive:disable:var-naming
 	ServiceAnnotationTlsContainerRef = "loadbalancer.openstack.org/default-tls-container-ref"
 	// revive:enable:var-naming
 	// See https://nip.io
 	defaultProxyHostnameSuffix      = "nip.io"
 	ServiceAnnotationLoadBalancerID = "loadbalancer.openstack.org/load-balancer-id"
+	// ServiceAnnotationLoadBalancerTriggerFailover triggers the failover of the load balancer amphorae whenever its
+	// value (e.g. a timestamp) changes. The last processed value is kept in ServiceAnnotationLoadBalancerLastFailover.
+	ServiceAnnotationLoadBalancerTriggerFailover = "loadbalancer.openstack.org/trigger-failover"
+	ServiceAnnotationLoadBalancerLastFailover    = "loadbalancer.openstack.org/last-failover"
 
 	// Octavia resources name formats
 	servicePrefix  = "kube_service_"
@@ -135,12 +148,18 @@ type serviceConfig struct {
 	lbID                        string
 	lbName                      string
 	supportLBTags               bool
+	labelTags                   []string
+	labelDescription            string
 	healthCheckNodePort         int
 	healthMonitorDelay          int
 	healthMonitorTimeout        int
 	healthMonitorMaxRetries     int
 	healthMonitorMaxRetriesDown int
+	healthMonitorPaused         bool
 	preferredIPFamily           corev1.IPFamily // preferred (the first) IP family indicated in service's `spec.ipFamilies`
+	additionalVIPs              []openstackutil.AdditionalVIP
+	memberPortSelector          *memberPortSelector
+	endpointNodes               sets.Set[string] // nodes of the ready endpoints, see LoadBalancerOpts.EndpointMemberSync
 }
 
 type listenerKey struct {
@@ -270,6 +289,19 @@ func (lbaas *LbaasV2) createOctaviaLoadBalancer(name, clusterName string, servic
 		}
 	}
 
+	if createOpts.VipSubnetID != "" && createOpts.VipAddress == "" {
+		subnetID, err := lbaas.selectVIPSubnet(service, createOpts.VipSubnetID, vipSubnetFallbackAllowed(service, lbClass))
+		if err != nil {
+			return nil, err
+		}
+		if subnetID != createOpts.VipSubnetID {
+			// The network is inferred from the fallback subnet
+			createOpts.VipSubnetID = subnetID
+			createOpts.VipNetworkID = ""
+			svcConf.lbSubnetID = subnetID
+		}
+	}
+
 	if !lbaas.opts.ProviderRequiresSerialAPICalls {
 		for portIndex, port := range service.Spec.Ports {
 			listenerCreateOpt := lbaas.buildListenerCreateOpt(port, svcConf, cpoutil.Sprintf255(listenerFormat, portIndex, name))
@@ -293,11 +325,19 @@ func (lbaas *LbaasV2) createOctaviaLoadBalancer(name, clusterName string, servic
 		}
 	}
 
+	var lbCreateOpts loadbalancers.CreateOptsBuilder = createOpts
+	if len(svcConf.additionalVIPs) > 0 {
+		lbCreateOpts = openstackutil.LoadBalancerCreateOpts{
+			CreateOpts:     createOpts,
+			AdditionalVIPs: svcConf.additionalVIPs,
+		}
+	}
+
 	mc := metrics.NewMetricContext("loadbalancer", "create")
-	loadbalancer, err := loadbalancers.Create(lbaas.lb, createOpts).Extract()
+	loadbalancer, err := loadbalancers.Create(lbaas.lb, lbCreateOpts).Extract()
 	if mc.ObserveRequest(err) != nil {
-		var printObj interface{} = createOpts
-		if opts, err := json.Marshal(createOpts); err == nil {
+		var printObj interface{} = lbCreateOpts
+		if opts, err := json.Marshal(lbCreateOpts); err == nil {
 			printObj = string(opts)
 		}
 		return nil, fmt.Errorf("error creating loadbalancer %v: %v", printObj, err)
@@ -345,7 +385,10 @@ func (lbaas *LbaasV2) GetLoadBalancer(ctx context.Context, clusterName string, s
 
 	status := &corev1.LoadBalancerStatus{}
 	portID := loadbalancer.VipPortID
-	if portID != "" {
+	if netutils.IsIPv6String(loadbalancer.VipAddress) {
+		// Floating IPs are IPv4 only, an IPv6 VIP is always advertised as is.
+		status.Ingress = []corev1.LoadBalancerIngress{{IP: loadbalancer.VipAddress}}
+	} else if portID != "" {
 		floatIP, err := openstackutil.GetFloatingIPByPortID(lbaas.network, portID)
 		if err != nil {
 			return nil, false, fmt.Errorf("failed when trying to get floating IP for port %s: %v", portID, err)
@@ -491,7 +534,7 @@ func getSubnetIDForLB(network *gophercloud.ServiceClient, node corev1.Node, pref
 
 	for _, port := range ports {
 		for _, fixedIP := range port.FixedIPs {
-			if fixedIP.IPAddress == ipAddress {
+			if sameIP(fixedIP.IPAddress, ipAddress) {
 				return fixedIP.SubnetID, nil
 			}
 		}
@@ -584,6 +627,77 @@ func (lbaas *LbaasV2) createFloatingIP(msg string, floatIPOpts floatingips.Creat
 	return floatIP, err
 }
 
+// isFloatingIPExhausted checks whether the floating IP creation failed because the floating network has no IP
+// addresses left.
+func isFloatingIPExhausted(err error) bool {
+	return strings.Contains(err.Error(), "IpAddressGenerationFailure") || strings.Contains(err.Error(), "No more IP addresses available")
+}
+
+// checkFloatingIPExhausted emits a specific event if the floating IP creation failed because the floating network has
+// no IP addresses left, as that can't be fixed by retrying.
+func (lbaas *LbaasV2) checkFloatingIPExhausted(service *corev1.Service, networkID string, err error) {
+	if !isFloatingIPExhausted(err) {
+		return
+	}
+	msg := "No floating IP address available on network %s for Service %s/%s"
+	lbaas.eventRecorder.Eventf(service, corev1.EventTypeWarning, eventLBFloatingIPExhausted, msg, networkID, service.Namespace, service.Name)
+	klog.Warningf(msg, networkID, service.Namespace, service.Name)
+}
+
+// vipSubnetFallbackAllowed returns true if the VIP subnet isn't explicitly requested for the Service, in which case
+// the fallback-subnet-id subnets can be used.
+func vipSubnetFallbackAllowed(service *corev1.Service, lbClass *LBClass) bool {
+	if getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerSubnetID, "") != "" ||
+		getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerNetworkID, "") != "" {
+		return false
+	}
+	return lbClass == nil || (lbClass.SubnetID == "" && lbClass.NetworkID == "")
+}
+
+// vipSubnetCandidates returns the VIP subnet followed by the fallback subnets, without duplicates.
+func vipSubnetCandidates(subnetID string, fallbackSubnetIDs []string) []string {
+	candidates := []string{subnetID}
+	for _, id := range fallbackSubnetIDs {
+		if id != "" && !slices.Contains(candidates, id) {
+			candidates = append(candidates, id)
+		}
+	}
+	return candidates
+}
+
+// selectVIPSubnet checks that the VIP subnet has IP addresses left before creating the load balancer, and if allowed
+// falls back to the first fallback subnet having some. The subnet is used as is if its availability can't be checked,
+// e.g. without admin privileges.
+func (lbaas *LbaasV2) selectVIPSubnet(service *corev1.Service, subnetID string, allowFallback bool) (string, error) {
+	candidates := []string{subnetID}
+	if allowFallback {
+		candidates = vipSubnetCandidates(subnetID, lbaas.opts.FallbackSubnetIDs)
+	}
+
+	for _, id := range candidates {
+		available, err := openstackutil.GetSubnetAvailableIPCount(lbaas.network, id)
+		if err != nil {
+			klog.V(4).Infof("Failed to get the available IP addresses of subnet %s, using it for Service %s/%s: %v", id, service.Namespace, service.Name, err)
+			return id, nil
+		}
+		metrics.SetVIPSubnetIPsAvailable(id, available)
+		if available > 0 {
+			if id != subnetID {
+				msg := "VIP subnet %s has no IP address left, using fallback subnet %s for Service %s/%s"
+				lbaas.eventRecorder.Eventf(service, corev1.EventTypeNormal, eventLBVIPSubnetFallback, msg, subnetID, id, service.Namespace, service.Name)
+				klog.Infof(msg, subnetID, id, service.Namespace, service.Name)
+			}
+			return id, nil
+		}
+
+		msg := "No IP address available on VIP subnet %s for Service %s/%s"
+		lbaas.eventRecorder.Eventf(service, corev1.EventTypeWarning, eventLBVIPSubnetExhausted, msg, id, service.Namespace, service.Name)
+		klog.Warningf(msg, id, service.Namespace, service.Name)
+	}
+
+	return "", fmt.Errorf("no IP address available on VIP subnets %s for Service %s/%s", strings.Join(candidates, ", "), service.Namespace, service.Name)
+}
+
 func (lbaas *LbaasV2) updateFloatingIP(floatingip *floatingips.FloatingIP, portID *string) (*floatingips.FloatingIP, error) {
 	floatUpdateOpts := floatingips.UpdateOpts{
 		PortID: portID,
@@ -722,6 +836,7 @@ func (lbaas *LbaasV2) ensureFloatingIP(clusterName string, service *corev1.Servi
 					klog.V(2).Infof("cannot use subnet %s: %v", subnet.Name, err)
 				}
 				if err != nil {
+					lbaas.checkFloatingIPExhausted(service, svcConf.lbPublicNetworkID, err)
 					return "", fmt.Errorf("no free subnet matching %q found for network %s (last error %v)",
 						svcConf.lbPublicSubnetSpec, svcConf.lbPublicNetworkID, err)
 				}
@@ -733,6 +848,7 @@ func (lbaas *LbaasV2) ensureFloatingIP(clusterName string, service *corev1.Servi
 				floatIPOpts.FloatingIP = loadBalancerIP
 				floatIP, err = lbaas.createFloatingIP("Creating", floatIPOpts)
 				if err != nil {
+					lbaas.checkFloatingIPExhausted(service, svcConf.lbPublicNetworkID, err)
 					return "", err
 				}
 				klog.V(2).Infof("Successfully created floating IP %s for loadbalancer %s", floatIP.FloatingIP, lb.ID)
@@ -790,17 +906,20 @@ func (lbaas *LbaasV2) ensureOctaviaHealthMonitor(lbID string, name string, pool
 	}
 
 	// update new monitor parameters
+	adminStateUp := !svcConf.healthMonitorPaused
 	if name != monitor.Name ||
 		svcConf.healthMonitorDelay != monitor.Delay ||
 		svcConf.healthMonitorTimeout != monitor.Timeout ||
 		svcConf.healthMonitorMaxRetries != monitor.MaxRetries ||
-		svcConf.healthMonitorMaxRetriesDown != monitor.MaxRetriesDown {
+		svcConf.healthMonitorMaxRetriesDown != monitor.MaxRetriesDown ||
+		adminStateUp != monitor.AdminStateUp {
 		updateOpts := v2monitors.UpdateOpts{
 			Name:           &name,
 			Delay:          svcConf.healthMonitorDelay,
 			Timeout:        svcConf.healthMonitorTimeout,
 			MaxRetries:     svcConf.healthMonitorMaxRetries,
 			MaxRetriesDown: svcConf.healthMonitorMaxRetriesDown,
+			AdminStateUp:   &adminStateUp,
 		}
 		klog.Infof("Updating health monitor %s updateOpts %+v", monitorID, updateOpts)
 		return openstackutil.UpdateHealthMonitor(lbaas.lb, monitorID, updateOpts, lbID)
@@ -842,6 +961,10 @@ func (lbaas *LbaasV2) buildMonitorCreateOpts(svcConf *serviceConfig, port corev1
 		opts.HTTPMethod = "GET"
 		opts.ExpectedCodes = "200"
 	}
+	if svcConf.healthMonitorPaused {
+		adminStateUp := false
+		opts.AdminStateUp = &adminStateUp
+	}
 	return opts
 }
 
@@ -893,13 +1016,18 @@ func (lbaas *LbaasV2) ensureOctaviaPool(lbID string, name string, listener *list
 			return nil, err
 		}
 		klog.V(2).Infof("Pool %s created for listener %s", pool.ID, listener.ID)
+	} else if err := lbaas.updatePoolLabels(lbID, pool, svcConf); err != nil {
+		return nil, err
 	}
 
+	// Only the nodes of the ready endpoints are members if the endpoint member sync is enabled
+	nodes = withEndpoints(nodes, svcConf.endpointNodes)
+
 	if lbaas.opts.ProviderRequiresSerialAPICalls {
 		klog.V(2).Infof("Using serial API calls to update members for pool %s", pool.ID)
 		var nodePort int = int(port.NodePort)
 
-		if err := openstackutil.SeriallyReconcilePoolMembers(lbaas.lb, pool, nodePort, lbID, nodes); err != nil {
+		if err := openstackutil.SeriallyReconcilePoolMembers(lbaas.lb, pool, nodePort, lbID, withoutDrainedNodes(nodes)); err != nil {
 			return nil, err
 		}
 		return pool, nil
@@ -911,7 +1039,7 @@ func (lbaas *LbaasV2) ensureOctaviaPool(lbID string, name string, listener *list
 		klog.Errorf("failed to get members in the pool %s: %v", pool.ID, err)
 	}
 	for _, m := range poolMembers {
-		curMembers.Insert(fmt.Sprintf("%s-%s-%d-%d", m.Name, m.Address, m.ProtocolPort, m.MonitorPort))
+		curMembers.Insert(lbaas.memberKey(m.Name, m.Address, m.ProtocolPort, m.MonitorPort, m.Weight))
 	}
 
 	members, newMembers, err := lbaas.buildBatchUpdateMemberOpts(port, nodes, svcConf)
@@ -919,6 +1047,16 @@ func (lbaas *LbaasV2) ensureOctaviaPool(lbID string, name string, listener *list
 		return nil, err
 	}
 
+	// Keep the members of the removed nodes while they are drained
+	for _, m := range lbaas.drainingMembers(service, pool.ID, poolMembers, members) {
+		members = append(members, m)
+		monitorPort := 0
+		if m.MonitorPort != nil {
+			monitorPort = *m.MonitorPort
+		}
+		newMembers.Insert(lbaas.memberKey(*m.Name, m.Address, m.ProtocolPort, monitorPort, *m.Weight))
+	}
+
 	if !curMembers.Equal(newMembers) {
 		klog.V(2).Infof("Updating %d members for pool %s", len(members), pool.ID)
 		if err := openstackutil.BatchUpdatePoolMembers(lbaas.lb, lbID, pool.ID, members); err != nil {
@@ -956,12 +1094,17 @@ func (lbaas *LbaasV2) buildPoolCreateOpt(listenerProtocol string, service *corev
 	}
 
 	lbmethod := v2pools.LBMethod(lbaas.opts.LBMethod)
-	return v2pools.CreateOpts{
+	createOpt := v2pools.CreateOpts{
 		Name:        name,
 		Protocol:    poolProto,
 		LBMethod:    lbmethod,
 		Persistence: persistence,
+		Description: svcConf.labelDescription,
+	}
+	if svcConf.supportLBTags {
+		createOpt.Tags = svcConf.labelTags
 	}
+	return createOpt
 }
 
 // buildBatchUpdateMemberOpts returns v2pools.BatchUpdateMemberOpts array for Services and Nodes alongside a list of member names
@@ -969,8 +1112,8 @@ func (lbaas *LbaasV2) buildBatchUpdateMemberOpts(port corev1.ServicePort, nodes
 	var members []v2pools.BatchUpdateMemberOpts
 	newMembers := sets.New[string]()
 
-	for _, node := range nodes {
-		addr, err := nodeAddressForLB(node, svcConf.preferredIPFamily)
+	for _, node := range withoutDrainedNodes(nodes) {
+		addr, subnetID, err := memberAddressForLB(lbaas.network, node, svcConf)
 		if err != nil {
 			if err == cpoerrors.ErrNoAddressFound {
 				// Node failure, do not create member
@@ -981,9 +1124,9 @@ func (lbaas *LbaasV2) buildBatchUpdateMemberOpts(port corev1.ServicePort, nodes
 			}
 		}
 
-		memberSubnetID := &svcConf.lbMemberSubnetID
-		if memberSubnetID != nil && *memberSubnetID == "" {
-			memberSubnetID = nil
+		var memberSubnetID *string
+		if subnetID != "" {
+			memberSubnetID = &subnetID
 		}
 
 		if port.NodePort != 0 { // It's 0 when AllocateLoadBalancerNodePorts=False
@@ -996,8 +1139,13 @@ func (lbaas *LbaasV2) buildBatchUpdateMemberOpts(port corev1.ServicePort, nodes
 			if svcConf.healthCheckNodePort > 0 && lbaas.canUseHTTPMonitor(port) {
 				member.MonitorPort = &svcConf.healthCheckNodePort
 			}
+			weight := 1
+			if lbaas.memberDrains != nil {
+				// Restore the weight of the members drained before
+				member.Weight = &weight
+			}
 			members = append(members, member)
-			newMembers.Insert(fmt.Sprintf("%s-%s-%d-%d", node.Name, addr, member.ProtocolPort, svcConf.healthCheckNodePort))
+			newMembers.Insert(lbaas.memberKey(node.Name, addr, member.ProtocolPort, svcConf.healthCheckNodePort, weight))
 		}
 	}
 	return members, newMembers, nil
@@ -1027,15 +1175,21 @@ func (lbaas *LbaasV2) ensureOctaviaListener(lbID string, name string, curListene
 		updateOpts := listeners.UpdateOpts{}
 
 		if svcConf.supportLBTags {
-			if !cpoutil.Contains(listener.Tags, svcConf.lbName) {
-				var newTags []string
-				copy(newTags, listener.Tags)
+			newTags := withLabelTags(listener.Tags, svcConf.labelTags)
+			if !cpoutil.Contains(newTags, svcConf.lbName) {
 				newTags = append(newTags, svcConf.lbName)
+			}
+			if !cpoutil.StringListEqual(newTags, listener.Tags) {
 				updateOpts.Tags = &newTags
 				listenerChanged = true
 			}
 		}
 
+		if svcConf.labelDescription != "" && svcConf.labelDescription != listener.Description {
+			updateOpts.Description = &svcConf.labelDescription
+			listenerChanged = true
+		}
+
 		if svcConf.connLimit != listener.ConnLimit {
 			updateOpts.ConnLimit = &svcConf.connLimit
 			listenerChanged = true
@@ -1105,8 +1259,9 @@ func (lbaas *LbaasV2) buildListenerCreateOpt(port corev1.ServicePort, svcConf *s
 	}
 
 	if svcConf.supportLBTags {
-		listenerCreateOpt.Tags = []string{svcConf.lbName}
+		listenerCreateOpt.Tags = append([]string{svcConf.lbName}, svcConf.labelTags...)
 	}
+	listenerCreateOpt.Description = svcConf.labelDescription
 
 	if openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureTimeout, lbaas.opts.LBProvider) {
 		listenerCreateOpt.TimeoutClientData = &svcConf.timeoutClientData
@@ -1238,6 +1393,8 @@ func (lbaas *LbaasV2) checkServiceUpdate(service *corev1.Service, nodes []*corev
 
 	svcConf.lbID = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerID, "")
 	svcConf.supportLBTags = openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureTags, lbaas.opts.LBProvider)
+	svcConf.labelTags = lbaas.serviceLabelTags(service)
+	svcConf.labelDescription = lbaas.serviceLabelDescription(service, svcConf.labelTags)
 
 	// Get service node-selector annotations
 	svcConf.nodeSelectors = getKeyValueFromServiceAnnotation(service, ServiceAnnotationLoadBalancerNodeSelector, lbaas.opts.NodeSelector)
@@ -1281,6 +1438,14 @@ func (lbaas *LbaasV2) checkServiceUpdate(service *corev1.Service, nodes []*corev
 		}
 	}
 
+	svcConf.memberPortSelector, err = lbaas.getMemberPortSelector(service)
+	if err != nil {
+		return fmt.Errorf("invalid member port selector for service %s: %v", serviceName, err)
+	}
+	if svcConf.memberPortSelector != nil && svcConf.memberPortSelector.subnetID != "" {
+		svcConf.lbMemberSubnetID = svcConf.memberPortSelector.subnetID
+	}
+
 	// This affects the protocol of listener and pool
 	keepClientIP := getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerXForwardedFor, false)
 	useProxyProtocol := getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerProxyEnabled, false)
@@ -1295,10 +1460,12 @@ func (lbaas *LbaasV2) checkServiceUpdate(service *corev1.Service, nodes []*corev
 	if svcConf.enableMonitor && service.Spec.ExternalTrafficPolicy == corev1.ServiceExternalTrafficPolicyTypeLocal && service.Spec.HealthCheckNodePort > 0 {
 		svcConf.healthCheckNodePort = int(service.Spec.HealthCheckNodePort)
 	}
+	svcConf.endpointNodes = lbaas.endpointMembers.memberNodes(service)
 	svcConf.healthMonitorDelay = getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorDelay, int(lbaas.opts.MonitorDelay.Duration.Seconds()))
 	svcConf.healthMonitorTimeout = getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorTimeout, int(lbaas.opts.MonitorTimeout.Duration.Seconds()))
 	svcConf.healthMonitorMaxRetries = getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorMaxRetries, int(lbaas.opts.MonitorMaxRetries))
 	svcConf.healthMonitorMaxRetriesDown = getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorMaxRetriesDown, int(lbaas.opts.MonitorMaxRetriesDown))
+	svcConf.healthMonitorPaused = getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorPaused, false)
 	return nil
 }
 
@@ -1426,6 +1593,14 @@ func (lbaas *LbaasV2) checkService(service *corev1.Service, nodes []*corev1.Node
 		svcConf.lbMemberSubnetID = memberSubnetID
 	}
 
+	svcConf.memberPortSelector, err = lbaas.getMemberPortSelector(service)
+	if err != nil {
+		return fmt.Errorf("invalid member port selector for service %s: %v", serviceName, err)
+	}
+	if svcConf.memberPortSelector != nil && svcConf.memberPortSelector.subnetID != "" {
+		svcConf.lbMemberSubnetID = svcConf.memberPortSelector.subnetID
+	}
+
 	if !svcConf.internal {
 		var lbClass *LBClass
 		var floatingNetworkID string
@@ -1456,8 +1631,9 @@ func (lbaas *LbaasV2) checkService(service *corev1.Service, nodes []*corev1.Node
 			floatingNetworkID = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerFloatingNetworkID, lbaas.opts.FloatingNetworkID)
 		}
 
-		// If there's no annotation and configuration, try to autodetect the FIP network by looking up external nets
-		if floatingNetworkID == "" {
+		// If there's no annotation and configuration, try to autodetect the FIP network by looking up external nets.
+		// Floating IPs are IPv4 only, so there is nothing to look up for an IPv6 Service.
+		if floatingNetworkID == "" && svcConf.preferredIPFamily != corev1.IPv6Protocol {
 			floatingNetworkID, err = openstackutil.GetFloatingNetworkID(lbaas.network)
 			if err != nil {
 				msg := "Failed to find floating-network-id for Service %s: %v"
@@ -1557,11 +1733,14 @@ func (lbaas *LbaasV2) checkService(service *corev1.Service, nodes []*corev1.Node
 	if svcConf.enableMonitor && service.Spec.ExternalTrafficPolicy == corev1.ServiceExternalTrafficPolicyTypeLocal && service.Spec.HealthCheckNodePort > 0 {
 		svcConf.healthCheckNodePort = int(service.Spec.HealthCheckNodePort)
 	}
+	svcConf.endpointNodes = lbaas.endpointMembers.memberNodes(service)
 	svcConf.healthMonitorDelay = getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorDelay, int(lbaas.opts.MonitorDelay.Duration.Seconds()))
 	svcConf.healthMonitorTimeout = getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorTimeout, int(lbaas.opts.MonitorTimeout.Duration.Seconds()))
 	svcConf.healthMonitorMaxRetries = getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorMaxRetries, int(lbaas.opts.MonitorMaxRetries))
 	svcConf.healthMonitorMaxRetriesDown = getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorMaxRetriesDown, int(lbaas.opts.MonitorMaxRetriesDown))
-	return nil
+	svcConf.healthMonitorPaused = getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorPaused, false)
+
+	return lbaas.checkExternalIPs(service, svcConf)
 }
 
 // checkListenerPorts checks if there is conflict for ports.
@@ -1645,7 +1824,7 @@ func (lbaas *LbaasV2) ensureOctaviaLoadBalancer(ctx context.Context, clusterName
 
 	// Check the load balancer in the Service annotation.
 	if svcConf.lbID != "" {
-		loadbalancer, err = openstackutil.GetLoadbalancerByID(lbaas.lb, svcConf.lbID)
+		loadbalancer, err = lbaas.getLoadbalancerByID(svcConf.lbID)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get load balancer %s: %v", svcConf.lbID, err)
 		}
@@ -1693,7 +1872,7 @@ func (lbaas *LbaasV2) ensureOctaviaLoadBalancer(ctx context.Context, clusterName
 		}
 	} else {
 		legacyName := lbaas.getLoadBalancerLegacyName(ctx, clusterName, service)
-		loadbalancer, err = getLoadbalancerByName(lbaas.lb, lbName, legacyName)
+		loadbalancer, err = lbaas.getLoadbalancerByName(lbName, legacyName)
 		if err != nil {
 			if err != cpoerrors.ErrNotFound {
 				return nil, fmt.Errorf("error getting loadbalancer for Service %s: %v", serviceName, err)
@@ -1713,8 +1892,9 @@ func (lbaas *LbaasV2) ensureOctaviaLoadBalancer(ctx context.Context, clusterName
 	lbaas.updateServiceAnnotation(service, ServiceAnnotationLoadBalancerID, loadbalancer.ID)
 
 	if loadbalancer.ProvisioningStatus != activeStatus {
-		return nil, fmt.Errorf("load balancer %s is not ACTIVE, current provisioning status: %s", loadbalancer.ID, loadbalancer.ProvisioningStatus)
+		return nil, lbaas.handleInactiveLoadBalancer(service, svcConf, loadbalancer, isLBOwner)
 	}
+	lbaas.pendingLBs.forget(loadbalancer.ID)
 
 	loadbalancer.Listeners, err = openstackutil.GetListenersByLoadBalancerID(lbaas.lb, loadbalancer.ID)
 	if err != nil {
@@ -1723,6 +1903,10 @@ func (lbaas *LbaasV2) ensureOctaviaLoadBalancer(ctx context.Context, clusterName
 
 	klog.V(4).InfoS("Load balancer ensured", "lbID", loadbalancer.ID, "isLBOwner", isLBOwner, "createNewLB", createNewLB)
 
+	if !createNewLB && len(svcConf.additionalVIPs) > 0 {
+		lbaas.checkAdditionalVIPs(service, svcConf, loadbalancer.ID, isLBOwner)
+	}
+
 	// This is an existing load balancer, either created by occm for other Services or by the user outside of cluster, or
 	// a newly created, unpopulated loadbalancer that needs populating.
 	if !createNewLB || (lbaas.opts.ProviderRequiresSerialAPICalls && createNewLB) {
@@ -1766,12 +1950,16 @@ func (lbaas *LbaasV2) ensureOctaviaLoadBalancer(ctx context.Context, clusterName
 		}
 	}
 
+	lbaas.nodeMemberships.set(ctx, service, loadbalancer.ID, lbMemberNodes(service, filteredNodes, svcConf), nodes)
+
 	addr := loadbalancer.VipAddress
 	// IPv6 Load Balancers have no support for Floating IP.
 	if netutils.IsIPv6String(addr) {
-		msg := "Floating IP not supported for IPv6 Service %s. Using IPv6 address instead %s."
-		lbaas.eventRecorder.Eventf(service, corev1.EventTypeWarning, eventLBFloatingIPSkipped, msg, serviceName, addr)
-		klog.Infof(msg, serviceName, addr)
+		if !svcConf.internal {
+			msg := "Floating IP not supported for IPv6 Service %s. Using IPv6 address instead %s."
+			lbaas.eventRecorder.Eventf(service, corev1.EventTypeWarning, eventLBFloatingIPSkipped, msg, serviceName, addr)
+			klog.Infof(msg, serviceName, addr)
+		}
 	} else {
 		addr, err = lbaas.ensureFloatingIP(clusterName, service, loadbalancer, svcConf, isLBOwner)
 		if err != nil {
@@ -1794,9 +1982,17 @@ func (lbaas *LbaasV2) ensureOctaviaLoadBalancer(ctx context.Context, clusterName
 		}
 	}
 
+	if err := lbaas.ensureLoadBalancerFailover(service, loadbalancer.ID, isLBOwner); err != nil {
+		return nil, err
+	}
+
 	// Create status the load balancer
 	status := lbaas.createLoadBalancerStatus(service, svcConf, addr)
 
+	if err := lbaas.ensureDNSRecord(clusterName, service, addr); err != nil {
+		return status, err
+	}
+
 	if lbaas.opts.ManageSecurityGroups {
 		err := lbaas.ensureAndUpdateOctaviaSecurityGroup(clusterName, service, filteredNodes, svcConf)
 		if err != nil {
@@ -1813,11 +2009,52 @@ func (lbaas *LbaasV2) ensureOctaviaLoadBalancer(ctx context.Context, clusterName
 	return status, nil
 }
 
+// ensureLoadBalancerFailover triggers the failover of the load balancer when the trigger-failover annotation has
+// a value that wasn't processed yet.
+func (lbaas *LbaasV2) ensureLoadBalancerFailover(service *corev1.Service, lbID string, isLBOwner bool) error {
+	trigger := getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerTriggerFailover, "")
+	if trigger == "" || trigger == getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerLastFailover, "") {
+		return nil
+	}
+
+	// The failover affects every Service using the load balancer, so only its owner is allowed to trigger it.
+	if !isLBOwner {
+		msg := "Failover of load balancer %s ignored as it is not owned by Service %s/%s"
+		lbaas.eventRecorder.Eventf(service, corev1.EventTypeWarning, eventLBFailover, msg, lbID, service.Namespace, service.Name)
+		klog.Warningf(msg, lbID, service.Namespace, service.Name)
+	} else {
+		klog.InfoS("Triggering load balancer failover", "lbID", lbID, "service", klog.KObj(service), "trigger", trigger)
+		if err := openstackutil.FailoverLoadBalancer(lbaas.lb, lbID); err != nil {
+			return err
+		}
+		lbaas.eventRecorder.Eventf(service, corev1.EventTypeNormal, eventLBFailover, "Triggered failover of load balancer %s", lbID)
+	}
+
+	lbaas.updateServiceAnnotation(service, ServiceAnnotationLoadBalancerLastFailover, trigger)
+
+	return nil
+}
+
 // EnsureLoadBalancer creates a new load balancer or updates the existing one.
 func (lbaas *LbaasV2) EnsureLoadBalancer(ctx context.Context, clusterName string, apiService *corev1.Service, nodes []*corev1.Node) (*corev1.LoadBalancerStatus, error) {
 	mc := metrics.NewMetricContext("loadbalancer", "ensure")
 	klog.InfoS("EnsureLoadBalancer", "cluster", clusterName, "service", klog.KObj(apiService))
-	status, err := lbaas.ensureOctaviaLoadBalancer(ctx, clusterName, apiService, nodes)
+	nodes, err := lbaas.handleNodesWithoutProviderID(ctx, nodes)
+	if err != nil {
+		return nil, mc.ObserveReconcile(err)
+	}
+	service, err := lbaas.annotationDefaults.apply(apiService)
+	if err != nil {
+		return nil, mc.ObserveReconcile(err)
+	}
+	var status *corev1.LoadBalancerStatus
+	err = lbaas.reconcileWithQuarantine(ctx, service, func() (err error) {
+		status, err = lbaas.ensureOctaviaLoadBalancer(ctx, clusterName, service, nodes)
+		return err
+	})
+	if err == nil {
+		err = lbaas.annotationDefaults.record(ctx, lbaas.kclient, apiService, service)
+	}
 	return status, mc.ObserveReconcile(err)
 }
 
@@ -1913,6 +2150,8 @@ func (lbaas *LbaasV2) updateOctaviaLoadBalancer(ctx context.Context, clusterName
 		}
 	}
 
+	lbaas.nodeMemberships.set(ctx, service, loadbalancer.ID, lbMemberNodes(service, filteredNodes, svcConf), nodes)
+
 	if lbaas.opts.ManageSecurityGroups {
 		err := lbaas.ensureAndUpdateOctaviaSecurityGroup(clusterName, service, filteredNodes, svcConf)
 		if err != nil {
@@ -1929,14 +2168,34 @@ func (lbaas *LbaasV2) updateOctaviaLoadBalancer(ctx context.Context, clusterName
 // UpdateLoadBalancer updates hosts under the specified load balancer.
 func (lbaas *LbaasV2) UpdateLoadBalancer(ctx context.Context, clusterName string, service *corev1.Service, nodes []*corev1.Node) error {
 	mc := metrics.NewMetricContext("loadbalancer", "update")
-	err := lbaas.updateOctaviaLoadBalancer(ctx, clusterName, service, nodes)
+	nodes, err := lbaas.handleNodesWithoutProviderID(ctx, nodes)
+	if err != nil {
+		return mc.ObserveReconcile(err)
+	}
+	service, err = lbaas.annotationDefaults.apply(service)
+	if err != nil {
+		return mc.ObserveReconcile(err)
+	}
+	err = lbaas.reconcileWithQuarantine(ctx, service, func() error {
+		return lbaas.updateOctaviaLoadBalancer(ctx, clusterName, service, nodes)
+	})
 	return mc.ObserveReconcile(err)
 }
 
 // EnsureLoadBalancerDeleted deletes the specified load balancer
 func (lbaas *LbaasV2) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *corev1.Service) error {
 	mc := metrics.NewMetricContext("loadbalancer", "delete")
-	err := lbaas.ensureLoadBalancerDeleted(ctx, clusterName, service)
+	service, err := lbaas.annotationDefaults.applyRecorded(service)
+	if err != nil {
+		return mc.ObserveReconcile(err)
+	}
+	err = lbaas.reconcileWithQuarantine(ctx, service, func() error {
+		return lbaas.ensureLoadBalancerDeleted(ctx, clusterName, service)
+	})
+	if err == nil {
+		lbaas.nodeMemberships.remove(ctx, service)
+		lbaas.quarantine.forget(service)
+	}
 	return mc.ObserveReconcile(err)
 }
 
@@ -2052,6 +2311,8 @@ func (lbaas *LbaasV2) ensureLoadBalancerDeleted(ctx context.Context, clusterName
 	}
 	svcConf.lbName = lbName
 
+	lbaas.ensureDNSRecordsDeleted(clusterName, service)
+
 	if svcConf.lbID != "" {
 		loadbalancer, err = openstackutil.GetLoadbalancerByID(lbaas.lb, svcConf.lbID)
 	} else {
@@ -0,0 +1,351 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	cpoutil "k8s.io/cloud-provider-openstack/pkg/util"
+)
+
+// ServiceAnnotationLoadBalancerAppliedDefaults records the annotations defaulted by the LoadBalancerDefaults the last
+// time the load balancer of the Service was reconciled, as a JSON object. They are used to delete the load balancer,
+// so that it is deleted the way it was created even if the LoadBalancerDefaults changed or were deleted since.
+const ServiceAnnotationLoadBalancerAppliedDefaults = "loadbalancer.openstack.org/applied-defaults"
+
+// loadBalancerDefaultsResource is the cluster-scoped custom resource holding default annotations of the Services, see
+// LoadBalancerOpts.AnnotationDefaults.
+var loadBalancerDefaultsResource = schema.GroupVersionResource{
+	Group:    "loadbalancer.openstack.org",
+	Version:  "v1alpha1",
+	Resource: "loadbalancerdefaults",
+}
+
+// defaultableAnnotations are the annotations a LoadBalancerDefaults can set. The annotations referring to a single
+// load balancer, port or address, or written by openstack-cloud-controller-manager, only make sense per Service.
+var defaultableAnnotations = sets.New(
+	ServiceAnnotationLoadBalancerInternal,
+	ServiceAnnotationLoadBalancerNodeSelector,
+	ServiceAnnotationLoadBalancerConnLimit,
+	ServiceAnnotationLoadBalancerFloatingNetworkID,
+	ServiceAnnotationLoadBalancerFloatingSubnet,
+	ServiceAnnotationLoadBalancerFloatingSubnetID,
+	ServiceAnnotationLoadBalancerFloatingSubnetTags,
+	ServiceAnnotationLoadBalancerClass,
+	ServiceAnnotationLoadBalancerKeepFloatingIP,
+	ServiceAnnotationLoadBalancerProxyEnabled,
+	ServiceAnnotationLoadBalancerSubnetID,
+	ServiceAnnotationLoadBalancerNetworkID,
+	ServiceAnnotationLoadBalancerMemberSubnetID,
+	ServiceAnnotationLoadBalancerMemberPortSelector,
+	ServiceAnnotationLoadBalancerTimeoutClientData,
+	ServiceAnnotationLoadBalancerTimeoutMemberConnect,
+	ServiceAnnotationLoadBalancerTimeoutMemberData,
+	ServiceAnnotationLoadBalancerTimeoutTCPInspect,
+	ServiceAnnotationLoadBalancerXForwardedFor,
+	ServiceAnnotationLoadBalancerFlavorID,
+	ServiceAnnotationLoadBalancerAvailabilityZone,
+	ServiceAnnotationLoadBalancerEnableHealthMonitor,
+	ServiceAnnotationLoadBalancerHealthMonitorDelay,
+	ServiceAnnotationLoadBalancerHealthMonitorTimeout,
+	ServiceAnnotationLoadBalancerHealthMonitorMaxRetries,
+	ServiceAnnotationLoadBalancerHealthMonitorMaxRetriesDown,
+	ServiceAnnotationLoadBalancerDNSZone,
+	ServiceAnnotationTlsContainerRef,
+	ServiceAnnotationLoadBalancerMaxRetries,
+)
+
+// loadBalancerDefaultsSpec is the spec of a LoadBalancerDefaults. It applies to the Services of the listed namespaces,
+// or of all the namespaces if none is, which match both selectors when set.
+type loadBalancerDefaultsSpec struct {
+	Namespaces        []string              `json:"namespaces,omitempty"`
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	ServiceSelector   *metav1.LabelSelector `json:"serviceSelector,omitempty"`
+	Annotations       map[string]string     `json:"annotations,omitempty"`
+}
+
+// loadBalancerDefaults is a parsed LoadBalancerDefaults.
+type loadBalancerDefaults struct {
+	name              string
+	namespaces        []string
+	namespaceSelector labels.Selector
+	serviceSelector   labels.Selector
+	annotations       map[string]string
+}
+
+// annotationDefaults applies the LoadBalancerDefaults to the Services, see LoadBalancerOpts.AnnotationDefaults.
+type annotationDefaults struct {
+	defaults         cache.GenericLister
+	defaultsSynced   cache.InformerSynced
+	namespaces       corelisters.NamespaceLister
+	namespacesSynced cache.InformerSynced
+}
+
+// newAnnotationDefaults returns nil, i.e. no defaults, if the LoadBalancerDefaults CustomResourceDefinition is not
+// installed, since its informer would never sync.
+func newAnnotationDefaults(dclient dynamic.Interface, discoveryClient discovery.DiscoveryInterface, informerFactory informers.SharedInformerFactory, stop <-chan struct{}) *annotationDefaults {
+	served, err := loadBalancerDefaultsServed(discoveryClient)
+	if err != nil {
+		// Assume the resource is served, the informer retries until it is
+		klog.Warningf("Failed to discover the LoadBalancerDefaults resource: %v", err)
+	} else if !served {
+		klog.Warningf("The LoadBalancerDefaults CustomResourceDefinition is not installed, the annotations of the Services are not defaulted")
+		return nil
+	}
+
+	dynamicInformerFactory := dynamicinformer.NewDynamicSharedInformerFactory(dclient, 0)
+	defaultsInformer := dynamicInformerFactory.ForResource(loadBalancerDefaultsResource)
+	namespaceInformer := informerFactory.Core().V1().Namespaces()
+
+	a := &annotationDefaults{
+		defaults:         defaultsInformer.Lister(),
+		defaultsSynced:   defaultsInformer.Informer().HasSynced,
+		namespaces:       namespaceInformer.Lister(),
+		namespacesSynced: namespaceInformer.Informer().HasSynced,
+	}
+	dynamicInformerFactory.Start(stop)
+
+	return a
+}
+
+// loadBalancerDefaultsServed returns whether the API server serves the LoadBalancerDefaults resource.
+func loadBalancerDefaultsServed(discoveryClient discovery.DiscoveryInterface) (bool, error) {
+	resources, err := discoveryClient.ServerResourcesForGroupVersion(loadBalancerDefaultsResource.GroupVersion().String())
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, r := range resources.APIResources {
+		if r.Name == loadBalancerDefaultsResource.Resource {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// apply returns a copy of the Service with the annotations of the LoadBalancerDefaults matching it, or the Service
+// itself if there are none. The annotations set on the Service take precedence, and an annotation set by several
+// LoadBalancerDefaults is taken from the first one by name. The copy is not written back to the Service, except for
+// ServiceAnnotationLoadBalancerAppliedDefaults which is set to the defaulted annotations, see record.
+func (a *annotationDefaults) apply(service *corev1.Service) (*corev1.Service, error) {
+	if a == nil {
+		return service, nil
+	}
+	if !a.defaultsSynced() || !a.namespacesSynced() {
+		return nil, fmt.Errorf("the LoadBalancerDefaults and Namespace caches are not synced yet")
+	}
+
+	objs, err := a.defaults.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the LoadBalancerDefaults: %v", err)
+	}
+
+	var all []loadBalancerDefaults
+	for _, obj := range objs {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		d, err := parseLoadBalancerDefaults(u)
+		if err != nil {
+			klog.Warningf("Ignoring the LoadBalancerDefaults %s: %v", u.GetName(), err)
+			continue
+		}
+		all = append(all, d)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].name < all[j].name })
+
+	defaults := make(map[string]string)
+	for _, d := range all {
+		matches, err := a.matches(d, service)
+		if err != nil {
+			return nil, err
+		}
+		if !matches {
+			continue
+		}
+		for key, value := range d.annotations {
+			if _, ok := service.Annotations[key]; ok {
+				continue
+			}
+			if _, ok := defaults[key]; ok {
+				continue
+			}
+			klog.V(4).InfoS("Defaulting the annotation of the Service", "service", klog.KObj(service), "annotation", key, "loadBalancerDefaults", d.name)
+			defaults[key] = value
+		}
+	}
+	if len(defaults) == 0 {
+		if _, ok := service.Annotations[ServiceAnnotationLoadBalancerAppliedDefaults]; ok {
+			service = service.DeepCopy()
+			delete(service.Annotations, ServiceAnnotationLoadBalancerAppliedDefaults)
+		}
+		return service, nil
+	}
+
+	applied, err := json.Marshal(defaults)
+	if err != nil {
+		return nil, err
+	}
+	service = withDefaults(service, defaults)
+	service.Annotations[ServiceAnnotationLoadBalancerAppliedDefaults] = string(applied)
+
+	return service, nil
+}
+
+// applyRecorded returns a copy of the Service with the annotations recorded in
+// ServiceAnnotationLoadBalancerAppliedDefaults, i.e. the defaults its load balancer was last reconciled with, to delete
+// it. The Services without them, e.g. reconciled before they were recorded, get the current defaults.
+func (a *annotationDefaults) applyRecorded(service *corev1.Service) (*corev1.Service, error) {
+	applied, ok := service.Annotations[ServiceAnnotationLoadBalancerAppliedDefaults]
+	if !ok {
+		return a.apply(service)
+	}
+
+	var defaults map[string]string
+	if err := json.Unmarshal([]byte(applied), &defaults); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation of the Service %s/%s: %v", ServiceAnnotationLoadBalancerAppliedDefaults, service.Namespace, service.Name, err)
+	}
+	for key := range defaults {
+		if _, ok := service.Annotations[key]; ok || !defaultableAnnotations.Has(key) {
+			delete(defaults, key)
+		}
+	}
+
+	return withDefaults(service, defaults), nil
+}
+
+// record writes the ServiceAnnotationLoadBalancerAppliedDefaults annotation set by apply on the defaulted copy to the
+// Service, if it changed.
+func (a *annotationDefaults) record(ctx context.Context, kclient kubernetes.Interface, service, defaulted *corev1.Service) error {
+	if a == nil {
+		return nil
+	}
+
+	applied, ok := defaulted.Annotations[ServiceAnnotationLoadBalancerAppliedDefaults]
+	if current, found := service.Annotations[ServiceAnnotationLoadBalancerAppliedDefaults]; found == ok && current == applied {
+		return nil
+	}
+
+	updated := service.DeepCopy()
+	if ok {
+		if updated.Annotations == nil {
+			updated.Annotations = make(map[string]string, 1)
+		}
+		updated.Annotations[ServiceAnnotationLoadBalancerAppliedDefaults] = applied
+	} else {
+		delete(updated.Annotations, ServiceAnnotationLoadBalancerAppliedDefaults)
+	}
+	if err := cpoutil.PatchService(ctx, kclient, service, updated); err != nil {
+		return fmt.Errorf("failed to record the applied defaults of the Service %s/%s: %v", service.Namespace, service.Name, err)
+	}
+	return nil
+}
+
+// withDefaults returns a copy of the Service with the annotations set.
+func withDefaults(service *corev1.Service, defaults map[string]string) *corev1.Service {
+	service = service.DeepCopy()
+	if service.Annotations == nil {
+		service.Annotations = make(map[string]string, len(defaults)+1)
+	}
+	for key, value := range defaults {
+		service.Annotations[key] = value
+	}
+	return service
+}
+
+// matches returns whether the LoadBalancerDefaults applies to the Service.
+func (a *annotationDefaults) matches(d loadBalancerDefaults, service *corev1.Service) (bool, error) {
+	if len(d.namespaces) > 0 && !slices.Contains(d.namespaces, service.Namespace) {
+		return false, nil
+	}
+	if !d.serviceSelector.Matches(labels.Set(service.Labels)) {
+		return false, nil
+	}
+	if d.namespaceSelector.Empty() {
+		return true, nil
+	}
+
+	namespace, err := a.namespaces.Get(service.Namespace)
+	if err != nil {
+		return false, fmt.Errorf("failed to get the namespace of the Service %s/%s: %v", service.Namespace, service.Name, err)
+	}
+	return d.namespaceSelector.Matches(labels.Set(namespace.Labels)), nil
+}
+
+// parseLoadBalancerDefaults parses the spec of a LoadBalancerDefaults, leaving out the annotations that cannot be
+// defaulted.
+func parseLoadBalancerDefaults(u *unstructured.Unstructured) (loadBalancerDefaults, error) {
+	d := loadBalancerDefaults{name: u.GetName()}
+
+	specObj, _, err := unstructured.NestedMap(u.Object, "spec")
+	if err != nil {
+		return d, err
+	}
+	var spec loadBalancerDefaultsSpec
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(specObj, &spec); err != nil {
+		return d, err
+	}
+
+	// A missing selector matches everything, unlike for LabelSelectorAsSelector
+	d.namespaceSelector, d.serviceSelector = labels.Everything(), labels.Everything()
+	if spec.NamespaceSelector != nil {
+		if d.namespaceSelector, err = metav1.LabelSelectorAsSelector(spec.NamespaceSelector); err != nil {
+			return d, fmt.Errorf("invalid namespaceSelector: %v", err)
+		}
+	}
+	if spec.ServiceSelector != nil {
+		if d.serviceSelector, err = metav1.LabelSelectorAsSelector(spec.ServiceSelector); err != nil {
+			return d, fmt.Errorf("invalid serviceSelector: %v", err)
+		}
+	}
+	d.namespaces = spec.Namespaces
+
+	d.annotations = make(map[string]string, len(spec.Annotations))
+	for key, value := range spec.Annotations {
+		if !defaultableAnnotations.Has(key) {
+			klog.Warningf("Ignoring the annotation %s of the LoadBalancerDefaults %s, which cannot be defaulted", key, d.name)
+			continue
+		}
+		d.annotations[key] = value
+	}
+
+	return d, nil
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestLoadBalancerDefaults(name string, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "loadbalancer.openstack.org/v1alpha1",
+		"kind":       "LoadBalancerDefaults",
+		"metadata":   map[string]interface{}{"name": name},
+		"spec":       spec,
+	}}
+}
+
+func TestAnnotationDefaults(t *testing.T) {
+	defaultsIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	namespaceIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	synced := func() bool { return true }
+	a := &annotationDefaults{
+		defaults:         cache.NewGenericLister(defaultsIndexer, loadBalancerDefaultsResource.GroupResource()),
+		defaultsSynced:   synced,
+		namespaces:       corelisters.NewNamespaceLister(namespaceIndexer),
+		namespacesSynced: synced,
+	}
+
+	for _, ns := range []*corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"network": "internal"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}},
+	} {
+		assert.NoError(t, namespaceIndexer.Add(ns))
+	}
+	for _, d := range []*unstructured.Unstructured{
+		newTestLoadBalancerDefaults("a-internal", map[string]interface{}{
+			"namespaceSelector": map[string]interface{}{"matchLabels": map[string]interface{}{"network": "internal"}},
+			"annotations": map[string]interface{}{
+				ServiceAnnotationLoadBalancerInternal: "true",
+				ServiceAnnotationLoadBalancerSubnetID: "internal-subnet",
+				ServiceAnnotationLoadBalancerID:       "not-defaultable",
+			},
+		}),
+		newTestLoadBalancerDefaults("b-all", map[string]interface{}{
+			"annotations": map[string]interface{}{
+				ServiceAnnotationLoadBalancerSubnetID: "public-subnet",
+				ServiceAnnotationLoadBalancerFlavorID: "small",
+			},
+		}),
+		newTestLoadBalancerDefaults("c-proxy", map[string]interface{}{
+			"namespaces":      []interface{}{"team-b"},
+			"serviceSelector": map[string]interface{}{"matchLabels": map[string]interface{}{"proxy": "true"}},
+			"annotations": map[string]interface{}{
+				ServiceAnnotationLoadBalancerProxyEnabled: "v2",
+			},
+		}),
+		newTestLoadBalancerDefaults("d-invalid", map[string]interface{}{
+			"serviceSelector": map[string]interface{}{"matchExpressions": []interface{}{
+				map[string]interface{}{"key": "app", "operator": "Invalid"},
+			}},
+			"annotations": map[string]interface{}{
+				ServiceAnnotationLoadBalancerConnLimit: "10",
+			},
+		}),
+	} {
+		assert.NoError(t, defaultsIndexer.Add(d))
+	}
+
+	tests := []struct {
+		name     string
+		service  *corev1.Service
+		expected map[string]string
+	}{
+		{
+			name:    "namespace selector takes precedence by name",
+			service: &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "web"}},
+			expected: map[string]string{
+				ServiceAnnotationLoadBalancerInternal: "true",
+				ServiceAnnotationLoadBalancerSubnetID: "internal-subnet",
+				ServiceAnnotationLoadBalancerFlavorID: "small",
+			},
+		},
+		{
+			name: "annotations of the Service are kept",
+			service: &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "team-a",
+				Name:        "web",
+				Annotations: map[string]string{ServiceAnnotationLoadBalancerInternal: "false"},
+			}},
+			expected: map[string]string{
+				ServiceAnnotationLoadBalancerInternal: "false",
+				ServiceAnnotationLoadBalancerSubnetID: "internal-subnet",
+				ServiceAnnotationLoadBalancerFlavorID: "small",
+			},
+		},
+		{
+			name: "namespaces and service selector",
+			service: &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+				Namespace: "team-b",
+				Name:      "web",
+				Labels:    map[string]string{"proxy": "true"},
+			}},
+			expected: map[string]string{
+				ServiceAnnotationLoadBalancerSubnetID:     "public-subnet",
+				ServiceAnnotationLoadBalancerFlavorID:     "small",
+				ServiceAnnotationLoadBalancerProxyEnabled: "v2",
+			},
+		},
+		{
+			name:    "service selector not matching",
+			service: &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "team-b", Name: "web"}},
+			expected: map[string]string{
+				ServiceAnnotationLoadBalancerSubnetID: "public-subnet",
+				ServiceAnnotationLoadBalancerFlavorID: "small",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original := test.service.DeepCopy()
+			service, err := a.apply(test.service)
+			assert.NoError(t, err)
+
+			// Only the defaulted annotations are recorded
+			var applied map[string]string
+			assert.NoError(t, json.Unmarshal([]byte(service.Annotations[ServiceAnnotationLoadBalancerAppliedDefaults]), &applied))
+			for key := range test.service.Annotations {
+				assert.NotContains(t, applied, key)
+			}
+			delete(service.Annotations, ServiceAnnotationLoadBalancerAppliedDefaults)
+			assert.Equal(t, test.expected, service.Annotations)
+			assert.Equal(t, original, test.service)
+		})
+	}
+
+	_, err := a.apply(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "missing", Name: "web"}})
+	assert.Error(t, err)
+
+	a.defaultsSynced = func() bool { return false }
+	_, err = a.apply(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "team-b", Name: "web"}})
+	assert.Error(t, err)
+
+	var disabled *annotationDefaults
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "web"}}
+	applied, err := disabled.apply(service)
+	assert.NoError(t, err)
+	assert.Same(t, service, applied)
+}
+
+func TestAnnotationDefaultsRecord(t *testing.T) {
+	defaultsIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	synced := func() bool { return true }
+	a := &annotationDefaults{
+		defaults:         cache.NewGenericLister(defaultsIndexer, loadBalancerDefaultsResource.GroupResource()),
+		defaultsSynced:   synced,
+		namespaces:       corelisters.NewNamespaceLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+		namespacesSynced: synced,
+	}
+	assert.NoError(t, defaultsIndexer.Add(newTestLoadBalancerDefaults("keep-fip", map[string]interface{}{
+		"annotations": map[string]interface{}{
+			ServiceAnnotationLoadBalancerKeepFloatingIP: "true",
+		},
+	})))
+
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "svc"}}
+	kclient := fake.NewSimpleClientset(service)
+	ctx := context.TODO()
+
+	defaulted, err := a.apply(service)
+	assert.NoError(t, err)
+	assert.NoError(t, a.record(ctx, kclient, service, defaulted))
+	service, err = kclient.CoreV1().Services("ns").Get(ctx, "svc", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"loadbalancer.openstack.org/keep-floatingip":"true"}`, service.Annotations[ServiceAnnotationLoadBalancerAppliedDefaults])
+
+	// The load balancer is deleted with the recorded defaults, even if the LoadBalancerDefaults was deleted since
+	assert.NoError(t, defaultsIndexer.Delete(newTestLoadBalancerDefaults("keep-fip", nil)))
+	deleted, err := a.applyRecorded(service)
+	assert.NoError(t, err)
+	assert.Equal(t, "true", deleted.Annotations[ServiceAnnotationLoadBalancerKeepFloatingIP])
+	var disabled *annotationDefaults
+	deleted, err = disabled.applyRecorded(service)
+	assert.NoError(t, err)
+	assert.Equal(t, "true", deleted.Annotations[ServiceAnnotationLoadBalancerKeepFloatingIP])
+
+	// The annotations of the Service take precedence over the recorded ones
+	overridden := service.DeepCopy()
+	overridden.Annotations[ServiceAnnotationLoadBalancerKeepFloatingIP] = "false"
+	deleted, err = a.applyRecorded(overridden)
+	assert.NoError(t, err)
+	assert.Equal(t, "false", deleted.Annotations[ServiceAnnotationLoadBalancerKeepFloatingIP])
+
+	// The record is removed once no default applies anymore
+	defaulted, err = a.apply(service)
+	assert.NoError(t, err)
+	assert.NotContains(t, defaulted.Annotations, ServiceAnnotationLoadBalancerAppliedDefaults)
+	assert.NoError(t, a.record(ctx, kclient, service, defaulted))
+	service, err = kclient.CoreV1().Services("ns").Get(ctx, "svc", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.NotContains(t, service.Annotations, ServiceAnnotationLoadBalancerAppliedDefaults)
+
+	// Without a record, the Service is deleted with the current defaults
+	deleted, err = a.applyRecorded(service)
+	assert.NoError(t, err)
+	assert.NotContains(t, deleted.Annotations, ServiceAnnotationLoadBalancerKeepFloatingIP)
+}
+
+func TestLoadBalancerDefaultsServed(t *testing.T) {
+	discoveryClient := &fakediscovery.FakeDiscovery{Fake: &fake.NewSimpleClientset().Fake}
+
+	served, err := loadBalancerDefaultsServed(discoveryClient)
+	assert.NoError(t, err)
+	assert.False(t, served)
+	var disabled *annotationDefaults
+	assert.Equal(t, disabled, newAnnotationDefaults(nil, discoveryClient, nil, nil))
+
+	discoveryClient.Resources = []*metav1.APIResourceList{{
+		GroupVersion: loadBalancerDefaultsResource.GroupVersion().String(),
+		APIResources: []metav1.APIResource{{Name: loadBalancerDefaultsResource.Resource, Kind: "LoadBalancerDefaults"}},
+	}}
+	served, err = loadBalancerDefaultsServed(discoveryClient)
+	assert.NoError(t, err)
+	assert.True(t, served)
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/openstack/dns/v2/recordsets"
+	"github.com/gophercloud/gophercloud/openstack/dns/v2/zones"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	netutils "k8s.io/utils/net"
+
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+// dnsRecordDescription returns the description marking the Designate recordsets managed for the Service.
+func dnsRecordDescription(clusterName string, service *corev1.Service) string {
+	return fmt.Sprintf("DNS record for Kubernetes Service %s/%s/%s", clusterName, service.Namespace, service.Name)
+}
+
+func dnsRecordType(addr string) string {
+	if netutils.IsIPv6String(addr) {
+		return "AAAA"
+	}
+	return "A"
+}
+
+// dnsRecordsPlan is the set of changes reconciling the recordsets owned by a Service.
+type dnsRecordsPlan struct {
+	update []recordsets.RecordSet
+	delete []recordsets.RecordSet
+	create bool
+}
+
+// planDNSRecords compares the recordsets owned by the Service with the wanted one. An empty name means no recordset
+// is wanted.
+func planDNSRecords(owned []recordsets.RecordSet, name, recordType, addr string) dnsRecordsPlan {
+	plan := dnsRecordsPlan{create: name != ""}
+	for _, rs := range owned {
+		if name != "" && rs.Name == name && rs.Type == recordType {
+			plan.create = false
+			if len(rs.Records) != 1 || rs.Records[0] != addr {
+				plan.update = append(plan.update, rs)
+			}
+			continue
+		}
+		plan.delete = append(plan.delete, rs)
+	}
+	return plan
+}
+
+// getDNSZone returns the Designate zone by name.
+func (lbaas *LbaasV2) getDNSZone(zoneName string) (*zones.Zone, error) {
+	if lbaas.dns == nil {
+		return nil, fmt.Errorf("DNS zone %s is set but the OpenStack DNS service is not available", zoneName)
+	}
+
+	zone, err := openstackutil.GetZoneByName(lbaas.dns, zoneName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get DNS zone %s: %w", zoneName, err)
+	}
+	return zone, nil
+}
+
+// deleteDNSRecords deletes the recordsets created for the Service in the zone. It's not an error if the zone
+// doesn't exist anymore.
+func (lbaas *LbaasV2) deleteDNSRecords(clusterName string, service *corev1.Service, zoneName string) error {
+	zone, err := lbaas.getDNSZone(zoneName)
+	if err != nil {
+		if errors.Is(err, cpoerrors.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	owned, err := openstackutil.GetRecordSets(lbaas.dns, zone.ID, recordsets.ListOpts{Description: dnsRecordDescription(clusterName, service)})
+	if err != nil {
+		return fmt.Errorf("failed to list DNS records of Service %s/%s: %v", service.Namespace, service.Name, err)
+	}
+
+	for _, rs := range owned {
+		klog.InfoS("Deleting DNS record", "name", rs.Name, "type", rs.Type, "zone", zone.Name, "service", klog.KObj(service))
+		if err := openstackutil.DeleteRecordSet(lbaas.dns, zone.ID, rs.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureDNSRecord makes the hostname of the Service resolve to the load balancer address, and deletes the recordsets
+// previously created for the Service with another hostname, address family or zone. The Services without hostname
+// or DNS zone are left alone, unless they have records to delete.
+func (lbaas *LbaasV2) ensureDNSRecord(clusterName string, service *corev1.Service, addr string) error {
+	hostname := getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerLoadbalancerHostname, "")
+	zoneName := ""
+	if hostname != "" {
+		zoneName = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerDNSZone, lbaas.opts.DNSZone)
+	}
+
+	recordZone := getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerDNSRecordZone, "")
+	if recordZone != "" && openstackutil.ToFQDN(recordZone) != openstackutil.ToFQDN(zoneName) {
+		if err := lbaas.deleteDNSRecords(clusterName, service, recordZone); err != nil {
+			return err
+		}
+		delete(service.Annotations, ServiceAnnotationLoadBalancerDNSRecordZone)
+	}
+	if zoneName == "" {
+		return nil
+	}
+
+	zone, err := lbaas.getDNSZone(zoneName)
+	if err != nil {
+		return err
+	}
+	lbaas.updateServiceAnnotation(service, ServiceAnnotationLoadBalancerDNSRecordZone, zone.Name)
+
+	description := dnsRecordDescription(clusterName, service)
+	owned, err := openstackutil.GetRecordSets(lbaas.dns, zone.ID, recordsets.ListOpts{Description: description})
+	if err != nil {
+		return fmt.Errorf("failed to list DNS records of Service %s/%s: %v", service.Namespace, service.Name, err)
+	}
+
+	name := openstackutil.ToFQDN(hostname)
+	if !openstackutil.IsNameInZone(name, zone.Name) {
+		return fmt.Errorf("hostname %s of Service %s/%s is not in DNS zone %s", hostname, service.Namespace, service.Name, zone.Name)
+	}
+	recordType := dnsRecordType(addr)
+
+	plan := planDNSRecords(owned, name, recordType, addr)
+	for _, rs := range plan.delete {
+		klog.InfoS("Deleting DNS record", "name", rs.Name, "type", rs.Type, "service", klog.KObj(service))
+		if err := openstackutil.DeleteRecordSet(lbaas.dns, zone.ID, rs.ID); err != nil {
+			return err
+		}
+	}
+	for _, rs := range plan.update {
+		klog.InfoS("Updating DNS record", "name", rs.Name, "type", rs.Type, "address", addr, "service", klog.KObj(service))
+		if err := openstackutil.UpdateRecordSetRecords(lbaas.dns, zone.ID, rs.ID, []string{addr}); err != nil {
+			return err
+		}
+	}
+	if !plan.create {
+		return nil
+	}
+
+	// Don't take over a recordset created by someone else.
+	existing, err := openstackutil.GetRecordSets(lbaas.dns, zone.ID, recordsets.ListOpts{Name: name, Type: recordType})
+	if err != nil {
+		return fmt.Errorf("failed to list DNS records named %s: %v", name, err)
+	}
+	if len(existing) > 0 {
+		msg := "DNS record %s %s already exists and is not managed for Service %s/%s"
+		lbaas.eventRecorder.Eventf(service, corev1.EventTypeWarning, eventLBDNSRecordConflict, msg, recordType, name, service.Namespace, service.Name)
+		return fmt.Errorf(msg, recordType, name, service.Namespace, service.Name)
+	}
+
+	klog.InfoS("Creating DNS record", "name", name, "type", recordType, "address", addr, "service", klog.KObj(service))
+	_, err = openstackutil.CreateRecordSet(lbaas.dns, zone.ID, recordsets.CreateOpts{
+		Name:        name,
+		Type:        recordType,
+		Records:     []string{addr},
+		TTL:         lbaas.opts.DNSRecordTTL,
+		Description: description,
+	})
+	return err
+}
+
+// ensureDNSRecordsDeleted deletes the recordsets created for the Service. A failure doesn't prevent the deletion of
+// the load balancer, it's reported as an event on the Service and the records are left in Designate.
+func (lbaas *LbaasV2) ensureDNSRecordsDeleted(clusterName string, service *corev1.Service) {
+	zoneName := getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerDNSRecordZone, "")
+	if zoneName == "" && getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerLoadbalancerHostname, "") != "" {
+		// The zone of the records may not have been recorded yet
+		zoneName = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerDNSZone, lbaas.opts.DNSZone)
+	}
+	if zoneName == "" {
+		return
+	}
+
+	if err := lbaas.deleteDNSRecords(clusterName, service, zoneName); err != nil {
+		klog.ErrorS(err, "Failed to delete DNS records", "zone", zoneName, "service", klog.KObj(service))
+		lbaas.eventRecorder.Eventf(service, corev1.EventTypeWarning, eventLBDNSRecordDeleteFailed, "Failed to delete the DNS records in zone %s: %v", zoneName, err)
+	}
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/dns/v2/recordsets"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// fakeDesignate serves the zones and recordsets of a Designate fake.
+type fakeDesignate struct {
+	// zones maps the zone names to their IDs
+	zones map[string]string
+	// recordsets maps the zone IDs to their recordsets
+	recordsets  map[string][]recordsets.RecordSet
+	failDeletes bool
+	lastID      int
+}
+
+func (d *fakeDesignate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodGet && len(path) == 1:
+		zones := []map[string]string{}
+		if id, ok := d.zones[query.Get("name")]; ok {
+			zones = append(zones, map[string]string{"id": id, "name": query.Get("name")})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"zones": zones})
+	case r.Method == http.MethodGet && len(path) == 3:
+		rss := []recordsets.RecordSet{}
+		for _, rs := range d.recordsets[path[1]] {
+			if (query.Get("description") == "" || rs.Description == query.Get("description")) &&
+				(query.Get("name") == "" || rs.Name == query.Get("name")) &&
+				(query.Get("type") == "" || rs.Type == query.Get("type")) {
+				rss = append(rss, rs)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"recordsets": rss})
+	case r.Method == http.MethodPost && len(path) == 3:
+		var rs recordsets.RecordSet
+		_ = json.NewDecoder(r.Body).Decode(&rs)
+		d.lastID++
+		rs.ID = fmt.Sprintf("rs-%d", d.lastID)
+		d.recordsets[path[1]] = append(d.recordsets[path[1]], rs)
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(rs)
+	case r.Method == http.MethodDelete && len(path) == 4:
+		if d.failDeletes {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var kept []recordsets.RecordSet
+		for _, rs := range d.recordsets[path[1]] {
+			if rs.ID != path[3] {
+				kept = append(kept, rs)
+			}
+		}
+		d.recordsets[path[1]] = kept
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestEnsureDNSRecord(t *testing.T) {
+	designate := &fakeDesignate{
+		zones:      map[string]string{"example.com.": "zone-1", "example.org.": "zone-2"},
+		recordsets: map[string][]recordsets.RecordSet{},
+	}
+	server := httptest.NewServer(designate)
+	defer server.Close()
+
+	recorder := record.NewFakeRecorder(10)
+	lbaas := &LbaasV2{LoadBalancer{
+		dns: &gophercloud.ServiceClient{
+			ProviderClient: &gophercloud.ProviderClient{HTTPClient: http.Client{Transport: http.DefaultTransport}},
+			Endpoint:       server.URL + "/",
+		},
+		eventRecorder: recorder,
+	}}
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"}}
+
+	// No hostname, Designate is not used
+	withoutDNS := &LbaasV2{LoadBalancer{opts: LoadBalancerOpts{DNSZone: "example.com"}}}
+	assert.NoError(t, withoutDNS.ensureDNSRecord("kubernetes", service, "192.0.2.10"))
+	withoutDNS.ensureDNSRecordsDeleted("kubernetes", service)
+	assert.Empty(t, service.Annotations)
+
+	service.Annotations = map[string]string{
+		ServiceAnnotationLoadBalancerLoadbalancerHostname: "app.example.com",
+		ServiceAnnotationLoadBalancerDNSZone:              "example.com",
+	}
+	assert.NoError(t, lbaas.ensureDNSRecord("kubernetes", service, "192.0.2.10"))
+	assert.Equal(t, "example.com.", service.Annotations[ServiceAnnotationLoadBalancerDNSRecordZone])
+	assert.Len(t, designate.recordsets["zone-1"], 1)
+
+	// The records of the previous zone are deleted when the zone changes
+	service.Annotations[ServiceAnnotationLoadBalancerLoadbalancerHostname] = "app.example.org"
+	service.Annotations[ServiceAnnotationLoadBalancerDNSZone] = "example.org"
+	assert.NoError(t, lbaas.ensureDNSRecord("kubernetes", service, "192.0.2.10"))
+	assert.Equal(t, "example.org.", service.Annotations[ServiceAnnotationLoadBalancerDNSRecordZone])
+	assert.Empty(t, designate.recordsets["zone-1"])
+	assert.Len(t, designate.recordsets["zone-2"], 1)
+
+	// A failed deletion doesn't block the deletion of the load balancer
+	designate.failDeletes = true
+	lbaas.ensureDNSRecordsDeleted("kubernetes", service)
+	assert.Len(t, designate.recordsets["zone-2"], 1)
+	assert.Contains(t, <-recorder.Events, eventLBDNSRecordDeleteFailed)
+
+	designate.failDeletes = false
+	lbaas.ensureDNSRecordsDeleted("kubernetes", service)
+	assert.Empty(t, designate.recordsets["zone-2"])
+
+	// The records are deleted when the hostname is removed
+	assert.NoError(t, lbaas.ensureDNSRecord("kubernetes", service, "192.0.2.10"))
+	delete(service.Annotations, ServiceAnnotationLoadBalancerLoadbalancerHostname)
+	assert.NoError(t, lbaas.ensureDNSRecord("kubernetes", service, "192.0.2.10"))
+	assert.Empty(t, designate.recordsets["zone-2"])
+	assert.NotContains(t, service.Annotations, ServiceAnnotationLoadBalancerDNSRecordZone)
+}
+
+func TestPlanDNSRecords(t *testing.T) {
+	current := recordsets.RecordSet{ID: "current", Name: "app.example.com.", Type: "A", Records: []string{"192.0.2.10"}}
+	old := recordsets.RecordSet{ID: "old", Name: "old.example.com.", Type: "A", Records: []string{"192.0.2.10"}}
+
+	tests := []struct {
+		name       string
+		owned      []recordsets.RecordSet
+		recordName string
+		recordType string
+		addr       string
+		expected   dnsRecordsPlan
+	}{
+		{
+			name:       "no record yet",
+			recordName: "app.example.com.",
+			recordType: "A",
+			addr:       "192.0.2.10",
+			expected:   dnsRecordsPlan{create: true},
+		},
+		{
+			name:       "record up to date",
+			owned:      []recordsets.RecordSet{current},
+			recordName: "app.example.com.",
+			recordType: "A",
+			addr:       "192.0.2.10",
+			expected:   dnsRecordsPlan{},
+		},
+		{
+			name:       "address changed",
+			owned:      []recordsets.RecordSet{current},
+			recordName: "app.example.com.",
+			recordType: "A",
+			addr:       "192.0.2.20",
+			expected:   dnsRecordsPlan{update: []recordsets.RecordSet{current}},
+		},
+		{
+			name:       "hostname changed",
+			owned:      []recordsets.RecordSet{old},
+			recordName: "app.example.com.",
+			recordType: "A",
+			addr:       "192.0.2.10",
+			expected:   dnsRecordsPlan{delete: []recordsets.RecordSet{old}, create: true},
+		},
+		{
+			name:       "address family changed",
+			owned:      []recordsets.RecordSet{current},
+			recordName: "app.example.com.",
+			recordType: "AAAA",
+			addr:       "2001:db8::10",
+			expected:   dnsRecordsPlan{delete: []recordsets.RecordSet{current}, create: true},
+		},
+		{
+			name:     "hostname removed",
+			owned:    []recordsets.RecordSet{current},
+			expected: dnsRecordsPlan{delete: []recordsets.RecordSet{current}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan := planDNSRecords(test.owned, test.recordName, test.recordType, test.addr)
+			assert.Equal(t, test.expected, plan)
+		})
+	}
+}
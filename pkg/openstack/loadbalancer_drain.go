@@ -0,0 +1,337 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	v2pools "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// NodeAnnotationLoadBalancerDrain drains the members of the node, as if it was removed from the load balancers, e.g.
+// before cordoning and deleting it.
+const NodeAnnotationLoadBalancerDrain = "loadbalancer.openstack.org/drain"
+
+// memberDrains tracks the members being drained: the members of the nodes removed from a pool are kept with a weight
+// of 0 for the drain period, so that Octavia stops sending them new connections while the established ones complete,
+// and left out of the pool members at the first update of the pool once the period is over, which deletes them.
+type memberDrains struct {
+	mu      sync.Mutex
+	period  time.Duration
+	started map[string]time.Time
+	now     func() time.Time
+
+	// enqueueAfter schedules the update of the members of a Service, once their drain period is over
+	enqueueAfter func(key string, after time.Duration)
+}
+
+func newMemberDrains(period time.Duration) *memberDrains {
+	return &memberDrains{
+		period:  period,
+		started: make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+// draining returns how long the member is still being drained, starting its drain if needed, or 0 once the period is
+// over and the member stops being tracked.
+func (d *memberDrains) draining(poolID, memberID string) time.Duration {
+	if d == nil {
+		return 0
+	}
+
+	key := poolID + "/" + memberID
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	started, ok := d.started[key]
+	if !ok {
+		d.started[key] = d.now()
+		return d.period
+	}
+	if remaining := d.period - d.now().Sub(started); remaining > 0 {
+		return remaining
+	}
+
+	delete(d.started, key)
+	return 0
+}
+
+// requeue schedules the update of the members of the Service once the drain of one of them is over, so that it is
+// deleted then rather than at the next change of the nodes or the Service.
+func (d *memberDrains) requeue(service *corev1.Service, after time.Duration) {
+	if d == nil || d.enqueueAfter == nil || service == nil {
+		return
+	}
+	d.enqueueAfter(service.Namespace+"/"+service.Name, after)
+}
+
+// forget stops tracking the member, e.g. when its node is back.
+func (d *memberDrains) forget(poolID, memberID string) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.started, poolID+"/"+memberID)
+}
+
+// prune stops tracking the members of the pool which don't exist anymore, e.g. deleted out of the drains.
+func (d *memberDrains) prune(poolID string, members []v2pools.Member) {
+	if d == nil {
+		return
+	}
+
+	existing := make(map[string]bool, len(members))
+	for _, m := range members {
+		existing[poolID+"/"+m.ID] = true
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key := range d.started {
+		if strings.HasPrefix(key, poolID+"/") && !existing[key] {
+			delete(d.started, key)
+		}
+	}
+}
+
+func isNodeDrained(node *corev1.Node) bool {
+	return node.Annotations[NodeAnnotationLoadBalancerDrain] == "true"
+}
+
+// drainingMembers returns the update options keeping the members of the pool which are not part of the new members,
+// if they are still being drained, and schedules the update of the Service at the end of their drain.
+func (lbaas *LbaasV2) drainingMembers(service *corev1.Service, poolID string, poolMembers []v2pools.Member, newMembers []v2pools.BatchUpdateMemberOpts) []v2pools.BatchUpdateMemberOpts {
+	if lbaas.memberDrains == nil {
+		return nil
+	}
+
+	lbaas.memberDrains.prune(poolID, poolMembers)
+
+	kept := make(map[string]bool, len(newMembers))
+	for _, m := range newMembers {
+		if m.Weight == nil || *m.Weight != 0 {
+			kept[m.Address] = true
+		}
+	}
+
+	var draining []v2pools.BatchUpdateMemberOpts
+	for _, m := range poolMembers {
+		if kept[m.Address] {
+			lbaas.memberDrains.forget(poolID, m.ID)
+			continue
+		}
+
+		remaining := lbaas.memberDrains.draining(poolID, m.ID)
+		if remaining == 0 {
+			continue
+		}
+
+		klog.V(2).Infof("Draining member %s (%s) of pool %s for %v", m.Name, m.Address, poolID, remaining)
+		lbaas.memberDrains.requeue(service, remaining)
+		name := m.Name
+		weight := 0
+		member := v2pools.BatchUpdateMemberOpts{
+			Address:      m.Address,
+			ProtocolPort: m.ProtocolPort,
+			Name:         &name,
+			Weight:       &weight,
+		}
+		if m.SubnetID != "" {
+			subnetID := m.SubnetID
+			member.SubnetID = &subnetID
+		}
+		if m.MonitorPort != 0 {
+			monitorPort := m.MonitorPort
+			member.MonitorPort = &monitorPort
+		}
+		draining = append(draining, member)
+	}
+
+	return draining
+}
+
+// memberKey identifies the member of a pool when comparing the current members with the desired ones. The weight is
+// only taken into account when the members are drained.
+func (lbaas *LbaasV2) memberKey(name, address string, protocolPort, monitorPort, weight int) string {
+	if lbaas.memberDrains == nil {
+		return fmt.Sprintf("%s-%s-%d-%d", name, address, protocolPort, monitorPort)
+	}
+	return fmt.Sprintf("%s-%s-%d-%d-%d", name, address, protocolPort, monitorPort, weight)
+}
+
+// withoutDrainedNodes returns the nodes which are not annotated to be drained.
+func withoutDrainedNodes(nodes []*corev1.Node) []*corev1.Node {
+	var kept []*corev1.Node
+	for _, node := range nodes {
+		if !isNodeDrained(node) {
+			kept = append(kept, node)
+		}
+	}
+	return kept
+}
+
+// nodeDrains updates the members of the Services as soon as the NodeAnnotationLoadBalancerDrain annotation of a node
+// changes, rather than at the next change of the nodes seen by the service controller, and once the drain period of
+// their members is over, see memberDrains.
+type nodeDrains struct {
+	services       corelisters.ServiceLister
+	servicesSynced cache.InformerSynced
+	queue          workqueue.RateLimitingInterface
+}
+
+func newNodeDrains(informerFactory informers.SharedInformerFactory) *nodeDrains {
+	nodeInformer := informerFactory.Core().V1().Nodes()
+	serviceInformer := informerFactory.Core().V1().Services()
+
+	d := &nodeDrains{
+		services:       serviceInformer.Lister(),
+		servicesSynced: serviceInformer.Informer().HasSynced,
+		queue:          workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "occm-node-drains"),
+	}
+
+	_, err := nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: d.nodeUpdated,
+	})
+	if err != nil {
+		klog.Fatalf("Failed to add the Node event handler: %v", err)
+	}
+
+	return d
+}
+
+// nodeUpdated queues the Services of LoadBalancer type when the node is annotated to be drained or back.
+func (d *nodeDrains) nodeUpdated(oldObj, newObj interface{}) {
+	oldNode, ok := oldObj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	newNode, ok := newObj.(*corev1.Node)
+	if !ok || isNodeDrained(oldNode) == isNodeDrained(newNode) {
+		return
+	}
+
+	services, err := d.services.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list the Services to drain node %s: %v", newNode.Name, err)
+		return
+	}
+	klog.V(2).Infof("Updating the members of the Services after a change of the %s annotation of node %s", NodeAnnotationLoadBalancerDrain, newNode.Name)
+	for _, service := range services {
+		if service.Spec.Type == corev1.ServiceTypeLoadBalancer {
+			d.queue.Add(service.Namespace + "/" + service.Name)
+		}
+	}
+}
+
+// enqueueAfter queues the Service after the delay, see memberDrains.requeue.
+func (d *nodeDrains) enqueueAfter(key string, after time.Duration) {
+	d.queue.AddAfter(key, after)
+}
+
+// runNodeDrains updates the members of the Services queued by the drains until stop is closed.
+func (os *OpenStack) runNodeDrains(stop <-chan struct{}) {
+	d := os.nodeDrains
+	defer d.queue.ShutDown()
+
+	if !cache.WaitForCacheSync(stop, d.servicesSynced, os.nodeInformerHasSynced) {
+		klog.Error("Failed to sync the caches of the node drains")
+		return
+	}
+
+	lb, ok := os.LoadBalancer()
+	if !ok {
+		return
+	}
+	lbaas := lb.(*LbaasV2)
+
+	go wait.Until(func() {
+		for os.processNextNodeDrain(lbaas) {
+		}
+	}, time.Second, stop)
+
+	<-stop
+}
+
+func (os *OpenStack) processNextNodeDrain(lbaas *LbaasV2) bool {
+	d := os.nodeDrains
+	item, quit := d.queue.Get()
+	if quit {
+		return false
+	}
+	defer d.queue.Done(item)
+
+	key := item.(string)
+	if err := os.syncNodeDrain(lbaas, key); err != nil {
+		klog.Errorf("Failed to update the members of Service %s after a drain, will retry: %v", key, err)
+		d.queue.AddRateLimited(item)
+		return true
+	}
+
+	d.queue.Forget(item)
+	return true
+}
+
+// syncNodeDrain updates the members of the Service with the nodes the service controller would use.
+func (os *OpenStack) syncNodeDrain(lbaas *LbaasV2, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	service, err := os.nodeDrains.services.Services(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Services handled by another controller or not yet provisioned are skipped.
+	if service.Spec.Type != corev1.ServiceTypeLoadBalancer || service.Spec.LoadBalancerClass != nil || len(service.Status.LoadBalancer.Ingress) == 0 {
+		return nil
+	}
+
+	allNodes, err := os.nodeInformer.Lister().List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %v", err)
+	}
+	nodes := make([]*corev1.Node, 0, len(allNodes))
+	for _, node := range allNodes {
+		if loadBalancerNode(node) {
+			nodes = append(nodes, node)
+		}
+	}
+
+	klog.V(2).Infof("Updating the members of Service %s after a drain", key)
+	return lbaas.UpdateLoadBalancer(context.TODO(), os.clusterName, service, nodes)
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"testing"
+	"time"
+
+	v2pools "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMemberDrains(t *testing.T) {
+	now := time.Now()
+	drains := newMemberDrains(time.Minute)
+	drains.now = func() time.Time { return now }
+
+	assert.Equal(t, time.Minute, drains.draining("pool", "member"))
+
+	now = now.Add(20 * time.Second)
+	assert.Equal(t, 40*time.Second, drains.draining("pool", "member"))
+
+	// The member is left out once the period is over and stops being tracked
+	now = now.Add(time.Minute)
+	assert.Zero(t, drains.draining("pool", "member"))
+	assert.Empty(t, drains.started)
+
+	assert.Positive(t, drains.draining("pool", "back"))
+	drains.forget("pool", "back")
+	assert.Empty(t, drains.started)
+
+	assert.Positive(t, drains.draining("pool", "deleted"))
+	assert.Positive(t, drains.draining("pool", "existing"))
+	assert.Positive(t, drains.draining("other", "deleted"))
+	drains.prune("pool", []v2pools.Member{{ID: "existing"}})
+	assert.Len(t, drains.started, 2)
+	assert.Contains(t, drains.started, "pool/existing")
+	assert.Contains(t, drains.started, "other/deleted")
+
+	var disabled *memberDrains
+	assert.Zero(t, disabled.draining("pool", "member"))
+	disabled.forget("pool", "member")
+	disabled.prune("pool", nil)
+	disabled.requeue(&corev1.Service{}, time.Minute)
+}
+
+func TestDrainingMembers(t *testing.T) {
+	now := time.Now()
+	lbaas := &LbaasV2{LoadBalancer{memberDrains: newMemberDrains(time.Minute)}}
+	lbaas.memberDrains.now = func() time.Time { return now }
+
+	poolMembers := []v2pools.Member{
+		{ID: "kept", Name: "node-1", Address: "10.0.0.1", ProtocolPort: 30000, Weight: 1},
+		{ID: "removed", Name: "node-2", Address: "10.0.0.2", ProtocolPort: 30000, Weight: 1, SubnetID: "subnet", MonitorPort: 30001},
+	}
+	name := "node-1"
+	newMembers := []v2pools.BatchUpdateMemberOpts{{Name: &name, Address: "10.0.0.1", ProtocolPort: 30000}}
+
+	draining := lbaas.drainingMembers(nil, "pool", poolMembers, newMembers)
+	assert.Len(t, draining, 1)
+	assert.Equal(t, "node-2", *draining[0].Name)
+	assert.Equal(t, "10.0.0.2", draining[0].Address)
+	assert.Equal(t, 0, *draining[0].Weight)
+	assert.Equal(t, "subnet", *draining[0].SubnetID)
+	assert.Equal(t, 30001, *draining[0].MonitorPort)
+
+	// The drained member is deleted by the first update of the pool once the period is over
+	now = now.Add(time.Minute)
+	assert.Empty(t, lbaas.drainingMembers(nil, "pool", poolMembers, newMembers))
+	assert.Empty(t, lbaas.memberDrains.started)
+
+	disabled := &LbaasV2{}
+	assert.Empty(t, disabled.drainingMembers(nil, "pool", poolMembers, newMembers))
+}
+
+func TestNodeDrainLifecycle(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}}
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "lb"},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+	}
+	clusterIP := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "cluster-ip"}}
+	informerFactory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	d := newNodeDrains(informerFactory)
+	defer d.queue.ShutDown()
+	serviceIndexer := informerFactory.Core().V1().Services().Informer().GetIndexer()
+	assert.NoError(t, serviceIndexer.Add(service))
+	assert.NoError(t, serviceIndexer.Add(clusterIP))
+
+	now := time.Now()
+	lbaas := &LbaasV2{LoadBalancer{memberDrains: newMemberDrains(time.Minute)}}
+	lbaas.memberDrains.now = func() time.Time { return now }
+	var requeued []time.Duration
+	lbaas.memberDrains.enqueueAfter = func(key string, after time.Duration) {
+		assert.Equal(t, "ns/lb", key)
+		requeued = append(requeued, after)
+	}
+
+	// Other changes of the node are left to the service controller
+	d.nodeUpdated(node, node.DeepCopy())
+	assert.Zero(t, d.queue.Len())
+
+	// Annotating the node queues the Services of LoadBalancer type
+	drained := node.DeepCopy()
+	drained.Annotations = map[string]string{NodeAnnotationLoadBalancerDrain: "true"}
+	d.nodeUpdated(node, drained)
+	assert.Equal(t, 1, d.queue.Len())
+	item, _ := d.queue.Get()
+	assert.Equal(t, "ns/lb", item)
+	d.queue.Done(item)
+
+	// Their update keeps the member of the node with a weight of 0, and requeues them at the end of the drain
+	name := "node-1"
+	poolMembers := []v2pools.Member{
+		{ID: "kept", Name: "node-1", Address: "10.0.0.1", ProtocolPort: 30000, Weight: 1},
+		{ID: "drained", Name: "node-2", Address: "10.0.0.2", ProtocolPort: 30000, Weight: 1},
+	}
+	members := []v2pools.BatchUpdateMemberOpts{{Name: &name, Address: "10.0.0.1", ProtocolPort: 30000}}
+	draining := lbaas.drainingMembers(service, "pool", poolMembers, members)
+	assert.Len(t, draining, 1)
+	assert.Equal(t, 0, *draining[0].Weight)
+	assert.Equal(t, []time.Duration{time.Minute}, requeued)
+
+	// A resync during the drain requeues them for the rest of the period
+	now = now.Add(20 * time.Second)
+	poolMembers[1].Weight = 0
+	assert.Len(t, lbaas.drainingMembers(service, "pool", poolMembers, members), 1)
+	assert.Equal(t, []time.Duration{time.Minute, 40 * time.Second}, requeued)
+
+	// The requeued update once the period is over deletes the member, without requeuing them again
+	now = now.Add(40 * time.Second)
+	assert.Empty(t, lbaas.drainingMembers(service, "pool", poolMembers, members))
+	assert.Len(t, requeued, 2)
+
+	// Removing the annotation queues the Services again, to add the node back
+	d.nodeUpdated(drained, node)
+	assert.Equal(t, 1, d.queue.Len())
+}
+
+func TestMemberKey(t *testing.T) {
+	disabled := &LbaasV2{}
+	assert.Equal(t, disabled.memberKey("node", "10.0.0.1", 30000, 0, 1), disabled.memberKey("node", "10.0.0.1", 30000, 0, 0))
+
+	enabled := &LbaasV2{LoadBalancer{memberDrains: newMemberDrains(time.Minute)}}
+	assert.NotEqual(t, enabled.memberKey("node", "10.0.0.1", 30000, 0, 1), enabled.memberKey("node", "10.0.0.1", 30000, 0, 0))
+}
+
+func TestWithoutDrainedNodes(t *testing.T) {
+	nodes := []*corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-2", Annotations: map[string]string{NodeAnnotationLoadBalancerDrain: "true"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-3", Annotations: map[string]string{NodeAnnotationLoadBalancerDrain: "false"}}},
+	}
+
+	kept := withoutDrainedNodes(nodes)
+	assert.Len(t, kept, 2)
+	assert.Equal(t, "node-1", kept[0].Name)
+	assert.Equal(t, "node-3", kept[1].Name)
+}
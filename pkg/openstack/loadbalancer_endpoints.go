@@ -0,0 +1,304 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// toBeDeletedTaint is added by the cluster autoscaler to the nodes it is about to delete, which are left out of the
+// load balancers by the service controller.
+const toBeDeletedTaint = "ToBeDeletedByClusterAutoscaler"
+
+// endpointMembers tracks the nodes of the ready endpoints of the Services with the Local external traffic policy, see
+// LoadBalancerOpts.EndpointMemberSync. The members of their pools are the nodes of their endpoints, and the pools of a
+// Service are updated as soon as its EndpointSlices change, rather than on the next resync or Node change.
+type endpointMembers struct {
+	slices         discoverylisters.EndpointSliceLister
+	slicesSynced   cache.InformerSynced
+	services       corelisters.ServiceLister
+	servicesSynced cache.InformerSynced
+	queue          workqueue.RateLimitingInterface
+
+	// Endpoint nodes from which the members of the Services were last built
+	mu      sync.Mutex
+	applied map[string]sets.Set[string]
+}
+
+func newEndpointMembers(informerFactory informers.SharedInformerFactory) *endpointMembers {
+	sliceInformer := informerFactory.Discovery().V1().EndpointSlices()
+	serviceInformer := informerFactory.Core().V1().Services()
+
+	e := &endpointMembers{
+		slices:         sliceInformer.Lister(),
+		slicesSynced:   sliceInformer.Informer().HasSynced,
+		services:       serviceInformer.Lister(),
+		servicesSynced: serviceInformer.Informer().HasSynced,
+		queue:          workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "occm-endpoint-members"),
+		applied:        make(map[string]sets.Set[string]),
+	}
+
+	_, err := sliceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    e.enqueueSlice,
+		UpdateFunc: func(_, obj interface{}) { e.enqueueSlice(obj) },
+		DeleteFunc: e.enqueueSlice,
+	})
+	if err != nil {
+		klog.Fatalf("Failed to add the EndpointSlice event handler: %v", err)
+	}
+
+	return e
+}
+
+// enqueueSlice queues the Service of the EndpointSlice.
+func (e *endpointMembers) enqueueSlice(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	slice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		return
+	}
+
+	name := slice.Labels[discoveryv1.LabelServiceName]
+	if name == "" {
+		return
+	}
+	e.queue.Add(slice.Namespace + "/" + name)
+}
+
+// endpointNodes returns the names of the nodes of the ready endpoints of the Service.
+func (e *endpointMembers) endpointNodes(service *corev1.Service) (sets.Set[string], error) {
+	selector := labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: service.Name})
+	slices, err := e.slices.EndpointSlices(service.Namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := sets.New[string]()
+	for _, slice := range slices {
+		for _, endpoint := range slice.Endpoints {
+			// A nil ready condition is to be interpreted as ready
+			if endpoint.NodeName == nil || (endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready) {
+				continue
+			}
+			nodes.Insert(*endpoint.NodeName)
+		}
+	}
+
+	return nodes, nil
+}
+
+// memberNodes returns the nodes of the ready endpoints of the Service, from which its members are built, and records
+// them as applied. It returns nil if all the nodes are to be members: the Service does not have the Local external
+// traffic policy, the EndpointSlices are not known yet or none of its endpoints is ready.
+func (e *endpointMembers) memberNodes(service *corev1.Service) sets.Set[string] {
+	if e == nil {
+		return nil
+	}
+
+	key := service.Namespace + "/" + service.Name
+	if service.Spec.ExternalTrafficPolicy != corev1.ServiceExternalTrafficPolicyLocal || !e.slicesSynced() {
+		e.forget(key)
+		return nil
+	}
+
+	nodes, err := e.endpointNodes(service)
+	if err != nil {
+		klog.Warningf("Failed to get the endpoint nodes of Service %s, all the nodes are members: %v", key, err)
+		e.forget(key)
+		return nil
+	}
+
+	e.mu.Lock()
+	e.applied[key] = nodes
+	e.mu.Unlock()
+
+	if nodes.Len() == 0 {
+		return nil
+	}
+	return nodes
+}
+
+// changed returns whether the endpoint nodes of the Service differ from the ones its members were last built from. The
+// Services whose members were not built yet are left to the service controller.
+func (e *endpointMembers) changed(service *corev1.Service) (bool, error) {
+	key := service.Namespace + "/" + service.Name
+
+	e.mu.Lock()
+	applied, ok := e.applied[key]
+	e.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+
+	nodes, err := e.endpointNodes(service)
+	if err != nil {
+		return false, err
+	}
+	return !nodes.Equal(applied), nil
+}
+
+func (e *endpointMembers) forget(key string) {
+	e.mu.Lock()
+	delete(e.applied, key)
+	e.mu.Unlock()
+}
+
+// withEndpoints returns the nodes of the endpoints, or all the nodes if none of them has an endpoint.
+func withEndpoints(nodes []*corev1.Node, endpointNodes sets.Set[string]) []*corev1.Node {
+	if endpointNodes.Len() == 0 {
+		return nodes
+	}
+
+	result := make([]*corev1.Node, 0, endpointNodes.Len())
+	for _, node := range nodes {
+		if endpointNodes.Has(node.Name) {
+			result = append(result, node)
+		}
+	}
+	if len(result) == 0 {
+		return nodes
+	}
+	return result
+}
+
+// loadBalancerNode returns whether the node can be a load balancer member, as the service controller does.
+func loadBalancerNode(node *corev1.Node) bool {
+	if !node.DeletionTimestamp.IsZero() {
+		return false
+	}
+	if _, ok := node.Labels[corev1.LabelNodeExcludeBalancers]; ok {
+		return false
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == toBeDeletedTaint {
+			return false
+		}
+	}
+	return true
+}
+
+// runEndpointMembers updates the members of the Services whose endpoint nodes changed until stop is closed.
+func (os *OpenStack) runEndpointMembers(stop <-chan struct{}) {
+	e := os.endpointMembers
+	defer e.queue.ShutDown()
+
+	klog.Info("Updating the members of the Services with the Local external traffic policy on EndpointSlice changes")
+
+	if !cache.WaitForCacheSync(stop, e.slicesSynced, e.servicesSynced, os.nodeInformerHasSynced) {
+		klog.Error("Failed to sync the caches of the endpoint member sync")
+		return
+	}
+
+	lb, ok := os.LoadBalancer()
+	if !ok {
+		return
+	}
+	lbaas := lb.(*LbaasV2)
+
+	go wait.Until(func() {
+		for os.processNextEndpointMembers(lbaas) {
+		}
+	}, time.Second, stop)
+
+	<-stop
+}
+
+func (os *OpenStack) processNextEndpointMembers(lbaas *LbaasV2) bool {
+	e := os.endpointMembers
+	item, quit := e.queue.Get()
+	if quit {
+		return false
+	}
+	defer e.queue.Done(item)
+
+	key := item.(string)
+	if err := os.syncEndpointMembers(lbaas, key, e.queue.NumRequeues(item) > 0); err != nil {
+		klog.Errorf("Failed to update the members of Service %s after an endpoint change, will retry: %v", key, err)
+		e.queue.AddRateLimited(item)
+		return true
+	}
+
+	e.queue.Forget(item)
+	return true
+}
+
+// syncEndpointMembers updates the members of the Service if its endpoint nodes changed, or unconditionally when
+// retrying a failed update.
+func (os *OpenStack) syncEndpointMembers(lbaas *LbaasV2, key string, retry bool) error {
+	e := os.endpointMembers
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	service, err := e.services.Services(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		e.forget(key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Services handled by another controller or not yet provisioned are skipped.
+	if service.Spec.Type != corev1.ServiceTypeLoadBalancer || service.Spec.LoadBalancerClass != nil {
+		e.forget(key)
+		return nil
+	}
+	if len(service.Status.LoadBalancer.Ingress) == 0 || service.Spec.ExternalTrafficPolicy != corev1.ServiceExternalTrafficPolicyLocal {
+		return nil
+	}
+
+	if !retry {
+		changed, err := e.changed(service)
+		if err != nil || !changed {
+			return err
+		}
+	}
+
+	allNodes, err := os.nodeInformer.Lister().List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %v", err)
+	}
+	nodes := make([]*corev1.Node, 0, len(allNodes))
+	for _, node := range allNodes {
+		if loadBalancerNode(node) {
+			nodes = append(nodes, node)
+		}
+	}
+
+	klog.V(2).Infof("Updating the members of Service %s after a change of its endpoint nodes", key)
+	return lbaas.UpdateLoadBalancer(context.TODO(), os.clusterName, service, nodes)
+}
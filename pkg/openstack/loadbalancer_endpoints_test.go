@@ -0,0 +1,149 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/ptr"
+)
+
+func endpointSlice(name, service string, endpoints ...discoveryv1.Endpoint) *discoveryv1.EndpointSlice {
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      name,
+			Labels:    map[string]string{discoveryv1.LabelServiceName: service},
+		},
+		Endpoints: endpoints,
+	}
+}
+
+func endpoint(node string, ready *bool) discoveryv1.Endpoint {
+	return discoveryv1.Endpoint{
+		NodeName:   ptr.To(node),
+		Conditions: discoveryv1.EndpointConditions{Ready: ready},
+	}
+}
+
+func newTestEndpointMembers(t *testing.T, slices ...*discoveryv1.EndpointSlice) (*endpointMembers, cache.Indexer) {
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	e := newEndpointMembers(factory)
+	e.slicesSynced = func() bool { return true }
+
+	indexer := factory.Discovery().V1().EndpointSlices().Informer().GetIndexer()
+	for _, slice := range slices {
+		assert.NoError(t, indexer.Add(slice))
+	}
+	return e, indexer
+}
+
+func TestEndpointMembers(t *testing.T) {
+	e, indexer := newTestEndpointMembers(t,
+		endpointSlice("web-ipv4", "web", endpoint("node-1", nil), endpoint("node-2", ptr.To(false))),
+		endpointSlice("web-ipv6", "web", endpoint("node-3", ptr.To(true))),
+		endpointSlice("db", "db", endpoint("node-4", nil)),
+		endpointSlice("idle", "idle", endpoint("node-1", ptr.To(false))),
+	)
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec:       corev1.ServiceSpec{ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyLocal},
+	}
+	assert.Equal(t, sets.New("node-1", "node-3"), e.memberNodes(service))
+
+	changed, err := e.changed(service)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	assert.NoError(t, indexer.Update(endpointSlice("web-ipv6", "web", endpoint("node-3", ptr.To(false)))))
+	changed, err = e.changed(service)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, sets.New("node-1"), e.memberNodes(service))
+
+	// A Service without ready endpoint has all the nodes as members
+	idle := service.DeepCopy()
+	idle.Name = "idle"
+	assert.Nil(t, e.memberNodes(idle))
+	assert.NoError(t, indexer.Update(endpointSlice("idle", "idle", endpoint("node-1", nil))))
+	changed, err = e.changed(idle)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	// The Services with the Cluster external traffic policy are forgotten
+	cluster := service.DeepCopy()
+	cluster.Spec.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyCluster
+	assert.Nil(t, e.memberNodes(cluster))
+	assert.NotContains(t, e.applied, "default/web")
+
+	// The Services whose members were not built yet are left to the service controller
+	changed, err = e.changed(service)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// All the nodes are members until the EndpointSlices are known
+	e.slicesSynced = func() bool { return false }
+	assert.Nil(t, e.memberNodes(service))
+
+	var disabled *endpointMembers
+	assert.Nil(t, disabled.memberNodes(service))
+}
+
+func TestEndpointMembersEnqueueSlice(t *testing.T) {
+	e, _ := newTestEndpointMembers(t)
+
+	e.enqueueSlice(endpointSlice("web-abcde", "web"))
+	e.enqueueSlice(cache.DeletedFinalStateUnknown{Key: "default/db-abcde", Obj: endpointSlice("db-abcde", "db")})
+	e.enqueueSlice(endpointSlice("orphan", ""))
+	e.enqueueSlice(&corev1.Service{})
+
+	assert.Equal(t, 2, e.queue.Len())
+	item, _ := e.queue.Get()
+	assert.Equal(t, "default/web", item)
+	item, _ = e.queue.Get()
+	assert.Equal(t, "default/db", item)
+}
+
+func TestWithEndpoints(t *testing.T) {
+	nodes := []*corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-3"}},
+	}
+
+	assert.Equal(t, nodes, withEndpoints(nodes, nil))
+	assert.Equal(t, nodes[1:2], withEndpoints(nodes, sets.New("node-2", "node-4")))
+	// Endpoints on nodes which are not members do not leave the pools empty
+	assert.Equal(t, nodes, withEndpoints(nodes, sets.New("node-4")))
+}
+
+func TestLoadBalancerNode(t *testing.T) {
+	now := metav1.Now()
+	assert.True(t, loadBalancerNode(&corev1.Node{}))
+	assert.False(t, loadBalancerNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{DeletionTimestamp: &now}}))
+	assert.False(t, loadBalancerNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{corev1.LabelNodeExcludeBalancers: ""}}}))
+	assert.False(t, loadBalancerNode(&corev1.Node{Spec: corev1.NodeSpec{Taints: []corev1.Taint{{Key: toBeDeletedTaint}}}}))
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	netutils "k8s.io/utils/net"
+
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+// checkExternalIPs maps the Service spec.externalIPs to additional VIPs of the load balancer. It's a no-op unless
+// external-ip-subnet-id is configured.
+func (lbaas *LbaasV2) checkExternalIPs(service *corev1.Service, svcConf *serviceConfig) error {
+	if len(lbaas.opts.ExternalIPSubnetIDs) == 0 || len(service.Spec.ExternalIPs) == 0 {
+		return nil
+	}
+
+	serviceName := fmt.Sprintf("%s/%s", service.Namespace, service.Name)
+	if !openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureAdditionalVIPs, lbaas.opts.LBProvider) {
+		msg := "ExternalIPs are ignored for Service %s because Octavia provider does not support additional VIPs. Please, upgrade Octavia API to version 2.26 or later to use them"
+		lbaas.eventRecorder.Eventf(service, corev1.EventTypeWarning, eventLBExternalIPsIgnored, msg, serviceName)
+		klog.Warningf(msg, serviceName)
+		return nil
+	}
+
+	var allowedSubnets []subnets.Subnet
+	for _, subnetID := range lbaas.opts.ExternalIPSubnetIDs {
+		mc := metrics.NewMetricContext("subnet", "get")
+		subnet, err := subnets.Get(lbaas.network, subnetID).Extract()
+		if mc.ObserveRequest(err) != nil {
+			return fmt.Errorf("failed to find subnet %q: %v", subnetID, err)
+		}
+		allowedSubnets = append(allowedSubnets, *subnet)
+	}
+
+	additionalVIPs, err := getAdditionalVIPs(service.Spec.ExternalIPs, allowedSubnets)
+	if err != nil {
+		return fmt.Errorf("invalid externalIPs of Service %s: %v", serviceName, err)
+	}
+	svcConf.additionalVIPs = additionalVIPs
+
+	return nil
+}
+
+// getAdditionalVIPs returns an additional VIP for each of the IPs, placed in the first of the subnets whose CIDR
+// contains the IP. An IP outside of all the subnets is an error.
+func getAdditionalVIPs(ips []string, allowedSubnets []subnets.Subnet) ([]openstackutil.AdditionalVIP, error) {
+	var additionalVIPs []openstackutil.AdditionalVIP
+	for _, ip := range ips {
+		addr := netutils.ParseIPSloppy(ip)
+		if addr == nil {
+			return nil, fmt.Errorf("%q is not a valid IP address", ip)
+		}
+
+		found := false
+		for _, subnet := range allowedSubnets {
+			_, cidr, err := net.ParseCIDR(subnet.CIDR)
+			if err != nil {
+				klog.Warningf("Failed to parse CIDR %q of subnet %s: %v", subnet.CIDR, subnet.ID, err)
+				continue
+			}
+			if cidr.Contains(addr) {
+				additionalVIPs = append(additionalVIPs, openstackutil.AdditionalVIP{SubnetID: subnet.ID, IPAddress: ip})
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("IP %s doesn't belong to any of the allowed subnets", ip)
+		}
+	}
+
+	return additionalVIPs, nil
+}
+
+// checkAdditionalVIPs warns when the additional VIPs of an existing load balancer don't match the Service
+// externalIPs. Octavia only allows to set them when the load balancer is created, so they're never updated.
+func (lbaas *LbaasV2) checkAdditionalVIPs(service *corev1.Service, svcConf *serviceConfig, lbID string, isLBOwner bool) {
+	serviceName := fmt.Sprintf("%s/%s", service.Namespace, service.Name)
+	if !isLBOwner {
+		msg := "ExternalIPs are ignored for Service %s because it shares the load balancer %s owned by another Service"
+		lbaas.eventRecorder.Eventf(service, corev1.EventTypeWarning, eventLBExternalIPsIgnored, msg, serviceName, lbID)
+		klog.Warningf(msg, serviceName, lbID)
+		return
+	}
+
+	current, err := openstackutil.GetLoadbalancerAdditionalVIPs(lbaas.lb, lbID)
+	if err != nil {
+		klog.Errorf("Failed to get additional VIPs of load balancer %s: %v", lbID, err)
+		return
+	}
+
+	currentIPs := make(map[string]bool)
+	for _, vip := range current {
+		currentIPs[vip.IPAddress] = true
+	}
+	for _, vip := range svcConf.additionalVIPs {
+		if !currentIPs[vip.IPAddress] {
+			msg := "ExternalIP %s of Service %s is missing from the load balancer %s, additional VIPs can only be set when the load balancer is created"
+			lbaas.eventRecorder.Eventf(service, corev1.EventTypeWarning, eventLBExternalIPsIgnored, msg, vip.IPAddress, serviceName, lbID)
+			klog.Warningf(msg, vip.IPAddress, serviceName, lbID)
+		}
+	}
+}
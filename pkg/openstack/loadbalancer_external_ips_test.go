@@ -0,0 +1,72 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
+	"github.com/stretchr/testify/assert"
+
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+func TestGetAdditionalVIPs(t *testing.T) {
+	allowedSubnets := []subnets.Subnet{
+		{ID: "subnet-v4", CIDR: "192.0.2.0/24"},
+		{ID: "subnet-broken", CIDR: "not-a-cidr"},
+		{ID: "subnet-v6", CIDR: "2001:db8::/64"},
+	}
+
+	tests := []struct {
+		name     string
+		ips      []string
+		expected []openstackutil.AdditionalVIP
+		wantErr  bool
+	}{
+		{
+			name: "IPs in the allowed subnets",
+			ips:  []string{"192.0.2.10", "2001:db8::10"},
+			expected: []openstackutil.AdditionalVIP{
+				{SubnetID: "subnet-v4", IPAddress: "192.0.2.10"},
+				{SubnetID: "subnet-v6", IPAddress: "2001:db8::10"},
+			},
+		},
+		{
+			name:    "IP outside of the allowed subnets",
+			ips:     []string{"192.0.2.10", "198.51.100.10"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid IP",
+			ips:     []string{"foo"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vips, err := getAdditionalVIPs(test.ips, allowedSubnets)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, vips)
+		})
+	}
+}
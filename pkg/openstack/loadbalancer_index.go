@@ -0,0 +1,224 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/client"
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+// lbIndex is an index of the Octavia load balancers of the project, refreshed by the replicas waiting for the
+// leadership, or listed once by the leader when it starts. Once leading, a replica stops refreshing it and each load
+// balancer of the index serves a single lookup, so that the first resync after a failover or a restart doesn't get
+// every load balancer from Octavia again.
+type lbIndex struct {
+	mu   sync.Mutex
+	byID map[string]loadbalancers.LoadBalancer
+	// byName has the IDs of the load balancers owned by the cluster, i.e. whose name or one of its tags is the
+	// name of a load balancer of the cluster, by name.
+	byName      map[string][]string
+	ownerPrefix string
+	refreshed   time.Time
+	maxAge      time.Duration
+	now         func() time.Time
+}
+
+func newLBIndex(maxAge time.Duration, clusterName string) *lbIndex {
+	return &lbIndex{
+		maxAge:      maxAge,
+		ownerPrefix: fmt.Sprintf("%s%s_", servicePrefix, clusterName),
+		now:         time.Now,
+	}
+}
+
+// isOwned returns true if the load balancer is owned by the cluster.
+func (i *lbIndex) isOwned(lb loadbalancers.LoadBalancer) bool {
+	if strings.HasPrefix(lb.Name, i.ownerPrefix) {
+		return true
+	}
+	for _, tag := range lb.Tags {
+		if strings.HasPrefix(tag, i.ownerPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *lbIndex) set(lbs []loadbalancers.LoadBalancer) {
+	byID := make(map[string]loadbalancers.LoadBalancer, len(lbs))
+	byName := make(map[string][]string)
+	for _, lb := range lbs {
+		byID[lb.ID] = lb
+		if i.isOwned(lb) {
+			byName[lb.Name] = append(byName[lb.Name], lb.ID)
+		}
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.byID = byID
+	i.byName = byName
+	i.refreshed = i.now()
+}
+
+func (i *lbIndex) refresh(lbClient *gophercloud.ServiceClient) error {
+	lbs, err := openstackutil.GetLoadBalancers(lbClient, loadbalancers.ListOpts{})
+	if err != nil {
+		return err
+	}
+	i.set(lbs)
+	return nil
+}
+
+// take returns the indexed load balancer and removes it from the index. Only the ACTIVE load balancers of an index
+// younger than maxAge are returned. Shared load balancers are never returned as their tags, which track the Services
+// sharing them, may have changed since.
+func (i *lbIndex) take(id string) (*loadbalancers.LoadBalancer, bool) {
+	if i == nil {
+		return nil, false
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.takeLocked(id)
+}
+
+func (i *lbIndex) takeLocked(id string) (*loadbalancers.LoadBalancer, bool) {
+	lb, ok := i.byID[id]
+	if !ok || i.now().Sub(i.refreshed) > i.maxAge {
+		return nil, false
+	}
+	delete(i.byID, id)
+
+	if lb.ProvisioningStatus != activeStatus || len(lb.Tags) > 1 {
+		return nil, false
+	}
+	return &lb, true
+}
+
+// takeByName returns the indexed load balancer owned by the cluster with the name, under the conditions of take.
+// Nothing is returned if several load balancers not being deleted have the name, so that the lookup in Octavia
+// reports the conflict. A load balancer missing in the index is looked up in Octavia as well, as it may have been
+// created since the index was refreshed.
+func (i *lbIndex) takeByName(name string) (*loadbalancers.LoadBalancer, bool) {
+	if i == nil {
+		return nil, false
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	ids := i.byName[name]
+	delete(i.byName, name)
+
+	var found []string
+	for _, id := range ids {
+		if lb, ok := i.byID[id]; ok && lb.ProvisioningStatus != "DELETED" && lb.ProvisioningStatus != "PENDING_DELETE" {
+			found = append(found, id)
+		}
+	}
+	if len(found) != 1 {
+		return nil, false
+	}
+	return i.takeLocked(found[0])
+}
+
+// getLoadbalancerByID gets the load balancer from the warm standby index if possible, from Octavia otherwise.
+func (lbaas *LbaasV2) getLoadbalancerByID(id string) (*loadbalancers.LoadBalancer, error) {
+	if lb, ok := lbaas.lbIndex.take(id); ok {
+		klog.V(4).Infof("Load balancer %s found in the warm standby index", id)
+		return lb, nil
+	}
+	return openstackutil.GetLoadbalancerByID(lbaas.lb, id)
+}
+
+// getLoadbalancerByName gets the load balancer from the load balancer index if possible, by name or legacy name from
+// Octavia otherwise.
+func (lbaas *LbaasV2) getLoadbalancerByName(name string, legacyName string) (*loadbalancers.LoadBalancer, error) {
+	if lb, ok := lbaas.lbIndex.takeByName(name); ok {
+		klog.V(4).Infof("Load balancer %s found by name %s in the load balancer index", lb.ID, name)
+		return lb, nil
+	}
+	return getLoadbalancerByName(lbaas.lb, name, legacyName)
+}
+
+// StartWarmStandby keeps the caches needed by the leader warm while waiting for the leadership, if warm-standby-period
+// is set: the Service and Node informers of the controllers are started, and the index of the Octavia load balancers
+// is refreshed until the replica leads.
+func (os *OpenStack) StartWarmStandby(informerFactory informers.SharedInformerFactory, stop <-chan struct{}) {
+	period := os.lbOpts.WarmStandbyPeriod.Duration
+	if !os.lbOpts.Enabled || period <= 0 {
+		return
+	}
+
+	klog.Infof("Warm standby enabled, refreshing the load balancer index every %v", period)
+
+	informerFactory.Core().V1().Services().Informer()
+	informerFactory.Core().V1().Nodes().Informer()
+	informerFactory.Start(stop)
+
+	lbClient, err := client.NewLoadBalancerV2(os.provider, os.epOpts)
+	if err != nil {
+		klog.Errorf("Failed to create an OpenStack LoadBalancer client, the load balancer index is disabled: %v", err)
+		return
+	}
+	os.setComponent(componentLoadBalancer, lbClient)
+
+	os.lbIndex = newLBIndex(period, os.clusterName)
+	os.leading = make(chan struct{})
+	go wait.Until(func() {
+		if err := os.lbIndex.refresh(lbClient); err != nil {
+			klog.Errorf("Failed to refresh the load balancer index: %v", err)
+		}
+	}, period, os.leading)
+}
+
+// buildStartupIndex lists the Octavia load balancers of the project once when the leader starts, if
+// startup-index-period is set and there is no warm standby index, so that the first resync gets the load balancers of
+// the Services from the index instead of one lookup per Service.
+func (os *OpenStack) buildStartupIndex() {
+	period := os.lbOpts.StartupIndexPeriod.Duration
+	if !os.lbOpts.Enabled || period <= 0 || os.lbIndex != nil {
+		return
+	}
+
+	lbClient, err := client.NewLoadBalancerV2(os.provider, os.epOpts)
+	if err != nil {
+		klog.Errorf("Failed to create an OpenStack LoadBalancer client, the load balancer index is disabled: %v", err)
+		return
+	}
+	os.setComponent(componentLoadBalancer, lbClient)
+
+	index := newLBIndex(period, os.clusterName)
+	if err := index.refresh(lbClient); err != nil {
+		klog.Errorf("Failed to list the load balancers, the load balancer index is disabled: %v", err)
+		return
+	}
+	klog.Infof("Load balancer index built with %d load balancers, %d owned by the cluster", len(index.byID), len(index.byName))
+	os.lbIndex = index
+}
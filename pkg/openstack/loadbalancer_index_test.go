@@ -0,0 +1,104 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLBIndexTake(t *testing.T) {
+	now := time.Now()
+	index := newLBIndex(time.Minute, "cluster")
+	index.now = func() time.Time { return now }
+	index.set([]loadbalancers.LoadBalancer{
+		{ID: "active", ProvisioningStatus: activeStatus, Tags: []string{"kube_service_cluster_default_svc"}},
+		{ID: "pending", ProvisioningStatus: "PENDING_UPDATE"},
+		{ID: "shared", ProvisioningStatus: activeStatus, Tags: []string{"kube_service_cluster_default_a", "kube_service_cluster_default_b"}},
+		{ID: "expired", ProvisioningStatus: activeStatus},
+	})
+
+	lb, ok := index.take("active")
+	assert.True(t, ok)
+	assert.Equal(t, "active", lb.ID)
+
+	// Each load balancer serves a single lookup
+	_, ok = index.take("active")
+	assert.False(t, ok)
+
+	_, ok = index.take("pending")
+	assert.False(t, ok)
+	_, ok = index.take("shared")
+	assert.False(t, ok)
+	_, ok = index.take("unknown")
+	assert.False(t, ok)
+
+	now = now.Add(2 * time.Minute)
+	_, ok = index.take("expired")
+	assert.False(t, ok)
+
+	var disabled *lbIndex
+	_, ok = disabled.take("active")
+	assert.False(t, ok)
+}
+
+func TestLBIndexTakeByName(t *testing.T) {
+	index := newLBIndex(time.Minute, "cluster")
+	index.set([]loadbalancers.LoadBalancer{
+		{ID: "owned", Name: "kube_service_cluster_default_a", ProvisioningStatus: activeStatus, Tags: []string{"kube_service_cluster_default_a"}},
+		{ID: "renamed", Name: "custom", ProvisioningStatus: activeStatus, Tags: []string{"kube_service_cluster_default_b"}},
+		{ID: "other-cluster", Name: "kube_service_other_default_a", ProvisioningStatus: activeStatus},
+		{ID: "dup1", Name: "kube_service_cluster_default_dup", ProvisioningStatus: activeStatus},
+		{ID: "dup2", Name: "kube_service_cluster_default_dup", ProvisioningStatus: activeStatus},
+		{ID: "deleting", Name: "kube_service_cluster_default_c", ProvisioningStatus: "PENDING_DELETE"},
+		{ID: "recreated", Name: "kube_service_cluster_default_c", ProvisioningStatus: activeStatus},
+	})
+
+	lb, ok := index.takeByName("kube_service_cluster_default_a")
+	assert.True(t, ok)
+	assert.Equal(t, "owned", lb.ID)
+	_, ok = index.takeByName("kube_service_cluster_default_a")
+	assert.False(t, ok)
+	// The load balancer is taken for both lookups
+	_, ok = index.take("owned")
+	assert.False(t, ok)
+
+	// Owned through its tag
+	lb, ok = index.takeByName("custom")
+	assert.True(t, ok)
+	assert.Equal(t, "renamed", lb.ID)
+
+	_, ok = index.takeByName("kube_service_other_default_a")
+	assert.False(t, ok)
+	_, ok = index.take("other-cluster")
+	assert.True(t, ok)
+
+	// Conflicts are left to Octavia
+	_, ok = index.takeByName("kube_service_cluster_default_dup")
+	assert.False(t, ok)
+
+	lb, ok = index.takeByName("kube_service_cluster_default_c")
+	assert.True(t, ok)
+	assert.Equal(t, "recreated", lb.ID)
+
+	var disabled *lbIndex
+	_, ok = disabled.takeByName("kube_service_cluster_default_a")
+	assert.False(t, ok)
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	v2pools "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	cpoutil "k8s.io/cloud-provider-openstack/pkg/util"
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+// labelTagPrefix marks the listener and pool tags holding a Service label, see LoadBalancerOpts.ServiceLabelTags.
+const labelTagPrefix = "label:"
+
+// serviceLabelTags returns the tags of the Service labels propagated to the listeners and pools.
+func (lbaas *LbaasV2) serviceLabelTags(service *corev1.Service) []string {
+	var tags []string
+	for _, key := range strings.Split(lbaas.opts.ServiceLabelTags, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		if value, ok := service.Labels[key]; ok {
+			tags = append(tags, cpoutil.Sprintf255("%s%s=%s", labelTagPrefix, key, value))
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// serviceLabelDescription returns the description of the listeners and pools of the Service, empty if the labels
+// aren't propagated.
+func (lbaas *LbaasV2) serviceLabelDescription(service *corev1.Service, labelTags []string) string {
+	if strings.TrimSpace(lbaas.opts.ServiceLabelTags) == "" {
+		return ""
+	}
+
+	description := "Kubernetes Service " + service.Namespace + "/" + service.Name
+	if len(labelTags) > 0 {
+		labels := make([]string, 0, len(labelTags))
+		for _, tag := range labelTags {
+			labels = append(labels, strings.TrimPrefix(tag, labelTagPrefix))
+		}
+		description += " (" + strings.Join(labels, ", ") + ")"
+	}
+	return cpoutil.CutString255(description)
+}
+
+// withLabelTags returns the tags with the label tags replaced by labelTags.
+func withLabelTags(tags []string, labelTags []string) []string {
+	newTags := make([]string, 0, len(tags)+len(labelTags))
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, labelTagPrefix) {
+			newTags = append(newTags, tag)
+		}
+	}
+	return append(newTags, labelTags...)
+}
+
+// updatePoolLabels keeps the tags and description of the pool in sync with the Service labels.
+func (lbaas *LbaasV2) updatePoolLabels(lbID string, pool *v2pools.Pool, svcConf *serviceConfig) error {
+	updateOpts := v2pools.UpdateOpts{}
+	poolChanged := false
+
+	if svcConf.supportLBTags {
+		newTags := withLabelTags(pool.Tags, svcConf.labelTags)
+		if !cpoutil.StringListEqual(newTags, pool.Tags) {
+			updateOpts.Tags = &newTags
+			poolChanged = true
+		}
+	}
+
+	if svcConf.labelDescription != "" && svcConf.labelDescription != pool.Description {
+		updateOpts.Description = &svcConf.labelDescription
+		poolChanged = true
+	}
+
+	if !poolChanged {
+		return nil
+	}
+
+	klog.InfoS("Updating pool labels", "poolID", pool.ID, "lbID", lbID, "updateOpts", updateOpts)
+	if err := openstackutil.UpdatePool(lbaas.lb, lbID, pool.ID, updateOpts); err != nil {
+		return fmt.Errorf("failed to update pool %s of loadbalancer %s: %v", pool.ID, lbID, err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestServiceLabelTags(t *testing.T) {
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default",
+		Name:      "web",
+		Labels:    map[string]string{"app": "web", "tier": "frontend", "other": "ignored"},
+	}}
+
+	lbaas := &LbaasV2{LoadBalancer{opts: LoadBalancerOpts{ServiceLabelTags: "tier, app,missing"}}}
+	tags := lbaas.serviceLabelTags(service)
+	assert.Equal(t, []string{"label:app=web", "label:tier=frontend"}, tags)
+	assert.Equal(t, "Kubernetes Service default/web (app=web, tier=frontend)", lbaas.serviceLabelDescription(service, tags))
+	assert.Equal(t, "Kubernetes Service default/web", lbaas.serviceLabelDescription(service, nil))
+
+	service.Labels["app"] = strings.Repeat("a", 300)
+	tags = lbaas.serviceLabelTags(service)
+	assert.Len(t, tags[0], 255)
+	assert.Len(t, lbaas.serviceLabelDescription(service, tags), 255)
+
+	disabled := &LbaasV2{}
+	assert.Empty(t, disabled.serviceLabelTags(service))
+	assert.Empty(t, disabled.serviceLabelDescription(service, nil))
+}
+
+func TestWithLabelTags(t *testing.T) {
+	tags := []string{"kube_service_cluster_default_web", "label:app=old", "user-tag"}
+	assert.Equal(t, []string{"kube_service_cluster_default_web", "user-tag", "label:app=web"}, withLabelTags(tags, []string{"label:app=web"}))
+	assert.Equal(t, []string{"kube_service_cluster_default_web", "user-tag"}, withLabelTags(tags, nil))
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/security/groups"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/networks"
+	neutronports "github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	netutils "k8s.io/utils/net"
+
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+const (
+	memberPortSelectorNetworkName   = "network-name"
+	memberPortSelectorSubnetID      = "subnet-id"
+	memberPortSelectorSecurityGroup = "security-group"
+)
+
+// memberPortSelector selects the node port whose fixed IP becomes the load balancer member address. A port has to
+// match all the criteria that are set. A selector lives for a single reconcile of the Service, the ports of each node
+// are listed once and reused by all the pools and the security group.
+type memberPortSelector struct {
+	networkIDs       sets.Set[string]
+	subnetID         string
+	securityGroupIDs sets.Set[string]
+
+	// nodePorts caches the ports of the nodes by instance ID
+	nodePorts map[string][]PortWithTrunkDetails
+}
+
+// getMemberPortSelector parses the member-port-selector of the Service, resolving the network and security group
+// names to IDs. It returns nil if no selector is configured.
+func (lbaas *LbaasV2) getMemberPortSelector(service *corev1.Service) (*memberPortSelector, error) {
+	criteria := getKeyValueFromServiceAnnotation(service, ServiceAnnotationLoadBalancerMemberPortSelector, lbaas.opts.MemberPortSelector)
+	if len(criteria) == 0 {
+		return nil, nil
+	}
+
+	selector := &memberPortSelector{nodePorts: make(map[string][]PortWithTrunkDetails)}
+	for key, value := range criteria {
+		if value == "" {
+			return nil, fmt.Errorf("member port selector %q has no value", key)
+		}
+
+		switch key {
+		case memberPortSelectorNetworkName:
+			mc := metrics.NewMetricContext("network", "list")
+			allPages, err := networks.List(lbaas.network, networks.ListOpts{Name: value}).AllPages()
+			if mc.ObserveRequest(err) != nil {
+				return nil, fmt.Errorf("failed to list networks named %q: %v", value, err)
+			}
+			nets, err := networks.ExtractNetworks(allPages)
+			if err != nil {
+				return nil, err
+			}
+			if len(nets) == 0 {
+				return nil, fmt.Errorf("network %q not found", value)
+			}
+			selector.networkIDs = sets.New[string]()
+			for _, n := range nets {
+				selector.networkIDs.Insert(n.ID)
+			}
+		case memberPortSelectorSubnetID:
+			selector.subnetID = value
+		case memberPortSelectorSecurityGroup:
+			ids, err := getSecurityGroupIDs(lbaas.network, value)
+			if err != nil {
+				return nil, err
+			}
+			selector.securityGroupIDs = sets.New[string](ids...)
+		default:
+			return nil, fmt.Errorf("unknown member port selector %q, supported selectors are %s, %s and %s", key,
+				memberPortSelectorNetworkName, memberPortSelectorSubnetID, memberPortSelectorSecurityGroup)
+		}
+	}
+
+	return selector, nil
+}
+
+// getSecurityGroupIDs returns the IDs of the security groups named nameOrID, or nameOrID itself if there are none.
+func getSecurityGroupIDs(client *gophercloud.ServiceClient, nameOrID string) ([]string, error) {
+	mc := metrics.NewMetricContext("security_group", "list")
+	allPages, err := groups.List(client, groups.ListOpts{Name: nameOrID}).AllPages()
+	if mc.ObserveRequest(err) != nil {
+		return nil, fmt.Errorf("failed to list security groups named %q: %v", nameOrID, err)
+	}
+	sgs, err := groups.ExtractGroups(allPages)
+	if err != nil {
+		return nil, err
+	}
+	if len(sgs) == 0 {
+		return []string{nameOrID}, nil
+	}
+
+	ids := make([]string, 0, len(sgs))
+	for _, sg := range sgs {
+		ids = append(ids, sg.ID)
+	}
+	return ids, nil
+}
+
+// matches returns true if the port satisfies all the criteria of the selector.
+func (s *memberPortSelector) matches(port neutronports.Port) bool {
+	if s.networkIDs != nil && !s.networkIDs.Has(port.NetworkID) {
+		return false
+	}
+	if s.securityGroupIDs != nil && !s.securityGroupIDs.HasAny(port.SecurityGroups...) {
+		return false
+	}
+	return true
+}
+
+// selectMemberAddress returns the first fixed IP of the preferred IP family, and its subnet, of the ports matching
+// the selector.
+func (s *memberPortSelector) selectMemberAddress(ports []PortWithTrunkDetails, preferredIPFamily corev1.IPFamily) (string, string, error) {
+	for _, port := range ports {
+		if !s.matches(port.Port) {
+			continue
+		}
+		for _, fixedIP := range port.FixedIPs {
+			if s.subnetID != "" && fixedIP.SubnetID != s.subnetID {
+				continue
+			}
+			switch preferredIPFamily {
+			case corev1.IPv4Protocol:
+				if !netutils.IsIPv4String(fixedIP.IPAddress) {
+					continue
+				}
+			case corev1.IPv6Protocol:
+				if !netutils.IsIPv6String(fixedIP.IPAddress) {
+					continue
+				}
+			}
+			return fixedIP.IPAddress, fixedIP.SubnetID, nil
+		}
+	}
+
+	return "", "", cpoerrors.ErrNoAddressFound
+}
+
+// memberAddressForLB returns the address of the node used as load balancer member, and the subnet to create the
+// member in. Without a member port selector, it's the node address and the member subnet of the Service.
+func memberAddressForLB(network *gophercloud.ServiceClient, node *corev1.Node, svcConf *serviceConfig) (string, string, error) {
+	if svcConf.memberPortSelector == nil {
+		addr, err := nodeAddressForLB(node, svcConf.preferredIPFamily)
+		return addr, svcConf.lbMemberSubnetID, err
+	}
+
+	instanceID, _, err := instanceIDFromProviderID(node.Spec.ProviderID)
+	if err != nil {
+		return "", "", fmt.Errorf("can't determine instance ID from ProviderID of node %s: %w", node.Name, err)
+	}
+
+	selector := svcConf.memberPortSelector
+	ports, ok := selector.nodePorts[instanceID]
+	if !ok {
+		mc := metrics.NewMetricContext("port", "list")
+		ports, err = getAttachedPorts(network, instanceID)
+		if mc.ObserveRequest(err) != nil {
+			return "", "", fmt.Errorf("failed to list the ports of node %s: %w", node.Name, err)
+		}
+		if selector.nodePorts == nil {
+			selector.nodePorts = make(map[string][]PortWithTrunkDetails)
+		}
+		selector.nodePorts[instanceID] = ports
+	}
+
+	return selector.selectMemberAddress(ports, svcConf.preferredIPFamily)
+}
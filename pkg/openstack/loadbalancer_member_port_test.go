@@ -0,0 +1,155 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	neutronports "github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+func TestSelectMemberAddress(t *testing.T) {
+	ports := []PortWithTrunkDetails{
+		{
+			Port: neutronports.Port{
+				NetworkID:      "mgmt-net",
+				SecurityGroups: []string{"mgmt-sg"},
+				FixedIPs: []neutronports.IP{
+					{SubnetID: "mgmt-subnet", IPAddress: "10.0.0.10"},
+				},
+			},
+		},
+		{
+			Port: neutronports.Port{
+				NetworkID:      "data-net",
+				SecurityGroups: []string{"default", "data-sg"},
+				FixedIPs: []neutronports.IP{
+					{SubnetID: "data-subnet-v6", IPAddress: "fd00::10"},
+					{SubnetID: "data-subnet", IPAddress: "192.168.0.10"},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name              string
+		selector          memberPortSelector
+		preferredIPFamily corev1.IPFamily
+		expectedAddr      string
+		expectedSubnetID  string
+		expectedErr       error
+	}{
+		{
+			name:             "by network",
+			selector:         memberPortSelector{networkIDs: sets.New("data-net")},
+			expectedAddr:     "fd00::10",
+			expectedSubnetID: "data-subnet-v6",
+		},
+		{
+			name:              "by network with IPv4 preferred",
+			selector:          memberPortSelector{networkIDs: sets.New("data-net")},
+			preferredIPFamily: corev1.IPv4Protocol,
+			expectedAddr:      "192.168.0.10",
+			expectedSubnetID:  "data-subnet",
+		},
+		{
+			name:             "by subnet",
+			selector:         memberPortSelector{subnetID: "data-subnet"},
+			expectedAddr:     "192.168.0.10",
+			expectedSubnetID: "data-subnet",
+		},
+		{
+			name:             "by security group",
+			selector:         memberPortSelector{securityGroupIDs: sets.New("mgmt-sg")},
+			expectedAddr:     "10.0.0.10",
+			expectedSubnetID: "mgmt-subnet",
+		},
+		{
+			name: "no port matching all the criteria",
+			selector: memberPortSelector{
+				networkIDs:       sets.New("data-net"),
+				securityGroupIDs: sets.New("mgmt-sg"),
+			},
+			expectedErr: cpoerrors.ErrNoAddressFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addr, subnetID, err := test.selector.selectMemberAddress(ports, test.preferredIPFamily)
+			assert.Equal(t, test.expectedErr, err)
+			assert.Equal(t, test.expectedAddr, addr)
+			assert.Equal(t, test.expectedSubnetID, subnetID)
+		})
+	}
+}
+
+func TestMemberAddressForLBListsPortsOnce(t *testing.T) {
+	listed := map[string]int{}
+	failing := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceID := r.URL.Query().Get("device_id")
+		listed[deviceID]++
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"ports": [{"id": "port-%s", "network_id": "data-net", "port_security_enabled": true, "security_groups": ["lb-sg"], "fixed_ips": [{"subnet_id": "data-subnet", "ip_address": "192.168.0.10"}]}]}`, deviceID)
+	}))
+	defer server.Close()
+
+	network := &gophercloud.ServiceClient{
+		ProviderClient: &gophercloud.ProviderClient{HTTPClient: http.Client{Transport: http.DefaultTransport}},
+		Endpoint:       server.URL + "/",
+	}
+	svcConf := &serviceConfig{
+		memberPortSelector: &memberPortSelector{networkIDs: sets.New[string]("data-net")},
+		preferredIPFamily:  corev1.IPv4Protocol,
+	}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Spec:       corev1.NodeSpec{ProviderID: "openstack:///server-1"},
+	}
+
+	// The ports are listed once per node for all the pools and the security group
+	for i := 0; i < 3; i++ {
+		addr, subnetID, err := memberAddressForLB(network, node, svcConf)
+		assert.NoError(t, err)
+		assert.Equal(t, "192.168.0.10", addr)
+		assert.Equal(t, "data-subnet", subnetID)
+	}
+	assert.NoError(t, applyNodeSecurityGroupIDForLB(network, svcConf, []*corev1.Node{node}, "lb-sg"))
+	assert.Equal(t, 2, listed["server-1"], "expected one listing for the member addresses and one with the port security for the security group")
+
+	// The listing errors are reported instead of skipping the node
+	failing = true
+	failingConf := &serviceConfig{memberPortSelector: &memberPortSelector{}, preferredIPFamily: corev1.IPv4Protocol}
+	_, _, err := memberAddressForLB(network, node, failingConf)
+	assert.Error(t, err)
+	assert.Error(t, applyNodeSecurityGroupIDForLB(network, failingConf, []*corev1.Node{node}, "lb-sg"))
+}
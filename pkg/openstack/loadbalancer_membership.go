@@ -0,0 +1,227 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// NodeAnnotationLoadBalancerMemberOf lists the load balancers the node is a member of, as a comma-separated list of
+// <namespace>/<service>=<load balancer ID> sorted by Service, see LoadBalancerOpts.NodeMembershipAnnotation.
+const NodeAnnotationLoadBalancerMemberOf = "loadbalancer.openstack.org/member-of"
+
+// nodeMemberships tracks the member nodes of the load balancer of each Service, to annotate the nodes with the load
+// balancers they are members of. The memberships are kept in memory and rebuilt as the Services are reconciled after
+// a restart, the entries of the annotations for the Services not reconciled yet are kept meanwhile.
+type nodeMemberships struct {
+	mu      sync.Mutex
+	kclient kubernetes.Interface
+	// byService has the load balancer and the member nodes of each Service, by <namespace>/<name>
+	byService map[string]lbMembers
+	// removed has the Services whose load balancer was deleted, by <namespace>/<name>, so that they're dropped from
+	// the annotations still listing them
+	removed sets.Set[string]
+}
+
+type lbMembers struct {
+	lbID  string
+	nodes sets.Set[string]
+}
+
+func newNodeMemberships(kclient kubernetes.Interface) *nodeMemberships {
+	return &nodeMemberships{
+		kclient:   kclient,
+		byService: make(map[string]lbMembers),
+		removed:   sets.New[string](),
+	}
+}
+
+// set records the member nodes of the load balancer of the Service and annotates its current and former member
+// nodes. The annotation of a node is only patched if it differs from the current one, so that an annotation which
+// failed to be updated is repaired on the next reconciliation.
+func (m *nodeMemberships) set(ctx context.Context, service *corev1.Service, lbID string, members []*corev1.Node, nodes []*corev1.Node) {
+	if m == nil {
+		return
+	}
+
+	key := fmt.Sprintf("%s/%s", service.Namespace, service.Name)
+	names := sets.New[string]()
+	for _, node := range members {
+		names.Insert(node.Name)
+	}
+
+	m.mu.Lock()
+	old := m.byService[key]
+	m.byService[key] = lbMembers{lbID: lbID, nodes: names}
+	m.removed.Delete(key)
+	m.mu.Unlock()
+
+	m.annotate(ctx, names.Union(old.nodes), nodes)
+}
+
+// remove forgets the load balancer of the deleted Service and annotates its former member nodes. If the load
+// balancer isn't tracked, e.g. it's deleted before being reconciled since a restart, the nodes whose annotation
+// lists it are annotated.
+func (m *nodeMemberships) remove(ctx context.Context, service *corev1.Service) {
+	if m == nil {
+		return
+	}
+
+	key := fmt.Sprintf("%s/%s", service.Namespace, service.Name)
+
+	m.mu.Lock()
+	old, ok := m.byService[key]
+	delete(m.byService, key)
+	m.removed.Insert(key)
+	m.mu.Unlock()
+
+	if ok {
+		m.annotate(ctx, old.nodes, nil)
+		return
+	}
+
+	list, err := m.kclient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.Warningf("Failed to list the nodes to remove the load balancer of service %s from their memberships: %v", key, err)
+		return
+	}
+
+	names := sets.New[string]()
+	var nodes []*corev1.Node
+	for i := range list.Items {
+		node := &list.Items[i]
+		if _, ok := parseMemberOf(node.Annotations[NodeAnnotationLoadBalancerMemberOf])[key]; ok {
+			names.Insert(node.Name)
+			nodes = append(nodes, node)
+		}
+	}
+	m.annotate(ctx, names, nodes)
+}
+
+// parseMemberOf returns the load balancer IDs by Service of the annotation of a node.
+func parseMemberOf(value string) map[string]string {
+	lbs := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		if key, lbID, ok := strings.Cut(entry, "="); ok && key != "" {
+			lbs[key] = lbID
+		}
+	}
+	return lbs
+}
+
+// memberOf returns the value of the annotation of the node: the tracked memberships, merged with the entries of the
+// current value for the Services which are neither tracked nor removed.
+func (m *nodeMemberships) memberOf(nodeName string, current string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lbs := parseMemberOf(current)
+	for key := range lbs {
+		if _, ok := m.byService[key]; ok || m.removed.Has(key) {
+			delete(lbs, key)
+		}
+	}
+	for key, members := range m.byService {
+		if members.nodes.Has(nodeName) {
+			lbs[key] = members.lbID
+		}
+	}
+
+	entries := make([]string, 0, len(lbs))
+	for key, lbID := range lbs {
+		entries = append(entries, key+"="+lbID)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+// annotate patches the annotation of the nodes differing from their memberships. It's called without holding the
+// lock, so that the reconciliations of the other Services don't wait for the API calls. The nodes missing from nodes
+// are read from the API.
+func (m *nodeMemberships) annotate(ctx context.Context, nodeNames sets.Set[string], nodes []*corev1.Node) {
+	current := make(map[string]*corev1.Node, len(nodes))
+	for _, node := range nodes {
+		current[node.Name] = node
+	}
+
+	for _, name := range sets.List(nodeNames) {
+		node, ok := current[name]
+		if !ok {
+			var err error
+			node, err = m.kclient.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				// The annotation is updated again on the next reconciliation
+				klog.Warningf("Failed to get node %s to update its load balancer membership: %v", name, err)
+				continue
+			}
+		}
+
+		currentValue := node.Annotations[NodeAnnotationLoadBalancerMemberOf]
+		value := m.memberOf(name, currentValue)
+		if value == currentValue {
+			continue
+		}
+
+		var annotation interface{}
+		if value != "" {
+			annotation = value
+		}
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]interface{}{NodeAnnotationLoadBalancerMemberOf: annotation},
+			},
+		})
+		if err != nil {
+			klog.Errorf("Failed to build the load balancer membership patch of node %s: %v", name, err)
+			continue
+		}
+
+		klog.V(4).InfoS("Updating load balancer membership of node", "node", name, "memberOf", value)
+		if _, err := m.kclient.CoreV1().Nodes().Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			// The annotation is updated again on the next reconciliation
+			klog.Warningf("Failed to update the load balancer membership of node %s: %v", name, err)
+		}
+	}
+}
+
+// lbMemberNodes returns the nodes which are members of the pools of the load balancer of the Service: the nodes of
+// its endpoints if the endpoint member sync applies, except the drained ones. No node is a member if the Service has
+// no node port.
+func lbMemberNodes(service *corev1.Service, nodes []*corev1.Node, svcConf *serviceConfig) []*corev1.Node {
+	for _, port := range service.Spec.Ports {
+		if port.NodePort != 0 {
+			return withoutDrainedNodes(withEndpoints(nodes, svcConf.endpointNodes))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func membershipNode(name string, annotations map[string]string) *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+}
+
+func membershipService(name string) *corev1.Service {
+	return &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+}
+
+func TestNodeMemberships(t *testing.T) {
+	ctx := context.Background()
+	kclient := fake.NewSimpleClientset(membershipNode("node-1", nil), membershipNode("node-2", nil), membershipNode("node-3", nil))
+	m := newNodeMemberships(kclient)
+
+	memberOf := func(name string) string {
+		node, err := kclient.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return node.Annotations[NodeAnnotationLoadBalancerMemberOf]
+	}
+	nodes := func() []*corev1.Node {
+		list, err := kclient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		assert.NoError(t, err)
+		var nodes []*corev1.Node
+		for i := range list.Items {
+			nodes = append(nodes, &list.Items[i])
+		}
+		return nodes
+	}
+
+	all := nodes()
+	m.set(ctx, membershipService("web"), "lb-web", all[:2], all)
+	m.set(ctx, membershipService("api"), "lb-api", all[1:], nodes())
+	assert.Equal(t, "default/web=lb-web", memberOf("node-1"))
+	assert.Equal(t, "default/api=lb-api,default/web=lb-web", memberOf("node-2"))
+	assert.Equal(t, "default/api=lb-api", memberOf("node-3"))
+
+	// Only the annotations which differ are patched
+	all = nodes()
+	kclient.ClearActions()
+	m.set(ctx, membershipService("web"), "lb-web", all[:2], all)
+	assert.Empty(t, kclient.Actions())
+
+	// node-2 is drained from the load balancer of web
+	m.set(ctx, membershipService("web"), "lb-web", all[:1], all)
+	assert.Len(t, kclient.Actions(), 1)
+	assert.Equal(t, "default/web=lb-web", memberOf("node-1"))
+	assert.Equal(t, "default/api=lb-api", memberOf("node-2"))
+
+	m.remove(ctx, membershipService("api"))
+	assert.Empty(t, memberOf("node-2"))
+	assert.Empty(t, memberOf("node-3"))
+	assert.NotContains(t, m.byService, "default/api")
+
+	// A deleted node is ignored
+	assert.NoError(t, kclient.CoreV1().Nodes().Delete(ctx, "node-1", metav1.DeleteOptions{}))
+	m.remove(ctx, membershipService("web"))
+	assert.Empty(t, m.byService)
+}
+
+func TestNodeMembershipsRestart(t *testing.T) {
+	ctx := context.Background()
+	// The nodes were annotated before a restart
+	kclient := fake.NewSimpleClientset(
+		membershipNode("node-1", map[string]string{NodeAnnotationLoadBalancerMemberOf: "default/api=lb-api,default/web=lb-web"}),
+		membershipNode("node-2", map[string]string{NodeAnnotationLoadBalancerMemberOf: "default/api=lb-api"}),
+	)
+	m := newNodeMemberships(kclient)
+
+	node := func(name string) *corev1.Node {
+		node, err := kclient.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return node
+	}
+	memberOf := func(name string) string {
+		return node(name).Annotations[NodeAnnotationLoadBalancerMemberOf]
+	}
+
+	// The entries of the Services not reconciled yet are kept
+	nodes := []*corev1.Node{node("node-1"), node("node-2")}
+	m.set(ctx, membershipService("web"), "lb-web-2", nodes, nodes)
+	assert.Equal(t, "default/api=lb-api,default/web=lb-web-2", memberOf("node-1"))
+	assert.Equal(t, "default/api=lb-api,default/web=lb-web-2", memberOf("node-2"))
+
+	// The load balancer of api is deleted before being reconciled
+	m.remove(ctx, membershipService("api"))
+	assert.Equal(t, "default/web=lb-web-2", memberOf("node-1"))
+	assert.Equal(t, "default/web=lb-web-2", memberOf("node-2"))
+
+	// A stale node doesn't bring it back
+	m.set(ctx, membershipService("web"), "lb-web-2", nodes[:1], nodes)
+	assert.Equal(t, "default/web=lb-web-2", memberOf("node-1"))
+	assert.Empty(t, memberOf("node-2"))
+}
+
+func TestNodeMembershipsDisabled(t *testing.T) {
+	var m *nodeMemberships
+	m.set(context.Background(), membershipService("web"), "lb-web", nil, nil)
+	m.remove(context.Background(), membershipService("web"))
+}
+
+func TestLBMemberNodes(t *testing.T) {
+	nodes := []*corev1.Node{
+		membershipNode("node-1", nil),
+		membershipNode("node-2", map[string]string{NodeAnnotationLoadBalancerDrain: "true"}),
+		membershipNode("node-3", nil),
+	}
+	service := &corev1.Service{Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 80, NodePort: 30080}}}}
+
+	names := func(nodes []*corev1.Node) []string {
+		var names []string
+		for _, node := range nodes {
+			names = append(names, node.Name)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"node-1", "node-3"}, names(lbMemberNodes(service, nodes, &serviceConfig{})))
+	assert.Equal(t, []string{"node-3"}, names(lbMemberNodes(service, nodes, &serviceConfig{endpointNodes: sets.New("node-3")})))
+
+	// Without node ports, e.g. with allocateLoadBalancerNodePorts false, the nodes aren't members
+	service.Spec.Ports[0].NodePort = 0
+	assert.Empty(t, lbMemberNodes(service, nodes, &serviceConfig{}))
+}
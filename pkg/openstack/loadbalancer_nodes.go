@@ -0,0 +1,95 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
+)
+
+// Policies for the nodes without providerID, see LoadBalancerOpts.NodeWithoutProviderID
+const (
+	nodeWithoutProviderIDLookup = "lookup"
+	nodeWithoutProviderIDSkip   = "skip"
+	nodeWithoutProviderIDReject = "reject"
+)
+
+var supportedNodeWithoutProviderIDPolicies = []string{nodeWithoutProviderIDLookup, nodeWithoutProviderIDSkip, nodeWithoutProviderIDReject}
+
+// handleNodesWithoutProviderID applies the configured policy to the nodes lacking a providerID, which are typically
+// provisioned externally and never initialized by the cloud node controller. It returns the nodes to use as the
+// load balancer members.
+func (lbaas *LbaasV2) handleNodesWithoutProviderID(ctx context.Context, nodes []*corev1.Node) ([]*corev1.Node, error) {
+	result := make([]*corev1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Spec.ProviderID != "" {
+			result = append(result, node)
+			continue
+		}
+
+		switch lbaas.opts.NodeWithoutProviderID {
+		case nodeWithoutProviderIDSkip:
+			klog.Warningf("Node %s has no providerID, skipping it", node.Name)
+			continue
+		case nodeWithoutProviderIDReject:
+			return nil, fmt.Errorf("node %s has no providerID", node.Name)
+		}
+
+		providerID, err := lbaas.repairNodeProviderID(ctx, node)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up providerID of node %s: %v", node.Name, err)
+		}
+		// Nodes come from the informer cache and must not be modified.
+		node = node.DeepCopy()
+		node.Spec.ProviderID = providerID
+		result = append(result, node)
+	}
+
+	return result, nil
+}
+
+// repairNodeProviderID looks up the server of the node in Nova, by name or by metadata, and backfills the providerID
+// of the Node object.
+func (lbaas *LbaasV2) repairNodeProviderID(ctx context.Context, node *corev1.Node) (string, error) {
+	if lbaas.instances == nil {
+		return "", fmt.Errorf("compute service unavailable")
+	}
+
+	server, err := lbaas.instances.getInstance(ctx, node)
+	if err == cloudprovider.InstanceNotFound && lbaas.opts.NodeNameMetadataKey != "" {
+		server, err = lbaas.instances.getInstanceByMetadata(lbaas.opts.NodeNameMetadataKey, node.Name)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	providerID := lbaas.instances.makeInstanceID(&server.Server)
+	klog.InfoS("Backfilling providerID of node", "node", node.Name, "providerID", providerID)
+	patch := []byte(fmt.Sprintf(`{"spec":{"providerID":%q}}`, providerID))
+	if _, err := lbaas.kclient.CoreV1().Nodes().Patch(ctx, node.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		// The providerID is still usable for this reconciliation, the repair is retried on the next one.
+		klog.Warningf("Failed to backfill providerID of node %s: %v", node.Name, err)
+	}
+
+	return providerID, nil
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+// Actions on the load balancers stuck in a PENDING_* status, see LoadBalancerOpts.PendingAction
+const (
+	pendingActionEvent    = "event"
+	pendingActionFailover = "failover"
+	pendingActionRecreate = "recreate"
+)
+
+var supportedPendingActions = []string{pendingActionEvent, pendingActionFailover, pendingActionRecreate}
+
+// pendingLoadBalancers tracks the load balancers stuck in PENDING_CREATE or PENDING_UPDATE for longer than a timeout,
+// e.g. because an Octavia worker died while provisioning them. Octavia doesn't recover them by itself and they are
+// immutable, so the reconciliations of their Services fail until an action is taken: a failover of the amphorae is
+// triggered first, and with the recreate action the load balancer is deleted with its children if it is still stuck
+// a timeout after the failover, its creation being retried by the next reconciliation. Octavia may refuse both with a
+// 409 Conflict while the load balancer is PENDING_*, the recreate action is then still tried a timeout after the
+// refused failover, and each refusal is reported in an event, the load balancer having to be reset by the cloud admin.
+type pendingLoadBalancers struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	action  string
+	// time of the failover triggered, or refused, for the stuck load balancers by ID
+	failovers map[string]time.Time
+	now       func() time.Time
+}
+
+func newPendingLoadBalancers(timeout time.Duration, action string) *pendingLoadBalancers {
+	return &pendingLoadBalancers{
+		timeout:   timeout,
+		action:    action,
+		failovers: make(map[string]time.Time),
+		now:       time.Now,
+	}
+}
+
+// stuckFor returns for how long the load balancer is stuck in its PENDING_* status, 0 if it's not stuck yet.
+// PENDING_DELETE is left alone as the load balancer is going away anyway.
+func (p *pendingLoadBalancers) stuckFor(lb *loadbalancers.LoadBalancer) time.Duration {
+	if p == nil || lb.ProvisioningStatus == "PENDING_DELETE" || !strings.HasPrefix(lb.ProvisioningStatus, "PENDING_") {
+		return 0
+	}
+
+	// Octavia bumps updated_at at each status change, including the one of a failover.
+	since := lb.UpdatedAt
+	if since.IsZero() {
+		since = lb.CreatedAt
+	}
+	if since.IsZero() {
+		return 0
+	}
+	if d := p.now().Sub(since); d >= p.timeout {
+		return d
+	}
+	return 0
+}
+
+// failedOver returns whether a failover was attempted for the load balancer, and if it was more than a timeout ago.
+func (p *pendingLoadBalancers) failedOver(lbID string) (triggered, expired bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t, ok := p.failovers[lbID]
+	return ok, ok && p.now().Sub(t) >= p.timeout
+}
+
+func (p *pendingLoadBalancers) recordFailover(lbID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.failovers[lbID] = p.now()
+}
+
+// forget stops tracking the load balancer, e.g. once it is ACTIVE again.
+func (p *pendingLoadBalancers) forget(lbID string) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.failovers, lbID)
+}
+
+// handleInactiveLoadBalancer returns the error of a reconciliation finding the load balancer of the Service not
+// ACTIVE. If the load balancer is stuck in a PENDING_* status, an event is emitted and the configured action is taken
+// when the Service owns the load balancer.
+func (lbaas *LbaasV2) handleInactiveLoadBalancer(service *corev1.Service, svcConf *serviceConfig, lb *loadbalancers.LoadBalancer, isLBOwner bool) error {
+	err := fmt.Errorf("load balancer %s is not ACTIVE, current provisioning status: %s", lb.ID, lb.ProvisioningStatus)
+
+	p := lbaas.pendingLBs
+	stuckFor := p.stuckFor(lb)
+	if stuckFor == 0 {
+		return err
+	}
+	stuckFor = stuckFor.Round(time.Second)
+
+	msg := fmt.Sprintf("Load balancer %s is stuck in %s for %v", lb.ID, lb.ProvisioningStatus, stuckFor)
+	klog.Warningf("%s, Service %s/%s", msg, service.Namespace, service.Name)
+	if p.action == pendingActionEvent {
+		lbaas.eventRecorder.Event(service, corev1.EventTypeWarning, eventLBStuck, msg)
+		return err
+	}
+	// The load balancer may be shared or not created by OCCM, only its owner is allowed to act on it.
+	if !isLBOwner {
+		lbaas.eventRecorder.Eventf(service, corev1.EventTypeWarning, eventLBStuck, "%s, no action taken as it is not owned by the Service", msg)
+		return err
+	}
+
+	triggered, expired := p.failedOver(lb.ID)
+	if !triggered {
+		if ferr := openstackutil.FailoverLoadBalancer(lbaas.lb, lb.ID); cpoerrors.IsConflictError(errors.Unwrap(ferr)) {
+			// Not retried before the recreate action, Octavia keeps refusing it until the status changes.
+			p.recordFailover(lb.ID)
+			lbaas.eventRecorder.Eventf(service, corev1.EventTypeWarning, eventLBStuck, "%s, Octavia refused its failover in this status, it has to be reset by the cloud admin", msg)
+			return err
+		} else if ferr != nil {
+			lbaas.eventRecorder.Eventf(service, corev1.EventTypeWarning, eventLBStuck, "%s, failover failed: %v", msg, ferr)
+			return fmt.Errorf("%v, failover of the stuck load balancer failed: %v", err, ferr)
+		}
+		p.recordFailover(lb.ID)
+		lbaas.eventRecorder.Eventf(service, corev1.EventTypeWarning, eventLBStuck, "%s, triggered its failover", msg)
+		return err
+	}
+	if p.action != pendingActionRecreate || !expired {
+		lbaas.eventRecorder.Event(service, corev1.EventTypeWarning, eventLBStuck, msg)
+		return err
+	}
+
+	// Deleting the load balancer would break the other Services sharing it.
+	for _, tag := range lb.Tags {
+		if strings.HasPrefix(tag, servicePrefix) && tag != svcConf.lbName {
+			lbaas.eventRecorder.Eventf(service, corev1.EventTypeWarning, eventLBStuck, "%s, not recreated as it is shared with Service %s", msg, tag)
+			return err
+		}
+	}
+
+	klog.InfoS("Deleting stuck load balancer", "lbID", lb.ID, "status", lb.ProvisioningStatus, "service", klog.KObj(service))
+	if derr := openstackutil.DeleteLoadbalancer(lbaas.lb, lb.ID, true); cpoerrors.IsConflictError(errors.Unwrap(derr)) {
+		// Retried a timeout later, in case the status changed in the meantime.
+		p.recordFailover(lb.ID)
+		lbaas.eventRecorder.Eventf(service, corev1.EventTypeWarning, eventLBStuck, "%s, Octavia refused its deletion in this status, it has to be reset by the cloud admin", msg)
+		return err
+	} else if derr != nil {
+		lbaas.eventRecorder.Eventf(service, corev1.EventTypeWarning, eventLBStuck, "%s, deletion failed: %v", msg, derr)
+		return fmt.Errorf("%v, deletion of the stuck load balancer failed: %v", err, derr)
+	}
+	p.forget(lb.ID)
+	delete(service.Annotations, ServiceAnnotationLoadBalancerID)
+	lbaas.eventRecorder.Eventf(service, corev1.EventTypeWarning, eventLBStuck, "%s, deleted it after a failover didn't recover it", msg)
+
+	return fmt.Errorf("load balancer %s was stuck in %s and deleted, its creation will be retried", lb.ID, lb.ProvisioningStatus)
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	fakeclient "github.com/gophercloud/gophercloud/testhelper/client"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestPendingLoadBalancersStuckFor(t *testing.T) {
+	now := time.Now()
+	p := newPendingLoadBalancers(10*time.Minute, pendingActionEvent)
+	p.now = func() time.Time { return now }
+
+	tests := []struct {
+		name     string
+		lb       loadbalancers.LoadBalancer
+		expected time.Duration
+	}{
+		{
+			name:     "active",
+			lb:       loadbalancers.LoadBalancer{ProvisioningStatus: activeStatus, UpdatedAt: now.Add(-time.Hour)},
+			expected: 0,
+		},
+		{
+			name:     "pending update within the timeout",
+			lb:       loadbalancers.LoadBalancer{ProvisioningStatus: "PENDING_UPDATE", UpdatedAt: now.Add(-time.Minute)},
+			expected: 0,
+		},
+		{
+			name:     "pending update beyond the timeout",
+			lb:       loadbalancers.LoadBalancer{ProvisioningStatus: "PENDING_UPDATE", UpdatedAt: now.Add(-time.Hour)},
+			expected: time.Hour,
+		},
+		{
+			name:     "pending create never updated",
+			lb:       loadbalancers.LoadBalancer{ProvisioningStatus: "PENDING_CREATE", CreatedAt: now.Add(-time.Hour)},
+			expected: time.Hour,
+		},
+		{
+			name:     "pending delete",
+			lb:       loadbalancers.LoadBalancer{ProvisioningStatus: "PENDING_DELETE", UpdatedAt: now.Add(-time.Hour)},
+			expected: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, p.stuckFor(&test.lb))
+		})
+	}
+
+	var disabled *pendingLoadBalancers
+	assert.Zero(t, disabled.stuckFor(&tests[2].lb))
+}
+
+func TestHandleInactiveLoadBalancer(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	const lbID = "lb-id"
+	failovers, deletes := 0, 0
+	// Octavia refuses the actions on the load balancers in a PENDING_* status, unless e.g. reset in the meantime
+	refuse := false
+	th.Mux.HandleFunc("/lbaas/loadbalancers/"+lbID+"/failover", func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, "PUT")
+		failovers++
+		if refuse {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+	th.Mux.HandleFunc("/lbaas/loadbalancers/"+lbID, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "DELETE":
+			th.TestFormValues(t, r, map[string]string{"cascade": "true"})
+			deletes++
+			if refuse {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	now := time.Now()
+	lbName := "kube_service_kubernetes_ns_svc"
+	newLBaaS := func(action string) (*LbaasV2, *record.FakeRecorder) {
+		p := newPendingLoadBalancers(10*time.Minute, action)
+		p.now = func() time.Time { return now }
+		recorder := record.NewFakeRecorder(10)
+		return &LbaasV2{LoadBalancer{lb: fakeclient.ServiceClient(), eventRecorder: recorder, pendingLBs: p}}, recorder
+	}
+	newService := func() *corev1.Service {
+		return &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "ns",
+			Name:        "svc",
+			Annotations: map[string]string{ServiceAnnotationLoadBalancerID: lbID},
+		}}
+	}
+	svcConf := &serviceConfig{lbName: lbName}
+	lb := &loadbalancers.LoadBalancer{
+		ID:                 lbID,
+		ProvisioningStatus: "PENDING_UPDATE",
+		UpdatedAt:          now.Add(-time.Hour),
+		Tags:               []string{lbName},
+	}
+
+	// Not stuck yet
+	lbaas, recorder := newLBaaS(pendingActionRecreate)
+	recent := *lb
+	recent.UpdatedAt = now
+	assert.ErrorContains(t, lbaas.handleInactiveLoadBalancer(newService(), svcConf, &recent, true), "is not ACTIVE")
+	assert.Empty(t, recorder.Events)
+
+	// The event action only reports the load balancer
+	lbaas, recorder = newLBaaS(pendingActionEvent)
+	assert.Error(t, lbaas.handleInactiveLoadBalancer(newService(), svcConf, lb, true))
+	assert.Contains(t, <-recorder.Events, "stuck in PENDING_UPDATE for 1h0m0s")
+	assert.Zero(t, failovers)
+
+	// Only the owner of the load balancer acts on it
+	lbaas, recorder = newLBaaS(pendingActionRecreate)
+	assert.Error(t, lbaas.handleInactiveLoadBalancer(newService(), svcConf, lb, false))
+	assert.Contains(t, <-recorder.Events, "no action taken")
+	assert.Zero(t, failovers)
+
+	// A failover is triggered first
+	service := newService()
+	assert.Error(t, lbaas.handleInactiveLoadBalancer(service, svcConf, lb, true))
+	assert.Contains(t, <-recorder.Events, "triggered its failover")
+	assert.Equal(t, 1, failovers)
+
+	// Still stuck within the timeout after the failover
+	now = now.Add(time.Minute)
+	assert.Error(t, lbaas.handleInactiveLoadBalancer(service, svcConf, lb, true))
+	<-recorder.Events
+	assert.Equal(t, 1, failovers)
+	assert.Zero(t, deletes)
+
+	// A shared load balancer isn't recreated
+	now = now.Add(10 * time.Minute)
+	shared := *lb
+	shared.Tags = []string{lbName, "kube_service_kubernetes_ns_other"}
+	assert.Error(t, lbaas.handleInactiveLoadBalancer(service, svcConf, &shared, true))
+	assert.Contains(t, <-recorder.Events, "shared with Service kube_service_kubernetes_ns_other")
+	assert.Zero(t, deletes)
+
+	// Still stuck a timeout after the failover, the load balancer is recreated
+	err := lbaas.handleInactiveLoadBalancer(service, svcConf, lb, true)
+	assert.ErrorContains(t, err, "creation will be retried")
+	assert.Contains(t, <-recorder.Events, "deleted it after a failover")
+	assert.Equal(t, 1, deletes)
+	assert.NotContains(t, service.Annotations, ServiceAnnotationLoadBalancerID)
+	assert.NotContains(t, lbaas.pendingLBs.failovers, lbID)
+
+	// The failover action never deletes the load balancer
+	lbaas, recorder = newLBaaS(pendingActionFailover)
+	assert.Error(t, lbaas.handleInactiveLoadBalancer(newService(), svcConf, lb, true))
+	<-recorder.Events
+	now = now.Add(time.Hour)
+	assert.Error(t, lbaas.handleInactiveLoadBalancer(newService(), svcConf, lb, true))
+	assert.Equal(t, fmt.Sprintf("Warning %s Load balancer %s is stuck in PENDING_UPDATE for 2h11m0s", eventLBStuck, lbID), <-recorder.Events)
+	assert.Equal(t, 2, failovers)
+	assert.Equal(t, 1, deletes)
+
+	// A refused failover is reported and not retried, the recreate action doesn't depend on it
+	refuse = true
+	lbaas, recorder = newLBaaS(pendingActionRecreate)
+	service = newService()
+	err = lbaas.handleInactiveLoadBalancer(service, svcConf, lb, true)
+	assert.ErrorContains(t, err, "is not ACTIVE")
+	assert.NotContains(t, err.Error(), "failover")
+	assert.Contains(t, <-recorder.Events, "Octavia refused its failover")
+	assert.Equal(t, 3, failovers)
+	now = now.Add(time.Minute)
+	assert.Error(t, lbaas.handleInactiveLoadBalancer(service, svcConf, lb, true))
+	<-recorder.Events
+	assert.Equal(t, 3, failovers)
+	assert.Equal(t, 1, deletes)
+
+	// A refused deletion is reported and retried a timeout later
+	now = now.Add(10 * time.Minute)
+	assert.ErrorContains(t, lbaas.handleInactiveLoadBalancer(service, svcConf, lb, true), "is not ACTIVE")
+	assert.Contains(t, <-recorder.Events, "Octavia refused its deletion")
+	assert.Equal(t, 2, deletes)
+	assert.Contains(t, service.Annotations, ServiceAnnotationLoadBalancerID)
+	assert.Error(t, lbaas.handleInactiveLoadBalancer(service, svcConf, lb, true))
+	<-recorder.Events
+	assert.Equal(t, 2, deletes)
+
+	refuse = false
+	now = now.Add(10 * time.Minute)
+	assert.ErrorContains(t, lbaas.handleInactiveLoadBalancer(service, svcConf, lb, true), "creation will be retried")
+	assert.Contains(t, <-recorder.Events, "deleted it after a failover")
+	assert.Equal(t, 3, deletes)
+	assert.Equal(t, 3, failovers)
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+)
+
+const (
+	// ServiceAnnotationLoadBalancerMaxRetries overrides LoadBalancerOpts.MaxRetries for the Service, 0 never
+	// quarantines it.
+	ServiceAnnotationLoadBalancerMaxRetries = "loadbalancer.openstack.org/max-retries"
+
+	// serviceConditionQuarantined is the condition of the Services whose load balancer is quarantined.
+	serviceConditionQuarantined = "LoadBalancerQuarantined"
+)
+
+// quarantine tracks the consecutive failures of the load balancer reconciliations of the Services. Once a Service
+// failed max-retries times in a row, e.g. because of a quota or a bad annotation, it is quarantined: its
+// reconciliations fail without any OpenStack request until a backoff, doubled at each further failure up to a
+// maximum, is over. The quarantine is released by a successful reconciliation, or as soon as the spec or the
+// annotations of the Service change.
+type quarantine struct {
+	mu         sync.Mutex
+	backoff    time.Duration
+	maxBackoff time.Duration
+	services   map[types.UID]*quarantineEntry
+	now        func() time.Time
+}
+
+type quarantineEntry struct {
+	// hash of the spec and annotations of the Service at the last failure
+	hash     uint64
+	failures int
+	until    time.Time
+	lastErr  error
+}
+
+func newQuarantine(backoff, maxBackoff time.Duration) *quarantine {
+	return &quarantine{
+		backoff:    backoff,
+		maxBackoff: maxBackoff,
+		services:   make(map[types.UID]*quarantineEntry),
+		now:        time.Now,
+	}
+}
+
+// serviceHash hashes the spec and annotations of the Service, which the reconciliations depend on.
+func serviceHash(service *corev1.Service) uint64 {
+	data, _ := json.Marshal([]interface{}{service.Spec, service.Annotations})
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	return h.Sum64()
+}
+
+// check returns an error if the Service is quarantined. A Service whose spec or annotations changed since its last
+// failure is released.
+func (q *quarantine) check(service *corev1.Service) (released bool, err error) {
+	if q == nil {
+		return false, nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e, ok := q.services[service.UID]
+	if !ok {
+		return false, nil
+	}
+	if e.hash != serviceHash(service) {
+		delete(q.services, service.UID)
+		return !e.until.IsZero(), nil
+	}
+	if now := q.now(); now.Before(e.until) {
+		return false, fmt.Errorf("load balancer of Service %s/%s is quarantined for %v after %d failures, last error: %v",
+			service.Namespace, service.Name, e.until.Sub(now).Round(time.Second), e.failures, e.lastErr)
+	}
+	return false, nil
+}
+
+// observe records the result of a reconciliation of the Service, returning whether the Service entered or left the
+// quarantine.
+func (q *quarantine) observe(service *corev1.Service, maxRetries int, err error) (quarantined, released bool) {
+	if q == nil {
+		return false, false
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e, ok := q.services[service.UID]
+	if err == nil || maxRetries <= 0 {
+		delete(q.services, service.UID)
+		return false, ok && !e.until.IsZero()
+	}
+
+	if !ok {
+		e = &quarantineEntry{}
+		q.services[service.UID] = e
+	}
+	e.hash = serviceHash(service)
+	e.failures++
+	e.lastErr = err
+	if e.failures < maxRetries {
+		return false, false
+	}
+
+	backoff := q.backoff
+	for i := maxRetries; i < e.failures && backoff < q.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > q.maxBackoff {
+		backoff = q.maxBackoff
+	}
+	quarantined = e.until.IsZero()
+	e.until = q.now().Add(backoff)
+	return quarantined, false
+}
+
+// forget stops tracking the Service, e.g. once its load balancer is deleted.
+func (q *quarantine) forget(service *corev1.Service) bool {
+	if q == nil {
+		return false
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e, ok := q.services[service.UID]
+	delete(q.services, service.UID)
+	return ok && !e.until.IsZero()
+}
+
+// reconcileWithQuarantine runs the reconciliation of the Service unless it is quarantined, and records its result.
+func (lbaas *LbaasV2) reconcileWithQuarantine(ctx context.Context, service *corev1.Service, reconcile func() error) error {
+	released, err := lbaas.quarantine.check(service)
+	if released {
+		lbaas.setQuarantined(ctx, service, false, "Service changed")
+	}
+	if err != nil {
+		return err
+	}
+
+	err = reconcile()
+
+	maxRetries := getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerMaxRetries, lbaas.opts.MaxRetries)
+	quarantined, released := lbaas.quarantine.observe(service, maxRetries, err)
+	switch {
+	case quarantined:
+		lbaas.setQuarantined(ctx, service, true, fmt.Sprintf("Quarantined after %d consecutive failures, last error: %v", maxRetries, err))
+	case released:
+		lbaas.setQuarantined(ctx, service, false, "Load balancer reconciled")
+	}
+	return err
+}
+
+// setQuarantined reports the quarantine of the Service with an event, its condition and the quarantined Services
+// metric.
+func (lbaas *LbaasV2) setQuarantined(ctx context.Context, service *corev1.Service, quarantined bool, msg string) {
+	metrics.SetServiceQuarantined(service.Namespace, service.Name, quarantined)
+
+	status, reason, eventType := metav1.ConditionFalse, "Released", corev1.EventTypeNormal
+	if quarantined {
+		status, reason, eventType = metav1.ConditionTrue, "ConsecutiveFailures", corev1.EventTypeWarning
+		klog.Warningf("Load balancer of Service %s/%s quarantined: %s", service.Namespace, service.Name, msg)
+	} else {
+		klog.Infof("Load balancer of Service %s/%s released from quarantine: %s", service.Namespace, service.Name, msg)
+	}
+	if lbaas.eventRecorder != nil {
+		lbaas.eventRecorder.Event(service, eventType, eventLBQuarantined, msg)
+	}
+	if lbaas.kclient == nil {
+		return
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []metav1.Condition{{
+				Type:               serviceConditionQuarantined,
+				Status:             status,
+				ObservedGeneration: service.Generation,
+				LastTransitionTime: metav1.NewTime(lbaas.quarantine.now()),
+				Reason:             reason,
+				Message:            msg,
+			}},
+		},
+	})
+	if err != nil {
+		klog.Errorf("Failed to build the %s condition of Service %s/%s: %v", serviceConditionQuarantined, service.Namespace, service.Name, err)
+		return
+	}
+	_, err = lbaas.kclient.CoreV1().Services(service.Namespace).Patch(ctx, service.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{}, "status")
+	if err != nil {
+		klog.Errorf("Failed to set the %s condition of Service %s/%s: %v", serviceConditionQuarantined, service.Namespace, service.Name, err)
+	}
+}
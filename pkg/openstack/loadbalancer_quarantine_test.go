@@ -0,0 +1,138 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestQuarantine(t *testing.T) {
+	now := time.Now()
+	q := newQuarantine(time.Minute, 3*time.Minute)
+	q.now = func() time.Time { return now }
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "svc", UID: "uid"}}
+	failure := fmt.Errorf("quota exceeded")
+
+	quarantined, _ := q.observe(service, 2, failure)
+	assert.False(t, quarantined)
+	_, err := q.check(service)
+	assert.NoError(t, err)
+
+	quarantined, _ = q.observe(service, 2, failure)
+	assert.True(t, quarantined)
+	_, err = q.check(service)
+	assert.ErrorContains(t, err, "quota exceeded")
+
+	// The backoff doubles at each further failure, up to the maximum
+	now = now.Add(time.Minute)
+	_, err = q.check(service)
+	assert.NoError(t, err)
+	quarantined, _ = q.observe(service, 2, failure)
+	assert.False(t, quarantined)
+	assert.Equal(t, now.Add(2*time.Minute), q.services["uid"].until)
+	now = now.Add(2 * time.Minute)
+	q.observe(service, 2, failure)
+	assert.Equal(t, now.Add(3*time.Minute), q.services["uid"].until)
+
+	// A success releases the Service
+	now = now.Add(3 * time.Minute)
+	_, released := q.observe(service, 2, nil)
+	assert.True(t, released)
+	assert.Empty(t, q.services)
+
+	// So does a change of the Service
+	q.observe(service, 1, failure)
+	changed := service.DeepCopy()
+	changed.Annotations = map[string]string{ServiceAnnotationLoadBalancerFlavorID: "small"}
+	released, err = q.check(changed)
+	assert.True(t, released)
+	assert.NoError(t, err)
+	assert.Empty(t, q.services)
+
+	// Never quarantined with no max retries
+	quarantined, _ = q.observe(service, 0, failure)
+	assert.False(t, quarantined)
+	assert.Empty(t, q.services)
+
+	q.observe(service, 1, failure)
+	assert.True(t, q.forget(service))
+	assert.Empty(t, q.services)
+
+	var disabled *quarantine
+	_, err = disabled.check(service)
+	assert.NoError(t, err)
+	disabled.observe(service, 1, failure)
+	disabled.forget(service)
+}
+
+func TestReconcileWithQuarantine(t *testing.T) {
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "ns",
+		Name:        "svc",
+		UID:         "uid",
+		Annotations: map[string]string{ServiceAnnotationLoadBalancerMaxRetries: "2"},
+	}}
+	kclient := fake.NewSimpleClientset(service)
+	recorder := record.NewFakeRecorder(10)
+	lbaas := &LbaasV2{LoadBalancer{
+		opts:          LoadBalancerOpts{MaxRetries: 5},
+		kclient:       kclient,
+		eventRecorder: recorder,
+		quarantine:    newQuarantine(time.Minute, time.Hour),
+	}}
+
+	calls := 0
+	failing := func() error {
+		calls++
+		return fmt.Errorf("bad annotation")
+	}
+
+	// The annotation takes precedence over max-retries
+	for i := 0; i < 3; i++ {
+		assert.Error(t, lbaas.reconcileWithQuarantine(context.TODO(), service, failing))
+	}
+	assert.Equal(t, 2, calls)
+	assert.Contains(t, <-recorder.Events, eventLBQuarantined)
+
+	updated, err := kclient.CoreV1().Services("ns").Get(context.TODO(), "svc", metav1.GetOptions{})
+	assert.NoError(t, err)
+	if assert.Len(t, updated.Status.Conditions, 1) {
+		assert.Equal(t, serviceConditionQuarantined, updated.Status.Conditions[0].Type)
+		assert.Equal(t, metav1.ConditionTrue, updated.Status.Conditions[0].Status)
+	}
+
+	// Fixing the Service releases it
+	fixed := service.DeepCopy()
+	fixed.Annotations["fixed"] = "true"
+	assert.NoError(t, lbaas.reconcileWithQuarantine(context.TODO(), fixed, func() error { return nil }))
+	assert.Contains(t, <-recorder.Events, "Service changed")
+
+	updated, err = kclient.CoreV1().Services("ns").Get(context.TODO(), "svc", metav1.GetOptions{})
+	assert.NoError(t, err)
+	if assert.Len(t, updated.Status.Conditions, 1) {
+		assert.Equal(t, metav1.ConditionFalse, updated.Status.Conditions[0].Status)
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package openstack
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
@@ -56,10 +57,13 @@ func applyNodeSecurityGroupIDForLB(network *gophercloud.ServiceClient, svcConf *
 			return fmt.Errorf("error getting server ID from the node: %w", err)
 		}
 
-		addr, _ := nodeAddressForLB(node, svcConf.preferredIPFamily)
-		if addr == "" {
-			// If node has no viable address let's ignore it.
-			continue
+		addr, subnetID, err := memberAddressForLB(network, node, svcConf)
+		if err != nil {
+			if errors.Is(err, cpoerrors.ErrNoAddressFound) {
+				// If node has no viable address let's ignore it.
+				continue
+			}
+			return fmt.Errorf("error getting address of node %s: %w", node.Name, err)
 		}
 
 		listOpts := neutronports.ListOpts{DeviceID: serverID}
@@ -80,7 +84,7 @@ func applyNodeSecurityGroupIDForLB(network *gophercloud.ServiceClient, svcConf *
 			}
 
 			// Only add SGs to the port actually attached to the LB
-			if !isPortMember(port, addr, svcConf.lbMemberSubnetID) {
+			if !isPortMember(port, addr, subnetID) {
 				continue
 			}
 
@@ -236,10 +240,36 @@ func (lbaas *LbaasV2) ensureAndUpdateOctaviaSecurityGroup(clusterName string, ap
 		lbSecGroupID = lbSecGroup.ID
 	}
 
+	existingRules, err := openstackutil.GetSecurityGroupRules(lbaas.network, rules.ListOpts{SecGroupID: lbSecGroupID})
+	if err != nil {
+		return fmt.Errorf(
+			"failed to find security group rules in %s: %v", lbSecGroupID, err)
+	}
+
+	wantedRules, err := lbaas.getWantedSecurityGroupRules(apiService, svcConf, lbSecGroupID)
+	if err != nil {
+		return err
+	}
+
+	toCreate, toDelete := getRulesToCreateAndDelete(wantedRules, existingRules)
+	if err := lbaas.applySecurityGroupRules(lbSecGroupName, toCreate, toDelete); err != nil {
+		return err
+	}
+
+	if err := applyNodeSecurityGroupIDForLB(lbaas.network, svcConf, nodes, lbSecGroupID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// getWantedSecurityGroupRules returns the list of the security group rules wanted in the SG of the Service.
+func (lbaas *LbaasV2) getWantedSecurityGroupRules(apiService *corev1.Service, svcConf *serviceConfig, lbSecGroupID string) ([]rules.CreateOpts, error) {
+	ports := apiService.Spec.Ports
+
 	mc := metrics.NewMetricContext("subnet", "get")
 	subnet, err := subnets.Get(lbaas.network, svcConf.lbMemberSubnetID).Extract()
 	if mc.ObserveRequest(err) != nil {
-		return fmt.Errorf(
+		return nil, fmt.Errorf(
 			"failed to find subnet %s from openstack: %v", svcConf.lbMemberSubnetID, err)
 	}
 
@@ -255,13 +285,6 @@ func (lbaas *LbaasV2) ensureAndUpdateOctaviaSecurityGroup(clusterName string, ap
 		cidrs = svcConf.allowedCIDR
 	}
 
-	existingRules, err := openstackutil.GetSecurityGroupRules(lbaas.network, rules.ListOpts{SecGroupID: lbSecGroupID})
-	if err != nil {
-		return fmt.Errorf(
-			"failed to find security group rules in %s: %v", lbSecGroupID, err)
-	}
-
-	// List of the security group rules wanted in the SG.
 	// Number of Ports plus the potential HealthCheckNodePort.
 	wantedRules := make([]rules.CreateOpts, 0, len(ports)+1)
 
@@ -287,11 +310,16 @@ func (lbaas *LbaasV2) ensureAndUpdateOctaviaSecurityGroup(clusterName string, ap
 		}
 		for _, cidr := range cidrs {
 			protocol := strings.ToLower(string(port.Protocol)) // K8s uses TCP, Neutron uses tcp, etc.
+			// With OVN the CIDRs are the source ranges of the Service, which may be of another IP family than the subnet.
+			cidrEtherType := rules.EtherType4
+			if netutils.IsIPv6CIDRString(cidr) {
+				cidrEtherType = rules.EtherType6
+			}
 			wantedRules = append(wantedRules,
 				rules.CreateOpts{
 					Direction:      rules.DirIngress,
 					Protocol:       rules.RuleProtocol(protocol),
-					EtherType:      etherType,
+					EtherType:      cidrEtherType,
 					RemoteIPPrefix: cidr,
 					SecGroupID:     lbSecGroupID,
 					PortRangeMin:   int(port.NodePort),
@@ -301,8 +329,11 @@ func (lbaas *LbaasV2) ensureAndUpdateOctaviaSecurityGroup(clusterName string, ap
 		}
 	}
 
-	toCreate, toDelete := getRulesToCreateAndDelete(wantedRules, existingRules)
+	return wantedRules, nil
+}
 
+// applySecurityGroupRules creates and deletes the given rules of the security group.
+func (lbaas *LbaasV2) applySecurityGroupRules(lbSecGroupName string, toCreate []rules.CreateOpts, toDelete []rules.SecGroupRule) error {
 	// create new rules
 	for _, opts := range toCreate {
 		err := lbaas.ensureSecurityRule(opts)
@@ -326,10 +357,77 @@ func (lbaas *LbaasV2) ensureAndUpdateOctaviaSecurityGroup(clusterName string, ap
 		}
 	}
 
-	if err := applyNodeSecurityGroupIDForLB(lbaas.network, svcConf, nodes, lbSecGroupID); err != nil {
+	return nil
+}
+
+// reconcileSecurityGroupDrift compares the rules of the security group managed for the Service with the wanted
+// ones and repairs any out-of-band modification, emitting an event on the Service when drift is found.
+func (lbaas *LbaasV2) reconcileSecurityGroupDrift(service *corev1.Service, nodes []*corev1.Node) error {
+	service, err := lbaas.annotationDefaults.apply(service)
+	if err != nil {
 		return err
 	}
-	return nil
+	serviceName := fmt.Sprintf("%s/%s", service.Namespace, service.Name)
+
+	lbSecGroupName := getSecurityGroupName(service)
+	lbSecGroupID, err := secgroups.IDFromName(lbaas.network, lbSecGroupName)
+	if err != nil {
+		if cpoerrors.IsNotFound(err) {
+			// The security group gets created by EnsureLoadBalancer(), nothing to compare against.
+			klog.V(4).Infof("Security group %s of Service %s not found, skipping drift detection", lbSecGroupName, serviceName)
+			return nil
+		}
+		return fmt.Errorf("error occurred finding security group: %s: %v", lbSecGroupName, err)
+	}
+
+	svcConf := new(serviceConfig)
+	if err := lbaas.checkServiceUpdate(service, nodes, svcConf); err != nil {
+		return err
+	}
+	if lbaas.opts.LBProvider == "ovn" {
+		sourceRanges, err := GetLoadBalancerSourceRanges(service, svcConf.preferredIPFamily)
+		if err != nil {
+			return fmt.Errorf("failed to get source ranges for loadbalancer service %s: %v", serviceName, err)
+		}
+		svcConf.allowedCIDR = sourceRanges.StringSlice()
+	}
+
+	existingRules, err := openstackutil.GetSecurityGroupRules(lbaas.network, rules.ListOpts{SecGroupID: lbSecGroupID})
+	if err != nil {
+		return fmt.Errorf("failed to find security group rules in %s: %v", lbSecGroupID, err)
+	}
+
+	wantedRules, err := lbaas.getWantedSecurityGroupRules(service, svcConf, lbSecGroupID)
+	if err != nil {
+		return err
+	}
+
+	toCreate, toDelete := getRulesToCreateAndDelete(wantedRules, existingRules)
+	if !lbaas.reportSecurityGroupDrift(service, lbSecGroupID, toCreate, toDelete) {
+		return nil
+	}
+
+	return lbaas.applySecurityGroupRules(lbSecGroupName, toCreate, toDelete)
+}
+
+// reportSecurityGroupDrift reports whether the rules of the security group drifted, emitting an event on the Service
+// when they did.
+func (lbaas *LbaasV2) reportSecurityGroupDrift(service *corev1.Service, lbSecGroupID string, toCreate []rules.CreateOpts, toDelete []rules.SecGroupRule) bool {
+	if len(toCreate) == 0 && len(toDelete) == 0 {
+		return false
+	}
+
+	serviceName := fmt.Sprintf("%s/%s", service.Namespace, service.Name)
+	msg := "Security group %s of Service %s was modified out-of-band (%d rules missing, %d unexpected), repairing it"
+	lbaas.eventRecorder.Eventf(service, corev1.EventTypeWarning, eventLBSecurityGroupDrift, msg, lbSecGroupID, serviceName, len(toCreate), len(toDelete))
+	klog.Warningf(msg, lbSecGroupID, serviceName, len(toCreate), len(toDelete))
+	return true
+}
+
+// needsSecurityGroupResync reports whether the security group of the Service is checked for drift: Services handled
+// by another controller or not yet provisioned are skipped.
+func needsSecurityGroupResync(service *corev1.Service) bool {
+	return service.Spec.Type == corev1.ServiceTypeLoadBalancer && service.Spec.LoadBalancerClass == nil && len(service.Status.LoadBalancer.Ingress) > 0
 }
 
 // ensureSecurityGroupDeleted deleting security group for specific loadbalancer service.
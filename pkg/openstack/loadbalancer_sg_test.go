@@ -0,0 +1,147 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/security/rules"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestNeedsSecurityGroupResync(t *testing.T) {
+	class := "other"
+	provisioned := corev1.ServiceStatus{
+		LoadBalancer: corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{{IP: "10.0.0.10"}}},
+	}
+
+	tests := []struct {
+		name    string
+		service corev1.Service
+		want    bool
+	}{
+		{
+			name: "provisioned load balancer",
+			service: corev1.Service{
+				Spec:   corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+				Status: provisioned,
+			},
+			want: true,
+		},
+		{
+			name: "load balancer not provisioned yet",
+			service: corev1.Service{
+				Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+			},
+		},
+		{
+			name: "load balancer of another controller",
+			service: corev1.Service{
+				Spec:   corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, LoadBalancerClass: &class},
+				Status: provisioned,
+			},
+		},
+		{
+			name: "not a load balancer",
+			service: corev1.Service{
+				Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, needsSecurityGroupResync(&test.service))
+		})
+	}
+}
+
+func TestReportSecurityGroupDrift(t *testing.T) {
+	wanted := []rules.CreateOpts{{
+		Direction:      rules.DirIngress,
+		EtherType:      rules.EtherType4,
+		SecGroupID:     "sg",
+		PortRangeMin:   30000,
+		PortRangeMax:   30000,
+		Protocol:       rules.ProtocolTCP,
+		RemoteIPPrefix: "10.0.0.0/24",
+	}}
+	matching := rules.SecGroupRule{
+		ID:             "rule-1",
+		Direction:      "ingress",
+		EtherType:      "IPv4",
+		SecGroupID:     "sg",
+		PortRangeMin:   30000,
+		PortRangeMax:   30000,
+		Protocol:       "tcp",
+		RemoteIPPrefix: "10.0.0.0/24",
+	}
+	opened := rules.SecGroupRule{
+		ID:             "rule-2",
+		Direction:      "ingress",
+		EtherType:      "IPv4",
+		SecGroupID:     "sg",
+		PortRangeMin:   22,
+		PortRangeMax:   22,
+		Protocol:       "tcp",
+		RemoteIPPrefix: "0.0.0.0/0",
+	}
+
+	tests := []struct {
+		name     string
+		existing []rules.SecGroupRule
+		drifted  bool
+		event    string
+	}{
+		{
+			name:     "no drift",
+			existing: []rules.SecGroupRule{matching},
+		},
+		{
+			name:     "rule deleted out-of-band",
+			existing: []rules.SecGroupRule{},
+			drifted:  true,
+			event:    "Warning LoadBalancerSecurityGroupDrift Security group sg of Service default/svc was modified out-of-band (1 rules missing, 0 unexpected), repairing it",
+		},
+		{
+			name:     "rule added out-of-band",
+			existing: []rules.SecGroupRule{matching, opened},
+			drifted:  true,
+			event:    "Warning LoadBalancerSecurityGroupDrift Security group sg of Service default/svc was modified out-of-band (0 rules missing, 1 unexpected), repairing it",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			lbaas := LbaasV2{LoadBalancer: LoadBalancer{eventRecorder: recorder}}
+			service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default"}}
+
+			toCreate, toDelete := getRulesToCreateAndDelete(wanted, test.existing)
+			assert.Equal(t, test.drifted, lbaas.reportSecurityGroupDrift(service, "sg", toCreate, toDelete))
+
+			if test.event == "" {
+				assert.Empty(t, recorder.Events)
+				return
+			}
+			assert.Equal(t, test.event, <-recorder.Events)
+		})
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
 )
 
@@ -2304,6 +2305,37 @@ func Test_buildMonitorCreateOpts(t *testing.T) {
 				ExpectedCodes: "200",
 			},
 		},
+		{
+			name: "using paused health monitor",
+			testArg: testArg{
+				lbaas: &LbaasV2{
+					LoadBalancer{
+						opts: LoadBalancerOpts{
+							LBProvider: "ovn",
+						},
+					},
+				},
+				svcConf: &serviceConfig{
+					healthMonitorDelay:          3,
+					healthMonitorTimeout:        5,
+					healthMonitorMaxRetries:     1,
+					healthMonitorMaxRetriesDown: 2,
+					healthMonitorPaused:         true,
+				},
+				port: corev1.ServicePort{
+					Protocol: corev1.ProtocolTCP,
+				},
+			},
+			want: v2monitors.CreateOpts{
+				Name:           "using paused health monitor",
+				Type:           "TCP",
+				Delay:          3,
+				Timeout:        5,
+				MaxRetries:     1,
+				MaxRetriesDown: 2,
+				AdminStateUp:   new(bool),
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -2553,3 +2585,185 @@ func TestFilterNodes(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleNodesWithoutProviderID(t *testing.T) {
+	withProviderID := &corev1.Node{
+		ObjectMeta: v1.ObjectMeta{Name: "node-1"},
+		Spec:       corev1.NodeSpec{ProviderID: "openstack:///a9b5e8ee-b7ec-4e45-8d5c-6e0e1cb2d4a2"},
+	}
+	withoutProviderID := &corev1.Node{
+		ObjectMeta: v1.ObjectMeta{Name: "node-2"},
+	}
+
+	tests := []struct {
+		name          string
+		policy        string
+		expectedNodes []*corev1.Node
+		expectedErr   bool
+	}{
+		{
+			name:          "skip policy drops the node",
+			policy:        nodeWithoutProviderIDSkip,
+			expectedNodes: []*corev1.Node{withProviderID},
+		},
+		{
+			name:        "reject policy fails",
+			policy:      nodeWithoutProviderIDReject,
+			expectedErr: true,
+		},
+		{
+			name:        "lookup policy fails without compute client",
+			policy:      nodeWithoutProviderIDLookup,
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lbaas := LbaasV2{
+				LoadBalancer: LoadBalancer{
+					opts: LoadBalancerOpts{NodeWithoutProviderID: test.policy},
+				},
+			}
+
+			nodes, err := lbaas.handleNodesWithoutProviderID(context.TODO(), []*corev1.Node{withProviderID, withoutProviderID})
+			if test.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, test.expectedNodes, nodes)
+			}
+		})
+	}
+}
+
+func TestEnsureLoadBalancerFailover(t *testing.T) {
+	tests := []struct {
+		name                 string
+		annotations          map[string]string
+		isLBOwner            bool
+		expectedLastFailover string
+		expectedEvents       int
+	}{
+		{
+			name:      "no trigger",
+			isLBOwner: true,
+		},
+		{
+			name: "trigger already processed",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerTriggerFailover: "2024-01-01T00:00:00Z",
+				ServiceAnnotationLoadBalancerLastFailover:    "2024-01-01T00:00:00Z",
+			},
+			isLBOwner:            true,
+			expectedLastFailover: "2024-01-01T00:00:00Z",
+		},
+		{
+			name: "shared load balancer is not failed over",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerTriggerFailover: "2024-01-02T00:00:00Z",
+				ServiceAnnotationLoadBalancerLastFailover:    "2024-01-01T00:00:00Z",
+			},
+			isLBOwner:            false,
+			expectedLastFailover: "2024-01-02T00:00:00Z",
+			expectedEvents:       1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			lbaas := LbaasV2{
+				LoadBalancer: LoadBalancer{
+					eventRecorder: recorder,
+				},
+			}
+			service := &corev1.Service{
+				ObjectMeta: v1.ObjectMeta{Name: "svc", Namespace: "default", Annotations: test.annotations},
+			}
+
+			err := lbaas.ensureLoadBalancerFailover(service, "lb-id", test.isLBOwner)
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedLastFailover, service.Annotations[ServiceAnnotationLoadBalancerLastFailover])
+			assert.Len(t, recorder.Events, test.expectedEvents)
+		})
+	}
+}
+
+func TestCheckFloatingIPExhausted(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		expectedEvents int
+	}{
+		{
+			name:           "pool exhausted",
+			err:            gophercloud.ErrDefault409{ErrUnexpectedResponseCode: gophercloud.ErrUnexpectedResponseCode{Body: []byte(`{"NeutronError": {"type": "IpAddressGenerationFailure", "message": "No more IP addresses available on network 123."}}`)}},
+			expectedEvents: 1,
+		},
+		{
+			name: "other error",
+			err:  fmt.Errorf("error creating LB floatingip: quota exceeded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			lbaas := LbaasV2{
+				LoadBalancer: LoadBalancer{
+					eventRecorder: recorder,
+				},
+			}
+			service := &corev1.Service{ObjectMeta: v1.ObjectMeta{Name: "svc", Namespace: "default"}}
+
+			lbaas.checkFloatingIPExhausted(service, "network-id", test.err)
+
+			assert.Len(t, recorder.Events, test.expectedEvents)
+		})
+	}
+}
+
+func TestVIPSubnetFallbackAllowed(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		lbClass     *LBClass
+		expected    bool
+	}{
+		{
+			name:     "default subnet",
+			expected: true,
+		},
+		{
+			name:     "class without subnet",
+			lbClass:  &LBClass{FloatingNetworkID: "floating-network-id"},
+			expected: true,
+		},
+		{
+			name:        "subnet annotation",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerSubnetID: "subnet-id"},
+		},
+		{
+			name:        "network annotation",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerNetworkID: "network-id"},
+		},
+		{
+			name:    "class subnet",
+			lbClass: &LBClass{SubnetID: "subnet-id"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := &corev1.Service{ObjectMeta: v1.ObjectMeta{Annotations: test.annotations}}
+			assert.Equal(t, test.expected, vipSubnetFallbackAllowed(service, test.lbClass))
+		})
+	}
+}
+
+func TestVIPSubnetCandidates(t *testing.T) {
+	assert.Equal(t, []string{"subnet-a"}, vipSubnetCandidates("subnet-a", nil))
+	assert.Equal(t, []string{"subnet-a", "subnet-b", "subnet-c"}, vipSubnetCandidates("subnet-a", []string{"subnet-b", "subnet-a", "", "subnet-c", "subnet-b"}))
+}
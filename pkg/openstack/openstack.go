@@ -17,6 +17,7 @@ limitations under the License.
 package openstack
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -32,7 +33,11 @@ import (
 	neutronports "github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
 	"github.com/spf13/pflag"
 	gcfg "gopkg.in/gcfg.v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/klog/v2"
@@ -42,6 +47,7 @@ import (
 	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes/scheme"
 	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/cloud-provider-openstack/pkg/client"
 	"k8s.io/cloud-provider-openstack/pkg/metrics"
@@ -63,6 +69,17 @@ const (
 // userAgentData is used to add extra information to the gophercloud user-agent
 var userAgentData []string
 
+// strictConfig makes the problems found by ValidateConfig fatal at startup
+var strictConfig bool
+
+// Components of OCCM identified in the User-Agent of their requests
+const (
+	componentLoadBalancer = "loadbalancer"
+	componentInstances    = "instances"
+	componentZones        = "zones"
+	componentRoutes       = "routes"
+)
+
 // supportedLBProvider map is used to define LoadBalancer providers that we support
 var supportedLBProvider = []string{"amphora", "octavia", "ovn"}
 
@@ -72,6 +89,7 @@ var supportedContainerStore = []string{"barbican", "external"}
 // AddExtraFlags is called by the main package to add component specific command line flags
 func AddExtraFlags(fs *pflag.FlagSet) {
 	fs.StringArrayVar(&userAgentData, "user-agent", nil, "Extra data to add to gophercloud user-agent. Use multiple times to add more than one component.")
+	fs.BoolVar(&strictConfig, "strict-config", false, "Fail to start if the cloud config has unknown options or misses options required by the enabled controllers, instead of only logging them.")
 }
 
 type PortWithTrunkDetails struct {
@@ -86,12 +104,24 @@ type PortWithPortSecurity struct {
 
 // LoadBalancer is used for creating and maintaining load balancers
 type LoadBalancer struct {
-	secret        *gophercloud.ServiceClient
-	network       *gophercloud.ServiceClient
-	lb            *gophercloud.ServiceClient
-	opts          LoadBalancerOpts
-	kclient       kubernetes.Interface
-	eventRecorder record.EventRecorder
+	secret          *gophercloud.ServiceClient
+	network         *gophercloud.ServiceClient
+	lb              *gophercloud.ServiceClient
+	dns             *gophercloud.ServiceClient
+	opts            LoadBalancerOpts
+	kclient         kubernetes.Interface
+	eventRecorder   record.EventRecorder
+	instances       *InstancesV2
+	lbIndex         *lbIndex
+	memberDrains    *memberDrains
+	endpointMembers *endpointMembers
+	nodeMemberships *nodeMemberships
+	// Default annotations of the Services, see LoadBalancerOpts.AnnotationDefaults
+	annotationDefaults *annotationDefaults
+	// Services failing persistently, see LoadBalancerOpts.MaxRetries
+	quarantine *quarantine
+	// Load balancers stuck in a PENDING_* status, see LoadBalancerOpts.PendingTimeout
+	pendingLBs *pendingLoadBalancers
 }
 
 // LoadBalancerOpts have the options to talk to Neutron LBaaSV2 or Octavia
@@ -124,6 +154,27 @@ type LoadBalancerOpts struct {
 	MaxSharedLB                    int                 `gcfg:"max-shared-lb"`                      //  Number of Services in maximum can share a single load balancer. Default 2
 	ContainerStore                 string              `gcfg:"container-store"`                    // Used to specify the store of the tls-container-ref
 	ProviderRequiresSerialAPICalls bool                `gcfg:"provider-requires-serial-api-calls"` // default false, the provider supports the "bulk update" API call
+	SecurityGroupResyncPeriod      util.MyDuration     `gcfg:"security-group-resync-period"`       // If set with manage-security-groups, the managed SG rules are periodically checked for drift. Default 0 (disabled)
+	NodeWithoutProviderID          string              `gcfg:"node-without-provider-id"`           // Policy for the nodes lacking a providerID: lookup, skip or reject. Default skip
+	NodeNameMetadataKey            string              `gcfg:"node-name-metadata-key"`             // Server metadata key holding the node name, used to look up nodes without providerID
+	FloatingIPAvailabilityPeriod   util.MyDuration     `gcfg:"floating-ip-availability-period"`    // If set, the available IPs of the floating networks are periodically exported as a metric. Default 0 (disabled)
+	ExternalIPSubnetIDs            []string            `gcfg:"external-ip-subnet-id"`              // Subnets allowed for Service externalIPs, which are added to the load balancer as additional VIPs. Default empty (disabled)
+	FallbackSubnetIDs              []string            `gcfg:"fallback-subnet-id"`                 // Ordered VIP subnets used when the VIP subnet has no IP address left. Default empty (disabled)
+	MemberPortSelector             string              `gcfg:"member-port-selector"`               // If specified, the member address of a node is the fixed IP of its port matching the network-name, subnet-id and security-group criteria
+	DNSZone                        string              `gcfg:"dns-zone"`                           // Designate zone of the Service hostnames. Default empty, a zone must be set per Service to create DNS records
+	DNSRecordTTL                   int                 `gcfg:"dns-record-ttl"`                     // TTL of the DNS records created for the Service hostnames. Default 0, the TTL of the zone
+	WarmStandbyPeriod              util.MyDuration     `gcfg:"warm-standby-period"`                // If set, the replicas not leading keep the Service and Node caches and an index of the load balancers warm. Default 0 (disabled)
+	MemberDrainPeriod              util.MyDuration     `gcfg:"member-drain-period"`                // If set, the members of the nodes removed from a pool are kept with a weight of 0 for this period before being deleted. Default 0 (disabled)
+	ServiceLabelTags               string              `gcfg:"service-label-tags"`                 // Comma-separated keys of the Service labels propagated to the tags and descriptions of the listeners and pools. Default empty
+	StartupIndexPeriod             util.MyDuration     `gcfg:"startup-index-period"`               // If set, the leader lists the load balancers once when it starts and gets them from this index for this period. Default 0 (disabled)
+	EndpointMemberSync             bool                `gcfg:"endpoint-member-sync"`               // If true, the members of the Services with the Local external traffic policy are the nodes of their ready endpoints, updated on EndpointSlice changes. Default false
+	NodeMembershipAnnotation       bool                `gcfg:"node-membership-annotation"`         // If true, the nodes are annotated with the load balancers they are members of. Default false
+	AnnotationDefaults             bool                `gcfg:"annotation-defaults"`                // If true, the annotations missing from the Services are taken from the matching LoadBalancerDefaults. Default false
+	MaxRetries                     int                 `gcfg:"max-retries"`                        // Consecutive failures after which the reconciliations of a Service are quarantined. Default 0 (disabled)
+	QuarantineBackoff              util.MyDuration     `gcfg:"quarantine-backoff"`                 // Time a Service is quarantined for, doubled at each further failure. Default 5m
+	QuarantineMaxBackoff           util.MyDuration     `gcfg:"quarantine-max-backoff"`             // Maximum time a Service is quarantined for. Default 1h
+	PendingTimeout                 util.MyDuration     `gcfg:"pending-timeout"`                    // Time after which a load balancer in PENDING_CREATE or PENDING_UPDATE is considered stuck. Default 30m, 0 disables it
+	PendingAction                  string              `gcfg:"pending-action"`                     // Action on the stuck load balancers: event, failover or recreate. Default event
 	// revive:disable:var-naming
 	TlsContainerRef string `gcfg:"default-tls-container-ref"` //  reference to a tls container
 	// revive:enable:var-naming
@@ -173,8 +224,40 @@ type OpenStack struct {
 	nodeInformer          coreinformers.NodeInformer
 	nodeInformerHasSynced func() bool
 
+	// Services whose security groups are checked for drift, see LoadBalancerOpts.SecurityGroupResyncPeriod
+	serviceLister            corelisters.ServiceLister
+	serviceInformerHasSynced func() bool
+
 	eventBroadcaster record.EventBroadcaster
 	eventRecorder    record.EventRecorder
+
+	// Warm standby and startup index, see StartWarmStandby and buildStartupIndex
+	lbIndex *lbIndex
+	leading chan struct{}
+
+	// Members being drained, see LoadBalancerOpts.MemberDrainPeriod, and the Services to update on drain changes
+	memberDrains *memberDrains
+	nodeDrains   *nodeDrains
+
+	// Endpoint nodes of the Services, see LoadBalancerOpts.EndpointMemberSync
+	endpointMembers *endpointMembers
+	stop            <-chan struct{}
+
+	// Load balancers of the nodes, see LoadBalancerOpts.NodeMembershipAnnotation
+	nodeMemberships *nodeMemberships
+
+	// Default annotations of the Services, see LoadBalancerOpts.AnnotationDefaults
+	dclient            dynamic.Interface
+	annotationDefaults *annotationDefaults
+
+	// Services failing persistently, see LoadBalancerOpts.MaxRetries
+	quarantine *quarantine
+
+	// Load balancers stuck in a PENDING_* status, see LoadBalancerOpts.PendingTimeout
+	pendingLBs *pendingLoadBalancers
+
+	// clusterName identifies the cluster in the User-Agent of the requests, see SetClusterName
+	clusterName string
 }
 
 // Config is used to read and store information from the cloud configuration file
@@ -191,6 +274,17 @@ func init() {
 	metrics.RegisterMetrics("occm")
 
 	cloudprovider.RegisterCloudProvider(ProviderName, func(config io.Reader) (cloudprovider.Interface, error) {
+		if config != nil {
+			data, err := io.ReadAll(config)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkConfig(data); err != nil {
+				return nil, err
+			}
+			config = bytes.NewReader(data)
+		}
+
 		cfg, err := ReadConfig(config)
 		if err != nil {
 			klog.Warningf("failed to read config: %v", err)
@@ -211,6 +305,101 @@ func (os *OpenStack) Initialize(clientBuilder cloudprovider.ControllerClientBuil
 	os.eventBroadcaster = record.NewBroadcaster()
 	os.eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: os.kclient.CoreV1().Events("")})
 	os.eventRecorder = os.eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "cloud-provider-openstack"})
+	os.stop = stop
+
+	if os.lbOpts.Enabled && os.lbOpts.NodeMembershipAnnotation {
+		os.nodeMemberships = newNodeMemberships(os.kclient)
+	}
+
+	if os.lbOpts.Enabled && os.lbOpts.AnnotationDefaults {
+		os.dclient = dynamic.NewForConfigOrDie(clientBuilder.ConfigOrDie("cloud-controller-manager"))
+	}
+
+	if os.leading != nil {
+		// The leader keeps its load balancer index, which expires after warm-standby-period
+		close(os.leading)
+	}
+	os.buildStartupIndex()
+
+	if os.lbOpts.Enabled && os.lbOpts.ManageSecurityGroups && os.lbOpts.SecurityGroupResyncPeriod.Duration > 0 {
+		go wait.Until(os.resyncSecurityGroups, os.lbOpts.SecurityGroupResyncPeriod.Duration, stop)
+	}
+
+	if os.lbOpts.Enabled && os.lbOpts.FloatingIPAvailabilityPeriod.Duration > 0 {
+		go wait.Until(os.pollFloatingIPAvailability, os.lbOpts.FloatingIPAvailabilityPeriod.Duration, stop)
+	}
+}
+
+// pollFloatingIPAvailability exports the number of available IPs of the floating networks used by load balancers, so
+// that the exhaustion of a floating IP pool can be noticed before the allocation for a Service fails.
+func (os *OpenStack) pollFloatingIPAvailability() {
+	network, err := client.NewNetworkV2(os.provider, os.epOpts)
+	if err != nil {
+		klog.Errorf("Failed to create an OpenStack Network client: %v", err)
+		return
+	}
+	os.setComponent(componentLoadBalancer, network)
+
+	networkIDs := sets.New[string]()
+	if os.lbOpts.FloatingNetworkID != "" {
+		networkIDs.Insert(os.lbOpts.FloatingNetworkID)
+	}
+	for _, class := range os.lbOpts.LBClasses {
+		if class.FloatingNetworkID != "" {
+			networkIDs.Insert(class.FloatingNetworkID)
+		}
+	}
+
+	for _, networkID := range sets.List(networkIDs) {
+		available, err := openstackutil.GetAvailableIPCount(network, networkID)
+		if err != nil {
+			klog.Errorf("Failed to get IP availability of floating network %s: %v", networkID, err)
+			continue
+		}
+		metrics.SetFloatingIPsAvailable(networkID, available)
+	}
+}
+
+// resyncSecurityGroups checks the security groups of all the LoadBalancer Services for out-of-band modifications
+// and repairs them. Without it such changes would persist until the Service is updated.
+func (os *OpenStack) resyncSecurityGroups() {
+	if os.nodeInformerHasSynced == nil || !os.nodeInformerHasSynced() ||
+		os.serviceInformerHasSynced == nil || !os.serviceInformerHasSynced() {
+		klog.V(4).Info("Node and Service informers not synced yet, skipping security group resync")
+		return
+	}
+
+	lb, ok := os.LoadBalancer()
+	if !ok {
+		return
+	}
+	lbaas := lb.(*LbaasV2)
+
+	nodes, err := os.nodeInformer.Lister().List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list nodes for security group resync: %v", err)
+		return
+	}
+	nodes, err = lbaas.handleNodesWithoutProviderID(context.TODO(), nodes)
+	if err != nil {
+		klog.Errorf("Failed to handle nodes without providerID for security group resync: %v", err)
+		return
+	}
+
+	services, err := os.serviceLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list services for security group resync: %v", err)
+		return
+	}
+
+	for _, service := range services {
+		if !needsSecurityGroupResync(service) {
+			continue
+		}
+		if err := lbaas.reconcileSecurityGroupDrift(service, nodes); err != nil {
+			klog.Errorf("Failed to resync security group of Service %s/%s: %v", service.Namespace, service.Name, err)
+		}
+	}
 }
 
 // ReadConfig reads values from the cloud.conf
@@ -239,6 +428,11 @@ func ReadConfig(config io.Reader) (Config, error) {
 	cfg.LoadBalancer.ContainerStore = "barbican"
 	cfg.LoadBalancer.MaxSharedLB = 2
 	cfg.LoadBalancer.ProviderRequiresSerialAPICalls = false
+	cfg.LoadBalancer.NodeWithoutProviderID = nodeWithoutProviderIDSkip
+	cfg.LoadBalancer.QuarantineBackoff = util.MyDuration{Duration: 5 * time.Minute}
+	cfg.LoadBalancer.QuarantineMaxBackoff = util.MyDuration{Duration: time.Hour}
+	cfg.LoadBalancer.PendingTimeout = util.MyDuration{Duration: 30 * time.Minute}
+	cfg.LoadBalancer.PendingAction = pendingActionEvent
 
 	err := gcfg.FatalOnly(gcfg.ReadInto(&cfg, config))
 	if err != nil {
@@ -272,6 +466,22 @@ func ReadConfig(config io.Reader) (Config, error) {
 		klog.Warningf("Unsupported Container Store: %s", cfg.LoadBalancer.ContainerStore)
 	}
 
+	if !util.Contains(supportedNodeWithoutProviderIDPolicies, cfg.LoadBalancer.NodeWithoutProviderID) {
+		return Config{}, fmt.Errorf("unsupported node-without-provider-id policy %q, supported values are %v", cfg.LoadBalancer.NodeWithoutProviderID, supportedNodeWithoutProviderIDPolicies)
+	}
+
+	if cfg.LoadBalancer.QuarantineBackoff.Duration <= 0 || cfg.LoadBalancer.QuarantineMaxBackoff.Duration < cfg.LoadBalancer.QuarantineBackoff.Duration {
+		return Config{}, fmt.Errorf("quarantine-backoff must be positive and not greater than quarantine-max-backoff")
+	}
+
+	if cfg.LoadBalancer.PendingTimeout.Duration < 0 {
+		return Config{}, fmt.Errorf("pending-timeout must not be negative")
+	}
+
+	if !util.Contains(supportedPendingActions, cfg.LoadBalancer.PendingAction) {
+		return Config{}, fmt.Errorf("unsupported pending-action %q, supported values are %v", cfg.LoadBalancer.PendingAction, supportedPendingActions)
+	}
+
 	return cfg, err
 }
 
@@ -303,6 +513,7 @@ func NewOpenStack(cfg Config) (*OpenStack, error) {
 		cfg.Metadata.RequestTimeout.Duration = time.Duration(defaultTimeOut)
 	}
 	provider.HTTPClient.Timeout = cfg.Metadata.RequestTimeout.Duration
+	client.SetRequestIDs(provider)
 
 	useV1Instances := false
 	v1instances := os.Getenv("OS_V1_INSTANCES")
@@ -327,6 +538,18 @@ func NewOpenStack(cfg Config) (*OpenStack, error) {
 	// and copy the resulting map to corresponding loadbalancer section
 	os.lbOpts.LBClasses = cfg.LoadBalancerClass
 
+	if os.lbOpts.MemberDrainPeriod.Duration > 0 {
+		os.memberDrains = newMemberDrains(os.lbOpts.MemberDrainPeriod.Duration)
+	}
+
+	if os.lbOpts.Enabled {
+		os.quarantine = newQuarantine(os.lbOpts.QuarantineBackoff.Duration, os.lbOpts.QuarantineMaxBackoff.Duration)
+	}
+
+	if os.lbOpts.Enabled && os.lbOpts.PendingTimeout.Duration > 0 {
+		os.pendingLBs = newPendingLoadBalancers(os.lbOpts.PendingTimeout.Duration, os.lbOpts.PendingAction)
+	}
+
 	err = checkOpenStackOpts(&os)
 	if err != nil {
 		return nil, err
@@ -335,6 +558,17 @@ func NewOpenStack(cfg Config) (*OpenStack, error) {
 	return &os, nil
 }
 
+// SetClusterName sets the name of the cluster, i.e. the --cluster-name of OCCM, added to the User-Agent of the
+// requests. It must be called before the controllers are started.
+func (os *OpenStack) SetClusterName(name string) {
+	os.clusterName = name
+}
+
+// setComponent sets the User-Agent of the component of OCCM sending the requests of the service clients.
+func (os *OpenStack) setComponent(component string, scs ...*gophercloud.ServiceClient) {
+	client.SetComponentUserAgent(os.provider, component, os.clusterName, scs...)
+}
+
 // Clusters is a no-op
 func (os *OpenStack) Clusters() (cloudprovider.Clusters, bool) {
 	return nil, false
@@ -376,6 +610,14 @@ func (os *OpenStack) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
 		klog.Warningf("Failed to create an OpenStack Secret client: %v", err)
 	}
 
+	// dns client is optional
+	dns, err := client.NewDNSV2(os.provider, os.epOpts)
+	if err != nil {
+		klog.Warningf("Failed to create an OpenStack DNS client: %v", err)
+	}
+
+	os.setComponent(componentLoadBalancer, network, lb, secret, dns)
+
 	// LBaaS v1 is deprecated in the OpenStack Liberty release.
 	// Currently kubernetes OpenStack cloud provider just support LBaaS v2.
 	lbVersion := os.lbOpts.LBVersion
@@ -384,9 +626,15 @@ func (os *OpenStack) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
 		return nil, false
 	}
 
+	// compute client is only used to look up the nodes without providerID
+	instances, ok := os.instancesv2()
+	if !ok {
+		klog.Warning("Failed to create an OpenStack Compute client, nodes without providerID cannot be looked up")
+	}
+
 	klog.V(1).Info("Claiming to support LoadBalancer")
 
-	return &LbaasV2{LoadBalancer{secret, network, lb, os.lbOpts, os.kclient, os.eventRecorder}}, true
+	return &LbaasV2{LoadBalancer{secret, network, lb, dns, os.lbOpts, os.kclient, os.eventRecorder, instances, os.lbIndex, os.memberDrains, os.endpointMembers, os.nodeMemberships, os.annotationDefaults, os.quarantine, os.pendingLBs}}, true
 }
 
 // Zones indicates that we support zones
@@ -423,6 +671,7 @@ func (os *OpenStack) GetZoneByProviderID(ctx context.Context, providerID string)
 	if err != nil {
 		return cloudprovider.Zone{}, err
 	}
+	os.setComponent(componentZones, compute)
 
 	var serverWithAttributesExt ServerAttributesExt
 	mc := metrics.NewMetricContext("server", "get")
@@ -447,6 +696,7 @@ func (os *OpenStack) GetZoneByNodeName(ctx context.Context, nodeName types.NodeN
 	if err != nil {
 		return cloudprovider.Zone{}, err
 	}
+	os.setComponent(componentZones, compute)
 
 	srv, err := getServerByName(compute, nodeName)
 	if err != nil {
@@ -473,6 +723,7 @@ func (os *OpenStack) Routes() (cloudprovider.Routes, bool) {
 		klog.Errorf("Failed to create an OpenStack Network client: %v", err)
 		return nil, false
 	}
+	os.setComponent(componentRoutes, network)
 
 	netExts, err := openstackutil.GetNetworkExtensions(network)
 	if err != nil {
@@ -506,4 +757,27 @@ func (os *OpenStack) SetInformers(informerFactory informers.SharedInformerFactor
 	klog.V(1).Infof("Setting up informers for Cloud")
 	os.nodeInformer = informerFactory.Core().V1().Nodes()
 	os.nodeInformerHasSynced = os.nodeInformer.Informer().HasSynced
+
+	if os.lbOpts.Enabled && os.lbOpts.ManageSecurityGroups && os.lbOpts.SecurityGroupResyncPeriod.Duration > 0 {
+		serviceInformer := informerFactory.Core().V1().Services()
+		os.serviceLister = serviceInformer.Lister()
+		os.serviceInformerHasSynced = serviceInformer.Informer().HasSynced
+	}
+
+	if os.lbOpts.Enabled && os.lbOpts.EndpointMemberSync {
+		os.endpointMembers = newEndpointMembers(informerFactory)
+		go os.runEndpointMembers(os.stop)
+	}
+
+	if os.lbOpts.Enabled {
+		os.nodeDrains = newNodeDrains(informerFactory)
+		if os.memberDrains != nil {
+			os.memberDrains.enqueueAfter = os.nodeDrains.enqueueAfter
+		}
+		go os.runNodeDrains(os.stop)
+	}
+
+	if os.lbOpts.Enabled && os.lbOpts.AnnotationDefaults {
+		os.annotationDefaults = newAnnotationDefaults(os.dclient, os.kclient.Discovery(), informerFactory, os.stop)
+	}
 }
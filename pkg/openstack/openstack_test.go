@@ -19,6 +19,8 @@ package openstack
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -33,6 +35,7 @@ import (
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	"k8s.io/cloud-provider-openstack/pkg/client"
 	"k8s.io/cloud-provider-openstack/pkg/util/metadata"
 )
 
@@ -146,6 +149,9 @@ func TestReadConfig(t *testing.T) {
 	if cfg.LoadBalancer.MonitorMaxRetriesDown != 3 {
 		t.Errorf("incorrect lb.monitor-max-retries-down: %d", cfg.LoadBalancer.MonitorMaxRetriesDown)
 	}
+	if cfg.LoadBalancer.NodeWithoutProviderID != "skip" {
+		t.Errorf("incorrect lb.node-without-provider-id: %s", cfg.LoadBalancer.NodeWithoutProviderID)
+	}
 	if cfg.Metadata.SearchOrder != "configDrive, metadataService" {
 		t.Errorf("incorrect md.search-order: %v", cfg.Metadata.SearchOrder)
 	}
@@ -244,6 +250,91 @@ clouds:
 	}
 }
 
+func TestReadCloudsInvalid(t *testing.T) {
+	cloudFile := filepath.Join(t.TempDir(), "clouds.yaml")
+	err := os.WriteFile(cloudFile, []byte(`
+clouds:
+  default:
+    auth:
+      auth_url: http://auth.url
+      username: admin
+      password: mypass
+      project_name: demo
+  noauth:
+    region_name: RegionOne
+  noauthurl:
+    auth:
+      username: admin
+      password: mypass
+  nocredentials:
+    auth:
+      auth_url: http://auth.url
+      username: admin
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ReadConfig points OS_CLIENT_CONFIG_FILE to the clouds file
+	t.Setenv("OS_CLIENT_CONFIG_FILE", "")
+
+	testCases := []struct {
+		name        string
+		cloud       string
+		global      string
+		expectedErr string
+	}{
+		{
+			name:  "valid",
+			cloud: "default",
+		},
+		{
+			name:        "missing cloud",
+			cloud:       "missing",
+			expectedErr: `failed to read the cloud "missing" from clouds.yaml: cloud missing does not exist in clouds.yaml`,
+		},
+		{
+			name:        "no auth section",
+			cloud:       "noauth",
+			expectedErr: `the cloud "noauth" has no auth section in clouds.yaml`,
+		},
+		{
+			name:        "no auth url",
+			cloud:       "noauthurl",
+			expectedErr: `the cloud "noauthurl" has no auth_url in clouds.yaml nor auth-url in the cloud config`,
+		},
+		{
+			name:   "auth url from the cloud config",
+			cloud:  "noauthurl",
+			global: "auth-url = http://auth.url\n",
+		},
+		{
+			name:        "no credentials",
+			cloud:       "nocredentials",
+			expectedErr: `the cloud "nocredentials" has no credentials in clouds.yaml nor in the cloud config, one of password, application_credential_secret or cert is required`,
+		},
+		{
+			name:   "credentials from the cloud config",
+			cloud:  "nocredentials",
+			global: "password = mypass\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ReadConfig(strings.NewReader("[Global]\nuse-clouds = true\nclouds-file = " + cloudFile + "\ncloud = " + tc.cloud + "\n" + tc.global))
+			if tc.expectedErr == "" {
+				if err != nil {
+					t.Errorf("Should succeed when a valid config is provided: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.expectedErr {
+				t.Errorf("expected error %q, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
 func TestToAuthOptions(t *testing.T) {
 	cfg := Config{}
 	cfg.Global.Username = "user"
@@ -1194,6 +1285,42 @@ func TestUserAgentFlag(t *testing.T) {
 	}
 }
 
+func TestComponentRequests(t *testing.T) {
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	provider := &gophercloud.ProviderClient{HTTPClient: http.Client{Transport: http.DefaultTransport}}
+	provider.UserAgent.Prepend("openstack-cloud-controller-manager/v1.30.0")
+	client.SetRequestIDs(provider)
+
+	os := &OpenStack{provider: provider}
+	os.SetClusterName(testClusterName)
+
+	sc := &gophercloud.ServiceClient{ProviderClient: provider, Endpoint: server.URL + "/"}
+	os.setComponent(componentRoutes, sc, nil)
+
+	for i := 0; i < 2; i++ {
+		requestID := headers.Get(client.RequestIDHeader)
+
+		_, err := sc.Get(sc.ServiceURL("ports"), nil, &gophercloud.RequestOpts{OkCodes: []int{http.StatusNoContent}})
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+
+		expected := "component/routes cluster/" + testClusterName + " openstack-cloud-controller-manager/v1.30.0 " + gophercloud.DefaultUserAgent
+		if ua := headers.Get("User-Agent"); ua != expected {
+			t.Errorf("User-Agent %q did not match expected value %q", ua, expected)
+		}
+		if id := headers.Get(client.RequestIDHeader); !strings.HasPrefix(id, "req-") || id == requestID {
+			t.Errorf("request ID %q is not a new request ID", id)
+		}
+	}
+}
+
 func testConfigFromEnv(t *testing.T, cfg *Config) {
 	if cfg.Global.AuthURL == "" {
 		t.Skip("No config found in environment")
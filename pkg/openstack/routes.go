@@ -134,7 +134,7 @@ func getRouterNetworkIDs(network *gophercloud.ServiceClient, routerID string) ([
 func getNodeNameByAddr(addr string, nodes []*v1.Node) (types.NodeName, bool) {
 	for _, node := range nodes {
 		for _, v := range node.Status.Addresses {
-			if v.Address == addr {
+			if sameIP(v.Address, addr) {
 				return types.NodeName(node.Name), true
 			}
 		}
@@ -144,6 +144,16 @@ func getNodeNameByAddr(addr string, nodes []*v1.Node) (types.NodeName, bool) {
 	return types.NodeName(addr), false
 }
 
+// sameIP reports whether a and b are the same IP address. IPv6 addresses may
+// be written in several notations, so a plain string comparison is not enough.
+func sameIP(a, b string) bool {
+	if a == b {
+		return true
+	}
+	ip := net.ParseIP(a)
+	return ip != nil && ip.Equal(net.ParseIP(b))
+}
+
 func getAddrByNodeName(name types.NodeName, needIPv6 bool, nodes []*v1.Node) string {
 	for _, node := range nodes {
 		if node.Name == string(name) {
@@ -302,7 +312,7 @@ func (r *Routes) CreateRoute(ctx context.Context, clusterName string, nameHint s
 		routes := router.Routes
 
 		for _, item := range routes {
-			if item.DestinationCIDR == route.DestinationCIDR && item.NextHop == addr {
+			if item.DestinationCIDR == route.DestinationCIDR && sameIP(item.NextHop, addr) {
 				klog.V(4).Infof("Skipping existing route: %v", route)
 				return nil
 			}
@@ -411,7 +421,7 @@ func (r *Routes) DeleteRoute(ctx context.Context, clusterName string, route *clo
 		routes := router.Routes
 		index := -1
 		for i, item := range routes {
-			if item.DestinationCIDR == route.DestinationCIDR && (item.NextHop == addr || route.Blackhole && item.NextHop == string(route.TargetNode)) {
+			if item.DestinationCIDR == route.DestinationCIDR && (sameIP(item.NextHop, addr) || route.Blackhole && item.NextHop == string(route.TargetNode)) {
 				index = i
 				break
 			}
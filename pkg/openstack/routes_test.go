@@ -162,6 +162,71 @@ func TestGetAddrByNodeName(t *testing.T) {
 	}
 }
 
+func TestGetNodeNameByAddr(t *testing.T) {
+	nodes := []*v1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "test-node-1",
+			},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{
+					{
+						Type:    v1.NodeInternalIP,
+						Address: "2001:4800:790e::82a8",
+					},
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "test-node-2",
+			},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{
+					{
+						Type:    v1.NodeInternalIP,
+						Address: "1.2.3.4",
+					},
+				},
+			},
+		},
+	}
+	tests := []struct {
+		addr  string
+		name  types.NodeName
+		found bool
+	}{
+		{
+			addr:  "1.2.3.4",
+			name:  "test-node-2",
+			found: true,
+		},
+		{
+			addr:  "2001:4800:790e::82a8",
+			name:  "test-node-1",
+			found: true,
+		},
+		{
+			// Same address as test-node-1, in the non-compressed notation
+			addr:  "2001:4800:790e:0:0:0:0:82a8",
+			name:  "test-node-1",
+			found: true,
+		},
+		{
+			addr:  "2001:4800:790e::82a9",
+			name:  "2001:4800:790e::82a9",
+			found: false,
+		},
+	}
+
+	for _, test := range tests {
+		name, found := getNodeNameByAddr(test.addr, nodes)
+		if name != test.name || found != test.found {
+			t.Fatalf("Expected (%q, %t) for %q, got (%q, %t)", test.name, test.found, test.addr, name, found)
+		}
+	}
+}
+
 func getServers(os *OpenStack) []servers.Server {
 	c, err := client.NewComputeV2(os.provider, os.epOpts)
 	if err != nil {
@@ -63,6 +63,9 @@ var MetadataService IMetadata
 // Metadata is fixed for the current host, so cache the value process-wide
 var metadataCache *Metadata
 
+// diskByPathDir is the directory of the udev by-path links of the disks.
+const diskByPathDir = "/dev/disk/by-path"
+
 // revive:disable:exported
 // Deprecated: use Opts instead
 type MetadataOpts = Opts
@@ -76,20 +79,22 @@ type Opts struct {
 
 // DeviceMetadata is a single/simplified data structure for all kinds of device metadata types.
 type DeviceMetadata struct {
-	Type    string `json:"type"`
-	Bus     string `json:"bus,omitempty"`
-	Serial  string `json:"serial,omitempty"`
-	Address string `json:"address,omitempty"`
+	Type    string   `json:"type"`
+	Bus     string   `json:"bus,omitempty"`
+	Serial  string   `json:"serial,omitempty"`
+	Address string   `json:"address,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
 	// .. and other fields.
 }
 
 // Metadata has the information fetched from OpenStack metadata service or
 // config drives. Assumes the "latest" meta_data.json format.
 type Metadata struct {
-	UUID             string           `json:"uuid"`
-	Name             string           `json:"name"`
-	AvailabilityZone string           `json:"availability_zone"`
-	Devices          []DeviceMetadata `json:"devices,omitempty"`
+	UUID             string            `json:"uuid"`
+	Name             string            `json:"name"`
+	AvailabilityZone string            `json:"availability_zone"`
+	Meta             map[string]string `json:"meta,omitempty"`
+	Devices          []DeviceMetadata  `json:"devices,omitempty"`
 	// .. and other fields we don't care about.  Expand as necessary.
 }
 
@@ -97,10 +102,11 @@ type metadataService struct {
 	searchOrder string
 }
 
-// IMetadata implements GetInstanceID & GetAvailabilityZone
+// IMetadata implements GetInstanceID, GetAvailabilityZone & GetInstanceMetadata
 type IMetadata interface {
 	GetInstanceID() (string, error)
 	GetAvailabilityZone() (string, error)
+	GetInstanceMetadata() (map[string]string, error)
 }
 
 // GetMetadataProvider retrieves instance of IMetadata
@@ -218,7 +224,25 @@ func GetDevicePath(volumeID string) (string, error) {
 	// Nova Hyper-V hosts cannot override disk SCSI IDs. In order to locate
 	// volumes, we're querying the metadata service. Note that the Hyper-V
 	// driver will include device metadata for untagged volumes as well.
-	//
+	return getDevicePathFromMetadata(fmt.Sprintf("volumeID %q", volumeID), func(device DeviceMetadata) bool {
+		return device.Serial == volumeID
+	})
+}
+
+// GetDevicePathByTag retrieves from the metadata service the device path of
+// the volume attached with the given device tag.
+func GetDevicePathByTag(tag string) (string, error) {
+	return getDevicePathFromMetadata(fmt.Sprintf("device tag %q", tag), func(device DeviceMetadata) bool {
+		for _, t := range device.Tags {
+			if t == tag {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func getDevicePathFromMetadata(desc string, match func(DeviceMetadata) bool) (string, error) {
 	// We're avoiding using cached metadata (or the configdrive),
 	// relying on the metadata service.
 	instanceMetadata, err := getFromMetadataService(defaultMetadataVersion)
@@ -228,35 +252,51 @@ func GetDevicePath(volumeID string) (string, error) {
 	}
 
 	for _, device := range instanceMetadata.Devices {
-		if device.Type == "disk" && device.Serial == volumeID {
+		if device.Type == "disk" && match(device) {
 			klog.V(4).Infof(
-				"Found disk metadata for volumeID %q. Bus: %q, Address: %q",
-				volumeID, device.Bus, device.Address)
+				"Found disk metadata for %s. Bus: %q, Address: %q",
+				desc, device.Bus, device.Address)
 
-			diskPattern := fmt.Sprintf("/dev/disk/by-path/*-%s-%s", device.Bus, device.Address)
-			diskPaths, err := filepath.Glob(diskPattern)
+			diskPaths, err := findDiskPaths(diskByPathDir, device.Bus, device.Address)
 			if err != nil {
-				klog.Errorf("Could not retrieve disk path for volumeID: %q. Error filepath.Glob(%q): %v",
-					volumeID, diskPattern, err)
-				return "", fmt.Errorf("could not retrieve disk path for volumeID: %q. Error filepath.Glob(%q): %v",
-					volumeID, diskPattern, err)
+				klog.Errorf("Could not retrieve disk path for %s: %v", desc, err)
+				return "", fmt.Errorf("could not retrieve disk path for %s: %v", desc, err)
 			}
 
 			if len(diskPaths) == 1 {
 				if diskPaths[0] == "" {
-					klog.Errorf("Disk path for volumeID %q is empty", volumeID)
-					return "", fmt.Errorf("disk path for volumeID %q is empty", volumeID)
+					klog.Errorf("Disk path for %s is empty", desc)
+					return "", fmt.Errorf("disk path for %s is empty", desc)
 				}
 				return diskPaths[0], nil
 			}
 
-			klog.Warningf("Expecting to find one disk path for volumeID %q, found %d: %v",
-				volumeID, len(diskPaths), diskPaths)
+			klog.Warningf("Expecting to find one disk path for %s, found %d: %v",
+				desc, len(diskPaths), diskPaths)
 		}
 	}
 
-	klog.Errorf("Could not retrieve device metadata for volumeID: %q", volumeID)
-	return "", fmt.Errorf("could not retrieve device metadata for volumeID: %q", volumeID)
+	klog.Errorf("Could not retrieve device metadata for %s", desc)
+	return "", fmt.Errorf("could not retrieve device metadata for %s", desc)
+}
+
+// findDiskPaths returns the by-path links in dir of the disk at the given bus
+// address. A virtio-blk disk is linked as pci-<address> (or
+// virtio-pci-<address> with older udev rules), while a disk behind a
+// controller, e.g. SCSI, is linked as pci-<controller>-scsi-<address>.
+func findDiskPaths(dir, bus, address string) ([]string, error) {
+	var diskPaths []string
+	for _, pattern := range []string{
+		filepath.Join(dir, fmt.Sprintf("%s-%s", bus, address)),
+		filepath.Join(dir, fmt.Sprintf("*-%s-%s", bus, address)),
+	} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("filepath.Glob(%q): %v", pattern, err)
+		}
+		diskPaths = append(diskPaths, matches...)
+	}
+	return diskPaths, nil
 }
 
 // Get retrieves metadata from either config drive or metadata service.
@@ -309,6 +349,15 @@ func (m *metadataService) GetAvailabilityZone() (string, error) {
 	return md.AvailabilityZone, nil
 }
 
+// GetInstanceMetadata returns the user-defined metadata of the node's server
+func (m *metadataService) GetInstanceMetadata() (map[string]string, error) {
+	md, err := Get(m.searchOrder)
+	if err != nil {
+		return nil, err
+	}
+	return md.Meta, nil
+}
+
 func CheckMetadataSearchOrder(order string) error {
 	if order == "" {
 		return errors.New("invalid value in section [Metadata] with key `search-order`. Value cannot be empty")
@@ -51,3 +51,26 @@ func (_m *MetadataMock) GetInstanceID() (string, error) {
 
 	return r0, r1
 }
+
+// GetInstanceMetadata provides a mock function with given fields:
+func (_m *MetadataMock) GetInstanceMetadata() (map[string]string, error) {
+	ret := _m.Called()
+
+	var r0 map[string]string
+	if rf, ok := ret.Get(0).(func() map[string]string); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[string]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
@@ -21,6 +21,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -89,6 +91,10 @@ func TestParseMetadata(t *testing.T) {
 		t.Errorf("incorrect az: %s", md.AvailabilityZone)
 	}
 
+	if md.Meta["role"] != "webservers" {
+		t.Errorf("incorrect meta: %v", md.Meta)
+	}
+
 	if len(md.Devices) != 1 {
 		t.Errorf("expecting to find 1 device, found %d", len(md.Devices))
 	}
@@ -108,6 +114,10 @@ func TestParseMetadata(t *testing.T) {
 	if md.Devices[0].Serial != "6df1888b-f373-41cf-b960-3786e60a28ef" {
 		t.Errorf("incorrect device serial: %s", md.Devices[0].Serial)
 	}
+
+	if len(md.Devices[0].Tags) != 1 || md.Devices[0].Tags[0] != "fake_tag" {
+		t.Errorf("incorrect device tags: %v", md.Devices[0].Tags)
+	}
 }
 
 func TestCheckMetaDataOpts(t *testing.T) {
@@ -175,3 +185,45 @@ func TestGetFromMetadataService(t *testing.T) {
 		_, _ = getFromMetadataService("")
 	})
 }
+
+func TestFindDiskPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, link := range []string{
+		"pci-0000:00:07.0",
+		"virtio-pci-0000:00:08.0",
+		"pci-0000:00:05.0-scsi-0:0:0:1",
+		"pci-0000:00:09.0-part1",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, link), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testcases := []struct {
+		name     string
+		bus      string
+		address  string
+		expected []string
+	}{
+		{"virtio", "pci", "0000:00:07.0", []string{"pci-0000:00:07.0"}},
+		{"virtio with the old udev link", "pci", "0000:00:08.0", []string{"virtio-pci-0000:00:08.0"}},
+		{"scsi", "scsi", "0:0:0:1", []string{"pci-0000:00:05.0-scsi-0:0:0:1"}},
+		{"partition only", "pci", "0000:00:09.0", nil},
+		{"missing", "pci", "0000:00:0a.0", nil},
+	}
+
+	for _, testcase := range testcases {
+		diskPaths, err := findDiskPaths(dir, testcase.bus, testcase.address)
+		if err != nil {
+			t.Errorf("%s failed: unexpected error: %v", testcase.name, err)
+			continue
+		}
+		var expected []string
+		for _, link := range testcase.expected {
+			expected = append(expected, filepath.Join(dir, link))
+		}
+		if !reflect.DeepEqual(diskPaths, expected) {
+			t.Errorf("%s failed: expected %v, got %v", testcase.name, expected, diskPaths)
+		}
+	}
+}
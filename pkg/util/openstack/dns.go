@@ -0,0 +1,116 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/dns/v2/recordsets"
+	"github.com/gophercloud/gophercloud/openstack/dns/v2/zones"
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+// ToFQDN returns the name as a fully qualified domain name, i.e. with a trailing dot, as used by Designate.
+func ToFQDN(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// IsNameInZone returns true if the fully qualified name belongs to the fully qualified zone name.
+func IsNameInZone(name, zone string) bool {
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// GetZoneByName returns the Designate zone by name
+func GetZoneByName(client *gophercloud.ServiceClient, name string) (*zones.Zone, error) {
+	mc := metrics.NewMetricContext("dns_zone", "list")
+	allPages, err := zones.List(client, zones.ListOpts{Name: ToFQDN(name)}).AllPages()
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+	allZones, err := zones.ExtractZones(allPages)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(allZones) == 0 {
+		return nil, cpoerrors.ErrNotFound
+	}
+	if len(allZones) > 1 {
+		return nil, cpoerrors.ErrMultipleResults
+	}
+
+	return &allZones[0], nil
+}
+
+// GetZones returns the Designate zones matching the options
+func GetZones(client *gophercloud.ServiceClient, opts zones.ListOpts) ([]zones.Zone, error) {
+	mc := metrics.NewMetricContext("dns_zone", "list")
+	allPages, err := zones.List(client, opts).AllPages()
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+	return zones.ExtractZones(allPages)
+}
+
+// GetRecordSets returns the recordsets of the zone matching the options
+func GetRecordSets(client *gophercloud.ServiceClient, zoneID string, opts recordsets.ListOpts) ([]recordsets.RecordSet, error) {
+	mc := metrics.NewMetricContext("dns_recordset", "list")
+	allPages, err := recordsets.ListByZone(client, zoneID, opts).AllPages()
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+	return recordsets.ExtractRecordSets(allPages)
+}
+
+// CreateRecordSet creates a recordset in the zone
+func CreateRecordSet(client *gophercloud.ServiceClient, zoneID string, opts recordsets.CreateOpts) (*recordsets.RecordSet, error) {
+	mc := metrics.NewMetricContext("dns_recordset", "create")
+	rs, err := recordsets.Create(client, zoneID, opts).Extract()
+	if mc.ObserveRequest(err) != nil {
+		return nil, fmt.Errorf("failed to create %s recordset %s: %v", opts.Type, opts.Name, err)
+	}
+	return rs, nil
+}
+
+// UpdateRecordSetRecords replaces the records of a recordset
+func UpdateRecordSetRecords(client *gophercloud.ServiceClient, zoneID string, rsID string, records []string) error {
+	mc := metrics.NewMetricContext("dns_recordset", "update")
+	_, err := recordsets.Update(client, zoneID, rsID, recordsets.UpdateOpts{Records: records}).Extract()
+	if mc.ObserveRequest(err) != nil {
+		return fmt.Errorf("failed to update recordset %s: %v", rsID, err)
+	}
+	return nil
+}
+
+// DeleteRecordSet deletes a recordset, it's not an error if the recordset doesn't exist
+func DeleteRecordSet(client *gophercloud.ServiceClient, zoneID string, rsID string) error {
+	mc := metrics.NewMetricContext("dns_recordset", "delete")
+	err := recordsets.Delete(client, zoneID, rsID).ExtractErr()
+	if err != nil && cpoerrors.IsNotFound(err) {
+		err = nil
+	}
+	if mc.ObserveRequest(err) != nil {
+		return fmt.Errorf("failed to delete recordset %s: %v", rsID, err)
+	}
+	return nil
+}
@@ -45,6 +45,7 @@ const (
 	OctaviaFeatureTimeout           = 3
 	OctaviaFeatureAvailabilityZones = 4
 	OctaviaFeatureHTTPMonitorsOnUDP = 5
+	OctaviaFeatureAdditionalVIPs    = 6
 
 	waitLoadbalancerInitDelay   = 1 * time.Second
 	waitLoadbalancerFactor      = 1.2
@@ -144,6 +145,14 @@ func IsOctaviaFeatureSupported(client *gophercloud.ServiceClient, feature int, l
 		if currentVer.GreaterThanOrEqual(verHTTPMonitorsOnUDP) {
 			return true
 		}
+	case OctaviaFeatureAdditionalVIPs:
+		if lbProvider == "ovn" {
+			return false
+		}
+		verAdditionalVIPs, _ := version.NewVersion("v2.26")
+		if currentVer.GreaterThanOrEqual(verAdditionalVIPs) {
+			return true
+		}
 	default:
 		klog.Warningf("Feature %d not recognized", feature)
 	}
@@ -224,6 +233,38 @@ func GetLoadbalancerByID(client *gophercloud.ServiceClient, lbID string) (*loadb
 	return lb, nil
 }
 
+// AdditionalVIP is an additional VIP of a load balancer, gophercloud doesn't
+// support them yet.
+type AdditionalVIP struct {
+	SubnetID  string `json:"subnet_id"`
+	IPAddress string `json:"ip_address,omitempty"`
+}
+
+// LoadBalancerCreateOpts extends loadbalancers.CreateOpts with additional VIPs.
+type LoadBalancerCreateOpts struct {
+	loadbalancers.CreateOpts
+	AdditionalVIPs []AdditionalVIP `json:"additional_vips,omitempty"`
+}
+
+// ToLoadBalancerCreateMap builds a request body from LoadBalancerCreateOpts.
+func (opts LoadBalancerCreateOpts) ToLoadBalancerCreateMap() (map[string]interface{}, error) {
+	return gophercloud.BuildRequestBody(opts, "loadbalancer")
+}
+
+// GetLoadbalancerAdditionalVIPs retrieves the additional VIPs of the loadbalancer.
+func GetLoadbalancerAdditionalVIPs(client *gophercloud.ServiceClient, lbID string) ([]AdditionalVIP, error) {
+	var lb struct {
+		AdditionalVIPs []AdditionalVIP `json:"additional_vips"`
+	}
+	mc := metrics.NewMetricContext("loadbalancer", "get")
+	err := loadbalancers.Get(client, lbID).ExtractIntoStructPtr(&lb, "loadbalancer")
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	return lb.AdditionalVIPs, nil
+}
+
 // GetLoadbalancerByName retrieves loadbalancer object
 func GetLoadbalancerByName(client *gophercloud.ServiceClient, name string) (*loadbalancers.LoadBalancer, error) {
 	opts := loadbalancers.ListOpts{
@@ -303,6 +344,18 @@ func waitLoadbalancerDeleted(client *gophercloud.ServiceClient, loadbalancerID s
 	return err
 }
 
+// FailoverLoadBalancer triggers the failover of the load balancer amphorae. It doesn't wait for the load balancer
+// to become ACTIVE again as the failover may take several minutes.
+func FailoverLoadBalancer(client *gophercloud.ServiceClient, lbID string) error {
+	mc := metrics.NewMetricContext("loadbalancer", "failover")
+	err := loadbalancers.Failover(client, lbID).ExtractErr()
+	if mc.ObserveRequest(err) != nil {
+		return fmt.Errorf("error triggering failover of loadbalancer %s: %w", lbID, err)
+	}
+
+	return nil
+}
+
 // DeleteLoadbalancer deletes a loadbalancer and wait for it's gone.
 func DeleteLoadbalancer(client *gophercloud.ServiceClient, lbID string, cascade bool) error {
 	opts := loadbalancers.DeleteOpts{}
@@ -314,7 +367,7 @@ func DeleteLoadbalancer(client *gophercloud.ServiceClient, lbID string, cascade
 	err := loadbalancers.Delete(client, lbID, opts).ExtractErr()
 	if err != nil && !cpoerrors.IsNotFound(err) {
 		_ = mc.ObserveRequest(err)
-		return fmt.Errorf("error deleting loadbalancer %s: %v", lbID, err)
+		return fmt.Errorf("error deleting loadbalancer %s: %w", lbID, err)
 	}
 	_ = mc.ObserveRequest(nil)
 
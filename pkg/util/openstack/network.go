@@ -17,10 +17,14 @@ limitations under the License.
 package openstack
 
 import (
+	"fmt"
+	"strconv"
+
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/external"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/networkipavailabilities"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/networks"
 	neutronports "github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
@@ -84,6 +88,57 @@ func GetFloatingIPByPortID(client *gophercloud.ServiceClient, portID string) (*f
 	return &ips[0], nil
 }
 
+// GetAvailableIPCount returns the number of IP addresses of the network which are not in use yet. The network IP
+// availability API requires admin privileges by default.
+func GetAvailableIPCount(client *gophercloud.ServiceClient, networkID string) (float64, error) {
+	mc := metrics.NewMetricContext("network_ip_availability", "get")
+	availability, err := networkipavailabilities.Get(client, networkID).Extract()
+	if mc.ObserveRequest(err) != nil {
+		return 0, err
+	}
+
+	return availableIPCount(availability.TotalIPs, availability.UsedIPs, "network", networkID)
+}
+
+// GetSubnetAvailableIPCount returns the number of IP addresses of the subnet which are not in use yet. The network IP
+// availability API requires admin privileges by default.
+func GetSubnetAvailableIPCount(client *gophercloud.ServiceClient, subnetID string) (float64, error) {
+	mc := metrics.NewMetricContext("subnet", "get")
+	subnet, err := subnets.Get(client, subnetID).Extract()
+	if mc.ObserveRequest(err) != nil {
+		return 0, err
+	}
+
+	mc = metrics.NewMetricContext("network_ip_availability", "get")
+	availability, err := networkipavailabilities.Get(client, subnet.NetworkID).Extract()
+	if mc.ObserveRequest(err) != nil {
+		return 0, err
+	}
+
+	for _, s := range availability.SubnetIPAvailabilities {
+		if s.SubnetID == subnetID {
+			return availableIPCount(s.TotalIPs, s.UsedIPs, "subnet", subnetID)
+		}
+	}
+
+	return 0, fmt.Errorf("no IP availability found for subnet %s in network %s", subnetID, subnet.NetworkID)
+}
+
+// availableIPCount parses the IP counts of the network IP availability API. They are strings in the API as IPv6
+// subnets may exceed 64-bit integers.
+func availableIPCount(totalIPs, usedIPs, kind, id string) (float64, error) {
+	total, err := strconv.ParseFloat(totalIPs, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid total IP count %q of %s %s: %v", totalIPs, kind, id, err)
+	}
+	used, err := strconv.ParseFloat(usedIPs, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid used IP count %q of %s %s: %v", usedIPs, kind, id, err)
+	}
+
+	return total - used, nil
+}
+
 // GetFloatingNetworkID returns a floating network ID.
 func GetFloatingNetworkID(client *gophercloud.ServiceClient) (string, error) {
 	type NetworkWithExternalExt struct {
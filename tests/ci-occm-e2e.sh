@@ -34,6 +34,7 @@ CONFIG_ANSIBLE="${CONFIG_ANSIBLE:-"true"}"
 RESOURCE_TYPE="${RESOURCE_TYPE:-"gce-project"}"
 ARTIFACTS="${ARTIFACTS:-${PWD}/_artifacts}"
 OCTAVIA_PROVIDER="${OCTAVIA_PROVIDER:-""}"
+IPV6_ENABLED="${IPV6_ENABLED:-"false"}"
 mkdir -p "${ARTIFACTS}/logs"
 
 cleanup() {
@@ -110,6 +111,7 @@ ansible-playbook -v \
   --ssh-common-args "-o StrictHostKeyChecking=no" \
   tests/playbooks/test-occm-e2e.yaml \
   -e octavia_provider=${OCTAVIA_PROVIDER} \
+  -e ipv6_enabled=${IPV6_ENABLED} \
   -e run_e2e=true
 exit_code=$?
 
@@ -19,6 +19,8 @@ CLUSTER_USER=${CLUSTER_USER:-"demo"}
 LB_SUBNET_NAME=${LB_SUBNET_NAME:-"private-subnet"}
 AUTO_CLEAN_UP=${AUTO_CLEAN_UP:-"true"}
 OCTAVIA_PROVIDER=${OCTAVIA_PROVIDER:-""}
+IPV6_ENABLED=${IPV6_ENABLED:-"false"}
+IPV6_SUBNET_NAME=${IPV6_SUBNET_NAME:-"ipv6-private-subnet"}
 
 function delete_resources() {
   ERROR_CODE="$?"
@@ -101,7 +103,7 @@ function wait_for_service_address {
 
   end=$(($(date +%s) + ${TIMEOUT}))
   while true; do
-    ipaddr=$(kubectl -n $NAMESPACE describe service ${service_name} | grep 'LoadBalancer Ingress' | awk -F":" '{print $2}' | tr -d ' ')
+    ipaddr=$(kubectl -n $NAMESPACE get service ${service_name} -o jsonpath='{.status.loadBalancer.ingress[0].ip}')
     if [ "x${ipaddr}" != "x" ]; then
       printf "\n>>>>>>> Service ${service_name} is created successfully, IP: ${ipaddr}\n"
       export ipaddr=${ipaddr}
@@ -121,10 +123,13 @@ function wait_for_service_address {
 ########################################################################
 function wait_address_accessible {
   local addr=$1
+  local host=${addr}
+  # IPv6 addresses need to be enclosed in brackets in URLs
+  [[ ${addr} == *:* ]] && host="[${addr}]"
 
   end=$(($(date +%s) + ${TIMEOUT}))
   while true; do
-    curl -sS http://${ipaddr}
+    curl -sS http://${host}
     if [ $? -eq 0 ]; then
       break
     fi
@@ -801,6 +806,101 @@ EOF
     fi
 }
 
+########################################################################
+## Name: test_ipv6
+## Desc: Create an IPv6 single-stack k8s service and send request to the
+##       service IPv6 VIP. Only runs when IPV6_ENABLED is "true", i.e.
+##       the cluster has IPv6 node addresses and an IPv6 service CIDR.
+## Params: None
+########################################################################
+function test_ipv6 {
+    local service="test-ipv6"
+
+    if [[ ${IPV6_ENABLED} != "true" ]]; then
+        printf "\n>>>>>>> Skipping Service ${service} test, IPv6 is not enabled\n"
+        return 0
+    fi
+
+    printf "\n>>>>>>> Checking the nodes have an IPv6 InternalIP\n"
+    for node in $(kubectl get nodes -o jsonpath='{.items[*].metadata.name}'); do
+        node_ips=$(kubectl get node ${node} -o jsonpath='{.status.addresses[?(@.type=="InternalIP")].address}')
+        if [[ ! "${node_ips}" =~ ":" ]]; then
+            printf "\n>>>>>>> FAIL: Node ${node} has no IPv6 InternalIP, addresses: ${node_ips}\n"
+            exit 1
+        fi
+    done
+
+    local subnet_id=$(openstack subnet show ${IPV6_SUBNET_NAME} -f value -c id)
+
+    printf "\n>>>>>>> Create Service ${service}\n"
+    cat <<EOF | kubectl apply -f -
+kind: Service
+apiVersion: v1
+metadata:
+  name: ${service}
+  namespace: $NAMESPACE
+  annotations:
+    loadbalancer.openstack.org/subnet-id: "${subnet_id}"
+spec:
+  type: LoadBalancer
+  ipFamilyPolicy: SingleStack
+  ipFamilies:
+    - IPv6
+  selector:
+    run: echoserver
+  ports:
+    - protocol: TCP
+      port: 80
+      targetPort: 8080
+EOF
+
+    printf "\n>>>>>>> Waiting for the Service ${service} creation finished\n"
+    wait_for_service_address ${service}
+    if [[ ! "${ipaddr}" =~ ":" ]]; then
+        printf "\n>>>>>>> FAIL: Service ${service} got a non IPv6 address ${ipaddr}\n"
+        exit 1
+    fi
+
+    printf "\n>>>>>>> Checking no floating IP is associated with the load balancer of Service ${service}\n"
+    lbID=$(_check_service_lb_annotation "${service}")
+    vip_port=$(openstack loadbalancer show $lbID -f value -c vip_port_id)
+    fips=$(openstack floating ip list --port ${vip_port} -f value -c ID)
+    if [ -n "${fips}" ]; then
+        printf "\n>>>>>>> FAIL: Floating IP ${fips} found on the VIP port ${vip_port} of Service ${service}\n"
+        exit 1
+    fi
+
+    printf "\n>>>>>>> Checking the members and health monitor of Service ${service} are IPv6\n"
+    pool_id=$(openstack loadbalancer pool list --loadbalancer $lbID -f value -c id | head -1)
+    member_ips=$(openstack loadbalancer member list ${pool_id} -f value -c address)
+    for member_ip in ${member_ips}; do
+        if [[ ! "${member_ip}" =~ ":" ]]; then
+            printf "\n>>>>>>> FAIL: Member ${member_ip} of Service ${service} is not an IPv6 address\n"
+            exit 1
+        fi
+    done
+    monitor_id=$(openstack loadbalancer pool show ${pool_id} -f value -c healthmonitor_id)
+    if [ -z "${monitor_id}" ]; then
+        printf "\n>>>>>>> FAIL: No health monitor found for Service ${service}\n"
+        exit 1
+    fi
+
+    wait_address_accessible $ipaddr
+
+    printf "\n>>>>>>> Sending request to the Service ${service}\n"
+    podname=$(curl -sS http://[${ipaddr}] | grep Hostname | awk -F':' '{print $2}' | cut -d ' ' -f2)
+    if [[ "$podname" =~ "echoserver" ]]; then
+        printf "\n>>>>>>> Expected: Get correct response from Service ${service}\n"
+    else
+        printf "\n>>>>>>> FAIL: Get incorrect response from Service ${service}, expected: echoserver, actual: $podname\n"
+        curl -sS http://[${ipaddr}]
+        exit 1
+    fi
+
+    printf "\n>>>>>>> Delete Service ${service}\n"
+    kubectl -n $NAMESPACE delete service ${service}
+}
+
 create_namespace
 create_deployment
 set_openstack_credentials
@@ -810,3 +910,4 @@ test_forwarded
 test_update_port
 test_shared_lb
 test_shared_user_lb
+test_ipv6
@@ -7,9 +7,14 @@ import (
 	"time"
 
 	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/backups"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/schedulerhints"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/volumetransfers"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/attachments"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/snapshots"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumetypes"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 	"k8s.io/cloud-provider-openstack/pkg/csi/cinder"
 	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
@@ -34,7 +39,7 @@ func getfakecloud() *cloud {
 var _ openstack.IOpenStack = &cloud{}
 
 // Fake Cloud
-func (cloud *cloud) CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourceVolID string, sourceBackupID string, tags map[string]string) (*volumes.Volume, error) {
+func (cloud *cloud) CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourceVolID string, sourceBackupID string, tags map[string]string, schedulerHints *schedulerhints.SchedulerHints) (*volumes.Volume, error) {
 
 	vol := &volumes.Volume{
 		ID:               randString(10),
@@ -59,7 +64,23 @@ func (cloud *cloud) DeleteVolume(volumeID string) error {
 	return nil
 }
 
-func (cloud *cloud) AttachVolume(instanceID, volumeID string) (string, error) {
+func (cloud *cloud) UpdateVolumeMetadata(volumeID string, metadata map[string]string) error {
+	vol, ok := cloud.volumes[volumeID]
+	if !ok {
+		return notFoundError()
+	}
+
+	if vol.Metadata == nil {
+		vol.Metadata = make(map[string]string)
+	}
+	for k, v := range metadata {
+		vol.Metadata[k] = v
+	}
+
+	return nil
+}
+
+func (cloud *cloud) AttachVolume(instanceID, volumeID, deviceTag string) (string, error) {
 	// update the volume with attachment
 
 	vol, ok := cloud.volumes[volumeID]
@@ -114,7 +135,7 @@ func (cloud *cloud) WaitDiskDetached(instanceID string, volumeID string) error {
 	return nil
 }
 
-func (cloud *cloud) WaitVolumeTargetStatus(volumeID string, tStatus []string) error {
+func (cloud *cloud) WaitVolumeTargetStatus(volumeID string, tStatus []string, op openstack.WaitOperation) error {
 	return nil
 }
 
@@ -122,6 +143,30 @@ func (cloud *cloud) GetAttachmentDiskPath(instanceID, volumeID string) (string,
 	return cinder.FakeDevicePath, nil
 }
 
+func (cloud *cloud) CreateAttachment(instanceID, volumeID string) (string, error) {
+	return "", fmt.Errorf("attachments are not supported by the fake cloud")
+}
+
+func (cloud *cloud) GetAttachment(instanceID, volumeID string) (*attachments.Attachment, error) {
+	return nil, notFoundError()
+}
+
+func (cloud *cloud) GetAttachmentByID(attachmentID string) (*attachments.Attachment, error) {
+	return nil, notFoundError()
+}
+
+func (cloud *cloud) UpdateAttachment(attachmentID string, connector map[string]interface{}) (*attachments.Attachment, error) {
+	return nil, fmt.Errorf("attachments are not supported by the fake cloud")
+}
+
+func (cloud *cloud) CompleteAttachment(attachmentID string) error {
+	return fmt.Errorf("attachments are not supported by the fake cloud")
+}
+
+func (cloud *cloud) DeleteAttachment(attachmentID string) error {
+	return fmt.Errorf("attachments are not supported by the fake cloud")
+}
+
 func (cloud *cloud) GetVolumesByName(name string) ([]volumes.Volume, error) {
 	var vlist []volumes.Volume
 	for _, v := range cloud.volumes {
@@ -134,6 +179,24 @@ func (cloud *cloud) GetVolumesByName(name string) ([]volumes.Volume, error) {
 	return vlist, nil
 }
 
+func (cloud *cloud) GetVolumesByMetadata(metadata map[string]string) ([]volumes.Volume, error) {
+	var vlist []volumes.Volume
+	for _, v := range cloud.volumes {
+		matches := true
+		for k, val := range metadata {
+			if v.Metadata[k] != val {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			vlist = append(vlist, *v)
+		}
+	}
+
+	return vlist, nil
+}
+
 func (cloud *cloud) GetVolume(volumeID string) (*volumes.Volume, error) {
 	vol, ok := cloud.volumes[volumeID]
 
@@ -154,11 +217,17 @@ func invalidError() error {
 
 func (cloud *cloud) CreateSnapshot(name, volID string, tags map[string]string) (*snapshots.Snapshot, error) {
 
+	var size int
+	if vol, ok := cloud.volumes[volID]; ok {
+		size = vol.Size
+	}
+
 	snap := &snapshots.Snapshot{
 		ID:        randString(10),
 		Name:      name,
-		Status:    "Available",
+		Status:    "available",
 		VolumeID:  volID,
+		Size:      size,
 		CreatedAt: time.Now(),
 	}
 
@@ -324,10 +393,56 @@ func (cloud *cloud) GetInstanceByID(instanceID string) (*servers.Server, error)
 	return inst, nil
 }
 
+func (cloud *cloud) GetBareMetalNode(nodeID string) (*nodes.Node, error) {
+	return nil, gophercloud.ErrDefault404{}
+}
+
 func (cloud *cloud) ExpandVolume(volumeID string, status string, size int) error {
 	return nil
 }
 
+func (cloud *cloud) RetypeVolume(volumeID string, volumeType string) error {
+	vol, ok := cloud.volumes[volumeID]
+	if !ok {
+		return notFoundError()
+	}
+
+	vol.VolumeType = volumeType
+
+	return nil
+}
+
+func (cloud *cloud) SetVolumeBootable(volumeID string, bootable bool) error {
+	vol, ok := cloud.volumes[volumeID]
+	if !ok {
+		return notFoundError()
+	}
+
+	vol.Bootable = strconv.FormatBool(bootable)
+
+	return nil
+}
+
+func (cloud *cloud) GetVolumeType(nameOrID string) (*volumetypes.VolumeType, error) {
+	return &volumetypes.VolumeType{ID: nameOrID, Name: nameOrID}, nil
+}
+
+func (cloud *cloud) CreateTransfer(volumeID, name string) (*volumetransfers.Transfer, error) {
+	return nil, notFoundError()
+}
+
+func (cloud *cloud) ListTransfers(volumeID string) ([]volumetransfers.Transfer, error) {
+	return nil, nil
+}
+
+func (cloud *cloud) DeleteTransfer(transferID string) error {
+	return notFoundError()
+}
+
+func (cloud *cloud) AcceptTransfer(transferID, authKey string) error {
+	return notFoundError()
+}
+
 func (cloud *cloud) GetMaxVolLimit() int64 {
 	return 256
 }
@@ -14,3 +14,7 @@ func (m *fakemetadata) GetInstanceID() (string, error) {
 func (m *fakemetadata) GetAvailabilityZone() (string, error) {
 	return cinder.FakeAvailability, nil
 }
+
+func (m *fakemetadata) GetInstanceMetadata() (map[string]string, error) {
+	return map[string]string{}, nil
+}
@@ -27,7 +27,9 @@ func TestDriver(t *testing.T) {
 	fakemnt := GetFakeMountProvider()
 	fakemet := &fakemetadata{}
 
-	d.SetupControllerService(fakecloudprovider)
+	if err := d.SetupControllerService(fakecloudprovider); err != nil {
+		t.Fatalf("Failed to set up the controller service: %v", err)
+	}
 	d.SetupNodeService(fakecloudprovider, fakemnt, fakemet)
 
 	// TODO: Stop call
@@ -21,7 +21,12 @@ import (
 	"strconv"
 
 	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/keymanager/v1/containers"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/messages"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/replicas"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/schedulerstats"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/securityservices"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/services"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/sharetypes"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/snapshots"
@@ -91,6 +96,15 @@ func (c fakeManilaClient) GetShareByName(shareName string) (*shares.Share, error
 	return c.GetShareByID(shareID)
 }
 
+func (c fakeManilaClient) ListShares(opts shares.ListOptsBuilder) ([]shares.Share, error) {
+	var res []shares.Share
+	for _, share := range fakeShares {
+		res = append(res, *share)
+	}
+
+	return res, nil
+}
+
 func (c fakeManilaClient) CreateShare(opts shares.CreateOptsBuilder) (*shares.Share, error) {
 	var res shares.CreateResult
 	res.Body = opts
@@ -152,6 +166,10 @@ func (c fakeManilaClient) GetExportLocations(shareID string) ([]shares.ExportLoc
 	return []shares.ExportLocation{{Path: "fake-server:/fake-path"}}, nil
 }
 
+func (c fakeManilaClient) RenameShare(shareID, name string) error {
+	return nil
+}
+
 func (c fakeManilaClient) SetShareMetadata(shareID string, opts shares.SetMetadataOptsBuilder) (map[string]string, error) {
 	return nil, nil
 }
@@ -214,6 +232,37 @@ func (c fakeManilaClient) GrantAccess(shareID string, opts shares.GrantAccessOpt
 	return accessRight, nil
 }
 
+func (c fakeManilaClient) RevokeAccess(shareID, accessID string) error {
+	id := strToInt(accessID)
+	if r, ok := fakeAccessRights[id]; !ok || r.ShareID != shareID {
+		return gophercloud.ErrResourceNotFound{}
+	}
+
+	delete(fakeAccessRights, id)
+
+	return nil
+}
+
+func (c fakeManilaClient) GetShareReplicas(shareID string) ([]replicas.Replica, error) {
+	if !shareExists(shareID) {
+		return nil, gophercloud.ErrResourceNotFound{}
+	}
+
+	return nil, nil
+}
+
+func (c fakeManilaClient) CreateShareReplica(opts replicas.CreateOptsBuilder) (*replicas.Replica, error) {
+	return nil, fmt.Errorf("share replicas are not supported")
+}
+
+func (c fakeManilaClient) DeleteShareReplica(replicaID string) error {
+	return gophercloud.ErrResourceNotFound{}
+}
+
+func (c fakeManilaClient) PromoteShareReplica(replicaID string) error {
+	return gophercloud.ErrResourceNotFound{}
+}
+
 func (c fakeManilaClient) GetSnapshotByID(snapID string) (*snapshots.Snapshot, error) {
 	s, ok := fakeSnapshots[strToInt(snapID)]
 	if !ok {
@@ -239,6 +288,15 @@ func (c fakeManilaClient) GetSnapshotByName(snapName string) (*snapshots.Snapsho
 	return c.GetSnapshotByID(snapID)
 }
 
+func (c fakeManilaClient) ListSnapshots(opts snapshots.ListOptsBuilder) ([]snapshots.Snapshot, error) {
+	var res []snapshots.Snapshot
+	for _, snap := range fakeSnapshots {
+		res = append(res, *snap)
+	}
+
+	return res, nil
+}
+
 func (c fakeManilaClient) CreateSnapshot(opts snapshots.CreateOptsBuilder) (*snapshots.Snapshot, error) {
 	var res snapshots.CreateResult
 	res.Body = opts
@@ -271,6 +329,74 @@ func (c fakeManilaClient) DeleteSnapshot(snapID string) error {
 	return nil
 }
 
+func (c fakeManilaClient) GetSecurityService(securityServiceID string) (*securityservices.SecurityService, error) {
+	return nil, gophercloud.ErrResourceNotFound{}
+}
+
+func (c fakeManilaClient) GetShareNetwork(shareNetworkID string) (*manilaclient.ShareNetwork, error) {
+	return nil, gophercloud.ErrResourceNotFound{}
+}
+
+func (c fakeManilaClient) GetShareNetworkIDFromName(shareNetworkName string) (string, error) {
+	return "", gophercloud.ErrResourceNotFound{}
+}
+
+func (c fakeManilaClient) GetShareNetworkSecurityServices(shareNetworkID string) ([]securityservices.SecurityService, error) {
+	return nil, nil
+}
+
+func (c fakeManilaClient) AddShareNetworkSecurityService(shareNetworkID, securityServiceID string) error {
+	return gophercloud.ErrResourceNotFound{}
+}
+
+func (c fakeManilaClient) GetPools(shareType string) ([]schedulerstats.Pool, error) {
+	return nil, nil
+}
+
+func (c fakeManilaClient) GetShareServices() ([]services.Service, error) {
+	return nil, nil
+}
+
 func (c fakeManilaClient) GetUserMessages(opts messages.ListOptsBuilder) ([]messages.Message, error) {
 	return nil, nil
 }
+
+func (c fakeManilaClient) GetShareGroupID(shareID string) (string, error) {
+	return "", nil
+}
+
+func (c fakeManilaClient) GetShareGroupShares(shareGroupID string) ([]shares.Share, error) {
+	return nil, nil
+}
+
+func (c fakeManilaClient) CreateShareGroupSnapshot(opts manilaclient.ShareGroupSnapshotCreateOpts) (*manilaclient.ShareGroupSnapshot, error) {
+	return nil, gophercloud.ErrResourceNotFound{}
+}
+
+func (c fakeManilaClient) GetShareGroupSnapshot(snapshotID string) (*manilaclient.ShareGroupSnapshot, error) {
+	return nil, gophercloud.ErrResourceNotFound{}
+}
+
+func (c fakeManilaClient) GetShareGroupSnapshotByName(name string) (*manilaclient.ShareGroupSnapshot, error) {
+	return nil, gophercloud.ErrResourceNotFound{}
+}
+
+func (c fakeManilaClient) DeleteShareGroupSnapshot(snapshotID string) error {
+	return gophercloud.ErrResourceNotFound{}
+}
+
+func (c fakeManilaClient) CreateShareGroup(opts manilaclient.ShareGroupCreateOpts) (*manilaclient.ShareGroup, error) {
+	return nil, gophercloud.ErrResourceNotFound{}
+}
+
+func (c fakeManilaClient) GetShareGroupByName(name string) (*manilaclient.ShareGroup, error) {
+	return nil, gophercloud.ErrResourceNotFound{}
+}
+
+func (c fakeManilaClient) GetShareGroupMemberShareID(shareGroupID, memberID string) (string, error) {
+	return "", gophercloud.ErrResourceNotFound{}
+}
+
+func (c fakeManilaClient) GetSecretContainer(containerID string) (*containers.Container, error) {
+	return nil, gophercloud.ErrResourceNotFound{}
+}
@@ -39,3 +39,14 @@ set -o errexit
 # go mod k8s.io/cloud-provider-openstack/pkg/util/mount
 /bin/udevadm --version
 /bin/findmnt -V
+
+# This utils are using by
+# go mod k8s.io/cloud-provider-openstack/pkg/csi/cinder to wipe volumes
+/sbin/blkdiscard -V
+/sbin/cryptsetup --version
+
+# This utils are using by
+# go mod k8s.io/cloud-provider-openstack/pkg/csi/cinder to set up read caches
+/sbin/dmsetup --version
+/sbin/lvcreate --version
+/sbin/lvremove --version
@@ -96,3 +96,14 @@ cp /bin/btrfs* ${DEST}/bin/
 copy_deps /bin/udevadm
 copy_deps /lib/udev/rules.d
 copy_deps /bin/findmnt
+
+# This utils are using by
+# go mod k8s.io/cloud-provider-openstack/pkg/csi/cinder to wipe volumes
+copy_deps /sbin/blkdiscard
+copy_deps /sbin/cryptsetup
+
+# This utils are using by
+# go mod k8s.io/cloud-provider-openstack/pkg/csi/cinder to set up read caches
+copy_deps /sbin/dmsetup
+copy_deps /sbin/lvcreate
+copy_deps /sbin/lvremove